@@ -35,6 +35,7 @@ import (
 	"github.com/chainguard-dev/clog"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/dlorenc/melange2/pkg/config"
 	"github.com/dlorenc/melange2/pkg/service/api"
 	"github.com/dlorenc/melange2/pkg/service/buildkit"
 	"github.com/dlorenc/melange2/pkg/service/metrics"
@@ -51,9 +52,21 @@ var (
 	defaultArch     = flag.String("default-arch", "x86_64", "Default architecture for single-backend mode")
 	outputDir       = flag.String("output-dir", "/var/lib/melange/output", "Directory for build outputs (local storage)")
 	gcsBucket       = flag.String("gcs-bucket", "", "GCS bucket for build outputs (if set, uses GCS instead of local storage)")
+	s3Bucket        = flag.String("s3-bucket", "", "S3 bucket for build outputs (if set, uses S3 instead of local storage)")
+	s3Endpoint      = flag.String("s3-endpoint", "", "S3 endpoint URL for S3-compatible stores such as MinIO (e.g., http://minio:9000)")
 	enableTracing   = flag.Bool("enable-tracing", false, "Enable OpenTelemetry tracing")
-	maxParallel     = flag.Int("max-parallel", 0, "Maximum number of concurrent package builds (0 = use pool capacity)")
+	maxParallel     = flag.Int("max-parallel", 0, "Maximum number of concurrent package builds (0 = use pool capacity, as backends are added and removed)")
+	configCacheSize = flag.Int("config-cache-size", 256, "Number of parsed package configurations to keep for retries (0 = disabled)")
 	apkoServiceAddr = flag.String("apko-service-addr", "", "gRPC address of apko service for remote layer generation (e.g., apko-server:9090)")
+	// Autoscaling flags
+	autoscalerURL     = flag.String("autoscaler-url", "", "URL of a webhook deciding which backends to add and drain (if unset, the pool is not autoscaled)")
+	autoscaleInterval = flag.Duration("autoscale-interval", scheduler.DefaultAutoscaleInterval, "How often the autoscaler webhook is called")
+	// HTTP server flags
+	httpReadTimeout  = flag.Duration("http-read-timeout", api.DefaultHTTPConfig().ReadTimeout, "Maximum duration for reading an entire request")
+	httpWriteTimeout = flag.Duration("http-write-timeout", api.DefaultHTTPConfig().WriteTimeout, "Maximum duration for writing a response")
+	httpIdleTimeout  = flag.Duration("http-idle-timeout", api.DefaultHTTPConfig().IdleTimeout, "How long to keep idle keep-alive connections open")
+	http2MaxStreams  = flag.Int("http2-max-concurrent-streams", 0, "Maximum concurrent streams per HTTP/2 connection (0 = net/http default)")
+	enableH2C        = flag.Bool("h2c", false, "Serve HTTP/2 over cleartext (h2c) alongside HTTP/1.1")
 	// Observability flags
 	otlpEndpoint    = flag.String("otlp-endpoint", "", "OTLP collector endpoint for traces (e.g., tempo:4317)")
 	otlpInsecure    = flag.Bool("otlp-insecure", true, "Use insecure OTLP connection (no TLS)")
@@ -62,6 +75,8 @@ var (
 	// PostgreSQL flags
 	postgresDSN     = flag.String("postgres-dsn", "", "PostgreSQL connection string (if set, uses PostgreSQL instead of in-memory store)")
 	postgresMaxConn = flag.Int("postgres-max-conn", 25, "Maximum PostgreSQL connections")
+	// Tenant flags
+	tenantTokens = flag.String("tenant-tokens", "", "Path to a YAML file of the bearer tokens of each tenant (if unset, every build is in the default tenant)")
 )
 
 func main() {
@@ -146,21 +161,38 @@ func run(ctx context.Context) error {
 
 	// Initialize storage backend
 	var storageBackend storage.Storage
-	if *gcsBucket != "" {
-		// Get GCS configuration from environment
-		maxConcurrentUploads := 200 // Default for scale
-		if v := os.Getenv("MAX_CONCURRENT_UPLOADS"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil && n > 0 {
-				maxConcurrentUploads = n
-			}
+	if *gcsBucket != "" && *s3Bucket != "" {
+		return fmt.Errorf("--gcs-bucket and --s3-bucket are mutually exclusive")
+	}
+	if *s3Endpoint != "" && *s3Bucket == "" {
+		return fmt.Errorf("--s3-endpoint requires --s3-bucket")
+	}
+	// Get object storage configuration from environment
+	maxConcurrentUploads := 200 // Default for scale
+	if v := os.Getenv("MAX_CONCURRENT_UPLOADS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxConcurrentUploads = n
 		}
+	}
+	switch {
+	case *gcsBucket != "":
 		log.Infof("using GCS storage: gs://%s (max concurrent uploads: %d)", *gcsBucket, maxConcurrentUploads)
 		storageBackend, err = storage.NewGCSStorage(ctx, *gcsBucket,
 			storage.WithMaxConcurrentUploads(maxConcurrentUploads))
 		if err != nil {
 			return fmt.Errorf("creating GCS storage: %w", err)
 		}
-	} else {
+	case *s3Bucket != "":
+		s3Opts := []storage.S3Option{storage.WithS3MaxConcurrentUploads(maxConcurrentUploads)}
+		if *s3Endpoint != "" {
+			s3Opts = append(s3Opts, storage.WithS3Endpoint(*s3Endpoint))
+		}
+		log.Infof("using S3 storage: s3://%s (max concurrent uploads: %d)", *s3Bucket, maxConcurrentUploads)
+		storageBackend, err = storage.NewS3Storage(ctx, *s3Bucket, s3Opts...)
+		if err != nil {
+			return fmt.Errorf("creating S3 storage: %w", err)
+		}
+	default:
 		log.Infof("using local storage: %s", *outputDir)
 		storageBackend, err = storage.NewLocalStorage(*outputDir)
 		if err != nil {
@@ -195,8 +227,23 @@ func run(ctx context.Context) error {
 		}
 	}
 
+	if melangeMetrics != nil {
+		if err := melangeMetrics.Register(pool.Collectors()...); err != nil {
+			return fmt.Errorf("registering buildkit pool metrics: %w", err)
+		}
+	}
+
 	// Create API server
-	apiServer := api.NewServer(buildStore, pool)
+	var serverOpts []api.ServerOption
+	if *tenantTokens != "" {
+		authn, err := api.LoadTokenAuthenticator(*tenantTokens)
+		if err != nil {
+			return fmt.Errorf("loading tenant tokens: %w", err)
+		}
+		serverOpts = append(serverOpts, api.WithAuthenticator(authn))
+		log.Infof("authenticating tenants with tokens from %s", *tenantTokens)
+	}
+	apiServer := api.NewServer(buildStore, pool, serverOpts...)
 
 	// Create a mux that routes /debug/pprof/ to pprof handlers and everything else to API
 	mux := http.NewServeMux()
@@ -215,14 +262,13 @@ func run(ctx context.Context) error {
 		http.DefaultServeMux.ServeHTTP(w, r)
 	})
 
-	httpServer := &http.Server{
-		Addr:              *listenAddr,
-		Handler:           mux,
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       60 * time.Second,
-		WriteTimeout:      60 * time.Second,
-		MaxHeaderBytes:    1 << 20, // 1MB
-	}
+	httpConfig := api.DefaultHTTPConfig()
+	httpConfig.ReadTimeout = *httpReadTimeout
+	httpConfig.WriteTimeout = *httpWriteTimeout
+	httpConfig.IdleTimeout = *httpIdleTimeout
+	httpConfig.MaxConcurrentStreams = *http2MaxStreams
+	httpConfig.H2C = *enableH2C
+	httpServer := api.NewHTTPServer(*listenAddr, mux, httpConfig)
 
 	// Get cache configuration from environment
 	cacheRegistry := os.Getenv("CACHE_REGISTRY")
@@ -253,6 +299,36 @@ func run(ctx context.Context) error {
 	}
 	log.Infof("scheduler poll interval: %s", pollInterval)
 
+	// Get BuildKit dial timeout from environment (default 10s)
+	var buildkitDialTimeout time.Duration
+	if v := os.Getenv("BUILDKIT_DIAL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid BUILDKIT_DIAL_TIMEOUT %q: must be a positive duration", v)
+		}
+		buildkitDialTimeout = d
+	}
+
+	// Get build log rotation from environment
+	// BUILD_LOG_MAX_BYTES: Size at which a package's build log is rotated (default 100MiB, negative disables)
+	// BUILD_LOG_MAX_FILES: How many rotated build logs to keep per package (default 3)
+	var buildLogMaxBytes int64
+	if v := os.Getenv("BUILD_LOG_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid BUILD_LOG_MAX_BYTES %q: %w", v, err)
+		}
+		buildLogMaxBytes = n
+	}
+	var buildLogMaxFiles int
+	if v := os.Getenv("BUILD_LOG_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid BUILD_LOG_MAX_FILES %q: %w", v, err)
+		}
+		buildLogMaxFiles = n
+	}
+
 	// Get APK cache configuration from environment
 	// APK_CACHE_DIR: Directory for persistent APK package cache
 	// APK_CACHE_TTL: How long to keep cached APK files (default 1h)
@@ -302,6 +378,17 @@ func run(ctx context.Context) error {
 	if melangeMetrics != nil {
 		schedOpts = append(schedOpts, scheduler.WithMetrics(melangeMetrics))
 	}
+	if *configCacheSize > 0 {
+		schedOpts = append(schedOpts, scheduler.WithParseCache(config.NewParseCache(*configCacheSize)))
+	}
+	if *autoscalerURL != "" {
+		log.Infof("autoscaling the pool with webhook %s every %s", *autoscalerURL, *autoscaleInterval)
+		autoscaler := &scheduler.WebhookAutoscaler{
+			URL:    *autoscalerURL,
+			Client: &http.Client{Timeout: *autoscaleInterval},
+		}
+		schedOpts = append(schedOpts, scheduler.WithAutoscaler(autoscaler, *autoscaleInterval))
+	}
 	sched := scheduler.New(buildStore, storageBackend, pool, scheduler.Config{
 		OutputDir:            *outputDir,
 		PollInterval:         pollInterval,
@@ -314,10 +401,13 @@ func run(ctx context.Context) error {
 		ApkCacheTTL:          apkCacheTTL,
 		ApkoServiceAddr:      apkoService,
 		SecretEnv:            secretEnv,
+		BuildKitDialTimeout:  buildkitDialTimeout,
+		BuildLogMaxBytes:     buildLogMaxBytes,
+		BuildLogMaxFiles:     buildLogMaxFiles,
 	}, schedOpts...)
 
 	// Create output directory (for local storage)
-	if *gcsBucket == "" {
+	if *gcsBucket == "" && *s3Bucket == "" {
 		if err := os.MkdirAll(*outputDir, 0755); err != nil {
 			return fmt.Errorf("creating output directory: %w", err)
 		}
@@ -350,6 +440,11 @@ func run(ctx context.Context) error {
 		return sched.RunCacheCleanup(ctx)
 	})
 
+	// Run the pool autoscaler (if configured)
+	eg.Go(func() error {
+		return sched.RunAutoscaler(ctx)
+	})
+
 	// Handle shutdown
 	eg.Go(func() error {
 		<-ctx.Done()
@@ -0,0 +1,95 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/e2e/harness"
+	"github.com/dlorenc/melange2/pkg/build"
+)
+
+// TestBuild_ReadOnlyCacheDir tests that writes made by a build to
+// /var/cache/melange never propagate to a read-only host cache directory.
+func TestBuild_ReadOnlyCacheDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	h := harness.New(t)
+	defer h.Close()
+
+	cacheDir := filepath.Join(h.TempDir(), "shared-cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "seed"), []byte("seeded\n"), 0644))
+
+	cfg := build.NewBuildConfig()
+	cfg.ConfigFile = filepath.Join("fixtures", "build", "cache-readonly.yaml")
+	cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+	cfg.ConfigFileRepositoryCommit = "e2e"
+	cfg.OutDir = filepath.Join(h.TempDir(), "packages")
+	cfg.CacheDir = cacheDir
+	cfg.CacheDirReadOnly = true
+	cfg.Arch = apko_types.ParseArchitecture("amd64")
+	cfg.BuildKitAddr = h.BuildKitAddr()
+
+	b, err := build.NewFromConfig(h.Context(), cfg)
+	require.NoError(t, err)
+	defer b.Close(h.Context())
+
+	require.NoError(t, b.BuildPackage(h.Context()))
+
+	seed, err := os.ReadFile(filepath.Join(cacheDir, "seed"))
+	require.NoError(t, err)
+	require.Equal(t, "seeded\n", string(seed), "host cache file should be unchanged")
+	require.NoFileExists(t, filepath.Join(cacheDir, "written"), "build writes should not reach the host cache")
+}
+
+// TestBuild_ReadOnlyCacheDirMissing tests that a missing read-only cache
+// directory fails the build instead of being created.
+func TestBuild_ReadOnlyCacheDirMissing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	h := harness.New(t)
+	defer h.Close()
+
+	cacheDir := filepath.Join(h.TempDir(), "missing-cache")
+
+	cfg := build.NewBuildConfig()
+	cfg.ConfigFile = filepath.Join("fixtures", "build", "cache-readonly.yaml")
+	cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+	cfg.ConfigFileRepositoryCommit = "e2e"
+	cfg.OutDir = filepath.Join(h.TempDir(), "packages")
+	cfg.CacheDir = cacheDir
+	cfg.CacheDirReadOnly = true
+	cfg.Arch = apko_types.ParseArchitecture("amd64")
+	cfg.BuildKitAddr = h.BuildKitAddr()
+
+	b, err := build.NewFromConfig(h.Context(), cfg)
+	require.NoError(t, err)
+	defer b.Close(h.Context())
+
+	err = b.BuildPackage(h.Context())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "read-only cache dir")
+	require.NoDirExists(t, cacheDir)
+}
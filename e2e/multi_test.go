@@ -0,0 +1,71 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/e2e/harness"
+	"github.com/dlorenc/melange2/pkg/build"
+)
+
+// TestBuild_MultipleConfigs tests that a directory of interdependent configs
+// is built in dependency order, with the dependent build installing the
+// package produced by the first.
+func TestBuild_MultipleConfigs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	h := harness.New(t)
+	defer h.Close()
+
+	outDir := filepath.Join(h.TempDir(), "packages")
+	arch := apko_types.ParseArchitecture("amd64")
+
+	paths, err := build.ExpandConfigPaths([]string{filepath.Join("fixtures", "build", "multi-config")})
+	require.NoError(t, err)
+
+	results, err := build.BuildConfigs(h.Context(), paths, []apko_types.Architecture{arch},
+		func(_ context.Context, configFile string) (*build.BuildConfig, error) {
+			cfg := build.NewBuildConfig()
+			cfg.ConfigFile = configFile
+			cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+			cfg.ConfigFileRepositoryCommit = "e2e"
+			cfg.OutDir = outDir
+			cfg.CacheDir = filepath.Join(h.TempDir(), "cache")
+			cfg.BuildKitAddr = h.BuildKitAddr()
+			cfg.GenerateIndex = true
+			// The packages built here are not signed.
+			cfg.IgnoreSignatures = true
+			return cfg, nil
+		})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	require.Equal(t, "multi-config-base", results[0].Package)
+	require.Equal(t, "multi-config-app", results[1].Package)
+	for _, r := range results {
+		require.NoError(t, r.Err, r.ConfigFile)
+	}
+
+	require.FileExists(t, filepath.Join(outDir, arch.ToAPK(), "multi-config-base-1.0.0-r0.apk"))
+	require.FileExists(t, filepath.Join(outDir, arch.ToAPK(), "multi-config-app-1.0.0-r0.apk"))
+}
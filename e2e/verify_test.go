@@ -0,0 +1,77 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/e2e/harness"
+	"github.com/dlorenc/melange2/pkg/build"
+)
+
+// buildWithVerifyInstall runs a full build of the given fixture with
+// install verification enabled.
+func buildWithVerifyInstall(t *testing.T, h *harness.Harness, name string) error {
+	t.Helper()
+
+	cfg := build.NewBuildConfig()
+	cfg.ConfigFile = filepath.Join("fixtures", "build", name)
+	cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+	cfg.ConfigFileRepositoryCommit = "e2e"
+	cfg.OutDir = filepath.Join(h.TempDir(), "packages")
+	cfg.CacheDir = filepath.Join(h.TempDir(), "cache")
+	cfg.Arch = apko_types.ParseArchitecture("amd64")
+	cfg.BuildKitAddr = h.BuildKitAddr()
+	cfg.GenerateIndex = true
+	cfg.VerifyInstall = true
+
+	b, err := build.NewFromConfig(h.Context(), cfg)
+	require.NoError(t, err)
+	defer b.Close(h.Context())
+
+	return b.BuildPackage(h.Context())
+}
+
+// TestVerifyInstall_Satisfiable tests that a package whose dependencies
+// resolve installs cleanly against the generated index.
+func TestVerifyInstall_Satisfiable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	h := harness.New(t)
+	defer h.Close()
+
+	require.NoError(t, buildWithVerifyInstall(t, h, "verify-satisfiable.yaml"))
+}
+
+// TestVerifyInstall_UnmetDependency tests that a package with a dependency
+// no repository provides fails the build.
+func TestVerifyInstall_UnmetDependency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	h := harness.New(t)
+	defer h.Close()
+
+	err := buildWithVerifyInstall(t, h, "verify-unsatisfiable.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying install of verify-unsatisfiable")
+}
@@ -12,5 +12,6 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate go run . -o ../../pkg/config/schema.json
+// Command gen-jsonschema writes the JSON schema for melange configuration
+// files. It is run by go generate in pkg/config.
 package main
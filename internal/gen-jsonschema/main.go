@@ -1,14 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"flag"
 	"log"
 	"os"
 
-	"github.com/invopop/jsonschema"
-
 	"github.com/dlorenc/melange2/pkg/config"
 )
 
@@ -21,19 +17,14 @@ func main() {
 		log.Fatal("output path is required")
 	}
 
-	r := new(jsonschema.Reflector)
-	if err := r.AddGoComments("github.com/dlorenc/melange2/pkg/build", "../../pkg/config"); err != nil {
-		log.Fatal(err)
-	}
-	schema := r.Reflect(config.Configuration{})
-	b := new(bytes.Buffer)
-	enc := json.NewEncoder(b)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(schema); err != nil {
+	// go generate runs in the directory of the go:generate directive, which
+	// is the config package's source directory.
+	b, err := config.GenerateSchema(".")
+	if err != nil {
 		log.Fatal(err)
 	}
 	// #nosec G306 - Generated schema file should be world-readable
-	if err := os.WriteFile(*outputFlag, b.Bytes(), 0o644); err != nil {
+	if err := os.WriteFile(*outputFlag, b, 0o644); err != nil {
 		log.Fatal(err)
 	}
 }
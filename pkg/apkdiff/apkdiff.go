@@ -0,0 +1,454 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apkdiff compares the contents of APK packages, such as the outputs
+// of two builds of the same configuration.
+package apkdiff
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/expandapk"
+	"github.com/dustin/go-humanize"
+	"gopkg.in/ini.v1"
+)
+
+// ChangeType is how a file or package differs between two builds.
+type ChangeType string
+
+const (
+	// Added means the file or package is only in the new build.
+	Added ChangeType = "added"
+	// Removed means the file or package is only in the old build.
+	Removed ChangeType = "removed"
+	// Modified means the file is in both builds but differs.
+	Modified ChangeType = "modified"
+)
+
+// File describes an entry in the data section of a package.
+type File struct {
+	// Type is "file", "dir", "symlink", "hardlink" or "other".
+	Type string `json:"type"`
+	// Mode holds the permission bits, including setuid, setgid and sticky.
+	Mode fs.FileMode `json:"mode"`
+	UID  int         `json:"uid"`
+	GID  int         `json:"gid"`
+	Size int64       `json:"size"`
+	// Link is the target of a symlink or hardlink.
+	Link string `json:"link,omitempty"`
+	// Digest is the SHA-256 of the contents of a regular file.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Package is a package read from an APK file.
+type Package struct {
+	Path    string
+	Name    string
+	Version string
+	Arch    string
+	// Files maps the path of each entry, without a leading slash, to it.
+	Files map[string]File
+}
+
+// FileChange describes how one file differs between two packages.
+type FileChange struct {
+	Path   string     `json:"path"`
+	Change ChangeType `json:"change"`
+	Old    *File      `json:"old,omitempty"`
+	New    *File      `json:"new,omitempty"`
+	// Differences names what changed in a modified file: "type",
+	// "content", "mode", "owner" and "link".
+	Differences []string `json:"differences,omitempty"`
+	// SizeDelta is the change in size, in bytes.
+	SizeDelta int64 `json:"size_delta"`
+}
+
+// PackageDiff describes how a package differs between two builds. A package
+// that is only in one build has no file changes.
+type PackageDiff struct {
+	Name       string       `json:"name"`
+	Arch       string       `json:"arch,omitempty"`
+	Change     ChangeType   `json:"change"`
+	OldPath    string       `json:"old_path,omitempty"`
+	NewPath    string       `json:"new_path,omitempty"`
+	OldVersion string       `json:"old_version,omitempty"`
+	NewVersion string       `json:"new_version,omitempty"`
+	Files      []FileChange `json:"files,omitempty"`
+	// SizeDelta is the change in the total size of the package's files.
+	SizeDelta int64 `json:"size_delta"`
+}
+
+// Report is the result of comparing two builds.
+type Report struct {
+	Packages []PackageDiff `json:"packages"`
+}
+
+// HasChanges reports whether the two builds differ in any way.
+func (r *Report) HasChanges() bool {
+	for _, p := range r.Packages {
+		if p.Change != Modified || len(p.Files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff compares the packages at oldPath and newPath. Both must be APK files,
+// or both directories of APK files such as melange output directories, in
+// which case the packages are paired up by name and architecture.
+func Diff(ctx context.Context, oldPath, newPath string) (*Report, error) {
+	oldInfo, err := os.Stat(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := os.Stat(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldInfo.IsDir() != newInfo.IsDir() {
+		return nil, fmt.Errorf("cannot compare %s with %s: both must be APK files or both directories", oldPath, newPath)
+	}
+
+	if !oldInfo.IsDir() {
+		oldPkg, err := ReadAPK(ctx, oldPath)
+		if err != nil {
+			return nil, err
+		}
+		newPkg, err := ReadAPK(ctx, newPath)
+		if err != nil {
+			return nil, err
+		}
+		return &Report{Packages: []PackageDiff{DiffPackages(oldPkg, newPkg)}}, nil
+	}
+
+	oldPkgs, err := readDir(ctx, oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newPkgs, err := readDir(ctx, newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(oldPkgs)+len(newPkgs))
+	for k := range oldPkgs {
+		keys = append(keys, k)
+	}
+	for k := range newPkgs {
+		if _, ok := oldPkgs[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	r := &Report{Packages: make([]PackageDiff, 0, len(keys))}
+	for _, k := range keys {
+		oldPkg, newPkg := oldPkgs[k], newPkgs[k]
+		switch {
+		case newPkg == nil:
+			r.Packages = append(r.Packages, PackageDiff{
+				Name: oldPkg.Name, Arch: oldPkg.Arch, Change: Removed,
+				OldPath: oldPkg.Path, OldVersion: oldPkg.Version,
+				SizeDelta: -oldPkg.size(),
+			})
+		case oldPkg == nil:
+			r.Packages = append(r.Packages, PackageDiff{
+				Name: newPkg.Name, Arch: newPkg.Arch, Change: Added,
+				NewPath: newPkg.Path, NewVersion: newPkg.Version,
+				SizeDelta: newPkg.size(),
+			})
+		default:
+			r.Packages = append(r.Packages, DiffPackages(oldPkg, newPkg))
+		}
+	}
+	return r, nil
+}
+
+// readDir reads every APK file under dir, keyed by architecture and name.
+func readDir(ctx context.Context, dir string) (map[string]*Package, error) {
+	pkgs := map[string]*Package{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".apk" {
+			return nil
+		}
+		pkg, err := ReadAPK(ctx, path)
+		if err != nil {
+			return err
+		}
+		key := pkg.Arch + "/" + pkg.Name
+		if extant, ok := pkgs[key]; ok {
+			return fmt.Errorf("%s contains more than one %s package for %s: %s and %s", dir, pkg.Name, pkg.Arch, extant.Path, pkg.Path)
+		}
+		pkgs[key] = pkg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// ReadAPK reads the metadata and file listing of the APK file at path.
+func ReadAPK(ctx context.Context, path string) (*Package, error) {
+	f, err := os.Open(path) // #nosec G304 - User-specified APK package to compare
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	exp, err := expandapk.ExpandApk(ctx, f, "")
+	if err != nil {
+		return nil, fmt.Errorf("expanding apk %q: %w", path, err)
+	}
+	defer exp.Close()
+
+	pkginfo, err := readPkgInfo(exp.ControlFS)
+	if err != nil {
+		return nil, fmt.Errorf("reading .PKGINFO of %q: %w", path, err)
+	}
+	section := pkginfo.Section("")
+
+	pkg := &Package{
+		Path:    path,
+		Name:    section.Key("pkgname").String(),
+		Version: section.Key("pkgver").String(),
+		Arch:    section.Key("arch").String(),
+		Files:   map[string]File{},
+	}
+	if pkg.Name == "" {
+		return nil, fmt.Errorf("%q has no pkgname in .PKGINFO", path)
+	}
+
+	data, err := exp.PackageData()
+	if err != nil {
+		return nil, fmt.Errorf("reading package data of %q: %w", path, err)
+	}
+	defer data.Close()
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading package data of %q: %w", path, err)
+		}
+
+		file := File{
+			Type: fileType(hdr.Typeflag),
+			Mode: hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
+			UID:  hdr.Uid,
+			GID:  hdr.Gid,
+			Link: hdr.Linkname,
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s in %q: %w", hdr.Name, path, err)
+			}
+			file.Size = n
+			file.Digest = hex.EncodeToString(h.Sum(nil))
+		}
+		pkg.Files[strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "/"), "/")] = file
+	}
+
+	return pkg, nil
+}
+
+// readPkgInfo parses .PKGINFO from the control section of an APK.
+func readPkgInfo(control fs.FS) (*ini.File, error) {
+	b, err := fs.ReadFile(control, ".PKGINFO")
+	if err != nil {
+		return nil, err
+	}
+	return ini.Load(b)
+}
+
+func fileType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	default:
+		return "other"
+	}
+}
+
+func (p *Package) size() int64 {
+	var n int64
+	for _, f := range p.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// DiffPackages compares the files of two packages.
+func DiffPackages(oldPkg, newPkg *Package) PackageDiff {
+	d := PackageDiff{
+		Name:       newPkg.Name,
+		Arch:       newPkg.Arch,
+		Change:     Modified,
+		OldPath:    oldPkg.Path,
+		NewPath:    newPkg.Path,
+		OldVersion: oldPkg.Version,
+		NewVersion: newPkg.Version,
+		SizeDelta:  newPkg.size() - oldPkg.size(),
+	}
+
+	for path, oldFile := range oldPkg.Files {
+		newFile, ok := newPkg.Files[path]
+		if !ok {
+			d.Files = append(d.Files, FileChange{Path: path, Change: Removed, Old: &oldFile, SizeDelta: -oldFile.Size})
+			continue
+		}
+		if differences := compareFiles(oldFile, newFile); len(differences) > 0 {
+			d.Files = append(d.Files, FileChange{
+				Path:        path,
+				Change:      Modified,
+				Old:         &oldFile,
+				New:         &newFile,
+				Differences: differences,
+				SizeDelta:   newFile.Size - oldFile.Size,
+			})
+		}
+	}
+	for path, newFile := range newPkg.Files {
+		if _, ok := oldPkg.Files[path]; !ok {
+			d.Files = append(d.Files, FileChange{Path: path, Change: Added, New: &newFile, SizeDelta: newFile.Size})
+		}
+	}
+
+	slices.SortFunc(d.Files, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return d
+}
+
+// compareFiles names the ways in which two entries with the same path differ.
+func compareFiles(a, b File) []string {
+	var differences []string
+	if a.Type != b.Type {
+		differences = append(differences, "type")
+	}
+	if a.Digest != b.Digest || a.Size != b.Size {
+		differences = append(differences, "content")
+	}
+	if a.Mode != b.Mode {
+		differences = append(differences, "mode")
+	}
+	if a.UID != b.UID || a.GID != b.GID {
+		differences = append(differences, "owner")
+	}
+	if a.Link != b.Link {
+		differences = append(differences, "link")
+	}
+	return differences
+}
+
+// WriteText writes a human-readable summary of r to w: one line per changed
+// file, prefixed with "+" (added), "-" (removed) or "~" (modified).
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, p := range r.Packages {
+		switch p.Change {
+		case Added:
+			fmt.Fprintf(&b, "+ %s %s (%s): new package\n", p.Name, p.NewVersion, p.Arch)
+			continue
+		case Removed:
+			fmt.Fprintf(&b, "- %s %s (%s): removed package\n", p.Name, p.OldVersion, p.Arch)
+			continue
+		}
+
+		version := p.NewVersion
+		if p.OldVersion != p.NewVersion {
+			version = p.OldVersion + " -> " + p.NewVersion
+		}
+		if len(p.Files) == 0 {
+			fmt.Fprintf(&b, "%s %s (%s): no file changes\n", p.Name, version, p.Arch)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s (%s): %d files changed, %s\n", p.Name, version, p.Arch, len(p.Files), sizeDelta(p.SizeDelta))
+
+		for _, f := range p.Files {
+			switch f.Change {
+			case Added:
+				fmt.Fprintf(&b, "  + /%s%s\n", f.Path, describe(f.New))
+			case Removed:
+				fmt.Fprintf(&b, "  - /%s%s\n", f.Path, describe(f.Old))
+			case Modified:
+				details := make([]string, 0, len(f.Differences))
+				for _, d := range f.Differences {
+					switch d {
+					case "type":
+						details = append(details, fmt.Sprintf("type %s -> %s", f.Old.Type, f.New.Type))
+					case "content":
+						details = append(details, fmt.Sprintf("content %s", sizeDelta(f.SizeDelta)))
+					case "mode":
+						details = append(details, fmt.Sprintf("mode %s -> %s", f.Old.Mode, f.New.Mode))
+					case "owner":
+						details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", f.Old.UID, f.Old.GID, f.New.UID, f.New.GID))
+					case "link":
+						details = append(details, fmt.Sprintf("link %s -> %s", f.Old.Link, f.New.Link))
+					}
+				}
+				fmt.Fprintf(&b, "  ~ /%s: %s\n", f.Path, strings.Join(details, ", "))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describe formats the size of a regular file or the target of a link, to
+// follow its path.
+func describe(f *File) string {
+	switch f.Type {
+	case "file":
+		return fmt.Sprintf(" (%s)", humanize.Bytes(uint64(f.Size)))
+	case "dir":
+		return "/"
+	case "symlink", "hardlink":
+		return " -> " + f.Link
+	default:
+		return ""
+	}
+}
+
+// sizeDelta formats a change in size, such as "+1.2 kB" or "-300 B".
+func sizeDelta(n int64) string {
+	if n < 0 {
+		return "-" + humanize.Bytes(uint64(-n))
+	}
+	return "+" + humanize.Bytes(uint64(n))
+}
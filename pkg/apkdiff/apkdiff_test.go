@@ -0,0 +1,138 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apkdiff
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures are two builds of a hello package. The second build changes
+// the contents of usr/bin/hello, makes usr/lib/libhello.so.1 executable,
+// drops the README and adds usr/share/hello/greeting. It also replaces the
+// hello-doc subpackage with hello-dev.
+var (
+	oldAPK = filepath.Join("testdata", "old", "x86_64", "hello-1.0.0-r0.apk")
+	newAPK = filepath.Join("testdata", "new", "x86_64", "hello-1.0.0-r1.apk")
+)
+
+func TestDiffAPKs(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	r, err := Diff(ctx, oldAPK, newAPK)
+	require.NoError(t, err)
+	require.True(t, r.HasChanges())
+	require.Len(t, r.Packages, 1)
+
+	p := r.Packages[0]
+	require.Equal(t, "hello", p.Name)
+	require.Equal(t, "x86_64", p.Arch)
+	require.Equal(t, Modified, p.Change)
+	require.Equal(t, "1.0.0-r0", p.OldVersion)
+	require.Equal(t, "1.0.0-r1", p.NewVersion)
+
+	var paths []string
+	changes := map[string]FileChange{}
+	for _, f := range p.Files {
+		paths = append(paths, f.Path)
+		changes[f.Path] = f
+	}
+	require.Equal(t, []string{
+		"usr/bin/hello",
+		"usr/lib/libhello.so.1",
+		"usr/share/doc",
+		"usr/share/doc/hello",
+		"usr/share/doc/hello/README",
+		"usr/share/hello",
+		"usr/share/hello/greeting",
+	}, paths)
+
+	hello := changes["usr/bin/hello"]
+	require.Equal(t, Modified, hello.Change)
+	require.Equal(t, []string{"content"}, hello.Differences)
+	require.Equal(t, int64(9), hello.SizeDelta)
+	require.NotEqual(t, hello.Old.Digest, hello.New.Digest)
+
+	lib := changes["usr/lib/libhello.so.1"]
+	require.Equal(t, Modified, lib.Change)
+	require.Equal(t, []string{"mode"}, lib.Differences)
+	require.EqualValues(t, 0o644, lib.Old.Mode)
+	require.EqualValues(t, 0o755, lib.New.Mode)
+
+	readme := changes["usr/share/doc/hello/README"]
+	require.Equal(t, Removed, readme.Change)
+	require.Nil(t, readme.New)
+	require.Equal(t, int64(-25), readme.SizeDelta)
+
+	greeting := changes["usr/share/hello/greeting"]
+	require.Equal(t, Added, greeting.Change)
+	require.Nil(t, greeting.Old)
+	require.Equal(t, "file", greeting.New.Type)
+
+	require.Equal(t, int64(9-25+13), p.SizeDelta)
+
+	var text bytes.Buffer
+	require.NoError(t, r.WriteText(&text))
+	require.Contains(t, text.String(), "hello 1.0.0-r0 -> 1.0.0-r1 (x86_64): 7 files changed")
+	require.Contains(t, text.String(), "  ~ /usr/bin/hello: content +9 B\n")
+	require.Contains(t, text.String(), "  ~ /usr/lib/libhello.so.1: mode -rw-r--r-- -> -rwxr-xr-x\n")
+	require.Contains(t, text.String(), "  - /usr/share/doc/hello/README (25 B)\n")
+	require.Contains(t, text.String(), "  + /usr/share/hello/greeting (13 B)\n")
+}
+
+func TestDiffSameAPK(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	r, err := Diff(ctx, oldAPK, oldAPK)
+	require.NoError(t, err)
+	require.False(t, r.HasChanges())
+	require.Empty(t, r.Packages[0].Files)
+
+	var text bytes.Buffer
+	require.NoError(t, r.WriteText(&text))
+	require.Equal(t, "hello 1.0.0-r0 (x86_64): no file changes\n", text.String())
+}
+
+func TestDiffDirs(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	r, err := Diff(ctx, filepath.Join("testdata", "old"), filepath.Join("testdata", "new"))
+	require.NoError(t, err)
+	require.True(t, r.HasChanges())
+	require.Len(t, r.Packages, 3)
+
+	require.Equal(t, "hello", r.Packages[0].Name)
+	require.Equal(t, Modified, r.Packages[0].Change)
+	require.Len(t, r.Packages[0].Files, 7)
+
+	require.Equal(t, "hello-dev", r.Packages[1].Name)
+	require.Equal(t, Added, r.Packages[1].Change)
+	require.Equal(t, "1.0.0-r1", r.Packages[1].NewVersion)
+
+	require.Equal(t, "hello-doc", r.Packages[2].Name)
+	require.Equal(t, Removed, r.Packages[2].Change)
+	require.Equal(t, "1.0.0-r0", r.Packages[2].OldVersion)
+}
+
+func TestDiffMixed(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	_, err := Diff(ctx, oldAPK, filepath.Join("testdata", "new"))
+	require.ErrorContains(t, err, "both must be APK files or both directories")
+}
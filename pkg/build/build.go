@@ -43,6 +43,10 @@ import (
 	"github.com/dlorenc/melange2/pkg/build/sbom/spdx"
 	"github.com/dlorenc/melange2/pkg/buildkit"
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
+	"github.com/dlorenc/melange2/pkg/linter"
+	"github.com/dlorenc/melange2/pkg/output"
+	"github.com/dlorenc/melange2/pkg/util"
 )
 
 const melangeOutputDirName = "melange-out"
@@ -76,37 +80,73 @@ type Build struct {
 	WorkspaceIgnore string
 	// Ordered directories where to find 'uses' pipelines.
 	PipelineDirs          []string
+	ErrorOnShadow         bool
+	NormalizePipelines    bool
+	StopAfter             string
 	SourceDir             string
 	SigningKey            string
 	SigningPassphrase     string
 	Namespace             string
+	SBOMExtraPackages     []config.SBOMExtraPackage
 	GenerateIndex         bool
+	VerifyInstall         bool
+	FailOnEmptyPackage    bool
 	EmptyWorkspace        bool
+	IncludeSource         []string
 	OutDir                string
+	APKNameTemplate       string
 	Arch                  apko_types.Architecture
 	Libc                  string
 	ExtraKeys             []string
 	ExtraRepos            []string
 	ExtraPackages         []string
 	DependencyLog  string
+	// DependencyLogFormat is the format of the dependency log, "text" or "json".
+	DependencyLogFormat string
 	CreateBuildLog bool
 	PersistLintResults    bool
+	LintReport            *linter.Report
+	Summary               *Summary
+	ProgressMode          buildkit.ProgressMode
 	CacheDir        string
+	CacheDirReadOnly bool
 	ApkCacheDir     string
+	// Lockfile, if set, is an apko lockfile whose packages are installed as
+	// the build environment instead of resolving it.
+	Lockfile string
+	// WriteLockfile, if set, is where the resolved build environment is
+	// written as an apko lockfile.
+	WriteLockfile string
 	StripOriginName bool
 	EnvFile               string
 	VarsFile              string
 	BuildKitAddr          string // BuildKit daemon address
+	BuildKitDialTimeout   time.Duration
+	BuildKitWorker        string
+	CrossEmulation        bool
+	ParallelSolve         bool
+	Offline               bool // Reject builds that need network access; see buildkit.Builder.WithOffline
+	BuildKitClient        *buildkit.Client
 	Debug                 bool
 	Remove                bool
+	// KeepWorkspaceOnSuccess and KeepWorkspaceOnFailure keep the workspace
+	// after a build with that outcome, even if Remove is set.
+	KeepWorkspaceOnSuccess bool
+	KeepWorkspaceOnFailure bool
 	CacheRegistry         string // Registry URL for BuildKit cache (e.g., "registry:5000/cache")
 	CacheMode             string // Cache export mode: "min" or "max" (default: "max")
 	ApkoRegistry          string // Registry URL for caching apko base images (e.g., "registry:5000/apko-cache")
 	ApkoRegistryInsecure  bool   // Allow insecure (HTTP) connection to ApkoRegistry
+	ApkoRegistryStrict    bool   // Fail instead of falling back to local layers when ApkoRegistry is unavailable
+	ApkoRegistryAttachSBOM bool  // Attach an SBOM to images pushed to ApkoRegistry as an OCI referrer
 	ApkoServiceAddr       string // gRPC address of the apko service (e.g., "apko-server:9090")
 	LintRequire, LintWarn []string
 	Auth                  map[string]options.Auth
 	IgnoreSignatures      bool
+	KeyringVerify         bool
+	UserAgent             string // User-Agent of outbound HTTP requests; "melange/<version>" if empty
+	Proxy                 melangehttp.ProxyConfig // Proxies of outbound HTTP requests, also set in the pipeline environment
+	FetchTimeout          time.Duration
 
 	EnabledBuildOptions []string
 
@@ -127,6 +167,14 @@ type Build struct {
 	// For docker/registry: image reference (e.g., "debug:failed")
 	ExportRef string
 
+	// ExportBuildLog embeds the build log in the exported debug image.
+	ExportBuildLog bool
+
+	// OCILayout, if set, is an OCI image layout directory the output of
+	// the build is also exported to, as an image with the SBOMs of the
+	// packages attached.
+	OCILayout string
+
 	// SBOMGenerator is the generator used to create SBOMs for this build.
 	// If not set, defaults to DefaultSBOMGenerator.
 	SBOMGenerator sbom.Generator
@@ -152,9 +200,28 @@ type Build struct {
 	// Populated after BuildPackage completes.
 	BuildKitSummary *buildkit.Summary
 
+	// Phases are the durations of the phases of the build, as far as it
+	// got. Populated by NewFromConfig and BuildPackage.
+	Phases PhaseTimings
+
 	// ExtraEnv contains additional environment variables to inject into all pipeline steps.
 	// This is useful for passing credentials like GITHUB_TOKEN for private repo access.
 	ExtraEnv map[string]string
+
+	// succeeded records whether BuildPackage completed without error.
+	succeeded bool
+
+	// guestSBOM generates an SBOM of the build environment image. It is set
+	// by buildGuestLayersLocal when ApkoRegistryAttachSBOM is set.
+	guestSBOM buildkit.ImageSBOMFunc
+
+	// warnings are the warnings of the build, beyond those found parsing
+	// its configuration.
+	warnings []Warning
+
+	// generated are the dependencies static analysis generated for each
+	// package, by name, before the packages were linted.
+	generated map[string]config.Dependencies
 }
 
 // NewFromConfig creates a new Build from a BuildConfig.
@@ -170,46 +237,77 @@ func NewFromConfig(ctx context.Context, cfg *BuildConfig) (*Build, error) {
 		WorkspaceDir:               cfg.WorkspaceDir,
 		WorkspaceIgnore:            cfg.WorkspaceIgnore,
 		PipelineDirs:               cfg.PipelineDirs,
+		ErrorOnShadow:              cfg.ErrorOnShadow,
+		NormalizePipelines:         cfg.NormalizePipelines,
+		StopAfter:                  cfg.StopAfter,
 		SourceDir:                  cfg.SourceDir,
 		SigningKey:                 cfg.SigningKey,
 		SigningPassphrase:          cfg.SigningPassphrase,
 		Namespace:                  cfg.Namespace,
+		SBOMExtraPackages:          cfg.SBOMExtraPackages,
 		GenerateIndex:              cfg.GenerateIndex,
+		VerifyInstall:              cfg.VerifyInstall,
+		FailOnEmptyPackage:         cfg.FailOnEmptyPackage,
 		EmptyWorkspace:             cfg.EmptyWorkspace,
+		IncludeSource:              cfg.IncludeSource,
 		OutDir:                     cfg.OutDir,
+		APKNameTemplate:            cfg.APKNameTemplate,
 		Arch:                       cfg.Arch,
 		Libc:                       cfg.Libc,
 		ExtraKeys:                  cfg.ExtraKeys,
 		ExtraRepos:                 cfg.ExtraRepos,
 		ExtraPackages:              cfg.ExtraPackages,
 		DependencyLog:              cfg.DependencyLog,
+		DependencyLogFormat:        cfg.DependencyLogFormat,
 		CreateBuildLog:             cfg.CreateBuildLog,
 		PersistLintResults:         cfg.PersistLintResults,
+		LintReport:                 cfg.LintReport,
+		Summary:                    cfg.Summary,
+		ProgressMode:               cfg.ProgressMode,
 		CacheDir:                   cfg.CacheDir,
+		CacheDirReadOnly:           cfg.CacheDirReadOnly,
 		ApkCacheDir:                cfg.ApkCacheDir,
+		Lockfile:                   cfg.Lockfile,
+		WriteLockfile:              cfg.WriteLockfile,
 		StripOriginName:            cfg.StripOriginName,
 		EnvFile:                    cfg.EnvFile,
 		VarsFile:                   cfg.VarsFile,
 		BuildKitAddr:               cfg.BuildKitAddr,
+		BuildKitDialTimeout:        cfg.BuildKitDialTimeout,
+		BuildKitWorker:             cfg.BuildKitWorker,
+		CrossEmulation:             cfg.CrossEmulation,
+		ParallelSolve:              cfg.ParallelSolve,
+		Offline:                    cfg.Offline,
+		BuildKitClient:             cfg.BuildKitClient,
 		Debug:                      cfg.Debug,
 		Remove:                     cfg.Remove,
+		KeepWorkspaceOnSuccess:     cfg.KeepWorkspaceOnSuccess,
+		KeepWorkspaceOnFailure:     cfg.KeepWorkspaceOnFailure,
 		CacheRegistry:              cfg.CacheRegistry,
 		CacheMode:                  cfg.CacheMode,
 		ApkoRegistry:               cfg.ApkoRegistry,
 		ApkoRegistryInsecure:       cfg.ApkoRegistryInsecure,
+		ApkoRegistryStrict:         cfg.ApkoRegistryStrict,
+		ApkoRegistryAttachSBOM:     cfg.ApkoRegistryAttachSBOM,
 		ApkoServiceAddr:            cfg.ApkoServiceAddr,
 		LintRequire:                cfg.LintRequire,
 		LintWarn:                   cfg.LintWarn,
 		Auth:                       cfg.Auth,
 		IgnoreSignatures:           cfg.IgnoreSignatures,
+		UserAgent:                  cfg.UserAgent,
+		Proxy:                      cfg.Proxy,
+		FetchTimeout:               cfg.FetchTimeout,
+		KeyringVerify:              cfg.KeyringVerify,
 		EnabledBuildOptions:        cfg.EnabledBuildOptions,
 		MaxLayers:                  cfg.MaxLayers,
 		ExportOnFailure:            cfg.ExportOnFailure,
 		ExportRef:                  cfg.ExportRef,
+		ExportBuildLog:             cfg.ExportBuildLog,
+		OCILayout:                  cfg.OCILayout,
 		GenerateProvenance:         cfg.GenerateProvenance,
 		ExtraEnv:                   cfg.ExtraEnv,
 		Start:                      time.Now(),
-		SBOMGenerator:              &spdx.Generator{},
+		SBOMGenerator:              &spdx.Generator{Concurrency: cfg.SBOMConcurrency},
 	}
 
 	// Apply defaults
@@ -270,6 +368,10 @@ func (b *Build) initialize(ctx context.Context) (*Build, error) {
 		}
 	}
 
+	if err := validateDependencyLogFormat(b.DependencyLogFormat); err != nil {
+		return nil, err
+	}
+
 	// If no config file could be automatically detected, error.
 	if b.ConfigFile == "" {
 		return nil, fmt.Errorf("melange.yaml is missing")
@@ -280,28 +382,85 @@ func (b *Build) initialize(ctx context.Context) (*Build, error) {
 	if b.ConfigFileRepositoryCommit == "" {
 		return nil, fmt.Errorf("config file repository commit was not set")
 	}
+	if b.ConfigFileRepositoryURL == UnknownRepositoryURL {
+		b.warn(ctx, config.WarningGit, "git repository URL for build config not provided")
+	}
 
 	if b.Configuration == nil {
+		parseStart := time.Now()
 		parsedCfg, err := config.ParseConfiguration(ctx,
 			b.ConfigFile,
 			config.WithEnvFileForParsing(b.EnvFile),
 			config.WithVarsFileForParsing(b.VarsFile),
 			config.WithCommit(b.ConfigFileRepositoryCommit),
 		)
+		b.addPhase(PhaseConfigParse, time.Since(parseStart))
 		if err != nil {
 			return nil, fmt.Errorf("failed to load configuration: %w", err)
 		}
 		b.Configuration = parsedCfg
 	}
 
+	// Declare extra SBOM packages on a copy, as the configuration may be
+	// shared with builds for other architectures.
+	if len(b.SBOMExtraPackages) > 0 {
+		cfg := *b.Configuration
+		sbomOpts := config.PackageSBOM{}
+		if cfg.Package.SBOM != nil {
+			sbomOpts = *cfg.Package.SBOM
+		}
+		sbomOpts.ExtraPackages = slices.Concat(sbomOpts.ExtraPackages, b.SBOMExtraPackages)
+		cfg.Package.SBOM = &sbomOpts
+		b.Configuration = &cfg
+	}
+
+	// Install the conditional packages whose condition holds for this
+	// architecture, again on a copy.
+	if len(b.Configuration.ConditionalPackages) > 0 {
+		pkgs, err := b.conditionalPackages()
+		if err != nil {
+			return nil, err
+		}
+		cfg := *b.Configuration
+		cfg.Environment.Contents.Packages = slices.Concat(cfg.Environment.Contents.Packages, pkgs)
+		b.Configuration = &cfg
+	}
+
+	// Emit the conditional runtime dependencies whose condition holds, on
+	// a copy as well.
+	if err := b.resolveConditionalDependencies(); err != nil {
+		return nil, err
+	}
+
 	if len(b.Configuration.Package.TargetArchitecture) == 1 &&
 		b.Configuration.Package.TargetArchitecture[0] == "all" {
-		log.Warnf("target-architecture: ['all'] is deprecated and will become an error; remove this field to build for all available archs")
-	} else if len(b.Configuration.Package.TargetArchitecture) != 0 &&
-		!slices.Contains(b.Configuration.Package.TargetArchitecture, b.Arch.ToAPK()) {
-		return nil, ErrSkipThisArch
+		b.warn(ctx, config.WarningDeprecated, "target-architecture: ['all'] is deprecated and will become an error; remove this field to build for all available archs")
+	} else {
+		ok, err := targetsArch(b.Configuration.Package.TargetArchitecture, b.Arch.ToAPK())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrSkipThisArch
+		}
 	}
 
+	if err := output.ValidateAPKNameTemplate(b.APKNameTemplate, b.Configuration, b.Arch.ToAPK()); err != nil {
+		return nil, err
+	}
+
+	if b.OCILayout != "" {
+		if err := buildkit.ValidateOCILayout(b.OCILayout); err != nil {
+			return nil, err
+		}
+	}
+
+	extraRepos, err := resolveLocalRepositories(ctx, b.ExtraRepos, b.Arch)
+	if err != nil {
+		return nil, err
+	}
+	b.ExtraRepos = extraRepos
+
 	// SOURCE_DATE_EPOCH will always overwrite the build flag
 	if _, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
 		t, err := sourceDateEpoch(b.SourceDateEpoch)
@@ -311,6 +470,13 @@ func (b *Build) initialize(ctx context.Context) (*Build, error) {
 		b.SourceDateEpoch = t
 	}
 
+	// Apply build options and deduplicate packages on a copy, as above,
+	// with its own package list and variables.
+	cfg := *b.Configuration
+	cfg.Environment.Contents.Packages = slices.Clone(cfg.Environment.Contents.Packages)
+	cfg.Vars = maps.Clone(cfg.Vars)
+	b.Configuration = &cfg
+
 	// Apply build options to the context.
 	for _, optName := range b.EnabledBuildOptions {
 		log.Infof("applying configuration patches for build option %s", optName)
@@ -320,13 +486,146 @@ func (b *Build) initialize(ctx context.Context) (*Build, error) {
 		}
 	}
 
+	// With the build options applied, the configuration and
+	// --package-append may list a package more than once.
+	envPkgs := dedupPackages(b.Configuration.Environment.Contents.Packages)
+	extraPkgs := slices.DeleteFunc(dedupPackages(b.ExtraPackages), func(pkg string) bool {
+		return slices.Contains(envPkgs, pkg)
+	})
+	if err := checkPackagePins(slices.Concat(envPkgs, extraPkgs)); err != nil {
+		return nil, fmt.Errorf("build environment packages: %w", err)
+	}
+	b.Configuration.Environment.Contents.Packages = envPkgs
+	b.ExtraPackages = extraPkgs
+
 	return b, nil
 }
 
+// conditionalPackages returns the conditional packages of the build
+// environment whose condition holds for the architecture being built.
+func (b *Build) conditionalPackages() ([]string, error) {
+	sm, err := NewSubstitutionMap(b.Configuration, b.Arch, b.buildFlavor(), b.EnabledBuildOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []string
+	for _, p := range b.Configuration.ConditionalPackages {
+		ifs, err := util.MutateAndQuoteStringFromMap(sm.Substitutions, p.If)
+		if err != nil {
+			return nil, fmt.Errorf("conditional package %s: %w", p.Name, err)
+		}
+		ok, err := shouldRun(ifs)
+		if err != nil {
+			return nil, fmt.Errorf("conditional package %s: %w", p.Name, err)
+		}
+		if ok {
+			pkgs = append(pkgs, p.Name)
+		}
+	}
+	return pkgs, nil
+}
+
+// resolveConditionalDependencies replaces the configuration with a copy
+// in which the conditional runtime dependencies of the package and its
+// subpackages whose condition holds are runtime dependencies. Besides the
+// substitutions of a pipeline's if, a condition may test
+// ${{subpackages.<name>.enabled}}, which is 'true' when the subpackage is
+// built.
+func (b *Build) resolveConditionalDependencies() error {
+	hasConditional := len(b.Configuration.Package.Dependencies.ConditionalRuntime) > 0
+	for _, sp := range b.Configuration.Subpackages {
+		hasConditional = hasConditional || len(sp.Dependencies.ConditionalRuntime) > 0
+	}
+	if !hasConditional {
+		return nil
+	}
+
+	sm, err := NewSubstitutionMap(b.Configuration, b.Arch, b.buildFlavor(), b.EnabledBuildOptions)
+	if err != nil {
+		return err
+	}
+	for _, sp := range b.Configuration.Subpackages {
+		ifs, err := util.MutateAndQuoteStringFromMap(sm.Subpackage(&sp).Substitutions, sp.If)
+		if err != nil {
+			return fmt.Errorf("subpackage %s: %w", sp.Name, err)
+		}
+		enabled, err := shouldRun(ifs)
+		if err != nil {
+			return fmt.Errorf("subpackage %s: %w", sp.Name, err)
+		}
+		sm.Substitutions[fmt.Sprintf("${{subpackages.%s.enabled}}", sp.Name)] = strconv.FormatBool(enabled)
+	}
+
+	resolve := func(pkg string, deps *config.Dependencies) error {
+		var runtime []string
+		for _, d := range deps.ConditionalRuntime {
+			ifs, err := util.MutateAndQuoteStringFromMap(sm.Substitutions, d.If)
+			if err != nil {
+				return fmt.Errorf("%s: conditional dependency %s: %w", pkg, d.Name, err)
+			}
+			ok, err := shouldRun(ifs)
+			if err != nil {
+				return fmt.Errorf("%s: conditional dependency %s: %w", pkg, d.Name, err)
+			}
+			if ok {
+				runtime = append(runtime, d.Name)
+			}
+		}
+		deps.Runtime = slices.Concat(deps.Runtime, runtime)
+		deps.ConditionalRuntime = nil
+		return nil
+	}
+
+	cfg := *b.Configuration
+	if err := resolve(cfg.Package.Name, &cfg.Package.Dependencies); err != nil {
+		return err
+	}
+	cfg.Subpackages = slices.Clone(cfg.Subpackages)
+	for i := range cfg.Subpackages {
+		sp := &cfg.Subpackages[i]
+		if err := resolve(sp.Name, &sp.Dependencies); err != nil {
+			return err
+		}
+	}
+	b.Configuration = &cfg
+	return nil
+}
+
+// targetsArch reports whether a package with the given target-architecture
+// list should be built for arch. The list is either an allowlist, or made up
+// entirely of negated entries such as "!riscv64" which build every arch except
+// those named. Mixing the two forms is an error.
+func targetsArch(targets []string, arch string) (bool, error) {
+	if len(targets) == 0 {
+		return true, nil
+	}
+
+	var allowed, negated []string
+	for _, ta := range targets {
+		if name, ok := strings.CutPrefix(ta, "!"); ok {
+			negated = append(negated, apko_types.ParseArchitecture(name).ToAPK())
+		} else {
+			allowed = append(allowed, apko_types.ParseArchitecture(ta).ToAPK())
+		}
+	}
+
+	switch {
+	case len(allowed) > 0 && len(negated) > 0:
+		return false, fmt.Errorf("target-architecture cannot mix allowed and negated (!) entries: %v", targets)
+	case len(negated) > 0:
+		return !slices.Contains(negated, arch), nil
+	default:
+		return slices.Contains(allowed, arch), nil
+	}
+}
+
 func (b *Build) Close(ctx context.Context) error {
 	log := clog.FromContext(ctx)
 	errs := []error{}
-	if b.Remove {
+	if b.keepWorkspace() {
+		log.Infof("keeping workspace dir %s", b.WorkspaceDir)
+	} else {
 		log.Debugf("deleting workspace dir %s", b.WorkspaceDir)
 		errs = append(errs, os.RemoveAll(b.WorkspaceDir))
 	}
@@ -334,6 +633,19 @@ func (b *Build) Close(ctx context.Context) error {
 	return errors.Join(errs...)
 }
 
+// keepWorkspace reports whether the workspace should be kept once the build
+// is over. A build that never ran counts as failed. The partial workspace
+// of a build stopped with StopAfter is always kept.
+func (b *Build) keepWorkspace() bool {
+	if b.StopAfter != "" {
+		return true
+	}
+	if b.succeeded {
+		return !b.Remove || b.KeepWorkspaceOnSuccess
+	}
+	return !b.Remove || b.KeepWorkspaceOnFailure
+}
+
 func copyFile(base, src, dest string, perm fs.FileMode) error {
 	basePath := filepath.Join(base, src)
 	destPath := filepath.Join(dest, src)
@@ -393,6 +705,42 @@ func (b *Build) applyBuildOption(bo config.BuildOption) {
 	}
 }
 
+// dedupPackages returns pkgs without repeated entries, keeping the first of
+// each in order.
+func dedupPackages(pkgs []string) []string {
+	seen := make(map[string]bool, len(pkgs))
+	var deduped []string
+	for _, pkg := range pkgs {
+		if !seen[pkg] {
+			seen[pkg] = true
+			deduped = append(deduped, pkg)
+		}
+	}
+	return deduped
+}
+
+// checkPackagePins fails if pkgs pin a package to more than one version, as
+// in "foo=1" and "foo=2". Other version constraints are left to apk to
+// resolve.
+func checkPackagePins(pkgs []string) error {
+	type pin struct{ version, pkg string }
+	pins := make(map[string]pin)
+	for _, pkg := range pkgs {
+		name := apk.ResolvePackageNameVersionPin(pkg).Name
+		version, ok := strings.CutPrefix(strings.TrimPrefix(pkg, name), "=")
+		if !ok || strings.HasPrefix(version, "~") {
+			continue
+		}
+		// Drop the repository tag, as in "foo=1@local".
+		version, _, _ = strings.Cut(version, "@")
+		if p, ok := pins[name]; ok && p.version != version {
+			return fmt.Errorf("package %s is pinned to conflicting versions: %s and %s", name, p.pkg, pkg)
+		}
+		pins[name] = pin{version: version, pkg: pkg}
+	}
+	return nil
+}
+
 func (b *Build) loadIgnoreRules(ctx context.Context) ([]*xignore.Pattern, error) {
 	log := clog.FromContext(ctx)
 	ignorePath := filepath.Join(b.SourceDir, b.WorkspaceIgnore)
@@ -454,6 +802,22 @@ func (b Build) getBuildConfigPURL() (*purl.PackageURL, error) {
 	return u, nil
 }
 
+// includePatterns compiles the IncludeSource patterns.
+func (b *Build) includePatterns() ([]*xignore.Pattern, error) {
+	patterns := make([]*xignore.Pattern, 0, len(b.IncludeSource))
+	for _, rule := range b.IncludeSource {
+		pattern := xignore.NewPattern(rule)
+		if err := pattern.Prepare(); err != nil {
+			return nil, fmt.Errorf("include-source %q: %w", rule, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// populateWorkspace copies the files of src, but for those matching the
+// ignore rules, into the workspace. In an empty workspace, only the files
+// matching the IncludeSource patterns are copied.
 func (b *Build) populateWorkspace(ctx context.Context, src fs.FS) error {
 	log := clog.FromContext(ctx)
 	_, span := otel.Tracer("melange").Start(ctx, "populateWorkspace")
@@ -464,6 +828,11 @@ func (b *Build) populateWorkspace(ctx context.Context, src fs.FS) error {
 		return err
 	}
 
+	includePatterns, err := b.includePatterns()
+	if err != nil {
+		return err
+	}
+
 	// Write out build settings into workspacedir
 	// For now, just the gcc spec file and just link settings.
 	// In the future can control debug symbol generation, march/mtune, etc.
@@ -499,6 +868,12 @@ func (b *Build) populateWorkspace(ctx context.Context, src fs.FS) error {
 			}
 		}
 
+		if b.EmptyWorkspace && !slices.ContainsFunc(includePatterns, func(pat *xignore.Pattern) bool {
+			return pat.Match(path)
+		}) {
+			return nil
+		}
+
 		log.Debugf("  -> %s", path)
 
 		if err := copyFile(b.SourceDir, path, b.WorkspaceDir, mode.Perm()); err != nil {
@@ -514,7 +889,18 @@ func (b *Build) BuildPackage(ctx context.Context) error {
 	defer span.End()
 
 	// All builds use BuildKit
-	return b.buildPackageBuildKit(ctx)
+	err := b.buildPackageBuildKit(ctx)
+	if len(b.Phases) > 0 {
+		clog.FromContext(ctx).Infof("build phases: %s", b.Phases)
+	}
+	if b.Summary != nil {
+		b.Summary.Add(b.summaryEntries(err)...)
+	}
+	if err != nil {
+		return err
+	}
+	b.succeeded = true
+	return nil
 }
 
 func (b *Build) SummarizePaths(ctx context.Context) {
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
@@ -33,6 +34,7 @@ import (
 	"github.com/chainguard-dev/clog"
 	"github.com/google/uuid"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcr_types "github.com/google/go-containerregistry/pkg/v1/types"
 	"go.opentelemetry.io/otel"
 	"gopkg.in/yaml.v3"
 	"sigs.k8s.io/release-utils/version"
@@ -40,7 +42,9 @@ import (
 	"github.com/dlorenc/melange2/pkg/build/sbom"
 	"github.com/dlorenc/melange2/pkg/build/sbom/spdx"
 	"github.com/dlorenc/melange2/pkg/buildkit"
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 	"github.com/dlorenc/melange2/pkg/output"
 	apkoservice "github.com/dlorenc/melange2/pkg/service/apko"
 )
@@ -48,6 +52,12 @@ import (
 // buildPackageBuildKit implements package building using BuildKit.
 // This is called when BuildKitAddr is set.
 func (b *Build) buildPackageBuildKit(ctx context.Context) error {
+	// Capture the log from the start so a debug image has the full context.
+	var buildLog *buildkit.LogBuffer
+	if b.ExportBuildLog && b.ExportOnFailure != "" && b.ExportOnFailure != "none" {
+		ctx, buildLog = buildkit.CaptureLog(ctx)
+	}
+
 	log := clog.FromContext(ctx)
 	ctx, span := otel.Tracer("melange").Start(ctx, "buildPackageBuildKit")
 	defer span.End()
@@ -73,11 +83,25 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		ctx = tctx
 	}
 
+	if b.NormalizePipelines {
+		if n := b.Configuration.NormalizePipelines(); n > 0 {
+			log.Infof("collapsed %d nested pipelines", n)
+		}
+	}
+
 	log.Debugf("evaluating pipelines for package requirements")
 	if err := b.Compile(ctx); err != nil {
 		return fmt.Errorf("compiling %s: %w", b.ConfigFile, err)
 	}
 
+	if b.KeyringVerify {
+		if b.IgnoreSignatures {
+			b.warn(ctx, config.WarningBuild, "skipping keyring verification because signatures are ignored")
+		} else if err := b.verifyKeyring(ctx); err != nil {
+			return err
+		}
+	}
+
 	// Filter out any subpackages with false If conditions.
 	b.Configuration.Subpackages = slices.DeleteFunc(b.Configuration.Subpackages, func(sp config.Subpackage) bool {
 		result, err := shouldRun(sp.If)
@@ -90,6 +114,19 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		return !result
 	})
 
+	if b.StopAfter != "" {
+		if err := b.stopAfter(); err != nil {
+			return err
+		}
+		log.Infof("stopping the build after step %q", b.StopAfter)
+	}
+
+	if b.Offline {
+		if err := b.checkOffline(); err != nil {
+			return err
+		}
+	}
+
 	// Initialize SBOMGroup for the main package and all subpackages
 	pkgNames := []string{b.Configuration.Package.Name}
 	for _, sp := range b.Configuration.Subpackages {
@@ -97,8 +134,9 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 	}
 	b.SBOMGroup = spdx.NewSBOMGroup(pkgNames...)
 
-	// Prepare workspace directory
-	if !b.EmptyWorkspace {
+	// Prepare workspace directory. An empty workspace is still given the
+	// files it includes.
+	if !b.EmptyWorkspace || len(b.IncludeSource) > 0 {
 		if err := os.MkdirAll(b.WorkspaceDir, 0o755); err != nil {
 			return fmt.Errorf("mkdir -p %s: %w", b.WorkspaceDir, err)
 		}
@@ -112,52 +150,48 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		}
 	}
 
-	// Create melange-out directories
-	if err := os.MkdirAll(filepath.Join(b.WorkspaceDir, melangeOutputDirName, b.Configuration.Package.Name), 0o755); err != nil {
-		return err
-	}
-	for _, sp := range b.Configuration.Subpackages {
-		if err := os.MkdirAll(filepath.Join(b.WorkspaceDir, melangeOutputDirName, sp.Name), 0o755); err != nil {
-			return err
-		}
-	}
-
 	// Build the guest environment with apko and get the layer(s)
 	log.Info("building guest environment with apko")
 	apkoStart := time.Now()
 	layers, releaseData, layerCleanup, err := b.buildGuestLayers(ctx)
 	apkoDuration := time.Since(apkoStart)
+	b.addPhase(PhaseApkoLayers, apkoDuration)
 	if err != nil {
 		return fmt.Errorf("building guest layers: %w", err)
 	}
 	defer layerCleanup()
 	log.Infof("apko_layer_generation took %s (%d layers)", apkoDuration, len(layers))
 
-	// Create BuildKit builder
-	builder, err := buildkit.NewBuilder(b.BuildKitAddr)
-	if err != nil {
-		return fmt.Errorf("creating buildkit builder: %w", err)
+	// Create BuildKit builder, reusing a shared connection if there is one
+	var builder *buildkit.Builder
+	if b.BuildKitClient != nil {
+		builder = buildkit.NewBuilderWithClient(b.BuildKitClient)
+	} else {
+		builder, err = buildkit.NewBuilder(b.BuildKitAddr, buildkit.WithDialTimeout(b.BuildKitDialTimeout))
+		if err != nil {
+			return fmt.Errorf("creating buildkit builder: %w", err)
+		}
 	}
 	defer builder.Close()
 
+	if b.BuildKitWorker != "" {
+		if err := builder.SelectWorker(ctx, b.BuildKitWorker); err != nil {
+			return err
+		}
+	}
+
+	if b.ProgressMode != "" {
+		builder.WithProgressMode(b.ProgressMode)
+	}
+
 	// Enable verbose output in debug mode
 	if b.Debug {
 		builder.WithShowLogs(true)
 	}
 
-	// Build base environment from apko configuration
-	// Use a minimum SOURCE_DATE_EPOCH of Jan 1, 1980 (315532800) to avoid issues
-	// with software that can't handle very old timestamps (e.g., Ruby's gem build)
-	sourceEpoch := b.SourceDateEpoch.Unix()
-	if sourceEpoch < 315532800 {
-		sourceEpoch = 315532800
-	}
-	baseEnv := map[string]string{
-		"SOURCE_DATE_EPOCH": fmt.Sprintf("%d", sourceEpoch),
-	}
-	maps.Copy(baseEnv, b.Configuration.Environment.Environment)
-	// Merge in extra environment variables (e.g., GITHUB_TOKEN for private repos)
-	maps.Copy(baseEnv, b.ExtraEnv)
+	builder.WithOffline(b.Offline)
+
+	baseEnv := b.pipelineEnvironment()
 
 	// Run the build
 	cfg := &buildkit.BuildConfig{
@@ -169,9 +203,26 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		SourceDir:       b.SourceDir,
 		WorkspaceDir:    b.WorkspaceDir,
 		CacheDir:        b.CacheDir,
+		CacheDirReadOnly: b.CacheDirReadOnly,
 		Debug:           b.Debug,
+		Redact:          b.Configuration.RedactEnvironment,
+		SecretEnv:       b.Proxy.Environment(),
 		ExportOnFailure: b.ExportOnFailure,
 		ExportRef:       b.ExportRef,
+		BuildLog:        buildLog,
+		CrossEmulation:  b.CrossEmulation,
+		ParallelSolve:   b.ParallelSolve,
+	}
+
+	// BuildKit exports the image to a layout of its own, which is added to
+	// b.OCILayout, shared with other builds, once its SBOMs are generated.
+	if b.OCILayout != "" {
+		staging, err := os.MkdirTemp("", "melange-oci-layout-")
+		if err != nil {
+			return fmt.Errorf("creating OCI layout staging dir: %w", err)
+		}
+		defer os.RemoveAll(staging)
+		cfg.OCILayoutDir = staging
 	}
 
 	// Add cache config if registry is configured
@@ -188,6 +239,12 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		cfg.ApkoRegistryConfig = &buildkit.ApkoRegistryConfig{
 			Registry: b.ApkoRegistry,
 			Insecure: b.ApkoRegistryInsecure,
+			Strict:    b.ApkoRegistryStrict,
+			SBOM:      b.guestSBOM,
+			UserAgent: b.UserAgent,
+		}
+		if b.ApkoRegistryAttachSBOM && b.guestSBOM == nil {
+			b.warn(ctx, config.WarningBuild, "not attaching an SBOM to the apko base image: unsupported with the apko service")
 		}
 		// Pass the image configuration for cache key generation
 		cfg.ImgConfig = &b.Configuration.Environment
@@ -195,7 +252,9 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 
 	log.Info("running build with BuildKit")
 	buildkitStart := time.Now()
-	if err := builder.BuildWithLayers(ctx, layers, cfg); err != nil {
+	err = builder.BuildWithLayers(ctx, layers, cfg)
+	b.addBuildKitPhases(builder.GetLastTimings())
+	if err != nil {
 		// Capture step timing even on failure for diagnostics
 		b.BuildKitSummary = builder.GetLastSummary()
 		return fmt.Errorf("buildkit build failed: %w", err)
@@ -206,6 +265,18 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 	// Capture BuildKit step timing for metrics
 	b.BuildKitSummary = builder.GetLastSummary()
 
+	if b.StopAfter != "" {
+		log.Infof("stopped after step %q; the partial workspace is in %s and no packages were built", b.StopAfter, b.WorkspaceDir)
+		return nil
+	}
+
+	// The build creates the output directory of every package, so they
+	// come only from the export, and are checked for in it before packages
+	// are built from it.
+	if err := buildkit.VerifyExport(filepath.Join(b.WorkspaceDir, melangeOutputDirName), pkgNames); err != nil {
+		return fmt.Errorf("buildkit build failed: %w", err)
+	}
+
 	// Load the workspace output into memory for further processing
 	log.Infof("loading workspace from: %s", b.WorkspaceDir)
 	b.WorkspaceDirFS = apkofs.DirFS(ctx, b.WorkspaceDir)
@@ -216,16 +287,27 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		return fmt.Errorf("getting PURL for build config: %w", err)
 	}
 
+	// Packages are analyzed once, before they are linted, so that linters
+	// take the dependencies it generates into account and emitting them
+	// reuses them.
+	generated, err := b.generateDependencies(ctx)
+	if err != nil {
+		return fmt.Errorf("generating dependencies: %w", err)
+	}
+
 	// Run post-build processing using the output processor
 	processor := &output.Processor{
 		Options: output.ProcessOptions{
-			SkipIndex: !b.GenerateIndex,
+			SkipIndex:          !b.GenerateIndex,
+			FailOnEmptyPackage: b.FailOnEmptyPackage,
 		},
 		Lint: output.LintConfig{
 			Require:        b.LintRequire,
 			Warn:           b.LintWarn,
 			PersistResults: b.PersistLintResults,
 			OutDir:         b.OutDir,
+			Report:         b.LintReport,
+			Dependencies:   generated,
 		},
 		SBOM: output.SBOMConfig{
 			Generator: b.SBOMGenerator,
@@ -243,7 +325,15 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 			Emitter: b.Emit,
 		},
 		Index: output.IndexConfig{
-			SigningKey: b.SigningKey,
+			SigningKey:      b.SigningKey,
+			APKNameTemplate: b.APKNameTemplate,
+		},
+		Verify: output.VerifyConfig{
+			Enabled:          b.VerifyInstall,
+			Repositories:     append(slices.Clone(b.Configuration.Environment.Contents.Repositories), b.ExtraRepos...),
+			Keyring:          append(slices.Clone(b.Configuration.Environment.Contents.Keyring), b.ExtraKeys...),
+			IgnoreSignatures: b.IgnoreSignatures,
+			ApkCacheDir:      b.ApkCacheDir,
 		},
 	}
 
@@ -256,17 +346,66 @@ func (b *Build) buildPackageBuildKit(ctx context.Context) error {
 		SourceDateEpoch: b.SourceDateEpoch,
 	}
 
-	if err := processor.Process(ctx, processInput); err != nil {
-		return err
+	exportStart := time.Now()
+	err = processor.Process(ctx, processInput)
+	if err == nil && b.OCILayout != "" {
+		err = b.exportOCILayout(ctx, cfg.OCILayoutDir)
 	}
+	b.addPhase(PhaseExport, time.Since(exportStart))
+	// The workspace is removed, or kept, by Close, which knows the outcome
+	// of the build.
+	return err
+}
 
-	// Clean up workspace
-	log.Debugf("cleaning workspace")
-	if err := os.RemoveAll(b.WorkspaceDir); err != nil {
-		log.Warnf("unable to clean workspace: %s", err)
+// pipelineEnvironment returns the base environment of the pipeline steps.
+// The proxies are not part of it: they are set in the steps as build
+// secrets, as they may hold credentials.
+func (b *Build) pipelineEnvironment() map[string]string {
+	// Use a minimum SOURCE_DATE_EPOCH of Jan 1, 1980 (315532800) to avoid issues
+	// with software that can't handle very old timestamps (e.g., Ruby's gem build)
+	sourceEpoch := b.SourceDateEpoch.Unix()
+	if sourceEpoch < 315532800 {
+		sourceEpoch = 315532800
+	}
+	env := map[string]string{
+		"SOURCE_DATE_EPOCH": fmt.Sprintf("%d", sourceEpoch),
+	}
+	maps.Copy(env, b.Configuration.Environment.Environment)
+	// The proxies given on the command line take precedence over those of
+	// the configuration, which may not know the network it is built on.
+	withoutProxies(env, b.Proxy)
+	// Merge in extra environment variables (e.g., GITHUB_TOKEN for private repos)
+	maps.Copy(env, b.ExtraEnv)
+	return env
+}
+
+// withoutProxies removes from env the variables setting the proxies of
+// proxy, so that those set as build secrets are not overridden.
+func withoutProxies(env map[string]string, proxy melangehttp.ProxyConfig) {
+	for name := range proxy.Environment() {
+		delete(env, name)
+	}
+}
+
+// exportOCILayout adds the image BuildKit exported to the layout in staging
+// to b.OCILayout, with the SBOMs generated for the packages attached.
+func (b *Build) exportOCILayout(ctx context.Context, staging string) error {
+	sbomPaths, err := filepath.Glob(filepath.Join(b.WorkspaceDir, melangeOutputDirName, "*", sbom.SBOMDir, "*.spdx.json"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(sbomPaths)
+	sboms := make([][]byte, 0, len(sbomPaths))
+	for _, p := range sbomPaths {
+		doc, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("reading SBOM: %w", err)
+		}
+		sboms = append(sboms, doc)
 	}
 
-	return nil
+	refName := fmt.Sprintf("%s-%s-%s", b.Configuration.Package.Name, b.Configuration.Package.FullVersion(), b.Arch.ToAPK())
+	return buildkit.AppendOCILayout(ctx, b.OCILayout, staging, refName, sboms)
 }
 
 // buildGuestLayers builds the apko image and returns layers for BuildKit.
@@ -297,6 +436,10 @@ func (b *Build) buildGuestLayersRemote(ctx context.Context) ([]v1.Layer, *apko_b
 	ctx, span := otel.Tracer("melange").Start(ctx, "buildGuestLayersRemote")
 	defer span.End()
 
+	if b.Lockfile != "" || b.WriteLockfile != "" {
+		return nil, nil, nil, errors.New("lockfiles are not supported with the apko service")
+	}
+
 	// Serialize image configuration to YAML
 	imgConfig := b.Configuration.Environment
 	configYAML, err := yaml.Marshal(imgConfig)
@@ -366,17 +509,12 @@ func (b *Build) buildGuestLayersRemote(ctx context.Context) ([]v1.Layer, *apko_b
 	return nil, releaseData, func() {}, nil
 }
 
-// buildGuestLayersLocal builds layers locally using the apko library.
-func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_build.ReleaseData, func(), error) {
+// guestImageConfiguration returns the apko image configuration used to resolve
+// the build environment. Build-only repositories from the configuration are
+// merged with --repository-append; neither is carried into test environments,
+// which are resolved from the test block alone.
+func (b *Build) guestImageConfiguration(ctx context.Context) apko_types.ImageConfiguration {
 	log := clog.FromContext(ctx)
-	ctx, span := otel.Tracer("melange").Start(ctx, "buildGuestLayersLocal")
-	defer span.End()
-
-	tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("creating apko tempdir: %w", err)
-	}
-	cleanup := func() { os.RemoveAll(tmp) }
 
 	imgConfig := b.Configuration.Environment
 	imgConfig.Archs = []apko_types.Architecture{b.Arch}
@@ -392,19 +530,23 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 		imgConfig.Contents.Keyring = append(imgConfig.Contents.Keyring, b.ExtraKeys...)
 	}
 
-	// Set the layer budget based on MaxLayers configuration
-	// Default to 50 if not set
-	maxLayers := b.MaxLayers
-	if maxLayers == 0 {
-		maxLayers = 50
-	}
-	// Use "origin" strategy which partitions packages by their origin
-	// This groups related packages together for better cache efficiency
-	imgConfig.Layering = &apko_types.Layering{
-		Strategy: "origin",
-		Budget:   maxLayers,
+	// Copy before appending so the parsed configuration is left untouched.
+	buildRepos := slices.Clone(imgConfig.Contents.BuildRepositories)
+	for _, repo := range b.ExtraRepos {
+		if !slices.Contains(buildRepos, repo) {
+			buildRepos = append(buildRepos, repo)
+		}
 	}
-	log.Infof("using layer budget of %d with origin strategy", maxLayers)
+	imgConfig.Contents.BuildRepositories = buildRepos
+
+	return imgConfig
+}
+
+// guestApkoOptions returns the apko options resolving and installing the
+// build environment described by imgConfig, using tmp as the temporary
+// directory.
+func (b *Build) guestApkoOptions(ctx context.Context, imgConfig apko_types.ImageConfiguration, tmp string) []apko_build.Option {
+	log := clog.FromContext(ctx)
 
 	opts := []apko_build.Option{
 		apko_build.WithImageConfiguration(imgConfig),
@@ -416,6 +558,9 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 		apko_build.WithTempDir(tmp),
 		apko_build.WithIgnoreSignatures(b.IgnoreSignatures),
 	}
+	if b.UserAgent != "" {
+		opts = append(opts, apko_build.WithTransport(melangehttp.NewUserAgentTransport(http.DefaultTransport, b.UserAgent)))
+	}
 
 	// Convert auth config to apko authenticator
 	if len(b.Auth) > 0 {
@@ -426,27 +571,71 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 		opts = append(opts, apko_build.WithAuthenticator(auth.MultiAuthenticator(auths...)))
 		log.Infof("auth configured for: %v", maps.Keys(b.Auth))
 	}
+	return opts
+}
 
-	configs, warn, err := apko_build.LockImageConfiguration(ctx, imgConfig, opts...)
+// buildGuestLayersLocal builds layers locally using the apko library.
+func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_build.ReleaseData, func(), error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("melange").Start(ctx, "buildGuestLayersLocal")
+	defer span.End()
+
+	tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
 	if err != nil {
-		cleanup()
-		return nil, nil, nil, fmt.Errorf("unable to lock image configuration: %w", err)
+		return nil, nil, nil, fmt.Errorf("creating apko tempdir: %w", err)
 	}
+	cleanup := func() { os.RemoveAll(tmp) }
 
-	for k, v := range warn {
-		log.Warnf("Unable to lock package %s: %s", k, v)
+	imgConfig := b.guestImageConfiguration(ctx)
+
+	// Set the layer budget based on MaxLayers configuration
+	// Default to 50 if not set
+	maxLayers := b.MaxLayers
+	if maxLayers == 0 {
+		maxLayers = 50
 	}
+	// Use "origin" strategy which partitions packages by their origin
+	// This groups related packages together for better cache efficiency
+	imgConfig.Layering = &apko_types.Layering{
+		Strategy: "origin",
+		Budget:   maxLayers,
+	}
+	log.Infof("using layer budget of %d with origin strategy", maxLayers)
 
-	locked, ok := configs["index"]
-	if !ok {
+	opts := b.guestApkoOptions(ctx, imgConfig, tmp)
+	if b.ApkoRegistryAttachSBOM {
+		opts = append(opts,
+			apko_build.WithSBOM(tmp),
+			apko_build.WithSBOMFormats([]string{"spdx"}),
+		)
+	}
+
+	// Keep the apk cache from being evicted while apko fills it.
+	release, err := cache.Acquire(b.ApkCacheDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	defer release()
+
+	locked, warn, err := b.lockEnvironment(ctx, imgConfig, opts...)
+	if err != nil {
 		cleanup()
-		return nil, nil, nil, errors.New("missing locked config")
+		return nil, nil, nil, err
+	}
+
+	for k, v := range warn {
+		log.Warnf("Unable to lock package %s: %s", k, v)
 	}
 
 	// Preserve the layering configuration in the locked config
 	locked.Layering = imgConfig.Layering
 	b.Configuration.Environment = *locked
 	opts = append(opts, apko_build.WithImageConfiguration(*locked))
+	if b.Lockfile != "" {
+		log.Infof("installing build environment from lockfile %s", b.Lockfile)
+		opts = append(opts, apko_build.WithLockFile(b.Lockfile))
+	}
 
 	guestFS := tarfs.New()
 	bc, err := apko_build.New(ctx, guestFS, opts...)
@@ -456,13 +645,22 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 	}
 
 	// Get the APK associated with our build, and then get a Resolver
-	namedIndexes, err := bc.APK().GetRepositoryIndexes(ctx, false)
-	if err != nil {
+	var namedIndexes []apk.NamedIndex
+	if err := retryResolution(ctx, "unable to obtain repository indexes", func() (err error) {
+		namedIndexes, err = bc.APK().GetRepositoryIndexes(ctx, false)
+		return err
+	}); err != nil {
 		cleanup()
-		return nil, nil, nil, fmt.Errorf("unable to obtain repository indexes: %w", err)
+		return nil, nil, nil, err
 	}
 	b.PkgResolver = apk.NewPkgResolver(ctx, namedIndexes)
 
+	if b.ApkoRegistryAttachSBOM {
+		b.guestSBOM = func(ctx context.Context, img v1.Image) ([]byte, ggcr_types.MediaType, error) {
+			return guestImageSBOM(ctx, bc, b.Arch, img)
+		}
+	}
+
 	bc.Summarize(ctx)
 
 	// Use BuildLayers which internally calls buildImage and handles layering
@@ -474,6 +672,24 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 	}
 	log.Infof("apko generated %d layer(s)", len(layers))
 
+	if b.WriteLockfile != "" {
+		installed, err := bc.APK().GetInstalled()
+		if err != nil {
+			cleanup()
+			return nil, nil, nil, fmt.Errorf("getting installed packages: %w", err)
+		}
+		pkgs, err := lockPackages(installed, namedIndexes, b.Arch)
+		if err != nil {
+			cleanup()
+			return nil, nil, nil, fmt.Errorf("locking build environment: %w", err)
+		}
+		if err := writeLockfile(b.WriteLockfile, *locked, b.Arch, pkgs); err != nil {
+			cleanup()
+			return nil, nil, nil, err
+		}
+		log.Infof("wrote %d locked package(s) to %s", len(pkgs), b.WriteLockfile)
+	}
+
 	// Get release data
 	releaseData := &apko_build.ReleaseData{
 		ID:        "unknown",
@@ -485,3 +701,22 @@ func (b *Build) buildGuestLayersLocal(ctx context.Context) ([]v1.Layer, *apko_bu
 
 	return layers, releaseData, cleanup, nil
 }
+
+// guestImageSBOM generates an SPDX SBOM for img, the build environment image
+// built by bc, for attachment to the image in the apko registry.
+func guestImageSBOM(ctx context.Context, bc *apko_build.Context, arch apko_types.Architecture, img v1.Image) ([]byte, ggcr_types.MediaType, error) {
+	sboms, err := bc.GenerateImageSBOM(ctx, arch, img)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, s := range sboms {
+		if s.Format == "spdx" {
+			data, err := os.ReadFile(s.Path)
+			if err != nil {
+				return nil, "", fmt.Errorf("reading SBOM: %w", err)
+			}
+			return data, buildkit.SPDXMediaType, nil
+		}
+	}
+	return nil, "", errors.New("apko did not generate an SPDX SBOM")
+}
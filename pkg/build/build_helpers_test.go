@@ -144,6 +144,84 @@ func TestLoadIgnoreRules(t *testing.T) {
 	})
 }
 
+func TestPopulateWorkspaceIncludeSource(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	srcDir := t.TempDir()
+	for _, f := range []string{
+		"main.c",
+		"Makefile",
+		"patches/fix-build.patch",
+		"patches/README",
+		"docs/notes.patch",
+		"build.tmp.patch",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(srcDir, f)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, f), []byte(f), 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, ".melangeignore"), []byte("*.tmp.*\n"), 0o644))
+
+	populate := func(t *testing.T, empty bool, include ...string) []string {
+		t.Helper()
+		b := &Build{
+			SourceDir:       srcDir,
+			WorkspaceDir:    t.TempDir(),
+			WorkspaceIgnore: ".melangeignore",
+			EmptyWorkspace:  empty,
+			IncludeSource:   include,
+			Configuration:   &config.Configuration{Package: config.Package{Name: "hello"}},
+		}
+		require.NoError(t, b.populateWorkspace(ctx, os.DirFS(srcDir)))
+
+		var files []string
+		require.NoError(t, filepath.WalkDir(b.WorkspaceDir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(b.WorkspaceDir, path)
+			files = append(files, filepath.ToSlash(rel))
+			return err
+		}))
+		return files
+	}
+
+	t.Run("only matching files are copied into an empty workspace", func(t *testing.T) {
+		require.ElementsMatch(t, []string{
+			".melange.gcc.spec",
+			"patches/fix-build.patch",
+		}, populate(t, true, "patches/*.patch"))
+	})
+
+	t.Run("several patterns", func(t *testing.T) {
+		require.ElementsMatch(t, []string{
+			".melange.gcc.spec",
+			"Makefile",
+			"patches/fix-build.patch",
+			"patches/README",
+		}, populate(t, true, "patches/*", "Makefile"))
+	})
+
+	t.Run("ignore rules still apply", func(t *testing.T) {
+		require.ElementsMatch(t, []string{
+			".melange.gcc.spec",
+			"docs/notes.patch",
+			"patches/fix-build.patch",
+		}, populate(t, true, "**/*.patch", "*.patch"))
+	})
+
+	t.Run("patterns are ignored outside an empty workspace", func(t *testing.T) {
+		require.ElementsMatch(t, []string{
+			".melange.gcc.spec",
+			".melangeignore",
+			"main.c",
+			"Makefile",
+			"patches/fix-build.patch",
+			"patches/README",
+			"docs/notes.patch",
+		}, populate(t, false, "patches/*.patch"))
+	})
+}
+
 func TestGetBuildConfigPURL(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -343,3 +421,53 @@ func TestPkgFromSub(t *testing.T) {
 	require.True(t, pkg.Options.NoProvides)
 	require.Equal(t, "echo pre", pkg.Scriptlets.PreInstall)
 }
+
+func TestBuildRepositoriesExcludedFromTests(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "build-repos.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: build-repos
+  version: 1.0.0
+  epoch: 0
+
+environment:
+  contents:
+    build-repositories:
+      - https://example.com/bootstrap
+    repositories:
+      - https://example.com/os
+    packages:
+      - busybox
+
+pipeline:
+  - runs: echo hello
+
+test:
+  pipeline:
+    - runs: echo test
+`), 0o644))
+
+	cfg, err := config.ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+
+	b := &Build{
+		Configuration: cfg,
+		Arch:          apko_types.ParseArchitecture("x86_64"),
+		ExtraRepos:    []string{"https://example.com/extra", "https://example.com/bootstrap"},
+	}
+	buildEnv := b.guestImageConfiguration(ctx)
+	require.Equal(t, []string{"https://example.com/bootstrap", "https://example.com/extra"}, buildEnv.Contents.BuildRepositories)
+	require.Equal(t, []string{"https://example.com/os"}, buildEnv.Contents.Repositories)
+	require.Equal(t, []string{"https://example.com/bootstrap"}, cfg.Environment.Contents.BuildRepositories,
+		"merging --repository-append must not mutate the parsed configuration")
+
+	tb := &TestBuildKit{
+		Config:        &TestConfig{Arch: b.Arch},
+		Configuration: *cfg,
+	}
+	testEnv := tb.testImageConfiguration()
+	require.Empty(t, testEnv.Contents.BuildRepositories)
+	require.NotContains(t, testEnv.Contents.Repositories, "https://example.com/bootstrap")
+}
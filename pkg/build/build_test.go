@@ -15,6 +15,7 @@
 package build
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -22,6 +23,7 @@ import (
 	"time"
 
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/chainguard-dev/clog/slogtest"
@@ -49,7 +51,7 @@ func TestConfiguration_Load(t *testing.T) {
 			expected: &config.Configuration{
 				Package: config.Package{
 					Name:      "hello",
-					Version:   "world",
+					Version:   "1.0.0",
 					Resources: &config.Resources{},
 				},
 				Pipeline: []config.Pipeline{
@@ -384,3 +386,397 @@ func TestSourceDateEpoch(t *testing.T) {
 		})
 	}
 }
+
+func TestTargetArchitectureNegation(t *testing.T) {
+	newBuild := func(t *testing.T, arch string, targets ...string) (*Build, error) {
+		t.Helper()
+		cfg := NewBuildConfig()
+		cfg.ConfigFile = "melange.yaml"
+		cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+		cfg.ConfigFileRepositoryCommit = "deadbeef"
+		cfg.WorkspaceDir = t.TempDir()
+		cfg.Arch = apko_types.ParseArchitecture(arch)
+		cfg.Configuration = &config.Configuration{
+			Package: config.Package{
+				Name:               "negation",
+				Version:            "1.0.0",
+				TargetArchitecture: targets,
+			},
+		}
+		return NewFromConfig(slogtest.Context(t), cfg)
+	}
+
+	t.Run("negated arch is skipped", func(t *testing.T) {
+		_, err := newBuild(t, "riscv64", "!riscv64")
+		require.ErrorIs(t, err, ErrSkipThisArch)
+	})
+
+	t.Run("other archs are built", func(t *testing.T) {
+		for _, arch := range []string{"x86_64", "aarch64"} {
+			b, err := newBuild(t, arch, "!riscv64", "!ppc64le")
+			require.NoError(t, err)
+			require.Equal(t, arch, b.Arch.ToAPK())
+		}
+	})
+
+	t.Run("allowlist still applies", func(t *testing.T) {
+		_, err := newBuild(t, "aarch64", "x86_64")
+		require.ErrorIs(t, err, ErrSkipThisArch)
+
+		_, err = newBuild(t, "x86_64", "x86_64")
+		require.NoError(t, err)
+	})
+
+	t.Run("mixing allow and negate is an error", func(t *testing.T) {
+		_, err := newBuild(t, "x86_64", "x86_64", "!riscv64")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrSkipThisArch)
+		require.Contains(t, err.Error(), "cannot mix allowed and negated")
+	})
+}
+
+func TestCloseKeepWorkspace(t *testing.T) {
+	tests := []struct {
+		name      string
+		remove    bool
+		onSuccess bool
+		onFailure bool
+		succeeded bool
+		wantKept  bool
+	}{
+		{name: "rm removes after success", remove: true, succeeded: true},
+		{name: "rm removes after failure", remove: true},
+		{name: "no rm keeps after success", succeeded: true, wantKept: true},
+		{name: "no rm keeps after failure", wantKept: true},
+		{name: "keep on success after success", remove: true, onSuccess: true, succeeded: true, wantKept: true},
+		{name: "keep on success after failure", remove: true, onSuccess: true},
+		{name: "keep on failure after failure", remove: true, onFailure: true, wantKept: true},
+		{name: "keep on failure after success", remove: true, onFailure: true, succeeded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := slogtest.Context(t)
+			dir := filepath.Join(t.TempDir(), "workspace")
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "melange-out"), 0o755))
+
+			b := &Build{
+				WorkspaceDir:           dir,
+				Remove:                 tt.remove,
+				KeepWorkspaceOnSuccess: tt.onSuccess,
+				KeepWorkspaceOnFailure: tt.onFailure,
+				succeeded:              tt.succeeded,
+			}
+			require.NoError(t, b.Close(ctx))
+
+			_, err := os.Stat(dir)
+			if tt.wantKept {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, os.ErrNotExist)
+			}
+		})
+	}
+}
+
+func TestBuildSummaryEntries(t *testing.T) {
+	b := &Build{
+		Configuration: &config.Configuration{
+			Package:     config.Package{Name: "hello", Version: "1.0.0", Epoch: 2},
+			Subpackages: []config.Subpackage{{Name: "hello-doc"}},
+		},
+		Arch:   apko_types.ParseArchitecture("arm64"),
+		OutDir: "packages",
+		Start:  time.Now(),
+	}
+
+	entries := b.summaryEntries(nil)
+	require.Len(t, entries, 2)
+	require.Equal(t, "aarch64", entries[0].Arch)
+	require.Equal(t, "hello", entries[0].Package)
+	require.Equal(t, "packages/aarch64/hello-1.0.0-r2.apk", entries[0].APK)
+	require.Equal(t, "hello-doc", entries[1].Package)
+	require.Equal(t, "packages/aarch64/hello-doc-1.0.0-r2.apk", entries[1].APK)
+	require.False(t, entries[0].Failed || entries[1].Failed)
+
+	b.APKNameTemplate = "{name}_{version}.apk"
+	require.Equal(t, "packages/aarch64/hello_1.0.0.apk", b.summaryEntries(nil)[0].APK)
+
+	entries = b.summaryEntries(fmt.Errorf("pipeline failed"))
+	require.Equal(t, []SummaryEntry{{Arch: "aarch64", Package: "hello", Failed: true, Duration: entries[0].Duration}}, entries)
+}
+
+func TestBuildWarnings(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+  target-architecture:
+    - all
+
+vars:
+  stale: foo
+
+pipeline:
+  - runs: make
+`), 0o644))
+
+	cfg := NewBuildConfig()
+	cfg.ConfigFile = fp
+	cfg.ConfigFileRepositoryURL = UnknownRepositoryURL
+	cfg.ConfigFileRepositoryCommit = "deadbeef"
+	cfg.WorkspaceDir = t.TempDir()
+	cfg.Arch = apko_types.ParseArchitecture("x86_64")
+	b, err := NewFromConfig(ctx, cfg)
+	require.NoError(t, err)
+
+	want := []Warning{
+		{Kind: config.WarningUnusedVariable, Message: `variable "stale" is declared but never used`},
+		{Kind: config.WarningGit, Message: "git repository URL for build config not provided"},
+		{Kind: config.WarningDeprecated, Message: "target-architecture: ['all'] is deprecated and will become an error; remove this field to build for all available archs"},
+	}
+	require.Equal(t, want, b.Warnings())
+
+	// The summary reports them with the main package.
+	b.Start = time.Now()
+	entries := b.summaryEntries(nil)
+	require.Equal(t, want, entries[0].Warnings)
+
+	var out bytes.Buffer
+	s := NewSummary()
+	s.Add(entries...)
+	require.NoError(t, s.Write(&out))
+	require.Contains(t, out.String(), "x86_64/hello: warning: [deprecated] target-architecture: ['all'] is deprecated")
+
+	// A build from a known repository does not warn about it.
+	cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+	b, err = NewFromConfig(ctx, cfg)
+	require.NoError(t, err)
+	require.NotContains(t, b.Warnings(), want[1])
+}
+
+func TestEnvironmentPackageDedup(t *testing.T) {
+	newBuild := func(t *testing.T, pkgs, extra []string) (*Build, error) {
+		t.Helper()
+		cfg := NewBuildConfig()
+		cfg.ConfigFile = "melange.yaml"
+		cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+		cfg.ConfigFileRepositoryCommit = "deadbeef"
+		cfg.WorkspaceDir = t.TempDir()
+		cfg.Arch = apko_types.ParseArchitecture("x86_64")
+		cfg.ExtraPackages = extra
+		cfg.EnabledBuildOptions = []string{"with-foo"}
+		cfg.Configuration = &config.Configuration{
+			Package: config.Package{Name: "dedup", Version: "1.0.0"},
+			Environment: apko_types.ImageConfiguration{
+				Contents: apko_types.ImageContents{Packages: pkgs},
+			},
+			Options: map[string]config.BuildOption{
+				"with-foo": {
+					Environment: config.EnvironmentOption{
+						Contents: config.ContentsOption{
+							Packages: config.ListOption{Add: []string{"foo=1.2.3-r0"}},
+						},
+					},
+				},
+			},
+		}
+		return NewFromConfig(slogtest.Context(t), cfg)
+	}
+
+	t.Run("identical entries are deduplicated", func(t *testing.T) {
+		b, err := newBuild(t, []string{"busybox", "foo=1.2.3-r0", "busybox"}, []string{"foo=1.2.3-r0", "make", "busybox"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"busybox", "foo=1.2.3-r0"}, b.Configuration.Environment.Contents.Packages)
+		require.Equal(t, []string{"make"}, b.ExtraPackages)
+	})
+
+	t.Run("constraints other than pins are kept", func(t *testing.T) {
+		b, err := newBuild(t, []string{"foo>1", "foo"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"foo>1", "foo", "foo=1.2.3-r0"}, b.Configuration.Environment.Contents.Packages)
+	})
+
+	t.Run("conflicting pins are an error", func(t *testing.T) {
+		_, err := newBuild(t, []string{"busybox"}, []string{"foo=2.0.0-r0"})
+		require.ErrorContains(t, err, "package foo is pinned to conflicting versions: foo=1.2.3-r0 and foo=2.0.0-r0")
+	})
+
+	t.Run("the configuration is left alone", func(t *testing.T) {
+		// Room to append to, as the configuration may be shared with
+		// builds for other architectures.
+		pkgs := make([]string, 2, 3)
+		pkgs[0], pkgs[1] = "busybox", "busybox"
+		b, err := newBuild(t, pkgs, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"busybox", "foo=1.2.3-r0"}, b.Configuration.Environment.Contents.Packages)
+		require.Equal(t, []string{"busybox", "busybox", ""}, pkgs[:3])
+	})
+}
+
+func TestConditionalEnvironmentPackages(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+
+environment:
+  contents:
+    packages:
+      - build-base
+      - name: nasm
+        if: ${{build.arch}} == 'x86_64'
+      - name: neon-headers
+        if: ${{build.arch}} == 'aarch64' || ${{options.simd.enabled}} == 'true'
+
+options:
+  simd: {}
+
+pipeline:
+  - runs: make
+`), 0o644))
+	parsed, err := config.ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+
+	newBuild := func(t *testing.T, arch string, opts ...string) *Build {
+		t.Helper()
+		cfg := NewBuildConfig()
+		cfg.ConfigFile = fp
+		cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+		cfg.ConfigFileRepositoryCommit = "deadbeef"
+		cfg.WorkspaceDir = t.TempDir()
+		cfg.Arch = apko_types.ParseArchitecture(arch)
+		cfg.EnabledBuildOptions = opts
+		// The configuration is shared by the builds of every architecture.
+		cfg.Configuration = parsed
+		b, err := NewFromConfig(ctx, cfg)
+		require.NoError(t, err)
+		return b
+	}
+
+	require.Equal(t, []string{"build-base", "nasm"}, newBuild(t, "x86_64").Configuration.Environment.Contents.Packages)
+	require.Equal(t, []string{"build-base", "neon-headers"}, newBuild(t, "aarch64").Configuration.Environment.Contents.Packages)
+	require.Equal(t, []string{"build-base"}, newBuild(t, "riscv64").Configuration.Environment.Contents.Packages)
+	require.Equal(t, []string{"build-base", "neon-headers"}, newBuild(t, "riscv64", "simd").Configuration.Environment.Contents.Packages)
+
+	// The shared configuration is left as parsed.
+	require.Equal(t, []string{"build-base"}, parsed.Environment.Contents.Packages)
+}
+
+func TestConditionalRuntimeDependencies(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+  dependencies:
+    runtime:
+      - ca-certificates-bundle
+      - name: hello-plugins
+        if: ${{subpackages.hello-plugins.enabled}} == 'true'
+      - name: hello-docs
+        if: ${{subpackages.hello-docs.enabled}} == 'true'
+
+options:
+  plugins: {}
+
+pipeline:
+  - runs: make
+
+subpackages:
+  - name: hello-plugins
+    if: ${{options.plugins.enabled}} == 'true'
+    pipeline:
+      - runs: make plugins
+  - name: hello-docs
+    if: ${{build.arch}} == 'x86_64'
+    dependencies:
+      runtime:
+        - name: man-db
+          if: ${{build.arch}} == 'x86_64'
+        - name: groff
+          if: ${{build.arch}} == 'aarch64'
+    pipeline:
+      - runs: make docs
+`), 0o644))
+	parsed, err := config.ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+
+	newBuild := func(t *testing.T, parsed *config.Configuration, arch string, opts ...string) (*Build, error) {
+		t.Helper()
+		cfg := NewBuildConfig()
+		cfg.ConfigFile = fp
+		cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+		cfg.ConfigFileRepositoryCommit = "deadbeef"
+		cfg.WorkspaceDir = t.TempDir()
+		cfg.Arch = apko_types.ParseArchitecture(arch)
+		cfg.EnabledBuildOptions = opts
+		cfg.Configuration = parsed
+		return NewFromConfig(ctx, cfg)
+	}
+
+	b, err := newBuild(t, parsed, "x86_64", "plugins")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca-certificates-bundle", "hello-plugins", "hello-docs"}, b.Configuration.Package.Dependencies.Runtime)
+	require.Equal(t, []string{"man-db"}, b.Configuration.Subpackages[1].Dependencies.Runtime)
+
+	b, err = newBuild(t, parsed, "aarch64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca-certificates-bundle"}, b.Configuration.Package.Dependencies.Runtime)
+	require.Equal(t, []string{"groff"}, b.Configuration.Subpackages[1].Dependencies.Runtime)
+
+	// The shared configuration is left as parsed.
+	require.Equal(t, []string{"ca-certificates-bundle"}, parsed.Package.Dependencies.Runtime)
+	require.Len(t, parsed.Package.Dependencies.ConditionalRuntime, 2)
+	require.Empty(t, parsed.Subpackages[1].Dependencies.Runtime)
+
+	t.Run("unknown subpackage", func(t *testing.T) {
+		cfg := *parsed
+		cfg.Package.Dependencies.ConditionalRuntime = []config.ConditionalDependency{
+			{Name: "hello-extras", If: "${{subpackages.hello-extras.enabled}} == 'true'"},
+		}
+		_, err := newBuild(t, &cfg, "x86_64")
+		require.ErrorContains(t, err, "hello: conditional dependency hello-extras: variable subpackages.hello-extras.enabled not defined")
+	})
+}
+
+func TestPipelineEnvironmentProxy(t *testing.T) {
+	b := &Build{
+		SourceDateEpoch: time.Unix(1234567890, 0),
+		Configuration: &config.Configuration{
+			Environment: apko_types.ImageConfiguration{
+				Environment: map[string]string{
+					"HTTP_PROXY": "http://config-proxy:3128",
+					"FOO":        "bar",
+				},
+			},
+		},
+		Proxy: melangehttp.ProxyConfig{
+			HTTPProxy:  "http://proxy.example.com:3128",
+			HTTPSProxy: "http://proxy.example.com:3129",
+			NoProxy:    "localhost,.internal",
+		},
+		ExtraEnv: map[string]string{"NO_PROXY": "extra"},
+	}
+
+	// The proxies are set as build secrets, overriding those of the
+	// configuration, but not the extra environment.
+	want := map[string]string{
+		"SOURCE_DATE_EPOCH": "1234567890",
+		"FOO":               "bar",
+		"NO_PROXY":          "extra",
+	}
+	if diff := cmp.Diff(want, b.pipelineEnvironment()); diff != "" {
+		t.Errorf("pipelineEnvironment() mismatch (-want +got):\n%s", diff)
+	}
+}
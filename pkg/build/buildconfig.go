@@ -22,7 +22,9 @@ import (
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
 
+	"github.com/dlorenc/melange2/pkg/buildkit"
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 	"github.com/dlorenc/melange2/pkg/linter"
 )
 
@@ -48,7 +50,9 @@ type BuildConfig struct {
 	// ConfigFileLicense is the SPDX license string for the build configuration file.
 	ConfigFileLicense string
 
-	// SourceDateEpoch is the timestamp used for reproducible builds.
+	// SourceDateEpoch is the timestamp used for reproducible builds, as the
+	// modification time of the files in the packages. Defaults to the Unix
+	// epoch; SOURCE_DATE_EPOCH, when set, takes precedence.
 	SourceDateEpoch time.Time
 
 	// WorkspaceDir is the directory used for the build workspace at /home/build.
@@ -60,6 +64,18 @@ type BuildConfig struct {
 	// PipelineDirs are ordered directories where to find 'uses' pipelines.
 	PipelineDirs []string
 
+	// ErrorOnShadow fails the build when a pipeline of PipelineDirs shadows
+	// a builtin pipeline, rather than warning about it.
+	ErrorOnShadow bool
+
+	// NormalizePipelines collapses trivially nested pipelines before
+	// compiling. This changes the structure of build logs.
+	NormalizePipelines bool
+
+	// StopAfter, if set, ends the build after the pipeline step with this
+	// name. The partial workspace is kept, and no packages are produced.
+	StopAfter string
+
 	// SourceDir is the directory containing source files for the build.
 	SourceDir string
 
@@ -72,15 +88,40 @@ type BuildConfig struct {
 	// Namespace is the namespace used in package URLs in SBOM.
 	Namespace string
 
+	// SBOMExtraPackages are declared in the SBOM of every package in
+	// addition to the configuration's package.sbom.extra-packages.
+	SBOMExtraPackages []config.SBOMExtraPackage
+
+	// SBOMConcurrency bounds how many packages' SBOMs are generated at
+	// once. Zero or less uses the number of CPUs.
+	SBOMConcurrency int
+
 	// GenerateIndex indicates whether to generate APKINDEX.tar.gz.
 	GenerateIndex bool
 
+	// VerifyInstall indicates whether built packages should be installed
+	// against the generated index after the build to check their dependencies.
+	VerifyInstall bool
+
+	// FailOnEmptyPackage indicates whether the build fails if the main
+	// package has no files, unless it is a virtual package.
+	FailOnEmptyPackage bool
+
 	// EmptyWorkspace indicates whether the build workspace should be empty.
 	EmptyWorkspace bool
 
+	// IncludeSource lists patterns, in the syntax of WorkspaceIgnore, of the
+	// files of SourceDir copied into an empty workspace.
+	IncludeSource []string
+
 	// OutDir is the directory where packages will be output.
 	OutDir string
 
+	// APKNameTemplate is the file name template of built packages, with
+	// the placeholders {name}, {version}, {epoch} and {arch}. Empty
+	// means output.DefaultAPKNameTemplate.
+	APKNameTemplate string
+
 	// Arch is the target architecture for the build.
 	Arch apko_types.Architecture
 
@@ -99,18 +140,45 @@ type BuildConfig struct {
 	// DependencyLog is the filename for dependency logging.
 	DependencyLog string
 
+	// DependencyLogFormat is the format of the dependency log: "text" (the
+	// default) or "json".
+	DependencyLogFormat string
+
 	// CreateBuildLog indicates whether to generate a package.log file.
 	CreateBuildLog bool
 
 	// PersistLintResults indicates whether to persist lint results to JSON files.
 	PersistLintResults bool
 
+	// LintReport, if set, collects lint results from every architecture into
+	// a single report. It is shared, not copied, by Clone.
+	LintReport *linter.Report
+
+	// Summary, if set, collects the outcome of the build of every
+	// architecture. It is shared, not copied, by Clone.
+	Summary *Summary
+
 	// CacheDir is the directory used for cached inputs.
 	CacheDir string
 
+	// CacheDirReadOnly treats CacheDir as a shared, immutable cache: the
+	// build works on a private copy and the directory is never created or
+	// written to.
+	CacheDirReadOnly bool
+
 	// ApkCacheDir is the directory used for cached apk packages.
 	ApkCacheDir string
 
+	// Lockfile is an apko lockfile. When set, exactly the packages it
+	// locks for the build architecture are installed as the build
+	// environment, instead of resolving the environment's packages.
+	Lockfile string
+
+	// WriteLockfile is the path of an apko lockfile to which the resolved
+	// build environment is written. Entries for other architectures in an
+	// existing file are kept.
+	WriteLockfile string
+
 	// StripOriginName determines whether origin names should be stripped.
 	StripOriginName bool
 
@@ -120,15 +188,57 @@ type BuildConfig struct {
 	// VarsFile is the variables file for build configuration variables.
 	VarsFile string
 
+	// SinceCommit, in a multi-config build (see BuildConfigs), limits the
+	// build to the configurations changed since this git commit and the
+	// configurations that depend on them.
+	SinceCommit string
+
 	// BuildKitAddr is the BuildKit daemon address.
 	BuildKitAddr string
 
+	// BuildKitDialTimeout bounds how long to wait for the BuildKit daemon
+	// to respond when connecting. Zero selects buildkit.DefaultDialTimeout.
+	BuildKitDialTimeout time.Duration
+
+	// BuildKitWorker selects the daemon's worker to build on, by ID or by
+	// worker filter (see buildkit.WorkerFilter). Empty uses the default worker.
+	BuildKitWorker string
+
+	// CrossEmulation checks before building that the BuildKit daemon
+	// supports the target architecture, natively or under QEMU emulation.
+	CrossEmulation bool
+
+	// ParallelSolve loads the layers of the build environment while the
+	// build graph is constructed, instead of before.
+	ParallelSolve bool
+
+	// Offline fails the build before its environment is resolved if a
+	// repository or key of the environment, a step or a registry is not on
+	// this host, and runs its steps without network access.
+	Offline bool
+
+	// BuildKitClient is an existing BuildKit connection to use instead of
+	// dialing BuildKitAddr. It is not closed when the build finishes.
+	BuildKitClient *buildkit.Client
+
 	// Debug enables debug logging of build pipelines.
 	Debug bool
 
+	// ProgressMode controls how the progress of build steps is displayed.
+	// Empty means buildkit.ProgressModeAuto.
+	ProgressMode buildkit.ProgressMode
+
 	// Remove indicates whether to clean up intermediate artifacts.
 	Remove bool
 
+	// KeepWorkspaceOnSuccess keeps the workspace after a successful build,
+	// even if Remove is set.
+	KeepWorkspaceOnSuccess bool
+
+	// KeepWorkspaceOnFailure keeps the workspace after a failed build, even
+	// if Remove is set.
+	KeepWorkspaceOnFailure bool
+
 	// CacheRegistry is the registry URL for BuildKit cache.
 	CacheRegistry string
 
@@ -141,6 +251,14 @@ type BuildConfig struct {
 	// ApkoRegistryInsecure allows insecure connection to ApkoRegistry.
 	ApkoRegistryInsecure bool
 
+	// ApkoRegistryStrict fails the build when ApkoRegistry is unavailable,
+	// instead of falling back to loading the apko layers locally.
+	ApkoRegistryStrict bool
+
+	// ApkoRegistryAttachSBOM attaches an SBOM of the build environment to
+	// each image pushed to ApkoRegistry, as an OCI referrer.
+	ApkoRegistryAttachSBOM bool
+
 	// ApkoServiceAddr is the gRPC address of the apko service.
 	// When set, apko layer generation is delegated to this remote service.
 	// Example: "apko-server:9090"
@@ -158,6 +276,24 @@ type BuildConfig struct {
 	// IgnoreSignatures indicates whether to ignore repository signature verification.
 	IgnoreSignatures bool
 
+	// KeyringVerify checks that every repository index is signed by a key
+	// in the keyring before the build starts. Skipped with IgnoreSignatures.
+	KeyringVerify bool
+
+	// UserAgent is sent by the fetch pipeline, with apk index and package
+	// downloads, and with requests to ApkoRegistry. Defaults to
+	// "melange/<version>".
+	UserAgent string
+
+	// Proxy configures the proxies of outbound HTTP requests, which are
+	// also set as HTTP_PROXY, HTTPS_PROXY and NO_PROXY in the environment
+	// of pipeline steps.
+	Proxy melangehttp.ProxyConfig
+
+	// FetchTimeout bounds each download of the fetch pipeline that does not
+	// set its own download-timeout. Zero means no limit.
+	FetchTimeout time.Duration
+
 	// EnabledBuildOptions are build options to apply to the configuration.
 	EnabledBuildOptions []string
 
@@ -170,6 +306,14 @@ type BuildConfig struct {
 	// ExportRef is the path or image reference for debug image export.
 	ExportRef string
 
+	// ExportBuildLog embeds the build log in the debug image exported on
+	// failure, at buildkit.DebugImageBuildLogPath.
+	ExportBuildLog bool
+
+	// OCILayout is an OCI image layout directory to also export the output
+	// of the build to.
+	OCILayout string
+
 	// GenerateProvenance indicates whether to generate SLSA provenance.
 	GenerateProvenance bool
 
@@ -181,11 +325,14 @@ type BuildConfig struct {
 // NewBuildConfig creates a new BuildConfig with sensible defaults.
 func NewBuildConfig() *BuildConfig {
 	return &BuildConfig{
-		WorkspaceIgnore: ".melangeignore",
-		OutDir:          ".",
-		CacheDir:        "./melange-cache/",
-		Remove:          true,
-		MaxLayers:       50,
+		WorkspaceIgnore:    ".melangeignore",
+		OutDir:             ".",
+		CacheDir:           "./melange-cache/",
+		Remove:             true,
+		MaxLayers:          50,
+		FailOnEmptyPackage: true,
+		// Default to the Unix epoch for reproducibility.
+		SourceDateEpoch: time.Unix(0, 0).UTC(),
 	}
 }
 
@@ -224,10 +371,18 @@ func (c *BuildConfig) Clone() *BuildConfig {
 		clone.ExtraRepos = make([]string, len(c.ExtraRepos))
 		copy(clone.ExtraRepos, c.ExtraRepos)
 	}
+	if c.IncludeSource != nil {
+		clone.IncludeSource = make([]string, len(c.IncludeSource))
+		copy(clone.IncludeSource, c.IncludeSource)
+	}
 	if c.ExtraPackages != nil {
 		clone.ExtraPackages = make([]string, len(c.ExtraPackages))
 		copy(clone.ExtraPackages, c.ExtraPackages)
 	}
+	if c.SBOMExtraPackages != nil {
+		clone.SBOMExtraPackages = make([]config.SBOMExtraPackage, len(c.SBOMExtraPackages))
+		copy(clone.SBOMExtraPackages, c.SBOMExtraPackages)
+	}
 	if c.LintRequire != nil {
 		clone.LintRequire = make([]string, len(c.LintRequire))
 		copy(clone.LintRequire, c.LintRequire)
@@ -266,6 +421,8 @@ type RemoteBuildParams struct {
 	ApkoRegistry  string
 	ApkoRegistryInsecure bool
 	ApkoServiceAddr string
+	// BuildKitDialTimeout bounds how long to wait for the backend to respond.
+	BuildKitDialTimeout time.Duration
 	// ExtraEnv contains additional environment variables to inject into all pipeline steps.
 	ExtraEnv map[string]string
 }
@@ -291,6 +448,7 @@ func NewBuildConfigForRemote(params RemoteBuildParams) *BuildConfig {
 	cfg.CacheDir = params.CacheDir
 	cfg.ApkCacheDir = params.ApkCacheDir
 	cfg.BuildKitAddr = params.BackendAddr
+	cfg.BuildKitDialTimeout = params.BuildKitDialTimeout
 	cfg.Debug = params.Debug
 	cfg.GenerateIndex = true
 	cfg.IgnoreSignatures = true
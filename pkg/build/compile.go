@@ -18,11 +18,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"maps"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/chainguard-dev/clog"
 	"gopkg.in/yaml.v3"
@@ -30,6 +32,7 @@ import (
 
 	"github.com/dlorenc/melange2/pkg/cond"
 	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/convention"
 	"github.com/dlorenc/melange2/pkg/util"
 )
 
@@ -42,9 +45,20 @@ func (b *Build) Compile(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if b.UserAgent != "" {
+		sm.Substitutions[config.SubstitutionBuildUserAgent] = b.UserAgent
+	}
+	if b.FetchTimeout > 0 {
+		// Whole seconds, rounded up, as the fetch pipeline expects.
+		sm.Substitutions[config.SubstitutionBuildFetchTimeout] = fmt.Sprintf("%ds", (b.FetchTimeout+time.Second-1)/time.Second)
+	}
 
+	// Warn about each shadowing pipeline once for the whole configuration.
+	shadowed := map[string]bool{}
 	c := &Compiled{
-		PipelineDirs: b.PipelineDirs,
+		PipelineDirs:  b.PipelineDirs,
+		ErrorOnShadow: b.ErrorOnShadow,
+		shadowed:      shadowed,
 	}
 
 	if err := c.CompilePipelines(ctx, sm, cfg.Pipeline); err != nil {
@@ -70,7 +84,9 @@ func (b *Build) Compile(ctx context.Context) error {
 		}
 
 		tc := &Compiled{
-			PipelineDirs: b.PipelineDirs,
+			PipelineDirs:  b.PipelineDirs,
+			ErrorOnShadow: b.ErrorOnShadow,
+			shadowed:      shadowed,
 		}
 		if err := tc.CompilePipelines(ctx, sm, sp.Test.Pipeline); err != nil {
 			return fmt.Errorf("compiling subpackage %q tests: %w", sp.Name, err)
@@ -93,7 +109,9 @@ func (b *Build) Compile(ctx context.Context) error {
 
 	if cfg.Test != nil {
 		tc := &Compiled{
-			PipelineDirs: b.PipelineDirs,
+			PipelineDirs:  b.PipelineDirs,
+			ErrorOnShadow: b.ErrorOnShadow,
+			shadowed:      shadowed,
 		}
 
 		if err := tc.CompilePipelines(ctx, sm, cfg.Test.Pipeline); err != nil {
@@ -116,6 +134,14 @@ func (b *Build) Compile(ctx context.Context) error {
 type Compiled struct {
 	PipelineDirs []string
 	Needs        []string
+
+	// ErrorOnShadow fails compilation when a pipeline of PipelineDirs
+	// shadows a builtin pipeline, rather than warning about it.
+	ErrorOnShadow bool
+
+	// shadowed holds the shadowing pipelines already warned about, by
+	// path. Compiled values of one configuration share it.
+	shadowed map[string]bool
 }
 
 func (c *Compiled) CompilePipelines(ctx context.Context, sm *SubstitutionMap, pipelines []config.Pipeline) error {
@@ -139,24 +165,14 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 	// When compiling an already-compiled config, `uses` will be redundant and FYI only,
 	// so ignore it if there is also a `pipelines` spelled out.
 	if uses != "" && len(pipeline.Pipeline) == 0 {
-		var data []byte
-		// Set this to fail up front in case there are no pipeline dirs specified
-		// and we can't find them.
-		err := fmt.Errorf("could not find 'uses' pipeline %q", uses)
-
-		for _, pd := range c.PipelineDirs {
-			log.Debugf("trying to load pipeline %q from %q", uses, pd)
-			data, err = os.ReadFile(filepath.Join(pd, uses+".yaml")) // #nosec G304 - Loading pipeline definition from configured directory
-			if err == nil {
-				log.Debugf("Found pipeline %s", string(data))
-				break
-			}
-		}
+		data, dir, location, err := loadPipeline(uses, c.PipelineDirs)
 		if err != nil {
-			log.Debugf("trying to load pipeline %q from embedded fs pipelines/%q.yaml", uses, uses)
-			data, err = PipelinesFS.ReadFile("pipelines/" + uses + ".yaml")
-			if err != nil {
-				return fmt.Errorf("unable to load pipeline: %w", err)
+			return fmt.Errorf("unable to load pipeline: %w", err)
+		}
+		log.Debugf("loaded pipeline %q from %s", uses, location)
+		if dir != "" {
+			if err := c.checkShadow(ctx, uses, dir, location); err != nil {
+				return err
 			}
 		}
 
@@ -174,13 +190,37 @@ func (c *Compiled) compilePipeline(ctx context.Context, sm *SubstitutionMap, pip
 		pipeline.Name = name
 	}
 
+	// Ranges are expanded from the data of the configuration when it is
+	// parsed, so one left here is in a pipeline definition.
+	if pipeline.Range != "" {
+		return fmt.Errorf("range %q is only supported in build configurations", pipeline.Range)
+	}
+
 	if parent != nil {
+		// Values passed to a nested pipeline may refer to the inputs of the
+		// pipeline invoking it, so resolve them from the parent before its
+		// inputs are shadowed by the nested pipeline's own.
+		for k, v := range with {
+			resolved, err := util.MutateStringFromMap(parent, v)
+			if err != nil {
+				return fmt.Errorf("mutating with %q: %w", k, err)
+			}
+			with[k] = resolved
+		}
+
 		m := maps.Clone(parent)
+		for k := range pipeline.Inputs {
+			delete(m, fmt.Sprintf("${{inputs.%s}}", k))
+		}
 		maps.Copy(m, with)
 		with = m
 	}
 
-	validated, err := validateWith(with, pipeline.Inputs)
+	id := uses
+	if id == "" {
+		id = identity(pipeline)
+	}
+	validated, err := validateWith(id, with, pipeline.Inputs)
 	if err != nil {
 		return fmt.Errorf("unable to validate with: %w", err)
 	}
@@ -271,6 +311,71 @@ func identity(p *config.Pipeline) string {
 	return unidentifiablePipeline
 }
 
+// checkShadow reports a pipeline loaded from path, in the pipeline directory
+// pd, that shadows the builtin pipeline of the same name, naming both: as a
+// warning, once, or as an error if ErrorOnShadow is set.
+func (c *Compiled) checkShadow(ctx context.Context, uses, pd, path string) error {
+	if filepath.Clean(pd) == convention.BuiltinPipelineDir {
+		return nil
+	}
+	builtin := builtinPipeline(uses)
+	if builtin == "" {
+		return nil
+	}
+
+	if c.ErrorOnShadow {
+		return fmt.Errorf("pipeline %q at %s shadows the builtin pipeline %s", uses, path, builtin)
+	}
+	if c.shadowed == nil {
+		c.shadowed = map[string]bool{}
+	}
+	if c.shadowed[path] {
+		return nil
+	}
+	c.shadowed[path] = true
+	clog.FromContext(ctx).Warnf("pipeline %q at %s shadows the builtin pipeline %s", uses, path, builtin)
+	return nil
+}
+
+// LoadPipeline returns the definition of the pipeline named uses, looked up
+// as a `uses` step looks it up: in the first of dirs that has it, or else
+// among the pipelines embedded in melange. It also returns where the
+// definition was found.
+func LoadPipeline(uses string, dirs ...string) ([]byte, string, error) {
+	data, _, location, err := loadPipeline(uses, dirs)
+	return data, location, err
+}
+
+// loadPipeline is LoadPipeline, also returning the directory of dirs the
+// definition was found in, or "" if it is embedded in melange.
+func loadPipeline(uses string, dirs []string) (data []byte, dir, location string, err error) {
+	for _, pd := range dirs {
+		path := filepath.Join(pd, uses+".yaml")
+		data, err := os.ReadFile(path) // #nosec G304 - Loading pipeline definition from configured directory
+		if err == nil {
+			return data, pd, path, nil
+		}
+	}
+	data, err = PipelinesFS.ReadFile("pipelines/" + uses + ".yaml")
+	if err != nil {
+		return nil, "", "", fmt.Errorf("could not find 'uses' pipeline %q", uses)
+	}
+	return data, "", "pipelines/" + uses + ".yaml embedded in melange", nil
+}
+
+// builtinPipeline returns the location of the builtin pipeline named uses,
+// or "" if there is none.
+func builtinPipeline(uses string) string {
+	path := filepath.Join(convention.BuiltinPipelineDir, uses+".yaml")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if _, err := fs.Stat(PipelinesFS, "pipelines/"+uses+".yaml"); err == nil {
+		return "pipelines/" + uses + ".yaml embedded in melange"
+	}
+	return ""
+}
+
 func (c *Compiled) gatherDeps(ctx context.Context, pipeline *config.Pipeline) error {
 	log := clog.FromContext(ctx)
 
@@ -15,9 +15,19 @@
 package build
 
 import (
+	"bytes"
 	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/chainguard-dev/clog"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
 	"github.com/dlorenc/melange2/pkg/config"
 )
 
@@ -61,6 +71,45 @@ func TestInheritWorkdir(t *testing.T) {
 	}
 }
 
+func TestCompileUsesInputs(t *testing.T) {
+	pipelineDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "greet.yaml"), []byte(`
+name: greet
+inputs:
+  who:
+    required: true
+  greeting:
+    default: hello
+runs: echo ${{inputs.greeting}} ${{inputs.who}}
+`), 0o644))
+
+	compile := func(with map[string]string) (*Build, error) {
+		b := &Build{
+			PipelineDirs: []string{pipelineDir},
+			Configuration: &config.Configuration{
+				Pipeline: []config.Pipeline{{Uses: "greet", With: with}},
+			},
+		}
+		return b, b.Compile(context.Background())
+	}
+
+	t.Run("missing required input", func(t *testing.T) {
+		_, err := compile(map[string]string{"greeting": "hi"})
+		require.ErrorContains(t, err, `pipeline "greet" missing required input "who"`)
+	})
+
+	t.Run("optional input is defaulted", func(t *testing.T) {
+		b, err := compile(map[string]string{"who": "world"})
+		require.NoError(t, err)
+		require.Equal(t, "echo hello world\n", b.Configuration.Pipeline[0].Runs)
+	})
+
+	t.Run("all inputs specified", func(t *testing.T) {
+		b, err := compile(map[string]string{"who": "world", "greeting": "goodbye"})
+		require.NoError(t, err)
+		require.Equal(t, "echo goodbye world\n", b.Configuration.Pipeline[0].Runs)
+	})
+}
 
 func TestIdentity(t *testing.T) {
 	tests := []struct {
@@ -279,3 +328,244 @@ func Test_stripComments(t *testing.T) {
 		t.Errorf("want:\n%s\ngot:\n%s", wantErr, err)
 	}
 }
+
+func TestCompileNestedUsesInputs(t *testing.T) {
+	pipelineDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "greet.yaml"), []byte(`
+name: greet
+inputs:
+  who:
+    required: true
+  greeting:
+    default: hello
+runs: echo ${{inputs.greeting}} ${{inputs.who}}
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "welcome.yaml"), []byte(`
+name: welcome
+inputs:
+  name:
+    required: true
+  greeting:
+    default: welcome
+  checksum:
+    default: 0000000000000000000000000000000000000000000000000000000000000000
+pipeline:
+  - uses: greet
+    with:
+      who: ${{inputs.name}}
+      greeting: ${{inputs.greeting}}
+  - uses: verify
+    with:
+      expected-sha256: ${{inputs.checksum}}
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "verify.yaml"), []byte(`
+name: verify
+inputs:
+  expected-sha256:
+    required: true
+runs: echo ${{inputs.expected-sha256}}
+`), 0o644))
+
+	compile := func(with map[string]string) (*Build, error) {
+		b := &Build{
+			PipelineDirs: []string{pipelineDir},
+			Configuration: &config.Configuration{
+				Pipeline: []config.Pipeline{{Uses: "welcome", With: with}},
+			},
+		}
+		return b, b.Compile(context.Background())
+	}
+
+	t.Run("parent input defaults flow into child", func(t *testing.T) {
+		// Both pipelines define a greeting input with different defaults;
+		// the child must get the parent's every time.
+		for range 10 {
+			b, err := compile(map[string]string{"name": "world"})
+			require.NoError(t, err)
+			require.Equal(t, "echo welcome world\n", b.Configuration.Pipeline[0].Pipeline[0].Runs)
+		}
+	})
+
+	t.Run("parent inputs flow into child", func(t *testing.T) {
+		sha := strings.Repeat("ab", 32)
+		b, err := compile(map[string]string{"name": "world", "greeting": "hi", "checksum": sha})
+		require.NoError(t, err)
+		require.Equal(t, "echo hi world\n", b.Configuration.Pipeline[0].Pipeline[0].Runs)
+		require.Equal(t, "echo "+sha+"\n", b.Configuration.Pipeline[0].Pipeline[1].Runs)
+	})
+
+	t.Run("resolved values are validated", func(t *testing.T) {
+		_, err := compile(map[string]string{"name": "world", "checksum": "not-a-sha"})
+		require.ErrorContains(t, err, `checksum input "expected-sha256" for pipeline, invalid length`)
+	})
+}
+
+func TestCompileRangedFetch(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+data:
+  - name: tarballs
+    items:
+      hello: https://example.com/hello-${{package.version}}.tar.gz
+      hello-data: https://example.com/hello-data-${{package.version}}.tar.gz
+      hello-docs: https://example.com/hello-docs-${{package.version}}.tar.gz
+pipeline:
+  - range: tarballs
+    uses: fetch
+    with:
+      uri: ${{range.value}}
+      directory: ${{range.key}}
+      expected-none: true
+  - runs: make
+`), 0o644))
+	cfg, err := config.ParseConfiguration(ctx, fp)
+	require.NoError(t, err)
+
+	b := &Build{Configuration: cfg}
+	require.NoError(t, b.Compile(ctx))
+
+	// Each item is fetched by a step of its own, in key order, followed by
+	// the rest of the pipeline.
+	require.Len(t, b.Configuration.Pipeline, 4)
+	var downloads []string
+	for _, p := range b.Configuration.Pipeline[:3] {
+		require.Equal(t, "fetch", p.Uses)
+		for _, step := range p.Pipeline {
+			for line := range strings.Lines(step.Runs) {
+				if fields := strings.Fields(line); slices.Contains(fields, "wget") {
+					downloads = append(downloads, fields[len(fields)-1])
+				}
+			}
+		}
+	}
+	require.Equal(t, []string{
+		"'https://example.com/hello-1.0.0.tar.gz'",
+		"'https://example.com/hello-data-1.0.0.tar.gz'",
+		"'https://example.com/hello-docs-1.0.0.tar.gz'",
+	}, downloads)
+	require.Equal(t, "make\n", b.Configuration.Pipeline[3].Runs)
+}
+
+func TestCompileRangeInPipelineDefinition(t *testing.T) {
+	pipelineDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "loop.yaml"), []byte(`
+name: loop
+range: items
+runs: echo ${{range.value}}
+`), 0o644))
+
+	b := &Build{
+		PipelineDirs: []string{pipelineDir},
+		Configuration: &config.Configuration{
+			Pipeline: []config.Pipeline{{Uses: "loop"}},
+		},
+	}
+	require.ErrorContains(t, b.Compile(context.Background()), `range "items" is only supported in build configurations`)
+}
+
+func TestCompileFetchUserAgent(t *testing.T) {
+	wgetUserAgent := func(t *testing.T, userAgent string) string {
+		b := &Build{
+			UserAgent: userAgent,
+			Configuration: &config.Configuration{
+				Package: config.Package{Name: "hello", Version: "1.0.0"},
+				Pipeline: []config.Pipeline{{
+					Uses: "fetch",
+					With: map[string]string{
+						"uri":           "https://example.com/hello-1.0.0.tar.gz",
+						"expected-none": "true",
+					},
+				}},
+			},
+		}
+		require.NoError(t, b.Compile(context.Background()))
+
+		for _, step := range b.Configuration.Pipeline[0].Pipeline {
+			for line := range strings.Lines(step.Runs) {
+				for _, field := range strings.Fields(line) {
+					if ua, ok := strings.CutPrefix(field, "'--user-agent="); ok {
+						return strings.TrimSuffix(ua, "'")
+					}
+				}
+			}
+		}
+		t.Fatal("fetch does not set a User-Agent")
+		return ""
+	}
+
+	t.Run("configured", func(t *testing.T) {
+		require.Equal(t, "mirror-friendly/2.0", wgetUserAgent(t, "mirror-friendly/2.0"))
+	})
+	t.Run("default", func(t *testing.T) {
+		require.Regexp(t, `^melange/`, wgetUserAgent(t, ""))
+	})
+}
+
+func TestCompileShadowedBuiltinPipeline(t *testing.T) {
+	pipelineDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "fetch.yaml"), []byte(`
+name: Fetch from our mirror
+pipeline:
+  - runs: echo fetching
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(pipelineDir, "our-setup.yaml"), []byte(`
+name: Set up
+pipeline:
+  - runs: echo setting up
+`), 0o644))
+
+	compile := func(t *testing.T, errorOnShadow bool, uses string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		ctx := clog.WithLogger(context.Background(), clog.New(slog.NewTextHandler(&out, nil)))
+		b := &Build{
+			PipelineDirs:  []string{pipelineDir},
+			ErrorOnShadow: errorOnShadow,
+			Configuration: &config.Configuration{
+				Package:  config.Package{Name: "hello", Version: "1.0.0"},
+				Pipeline: []config.Pipeline{{Uses: uses}},
+			},
+		}
+		err := b.Compile(ctx)
+		return out.String(), err
+	}
+
+	t.Run("warns", func(t *testing.T) {
+		logs, err := compile(t, false, "fetch")
+		require.NoError(t, err)
+		require.Contains(t, logs, "level=WARN")
+		require.Contains(t, logs, filepath.Join(pipelineDir, "fetch.yaml")+" shadows the builtin pipeline pipelines/fetch.yaml embedded in melange")
+	})
+
+	t.Run("warns once", func(t *testing.T) {
+		var out bytes.Buffer
+		ctx := clog.WithLogger(context.Background(), clog.New(slog.NewTextHandler(&out, nil)))
+		b := &Build{
+			PipelineDirs: []string{pipelineDir},
+			Configuration: &config.Configuration{
+				Package:  config.Package{Name: "hello", Version: "1.0.0"},
+				Pipeline: []config.Pipeline{{Uses: "fetch"}, {Uses: "fetch"}},
+				Test:     &config.Test{Pipeline: []config.Pipeline{{Uses: "fetch"}}},
+			},
+		}
+		require.NoError(t, b.Compile(ctx))
+		require.Equal(t, 1, strings.Count(out.String(), "shadows the builtin pipeline"))
+	})
+
+	t.Run("error on shadow", func(t *testing.T) {
+		_, err := compile(t, true, "fetch")
+		require.ErrorContains(t, err, `pipeline "fetch" at `+filepath.Join(pipelineDir, "fetch.yaml")+" shadows the builtin pipeline")
+	})
+
+	t.Run("other pipelines", func(t *testing.T) {
+		logs, err := compile(t, true, "our-setup")
+		require.NoError(t, err)
+		require.NotContains(t, logs, "shadows")
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+const (
+	// DependencyLogFormatText writes the dependencies generated by analysis
+	// as a single JSON object. This is the historical format.
+	DependencyLogFormatText = "text"
+	// DependencyLogFormatJSON writes a JSON array of every resolved
+	// dependency, with its version and where it came from.
+	DependencyLogFormatJSON = "json"
+)
+
+const (
+	// DependencySourceConfig marks a dependency declared in the build configuration.
+	DependencySourceConfig = "config"
+	// DependencySourceAnalysis marks a dependency found by analyzing the package contents.
+	DependencySourceAnalysis = "analysis"
+)
+
+// DependencyLogEntry is a single resolved dependency in a JSON dependency log.
+type DependencyLogEntry struct {
+	// Type is the kind of dependency: "runtime", "provides" or "vendored",
+	// or "build" for a package of the build environment.
+	Type string `json:"type"`
+	// Name is the dependency without any version.
+	Name string `json:"name"`
+	// Constraint is the version operator, e.g. "=" or ">=", if versioned.
+	Constraint string `json:"constraint,omitempty"`
+	// Version is the version the dependency is constrained to, if any.
+	Version string `json:"version,omitempty"`
+	// Source is where the dependency came from: DependencySourceConfig or
+	// DependencySourceAnalysis.
+	Source string `json:"source"`
+	// Note explains why the dependency is there, from the package notes of
+	// the build environment.
+	Note string `json:"note,omitempty"`
+}
+
+// validateDependencyLogFormat checks that format is a known dependency log format.
+func validateDependencyLogFormat(format string) error {
+	switch format {
+	case "", DependencyLogFormatText, DependencyLogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown dependency log format %q (expected %q or %q)", format, DependencyLogFormatText, DependencyLogFormatJSON)
+}
+
+// writeDependencyLog writes the dependency log in the given format. The text
+// format records what analysis generated; the JSON format records the final
+// resolved set, attributing each entry to the configuration or to analysis,
+// followed by the packages of the build environment with their notes.
+func writeDependencyLog(w io.Writer, format string, generated, configured, resolved config.Dependencies, environment []string, notes map[string]string) error {
+	if format != DependencyLogFormatJSON {
+		return json.NewEncoder(w).Encode(&generated)
+	}
+
+	entries := []DependencyLogEntry{}
+	add := func(typ string, deps, declared []string) {
+		for _, dep := range deps {
+			e := DependencyLogEntry{Type: typ, Source: DependencySourceAnalysis}
+			e.Name, e.Constraint, e.Version = splitDependency(dep)
+			if slices.Contains(declared, dep) {
+				e.Source = DependencySourceConfig
+			}
+			entries = append(entries, e)
+		}
+	}
+	add("runtime", resolved.Runtime, configured.Runtime)
+	add("provides", resolved.Provides, configured.Provides)
+	add("vendored", resolved.Vendored, nil)
+	add("build", environment, environment)
+	for i, e := range entries {
+		if e.Type == "build" {
+			entries[i].Note = notes[config.PackageName(e.Name)]
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// splitDependency splits a dependency such as "cmd:foo=1.2-r0" or
+// "so-ver:libfoo.so>=1" into its name, constraint and version.
+func splitDependency(dep string) (name, constraint, version string) {
+	i := strings.IndexAny(dep, "<>=~")
+	if i < 0 {
+		return dep, "", ""
+	}
+	j := i + 1
+	for j < len(dep) && strings.ContainsRune("<>=~", rune(dep[j])) {
+		j++
+	}
+	return dep[:i], dep[i:j], dep[j:]
+}
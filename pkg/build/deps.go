@@ -0,0 +1,109 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apko_build "chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/tarfs"
+
+	"github.com/dlorenc/melange2/pkg/cache"
+)
+
+// EnvironmentPackage is a package of the build environment, at the version
+// resolved for it.
+type EnvironmentPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Repository is the repository the package is installed from.
+	Repository string `json:"repository"`
+}
+
+// ResolveEnvironment resolves the build environment as a build would, from
+// the repositories or the lockfile, and returns its packages sorted by
+// name. Nothing is installed or built.
+func (b *Build) ResolveEnvironment(ctx context.Context) ([]EnvironmentPackage, error) {
+	tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating apko tempdir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	imgConfig := b.guestImageConfiguration(ctx)
+	opts := b.guestApkoOptions(ctx, imgConfig, tmp)
+
+	// Keep the apk cache from being evicted while apko fills it.
+	release, err := cache.Acquire(b.ApkCacheDir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	locked, _, err := b.lockEnvironment(ctx, imgConfig, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := apko_build.New(ctx, tarfs.New(), append(opts, apko_build.WithImageConfiguration(*locked))...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create build context: %w", err)
+	}
+	var namedIndexes []apk.NamedIndex
+	if err := retryResolution(ctx, "unable to obtain repository indexes", func() (err error) {
+		namedIndexes, err = bc.APK().GetRepositoryIndexes(ctx, b.IgnoreSignatures)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return environmentPackages(locked.Contents.Packages, namedIndexes)
+}
+
+// environmentPackages describes the packages of a locked build environment,
+// each pinned as "name=version", with the repository of indexes each is
+// resolved from.
+func environmentPackages(pinned []string, indexes []apk.NamedIndex) ([]EnvironmentPackage, error) {
+	repos := map[string]string{}
+	for _, idx := range indexes {
+		for _, p := range idx.Packages() {
+			// Earlier repositories take precedence, as they do when resolving.
+			key := p.Name + "=" + p.Version
+			if _, ok := repos[key]; !ok {
+				repos[key] = p.Repository().URI
+			}
+		}
+	}
+
+	pkgs := make([]EnvironmentPackage, 0, len(pinned))
+	for _, pin := range pinned {
+		name, version, ok := strings.Cut(pin, "=")
+		if !ok {
+			return nil, fmt.Errorf("package %q of the locked build environment is not pinned", pin)
+		}
+		repo, ok := repos[pin]
+		if !ok {
+			return nil, fmt.Errorf("package %s-%s is not in any repository index", name, version)
+		}
+		pkgs = append(pkgs, EnvironmentPackage{Name: name, Version: version, Repository: repo})
+	}
+	slices.SortFunc(pkgs, func(a, b EnvironmentPackage) int { return strings.Compare(a.Name, b.Name) })
+	return pkgs, nil
+}
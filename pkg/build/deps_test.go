@@ -0,0 +1,102 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// writeDepsIndex writes an unsigned index for arch to dir in which hello
+// 1.0-r0 depends on libhello, of which there are two versions.
+func writeDepsIndex(t *testing.T, dir, arch string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("P:hello\nV:1.0-r0\nA:" + arch + "\nD:libhello\n\n" +
+		"P:libhello\nV:2.0-r0\nA:" + arch + "\n\n" +
+		"P:libhello\nV:2.1-r0\nA:" + arch + "\n\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	index := filepath.Join(dir, arch, "APKINDEX.tar.gz")
+	require.NoError(t, os.MkdirAll(filepath.Dir(index), 0o755))
+	require.NoError(t, os.WriteFile(index, buf.Bytes(), 0o644))
+}
+
+func TestResolveEnvironment(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	root := t.TempDir()
+	writeDepsIndex(t, root, "x86_64")
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer srv.Close()
+
+	amd64 := apko_types.ParseArchitecture("x86_64")
+	b := &Build{
+		Arch:             amd64,
+		IgnoreSignatures: true,
+		ApkCacheDir:      t.TempDir(),
+		Configuration: &config.Configuration{
+			Environment: apko_types.ImageConfiguration{
+				Contents: apko_types.ImageContents{
+					Repositories: []string{srv.URL},
+					Packages:     []string{"hello"},
+				},
+			},
+		},
+	}
+
+	pkgs, err := b.ResolveEnvironment(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []EnvironmentPackage{
+		{Name: "hello", Version: "1.0-r0", Repository: srv.URL + "/x86_64"},
+		{Name: "libhello", Version: "2.1-r0", Repository: srv.URL + "/x86_64"},
+	}, pkgs)
+}
+
+func TestEnvironmentPackages(t *testing.T) {
+	indexes := testIndexes(apko_types.ParseArchitecture("x86_64"))
+
+	pkgs, err := environmentPackages([]string{"glibc=2.40-r1", "busybox=1.36.1-r0"}, indexes)
+	require.NoError(t, err)
+	require.Equal(t, []EnvironmentPackage{
+		{Name: "busybox", Version: "1.36.1-r0", Repository: "https://packages.example.com/os/x86_64"},
+		{Name: "glibc", Version: "2.40-r1", Repository: "https://packages.example.com/os/x86_64"},
+	}, pkgs)
+
+	_, err = environmentPackages([]string{"busybox=1.35.0-r0"}, indexes)
+	require.ErrorContains(t, err, "busybox-1.35.0-r0 is not in any repository index")
+
+	_, err = environmentPackages([]string{"busybox"}, indexes)
+	require.ErrorContains(t, err, "is not pinned")
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+
+	"github.com/dlorenc/melange2/pkg/sign"
+)
+
+// verifyKeyring checks, before any packages are resolved, that the APKINDEX
+// of every configured repository is signed by a key in the configured
+// keyring. Each repository that fails is reported with the keys it was
+// signed by and the keys that were expected.
+func (b *Build) verifyKeyring(ctx context.Context) error {
+	log := clog.FromContext(ctx)
+
+	imgConfig := b.guestImageConfiguration(ctx)
+
+	var repos []string
+	for _, repo := range append(imgConfig.Contents.Repositories, imgConfig.Contents.BuildRepositories...) {
+		// Tagged repositories are written as "@tag url".
+		if strings.HasPrefix(repo, "@") {
+			if _, r, ok := strings.Cut(repo, " "); ok {
+				repo = strings.TrimSpace(r)
+			}
+		}
+		if !slices.Contains(repos, repo) {
+			repos = append(repos, repo)
+		}
+	}
+	if len(repos) == 0 {
+		return nil
+	}
+
+	keys := map[string][]byte{}
+	for _, k := range append(imgConfig.Contents.Keyring, b.ExtraKeys...) {
+		name := path.Base(k)
+		if _, ok := keys[name]; ok {
+			continue
+		}
+		data, err := b.fetchKeyringFile(ctx, k)
+		if err != nil {
+			return fmt.Errorf("loading keyring entry %s: %w", k, err)
+		}
+		keys[name] = data
+	}
+
+	arch := b.Arch.ToAPK()
+	var errs []error
+	for _, repo := range repos {
+		index := strings.TrimSuffix(repo, "/") + "/" + arch + "/APKINDEX.tar.gz"
+		data, err := b.fetchKeyringFile(ctx, index)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("repository %s: fetching %s: %w", repo, index, err))
+			continue
+		}
+		if err := sign.VerifyIndex(data, keys); err != nil {
+			errs = append(errs, fmt.Errorf("repository %s: %w", repo, err))
+			continue
+		}
+		log.Infof("verified signature of %s", index)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("keyring verification failed:\n%w", err)
+	}
+	return nil
+}
+
+// Limits on fetching a key or index, variables so that tests can lower them.
+var (
+	// keyringFetchTimeout bounds the time to fetch one file.
+	keyringFetchTimeout = 2 * time.Minute
+	// maxKeyringFileSize is the largest file fetched, comfortably above
+	// the size of the APKINDEX of a large repository.
+	maxKeyringFileSize int64 = 256 << 20
+)
+
+// fetchKeyringFile reads a key or index from a URL or a local path, applying
+// any configured repository authentication.
+func (b *Build) fetchKeyringFile(ctx context.Context, loc string) ([]byte, error) {
+	u, err := url.Parse(loc)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return os.ReadFile(strings.TrimPrefix(loc, "file://")) // #nosec G304 - User-specified repository or key
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := b.Auth[u.Hostname()]; ok {
+		req.SetBasicAuth(a.User, a.Pass)
+	}
+
+	client := &http.Client{Timeout: keyringFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeyringFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxKeyringFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxKeyringFileSize)
+	}
+	return data, nil
+}
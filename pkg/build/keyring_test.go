@@ -0,0 +1,166 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/sign"
+)
+
+// writeSignedIndex writes an APKINDEX.tar.gz under dir/<arch>/ signed with
+// the private key at keyPath.
+func writeSignedIndex(t *testing.T, dir, arch, keyPath string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("P:hello\nV:1.0-r0\n\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	index := filepath.Join(dir, arch, "APKINDEX.tar.gz")
+	require.NoError(t, os.MkdirAll(filepath.Dir(index), 0o755))
+	require.NoError(t, os.WriteFile(index, buf.Bytes(), 0o644))
+	require.NoError(t, sign.SignIndex(slogtest.Context(t), keyPath, index))
+}
+
+// writeKeyPair writes an RSA key pair as dir/name and dir/name.pub.
+func writeKeyPair(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath+".pub", pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pub,
+	}), 0o644))
+	return keyPath
+}
+
+func TestVerifyKeyring(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	root := t.TempDir()
+	goodKeys := t.TempDir()
+	badKeys := t.TempDir()
+
+	// Both keys share a file name, so only the signature itself differs.
+	goodKey := writeKeyPair(t, goodKeys, "melange.rsa")
+	badKey := writeKeyPair(t, badKeys, "melange.rsa")
+
+	writeSignedIndex(t, filepath.Join(root, "good"), "x86_64", goodKey)
+	writeSignedIndex(t, filepath.Join(root, "bad"), "x86_64", badKey)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "keys"), 0o755))
+	pub, err := os.ReadFile(goodKey + ".pub")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "keys", "melange.rsa.pub"), pub, 0o644))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer srv.Close()
+
+	newBuild := func(repos ...string) *Build {
+		return &Build{
+			Arch: apko_types.ParseArchitecture("x86_64"),
+			Configuration: &config.Configuration{
+				Environment: apko_types.ImageConfiguration{
+					Contents: apko_types.ImageContents{
+						Repositories: repos,
+						Keyring:      []string{srv.URL + "/keys/melange.rsa.pub"},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		require.NoError(t, newBuild(srv.URL+"/good").verifyKeyring(ctx))
+	})
+
+	t.Run("local repository", func(t *testing.T) {
+		require.NoError(t, newBuild(filepath.Join(root, "good")).verifyKeyring(ctx))
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		err := newBuild(srv.URL+"/good", srv.URL+"/bad").verifyKeyring(ctx)
+		require.ErrorContains(t, err, "repository "+srv.URL+"/bad: signature by melange.rsa.pub does not verify")
+		require.NotContains(t, err.Error(), "repository "+srv.URL+"/good")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		b := newBuild(srv.URL + "/good")
+		require.NoError(t, os.WriteFile(filepath.Join(root, "keys", "other.rsa.pub"), pub, 0o644))
+		b.Configuration.Environment.Contents.Keyring = []string{srv.URL + "/keys/other.rsa.pub"}
+		require.ErrorContains(t, b.verifyKeyring(ctx), "index is signed by [melange.rsa.pub], but the keyring only contains [other.rsa.pub]")
+	})
+
+	t.Run("missing index", func(t *testing.T) {
+		require.ErrorContains(t, newBuild(srv.URL+"/missing").verifyKeyring(ctx), "unexpected status code 404")
+	})
+
+	t.Run("oversized file", func(t *testing.T) {
+		defer func(max int64) { maxKeyringFileSize = max }(maxKeyringFileSize)
+		maxKeyringFileSize = 16
+		require.ErrorContains(t, newBuild(srv.URL+"/good").verifyKeyring(ctx), "file is larger than 16 bytes")
+	})
+
+	t.Run("slow server", func(t *testing.T) {
+		defer func(timeout time.Duration) { keyringFetchTimeout = timeout }(keyringFetchTimeout)
+		keyringFetchTimeout = 100 * time.Millisecond
+
+		done := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		}))
+		defer slow.Close()
+		defer close(done)
+
+		b := newBuild(srv.URL + "/good")
+		b.Configuration.Environment.Contents.Keyring = []string{slow.URL + "/keys/melange.rsa.pub"}
+		require.ErrorContains(t, b.verifyKeyring(ctx), "Client.Timeout exceeded")
+	})
+}
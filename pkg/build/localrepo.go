@@ -0,0 +1,95 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog"
+)
+
+// resolveLocalRepositories returns repos, as given to --repository-append,
+// with the local repositories among them resolved with
+// resolveLocalRepository. A local repository without an APKINDEX for arch,
+// such as the output directory of a series of builds before its first
+// package for arch, is skipped with a warning; one that does not exist is
+// an error.
+func resolveLocalRepositories(ctx context.Context, repos []string, arch apko_types.Architecture) ([]string, error) {
+	if repos == nil {
+		return nil, nil
+	}
+	log := clog.FromContext(ctx)
+	resolved := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		r, err := resolveLocalRepository(repo, arch)
+		if errors.Is(err, errNoIndex) {
+			log.Warnf("skipping repository: %v", err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// errNoIndex is returned by resolveLocalRepository for a local repository
+// without an APKINDEX.
+var errNoIndex = errors.New("no index")
+
+// resolveLocalRepository returns repo as apko takes it. A repository given
+// as a file:// URL or a path, rather than a URL of a remote repository, is
+// local: it becomes an absolute path, the APKINDEX of which apko reads from
+// disk. A local repository that does not exist is an error; one that has no
+// APKINDEX for arch returns an error wrapping errNoIndex. A tag, as in
+// "@local ./packages", is kept.
+func resolveLocalRepository(repo string, arch apko_types.Architecture) (string, error) {
+	tag, uri := "", repo
+	if strings.HasPrefix(repo, "@") {
+		if t, u, ok := strings.Cut(repo, " "); ok {
+			tag, uri = t+" ", strings.TrimSpace(u)
+		}
+	}
+
+	dir, ok := strings.CutPrefix(uri, "file://")
+	if !ok && strings.Contains(uri, "://") {
+		return repo, nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolving repository %s: %w", repo, err)
+	}
+
+	if info, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("repository %s does not exist", repo)
+	} else if err != nil {
+		return "", fmt.Errorf("repository %s: %w", repo, err)
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("repository %s is not a directory", repo)
+	}
+	index := filepath.Join(dir, arch.ToAPK(), "APKINDEX.tar.gz")
+	if _, err := os.Stat(index); errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("repository %s has %w for %s", repo, errNoIndex, arch.ToAPK())
+	} else if err != nil {
+		return "", fmt.Errorf("repository %s: %w", repo, err)
+	}
+	return tag + dir, nil
+}
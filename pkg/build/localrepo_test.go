@@ -0,0 +1,110 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	apko_build "chainguard.dev/apko/pkg/build"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestResolveLocalRepository(t *testing.T) {
+	amd64 := apko_types.ParseArchitecture("x86_64")
+	root := t.TempDir()
+	writeIndex(t, root, "x86_64")
+	t.Chdir(filepath.Dir(root))
+
+	for _, tc := range []struct {
+		repo, want string
+	}{
+		{"https://packages.wolfi.dev/os", "https://packages.wolfi.dev/os"},
+		{"@wolfi https://packages.wolfi.dev/os", "@wolfi https://packages.wolfi.dev/os"},
+		{root, root},
+		{"file://" + root, root},
+		{"./" + filepath.Base(root), root},
+		{"@local " + filepath.Base(root) + "/", "@local " + root},
+	} {
+		got, err := resolveLocalRepository(tc.repo, amd64)
+		require.NoError(t, err, tc.repo)
+		require.Equal(t, tc.want, got, tc.repo)
+	}
+
+	_, err := resolveLocalRepository(filepath.Join(root, "missing"), amd64)
+	require.ErrorContains(t, err, "does not exist")
+	require.NotErrorIs(t, err, errNoIndex)
+
+	_, err = resolveLocalRepository("file://"+root, apko_types.ParseArchitecture("aarch64"))
+	require.ErrorIs(t, err, errNoIndex)
+	require.ErrorContains(t, err, "has no index for aarch64")
+
+	file := filepath.Join(root, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, err = resolveLocalRepository(file, amd64)
+	require.ErrorContains(t, err, "is not a directory")
+}
+
+func TestResolveLocalRepositoriesSkipsMissingIndex(t *testing.T) {
+	ctx := slogtest.Context(t)
+	amd64 := apko_types.ParseArchitecture("x86_64")
+	root := t.TempDir()
+	writeIndex(t, root, "x86_64")
+	empty := t.TempDir()
+
+	got, err := resolveLocalRepositories(ctx, []string{
+		empty,
+		root,
+		"https://packages.wolfi.dev/os",
+	}, amd64)
+	require.NoError(t, err)
+	require.Equal(t, []string{root, "https://packages.wolfi.dev/os"}, got)
+
+	// A repository that does not exist is likely a typo.
+	_, err = resolveLocalRepositories(ctx, []string{root, filepath.Join(root, "missing")}, amd64)
+	require.ErrorContains(t, err, "does not exist")
+}
+
+func TestLocalRepositoryBuildDependencies(t *testing.T) {
+	ctx := slogtest.Context(t)
+	amd64 := apko_types.ParseArchitecture("x86_64")
+
+	root := t.TempDir()
+	writeIndex(t, root, "x86_64")
+	repos, err := resolveLocalRepositories(ctx, []string{"file://" + root}, amd64)
+	require.NoError(t, err)
+
+	b := &Build{
+		Arch:       amd64,
+		ExtraRepos: repos,
+		Configuration: &config.Configuration{
+			Environment: apko_types.ImageConfiguration{
+				Contents: apko_types.ImageContents{Packages: []string{"hello"}},
+			},
+		},
+	}
+	locked, _, err := b.lockEnvironment(ctx, b.guestImageConfiguration(ctx),
+		apko_build.WithArch(amd64),
+		apko_build.WithExtraBuildRepos(b.ExtraRepos),
+		apko_build.WithIgnoreSignatures(true),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello=1.0-r0"}, locked.Contents.Packages)
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apko_build "chainguard.dev/apko/pkg/build"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+// lockfileMu serializes updates to a lockfile shared by the builds for
+// several architectures.
+var lockfileMu sync.Mutex
+
+// lockEnvironment resolves the build environment to exact package versions.
+// With a lockfile, the versions locked for the build architecture are used
+// as is; otherwise the package closure is resolved from the repositories.
+// Transient repository errors are retried; errors are returned as a
+// *ResolutionError.
+func (b *Build) lockEnvironment(ctx context.Context, imgConfig apko_types.ImageConfiguration, opts ...apko_build.Option) (*apko_types.ImageConfiguration, map[string][]string, error) {
+	key := "index"
+	if b.Lockfile != "" {
+		opts = append(opts, apko_build.WithLockFile(b.Lockfile))
+		key = b.Arch.String()
+	}
+
+	var configs map[string]*apko_types.ImageConfiguration
+	var warn map[string][]string
+	if err := retryResolution(ctx, "unable to lock image configuration", func() (err error) {
+		configs, warn, err = apko_build.LockImageConfiguration(ctx, imgConfig, opts...)
+		return err
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	locked, ok := configs[key]
+	if !ok {
+		if b.Lockfile != "" {
+			return nil, nil, fmt.Errorf("lockfile %s has no packages for %s", b.Lockfile, b.Arch)
+		}
+		return nil, nil, errors.New("missing locked config")
+	}
+	return locked, warn, nil
+}
+
+// lockPackages describes the installed packages as lockfile entries for
+// arch, taking the download URL of each from the repository indexes it was
+// resolved from.
+func lockPackages(installed []*apk.InstalledPackage, indexes []apk.NamedIndex, arch apko_types.Architecture) ([]lock.LockPkg, error) {
+	available := map[string]*apk.RepositoryPackage{}
+	for _, idx := range indexes {
+		for _, p := range idx.Packages() {
+			// Earlier repositories take precedence, as they do when resolving.
+			key := p.Name + "=" + p.Version
+			if _, ok := available[key]; !ok {
+				available[key] = p
+			}
+		}
+	}
+
+	pkgs := make([]lock.LockPkg, 0, len(installed))
+	for _, ip := range installed {
+		p, ok := available[ip.Name+"="+ip.Version]
+		if !ok {
+			return nil, fmt.Errorf("installed package %s-%s is not in any repository index", ip.Name, ip.Version)
+		}
+		pkgs = append(pkgs, lock.LockPkg{
+			Name:         p.Name,
+			URL:          p.URL(),
+			Version:      p.Version,
+			Architecture: arch.ToAPK(),
+			Checksum:     p.ChecksumString(),
+		})
+	}
+	return pkgs, nil
+}
+
+// writeLockfile records pkgs, in installation order, as the packages locked
+// for arch in the lockfile at path, along with the repositories and keys of
+// imgConfig. Entries for other architectures already in the file are kept,
+// so the builds for several architectures can share one lockfile.
+func writeLockfile(path string, imgConfig apko_types.ImageConfiguration, arch apko_types.Architecture, pkgs []lock.LockPkg) error {
+	lockfileMu.Lock()
+	defer lockfileMu.Unlock()
+
+	l := lock.Lock{Version: "v1"}
+	if _, err := os.Stat(path); err == nil {
+		if l, err = lock.FromFile(path); err != nil {
+			return fmt.Errorf("reading lockfile %s: %w", path, err)
+		}
+	}
+
+	apkArch := arch.ToAPK()
+	l.Contents.Packages = slices.DeleteFunc(l.Contents.Packages, func(p lock.LockPkg) bool { return p.Architecture == apkArch })
+	l.Contents.Packages = append(l.Contents.Packages, pkgs...)
+
+	lockRepos := func(existing []lock.LockRepo, repos []string) []lock.LockRepo {
+		existing = slices.DeleteFunc(existing, func(r lock.LockRepo) bool { return r.Architecture == apkArch })
+		for _, repo := range repos {
+			existing = append(existing, lock.LockRepo{
+				Name:         repo,
+				URL:          fmt.Sprintf("%s/%s", repo, apkArch),
+				Architecture: apkArch,
+			})
+		}
+		return existing
+	}
+	l.Contents.Repositories = lockRepos(l.Contents.Repositories, imgConfig.Contents.Repositories)
+	l.Contents.BuildRepositories = lockRepos(l.Contents.BuildRepositories, imgConfig.Contents.BuildRepositories)
+	l.Contents.RuntimeOnlyRepositories = lockRepos(l.Contents.RuntimeOnlyRepositories, imgConfig.Contents.RuntimeOnlyRepositories)
+
+	for _, key := range imgConfig.Contents.Keyring {
+		if !slices.ContainsFunc(l.Contents.Keyrings, func(k lock.LockKeyring) bool { return k.URL == key }) {
+			l.Contents.Keyrings = append(l.Contents.Keyrings, lock.LockKeyring{Name: key, URL: key})
+		}
+	}
+
+	if err := l.SaveToFile(path); err != nil {
+		return fmt.Errorf("writing lockfile %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apko_build "chainguard.dev/apko/pkg/build"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/lock"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// testIndexes returns a repository index for arch holding two versions of
+// busybox and one of glibc.
+func testIndexes(arch apko_types.Architecture) []apk.NamedIndex {
+	repo := apk.Repository{URI: "https://packages.example.com/os/" + arch.ToAPK()}
+	idx := repo.WithIndex(&apk.APKIndex{Packages: []*apk.Package{
+		{Name: "busybox", Version: "1.36.1-r0", Checksum: []byte("busybox-1.36.1")},
+		{Name: "busybox", Version: "1.37.0-r0", Checksum: []byte("busybox-1.37.0")},
+		{Name: "glibc", Version: "2.40-r1", Checksum: []byte("glibc-2.40")},
+	}})
+	return []apk.NamedIndex{apk.NewNamedRepositoryWithIndex("os", idx)}
+}
+
+func TestLockfile(t *testing.T) {
+	ctx := slogtest.Context(t)
+	path := filepath.Join(t.TempDir(), "melange.lock.json")
+	imgConfig := apko_types.ImageConfiguration{
+		Contents: apko_types.ImageContents{
+			Repositories: []string{"https://packages.example.com/os"},
+			Keyring:      []string{"https://packages.example.com/os/key.rsa.pub"},
+			Packages:     []string{"busybox"},
+		},
+	}
+	installed := []*apk.InstalledPackage{
+		{Package: apk.Package{Name: "glibc", Version: "2.40-r1"}},
+		{Package: apk.Package{Name: "busybox", Version: "1.36.1-r0"}},
+	}
+
+	// Write the environments of two architectures, then rewrite one.
+	for _, arch := range []apko_types.Architecture{apko_types.ParseArchitecture("x86_64"), apko_types.ParseArchitecture("aarch64"), apko_types.ParseArchitecture("x86_64")} {
+		pkgs, err := lockPackages(installed, testIndexes(arch), arch)
+		require.NoError(t, err)
+		require.NoError(t, writeLockfile(path, imgConfig, arch, pkgs))
+	}
+
+	l, err := lock.FromFile(path)
+	require.NoError(t, err)
+	require.Len(t, l.Contents.Packages, 4)
+	require.Equal(t, lock.LockPkg{
+		Name:         "glibc",
+		URL:          "https://packages.example.com/os/aarch64/glibc-2.40-r1.apk",
+		Version:      "2.40-r1",
+		Architecture: "aarch64",
+		Checksum:     (&apk.Package{Checksum: []byte("glibc-2.40")}).ChecksumString(),
+	}, l.Contents.Packages[0])
+	require.Len(t, l.Contents.Repositories, 2)
+	require.Len(t, l.Contents.Keyrings, 1)
+
+	amd64 := apko_types.ParseArchitecture("x86_64")
+	// Locking from a lockfile resolves nothing, so the repository may be empty.
+	localRepo := t.TempDir()
+	require.Equal(t, map[string][]string{
+		"amd64": {"glibc=2.40-r1", "busybox=1.36.1-r0"},
+	}, l.Arch2LockedPackages([]apko_types.Architecture{amd64}))
+
+	t.Run("build uses locked versions", func(t *testing.T) {
+		b := &Build{Arch: amd64, Lockfile: path}
+		ic := apko_types.ImageConfiguration{
+			Archs:    []apko_types.Architecture{amd64},
+			Contents: apko_types.ImageContents{Repositories: []string{localRepo}, Packages: []string{"busybox"}},
+		}
+		locked, _, err := b.lockEnvironment(ctx, ic, apko_build.WithArch(amd64))
+		require.NoError(t, err)
+		require.Equal(t, []string{"glibc=2.40-r1", "busybox=1.36.1-r0"}, locked.Contents.Packages)
+	})
+
+	t.Run("architecture not in lockfile", func(t *testing.T) {
+		arch := apko_types.ParseArchitecture("ppc64le")
+		b := &Build{Arch: arch, Lockfile: path}
+		ic := apko_types.ImageConfiguration{
+			Archs:    []apko_types.Architecture{arch},
+			Contents: apko_types.ImageContents{Repositories: []string{localRepo}, Packages: []string{"busybox"}},
+		}
+		_, _, err := b.lockEnvironment(ctx, ic, apko_build.WithArch(arch))
+		require.ErrorContains(t, err, "has no packages for ppc64le")
+	})
+
+	t.Run("package not in index", func(t *testing.T) {
+		_, err := lockPackages([]*apk.InstalledPackage{
+			{Package: apk.Package{Name: "busybox", Version: "1.35.0-r0"}},
+		}, testIndexes(amd64), amd64)
+		require.ErrorContains(t, err, "busybox-1.35.0-r0 is not in any repository index")
+	})
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog"
+
+	"github.com/dlorenc/melange2/pkg/buildkit"
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/service/dag"
+	"github.com/dlorenc/melange2/pkg/util"
+)
+
+// OrderedConfig is a configuration file scheduled as part of a multi-config
+// build.
+type OrderedConfig struct {
+	// ConfigFile is the path to the configuration file.
+	ConfigFile string
+	// Package is the name of the package the configuration builds.
+	Package string
+	// Dependencies are the packages built by other configurations in the
+	// same invocation that this configuration needs in its build environment.
+	Dependencies []string
+}
+
+// ConfigResult is the outcome of one configuration in a multi-config build.
+type ConfigResult struct {
+	ConfigFile string
+	Package    string
+	Duration   time.Duration
+	// Skipped is set when the configuration was not built because one of
+	// its dependencies failed.
+	Skipped bool
+	// Err is set when the build failed or was skipped.
+	Err error
+}
+
+// ExpandConfigPaths returns the configuration files named by paths. A
+// directory is replaced by the YAML files directly inside it, in lexical
+// order.
+func ExpandConfigPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			files = append(files, filepath.Join(p, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// OrderConfigs parses the configurations at paths and returns them in build
+// order: each configuration comes after the configurations that produce the
+// packages in its build environment, including subpackages and provides.
+// Packages not built by any of the configurations are ignored.
+func OrderConfigs(ctx context.Context, paths []string, opts ...config.ConfigurationParsingOption) ([]OrderedConfig, error) {
+	type parsed struct {
+		path string
+		cfg  *config.Configuration
+	}
+
+	var configs []parsed
+	// providers maps every name a configuration can satisfy to its package.
+	providers := map[string]string{}
+	for _, p := range paths {
+		cfg, err := config.ParseConfiguration(ctx, p, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", p, err)
+		}
+		configs = append(configs, parsed{path: p, cfg: cfg})
+
+		name := cfg.Package.Name
+		provided := slices.Clone(cfg.Package.Dependencies.Provides)
+		provided = append(provided, name)
+		for _, sp := range cfg.Subpackages {
+			provided = append(provided, sp.Name)
+			provided = append(provided, sp.Dependencies.Provides...)
+		}
+		for _, prov := range provided {
+			prov = packageName(prov)
+			if _, ok := providers[prov]; !ok {
+				providers[prov] = name
+			}
+		}
+	}
+
+	graph := dag.NewGraph()
+	files := map[string]string{}
+	for _, c := range configs {
+		name := c.cfg.Package.Name
+
+		var deps []string
+		// A conditional package is a dependency whichever architecture
+		// it is installed on.
+		pkgs := slices.Clone(c.cfg.Environment.Contents.Packages)
+		for _, p := range c.cfg.ConditionalPackages {
+			pkgs = append(pkgs, p.Name)
+		}
+		for _, pkg := range pkgs {
+			dep, ok := providers[packageName(pkg)]
+			if !ok || dep == name || slices.Contains(deps, dep) {
+				continue
+			}
+			deps = append(deps, dep)
+		}
+
+		if err := graph.AddNode(name, "", deps); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.path, err)
+		}
+		files[name] = c.path
+	}
+
+	sorted, err := graph.TopologicalSort()
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]OrderedConfig, 0, len(sorted))
+	for _, n := range sorted {
+		ordered = append(ordered, OrderedConfig{
+			ConfigFile:   files[n.Name],
+			Package:      n.Name,
+			Dependencies: n.Dependencies,
+		})
+	}
+	return ordered, nil
+}
+
+// packageName strips any version constraint from a package reference, such
+// as "foo>=1.2" or "so:libfoo.so.1=1".
+func packageName(ref string) string {
+	if i := strings.IndexAny(ref, "=<>~"); i >= 0 {
+		return ref[:i]
+	}
+	return ref
+}
+
+// BuildConfigs builds the configurations at paths in dependency order for
+// the given architectures. newConfig returns the BuildConfig for a single
+// configuration file.
+//
+// All builds share one BuildKit connection, dialed using the first
+// configuration's BuildKitAddr. Once a package has been built, its output
+// directory is added as a repository for the builds that follow so that
+// dependents can install it; sign packages with a key (whose public half is
+// added to the keyring) or disable signature verification for this to
+// resolve.
+//
+// With SinceCommit set in the first configuration's BuildConfig, only the
+// configurations whose file or source directory changed since that commit
+// are built, along with the configurations that depend on them.
+//
+// A configuration whose dependencies failed is skipped. The returned error
+// covers failures to plan the build; per-configuration failures are
+// reported in the results.
+func BuildConfigs(ctx context.Context, paths []string, archs []apko_types.Architecture, newConfig func(ctx context.Context, configFile string) (*BuildConfig, error)) ([]ConfigResult, error) {
+	base, err := newConfig(ctx, paths[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var parseOpts []config.ConfigurationParsingOption
+	if base.EnvFile != "" {
+		parseOpts = append(parseOpts, config.WithEnvFileForParsing(base.EnvFile))
+	}
+	if base.VarsFile != "" {
+		parseOpts = append(parseOpts, config.WithVarsFileForParsing(base.VarsFile))
+	}
+	ordered, err := OrderConfigs(ctx, paths, parseOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if base.SinceCommit != "" {
+		ordered, err = sinceCommitConfigs(ctx, ordered, base.SinceCommit, newConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := base.BuildKitClient
+	if client == nil {
+		client, err = buildkit.New(ctx, base.BuildKitAddr, buildkit.WithDialTimeout(base.BuildKitDialTimeout))
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+	}
+
+	var repos, keys []string
+	results := buildInOrder(ctx, ordered, func(ctx context.Context, oc OrderedConfig) error {
+		cfg, err := newConfig(ctx, oc.ConfigFile)
+		if err != nil {
+			return err
+		}
+		cfg.BuildKitClient = client
+		cfg.ExtraRepos = util.AppendMissing(cfg.ExtraRepos, repos)
+		cfg.ExtraKeys = util.AppendMissing(cfg.ExtraKeys, keys)
+
+		if err := RunBuild(ctx, archs, cfg); err != nil {
+			return err
+		}
+
+		if cfg.GenerateIndex {
+			if outDir, err := filepath.Abs(cfg.OutDir); err == nil {
+				repos = util.AppendMissing(repos, []string{outDir})
+			}
+			if cfg.SigningKey != "" {
+				keys = util.AppendMissing(keys, []string{cfg.SigningKey + ".pub"})
+			}
+		}
+		return nil
+	})
+	return results, nil
+}
+
+// buildInOrder calls build for each configuration in order, skipping those
+// whose dependencies did not build successfully.
+func buildInOrder(ctx context.Context, ordered []OrderedConfig, build func(context.Context, OrderedConfig) error) []ConfigResult {
+	log := clog.FromContext(ctx)
+
+	failed := map[string]bool{}
+	results := make([]ConfigResult, 0, len(ordered))
+	for i, oc := range ordered {
+		result := ConfigResult{ConfigFile: oc.ConfigFile, Package: oc.Package}
+
+		if dep := slices.IndexFunc(oc.Dependencies, func(d string) bool { return failed[d] }); dep >= 0 {
+			log.Warnf("[%d/%d] skipping %s: dependency %s did not build", i+1, len(ordered), oc.Package, oc.Dependencies[dep])
+			result.Skipped = true
+			result.Err = fmt.Errorf("dependency %s did not build", oc.Dependencies[dep])
+			failed[oc.Package] = true
+			results = append(results, result)
+			continue
+		}
+
+		log.Infof("[%d/%d] building %s from %s", i+1, len(ordered), oc.Package, oc.ConfigFile)
+		start := time.Now()
+		result.Err = build(clog.WithLogger(ctx, log.With("package", oc.Package)), oc)
+		result.Duration = time.Since(start)
+		if result.Err != nil {
+			failed[oc.Package] = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
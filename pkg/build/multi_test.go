@@ -0,0 +1,271 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMultiConfig(t *testing.T, dir, file, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, file)
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	return p
+}
+
+func TestOrderConfigs(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+
+	// app sorts first but depends on lib through a subpackage, and tool
+	// depends on lib through a provides with a version constraint.
+	writeMultiConfig(t, dir, "app.yaml", `
+package:
+  name: app
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - busybox
+      - lib-dev
+      - tool
+pipeline:
+  - runs: echo app
+`)
+	writeMultiConfig(t, dir, "lib.yaml", `
+package:
+  name: lib
+  version: 1.0.0
+  epoch: 0
+pipeline:
+  - runs: echo lib
+subpackages:
+  - name: ${{package.name}}-dev
+    pipeline:
+      - runs: echo dev
+    dependencies:
+      provides:
+        - libfoo=1.0.0
+`)
+	writeMultiConfig(t, dir, "tool.yaml", `
+package:
+  name: tool
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - libfoo>=1.0
+pipeline:
+  - runs: echo tool
+`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a config"), 0o644))
+
+	paths, err := ExpandConfigPaths([]string{dir})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "app.yaml"),
+		filepath.Join(dir, "lib.yaml"),
+		filepath.Join(dir, "tool.yaml"),
+	}, paths)
+
+	ordered, err := OrderConfigs(ctx, paths)
+	require.NoError(t, err)
+	require.Equal(t, []OrderedConfig{
+		{ConfigFile: filepath.Join(dir, "lib.yaml"), Package: "lib"},
+		{ConfigFile: filepath.Join(dir, "tool.yaml"), Package: "tool", Dependencies: []string{"lib"}},
+		{ConfigFile: filepath.Join(dir, "app.yaml"), Package: "app", Dependencies: []string{"lib", "tool"}},
+	}, ordered)
+
+	t.Run("cycle", func(t *testing.T) {
+		dir := t.TempDir()
+		a := writeMultiConfig(t, dir, "a.yaml", `
+package:
+  name: a
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages: [b]
+pipeline:
+  - runs: echo a
+`)
+		b := writeMultiConfig(t, dir, "b.yaml", `
+package:
+  name: b
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages: [a]
+pipeline:
+  - runs: echo b
+`)
+		_, err := OrderConfigs(ctx, []string{a, b})
+		require.ErrorContains(t, err, "cycle detected")
+	})
+
+	t.Run("duplicate package", func(t *testing.T) {
+		_, err := OrderConfigs(ctx, []string{paths[1], paths[1]})
+		require.ErrorContains(t, err, "duplicate package: lib")
+	})
+}
+
+func TestBuildInOrder(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	ordered := []OrderedConfig{
+		{ConfigFile: "lib.yaml", Package: "lib"},
+		{ConfigFile: "broken.yaml", Package: "broken"},
+		{ConfigFile: "tool.yaml", Package: "tool", Dependencies: []string{"lib"}},
+		{ConfigFile: "app.yaml", Package: "app", Dependencies: []string{"lib", "broken"}},
+		{ConfigFile: "plugin.yaml", Package: "plugin", Dependencies: []string{"app"}},
+	}
+
+	var built []string
+	results := buildInOrder(ctx, ordered, func(_ context.Context, oc OrderedConfig) error {
+		built = append(built, oc.Package)
+		if oc.Package == "broken" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	require.Equal(t, []string{"lib", "broken", "tool"}, built)
+	require.Len(t, results, len(ordered))
+
+	require.NoError(t, results[0].Err)
+	require.ErrorContains(t, results[1].Err, "boom")
+	require.False(t, results[1].Skipped)
+	require.NoError(t, results[2].Err)
+
+	// Dependents of a failed build are skipped, transitively.
+	require.True(t, results[3].Skipped)
+	require.ErrorContains(t, results[3].Err, "dependency broken did not build")
+	require.True(t, results[4].Skipped)
+	require.ErrorContains(t, results[4].Err, "dependency app did not build")
+}
+
+func TestSinceCommitConfigs(t *testing.T) {
+	ctx := slogtest.Context(t)
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(t *testing.T, msg string) plumbing.Hash {
+		t.Helper()
+		require.NoError(t, wt.AddGlob("."))
+		hash, err := wt.Commit(msg, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+
+	// app depends on lib; tool builds from the source directory "tool".
+	writeMultiConfig(t, dir, "lib.yaml", `
+package:
+  name: lib
+  version: 1.0.0
+  epoch: 0
+pipeline:
+  - runs: echo lib
+`)
+	writeMultiConfig(t, dir, "app.yaml", `
+package:
+  name: app
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages: [lib]
+pipeline:
+  - runs: echo app
+`)
+	writeMultiConfig(t, dir, "tool.yaml", `
+package:
+  name: tool
+  version: 1.0.0
+  epoch: 0
+pipeline:
+  - runs: echo tool
+`)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "tool"), 0o755))
+	writeMultiConfig(t, dir, "tool/main.c", "int main() {}\n")
+	initial := commit(t, "initial")
+
+	paths, err := ExpandConfigPaths([]string{dir})
+	require.NoError(t, err)
+	ordered, err := OrderConfigs(ctx, paths)
+	require.NoError(t, err)
+
+	newConfig := func(_ context.Context, configFile string) (*BuildConfig, error) {
+		cfg := NewBuildConfig()
+		if configFile == filepath.Join(dir, "tool.yaml") {
+			cfg.SourceDir = filepath.Join(dir, "tool")
+		}
+		return cfg, nil
+	}
+	selected := func(t *testing.T) []string {
+		t.Helper()
+		configs, err := sinceCommitConfigs(ctx, ordered, initial.String(), newConfig)
+		require.NoError(t, err)
+		var pkgs []string
+		for _, oc := range configs {
+			pkgs = append(pkgs, oc.Package)
+		}
+		return pkgs
+	}
+
+	t.Run("nothing changed", func(t *testing.T) {
+		require.Empty(t, selected(t))
+	})
+
+	t.Run("changed config and its dependents", func(t *testing.T) {
+		writeMultiConfig(t, dir, "lib.yaml", `
+package:
+  name: lib
+  version: 1.0.1
+  epoch: 0
+pipeline:
+  - runs: echo lib
+`)
+		commit(t, "update lib")
+		require.Equal(t, []string{"lib", "app"}, selected(t))
+	})
+
+	t.Run("untracked file in a source directory", func(t *testing.T) {
+		writeMultiConfig(t, dir, "tool/fix.patch", "--- a\n+++ b\n")
+		require.Equal(t, []string{"lib", "app", "tool"}, selected(t))
+	})
+
+	t.Run("unknown commit", func(t *testing.T) {
+		_, err := sinceCommitConfigs(ctx, ordered, "does-not-exist", newConfig)
+		require.ErrorContains(t, err, "finding changes since does-not-exist")
+	})
+}
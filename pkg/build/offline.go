@@ -0,0 +1,54 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"github.com/dlorenc/melange2/pkg/buildkit"
+)
+
+// checkOffline returns an error for the first part of an offline build that
+// needs network access: a repository or key its environment is resolved
+// from, a registry, or a step. It is checked before the environment is
+// resolved, which would reach those repositories first.
+func (b *Build) checkOffline() error {
+	contents := b.Configuration.Environment.Contents
+	for _, repos := range [][]string{contents.Repositories, contents.BuildRepositories, b.ExtraRepos} {
+		for _, repo := range repos {
+			if err := buildkit.CheckOfflineRepository("repository", repo); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keys := range [][]string{contents.Keyring, b.ExtraKeys} {
+		for _, key := range keys {
+			if err := buildkit.CheckOfflineRepository("key", key); err != nil {
+				return err
+			}
+		}
+	}
+
+	cfg := &buildkit.BuildConfig{
+		Pipelines:   b.Configuration.Pipeline,
+		Subpackages: b.Configuration.Subpackages,
+		CacheDir:    b.CacheDir,
+	}
+	if b.CacheRegistry != "" {
+		cfg.CacheConfig = &buildkit.CacheConfig{Registry: b.CacheRegistry}
+	}
+	if b.ApkoRegistry != "" {
+		cfg.ApkoRegistryConfig = &buildkit.ApkoRegistryConfig{Registry: b.ApkoRegistry}
+	}
+	return buildkit.CheckOffline(cfg)
+}
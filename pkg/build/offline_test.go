@@ -0,0 +1,92 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/buildkit"
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestOfflineBuild(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	run := func(t *testing.T, repos, keys []string, pipelines ...config.Pipeline) error {
+		t.Helper()
+		cfg := NewBuildConfig()
+		cfg.ConfigFile = "melange.yaml"
+		cfg.ConfigFileRepositoryURL = UnknownRepositoryURL
+		cfg.ConfigFileRepositoryCommit = "deadbeef"
+		cfg.WorkspaceDir = t.TempDir()
+		cfg.OutDir = t.TempDir()
+		cfg.Offline = true
+		// No BuildKit daemon listens there, so a build that gets past the
+		// check fails.
+		cfg.BuildKitAddr = "unix://" + filepath.Join(t.TempDir(), "buildkitd.sock")
+		cfg.BuildKitDialTimeout = time.Second
+		cfg.Configuration = &config.Configuration{
+			Package: config.Package{Name: "hello", Version: "1.0.0"},
+			Environment: apko_types.ImageConfiguration{
+				Contents: apko_types.ImageContents{Repositories: repos, Keyring: keys},
+			},
+			Pipeline: pipelines,
+		}
+		return RunBuild(ctx, []apko_types.Architecture{apko_types.ParseArchitecture("x86_64")}, cfg)
+	}
+
+	t.Run("remote repository fails before resolution", func(t *testing.T) {
+		err := run(t, []string{"https://packages.wolfi.dev/os"}, nil)
+		require.ErrorIs(t, err, buildkit.ErrOffline)
+		require.ErrorContains(t, err, "repository https://packages.wolfi.dev/os")
+	})
+
+	t.Run("remote key fails before resolution", func(t *testing.T) {
+		err := run(t, []string{"/srv/packages"}, []string{"https://packages.wolfi.dev/os/wolfi-signing.rsa.pub"})
+		require.ErrorIs(t, err, buildkit.ErrOffline)
+		require.ErrorContains(t, err, "key https://packages.wolfi.dev/os/wolfi-signing.rsa.pub")
+	})
+
+	t.Run("fetch step fails before resolution", func(t *testing.T) {
+		err := run(t, []string{"/srv/packages"}, nil, config.Pipeline{
+			Uses: "fetch",
+			With: map[string]string{"uri": "https://example.com/hello-1.0.0.tar.gz"},
+		})
+		require.ErrorIs(t, err, buildkit.ErrOffline)
+		require.ErrorContains(t, err, "fetches https://example.com/hello-1.0.0.tar.gz")
+	})
+
+	t.Run("local sources pass the check", func(t *testing.T) {
+		b := &Build{
+			Configuration: &config.Configuration{
+				Environment: apko_types.ImageConfiguration{
+					Contents: apko_types.ImageContents{
+						Repositories: []string{"/srv/packages", "@local file:///srv/local"},
+						Keyring:      []string{"/srv/keys/melange.rsa.pub"},
+					},
+				},
+				Pipeline: []config.Pipeline{{Runs: "make"}},
+			},
+			ExtraRepos: []string{"http://localhost:8080/packages"},
+		}
+		require.NoError(t, b.checkOffline())
+	})
+}
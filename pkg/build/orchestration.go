@@ -55,7 +55,9 @@ type Orchestrator[C any] struct {
 }
 
 // RunForArchitectures executes the build/test for all specified architectures.
-// If archs is empty, it defaults to all architectures.
+// If archs is empty, it defaults to all architectures. Every architecture is
+// executed even if another fails; the returned error joins the errors of
+// those that failed, each naming its architecture.
 func (o *Orchestrator[C]) RunForArchitectures(ctx context.Context, archs []apko_types.Architecture) error {
 	log := clog.FromContext(ctx)
 	ctx, span := otel.Tracer("melange").Start(ctx, o.SpanName)
@@ -91,8 +93,9 @@ func (o *Orchestrator[C]) RunForArchitectures(ctx context.Context, archs []apko_
 	}
 
 	var errg errgroup.Group
+	errs := make([]error, len(executors))
 
-	for _, exec := range executors {
+	for i, exec := range executors {
 		errg.Go(func() error {
 			lctx := ctx
 			if len(executors) != 1 {
@@ -101,13 +104,26 @@ func (o *Orchestrator[C]) RunForArchitectures(ctx context.Context, archs []apko_
 			}
 
 			if err := exec.Execute(lctx); err != nil {
-				return fmt.Errorf("execution failed: %w", err)
+				errs[i] = fmt.Errorf("%s: execution failed: %w", exec.GetArch().ToAPK(), err)
 			}
 			return nil
 		})
 	}
 
-	return errg.Wait()
+	_ = errg.Wait()
+	return errors.Join(errs...)
+}
+
+// RunBuild builds the package configured by cfg for each of archs, or for
+// every architecture if archs is empty, concurrently. Each architecture is
+// built from a clone of cfg, so cfg.Arch is ignored, and architectures the
+// package does not target are skipped. The returned error joins the errors
+// of the architectures that failed to build, each naming its architecture.
+//
+// This is the entry point for running a build from Go; the build command is
+// a thin wrapper around it.
+func RunBuild(ctx context.Context, archs []apko_types.Architecture, cfg *BuildConfig) error {
+	return NewBuildOrchestrator(cfg).RunForArchitectures(ctx, archs)
 }
 
 // buildExecutor wraps a Build to implement the Executor interface.
@@ -118,7 +134,7 @@ type buildExecutor struct {
 func (e *buildExecutor) Execute(ctx context.Context) error {
 	log := clog.FromContext(ctx)
 	if err := e.build.BuildPackage(ctx); err != nil {
-		if !e.build.Remove {
+		if e.build.keepWorkspace() {
 			log.Error("ERROR: failed to build package. the build environment has been preserved:")
 			e.build.SummarizePaths(ctx)
 		}
@@ -140,6 +156,11 @@ func NewBuildExecutorFactory() ExecutorFactory[BuildConfig] {
 	return func(ctx context.Context, cfg *BuildConfig) (Executor, error) {
 		bc, err := NewFromConfig(ctx, cfg)
 		if err != nil {
+			if cfg.Summary != nil && !errors.Is(err, ErrSkipThisArch) {
+				// The build never started, so it records no outcome of
+				// its own.
+				cfg.Summary.Add(SummaryEntry{Arch: cfg.Arch.ToAPK(), Package: summaryPackage(cfg), Failed: true})
+			}
 			return nil, err
 		}
 		return &buildExecutor{build: bc}, nil
@@ -0,0 +1,158 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// fakeExecutor records its execution, failing with err.
+type fakeExecutor struct {
+	arch apko_types.Architecture
+	err  error
+
+	mu       *sync.Mutex
+	executed *[]string
+}
+
+func (e *fakeExecutor) Execute(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	*e.executed = append(*e.executed, e.arch.ToAPK())
+	return e.err
+}
+
+func (e *fakeExecutor) Close(context.Context) error { return nil }
+
+func (e *fakeExecutor) GetArch() apko_types.Architecture { return e.arch }
+
+func TestRunForArchitectures(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	// run executes a build of every arch of archs, failing for those of
+	// failing and skipping those of skipped, and returns the archs executed.
+	run := func(t *testing.T, archs []string, failing, skipped map[string]error) ([]string, error) {
+		t.Helper()
+		var mu sync.Mutex
+		var executed []string
+		base := NewBuildConfig()
+		base.Arch = apko_types.ParseArchitecture("s390x")
+
+		o := NewBuildOrchestrator(base)
+		o.Factory = func(_ context.Context, cfg *BuildConfig) (Executor, error) {
+			// Each arch is built from its own clone of the base config.
+			require.NotSame(t, base, cfg)
+			if err := skipped[cfg.Arch.ToAPK()]; err != nil {
+				return nil, err
+			}
+			return &fakeExecutor{arch: cfg.Arch, err: failing[cfg.Arch.ToAPK()], mu: &mu, executed: &executed}, nil
+		}
+
+		var as []apko_types.Architecture
+		for _, a := range archs {
+			as = append(as, apko_types.ParseArchitecture(a))
+		}
+		err := o.RunForArchitectures(ctx, as)
+		require.Equal(t, "s390x", base.Arch.ToAPK())
+		return executed, err
+	}
+
+	t.Run("every arch is built", func(t *testing.T) {
+		executed, err := run(t, []string{"x86_64", "aarch64"}, nil, nil)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"x86_64", "aarch64"}, executed)
+	})
+
+	t.Run("errors name their arch", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		executed, err := run(t, []string{"x86_64", "aarch64", "riscv64"}, map[string]error{
+			"x86_64":  errBoom,
+			"riscv64": errors.New("bang"),
+		}, nil)
+		// A failing arch does not stop the others.
+		require.ElementsMatch(t, []string{"x86_64", "aarch64", "riscv64"}, executed)
+		require.ErrorIs(t, err, errBoom)
+		require.ErrorContains(t, err, "x86_64: execution failed: boom")
+		require.ErrorContains(t, err, "riscv64: execution failed: bang")
+		require.NotContains(t, err.Error(), "aarch64")
+	})
+
+	t.Run("skipped archs", func(t *testing.T) {
+		executed, err := run(t, []string{"x86_64", "aarch64"}, nil, map[string]error{"aarch64": ErrSkipThisArch})
+		require.NoError(t, err)
+		require.Equal(t, []string{"x86_64"}, executed)
+	})
+}
+
+func TestRunBuild(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	cfg := NewBuildConfig()
+	cfg.ConfigFile = "melange.yaml"
+	cfg.ConfigFileRepositoryURL = UnknownRepositoryURL
+	cfg.ConfigFileRepositoryCommit = "deadbeef"
+	cfg.WorkspaceDir = t.TempDir()
+	cfg.OutDir = t.TempDir()
+	// No BuildKit daemon listens there, so every build fails.
+	cfg.BuildKitAddr = "unix://" + filepath.Join(t.TempDir(), "buildkitd.sock")
+	cfg.BuildKitDialTimeout = time.Second
+	cfg.Configuration = &config.Configuration{
+		Package: config.Package{
+			Name:               "hello",
+			Version:            "1.0.0",
+			TargetArchitecture: []string{"!riscv64"},
+		},
+	}
+
+	err := RunBuild(ctx, []apko_types.Architecture{
+		apko_types.ParseArchitecture("x86_64"),
+		apko_types.ParseArchitecture("aarch64"),
+		apko_types.ParseArchitecture("riscv64"),
+	}, cfg)
+	require.ErrorContains(t, err, "x86_64: execution failed: failed to build package")
+	require.ErrorContains(t, err, "aarch64: execution failed: failed to build package")
+	// Archs the package does not target are skipped.
+	require.NotContains(t, err.Error(), "riscv64")
+}
+
+func TestBuildExecutorFactoryRecordsFailedSetup(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	cfg := NewBuildConfig()
+	cfg.ConfigFile = filepath.Join(t.TempDir(), "hello.yaml")
+	cfg.Arch = apko_types.ParseArchitecture("x86_64")
+	cfg.Summary = NewSummary()
+
+	// The configuration does not exist, so the build cannot be set up.
+	_, err := NewBuildExecutorFactory()(ctx, cfg)
+	require.Error(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Summary.Write(&out))
+	require.Regexp(t, `(?m)^x86_64\s+hello\s+failed\s`, out.String())
+	require.Regexp(t, `(?m)^0 built, 1 failed in \d+s$`, out.String())
+}
@@ -19,7 +19,6 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -38,6 +37,7 @@ import (
 	"github.com/klauspost/pgzip"
 
 	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/output"
 	"github.com/dlorenc/melange2/pkg/sca"
 	"github.com/dlorenc/melange2/pkg/sign"
 	"github.com/dlorenc/melange2/pkg/tarball"
@@ -89,7 +89,13 @@ func pkgFromSub(sub *config.Subpackage) *config.Package {
 
 func (b *Build) Emit(ctx context.Context, pkg *config.Package) error {
 	b.End = time.Now()
-	pc := PackageBuild{
+	pc := b.packageBuild(pkg)
+	return pc.EmitPackage(ctx)
+}
+
+// packageBuild returns the build of the package or subpackage pkg.
+func (b *Build) packageBuild(pkg *config.Package) *PackageBuild {
+	pc := &PackageBuild{
 		Build:        b,
 		Origin:       &b.Configuration.Package,
 		PackageName:  pkg.Name,
@@ -107,8 +113,29 @@ func (b *Build) Emit(ctx context.Context, pkg *config.Package) error {
 	if !b.StripOriginName {
 		pc.OriginName = pc.Origin.Name
 	}
+	return pc
+}
 
-	return pc.EmitPackage(ctx)
+// generateDependencies runs the static analysis of every package of the
+// build, recording the dependencies it generates for EmitPackage, and
+// returns their runtime dependencies by package name.
+func (b *Build) generateDependencies(ctx context.Context) (map[string][]string, error) {
+	pkgs := []*config.Package{&b.Configuration.Package}
+	for i := range b.Configuration.Subpackages {
+		pkgs = append(pkgs, pkgFromSub(&b.Configuration.Subpackages[i]))
+	}
+
+	b.generated = make(map[string]config.Dependencies, len(pkgs))
+	runtime := make(map[string][]string, len(pkgs))
+	for _, pkg := range pkgs {
+		generated := config.Dependencies{}
+		if err := sca.Analyze(ctx, &SCABuildInterface{PackageBuild: b.packageBuild(pkg)}, &generated); err != nil {
+			return nil, fmt.Errorf("analyzing package %s: %w", pkg.Name, err)
+		}
+		b.generated[pkg.Name] = generated
+		runtime[pkg.Name] = generated.Runtime
+	}
+	return runtime, nil
 }
 
 // AppendBuildLog will create or append a list of packages that were built by melange build
@@ -140,7 +167,11 @@ func (pc *PackageBuild) Identity() string {
 }
 
 func (pc *PackageBuild) Filename() string {
-	return fmt.Sprintf("%s/%s.apk", pc.OutDir, pc.Identity())
+	var template string
+	if pc.Build != nil {
+		template = pc.Build.APKNameTemplate
+	}
+	return fmt.Sprintf("%s/%s", pc.OutDir, output.APKFileName(template, pc.PackageName, pc.Origin.Version, pc.Origin.Epoch, pc.Arch))
 }
 
 func (pc *PackageBuild) ProvenanceFilename() string {
@@ -329,25 +360,18 @@ func removeSelfProvidedDeps(runtimeDeps, providedDeps []string) []string {
 
 func (pc *PackageBuild) GenerateDependencies(ctx context.Context, hdl sca.SCAHandle) error {
 	log := clog.FromContext(ctx)
-	generated := config.Dependencies{}
 
-	if err := sca.Analyze(ctx, hdl, &generated); err != nil {
-		return fmt.Errorf("analyzing package: %w", err)
-	}
-
-	if pc.Build.DependencyLog != "" {
-		log.Info("writing dependency log")
-
-		logFile, err := os.Create(fmt.Sprintf("%s.%s", pc.Build.DependencyLog, pc.Arch))
-		if err != nil {
-			log.Warnf("Unable to open dependency log: %v", err)
+	// The build may already have analyzed the package, before linting it.
+	generated, ok := pc.Build.generated[pc.PackageName]
+	if !ok {
+		if err := sca.Analyze(ctx, hdl, &generated); err != nil {
+			return fmt.Errorf("analyzing package: %w", err)
 		}
-		defer logFile.Close()
+	}
 
-		je := json.NewEncoder(logFile)
-		if err := je.Encode(&generated); err != nil {
-			return err
-		}
+	configured := config.Dependencies{
+		Runtime:  slices.Clone(pc.Dependencies.Runtime),
+		Provides: slices.Clone(pc.Dependencies.Provides),
 	}
 
 	// Only consider vendored deps for self-provided generated runtime deps.
@@ -367,6 +391,21 @@ func (pc *PackageBuild) GenerateDependencies(ctx context.Context, hdl sca.SCAHan
 	// Sets .PKGINFO `# vendored = ...` comments; does not affect resolution.
 	pc.Dependencies.Vendored = slices.Compact(slices.Sorted(slices.Values(generated.Vendored)))
 
+	if pc.Build.DependencyLog != "" {
+		log.Info("writing dependency log")
+
+		logFile, err := os.Create(fmt.Sprintf("%s.%s", pc.Build.DependencyLog, pc.Arch))
+		if err != nil {
+			log.Warnf("Unable to open dependency log: %v", err)
+		}
+		defer logFile.Close()
+
+		env := pc.Build.Configuration.Environment.Contents.Packages
+		if err := writeDependencyLog(logFile, pc.Build.DependencyLogFormat, generated, configured, pc.Dependencies, env, pc.Build.Configuration.PackageNotes); err != nil {
+			return err
+		}
+	}
+
 	pc.Dependencies.Summarize(ctx)
 
 	return nil
@@ -84,6 +84,22 @@ func TestPackageBuildFilename(t *testing.T) {
 	require.Equal(t, expected, pb.Filename())
 }
 
+func TestPackageBuildFilenameTemplate(t *testing.T) {
+	pb := &PackageBuild{
+		Build:       &Build{APKNameTemplate: "{arch}-{name}_{version}-{epoch}.apk"},
+		PackageName: "testpkg",
+		OutDir:      "/output/x86_64",
+		Arch:        "x86_64",
+		Origin: &config.Package{
+			Version: "1.0.0",
+			Epoch:   2,
+		},
+	}
+
+	expected := "/output/x86_64/x86_64-testpkg_1.0.0-2.apk"
+	require.Equal(t, expected, pb.Filename())
+}
+
 func TestPackageBuildProvenanceFilename(t *testing.T) {
 	pb := &PackageBuild{
 		PackageName: "testpkg",
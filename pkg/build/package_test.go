@@ -15,12 +15,21 @@
 package build
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/dlorenc/melange2/pkg/config"
 
+	apkofs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/chainguard-dev/clog/slogtest"
 	"github.com/stretchr/testify/require"
 )
 
@@ -138,3 +147,129 @@ datahash = baadf00d
 		})
 	}
 }
+
+func TestWriteDependencyLog(t *testing.T) {
+	generated := config.Dependencies{
+		Runtime:  []string{"so:libc.so.6", "cmd:sh"},
+		Provides: []string{"so:libfoo.so.1=1"},
+		Vendored: []string{"so:libbundled.so.2=2"},
+	}
+	configured := config.Dependencies{
+		Runtime: []string{"ca-certificates-bundle>=20240101"},
+	}
+	resolved := config.Dependencies{
+		Runtime:  []string{"ca-certificates-bundle>=20240101", "cmd:sh", "so:libc.so.6"},
+		Provides: []string{"so:libfoo.so.1=1"},
+		Vendored: []string{"so:libbundled.so.2=2"},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		for _, format := range []string{"", DependencyLogFormatText} {
+			var buf bytes.Buffer
+			require.NoError(t, writeDependencyLog(&buf, format, generated, configured, resolved, nil, nil))
+
+			// The text format is unchanged: the generated dependencies as one JSON object.
+			want, err := json.Marshal(&generated)
+			require.NoError(t, err)
+			require.Equal(t, string(want)+"\n", buf.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, writeDependencyLog(&buf, DependencyLogFormatJSON, generated, configured, resolved, nil, nil))
+
+		var got []DependencyLogEntry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		require.Equal(t, []DependencyLogEntry{
+			{Type: "runtime", Name: "ca-certificates-bundle", Constraint: ">=", Version: "20240101", Source: DependencySourceConfig},
+			{Type: "runtime", Name: "cmd:sh", Source: DependencySourceAnalysis},
+			{Type: "runtime", Name: "so:libc.so.6", Source: DependencySourceAnalysis},
+			{Type: "provides", Name: "so:libfoo.so.1", Constraint: "=", Version: "1", Source: DependencySourceAnalysis},
+			{Type: "vendored", Name: "so:libbundled.so.2", Constraint: "=", Version: "2", Source: DependencySourceAnalysis},
+		}, got)
+	})
+
+	t.Run("same resolved set", func(t *testing.T) {
+		var text, js bytes.Buffer
+		require.NoError(t, writeDependencyLog(&text, DependencyLogFormatText, generated, configured, resolved, nil, nil))
+		require.NoError(t, writeDependencyLog(&js, DependencyLogFormatJSON, generated, configured, resolved, nil, nil))
+
+		var fromText config.Dependencies
+		require.NoError(t, json.Unmarshal(text.Bytes(), &fromText))
+		var entries []DependencyLogEntry
+		require.NoError(t, json.Unmarshal(js.Bytes(), &entries))
+
+		// Every dependency generated by analysis appears in the JSON log.
+		var analyzed []string
+		for _, e := range entries {
+			if e.Source == DependencySourceAnalysis && e.Type != "vendored" {
+				analyzed = append(analyzed, e.Name+e.Constraint+e.Version)
+			}
+		}
+		require.ElementsMatch(t, append(fromText.Runtime, fromText.Provides...), analyzed)
+	})
+
+	t.Run("build environment notes", func(t *testing.T) {
+		environment := []string{"build-base", "go>=1.22", "openssl-dev"}
+		notes := map[string]string{
+			"go":          "builds the helper binaries",
+			"openssl-dev": "links the TLS support",
+		}
+
+		var text bytes.Buffer
+		require.NoError(t, writeDependencyLog(&text, DependencyLogFormatText, generated, configured, resolved, environment, notes))
+		want, err := json.Marshal(&generated)
+		require.NoError(t, err)
+		require.Equal(t, string(want)+"\n", text.String())
+
+		var buf bytes.Buffer
+		require.NoError(t, writeDependencyLog(&buf, DependencyLogFormatJSON, generated, configured, resolved, environment, notes))
+		var got []DependencyLogEntry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		require.Equal(t, []DependencyLogEntry{
+			{Type: "build", Name: "build-base", Source: DependencySourceConfig},
+			{Type: "build", Name: "go", Constraint: ">=", Version: "1.22", Source: DependencySourceConfig, Note: "builds the helper binaries"},
+			{Type: "build", Name: "openssl-dev", Source: DependencySourceConfig, Note: "links the TLS support"},
+		}, got[len(got)-3:])
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		require.Error(t, validateDependencyLogFormat("yaml"))
+		require.NoError(t, validateDependencyLogFormat(DependencyLogFormatJSON))
+	})
+}
+
+func TestEmitDataSectionBuildDate(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "usr", "bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "usr", "bin", "hello"), []byte("#!/bin/sh\necho hello\n"), 0o755))
+	require.NoError(t, os.Symlink("hello", filepath.Join(dir, "usr", "bin", "hi")))
+	fsys := apkofs.DirFS(ctx, dir)
+
+	buildDate := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	pc := &PackageBuild{Build: &Build{SourceDateEpoch: buildDate}}
+
+	f, err := os.CreateTemp(t.TempDir(), "data-*.tar.gz")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, pc.emitDataSection(ctx, fsys, fsys, nil, nil, f))
+
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		require.True(t, buildDate.Equal(hdr.ModTime), "%s has mtime %v, want %v", hdr.Name, hdr.ModTime, buildDate)
+	}
+	require.Contains(t, names, "usr/bin/hello")
+	require.Contains(t, names, "usr/bin/hi")
+}
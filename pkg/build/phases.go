@@ -0,0 +1,85 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dlorenc/melange2/pkg/buildkit"
+)
+
+// The phases of a build, in the order they run.
+const (
+	// PhaseConfigParse parses the configuration of the build.
+	PhaseConfigParse = "config_parse"
+	// PhaseApkoLayers builds the layers of the build environment with apko.
+	PhaseApkoLayers = "apko_layer_generation"
+	// PhaseLLB loads the layers and constructs the LLB graph of the build.
+	PhaseLLB = "llb_construction"
+	// PhaseSolve solves the graph with BuildKit, which exports the
+	// workspace.
+	PhaseSolve = "solve"
+	// PhaseExport turns the exported workspace into packages, with their
+	// SBOMs, lint results and index.
+	PhaseExport = "export"
+)
+
+// PhaseTiming is how long a phase of a build took.
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// PhaseTimings are the phases of a build, in the order they ran, as far as
+// the build got.
+type PhaseTimings []PhaseTiming
+
+// Get returns how long phase took, and whether it ran.
+func (p PhaseTimings) Get(phase string) (time.Duration, bool) {
+	for _, t := range p {
+		if t.Phase == phase {
+			return t.Duration, true
+		}
+	}
+	return 0, false
+}
+
+// String returns the phases as a single line, as in
+// "config_parse=12ms apko_layer_generation=3.2s".
+func (p PhaseTimings) String() string {
+	parts := make([]string, 0, len(p))
+	for _, t := range p {
+		parts = append(parts, fmt.Sprintf("%s=%s", t.Phase, t.Duration.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// addPhase records that phase of the build took d.
+func (b *Build) addPhase(phase string, d time.Duration) {
+	b.Phases = append(b.Phases, PhaseTiming{Phase: phase, Duration: d})
+}
+
+// addBuildKitPhases records the phases of the build BuildKit ran, as far
+// as it got.
+func (b *Build) addBuildKitPhases(t buildkit.Timings) {
+	if t.Graph > 0 {
+		b.addPhase(PhaseLLB, t.Graph)
+	}
+	if t.Solve > 0 {
+		b.addPhase(PhaseSolve, t.Solve)
+	}
+}
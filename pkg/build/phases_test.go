@@ -0,0 +1,88 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/buildkit"
+)
+
+func TestBuildPhases(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	fp := filepath.Join(t.TempDir(), "melange.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(`
+package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+
+pipeline:
+  - runs: make
+`), 0o644))
+
+	cfg := NewBuildConfig()
+	cfg.ConfigFile = fp
+	cfg.ConfigFileRepositoryURL = "https://github.com/dlorenc/melange2"
+	cfg.ConfigFileRepositoryCommit = "deadbeef"
+	cfg.WorkspaceDir = t.TempDir()
+	cfg.Arch = apko_types.ParseArchitecture("x86_64")
+	b, err := NewFromConfig(ctx, cfg)
+	require.NoError(t, err)
+
+	parse, ok := b.Phases.Get(PhaseConfigParse)
+	require.True(t, ok)
+	require.GreaterOrEqual(t, parse, time.Duration(0))
+
+	// The rest of the phases, as a build that failed solving records them.
+	b.addPhase(PhaseApkoLayers, 3*time.Second)
+	b.addBuildKitPhases(buildkit.Timings{Graph: 250 * time.Millisecond, Solve: time.Minute})
+	b.addBuildKitPhases(buildkit.Timings{})
+	var phases []string
+	for _, p := range b.Phases {
+		require.GreaterOrEqual(t, p.Duration, time.Duration(0), p.Phase)
+		phases = append(phases, p.Phase)
+	}
+	require.Equal(t, []string{PhaseConfigParse, PhaseApkoLayers, PhaseLLB, PhaseSolve}, phases)
+	_, ok = b.Phases.Get(PhaseExport)
+	require.False(t, ok)
+
+	// The summary reports them with the main package.
+	b.Start = time.Now()
+	entries := b.summaryEntries(nil)
+	require.Equal(t, b.Phases, entries[0].Phases)
+
+	var out bytes.Buffer
+	s := NewSummary()
+	s.Add(entries...)
+	require.NoError(t, s.Write(&out))
+	require.Contains(t, out.String(), "apko_layer_generation=3s llb_construction=250ms solve=1m0s\n")
+	require.Contains(t, out.String(), "x86_64/hello: phases: config_parse=")
+
+	// A configuration parsed already is not timed again.
+	cfg.Configuration = b.Configuration
+	b, err = NewFromConfig(ctx, cfg)
+	require.NoError(t, err)
+	require.Empty(t, b.Phases)
+}
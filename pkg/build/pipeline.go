@@ -18,6 +18,7 @@ import (
 	"embed"
 	"fmt"
 	"maps"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -25,6 +26,7 @@ import (
 
 	"github.com/dlorenc/melange2/pkg/cond"
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 	"github.com/dlorenc/melange2/pkg/util"
 )
 
@@ -92,6 +94,8 @@ func NewSubstitutionMap(cfg *config.Configuration, arch apkoTypes.Architecture,
 	nw[config.SubstitutionCrossTripletRustMusl] = arch.ToRustTriplet("musl")
 	nw[config.SubstitutionBuildArch] = arch.ToAPK()
 	nw[config.SubstitutionBuildGoArch] = arch.String()
+	nw[config.SubstitutionBuildUserAgent] = melangehttp.DefaultUserAgent()
+	nw[config.SubstitutionBuildFetchTimeout] = "0"
 
 	// Retrieve vars from config
 	subst_nw, err := cfg.GetVarsFromConfig()
@@ -129,11 +133,15 @@ func NewSubstitutionMap(cfg *config.Configuration, arch apkoTypes.Architecture,
 	return &SubstitutionMap{nw}, nil
 }
 
-func validateWith(data map[string]string, inputs map[string]config.Input) (map[string]string, error) {
+// validateWith applies input defaults to the with: values passed to pipeline
+// and checks that every required input has been provided.
+func validateWith(pipeline string, data map[string]string, inputs map[string]config.Input) (map[string]string, error) {
 	if data == nil {
 		data = make(map[string]string)
 	}
-	for k, v := range inputs {
+	// Visit inputs in a stable order so errors are reproducible.
+	for _, k := range slices.Sorted(maps.Keys(inputs)) {
+		v := inputs[k]
 		if data[k] == "" {
 			data[k] = v.Default
 		}
@@ -150,7 +158,7 @@ func validateWith(data map[string]string, inputs map[string]config.Input) (map[s
 			}
 		}
 		if v.Required && data[k] == "" {
-			return data, fmt.Errorf("required input %q for pipeline is missing", k)
+			return data, fmt.Errorf("pipeline %q missing required input %q", pipeline, k)
 		}
 	}
 
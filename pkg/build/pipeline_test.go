@@ -15,9 +15,19 @@
 package build
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -198,13 +208,13 @@ func Test_validateWith(t *testing.T) {
 				"expected-commit": {Default: "", Required: true},
 			},
 			expectError: true,
-			errorMsg:    "required input \"expected-commit\" for pipeline is missing",
+			errorMsg:    "pipeline \"git-checkout\" missing required input \"expected-commit\"",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := validateWith(tt.data, tt.inputs)
+			result, err := validateWith("git-checkout", tt.data, tt.inputs)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -217,3 +227,166 @@ func Test_validateWith(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchRetriesOnChecksumMismatch(t *testing.T) {
+	for _, tool := range []string{"bash", "wget", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+	}
+
+	good := []byte("the real artifact")
+	sum := sha256.Sum256(good)
+	expected := hex.EncodeToString(sum[:])
+
+	// serve returns a server that sends corrupted content for the first
+	// corrupt requests.
+	serve := func(t *testing.T, corrupt int32) (*httptest.Server, *atomic.Int32) {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if requests.Add(1) <= corrupt {
+				_, _ = w.Write([]byte("corrupted by the CDN"))
+				return
+			}
+			_, _ = w.Write(good)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &requests
+	}
+
+	fetch := func(t *testing.T, uri, retries string) (string, error) {
+		t.Helper()
+		b := &Build{
+			Configuration: &config.Configuration{
+				Pipeline: []config.Pipeline{{
+					Uses: "fetch",
+					With: map[string]string{
+						"uri":             uri,
+						"expected-sha256": expected,
+						"extract":         "false",
+					},
+				}},
+			},
+		}
+		if retries != "" {
+			b.Configuration.Pipeline[0].With["retries"] = retries
+		}
+		require.NoError(t, b.Compile(context.Background()))
+		runs := b.Configuration.Pipeline[0].Pipeline[0].Runs
+
+		dir := t.TempDir()
+		cmd := exec.Command("bash", "-c", runs)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			got, rerr := os.ReadFile(filepath.Join(dir, "artifact.tar.gz"))
+			require.NoError(t, rerr)
+			require.Equal(t, good, got)
+		}
+		return string(out), err
+	}
+
+	t.Run("mismatch then match", func(t *testing.T) {
+		srv, requests := serve(t, 2)
+		out, err := fetch(t, srv.URL+"/artifact.tar.gz", "2")
+		require.NoError(t, err, out)
+		require.Equal(t, int32(3), requests.Load())
+		require.Contains(t, out, "retry 2 of 2")
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		srv, requests := serve(t, 5)
+		out, err := fetch(t, srv.URL+"/artifact.tar.gz", "2")
+		require.Error(t, err)
+		require.Equal(t, int32(3), requests.Load())
+
+		bad := sha256.Sum256([]byte("corrupted by the CDN"))
+		require.Contains(t, out, "does not match found: "+hex.EncodeToString(bad[:]))
+		require.Contains(t, out, "expected "+expected+", attempts: 3")
+	})
+
+	t.Run("no retries by default", func(t *testing.T) {
+		srv, requests := serve(t, 1)
+		out, err := fetch(t, srv.URL+"/artifact.tar.gz", "")
+		require.Error(t, err)
+		require.Equal(t, int32(1), requests.Load())
+		require.Contains(t, out, "attempts: 1")
+	})
+}
+
+func TestFetchDownloadTimeout(t *testing.T) {
+	for _, tool := range []string{"bash", "wget", "sha256sum", "timeout"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+	}
+
+	artifact := []byte("a slowly served artifact")
+	sum := sha256.Sum256(artifact)
+
+	// The server sends a byte every 100ms, often enough that no read times
+	// out, so that the download takes over two seconds.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(artifact)))
+		flusher := w.(http.Flusher)
+		for _, c := range artifact {
+			if _, err := w.Write([]byte{c}); err != nil {
+				return
+			}
+			flusher.Flush()
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	fetch := func(t *testing.T, b *Build, with map[string]string) (string, error) {
+		t.Helper()
+		b.Configuration = &config.Configuration{
+			Pipeline: []config.Pipeline{{
+				Uses: "fetch",
+				With: map[string]string{
+					"uri":             srv.URL + "/artifact.tar.gz",
+					"expected-sha256": hex.EncodeToString(sum[:]),
+					"extract":         "false",
+				},
+			}},
+		}
+		maps.Copy(b.Configuration.Pipeline[0].With, with)
+		require.NoError(t, b.Compile(context.Background()))
+
+		cmd := exec.Command("bash", "-c", b.Configuration.Pipeline[0].Pipeline[0].Runs)
+		cmd.Dir = t.TempDir()
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+
+	t.Run("download-timeout", func(t *testing.T) {
+		start := time.Now()
+		// The URI is printed as is, even if it contains a "%".
+		uri := srv.URL + "/artifact%2B1.tar.gz"
+		out, err := fetch(t, &Build{}, map[string]string{"download-timeout": "1s", "uri": uri})
+		require.Error(t, err)
+		require.Contains(t, out, "fetch: download of "+uri+" timed out after 1s")
+		require.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	t.Run("fetch timeout of the build", func(t *testing.T) {
+		out, err := fetch(t, &Build{FetchTimeout: 500 * time.Millisecond}, nil)
+		require.Error(t, err)
+		require.Contains(t, out, "timed out after 1s")
+	})
+
+	t.Run("download-timeout overrides the build", func(t *testing.T) {
+		out, err := fetch(t, &Build{FetchTimeout: time.Second}, map[string]string{"download-timeout": "1m"})
+		require.NoError(t, err, out)
+	})
+
+	t.Run("no limit by default", func(t *testing.T) {
+		out, err := fetch(t, &Build{}, nil)
+		require.NoError(t, err, out)
+	})
+}
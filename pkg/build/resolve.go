@@ -0,0 +1,146 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"syscall"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"github.com/chainguard-dev/clog"
+)
+
+// Resolving the build environment is retried this many times in all, waiting
+// resolveBackoff before the first retry and twice as long before each one
+// after it, up to resolveMaxBackoff.
+var (
+	resolveAttempts   = 4
+	resolveBackoff    = 2 * time.Second
+	resolveMaxBackoff = 30 * time.Second
+)
+
+// ResolutionError is returned when the packages of the build environment
+// cannot be resolved from its repositories. It tells a repository that could
+// not be reached, which may succeed when tried again later, from one that was
+// read but cannot satisfy the requested packages.
+type ResolutionError struct {
+	// Op describes what was being resolved.
+	Op string
+	// Transient is true if the last attempt failed fetching from a
+	// repository, with an error that may not recur.
+	Transient bool
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *ResolutionError) Error() string {
+	switch {
+	case e.Transient:
+		return fmt.Sprintf("%s: transient repository error, gave up after %d attempts: %v", e.Op, e.Attempts, e.Err)
+	case e.Unsatisfiable():
+		return fmt.Sprintf("%s: unsatisfiable dependencies: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *ResolutionError) Unwrap() error {
+	return e.Err
+}
+
+// Unsatisfiable reports whether the repositories were read, but hold no set
+// of packages satisfying the request.
+func (e *ResolutionError) Unsatisfiable() bool {
+	return isUnsatisfiable(e.Err)
+}
+
+// retryResolution calls resolve until it succeeds, fails with an error that
+// is not transient, or has been called resolveAttempts times. Errors are
+// returned as a *ResolutionError for op.
+func retryResolution(ctx context.Context, op string, resolve func() error) error {
+	log := clog.FromContext(ctx)
+
+	backoff := resolveBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = resolve(); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return &ResolutionError{Op: op, Attempts: attempt, Err: err}
+		}
+		transient := isTransientResolutionError(err)
+		if !transient || attempt >= resolveAttempts {
+			return &ResolutionError{Op: op, Transient: transient, Attempts: attempt, Err: err}
+		}
+
+		log.Warnf("%s (attempt %d/%d), retrying in %s: %v", op, attempt, resolveAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return &ResolutionError{Op: op, Attempts: attempt, Err: err}
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, resolveMaxBackoff)
+	}
+}
+
+// transientStatus matches the errors for HTTP responses from a repository
+// that may succeed when repeated.
+var transientStatus = regexp.MustCompile(`unexpected status code (408|429|5\d\d)\b`)
+
+// isTransientResolutionError reports whether err, returned resolving the
+// build environment, may not recur: a repository could not be reached, or
+// answered with a server error.
+func isTransientResolutionError(err error) bool {
+	if isUnsatisfiable(err) {
+		return false
+	}
+
+	// Certificates are not fixed by trying again.
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &verification) {
+		return false
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	}
+	return transientStatus.MatchString(err.Error())
+}
+
+// isUnsatisfiable reports whether err says that no set of packages in the
+// repositories satisfies the request.
+func isUnsatisfiable(err error) bool {
+	var constraint *apk.ConstraintError
+	var dep *apk.DepError
+	var disqualified *apk.DisqualifiedError
+	return errors.As(err, &constraint) || errors.As(err, &dep) || errors.As(err, &disqualified)
+}
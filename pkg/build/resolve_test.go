@@ -0,0 +1,151 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apko_build "chainguard.dev/apko/pkg/build"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyRepo serves files, failing the first failures requests for an
+// index with 503 Service Unavailable.
+type flakyRepo struct {
+	files    http.Handler
+	failures int32
+	requests atomic.Int32
+}
+
+func (r *flakyRepo) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, "/APKINDEX.tar.gz") && r.requests.Add(1) <= r.failures {
+		// Have the HTTP client of apk retry at once.
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	r.files.ServeHTTP(w, req)
+}
+
+// writeIndex writes an unsigned index for arch to dir holding hello-1.0-r0.
+func writeIndex(t *testing.T, dir, arch string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("P:hello\nV:1.0-r0\nA:" + arch + "\n\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	index := filepath.Join(dir, arch, "APKINDEX.tar.gz")
+	require.NoError(t, os.MkdirAll(filepath.Dir(index), 0o755))
+	require.NoError(t, os.WriteFile(index, buf.Bytes(), 0o644))
+}
+
+func TestLockEnvironmentRetries(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	attempts, backoff := resolveAttempts, resolveBackoff
+	resolveAttempts, resolveBackoff = 3, time.Millisecond
+	t.Cleanup(func() { resolveAttempts, resolveBackoff = attempts, backoff })
+
+	root := t.TempDir()
+	writeIndex(t, root, "x86_64")
+
+	amd64 := apko_types.ParseArchitecture("x86_64")
+	lock := func(t *testing.T, failures int32, pkg string) (*apko_types.ImageConfiguration, *flakyRepo, error) {
+		repo := &flakyRepo{files: http.FileServer(http.Dir(root)), failures: failures}
+		srv := httptest.NewServer(repo)
+		t.Cleanup(srv.Close)
+
+		b := &Build{Arch: amd64}
+		ic := apko_types.ImageConfiguration{
+			Archs:    []apko_types.Architecture{amd64},
+			Contents: apko_types.ImageContents{Repositories: []string{srv.URL}, Packages: []string{pkg}},
+		}
+		locked, _, err := b.lockEnvironment(ctx, ic, apko_build.WithArch(amd64), apko_build.WithIgnoreSignatures(true))
+		return locked, repo, err
+	}
+
+	t.Run("transient failure", func(t *testing.T) {
+		// Enough failures to exhaust the retries of the HTTP client, but
+		// not those of the resolution.
+		locked, repo, err := lock(t, 8, "hello")
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello=1.0-r0"}, locked.Contents.Packages)
+		require.Greater(t, repo.requests.Load(), int32(8))
+	})
+
+	t.Run("repository down", func(t *testing.T) {
+		_, _, err := lock(t, 1000, "hello")
+		var resErr *ResolutionError
+		require.ErrorAs(t, err, &resErr)
+		require.True(t, resErr.Transient)
+		require.False(t, resErr.Unsatisfiable())
+		require.Equal(t, 3, resErr.Attempts)
+		require.ErrorContains(t, err, "unable to lock image configuration: transient repository error, gave up after 3 attempts")
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		_, repo, err := lock(t, 0, "goodbye")
+		var resErr *ResolutionError
+		require.ErrorAs(t, err, &resErr)
+		require.False(t, resErr.Transient)
+		require.True(t, resErr.Unsatisfiable())
+		require.Equal(t, 1, resErr.Attempts)
+		require.ErrorContains(t, err, "unable to lock image configuration: unsatisfiable dependencies")
+		require.NotZero(t, repo.requests.Load())
+	})
+}
+
+func TestIsTransientResolutionError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("reading index: %w", errors.New("unexpected status code 503")), true},
+		{errors.New("unexpected status code 429"), true},
+		{errors.New("unexpected status code 404"), false},
+		{fmt.Errorf("fetching: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+		{&url.Error{Op: "Head", URL: "https://packages.example.com", Err: x509.UnknownAuthorityError{}}, false},
+		{errors.New("signature verification failed for repository index, for all provided keys"), false},
+		{fmt.Errorf("resolving: %w", &apk.ConstraintError{Constraint: "hello", Wrapped: errors.New("unexpected status code 503")}), false},
+	} {
+		require.Equal(t, tc.want, isTransientResolutionError(tc.err), tc.err.Error())
+	}
+}
@@ -17,15 +17,24 @@ package spdx
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"time"
 
 	"chainguard.dev/apko/pkg/sbom/generator/spdx"
 	"github.com/spdx/tools-golang/spdx/v2/common"
+	"golang.org/x/sync/errgroup"
 
 	build "github.com/dlorenc/melange2/pkg/build/sbom"
 	"github.com/dlorenc/melange2/pkg/sbom"
@@ -90,10 +99,57 @@ func (sg *SBOMGroup) AddUpstreamSourcePackage(p *sbom.Package) {
 	}
 }
 
+// AddExtraPackage adds a package declared manually in the build configuration
+// to all SBOMs in the group.
+func (sg *SBOMGroup) AddExtraPackage(p *sbom.Package) {
+	for _, doc := range sg.set {
+		doc.AddPackage(p)
+		doc.AddRelationship(doc.Describes, p, common.TypeRelationshipContains)
+	}
+}
+
+// AddPatch adds a patch applied during the build to all SBOMs in the group,
+// with a PATCH_APPLIED relationship to each of the upstream source packages
+// it modified, or to the described package if the build has none.
+func (sg *SBOMGroup) AddPatch(p *sbom.Package, sources []*sbom.Package) {
+	for _, doc := range sg.set {
+		doc.AddPackage(p)
+		if len(sources) == 0 {
+			doc.AddRelationship(p, doc.Describes, common.TypeRelationshipPatchApplied)
+			continue
+		}
+		for _, src := range sources {
+			doc.AddRelationship(p, src, common.TypeRelationshipPatchApplied)
+		}
+	}
+}
+
 // Generator is the standard implementation of Generator.
 // It creates a basic SBOMGroup with one SBOM document per package and populates
 // it with all the standard SBOM information.
-type Generator struct{}
+type Generator struct {
+	// Concurrency bounds how many packages' SBOMs are converted and written
+	// at once. Zero or less uses runtime.NumCPU().
+	Concurrency int
+}
+
+// forEachPackage calls fn with the index and name of each package in names,
+// running at most concurrency calls at once, or runtime.NumCPU() if
+// concurrency is not positive. It returns the first error fn returns.
+func forEachPackage(ctx context.Context, names []string, concurrency int, fn func(ctx context.Context, i int, name string) error) error {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, name := range names {
+		g.Go(func() error {
+			return fn(ctx, i, name)
+		})
+	}
+	return g.Wait()
+}
 
 // GenerateSPDX creates an SPDX SBOM document containing all packages based on the build context.
 // It returns a map of package names to their corresponding SPDX documents.
@@ -115,6 +171,11 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 	for _, sp := range gc.Configuration.Subpackages {
 		spSBOM := sg.Document(sp.Name)
 
+		purlNamespace := gc.Namespace
+		if sp.PurlNamespace != "" {
+			purlNamespace = sp.PurlNamespace
+		}
+
 		apkSubPkg := &sbom.Package{
 			Name:            sp.Name,
 			Version:         pkg.FullVersion(),
@@ -122,7 +183,7 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 			LicenseDeclared: pkg.LicenseExpression(),
 			Namespace:       gc.Namespace,
 			Arch:            arch,
-			PURL:            pkg.PackageURLForSubpackage(gc.Namespace, arch, sp.Name),
+			PURL:            pkg.PackageURLForSubpackage(purlNamespace, arch, sp.Name),
 		}
 		spSBOM.AddPackageAndSetDescribed(apkSubPkg)
 
@@ -152,6 +213,7 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 		Namespace:       gc.Namespace,
 		Arch:            arch,
 		PURL:            pkg.PackageURL(gc.Namespace, arch),
+		Changelog:       pkg.ChangelogPath(),
 	}
 	pSBOM.AddPackageAndSetDescribed(apkPkg)
 
@@ -169,6 +231,7 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 
 	// Add upstream source packages from main package pipelines to main package SBOM
 	// and to all subpackage SBOMs (since subpackages are derived from the main source)
+	var upstreamPkgs []*sbom.Package
 	for i, p := range gc.Configuration.Pipeline {
 		uniqueID := strconv.Itoa(i)
 		upstreamPkg, err := p.SBOMPackageForUpstreamSource(gc.Configuration.Package.LicenseExpression(), gc.Namespace, uniqueID)
@@ -181,6 +244,8 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 			continue
 		}
 
+		upstreamPkgs = append(upstreamPkgs, upstreamPkg)
+
 		// Add to main package SBOM
 		pSBOM.AddUpstreamSourcePackage(upstreamPkg)
 
@@ -190,6 +255,30 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 		}
 	}
 
+	// Add manually declared packages to all SBOMs
+	if pkg.SBOM != nil {
+		for _, ep := range pkg.SBOM.ExtraPackages {
+			extraPkg, err := ep.SBOMPackage(gc.Namespace)
+			if err != nil {
+				return nil, fmt.Errorf("creating SBOM package for extra package %s: %w", ep.Name, err)
+			}
+			sg.AddExtraPackage(extraPkg)
+		}
+	}
+
+	// Add patches applied to the main package's source to all SBOMs
+	for _, patch := range gc.Configuration.SBOMPatches() {
+		patchPkg := patch.SBOMPackage(gc.Namespace, pkg.LicenseExpression())
+		checksum, err := patchChecksum(gc.WorkspaceDir, patch.Path)
+		if err != nil {
+			return nil, fmt.Errorf("checksumming patch %s: %w", patch.Path, err)
+		}
+		if checksum != "" {
+			patchPkg.Checksums = map[string]string{"SHA256": checksum}
+		}
+		sg.AddPatch(patchPkg, upstreamPkgs)
+	}
+
 	// Add licensing information
 	li, err := gc.Configuration.Package.LicensingInfos(gc.WorkspaceDir)
 	if err != nil {
@@ -197,14 +286,21 @@ func (g *Generator) GenerateSPDX(ctx context.Context, gc *build.GeneratorContext
 	}
 	sg.SetLicensingInfos(li)
 
-	out := make(map[string]spdx.Document)
-
-	// Convert the SBOMs to SPDX and write them
-	for _, sp := range gc.Configuration.Subpackages {
-		out[sp.Name] = sg.Document(sp.Name).ToSPDX(ctx, gc.ReleaseData)
+	// Convert the SBOMs to SPDX. Each package's document is independent of
+	// the others, and is stored by its index, so the result does not depend
+	// on the order the conversions finish in.
+	docs := make([]spdx.Document, len(pkgNames))
+	if err := forEachPackage(ctx, pkgNames, g.Concurrency, func(ctx context.Context, i int, name string) error {
+		docs[i] = sg.Document(name).ToSPDX(ctx, gc.ReleaseData)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
-	out[pkg.Name] = pSBOM.ToSPDX(ctx, gc.ReleaseData)
+	out := make(map[string]spdx.Document, len(pkgNames))
+	for i, name := range pkgNames {
+		out[name] = docs[i]
+	}
 	return out, nil
 }
 
@@ -216,13 +312,35 @@ func (g *Generator) GenerateSBOM(ctx context.Context, gc *build.GeneratorContext
 		return fmt.Errorf("generating SPDX SBOMs: %w", err)
 	}
 
-	for name, sbom := range sboms {
+	names := slices.Sorted(maps.Keys(sboms))
+	return forEachPackage(ctx, names, g.Concurrency, func(_ context.Context, _ int, name string) error {
+		sbom := sboms[name]
 		if err := writeSBOM(gc, name, &sbom); err != nil {
 			return fmt.Errorf("writing SBOM for %s: %w", name, err)
 		}
+		return nil
+	})
+}
+
+// patchChecksum returns the hex-encoded SHA-256 checksum of the patch at
+// path in the workspace, or "" if the workspace does not hold it.
+func patchChecksum(workspaceDir, path string) (string, error) {
+	if workspaceDir == "" {
+		return "", nil
+	}
+	f, err := os.Open(filepath.Join(workspaceDir, path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
+	defer f.Close()
 
-	return nil
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // writeSPDXSBOM writes an SBOM document to the SBOM filesystem.
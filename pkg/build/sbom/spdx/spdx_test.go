@@ -16,10 +16,14 @@ package spdx
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -618,3 +622,435 @@ func TestSBOMGenerationWithSubpackageGitCheckout(t *testing.T) {
 		}
 	}
 }
+
+func TestSBOMGenerationWithExtraPackages(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	outputFS := apkofs.DirFS(ctx, tmpDir)
+
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-pkg",
+			Version: "1.2.3",
+			Epoch:   0,
+			Copyright: []config.Copyright{
+				{License: "MIT"},
+			},
+			SBOM: &config.PackageSBOM{
+				ExtraPackages: []config.SBOMExtraPackage{{
+					Name:             "zlib",
+					Version:          "1.3.1",
+					License:          "Zlib",
+					PURL:             "pkg:GENERIC/zlib@1.3.1",
+					DownloadLocation: "https://zlib.net/zlib-1.3.1.tar.gz",
+				}},
+			},
+		},
+		Subpackages: []config.Subpackage{
+			{Name: "test-pkg-dev"},
+		},
+	}
+
+	genCtx := &build.GeneratorContext{
+		Configuration:   cfg,
+		WorkspaceDir:    tmpDir,
+		OutputFS:        outputFS,
+		SourceDateEpoch: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Namespace:       "test-ns",
+		Arch:            "x86_64",
+		ReleaseData: &apko_build.ReleaseData{
+			ID:        "test-os",
+			VersionID: "1.0",
+		},
+	}
+
+	gen := &Generator{}
+	if err := gen.GenerateSBOM(ctx, genCtx); err != nil {
+		t.Fatalf("GenerateSBOM failed: %v", err)
+	}
+
+	expectedPkg := spdx.Package{
+		ID:               "SPDXRef-Package-extra-zlib-1.3.1",
+		Name:             "zlib",
+		Version:          "1.3.1",
+		FilesAnalyzed:    false,
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "Zlib",
+		DownloadLocation: "https://zlib.net/zlib-1.3.1.tar.gz",
+		Originator:       "Organization: Test-Ns",
+		Supplier:         "Organization: Test-Ns",
+		ExternalRefs: []spdx.ExternalRef{
+			{
+				Category: "PACKAGE-MANAGER",
+				// The declared purl is normalized.
+				Locator: "pkg:generic/zlib@1.3.1",
+				Type:    "purl",
+			},
+		},
+	}
+
+	for _, pkgName := range []string{"test-pkg", "test-pkg-dev"} {
+		sbomPath := filepath.Join(tmpDir, pkgName, build.SBOMDir,
+			fmt.Sprintf("%s-%s.spdx.json", pkgName, cfg.Package.FullVersion()))
+
+		var actual spdx.Document
+		data, err := os.ReadFile(sbomPath)
+		if err != nil {
+			t.Fatalf("failed to read SBOM for %s: %v", pkgName, err)
+		}
+		if err := json.Unmarshal(data, &actual); err != nil {
+			t.Fatalf("failed to unmarshal SBOM for %s: %v", pkgName, err)
+		}
+
+		var found *spdx.Package
+		for i := range actual.Packages {
+			if actual.Packages[i].ID == expectedPkg.ID {
+				found = &actual.Packages[i]
+			}
+		}
+		if found == nil {
+			t.Fatalf("%s: extra package %s not found in SBOM", pkgName, expectedPkg.ID)
+		}
+		if diff := cmp.Diff(expectedPkg, *found); diff != "" {
+			t.Errorf("%s: extra package mismatch (-want +got):\n%s", pkgName, diff)
+		}
+
+		wantRel := spdx.Relationship{
+			Element: fmt.Sprintf("SPDXRef-Package-%s-1.2.3-r0", pkgName),
+			Related: expectedPkg.ID,
+			Type:    "CONTAINS",
+		}
+		var hasRel bool
+		for _, rel := range actual.Relationships {
+			if rel == wantRel {
+				hasRel = true
+			}
+		}
+		if !hasRel {
+			t.Errorf("%s: missing relationship %+v in %+v", pkgName, wantRel, actual.Relationships)
+		}
+	}
+}
+
+func TestSBOMGenerationWithSubpackagePurlNamespace(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-pkg",
+			Version: "1.2.3",
+			Epoch:   0,
+		},
+		Subpackages: []config.Subpackage{
+			{Name: "test-pkg-dev"},
+			{Name: "test-pkg-plugin", PurlNamespace: "plugins"},
+		},
+	}
+
+	docs, err := (&Generator{}).GenerateSPDX(ctx, &build.GeneratorContext{
+		Configuration:   cfg,
+		WorkspaceDir:    t.TempDir(),
+		SourceDateEpoch: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Namespace:       "test-ns",
+		Arch:            "x86_64",
+	})
+	if err != nil {
+		t.Fatalf("GenerateSPDX failed: %v", err)
+	}
+
+	for pkgName, want := range map[string]string{
+		"test-pkg":        "pkg:apk/test-ns/test-pkg@1.2.3-r0?arch=x86_64&distro=test-ns",
+		"test-pkg-dev":    "pkg:apk/test-ns/test-pkg-dev@1.2.3-r0?arch=x86_64&distro=test-ns",
+		"test-pkg-plugin": "pkg:apk/plugins/test-pkg-plugin@1.2.3-r0?arch=x86_64&distro=plugins",
+	} {
+		doc := docs[pkgName]
+		var got []string
+		for _, p := range doc.Packages {
+			if p.Name != pkgName {
+				continue
+			}
+			for _, ref := range p.ExternalRefs {
+				if ref.Type == "purl" {
+					got = append(got, ref.Locator)
+				}
+			}
+		}
+		if diff := cmp.Diff([]string{want}, got); diff != "" {
+			t.Errorf("%s: purl mismatch (-want +got):\n%s", pkgName, diff)
+		}
+	}
+}
+
+func TestSBOMGenerationWithPatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	outputFS := apkofs.DirFS(ctx, tmpDir)
+
+	patch := []byte("--- a/main.c\n+++ b/main.c\n")
+	if err := os.WriteFile(filepath.Join(tmpDir, "fix-build.patch"), patch, 0o644); err != nil {
+		t.Fatalf("writing patch: %v", err)
+	}
+	sum := sha256.Sum256(patch)
+
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-pkg",
+			Version: "1.2.3",
+			Epoch:   0,
+			Copyright: []config.Copyright{
+				{License: "MIT"},
+			},
+			SBOM: &config.PackageSBOM{
+				Patches: []config.SBOMPatch{{
+					Path:        "fix-build.patch",
+					Description: "Fix the build with GCC 14",
+				}},
+				DetectPatches: true,
+			},
+		},
+		Pipeline: []config.Pipeline{
+			{
+				Uses: "git-checkout",
+				With: map[string]string{
+					"repository":      "https://github.com/main/repo.git",
+					"tag":             "v1.2.3",
+					"expected-commit": "abc123def456",
+				},
+			},
+			{
+				Uses: "patch",
+				With: map[string]string{
+					// The declared patch is not recorded twice.
+					"patches": "fix-build.patch CVE-2024-1234.patch",
+				},
+			},
+		},
+		Subpackages: []config.Subpackage{
+			{Name: "test-pkg-dev"},
+		},
+	}
+
+	upstreamPkg, err := cfg.Pipeline[0].SBOMPackageForUpstreamSource("MIT", "test-ns", "0")
+	if err != nil {
+		t.Fatalf("creating upstream source package: %v", err)
+	}
+
+	genCtx := &build.GeneratorContext{
+		Configuration:   cfg,
+		WorkspaceDir:    tmpDir,
+		OutputFS:        outputFS,
+		SourceDateEpoch: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Namespace:       "test-ns",
+		Arch:            "x86_64",
+		ReleaseData: &apko_build.ReleaseData{
+			ID:        "test-os",
+			VersionID: "1.0",
+		},
+	}
+
+	gen := &Generator{}
+	if err := gen.GenerateSBOM(ctx, genCtx); err != nil {
+		t.Fatalf("GenerateSBOM failed: %v", err)
+	}
+
+	expectedPkgs := []spdx.Package{
+		{
+			ID:               "SPDXRef-Package-patch-fix-build.patch",
+			Name:             "fix-build.patch",
+			FilesAnalyzed:    false,
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "MIT",
+			Description:      "Fix the build with GCC 14",
+			DownloadLocation: "NOASSERTION",
+			Originator:       "Organization: Test-Ns",
+			Supplier:         "Organization: Test-Ns",
+			PrimaryPurpose:   "FILE",
+			Checksums: []spdx.Checksum{
+				{Algorithm: "SHA256", Value: hex.EncodeToString(sum[:])},
+			},
+		},
+		{
+			// Not in the workspace, so it has no checksum.
+			ID:               "SPDXRef-Package-patch-CVE-2024-1234.patch",
+			Name:             "CVE-2024-1234.patch",
+			FilesAnalyzed:    false,
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "MIT",
+			DownloadLocation: "NOASSERTION",
+			Originator:       "Organization: Test-Ns",
+			Supplier:         "Organization: Test-Ns",
+			PrimaryPurpose:   "FILE",
+		},
+	}
+
+	for _, pkgName := range []string{"test-pkg", "test-pkg-dev"} {
+		sbomPath := filepath.Join(tmpDir, pkgName, build.SBOMDir,
+			fmt.Sprintf("%s-%s.spdx.json", pkgName, cfg.Package.FullVersion()))
+
+		var actual spdx.Document
+		data, err := os.ReadFile(sbomPath)
+		if err != nil {
+			t.Fatalf("failed to read SBOM for %s: %v", pkgName, err)
+		}
+		if err := json.Unmarshal(data, &actual); err != nil {
+			t.Fatalf("failed to unmarshal SBOM for %s: %v", pkgName, err)
+		}
+
+		var patchPkgs []spdx.Package
+		for _, p := range actual.Packages {
+			if p.PrimaryPurpose == "FILE" {
+				patchPkgs = append(patchPkgs, p)
+			}
+		}
+		if diff := cmp.Diff(expectedPkgs, patchPkgs); diff != "" {
+			t.Errorf("%s: patches mismatch (-want +got):\n%s", pkgName, diff)
+		}
+
+		for _, want := range expectedPkgs {
+			wantRel := spdx.Relationship{
+				Element: want.ID,
+				Related: upstreamPkg.ID(),
+				Type:    "PATCH_APPLIED",
+			}
+			var hasRel bool
+			for _, rel := range actual.Relationships {
+				if rel == wantRel {
+					hasRel = true
+				}
+			}
+			if !hasRel {
+				t.Errorf("%s: missing relationship %+v in %+v", pkgName, wantRel, actual.Relationships)
+			}
+		}
+	}
+}
+
+func TestSBOMGenerationConcurrency(t *testing.T) {
+	ctx := context.Background()
+	workspaceDir := t.TempDir()
+	for _, name := range []string{"LICENSE-A", "LICENSE-B"} {
+		if err := os.WriteFile(filepath.Join(workspaceDir, name), []byte(name+" text"), 0o644); err != nil {
+			t.Fatalf("writing license: %v", err)
+		}
+	}
+
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-pkg",
+			Version: "1.2.3",
+			Epoch:   0,
+			Copyright: []config.Copyright{
+				{License: "LicenseRef-A", LicensePath: "LICENSE-A"},
+				{License: "LicenseRef-B", LicensePath: "LICENSE-B"},
+			},
+		},
+		Pipeline: []config.Pipeline{{
+			Uses: "git-checkout",
+			With: map[string]string{
+				"repository":      "https://github.com/main/repo.git",
+				"tag":             "v1.2.3",
+				"expected-commit": "abc123def456",
+			},
+		}},
+	}
+	for i := range 32 {
+		cfg.Subpackages = append(cfg.Subpackages, config.Subpackage{Name: fmt.Sprintf("test-pkg-sub%d", i)})
+	}
+
+	// generate writes the SBOMs of the build with the given concurrency and
+	// returns them by path.
+	generate := func(concurrency int) map[string][]byte {
+		outDir := t.TempDir()
+		gen := &Generator{Concurrency: concurrency}
+		if err := gen.GenerateSBOM(ctx, &build.GeneratorContext{
+			Configuration:   cfg,
+			WorkspaceDir:    workspaceDir,
+			OutputFS:        apkofs.DirFS(ctx, outDir),
+			SourceDateEpoch: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			Namespace:       "test-ns",
+			Arch:            "x86_64",
+		}); err != nil {
+			t.Fatalf("GenerateSBOM failed: %v", err)
+		}
+
+		files := map[string][]byte{}
+		if err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(outDir, path)
+			files[rel] = data
+			return err
+		}); err != nil {
+			t.Fatalf("reading SBOMs: %v", err)
+		}
+		return files
+	}
+
+	serial := generate(1)
+	if got, want := len(serial), len(cfg.Subpackages)+1; got != want {
+		t.Fatalf("got %d SBOMs, want %d", got, want)
+	}
+	for _, concurrency := range []int{0, 4, 64} {
+		if diff := cmp.Diff(serial, generate(concurrency)); diff != "" {
+			t.Errorf("concurrency %d: SBOMs differ from serial generation (-want +got):\n%s", concurrency, diff)
+		}
+	}
+}
+
+func TestForEachPackage(t *testing.T) {
+	ctx := context.Background()
+
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("pkg-%d", i)
+	}
+
+	t.Run("respects the concurrency bound", func(t *testing.T) {
+		const limit = 3
+		var running, peak atomic.Int32
+		seen := make([]bool, len(names))
+		if err := forEachPackage(ctx, names, limit, func(_ context.Context, i int, name string) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			if name != names[i] {
+				return fmt.Errorf("got name %s for index %d", name, i)
+			}
+			seen[i] = true
+			time.Sleep(time.Millisecond)
+			return nil
+		}); err != nil {
+			t.Fatalf("forEachPackage: %v", err)
+		}
+
+		if got := peak.Load(); got > limit {
+			t.Errorf("ran %d calls at once, want at most %d", got, limit)
+		}
+		for i, ok := range seen {
+			if !ok {
+				t.Errorf("%s was not visited", names[i])
+			}
+		}
+	})
+
+	t.Run("returns the first error", func(t *testing.T) {
+		err := forEachPackage(ctx, names, 1, func(_ context.Context, _ int, name string) error {
+			if name == "pkg-7" {
+				return fmt.Errorf("failed %s", name)
+			}
+			return nil
+		})
+		if err == nil || err.Error() != "failed pkg-7" {
+			t.Errorf("got error %v, want failed pkg-7", err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// changedSince returns the absolute paths of the files of the git
+// repository containing dir that differ from the commit ref resolves to:
+// files changed by the commits since ref, and files modified, staged or
+// untracked in the worktree.
+func changedSince(ctx context.Context, dir, ref string) ([]string, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("opening git repository: %w", err)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	since, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("reading commit %s: %w", ref, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("determining HEAD: %w", err)
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("reading HEAD: %w", err)
+	}
+
+	sinceTree, err := since.Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := sinceTree.DiffContext(ctx, headTree)
+	if err != nil {
+		return nil, fmt.Errorf("comparing %s with HEAD: %w", ref, err)
+	}
+
+	names := map[string]bool{}
+	for _, c := range changes {
+		names[c.From.Name] = true
+		names[c.To.Name] = true
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("reading worktree status: %w", err)
+	}
+	for name, s := range status {
+		if s.Worktree != git.Unmodified || s.Staging != git.Unmodified {
+			names[name] = true
+		}
+	}
+	delete(names, "")
+
+	root := resolvePath(wt.Filesystem.Root())
+	files := make([]string, 0, len(names))
+	for name := range names {
+		files = append(files, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// selectChanged returns the configurations of ordered, in order, that are
+// affected by the changed files: those whose configuration file or source
+// directory, as returned by sourceDir, contains a changed file, and those
+// that depend on them. ordered must be in build order.
+func selectChanged(ctx context.Context, ordered []OrderedConfig, changed []string, sourceDir func(OrderedConfig) (string, error)) ([]OrderedConfig, error) {
+	log := clog.FromContext(ctx)
+
+	changedIn := func(path string) bool {
+		path = resolvePath(path)
+		return slices.ContainsFunc(changed, func(f string) bool {
+			return f == path || strings.HasPrefix(f, path+string(filepath.Separator))
+		})
+	}
+
+	selected := map[string]bool{}
+	var configs []OrderedConfig
+	for _, oc := range ordered {
+		if dep := slices.IndexFunc(oc.Dependencies, func(d string) bool { return selected[d] }); dep >= 0 {
+			log.Infof("selecting %s: dependency %s is rebuilt", oc.Package, oc.Dependencies[dep])
+		} else if changedIn(oc.ConfigFile) {
+			log.Infof("selecting %s: %s changed", oc.Package, oc.ConfigFile)
+		} else {
+			dir, err := sourceDir(oc)
+			if err != nil {
+				return nil, err
+			}
+			if dir == "" || !changedIn(dir) {
+				continue
+			}
+			log.Infof("selecting %s: source directory %s changed", oc.Package, dir)
+		}
+		selected[oc.Package] = true
+		configs = append(configs, oc)
+	}
+	return configs, nil
+}
+
+// resolvePath returns the absolute path of p with symlinks resolved, so
+// that paths can be compared with those of git, or p made absolute if it
+// cannot be resolved.
+func resolvePath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	return p
+}
+
+// sinceCommitConfigs narrows ordered, the configurations of a multi-config
+// build, to those affected by the changes since the commit ref, in the git
+// repository of the first configuration.
+func sinceCommitConfigs(ctx context.Context, ordered []OrderedConfig, ref string, newConfig func(ctx context.Context, configFile string) (*BuildConfig, error)) ([]OrderedConfig, error) {
+	if len(ordered) == 0 {
+		return ordered, nil
+	}
+	changed, err := changedSince(ctx, filepath.Dir(ordered[0].ConfigFile), ref)
+	if err != nil {
+		return nil, fmt.Errorf("finding changes since %s: %w", ref, err)
+	}
+
+	selected, err := selectChanged(ctx, ordered, changed, func(oc OrderedConfig) (string, error) {
+		cfg, err := newConfig(ctx, oc.ConfigFile)
+		if err != nil {
+			return "", err
+		}
+		return cfg.SourceDir, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	clog.FromContext(ctx).Infof("building %d of %d configs changed since %s", len(selected), len(ordered), ref)
+	return selected, nil
+}
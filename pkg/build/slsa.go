@@ -17,6 +17,7 @@ package build
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 
 	provenancev1 "github.com/in-toto/attestation/go/predicates/provenance/v1"
 	intoto "github.com/in-toto/attestation/go/v1"
@@ -83,7 +84,7 @@ func (pc *PackageBuild) generateSLSA() ([]byte, error) {
 
 	subject := []*intoto.ResourceDescriptor{
 		{
-			Name: pc.Identity() + ".apk",
+			Name: filepath.Base(pc.Filename()),
 			Digest: map[string]string{
 				"sha256": pc.DataHash,
 			},
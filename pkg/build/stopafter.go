@@ -0,0 +1,72 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// stopAfter replaces the configuration with a copy whose pipelines end
+// after the step named StopAfter, as truncated by stopAfterPipelines. It
+// fails if no step has the name.
+func (b *Build) stopAfter() error {
+	pipelines, subpackages, ok := stopAfterPipelines(b.Configuration.Pipeline, b.Configuration.Subpackages, b.StopAfter)
+	if !ok {
+		return fmt.Errorf("no pipeline step of %s is named %q", b.ConfigFile, b.StopAfter)
+	}
+	cfg := *b.Configuration
+	cfg.Pipeline, cfg.Subpackages = pipelines, subpackages
+	b.Configuration = &cfg
+	return nil
+}
+
+// stopAfterPipelines truncates the pipelines of a build so that it ends
+// after the pipeline step named name. Steps are searched in the order they
+// run: the main pipelines, then the pipelines of each subpackage. The named
+// step runs whole, with any nested steps; everything after it is dropped,
+// including the subpackages that would run later. It reports false, and
+// returns the pipelines unchanged, if no step has the name.
+func stopAfterPipelines(pipelines []config.Pipeline, subpackages []config.Subpackage, name string) ([]config.Pipeline, []config.Subpackage, bool) {
+	if truncated, ok := truncatePipelines(pipelines, name); ok {
+		return truncated, nil, true
+	}
+	for i, sp := range subpackages {
+		if truncated, ok := truncatePipelines(sp.Pipeline, name); ok {
+			kept := slices.Clone(subpackages[:i+1])
+			kept[i].Pipeline = truncated
+			return pipelines, kept, true
+		}
+	}
+	return pipelines, subpackages, false
+}
+
+// truncatePipelines returns a copy of pipelines that ends with the step
+// named name, which may be nested.
+func truncatePipelines(pipelines []config.Pipeline, name string) ([]config.Pipeline, bool) {
+	for i, p := range pipelines {
+		if p.Name == name {
+			return slices.Clone(pipelines[:i+1]), true
+		}
+		if nested, ok := truncatePipelines(p.Pipeline, name); ok {
+			kept := slices.Clone(pipelines[:i+1])
+			kept[i].Pipeline = nested
+			return kept, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestStopAfterPipelines(t *testing.T) {
+	pipelines := []config.Pipeline{
+		{Name: "fetch"},
+		{
+			Name: "build",
+			Pipeline: []config.Pipeline{
+				{Name: "configure"},
+				{Name: "make"},
+			},
+		},
+		{Name: "install"},
+	}
+	subpackages := []config.Subpackage{
+		{Name: "dev", Pipeline: []config.Pipeline{{Name: "split-dev"}, {Name: "strip"}}},
+		{Name: "doc", Pipeline: []config.Pipeline{{Name: "split-doc"}}},
+	}
+
+	names := func(pipelines []config.Pipeline) []string {
+		var out []string
+		var walk func([]config.Pipeline)
+		walk = func(pipelines []config.Pipeline) {
+			for _, p := range pipelines {
+				out = append(out, p.Name)
+				walk(p.Pipeline)
+			}
+		}
+		walk(pipelines)
+		return out
+	}
+
+	t.Run("top-level step", func(t *testing.T) {
+		p, sp, ok := stopAfterPipelines(pipelines, subpackages, "fetch")
+		require.True(t, ok)
+		require.Equal(t, []string{"fetch"}, names(p))
+		require.Empty(t, sp)
+	})
+
+	t.Run("step runs with its nested steps", func(t *testing.T) {
+		p, _, ok := stopAfterPipelines(pipelines, subpackages, "build")
+		require.True(t, ok)
+		require.Equal(t, []string{"fetch", "build", "configure", "make"}, names(p))
+	})
+
+	t.Run("nested step", func(t *testing.T) {
+		p, sp, ok := stopAfterPipelines(pipelines, subpackages, "configure")
+		require.True(t, ok)
+		require.Equal(t, []string{"fetch", "build", "configure"}, names(p))
+		require.Empty(t, sp)
+
+		// The original pipelines are unchanged.
+		require.Equal(t, []string{"fetch", "build", "configure", "make", "install"}, names(pipelines))
+	})
+
+	t.Run("subpackage step", func(t *testing.T) {
+		p, sp, ok := stopAfterPipelines(pipelines, subpackages, "split-dev")
+		require.True(t, ok)
+		require.Equal(t, names(pipelines), names(p))
+		require.Len(t, sp, 1)
+		require.Equal(t, "dev", sp[0].Name)
+		require.Equal(t, []string{"split-dev"}, names(sp[0].Pipeline))
+		require.Equal(t, []string{"split-dev", "strip"}, names(subpackages[0].Pipeline))
+	})
+
+	t.Run("unknown step", func(t *testing.T) {
+		p, sp, ok := stopAfterPipelines(pipelines, subpackages, "nope")
+		require.False(t, ok)
+		require.Equal(t, pipelines, p)
+		require.Equal(t, subpackages, sp)
+	})
+}
+
+func TestBuildStopAfter(t *testing.T) {
+	cfg := &config.Configuration{
+		Package:     config.Package{Name: "hello"},
+		Pipeline:    []config.Pipeline{{Name: "fetch"}, {Name: "build"}},
+		Subpackages: []config.Subpackage{{Name: "hello-dev"}},
+	}
+
+	t.Run("on a copy of the configuration", func(t *testing.T) {
+		b := &Build{Configuration: cfg, StopAfter: "fetch"}
+		require.NoError(t, b.stopAfter())
+		require.Len(t, b.Configuration.Pipeline, 1)
+		require.Empty(t, b.Configuration.Subpackages)
+
+		// The configuration may be shared with builds for other
+		// architectures.
+		require.Len(t, cfg.Pipeline, 2)
+		require.Len(t, cfg.Subpackages, 1)
+	})
+
+	t.Run("unknown step", func(t *testing.T) {
+		b := &Build{Configuration: cfg, ConfigFile: "hello.yaml", StopAfter: "nope"}
+		require.EqualError(t, b.stopAfter(), `no pipeline step of hello.yaml is named "nope"`)
+	})
+}
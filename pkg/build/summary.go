@@ -0,0 +1,166 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dlorenc/melange2/pkg/output"
+)
+
+// Summary collects the outcome of the packages built by a command, across
+// architectures and configurations, so that it can be reported concisely
+// once the builds are done. It is safe for concurrent use.
+type Summary struct {
+	start time.Time
+
+	mu      sync.Mutex
+	entries []SummaryEntry
+}
+
+// SummaryEntry is the outcome of building a package for an architecture.
+type SummaryEntry struct {
+	Arch    string
+	Package string
+	// Failed is set if the build failed. A failed build has an entry for
+	// its main package only.
+	Failed bool
+	// APK is the path of the built package file, if the build succeeded.
+	APK string
+	// Duration is how long the build of the package took.
+	Duration time.Duration
+	// Warnings are the warnings of the build. Only the entry for the main
+	// package has them.
+	Warnings []Warning
+	// Phases are the durations of the phases of the build. Only the entry
+	// for the main package has them.
+	Phases PhaseTimings
+}
+
+// NewSummary creates an empty Summary, timing the builds from now.
+func NewSummary() *Summary {
+	return &Summary{start: time.Now()}
+}
+
+// Add records the outcome of building packages.
+func (s *Summary) Add(entries ...SummaryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entries...)
+}
+
+// Write writes the status of each package, sorted by architecture and
+// package, the warnings of the builds, the durations of their phases, and
+// the total duration of the builds to w.
+func (s *Summary) Write(w io.Writer) error {
+	s.mu.Lock()
+	entries := slices.Clone(s.entries)
+	s.mu.Unlock()
+
+	slices.SortStableFunc(entries, func(a, b SummaryEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Arch, b.Arch),
+			cmp.Compare(a.Package, b.Package),
+		)
+	})
+
+	var built, failed int
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARCH\tPACKAGE\tSTATUS\tDURATION\tAPK")
+	for _, e := range entries {
+		status := "built"
+		if e.Failed {
+			status = "failed"
+			failed++
+		} else {
+			built++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Arch, e.Package, status, e.Duration.Round(time.Second), e.APK)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		for _, warning := range e.Warnings {
+			if _, err := fmt.Fprintf(w, "%s/%s: warning: %s\n", e.Arch, e.Package, warning); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if len(e.Phases) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s/%s: phases: %s\n", e.Arch, e.Package, e.Phases); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d built, %d failed in %s\n", built, failed, time.Since(s.start).Round(time.Second))
+	return err
+}
+
+// summaryEntries returns the outcome of the build for a Summary, given the
+// error it failed with, if any.
+func (b *Build) summaryEntries(err error) []SummaryEntry {
+	arch := b.Arch.ToAPK()
+	duration := time.Since(b.Start)
+	pkg := b.Configuration.Package
+
+	if err != nil {
+		return []SummaryEntry{{
+			Arch:     arch,
+			Package:  pkg.Name,
+			Failed:   true,
+			Duration: duration,
+			Warnings: b.Warnings(),
+			Phases:   b.Phases,
+		}}
+	}
+
+	var entries []SummaryEntry
+	for name := range b.Configuration.AllPackageNames() {
+		entries = append(entries, SummaryEntry{
+			Arch:     arch,
+			Package:  name,
+			APK:      filepath.Join(b.OutDir, arch, output.APKFileName(b.APKNameTemplate, name, pkg.Version, pkg.Epoch, arch)),
+			Duration: duration,
+		})
+	}
+	entries[0].Warnings = b.Warnings()
+	entries[0].Phases = b.Phases
+	return entries
+}
+
+// summaryPackage returns the name a Summary records a build of cfg under
+// when the build could not be set up: that of its package, if the
+// configuration was parsed, or else the name of its file.
+func summaryPackage(cfg *BuildConfig) string {
+	if cfg.Configuration != nil && cfg.Configuration.Package.Name != "" {
+		return cfg.Configuration.Package.Name
+	}
+	return strings.TrimSuffix(filepath.Base(cfg.ConfigFile), filepath.Ext(cfg.ConfigFile))
+}
@@ -18,8 +18,10 @@ import (
 	"context"
 	"fmt"
 	"maps"
+	"net/http"
 	"os"
 	"slices"
+	"time"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	apko_build "chainguard.dev/apko/pkg/build"
@@ -32,7 +34,9 @@ import (
 	"sigs.k8s.io/release-utils/version"
 
 	"github.com/dlorenc/melange2/pkg/buildkit"
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/config"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 	"github.com/dlorenc/melange2/pkg/util"
 )
 
@@ -54,12 +58,19 @@ type TestConfig struct {
 	// PipelineDirs are directories where to find 'uses' pipelines.
 	PipelineDirs []string
 
+	// ErrorOnShadow fails the test when a pipeline of PipelineDirs shadows
+	// a builtin pipeline, rather than warning about it.
+	ErrorOnShadow bool
+
 	// SourceDir is the directory containing source files.
 	SourceDir string
 
 	// CacheDir is the directory for cached inputs.
 	CacheDir string
 
+	// CacheDirReadOnly treats CacheDir as a shared, immutable cache.
+	CacheDirReadOnly bool
+
 	// ApkCacheDir is the directory for cached apk packages.
 	ApkCacheDir string
 
@@ -84,8 +95,28 @@ type TestConfig struct {
 	// IgnoreSignatures indicates whether to ignore repository signature verification.
 	IgnoreSignatures bool
 
+	// UserAgent is sent by the fetch pipeline and with apk index and package
+	// downloads. Defaults to "melange/<version>".
+	UserAgent string
+
+	// Proxy configures the proxies of outbound HTTP requests, which are
+	// also set in the environment of test pipeline steps.
+	Proxy melangehttp.ProxyConfig
+
+	// InheritBuildRepos adds the build environment's repositories and keyring
+	// to the test environments.
+	InheritBuildRepos bool
+
 	// BuildKitAddr is the BuildKit daemon address.
 	BuildKitAddr string
+
+	// BuildKitDialTimeout bounds how long to wait for the BuildKit daemon
+	// to respond when connecting. Zero selects buildkit.DefaultDialTimeout.
+	BuildKitDialTimeout time.Duration
+
+	// BuildKitWorker selects the daemon's worker to build on, by ID or by
+	// worker filter (see buildkit.WorkerFilter). Empty uses the default worker.
+	BuildKitWorker string
 }
 
 // NewTestConfig creates a new TestConfig with sensible defaults.
@@ -143,6 +174,7 @@ func NewTestBuildKitFromConfig(ctx context.Context, cfg *TestConfig) (*TestBuild
 	if cfg.ConfigFile != "" {
 		parsedCfg, err := config.ParseConfiguration(ctx, cfg.ConfigFile,
 			config.WithEnvFileForParsing(cfg.EnvFile),
+			config.WithInheritBuildRepositories(cfg.InheritBuildRepos),
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load configuration: %w", err)
@@ -178,18 +210,21 @@ func (t *TestBuildKit) TestPackage(ctx context.Context) error {
 	pkg := &t.Configuration.Package
 
 	// Check architecture
-	inarchs := len(pkg.TargetArchitecture) == 0
-	for _, ta := range pkg.TargetArchitecture {
-		if apko_types.ParseArchitecture(ta) == t.Config.Arch {
-			inarchs = true
-			break
-		}
+	inarchs, err := targetsArch(pkg.TargetArchitecture, t.Config.Arch.ToAPK())
+	if err != nil {
+		return err
 	}
 	if !inarchs {
 		log.Warnf("skipping test for %s on %s", pkg.Name, t.Config.Arch)
 		return nil
 	}
 
+	extraRepos, err := resolveLocalRepositories(ctx, t.Config.ExtraRepos, t.Config.Arch)
+	if err != nil {
+		return err
+	}
+	t.Config.ExtraRepos = extraRepos
+
 	// Compile the configuration to resolve 'uses' pipelines
 	log.Debugf("evaluating pipelines for test requirements")
 	if err := t.Compile(ctx); err != nil {
@@ -215,23 +250,21 @@ func (t *TestBuildKit) TestPackage(ctx context.Context) error {
 	}
 
 	// Create BuildKit builder
-	builder, err := buildkit.NewBuilder(t.Config.BuildKitAddr)
+	builder, err := buildkit.NewBuilder(t.Config.BuildKitAddr, buildkit.WithDialTimeout(t.Config.BuildKitDialTimeout))
 	if err != nil {
 		return fmt.Errorf("creating buildkit builder: %w", err)
 	}
 	defer builder.Close()
 
-	if t.Config.Debug {
-		builder.WithShowLogs(true)
+	if t.Config.BuildKitWorker != "" {
+		if err := builder.SelectWorker(ctx, t.Config.BuildKitWorker); err != nil {
+			return err
+		}
 	}
 
-	// Build the test environment with apko (with package installed)
-	log.Info("building test environment with apko")
-	layer, cleanup, err := t.buildTestLayer(ctx)
-	if err != nil {
-		return fmt.Errorf("building test layer: %w", err)
+	if t.Config.Debug {
+		builder.WithShowLogs(true)
 	}
-	defer cleanup()
 
 	// Build base environment
 	baseEnv := map[string]string{
@@ -240,6 +273,7 @@ func (t *TestBuildKit) TestPackage(ctx context.Context) error {
 	if t.Configuration.Test != nil {
 		maps.Copy(baseEnv, t.Configuration.Test.Environment.Environment)
 	}
+	withoutProxies(baseEnv, t.Config.Proxy)
 
 	// Build test pipelines
 	var testPipelines []config.Pipeline
@@ -270,15 +304,38 @@ func (t *TestBuildKit) TestPackage(ctx context.Context) error {
 
 	// Configure and run tests
 	testCfg := &buildkit.TestConfig{
-		PackageName:     pkg.Name,
-		Arch:            t.Config.Arch,
-		TestPipelines:   testPipelines,
-		SubpackageTests: subpackageTests,
-		BaseEnv:         baseEnv,
-		SourceDir:       t.Config.SourceDir,
-		WorkspaceDir:    workspaceDir,
-		CacheDir:        t.Config.CacheDir,
-		Debug:           t.Config.Debug,
+		PackageName:      pkg.Name,
+		Arch:             t.Config.Arch,
+		TestPipelines:    testPipelines,
+		SubpackageTests:  subpackageTests,
+		BaseEnv:          baseEnv,
+		SourceDir:        t.Config.SourceDir,
+		WorkspaceDir:     workspaceDir,
+		CacheDir:         t.Config.CacheDir,
+		CacheDirReadOnly: t.Config.CacheDirReadOnly,
+		Debug:            t.Config.Debug,
+		Redact:           t.Configuration.RedactEnvironment,
+		SecretEnv:        t.Config.Proxy.Environment(),
+	}
+
+	// Build the test environment with apko (with package installed)
+	log.Info("building test environment with apko")
+	layer, cleanup, err := t.buildTestLayer(ctx)
+	if err != nil {
+		return fmt.Errorf("building test layer: %w", err)
+	}
+	defer cleanup()
+
+	// With a test matrix, the tests run on each of its base images in
+	// turn, with the test environment copied onto it.
+	if images := t.Configuration.Test.BaseImages(); len(images) > 0 {
+		log.Infof("running tests with BuildKit on %d base images", len(images))
+		if _, err := builder.TestWithImages(ctx, images, []v1.Layer{layer}, testCfg); err != nil {
+			return fmt.Errorf("buildkit test failed: %w", err)
+		}
+
+		log.Info("all tests passed")
+		return nil
 	}
 
 	log.Info("running tests with BuildKit")
@@ -299,9 +356,16 @@ func (t *TestBuildKit) Compile(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if t.Config.UserAgent != "" {
+		sm.Substitutions[config.SubstitutionBuildUserAgent] = t.Config.UserAgent
+	}
 
+	// Warn about each shadowing pipeline once for the whole configuration.
+	shadowed := map[string]bool{}
 	ignore := &Compiled{
-		PipelineDirs: t.Config.PipelineDirs,
+		PipelineDirs:  t.Config.PipelineDirs,
+		ErrorOnShadow: t.Config.ErrorOnShadow,
+		shadowed:      shadowed,
 	}
 
 	// Compile build pipelines (to evaluate but not accumulate deps)
@@ -328,7 +392,9 @@ func (t *TestBuildKit) Compile(ctx context.Context) error {
 		}
 
 		test := &Compiled{
-			PipelineDirs: t.Config.PipelineDirs,
+			PipelineDirs:  t.Config.PipelineDirs,
+			ErrorOnShadow: t.Config.ErrorOnShadow,
+			shadowed:      shadowed,
 		}
 
 		te := &cfg.Subpackages[i].Test.Environment.Contents
@@ -349,7 +415,9 @@ func (t *TestBuildKit) Compile(ctx context.Context) error {
 
 	if cfg.Test != nil {
 		test := &Compiled{
-			PipelineDirs: t.Config.PipelineDirs,
+			PipelineDirs:  t.Config.PipelineDirs,
+			ErrorOnShadow: t.Config.ErrorOnShadow,
+			shadowed:      shadowed,
 		}
 
 		te := &t.Configuration.Test.Environment.Contents
@@ -375,6 +443,18 @@ func (t *TestBuildKit) Compile(ctx context.Context) error {
 	return nil
 }
 
+// testImageConfiguration returns the apko image configuration for the test
+// environment. It is derived from the test block only, so build-only
+// repositories of the build environment never reach the test image.
+func (t *TestBuildKit) testImageConfiguration() apko_types.ImageConfiguration {
+	var imgConfig apko_types.ImageConfiguration
+	if t.Configuration.Test != nil {
+		imgConfig = t.Configuration.Test.Environment
+	}
+	imgConfig.Archs = []apko_types.Architecture{t.Config.Arch}
+	return imgConfig
+}
+
 // buildTestLayer builds the apko image for testing and returns the layer.
 func (t *TestBuildKit) buildTestLayer(ctx context.Context) (v1.Layer, func(), error) {
 	log := clog.FromContext(ctx)
@@ -387,12 +467,7 @@ func (t *TestBuildKit) buildTestLayer(ctx context.Context) (v1.Layer, func(), er
 	}
 	cleanup := func() { os.RemoveAll(tmp) }
 
-	// Get the test environment configuration
-	var imgConfig apko_types.ImageConfiguration
-	if t.Configuration.Test != nil {
-		imgConfig = t.Configuration.Test.Environment
-	}
-	imgConfig.Archs = []apko_types.Architecture{t.Config.Arch}
+	imgConfig := t.testImageConfiguration()
 
 	opts := []apko_build.Option{
 		apko_build.WithImageConfiguration(imgConfig),
@@ -404,6 +479,17 @@ func (t *TestBuildKit) buildTestLayer(ctx context.Context) (v1.Layer, func(), er
 		apko_build.WithTempDir(tmp),
 		apko_build.WithIgnoreSignatures(t.Config.IgnoreSignatures),
 	}
+	if t.Config.UserAgent != "" {
+		opts = append(opts, apko_build.WithTransport(melangehttp.NewUserAgentTransport(http.DefaultTransport, t.Config.UserAgent)))
+	}
+
+	// Keep the apk cache from being evicted while apko fills it.
+	release, err := cache.Acquire(t.Config.ApkCacheDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	defer release()
 
 	guestFS := tarfs.New()
 	bc, err := apko_build.New(ctx, guestFS, opts...)
@@ -64,7 +64,7 @@ func TestConfigurationLoad(t *testing.T) {
 			expected: &config.Configuration{
 				Package: config.Package{
 					Name:      "hello",
-					Version:   "world",
+					Version:   "1.0.0",
 					Resources: &config.Resources{},
 				},
 				Test: &config.Test{
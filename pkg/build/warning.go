@@ -0,0 +1,53 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/chainguard-dev/clog"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// Warning is a problem found parsing or building a package that does not
+// fail the build.
+type Warning = config.Warning
+
+// UnknownRepositoryURL is the ConfigFileRepositoryURL of a build whose
+// configuration is not known to come from a git repository. Builds with it
+// warn that the URL was not provided.
+const UnknownRepositoryURL = "https://unknown/unknown/unknown"
+
+// Warnings returns the warnings of the build so far: those found parsing
+// its configuration, then those of the build itself, in the order they were
+// logged.
+func (b *Build) Warnings() []Warning {
+	var warnings []Warning
+	if b.Configuration != nil {
+		warnings = slices.Clone(b.Configuration.Warnings)
+	}
+	return append(warnings, b.warnings...)
+}
+
+// warn logs a warning of the given kind and records it in the warnings of
+// the build.
+func (b *Build) warn(ctx context.Context, kind, format string, args ...any) {
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...)}
+	clog.FromContext(ctx).Warn(w.Message)
+	b.warnings = append(b.warnings, w)
+}
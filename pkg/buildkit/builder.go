@@ -16,9 +16,13 @@ package buildkit
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,9 +31,9 @@ import (
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/config"
 )
 
@@ -44,16 +48,43 @@ type Builder struct {
 	// ShowLogs enables display of stdout/stderr from build steps.
 	ShowLogs bool
 
+	// progressCallback, if set, receives structured progress updates.
+	progressCallback ProgressCallback
+
+	// sharedClient is set when the client is owned by the caller and must
+	// not be closed with the builder.
+	sharedClient bool
+
+	// workerFilter, if set, restricts every operation to matching workers.
+	workerFilter string
+
+	// offline, if set, rejects builds that need network access.
+	offline bool
+
 	// lastSummary stores the build summary from the most recent build.
 	// Access via GetLastSummary() after BuildWithLayers completes.
 	lastSummary *Summary
+
+	// lastTimings stores the phase timings of the most recent build.
+	lastTimings Timings
+}
+
+// Timings are the durations of the phases of a build run by BuildWithLayers.
+// A phase the build failed before has a zero duration.
+type Timings struct {
+	// Graph is how long loading the layers and constructing the LLB graph
+	// took.
+	Graph time.Duration
+	// Solve is how long solving the graph took, exporting the workspace
+	// included.
+	Solve time.Duration
 }
 
 // NewBuilder creates a new BuildKit builder.
-func NewBuilder(addr string) (*Builder, error) {
-	c, err := New(context.Background(), addr)
+func NewBuilder(addr string, opts ...Option) (*Builder, error) {
+	c, err := New(context.Background(), addr, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to buildkit: %w", err)
+		return nil, err
 	}
 
 	return &Builder{
@@ -65,6 +96,20 @@ func NewBuilder(addr string) (*Builder, error) {
 	}, nil
 }
 
+// NewBuilderWithClient creates a builder that uses an existing connection,
+// so that several builds can share one BuildKit session. Closing the
+// builder leaves the client open; the caller is responsible for closing it.
+func NewBuilderWithClient(c *Client) *Builder {
+	return &Builder{
+		client:       c,
+		loader:       NewImageLoader(""),
+		pipeline:     NewPipelineBuilder(),
+		ProgressMode: ProgressModeAuto,
+		ShowLogs:     false,
+		sharedClient: true,
+	}
+}
+
 // WithProgressMode sets the progress display mode.
 func (b *Builder) WithProgressMode(mode ProgressMode) *Builder {
 	b.ProgressMode = mode
@@ -77,6 +122,25 @@ func (b *Builder) WithShowLogs(show bool) *Builder {
 	return b
 }
 
+// WithProgressCallback sets a callback that receives a VertexStatus for each
+// progress update, in addition to the progress display. Combine with
+// ProgressModeQuiet to receive structured updates only.
+func (b *Builder) WithProgressCallback(cb ProgressCallback) *Builder {
+	b.progressCallback = cb
+	return b
+}
+
+// WithOffline enables or disables offline mode. Offline, a build fails
+// before it is solved if a step downloads from the network, or if it uses a
+// registry that is not on this host, and its steps run without network
+// access. Fetch steps that pin a checksum may still run if a cache
+// directory mirrors their downloads. Tests are not affected.
+func (b *Builder) WithOffline(offline bool) *Builder {
+	b.offline = offline
+	b.pipeline.Offline = offline
+	return b
+}
+
 // WithCacheMounts sets the cache mounts to use for build steps.
 func (b *Builder) WithCacheMounts(mounts []CacheMount) *Builder {
 	b.pipeline.CacheMounts = mounts
@@ -94,17 +158,63 @@ func (b *Builder) WithDefaultCacheMounts() *Builder {
 	return b
 }
 
-// Close closes the BuildKit connection.
+// SelectWorker restricts builds to the daemon's worker matching worker (see
+// WorkerFilter), for daemons that run several workers, e.g. with different
+// snapshotters. It returns an error if no advertised worker matches.
+func (b *Builder) SelectWorker(ctx context.Context, worker string) error {
+	filter, err := b.client.ResolveWorker(ctx, worker)
+	if err != nil {
+		return err
+	}
+	b.workerFilter = filter
+	return nil
+}
+
+// constraints returns the options used to marshal LLB for the given
+// platform, including the worker filter if one was selected.
+func (b *Builder) constraints(platform llb.ConstraintsOpt) []llb.ConstraintsOpt {
+	opts := []llb.ConstraintsOpt{platform}
+	if b.workerFilter != "" {
+		opts = append(opts, llb.Require(b.workerFilter))
+	}
+	return opts
+}
+
+// Close closes the BuildKit connection, unless it is shared.
 func (b *Builder) Close() error {
+	if b.sharedClient {
+		return nil
+	}
 	return b.client.Close()
 }
 
+// checkReadOnlyCacheDir verifies that a read-only cache directory exists.
+// Shared caches are expected to be provisioned (and possibly mounted
+// read-only) ahead of time, so a missing directory is an error rather
+// than something to create.
+func checkReadOnlyCacheDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("read-only cache dir: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("read-only cache dir %s is not a directory", dir)
+	}
+	return nil
+}
+
 // GetLastSummary returns the build summary from the most recent build.
 // Returns nil if no build has been executed yet.
 func (b *Builder) GetLastSummary() *Summary {
 	return b.lastSummary
 }
 
+// GetLastTimings returns the phase timings of the most recent build, whether
+// it failed or not.
+func (b *Builder) GetLastTimings() Timings {
+	return b.lastTimings
+}
+
 // CacheConfig specifies remote cache configuration for BuildKit.
 type CacheConfig struct {
 	// Registry is the registry URL for cache storage.
@@ -134,6 +244,17 @@ type ApkoRegistryConfig struct {
 
 	// Insecure allows connecting to registries over HTTP.
 	Insecure bool
+
+	// Strict fails the build when the registry cannot be used. By default,
+	// the layers are loaded locally via llb.Local() instead.
+	Strict bool
+
+	// SBOM, if set, generates an SBOM for each image pushed to Registry,
+	// which is attached to the image as an OCI referrer.
+	SBOM ImageSBOMFunc
+
+	// UserAgent, if set, is sent with requests to Registry.
+	UserAgent string
 }
 
 // BuildConfig contains configuration for a build.
@@ -164,9 +285,23 @@ type BuildConfig struct {
 	// from the host filesystem into the build.
 	CacheDir string
 
+	// CacheDirReadOnly marks CacheDir as a shared, immutable cache. The
+	// directory must already exist and is never created or written to.
+	CacheDirReadOnly bool
+
 	// Debug enables shell debugging (set -x).
 	Debug bool
 
+	// Redact names environment variables whose values are masked in the
+	// build output, in addition to those with secret-like names.
+	Redact []string
+
+	// SecretEnv holds environment variables set in every pipeline step as
+	// build secrets, such as proxies whose URLs may hold credentials. Their
+	// values are masked in the build output and, not being part of the LLB
+	// graph, do not change its cache keys.
+	SecretEnv map[string]string
+
 	// ExportOnFailure specifies how to export the build environment on failure.
 	// Valid values: "" (disabled), "tarball", "docker", "registry"
 	ExportOnFailure string
@@ -176,6 +311,20 @@ type BuildConfig struct {
 	// For docker/registry: image reference (e.g., "debug:failed")
 	ExportRef string
 
+	// OCILayoutDir, if set, is a directory the output of the build is also
+	// exported to, as an image in an OCI image layout. The directory must
+	// not exist, be empty, or already be a layout.
+	OCILayoutDir string
+
+	// BuildLog, if set, holds the log of this build. Its contents are
+	// embedded in the debug image exported on failure.
+	BuildLog *LogBuffer
+
+	// CrossEmulation checks before building that the daemon supports Arch,
+	// either natively or as an emulated platform, so that a cross-arch
+	// build without QEMU binfmt registration fails early and clearly.
+	CrossEmulation bool
+
 	// CacheConfig specifies remote cache configuration.
 	// If nil or Registry is empty, caching is disabled.
 	CacheConfig *CacheConfig
@@ -189,6 +338,11 @@ type BuildConfig struct {
 	// ImgConfig is the apko image configuration used to generate the layers.
 	// This is used for cache key generation when ApkoRegistryConfig is set.
 	ImgConfig *apko_types.ImageConfiguration
+
+	// ParallelSolve loads the layers while the LLB graph of the pipelines
+	// is constructed, rather than before. The graph does not depend on the
+	// contents of the layers, so the definition solved is the same.
+	ParallelSolve bool
 }
 
 // Build executes a build using BuildKit.
@@ -213,104 +367,33 @@ func (b *Builder) Build(ctx context.Context, layer v1.Layer, cfg *BuildConfig) e
 func (b *Builder) BuildWithLayers(ctx context.Context, layers []v1.Layer, cfg *BuildConfig) error {
 	log := clog.FromContext(ctx)
 
-	// Select and use the appropriate layer loader
-	loader := SelectLayerLoader(cfg, layers, b.loader)
-	loadResult, err := loader.Load(ctx, layers, cfg)
-	if err != nil {
-		return fmt.Errorf("loading layers: %w", err)
-	}
-	defer loadResult.Cleanup()
-
-	state := loadResult.State
-	localDirs := loadResult.LocalDirs
-
-	// Prepare workspace directories
-	state = PrepareWorkspace(state, cfg.PackageName)
-
-	// If we have source files, copy them to the workspace
-	if cfg.SourceDir != "" {
-		// Only mount source directory if it exists
-		if _, err := os.Stat(cfg.SourceDir); err == nil {
-			sourceLocalName := "source"
-			state = CopySourceToWorkspace(state, sourceLocalName)
-			localDirs[sourceLocalName] = cfg.SourceDir
+	if cfg.CrossEmulation {
+		emulated, err := b.checkCrossEmulation(ctx, cfg.Arch)
+		if err != nil {
+			return err
 		}
-	}
-
-	// If we have a cache directory, copy it to /var/cache/melange
-	if cfg.CacheDir != "" {
-		log.Infof("copying cache from %s to %s", cfg.CacheDir, DefaultCacheDir)
-		state = CopyCacheToWorkspace(state, CacheLocalName)
-		localDirs[CacheLocalName] = cfg.CacheDir
-	}
-
-	// Create subpackage output directories
-	for _, sp := range cfg.Subpackages {
-		state = state.File(
-			llb.Mkdir(WorkspaceOutputDir(sp.Name), 0755,
-				llb.WithParents(true),
-			),
-			llb.WithCustomName(fmt.Sprintf("create output directory for %s", sp.Name)),
-		)
-	}
-
-	// Configure the pipeline builder
-	b.pipeline.Debug = cfg.Debug
-	if cfg.BaseEnv != nil {
-		b.pipeline.BaseEnv = MergeEnv(b.pipeline.BaseEnv, cfg.BaseEnv)
-	}
-
-	// Helper to export debug image on failure
-	exportOnFailure := func(lastGoodState llb.State, pipelineErr error, context string) error {
-		if cfg.ExportOnFailure == "" {
-			return fmt.Errorf("%s: %w", context, pipelineErr)
-		}
-
-		log.Warnf("build failed at %s, exporting debug image...", context)
-		exportCfg := &ExportConfig{
-			Type:      ExportType(cfg.ExportOnFailure),
-			Ref:       cfg.ExportRef,
-			Arch:      cfg.Arch,
-			LocalDirs: localDirs,
+		if emulated {
+			log.Infof("building %s under emulation", cfg.Arch.ToAPK())
 		}
-		if exportErr := b.ExportDebugImage(ctx, lastGoodState, exportCfg); exportErr != nil {
-			log.Errorf("failed to export debug image: %v", exportErr)
-		}
-		return fmt.Errorf("%s: %w", context, pipelineErr)
 	}
 
-	// Run main pipelines with recovery support
-	log.Info("running main pipelines")
-	result := b.pipeline.BuildPipelinesWithRecovery(state, cfg.Pipelines)
-	if result.Error != nil {
-		return exportOnFailure(result.State, result.Error, "building main pipelines")
+	b.lastTimings = Timings{}
+	graphStart := time.Now()
+	g, err := b.buildGraph(ctx, SelectLayerLoader(cfg, layers, b.loader), layers, cfg)
+	b.lastTimings.Graph = time.Since(graphStart)
+	if err != nil {
+		return err
 	}
-	state = result.State
+	defer g.cleanup()
+	def, localDirs, redactor := g.def, g.localDirs, g.redactor
 
-	// Run subpackage pipelines
-	for _, sp := range cfg.Subpackages {
-		log.Infof("running pipelines for subpackage %s", sp.Name)
-		result := b.pipeline.BuildPipelinesWithRecovery(state, sp.Pipeline)
-		if result.Error != nil {
-			return exportOnFailure(result.State, result.Error, fmt.Sprintf("building subpackage %s pipelines", sp.Name))
+	// Keep cache gc from evicting what the build copies from the cache.
+	if cfg.CacheDir != "" {
+		release, err := cache.Acquire(cfg.CacheDir)
+		if err != nil {
+			return err
 		}
-		state = result.State
-	}
-
-	// Export the workspace
-	log.Info("exporting workspace")
-	exportState := ExportWorkspace(state)
-
-	// Marshal to LLB definition
-	ociPlatform := cfg.Arch.ToOCIPlatform()
-	platform := llb.Platform(ocispecs.Platform{
-		OS:           ociPlatform.OS,
-		Architecture: ociPlatform.Architecture,
-		Variant:      ociPlatform.Variant,
-	})
-	def, err := exportState.Marshal(ctx, platform)
-	if err != nil {
-		return fmt.Errorf("marshaling LLB: %w", err)
+		defer release()
 	}
 
 	// Ensure output directory exists
@@ -323,8 +406,14 @@ func (b *Builder) BuildWithLayers(ctx context.Context, layers []v1.Layer, cfg *B
 		return fmt.Errorf("creating melange-out dir: %w", err)
 	}
 
+	if cfg.OCILayoutDir != "" {
+		if err := ValidateOCILayout(cfg.OCILayoutDir); err != nil {
+			return err
+		}
+	}
+
 	// Create progress writer
-	progress := NewProgressWriter(os.Stderr, b.ProgressMode, b.ShowLogs)
+	progress := NewProgressWriter(os.Stderr, b.ProgressMode, b.ShowLogs).WithCallback(b.progressCallback).WithRedactor(redactor)
 
 	// Solve and export with progress tracking
 	log.Info("solving build graph")
@@ -342,10 +431,8 @@ func (b *Builder) BuildWithLayers(ctx context.Context, layers []v1.Layer, cfg *B
 	eg.Go(func() error {
 		solveOpt := client.SolveOpt{
 			LocalDirs: localDirs,
-			Exports: []client.ExportEntry{{
-				Type:      client.ExporterLocal,
-				OutputDir: melangeOutDir,
-			}},
+			Exports:   workspaceExports(melangeOutDir, cfg),
+			Session:   secretEnvSession(cfg.SecretEnv),
 		}
 
 		// Track if cache export is enabled for retry logic
@@ -406,13 +493,15 @@ func (b *Builder) BuildWithLayers(ctx context.Context, layers []v1.Layer, cfg *B
 		return err
 	})
 
-	if err := eg.Wait(); err != nil {
+	err = eg.Wait()
+	solveDuration := time.Since(solveStart)
+	b.lastTimings.Solve = solveDuration
+	if err != nil {
 		// Capture summary even on failure for diagnostics
 		summary := progress.GetSummary()
 		b.lastSummary = &summary
-		return fmt.Errorf("solving build: %w", err)
+		return fmt.Errorf("solving build: %w", redactor.RedactError(err))
 	}
-	solveDuration := time.Since(solveStart)
 	log.Infof("graph_solve took %s", solveDuration)
 
 	// Capture build summary with step timing
@@ -423,6 +512,176 @@ func (b *Builder) BuildWithLayers(ctx context.Context, layers []v1.Layer, cfg *B
 	return nil
 }
 
+// buildGraph is the marshaled LLB graph of a build.
+type buildGraph struct {
+	def       *llb.Definition
+	localDirs map[string]string
+	redactor  *Redactor
+
+	// cleanup releases the loaded layers once the graph has been solved.
+	cleanup func()
+}
+
+// buildGraph loads the layers with loader and constructs the LLB graph of
+// the build upon them. With cfg.ParallelSolve, the layers are loaded while
+// the graph is constructed, joining before it is marshaled; the definition
+// is the same either way.
+func (b *Builder) buildGraph(ctx context.Context, loader LayerLoader, layers []v1.Layer, cfg *BuildConfig) (_ *buildGraph, err error) {
+	log := clog.FromContext(ctx)
+
+	if b.offline {
+		if err := CheckOffline(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	load := startLayerLoad(ctx, loader, layers, cfg)
+	defer func() {
+		if err != nil {
+			load.cleanup()
+		}
+	}()
+
+	localDirs := map[string]string{}
+	joined := false
+	join := func() error {
+		result, err := load.wait()
+		if err != nil {
+			return fmt.Errorf("loading layers: %w", err)
+		}
+		if !joined {
+			maps.Copy(localDirs, result.LocalDirs)
+			joined = true
+		}
+		return nil
+	}
+
+	var state llb.State
+	if cfg.ParallelSolve {
+		log.Info("constructing build graph while loading layers")
+		state = load.state()
+	} else {
+		if err := join(); err != nil {
+			return nil, err
+		}
+		state = load.result.State
+	}
+
+	// Prepare workspace directories
+	state = PrepareWorkspace(state, cfg.PackageName)
+
+	// If we have source files, copy them to the workspace
+	if cfg.SourceDir != "" {
+		// Only mount source directory if it exists
+		if _, err := os.Stat(cfg.SourceDir); err == nil {
+			sourceLocalName := "source"
+			state = CopySourceToWorkspace(state, sourceLocalName)
+			localDirs[sourceLocalName] = cfg.SourceDir
+		}
+	}
+
+	// If we have a cache directory, copy it to /var/cache/melange
+	if cfg.CacheDir != "" {
+		if cfg.CacheDirReadOnly {
+			if err := checkReadOnlyCacheDir(cfg.CacheDir); err != nil {
+				return nil, err
+			}
+			log.Infof("copying read-only cache from %s to %s", cfg.CacheDir, DefaultCacheDir)
+		} else {
+			log.Infof("copying cache from %s to %s", cfg.CacheDir, DefaultCacheDir)
+		}
+		state = CopyCacheToWorkspace(state, CacheLocalName)
+		localDirs[CacheLocalName] = cfg.CacheDir
+	}
+
+	// Create subpackage output directories
+	for _, sp := range cfg.Subpackages {
+		state = state.File(
+			llb.Mkdir(WorkspaceOutputDir(sp.Name), 0755,
+				llb.WithParents(true),
+			),
+			llb.WithCustomName(fmt.Sprintf("create output directory for %s", sp.Name)),
+		)
+	}
+
+	// Configure the pipeline builder
+	b.pipeline.Debug = cfg.Debug
+	if cfg.BaseEnv != nil {
+		b.pipeline.BaseEnv = MergeEnv(b.pipeline.BaseEnv, cfg.BaseEnv)
+	}
+	b.pipeline.SecretEnv = slices.Sorted(maps.Keys(cfg.SecretEnv))
+	redactor := newSecretEnvRedactor(cfg.Redact, cfg.SecretEnv)
+	b.pipeline.Redactor = redactor
+
+	// Helper to export debug image on failure
+	exportOnFailure := func(lastGoodState llb.State, pipelineErr error, context string) error {
+		// The debug image needs the layers, and a failure to load them
+		// comes first.
+		if err := join(); err != nil {
+			return err
+		}
+		if cfg.ExportOnFailure == "" {
+			return fmt.Errorf("%s: %w", context, pipelineErr)
+		}
+
+		log.Warnf("build failed at %s, exporting debug image...", context)
+		exportCfg := &ExportConfig{
+			Type:      ExportType(cfg.ExportOnFailure),
+			Ref:       cfg.ExportRef,
+			Arch:      cfg.Arch,
+			LocalDirs: localDirs,
+			SecretEnv: cfg.SecretEnv,
+		}
+		if cfg.BuildLog != nil {
+			exportCfg.BuildLog = cfg.BuildLog.Bytes()
+		}
+		if exportErr := b.ExportDebugImage(ctx, lastGoodState, exportCfg); exportErr != nil {
+			log.Errorf("failed to export debug image: %v", exportErr)
+		}
+		return fmt.Errorf("%s: %w", context, pipelineErr)
+	}
+
+	// Run main pipelines with recovery support
+	log.Info("running main pipelines")
+	result := b.pipeline.BuildPipelinesWithRecovery(state, cfg.Pipelines)
+	if result.Error != nil {
+		return nil, exportOnFailure(result.State, result.Error, "building main pipelines")
+	}
+	state = result.State
+
+	// Run subpackage pipelines
+	for _, sp := range cfg.Subpackages {
+		log.Infof("running pipelines for subpackage %s", sp.Name)
+		result := b.pipeline.BuildPipelinesWithRecovery(state, sp.Pipeline)
+		if result.Error != nil {
+			return nil, exportOnFailure(result.State, result.Error, fmt.Sprintf("building subpackage %s pipelines", sp.Name))
+		}
+		state = result.State
+	}
+
+	// Export the workspace
+	log.Info("exporting workspace")
+	exportState := ExportWorkspace(state)
+
+	if err := join(); err != nil {
+		return nil, err
+	}
+
+	// Marshal to LLB definition
+	platform := llbPlatform(cfg.Arch)
+	def, err := exportState.Marshal(ctx, b.constraints(platform)...)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling LLB: %w", err)
+	}
+
+	return &buildGraph{
+		def:       def,
+		localDirs: localDirs,
+		redactor:  redactor,
+		cleanup:   load.cleanup,
+	}, nil
+}
+
 // TestConfig contains configuration for running tests.
 type TestConfig struct {
 	// PackageName is the name of the package being tested.
@@ -452,8 +711,19 @@ type TestConfig struct {
 	// CacheDir is the host directory to mount at /var/cache/melange.
 	CacheDir string
 
+	// CacheDirReadOnly marks CacheDir as a shared, immutable cache.
+	CacheDirReadOnly bool
+
 	// Debug enables shell debugging (set -x).
 	Debug bool
+
+	// Redact names environment variables whose values are masked in the
+	// test output, in addition to those with secret-like names.
+	Redact []string
+
+	// SecretEnv holds environment variables set in every test step as
+	// build secrets, as BuildConfig.SecretEnv does for builds.
+	SecretEnv map[string]string
 }
 
 // SubpackageTestConfig contains test configuration for a single subpackage.
@@ -570,8 +840,19 @@ func (b *Builder) runTestPipelinesWithProvider(ctx context.Context, provider Tes
 
 	// Copy cache directory if provided
 	if cfg.CacheDir != "" {
+		if cfg.CacheDirReadOnly {
+			if err := checkReadOnlyCacheDir(cfg.CacheDir); err != nil {
+				return err
+			}
+		}
 		state = CopyCacheToWorkspace(state, CacheLocalName)
 		localDirs[CacheLocalName] = cfg.CacheDir
+
+		release, err := cache.Acquire(cfg.CacheDir)
+		if err != nil {
+			return err
+		}
+		defer release()
 	}
 
 	// Configure pipeline builder for this test run
@@ -581,6 +862,8 @@ func (b *Builder) runTestPipelinesWithProvider(ctx context.Context, provider Tes
 		pipelineBuilder.BaseEnv = MergeEnv(pipelineBuilder.BaseEnv, cfg.BaseEnv)
 	}
 	pipelineBuilder.CacheMounts = b.pipeline.CacheMounts
+	pipelineBuilder.SecretEnv = slices.Sorted(maps.Keys(cfg.SecretEnv))
+	pipelineBuilder.Redactor = newSecretEnvRedactor(cfg.Redact, cfg.SecretEnv)
 
 	// Run test pipelines (merged into single LLB Run for process state persistence)
 	// This maintains background processes between steps while isolating env vars
@@ -609,13 +892,8 @@ func (b *Builder) runTestPipelinesWithProvider(ctx context.Context, provider Tes
 	)
 
 	// Marshal to LLB definition
-	ociPlatform := cfg.Arch.ToOCIPlatform()
-	platform := llb.Platform(ocispecs.Platform{
-		OS:           ociPlatform.OS,
-		Architecture: ociPlatform.Architecture,
-		Variant:      ociPlatform.Variant,
-	})
-	def, err := exportState.Marshal(ctx, platform)
+	platform := llbPlatform(cfg.Arch)
+	def, err := exportState.Marshal(ctx, b.constraints(platform)...)
 	if err != nil {
 		return fmt.Errorf("marshaling LLB: %w", err)
 	}
@@ -631,7 +909,7 @@ func (b *Builder) runTestPipelinesWithProvider(ctx context.Context, provider Tes
 	}
 
 	// Create progress writer
-	progress := NewProgressWriter(os.Stderr, b.ProgressMode, b.ShowLogs)
+	progress := NewProgressWriter(os.Stderr, b.ProgressMode, b.ShowLogs).WithCallback(b.progressCallback).WithRedactor(pipelineBuilder.Redactor)
 
 	// Solve and export
 	statusCh := make(chan *client.SolveStatus)
@@ -648,12 +926,13 @@ func (b *Builder) runTestPipelinesWithProvider(ctx context.Context, provider Tes
 				Type:      client.ExporterLocal,
 				OutputDir: testResultsDir,
 			}},
+			Session: secretEnvSession(cfg.SecretEnv),
 		}, statusCh)
 		return err
 	})
 
 	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("test execution failed: %w", err)
+		return fmt.Errorf("test execution failed: %w", pipelineBuilder.Redactor.RedactError(err))
 	}
 
 	return nil
@@ -667,6 +946,59 @@ func (b *Builder) TestWithImage(ctx context.Context, imageRef string, cfg *TestC
 	return b.testWithProvider(ctx, provider, cfg)
 }
 
+// ImageTestResult is the outcome of the tests on one base image of a test
+// matrix.
+type ImageTestResult struct {
+	// Image is the reference of the base image.
+	Image string
+
+	// Err is why the tests failed on the image, or nil if they passed.
+	Err error
+}
+
+// TestWithImages executes the tests once on each of imageRefs, with layers,
+// the test environment, copied onto the image, and returns the result on
+// each in the same order. The tests run on every image even after they fail
+// on one, and the results of each are exported to a subdirectory of
+// cfg.WorkspaceDir named after the image's position in imageRefs. The error
+// reports every image the tests failed on.
+func (b *Builder) TestWithImages(ctx context.Context, imageRefs []string, layers []v1.Layer, cfg *TestConfig) ([]ImageTestResult, error) {
+	return testMatrix(ctx, imageRefs, func(ctx context.Context, i int, imageRef string) error {
+		imageCfg := *cfg
+		imageCfg.WorkspaceDir = filepath.Join(cfg.WorkspaceDir, "matrix", strconv.Itoa(i))
+		return b.testWithProvider(ctx, NewLayeredImageTestStateProvider(imageRef, layers, b.loader), &imageCfg)
+	})
+}
+
+// testMatrix calls test for each of imageRefs in turn, and returns the
+// results and an error joining the failures, if any.
+func testMatrix(ctx context.Context, imageRefs []string, test func(ctx context.Context, i int, imageRef string) error) ([]ImageTestResult, error) {
+	log := clog.FromContext(ctx)
+
+	results := make([]ImageTestResult, 0, len(imageRefs))
+	var errs []error
+	for i, ref := range imageRefs {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		log.Infof("running tests on base image %s (%d of %d)", ref, i+1, len(imageRefs))
+		err := test(ctx, i, ref)
+		results = append(results, ImageTestResult{Image: ref, Err: err})
+		if err != nil {
+			log.Errorf("tests failed on base image %s: %v", ref, err)
+			errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+			continue
+		}
+		log.Infof("tests passed on base image %s", ref)
+	}
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("tests failed on %d of %d base images: %w", len(errs), len(imageRefs), errors.Join(errs...))
+	}
+	return results, nil
+}
+
 // isCacheExportError detects if an error is related to cache export.
 // This includes registry connection issues (connection reset, broken pipe, EOF)
 // that occur during the "exporting cache" phase.
@@ -16,13 +16,19 @@ package buildkit
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dlorenc/melange2/pkg/config"
@@ -342,6 +348,103 @@ test "$TEST_VAR" = "test_value"
 	require.NoError(t, err)
 }
 
+// TestTestWithImagesIntegration tests that TestWithImages runs the tests on
+// each base image, with the test environment copied onto it, and reports the
+// result on each.
+func TestTestWithImagesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	bk := startBuildKitContainer(t, ctx)
+
+	builder, err := NewBuilder(bk.Addr)
+	require.NoError(t, err)
+	defer builder.Close()
+
+	workspaceDir := t.TempDir()
+
+	cfg := &TestConfig{
+		PackageName:  "matrix-pkg",
+		Arch:         apko_types.ParseArchitecture("amd64"),
+		WorkspaceDir: workspaceDir,
+		TestPipelines: []config.Pipeline{
+			{
+				Name: "needs-apk",
+				Runs: "test -f /usr/share/matrix-pkg/installed && command -v apk",
+			},
+		},
+	}
+	layer := createTestLayer(t, map[string][]byte{
+		"usr/share/matrix-pkg/installed": []byte("yes\n"),
+	})
+
+	busybox := "cgr.dev/chainguard/busybox:latest"
+	results, err := builder.TestWithImages(ctx, []string{TestBaseImage, busybox}, []v1.Layer{layer}, cfg)
+	require.ErrorContains(t, err, "tests failed on 1 of 2 base images")
+	require.ErrorContains(t, err, busybox)
+	require.Len(t, results, 2)
+	require.Equal(t, TestBaseImage, results[0].Image)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, busybox, results[1].Image)
+	require.Error(t, results[1].Err)
+
+	// The results of each image are exported apart.
+	content, err := os.ReadFile(filepath.Join(workspaceDir, "matrix", "0", "test-results", "matrix-pkg", "status.txt"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "PASSED")
+}
+
+func TestTestMatrix(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	t.Run("runs the tests on each image", func(t *testing.T) {
+		var ran []string
+		results, err := testMatrix(ctx, []string{"glibc:latest", "musl:latest"}, func(_ context.Context, i int, ref string) error {
+			require.Equal(t, len(ran), i)
+			ran = append(ran, ref)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"glibc:latest", "musl:latest"}, ran)
+		require.Equal(t, []ImageTestResult{
+			{Image: "glibc:latest"},
+			{Image: "musl:latest"},
+		}, results)
+	})
+
+	t.Run("aggregates failures", func(t *testing.T) {
+		errMusl := errors.New("missing libc symbol")
+		var ran int
+		results, err := testMatrix(ctx, []string{"musl:latest", "glibc:latest"}, func(_ context.Context, _ int, ref string) error {
+			ran++
+			if ref == "musl:latest" {
+				return errMusl
+			}
+			return nil
+		})
+		// A failure does not stop the tests on the other images.
+		require.Equal(t, 2, ran)
+		require.ErrorIs(t, err, errMusl)
+		require.EqualError(t, err, "tests failed on 1 of 2 base images: musl:latest: missing libc symbol")
+		require.Equal(t, []ImageTestResult{
+			{Image: "musl:latest", Err: errMusl},
+			{Image: "glibc:latest"},
+		}, results)
+	})
+
+	t.Run("stops with the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		results, err := testMatrix(ctx, []string{"a", "b"}, func(context.Context, int, string) error {
+			cancel()
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Len(t, results, 1)
+	})
+}
+
 // TestBuilderWithRealImage uses a real alpine image to test the full flow
 func TestBuilderWithRealImage(t *testing.T) {
 	if testing.Short() {
@@ -406,3 +509,176 @@ echo "hello" > /home/build/melange-out/test-pkg/result.txt
 	require.NoError(t, err)
 	require.Contains(t, string(content), "hello")
 }
+
+func TestCheckReadOnlyCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, checkReadOnlyCacheDir(dir))
+
+	err := checkReadOnlyCacheDir(filepath.Join(dir, "missing"))
+	require.ErrorContains(t, err, "read-only cache dir")
+	require.NoDirExists(t, filepath.Join(dir, "missing"))
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	require.ErrorContains(t, checkReadOnlyCacheDir(file), "is not a directory")
+}
+
+func TestBuilderConstraintsWorkerFilter(t *testing.T) {
+	state := llb.Image(TestBaseImage).Run(llb.Args([]string{"true"})).Root()
+
+	filters := func(t *testing.T, b *Builder) [][]string {
+		t.Helper()
+		def, err := state.Marshal(context.Background(), b.constraints(llb.LinuxAmd64)...)
+		require.NoError(t, err)
+
+		var got [][]string
+		for _, dt := range def.Def {
+			var op pb.Op
+			require.NoError(t, op.Unmarshal(dt))
+			if op.GetOp() == nil {
+				continue // the terminal op carries no constraints
+			}
+			got = append(got, op.GetConstraints().GetFilter())
+		}
+		require.NotEmpty(t, got)
+		return got
+	}
+
+	t.Run("default worker", func(t *testing.T) {
+		for _, f := range filters(t, &Builder{}) {
+			require.Empty(t, f)
+		}
+	})
+
+	t.Run("selected worker", func(t *testing.T) {
+		for _, f := range filters(t, &Builder{workerFilter: "id==worker-1"}) {
+			require.Equal(t, []string{"id==worker-1"}, f)
+		}
+	})
+}
+
+func TestWorkerFilter(t *testing.T) {
+	require.Equal(t, "id==abc123", WorkerFilter("abc123"))
+	require.Equal(t, `labels."org.mobyproject.buildkit.worker.snapshotter"==overlayfs`,
+		WorkerFilter(`labels."org.mobyproject.buildkit.worker.snapshotter"==overlayfs`))
+}
+
+func TestBuilderSelectWorker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	bk := startBuildKitContainer(t, ctx)
+
+	builder, err := NewBuilder(bk.Addr)
+	require.NoError(t, err)
+	defer builder.Close()
+
+	workers, err := builder.client.Client().ListWorkers(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, workers)
+
+	require.NoError(t, builder.SelectWorker(ctx, workers[0].ID))
+	require.Equal(t, "id=="+workers[0].ID, builder.workerFilter)
+
+	err = builder.SelectWorker(ctx, "no-such-worker")
+	require.ErrorContains(t, err, "no buildkit worker")
+	require.ErrorContains(t, err, workers[0].ID)
+}
+
+// slowLayerLoader loads a fixed image after a delay, standing in for the
+// extraction of apko layers.
+type slowLayerLoader struct {
+	delay time.Duration
+	err   error
+}
+
+func (l slowLayerLoader) Load(context.Context, []v1.Layer, *BuildConfig) (*LayerLoadResult, error) {
+	time.Sleep(l.delay)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &LayerLoadResult{
+		State:     llb.Image(TestBaseImage),
+		LocalDirs: map[string]string{"apko-layer-0": "/tmp/layer-0"},
+		Cleanup:   func() {},
+	}, nil
+}
+
+// graphTestConfig returns the configuration of a build with many pipelines.
+// It has no source or cache directory, as llb.Local makes every definition
+// it is part of unique.
+func graphTestConfig(t testing.TB, parallel bool) *BuildConfig {
+	var pipelines []config.Pipeline
+	for i := range 50 {
+		pipelines = append(pipelines, config.Pipeline{
+			Name: fmt.Sprintf("step %d", i),
+			Runs: fmt.Sprintf("echo %d > /home/build/step-%d", i, i),
+		})
+	}
+	return &BuildConfig{
+		PackageName: "test-pkg",
+		Arch:        apko_types.ParseArchitecture("x86_64"),
+		Pipelines:   pipelines,
+		Subpackages: []config.Subpackage{{
+			Name:     "test-pkg-doc",
+			Pipeline: []config.Pipeline{{Runs: "mkdir -p ${{targets.subpkgdir}}/usr/share/doc"}},
+		}},
+		BaseEnv:       map[string]string{"FOO": "bar"},
+		WorkspaceDir:  t.TempDir(),
+		ParallelSolve: parallel,
+	}
+}
+
+func TestBuildGraphParallelSolve(t *testing.T) {
+	ctx := slogtest.Context(t)
+	loader := slowLayerLoader{delay: 10 * time.Millisecond}
+
+	cfg := graphTestConfig(t, false)
+	serial, err := (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, loader, nil, cfg)
+	require.NoError(t, err)
+	defer serial.cleanup()
+
+	cfg.ParallelSolve = true
+	parallel, err := (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, loader, nil, cfg)
+	require.NoError(t, err)
+	defer parallel.cleanup()
+
+	require.Equal(t, serial.def.Def, parallel.def.Def)
+	require.Equal(t, map[string]string{"apko-layer-0": "/tmp/layer-0"}, parallel.localDirs)
+	require.Equal(t, serial.localDirs, parallel.localDirs)
+
+	t.Run("load error", func(t *testing.T) {
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, slowLayerLoader{err: errors.New("no space left on device")}, nil, cfg)
+		require.ErrorContains(t, err, "loading layers: no space left on device")
+	})
+
+	t.Run("pipeline error", func(t *testing.T) {
+		cfg := graphTestConfig(t, true)
+		cfg.Pipelines = append(cfg.Pipelines, config.Pipeline{
+			Name:       "nothing",
+			Assertions: &config.PipelineAssertions{RequiredSteps: 1},
+		})
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, loader, nil, cfg)
+		require.ErrorContains(t, err, "building main pipelines")
+	})
+}
+
+func BenchmarkBuildGraph(b *testing.B) {
+	ctx := context.Background()
+	loader := slowLayerLoader{delay: 5 * time.Millisecond}
+
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%t", parallel), func(b *testing.B) {
+			cfg := graphTestConfig(b, parallel)
+			for b.Loop() {
+				g, err := (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, loader, nil, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				g.cleanup()
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureLog(t *testing.T) {
+	var out bytes.Buffer
+	ctx := clog.WithLogger(context.Background(),
+		clog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	ctx, buf := CaptureLog(ctx)
+	log := clog.FromContext(ctx)
+	log.Debugf("resolving %s", "busybox")
+	log.With("arch", "x86_64").Infof("running main pipelines")
+
+	// The original logger still receives records at its own level.
+	require.Contains(t, out.String(), "running main pipelines")
+	require.NotContains(t, out.String(), "resolving busybox")
+
+	// The capture receives everything, including debug records and attrs.
+	captured := string(buf.Bytes())
+	require.Contains(t, captured, "resolving busybox")
+	require.Contains(t, captured, "running main pipelines")
+	require.Contains(t, captured, "arch=x86_64")
+}
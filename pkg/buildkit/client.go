@@ -20,6 +20,8 @@ package buildkit
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/moby/buildkit/client"
 )
@@ -27,6 +29,10 @@ import (
 const (
 	// DefaultAddr is the default BuildKit daemon address.
 	DefaultAddr = "tcp://localhost:1234"
+
+	// DefaultDialTimeout is how long New waits for BuildKit to respond
+	// before giving up on the connection.
+	DefaultDialTimeout = 10 * time.Second
 )
 
 // Client wraps the BuildKit client with melange-specific functionality.
@@ -35,18 +41,52 @@ type Client struct {
 	addr string
 }
 
+// clientOptions holds optional settings for New.
+type clientOptions struct {
+	dialTimeout time.Duration
+}
+
+// Option configures a Client.
+type Option func(*clientOptions)
+
+// WithDialTimeout sets how long New waits for BuildKit to respond.
+// A zero or negative value selects DefaultDialTimeout.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *clientOptions) {
+		if d > 0 {
+			o.dialTimeout = d
+		}
+	}
+}
+
 // New creates a new BuildKit client connected to the specified address.
 // If addr is empty, DefaultAddr is used.
-func New(ctx context.Context, addr string) (*Client, error) {
+//
+// The underlying gRPC connection is established lazily, so New performs a
+// round-trip to the daemon and fails if it does not answer within the dial
+// timeout (DefaultDialTimeout unless overridden with WithDialTimeout).
+func New(ctx context.Context, addr string, opts ...Option) (*Client, error) {
 	if addr == "" {
 		addr = DefaultAddr
 	}
 
+	o := clientOptions{dialTimeout: DefaultDialTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	bk, err := client.New(ctx, addr)
 	if err != nil {
 		return nil, fmt.Errorf("connecting to buildkit at %s: %w", addr, err)
 	}
 
+	dctx, cancel := context.WithTimeout(ctx, o.dialTimeout)
+	defer cancel()
+	if _, err := bk.ListWorkers(dctx); err != nil {
+		bk.Close()
+		return nil, fmt.Errorf("connecting to buildkit at %s: %w", addr, err)
+	}
+
 	return &Client{
 		bk:   bk,
 		addr: addr,
@@ -82,3 +122,38 @@ func (c *Client) Ping(ctx context.Context) error {
 func (c *Client) Client() *client.Client {
 	return c.bk
 }
+
+// WorkerFilter returns the BuildKit worker filter that selects worker. A
+// plain value selects the worker with that ID; a value containing a filter
+// operator, such as
+// labels."org.mobyproject.buildkit.worker.snapshotter"==overlayfs, is used
+// as is.
+func WorkerFilter(worker string) string {
+	if strings.ContainsAny(worker, "=!~") {
+		return worker
+	}
+	return "id==" + worker
+}
+
+// ResolveWorker checks that the daemon advertises a worker matching worker
+// and returns the filter that selects it.
+func (c *Client) ResolveWorker(ctx context.Context, worker string) (string, error) {
+	filter := WorkerFilter(worker)
+	workers, err := c.bk.ListWorkers(ctx, client.WithFilter([]string{filter}))
+	if err != nil {
+		return "", fmt.Errorf("listing buildkit workers matching %q: %w", filter, err)
+	}
+	if len(workers) > 0 {
+		return filter, nil
+	}
+
+	all, err := c.bk.ListWorkers(ctx)
+	if err != nil {
+		return "", fmt.Errorf("listing buildkit workers: %w", err)
+	}
+	ids := make([]string, 0, len(all))
+	for _, w := range all {
+		ids = append(ids, w.ID)
+	}
+	return "", fmt.Errorf("no buildkit worker on %s matches %q (available workers: %s)", c.addr, filter, strings.Join(ids, ", "))
+}
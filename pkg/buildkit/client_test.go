@@ -16,7 +16,9 @@ package buildkit
 
 import (
 	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -57,6 +59,32 @@ func TestNewClientConnectionError(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestNewClientDialTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	// A listener that accepts connections but never speaks gRPC behaves
+	// like a black-holed backend.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := "tcp://" + ln.Addr().String()
+	start := time.Now()
+	_, err = New(ctx, addr, WithDialTimeout(500*time.Millisecond))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connecting to buildkit at "+addr)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
 func TestClientPing(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test in short mode")
@@ -0,0 +1,59 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// HostEnv is the environment variable BuildKit clients conventionally
+	// read the daemon address from.
+	HostEnv = "BUILDKIT_HOST"
+
+	// DefaultSocket is the socket of a buildkitd running as root.
+	DefaultSocket = "unix:///run/buildkit/buildkitd.sock"
+)
+
+// socketAddrs returns the sockets of a local buildkitd that DiscoverAddr
+// looks for, in order: that of a rootful daemon, then that of a rootless
+// one.
+var socketAddrs = func() []string {
+	addrs := []string{DefaultSocket}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		addrs = append(addrs, "unix://"+filepath.Join(dir, "buildkit", "buildkitd.sock"))
+	}
+	return addrs
+}
+
+// DiscoverAddr returns the address of the BuildKit daemon to use when none
+// was given, and where it was found: the address in $BUILDKIT_HOST if it is
+// set, otherwise the first socket of a local buildkitd that exists,
+// otherwise DefaultAddr.
+func DiscoverAddr() (addr, source string) {
+	if addr := os.Getenv(HostEnv); addr != "" {
+		return addr, "$" + HostEnv
+	}
+	for _, addr := range socketAddrs() {
+		fi, err := os.Stat(strings.TrimPrefix(addr, "unix://"))
+		if err == nil && fi.Mode()&fs.ModeSocket != 0 {
+			return addr, "local socket"
+		}
+	}
+	return DefaultAddr, "default"
+}
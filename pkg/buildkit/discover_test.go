@@ -0,0 +1,58 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverAddr(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "buildkitd.sock")
+	missing := filepath.Join(dir, "missing.sock")
+
+	orig := socketAddrs
+	t.Cleanup(func() { socketAddrs = orig })
+	socketAddrs = func() []string { return []string{"unix://" + missing, "unix://" + sock} }
+
+	t.Run("default", func(t *testing.T) {
+		t.Setenv(HostEnv, "")
+		addr, source := DiscoverAddr()
+		require.Equal(t, DefaultAddr, addr)
+		require.Equal(t, "default", source)
+	})
+
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	t.Run("local socket", func(t *testing.T) {
+		t.Setenv(HostEnv, "")
+		addr, source := DiscoverAddr()
+		require.Equal(t, "unix://"+sock, addr)
+		require.Equal(t, "local socket", source)
+	})
+
+	t.Run("BUILDKIT_HOST", func(t *testing.T) {
+		t.Setenv(HostEnv, "tcp://buildkitd.example.com:1234")
+		addr, source := DiscoverAddr()
+		require.Equal(t, "tcp://buildkitd.example.com:1234", addr)
+		require.Equal(t, "$BUILDKIT_HOST", source)
+	})
+}
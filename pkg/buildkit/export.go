@@ -18,13 +18,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/chainguard-dev/clog"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
-	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -38,6 +40,9 @@ const (
 	ExportTypeRegistry ExportType = "registry"
 )
 
+// DebugImageBuildLogPath is where the build log is written in a debug image.
+const DebugImageBuildLogPath = "/melange-build.log"
+
 // ExportConfig contains configuration for exporting a debug image.
 type ExportConfig struct {
 	// Type specifies how to export the image.
@@ -51,6 +56,14 @@ type ExportConfig struct {
 
 	// LocalDirs are the local directories to mount during export.
 	LocalDirs map[string]string
+
+	// BuildLog, if set, is written to DebugImageBuildLogPath in the image
+	// so that it carries the log of the build that failed.
+	BuildLog []byte
+
+	// SecretEnv holds the values of the build secrets the steps of the
+	// state set variables from.
+	SecretEnv map[string]string
 }
 
 // ExportDebugImage exports the given LLB state as a debug image.
@@ -65,15 +78,21 @@ func (b *Builder) ExportDebugImage(ctx context.Context, state llb.State, cfg *Ex
 
 	log.Infof("exporting debug image as %s to %s", cfg.Type, cfg.Ref)
 
+	localDirs := cfg.LocalDirs
+	if len(cfg.BuildLog) > 0 {
+		var cleanup func()
+		var err error
+		state, localDirs, cleanup, err = withBuildLog(state, cfg.BuildLog, localDirs)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
 	// Marshal the state to LLB definition
-	ociPlatform := cfg.Arch.ToOCIPlatform()
-	platform := llb.Platform(ocispecs.Platform{
-		OS:           ociPlatform.OS,
-		Architecture: ociPlatform.Architecture,
-		Variant:      ociPlatform.Variant,
-	})
+	platform := llbPlatform(cfg.Arch)
 
-	def, err := state.Marshal(ctx, platform)
+	def, err := state.Marshal(ctx, b.constraints(platform)...)
 	if err != nil {
 		return fmt.Errorf("marshaling debug image LLB: %w", err)
 	}
@@ -97,8 +116,9 @@ func (b *Builder) ExportDebugImage(ctx context.Context, state llb.State, cfg *Ex
 
 	eg.Go(func() error {
 		_, err := b.client.Client().Solve(ctx, def, client.SolveOpt{
-			LocalDirs: cfg.LocalDirs,
+			LocalDirs: localDirs,
 			Exports:   exports,
+			Session:   secretEnvSession(cfg.SecretEnv),
 		}, statusCh)
 		return err
 	})
@@ -111,6 +131,41 @@ func (b *Builder) ExportDebugImage(ctx context.Context, state llb.State, cfg *Ex
 	return nil
 }
 
+// buildLogLocalName is the name of the local directory the build log is
+// sent to BuildKit from.
+const buildLogLocalName = "melange-build-log"
+
+// withBuildLog copies the build log into state at DebugImageBuildLogPath.
+// The log is written to a temporary directory, added to a copy of
+// localDirs, and sent to BuildKit with the local directories of the solve:
+// embedded in the LLB definition, a long log would exceed the size of a
+// gRPC message. The returned function removes the directory.
+func withBuildLog(state llb.State, buildLog []byte, localDirs map[string]string) (llb.State, map[string]string, func(), error) {
+	dir, err := os.MkdirTemp("", "melange-build-log-")
+	if err != nil {
+		return state, nil, nil, fmt.Errorf("creating build log dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	name := path.Base(DebugImageBuildLogPath)
+	if err := os.WriteFile(filepath.Join(dir, name), buildLog, 0o644); err != nil {
+		cleanup()
+		return state, nil, nil, fmt.Errorf("writing build log: %w", err)
+	}
+
+	dirs := maps.Clone(localDirs)
+	if dirs == nil {
+		dirs = map[string]string{}
+	}
+	dirs[buildLogLocalName] = dir
+
+	state = state.File(
+		llb.Copy(llb.Local(buildLogLocalName), "/"+name, DebugImageBuildLogPath),
+		llb.WithCustomName("write build log to "+DebugImageBuildLogPath),
+	)
+	return state, dirs, cleanup, nil
+}
+
 // buildExportEntries creates the BuildKit export entries based on export type.
 func buildExportEntries(cfg *ExportConfig) ([]client.ExportEntry, error) {
 	switch cfg.Type {
@@ -15,11 +15,23 @@
 package buildkit
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
 )
 
@@ -168,3 +180,139 @@ func TestExportDebugImageTarball(t *testing.T) {
 	require.NoError(t, err)
 	require.Greater(t, info.Size(), int64(0))
 }
+
+func TestWithBuildLog(t *testing.T) {
+	// A log longer than a gRPC message may be.
+	buildLog := bytes.Repeat([]byte("step 3 failed\n"), 1<<20)
+	localDirs := map[string]string{"workspace": t.TempDir()}
+
+	state, dirs, cleanup, err := withBuildLog(llb.Image(TestBaseImage), buildLog, localDirs)
+	require.NoError(t, err)
+
+	// The log is sent as a local directory, leaving those given untouched.
+	require.Len(t, localDirs, 1)
+	require.Equal(t, localDirs["workspace"], dirs["workspace"])
+	got, err := os.ReadFile(filepath.Join(dirs[buildLogLocalName], path.Base(DebugImageBuildLogPath)))
+	require.NoError(t, err)
+	require.Equal(t, buildLog, got)
+
+	def, err := state.Marshal(context.Background(), llb.LinuxAmd64)
+	require.NoError(t, err)
+
+	var size int
+	var cp *pb.FileActionCopy
+	for _, dt := range def.Def {
+		size += len(dt)
+		var op pb.Op
+		require.NoError(t, op.Unmarshal(dt))
+		for _, action := range op.GetFile().GetActions() {
+			if c := action.GetCopy(); c != nil {
+				cp = c
+			}
+		}
+	}
+	require.NotNil(t, cp, "expected a copy action")
+	require.Equal(t, DebugImageBuildLogPath, cp.Dest)
+	require.Less(t, size, 4096, "the build log must not be embedded in the definition")
+
+	cleanup()
+	require.NoDirExists(t, dirs[buildLogLocalName])
+}
+
+func TestExportDebugImageTarballWithBuildLog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	bk := startBuildKitContainer(t, ctx)
+
+	builder, err := NewBuilder(bk.Addr)
+	require.NoError(t, err)
+	defer builder.Close()
+
+	tarPath := t.TempDir() + "/debug.tar"
+	cfg := &ExportConfig{
+		Type:     ExportTypeTarball,
+		Ref:      tarPath,
+		Arch:     apko_types.ParseArchitecture("amd64"),
+		BuildLog: []byte("running main pipelines\nbuild failed at step 2\n"),
+	}
+
+	err = builder.ExportDebugImage(ctx, PrepareWorkspace(testBaseState(), "debug-test"), cfg)
+	require.NoError(t, err)
+
+	got, err := readFileFromOCITarball(tarPath, strings.TrimPrefix(DebugImageBuildLogPath, "/"))
+	require.NoError(t, err)
+	require.Equal(t, string(cfg.BuildLog), string(got))
+}
+
+// readFileFromOCITarball returns the contents of name from the topmost layer
+// of the image in an OCI layout tarball that contains it.
+func readFileFromOCITarball(tarPath, name string) ([]byte, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	blobs := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		blobs[hdr.Name] = data
+	}
+	blob := func(digest string) []byte {
+		return blobs["blobs/"+strings.Replace(digest, ":", "/", 1)]
+	}
+
+	var index ocispecs.Index
+	if err := json.Unmarshal(blobs["index.json"], &index); err != nil {
+		return nil, err
+	}
+	var manifest ocispecs.Manifest
+	for len(index.Manifests) > 0 {
+		desc := index.Manifests[0]
+		if desc.MediaType != ocispecs.MediaTypeImageIndex {
+			if err := json.Unmarshal(blob(desc.Digest.String()), &manifest); err != nil {
+				return nil, err
+			}
+			break
+		}
+		index = ocispecs.Index{}
+		if err := json.Unmarshal(blob(desc.Digest.String()), &index); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		gz, err := gzip.NewReader(bytes.NewReader(blob(manifest.Layers[i].Digest.String())))
+		if err != nil {
+			return nil, err
+		}
+		lr := tar.NewReader(gz)
+		for {
+			hdr, err := lr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if strings.TrimPrefix(hdr.Name, "./") == name {
+				return io.ReadAll(lr)
+			}
+		}
+	}
+	return nil, os.ErrNotExist
+}
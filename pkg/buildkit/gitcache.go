@@ -0,0 +1,68 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+const (
+	// GitCheckoutCacheDir is where the cache of a git-checkout step is
+	// mounted.
+	GitCheckoutCacheDir = "/var/cache/melange-git-checkout"
+
+	// GitCheckoutCacheEnv is set, for a git-checkout step with a cache, to
+	// where the cache is mounted. The pipeline keeps its clone there, and
+	// reuses it while the reference it checks out has not moved.
+	GitCheckoutCacheEnv = "MELANGE_GIT_CHECKOUT_CACHE"
+)
+
+// fullCommit matches a full SHA-1 or SHA-256 commit hash.
+var fullCommit = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// GitCheckoutCacheID returns the ID of the cache of the clone of repository
+// at commit. It is the same for every spelling of the URL that differs only
+// in a trailing slash or ".git", and for every case of the commit hash.
+func GitCheckoutCacheID(repository, commit string) string {
+	repository = strings.TrimSuffix(strings.TrimSuffix(repository, "/"), ".git")
+	sum := sha256.Sum256([]byte(repository + "\x00" + strings.ToLower(commit)))
+	return "melange-git-checkout-" + hex.EncodeToString(sum[:8])
+}
+
+// gitCheckoutCache returns the cache mount of p, if it is a git-checkout
+// step that pins the commit it checks out with expected-commit. Steps that
+// do not pin a commit have nothing stable to key a cache by.
+func gitCheckoutCache(p *config.Pipeline) (CacheMount, bool) {
+	if p.Uses != "git-checkout" {
+		return CacheMount{}, false
+	}
+	repository, commit := p.With["repository"], strings.ToLower(p.With["expected-commit"])
+	if repository == "" || !fullCommit.MatchString(commit) {
+		return CacheMount{}, false
+	}
+	return CacheMount{
+		ID:     GitCheckoutCacheID(repository, commit),
+		Target: GitCheckoutCacheDir,
+		// A step that fills the cache has it to itself.
+		Mode: llb.CacheMountLocked,
+	}, true
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestGitCheckoutCacheID(t *testing.T) {
+	const (
+		repo    = "https://github.com/chainguard-dev/melange"
+		commit  = "0123456789abcdef0123456789abcdef01234567"
+		another = "89abcdef0123456789abcdef0123456789abcdef"
+	)
+
+	id := GitCheckoutCacheID(repo, commit)
+	require.Equal(t, id, GitCheckoutCacheID(repo, commit), "the ID is stable")
+	require.Equal(t, id, GitCheckoutCacheID(repo+".git", commit))
+	require.Equal(t, id, GitCheckoutCacheID(repo+"/", commit))
+	require.Equal(t, id, GitCheckoutCacheID(repo, "0123456789ABCDEF0123456789ABCDEF01234567"))
+
+	require.NotEqual(t, id, GitCheckoutCacheID(repo, another), "commits have their own caches")
+	require.NotEqual(t, id, GitCheckoutCacheID("https://github.com/chainguard-dev/apko", commit), "repositories have their own caches")
+}
+
+func TestGitCheckoutCache(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	step := func(with map[string]string) *config.Pipeline {
+		return &config.Pipeline{Uses: "git-checkout", With: with}
+	}
+
+	mount, ok := gitCheckoutCache(step(map[string]string{
+		"repository":      "https://github.com/chainguard-dev/melange",
+		"tag":             "v0.30.0",
+		"expected-commit": commit,
+	}))
+	require.True(t, ok)
+	require.Equal(t, GitCheckoutCacheID("https://github.com/chainguard-dev/melange", commit), mount.ID)
+	require.Equal(t, GitCheckoutCacheDir, mount.Target)
+
+	for name, p := range map[string]*config.Pipeline{
+		"no expected-commit": step(map[string]string{"repository": "https://github.com/chainguard-dev/melange", "branch": "main"}),
+		"abbreviated commit": step(map[string]string{"repository": "https://github.com/chainguard-dev/melange", "expected-commit": "0123456"}),
+		"no repository":      step(map[string]string{"expected-commit": commit}),
+		"another pipeline":   {Uses: "fetch", With: map[string]string{"repository": "https://github.com/chainguard-dev/melange", "expected-commit": commit}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, ok := gitCheckoutCache(p)
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestPipelineBuilderGitCheckoutCache(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	builder := NewPipelineBuilder()
+	state, err := builder.BuildPipelines(llb.Image(TestBaseImage), []config.Pipeline{
+		{
+			Uses: "git-checkout",
+			With: map[string]string{
+				"repository":      "https://github.com/chainguard-dev/melange",
+				"expected-commit": commit,
+			},
+			Pipeline: []config.Pipeline{{Runs: "git clone"}},
+		},
+		{Runs: "make"},
+	})
+	require.NoError(t, err)
+
+	def, err := state.Marshal(context.Background(), llb.LinuxAmd64)
+	require.NoError(t, err)
+	caches := map[string][]string{}
+	for _, dt := range def.Def {
+		var op pb.Op
+		require.NoError(t, op.Unmarshal(dt))
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		args := strings.Join(exec.GetMeta().GetArgs(), " ")
+		for _, m := range exec.GetMounts() {
+			if m.GetCacheOpt() != nil {
+				caches[args] = append(caches[args], m.GetCacheOpt().GetID())
+			}
+		}
+		if strings.Contains(args, "git clone") {
+			require.Contains(t, exec.GetMeta().GetEnv(), GitCheckoutCacheEnv+"="+GitCheckoutCacheDir)
+		}
+	}
+
+	// Only the steps of the checkout have the cache.
+	for args, ids := range caches {
+		require.Contains(t, args, "git clone")
+		require.Equal(t, []string{GitCheckoutCacheID("https://github.com/chainguard-dev/melange", commit)}, ids)
+	}
+	require.Len(t, caches, 1)
+	require.Empty(t, builder.CacheMounts)
+}
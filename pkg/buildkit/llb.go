@@ -17,10 +17,12 @@ package buildkit
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/moby/buildkit/client/llb"
 
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/cond"
 	"github.com/dlorenc/melange2/pkg/config"
 )
@@ -69,6 +71,17 @@ type PipelineBuilder struct {
 	// CacheMounts specifies cache mounts to use for build steps.
 	// These are applied to all pipeline steps.
 	CacheMounts []CacheMount
+
+	// Redactor, if set, records the secret values in the environment of
+	// each step as it is built, so that they can be masked in the logs.
+	Redactor *Redactor
+
+	// Offline runs every step without network access.
+	Offline bool
+
+	// SecretEnv names the variables set in every step from the build
+	// secrets of the same ID, unless the environment of the step sets them.
+	SecretEnv []string
 }
 
 // NewPipelineBuilder creates a new PipelineBuilder with default configuration.
@@ -133,14 +146,10 @@ func (b *PipelineBuilder) BuildPipelinesWithRecovery(base llb.State, pipelines [
 // Returns the modified state after running the pipeline.
 func (b *PipelineBuilder) BuildPipeline(base llb.State, p *config.Pipeline) (llb.State, error) {
 	// Check if this pipeline should run
-	if p.If != "" {
-		shouldRun, err := cond.Evaluate(p.If)
-		if err != nil {
-			return llb.State{}, fmt.Errorf("evaluating if condition %q: %w", p.If, err)
-		}
-		if !shouldRun {
-			return base, nil
-		}
+	if run, err := shouldRun(p); err != nil {
+		return llb.State{}, err
+	} else if !run {
+		return base, nil
 	}
 
 	state := base
@@ -162,6 +171,7 @@ func (b *PipelineBuilder) BuildPipeline(base llb.State, p *config.Pipeline) (llb
 
 		// Build environment
 		env := MergeEnv(b.BaseEnv, p.Environment)
+		b.Redactor.AddEnv(env)
 
 		// Build run options
 		// Run as build user (UID 1000) for permission parity with baseline melange.
@@ -176,10 +186,15 @@ func (b *PipelineBuilder) BuildPipeline(base llb.State, p *config.Pipeline) (llb
 
 		// Add sorted environment variables for determinism
 		opts = append(opts, SortedEnvOpts(env)...)
+		opts = append(opts, SecretEnvOpts(b.SecretEnv, env)...)
 
 		// Add cache mounts
 		opts = append(opts, CacheMountOptions(b.CacheMounts)...)
 
+		if b.Offline {
+			opts = append(opts, llb.Network(llb.NetModeNone))
+		}
+
 		// Add custom name for better logging
 		if name := pipelineName(p); name != "" {
 			opts = append(opts, llb.WithCustomName(name))
@@ -189,26 +204,70 @@ func (b *PipelineBuilder) BuildPipeline(base llb.State, p *config.Pipeline) (llb
 	}
 
 	// Process nested pipelines
+	ran := 0
 	if len(p.Pipeline) > 0 {
 		// Create a child builder with merged environment
 		childBuilder := &PipelineBuilder{
 			Debug:       b.Debug,
 			BaseEnv:     MergeEnv(b.BaseEnv, p.Environment),
 			CacheMounts: b.CacheMounts,
+			Redactor:    b.Redactor,
+			Offline:     b.Offline,
+			SecretEnv:   b.SecretEnv,
+		}
+		if mount, ok := gitCheckoutCache(p); ok {
+			childBuilder.CacheMounts = append(slices.Clone(b.CacheMounts), mount)
+			childBuilder.BaseEnv[GitCheckoutCacheEnv] = GitCheckoutCacheDir
 		}
 
 		for i := range p.Pipeline {
-			var err error
-			state, err = childBuilder.BuildPipeline(state, &p.Pipeline[i])
+			child := &p.Pipeline[i]
+			run, err := shouldRun(child)
+			if err != nil {
+				return llb.State{}, fmt.Errorf("nested pipeline %d: %w", i, err)
+			}
+			if run && (child.Runs != "" || len(child.Pipeline) > 0) {
+				ran++
+			}
+			state, err = childBuilder.BuildPipeline(state, child)
 			if err != nil {
 				return llb.State{}, fmt.Errorf("nested pipeline %d: %w", i, err)
 			}
 		}
 	}
+	if err := checkAssertions(p, ran); err != nil {
+		return llb.State{}, err
+	}
 
 	return state, nil
 }
 
+// shouldRun evaluates the if condition of p, reporting whether it runs.
+func shouldRun(p *config.Pipeline) (bool, error) {
+	if p.If == "" {
+		return true, nil
+	}
+	run, err := cond.Evaluate(p.If)
+	if err != nil {
+		return false, fmt.Errorf("evaluating if condition %q: %w", p.If, err)
+	}
+	return run, nil
+}
+
+// checkAssertions returns an error if ran, the number of nested pipelines of
+// p that run, falls short of the steps its assertions require. As a failing
+// step fails the whole build or test, every step that runs succeeds.
+func checkAssertions(p *config.Pipeline, ran int) error {
+	if p.Assertions == nil || ran >= p.Assertions.RequiredSteps {
+		return nil
+	}
+	name := pipelineName(p)
+	if name == "" {
+		name = "pipeline"
+	}
+	return fmt.Errorf("%s: assertion failed: only %d of %d required steps ran", name, ran, p.Assertions.RequiredSteps)
+}
+
 // buildScript creates the shell script to run for a pipeline step.
 func (b *PipelineBuilder) buildScript(runs, workdir string) string {
 	debugOpt := ' '
@@ -307,10 +366,12 @@ func ExportWorkspace(state llb.State) llb.State {
 }
 
 // CopyCacheToWorkspace copies cache files from a Local mount to /var/cache/melange.
-// This enables pre-populating the cache from the host filesystem.
+// This enables pre-populating the cache from the host filesystem. The build
+// operates on its own copy, so writes to /var/cache/melange never reach the
+// host directory. The lock file of the cache is not copied.
 func CopyCacheToWorkspace(base llb.State, localName string) llb.State {
 	return base.File(
-		llb.Copy(llb.Local(localName), "/", DefaultCacheDir+"/", &llb.CopyInfo{
+		llb.Copy(llb.Local(localName, llb.ExcludePatterns([]string{cache.LockName})), "/", DefaultCacheDir+"/", &llb.CopyInfo{
 			CopyDirContentsOnly: true,
 			CreateDestPath:      true,
 		}),
@@ -353,6 +414,7 @@ func (b *PipelineBuilder) BuildTestPipelines(base llb.State, pipelines []config.
 	for k, v := range b.BaseEnv {
 		env[k] = v
 	}
+	b.Redactor.AddEnv(env)
 
 	// Build run options
 	opts := []llb.RunOption{
@@ -363,10 +425,15 @@ func (b *PipelineBuilder) BuildTestPipelines(base llb.State, pipelines []config.
 
 	// Add sorted environment variables for determinism
 	opts = append(opts, SortedEnvOpts(env)...)
+	opts = append(opts, SecretEnvOpts(b.SecretEnv, env)...)
 
 	// Add cache mounts
 	opts = append(opts, CacheMountOptions(b.CacheMounts)...)
 
+	if b.Offline {
+		opts = append(opts, llb.Network(llb.NetModeNone))
+	}
+
 	// Add custom name
 	opts = append(opts, llb.WithCustomName("run test pipelines"))
 
@@ -377,19 +444,15 @@ func (b *PipelineBuilder) BuildTestPipelines(base llb.State, pipelines []config.
 // Returns empty string if the pipeline should be skipped.
 func (b *PipelineBuilder) buildTestPipelineScript(p *config.Pipeline, index int) (string, error) {
 	// Check if this pipeline should run
-	if p.If != "" {
-		shouldRun, err := cond.Evaluate(p.If)
-		if err != nil {
-			return "", fmt.Errorf("evaluating if condition %q: %w", p.If, err)
-		}
-		if !shouldRun {
-			return "", nil
-		}
+	if run, err := shouldRun(p); err != nil {
+		return "", err
+	} else if !run {
+		return "", nil
 	}
 
 	// Skip if nothing to run
 	if p.Runs == "" && len(p.Pipeline) == 0 {
-		return "", nil
+		return "", checkAssertions(p, 0)
 	}
 
 	// Determine working directory
@@ -405,6 +468,7 @@ func (b *PipelineBuilder) buildTestPipelineScript(p *config.Pipeline, index int)
 	// Build environment exports for this step
 	var envExports string
 	if len(p.Environment) > 0 {
+		b.Redactor.AddEnv(p.Environment)
 		for k, v := range p.Environment {
 			// Escape single quotes in value
 			escapedV := strings.ReplaceAll(v, "'", "'\"'\"'")
@@ -414,16 +478,19 @@ func (b *PipelineBuilder) buildTestPipelineScript(p *config.Pipeline, index int)
 
 	// Build nested pipeline scripts recursively
 	var nestedScripts string
-	if len(p.Pipeline) > 0 {
-		for i := range p.Pipeline {
-			nested, err := b.buildTestPipelineScript(&p.Pipeline[i], i)
-			if err != nil {
-				return "", fmt.Errorf("nested pipeline %d: %w", i, err)
-			}
-			if nested != "" {
-				nestedScripts += nested + "\n"
-			}
+	ran := 0
+	for i := range p.Pipeline {
+		nested, err := b.buildTestPipelineScript(&p.Pipeline[i], i)
+		if err != nil {
+			return "", fmt.Errorf("nested pipeline %d: %w", i, err)
 		}
+		if nested != "" {
+			nestedScripts += nested + "\n"
+			ran++
+		}
+	}
+	if err := checkAssertions(p, ran); err != nil {
+		return "", err
 	}
 
 	// Build the script for this step
@@ -176,6 +176,57 @@ func TestPipelineBuilderEmptyRuns(t *testing.T) {
 	require.NotEmpty(t, def.Def)
 }
 
+func TestPipelineBuilderAssertions(t *testing.T) {
+	// One step runs on each architecture.
+	archSteps := func(required int) config.Pipeline {
+		return config.Pipeline{
+			Name:       "arch-specific",
+			Assertions: &config.PipelineAssertions{RequiredSteps: required},
+			Pipeline: []config.Pipeline{
+				{If: "'x86_64' == 'x86_64'", Runs: "echo x86_64"},
+				{If: "'x86_64' == 'aarch64'", Runs: "echo aarch64"},
+			},
+		}
+	}
+	base := llb.Image(TestBaseImage)
+
+	t.Run("build met", func(t *testing.T) {
+		p := archSteps(1)
+		_, err := NewPipelineBuilder().BuildPipeline(base, &p)
+		require.NoError(t, err)
+	})
+
+	t.Run("build unmet", func(t *testing.T) {
+		p := archSteps(2)
+		_, err := NewPipelineBuilder().BuildPipeline(base, &p)
+		require.EqualError(t, err, "arch-specific: assertion failed: only 1 of 2 required steps ran")
+	})
+
+	t.Run("test met", func(t *testing.T) {
+		state, err := NewPipelineBuilder().BuildTestPipelines(base, []config.Pipeline{archSteps(1)})
+		require.NoError(t, err)
+		def, err := state.Marshal(context.Background(), llb.LinuxAmd64)
+		require.NoError(t, err)
+		require.NotEmpty(t, def.Def)
+	})
+
+	t.Run("test unmet", func(t *testing.T) {
+		_, err := NewPipelineBuilder().BuildTestPipelines(base, []config.Pipeline{
+			{Runs: "echo first"},
+			archSteps(2),
+		})
+		require.EqualError(t, err, "pipeline 1: arch-specific: assertion failed: only 1 of 2 required steps ran")
+	})
+
+	t.Run("test nothing to run", func(t *testing.T) {
+		_, err := NewPipelineBuilder().BuildTestPipelines(base, []config.Pipeline{{
+			Assertions: &config.PipelineAssertions{RequiredSteps: 1},
+			Pipeline:   []config.Pipeline{{If: "'a' == 'b'", Runs: "echo skipped"}},
+		}})
+		require.EqualError(t, err, "pipeline 0: pipeline: assertion failed: only 0 of 1 required steps ran")
+	})
+}
+
 func TestPipelineBuilderMultiplePipelines(t *testing.T) {
 	builder := NewPipelineBuilder()
 
@@ -49,6 +49,52 @@ type LayerLoader interface {
 	Load(ctx context.Context, layers []v1.Layer, cfg *BuildConfig) (*LayerLoadResult, error)
 }
 
+// layerLoad is a load of layers by a LayerLoader running in the background.
+type layerLoad struct {
+	done   chan struct{}
+	result *LayerLoadResult
+	err    error
+}
+
+// startLayerLoad starts loading layers with loader.
+func startLayerLoad(ctx context.Context, loader LayerLoader, layers []v1.Layer, cfg *BuildConfig) *layerLoad {
+	l := &layerLoad{done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		l.result, l.err = loader.Load(ctx, layers, cfg)
+	}()
+	return l
+}
+
+// wait waits for the load to finish and returns its result.
+func (l *layerLoad) wait() (*LayerLoadResult, error) {
+	<-l.done
+	return l.result, l.err
+}
+
+// state returns a state for the loaded layers, which can be built upon
+// before the load finishes. Marshaling it waits for the load.
+func (l *layerLoad) state() llb.State {
+	return llb.Scratch().Async(func(ctx context.Context, _ llb.State, _ *llb.Constraints) (llb.State, error) {
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return llb.State{}, ctx.Err()
+		}
+		if l.err != nil {
+			return llb.State{}, l.err
+		}
+		return l.result.State, nil
+	})
+}
+
+// cleanup waits for the load to finish and releases the loaded layers.
+func (l *layerLoad) cleanup() {
+	if result, err := l.wait(); err == nil {
+		result.Cleanup()
+	}
+}
+
 // LocalLayerLoader extracts layers to disk and references them via llb.Local().
 // This is the traditional approach that works without any registry.
 type LocalLayerLoader struct {
@@ -101,11 +147,19 @@ func (l *LocalLayerLoader) Load(ctx context.Context, layers []v1.Layer, cfg *Bui
 
 // RegistryLayerLoader pushes layers to a registry and references them via llb.Image().
 // This provides better caching as BuildKit can cache layers by content address.
-type RegistryLayerLoader struct{}
+//
+// If the registry cannot be used, the layers are loaded with the fallback
+// loader instead, unless ApkoRegistryConfig.Strict is set.
+type RegistryLayerLoader struct {
+	fallback LayerLoader
+}
 
-// NewRegistryLayerLoader creates a new RegistryLayerLoader.
-func NewRegistryLayerLoader() *RegistryLayerLoader {
-	return &RegistryLayerLoader{}
+// NewRegistryLayerLoader creates a new RegistryLayerLoader. fallback may be
+// nil, in which case registry errors always fail the load.
+func NewRegistryLayerLoader(fallback LayerLoader) *RegistryLayerLoader {
+	return &RegistryLayerLoader{
+		fallback: fallback,
+	}
 }
 
 // Load pushes layers to the configured registry and creates an LLB state.
@@ -128,9 +182,15 @@ func (l *RegistryLayerLoader) Load(ctx context.Context, layers []v1.Layer, cfg *
 	loadStart := time.Now()
 
 	cache := NewApkoImageCache(cfg.ApkoRegistryConfig.Registry, cfg.ApkoRegistryConfig.Insecure)
+	cache.SBOM = cfg.ApkoRegistryConfig.SBOM
+	cache.UserAgent = cfg.ApkoRegistryConfig.UserAgent
 	imgRef, cacheHit, err := cache.GetOrCreate(ctx, *cfg.ImgConfig, layers)
 	if err != nil {
-		return nil, fmt.Errorf("caching apko image: %w", err)
+		if cfg.ApkoRegistryConfig.Strict || l.fallback == nil {
+			return nil, fmt.Errorf("caching apko image: %w", err)
+		}
+		log.Warnf("apko registry cache %s is unavailable, falling back to local layers: %v", cfg.ApkoRegistryConfig.Registry, err)
+		return l.fallback.Load(ctx, layers, cfg)
 	}
 
 	loadDuration := time.Since(loadStart)
@@ -196,8 +256,9 @@ func SelectLayerLoader(cfg *BuildConfig, layers []v1.Layer, imageLoader *ImageLo
 		return NewServiceLayerLoader()
 
 	case hasApkoRegistry && len(layers) > 0:
-		// Registry mode: we push layers to registry ourselves
-		return NewRegistryLayerLoader()
+		// Registry mode: we push layers to registry ourselves, falling back
+		// to local mode if the registry is unavailable
+		return NewRegistryLayerLoader(NewLocalLayerLoader(imageLoader))
 
 	default:
 		// Local mode: extract layers to disk
@@ -222,6 +283,7 @@ type TestStateResult struct {
 // Different implementations handle different sources:
 // - LayerTestStateProvider: loads from v1.Layer via ImageLoader
 // - ImageTestStateProvider: uses llb.Image() directly
+// - LayeredImageTestStateProvider: loads from v1.Layer onto llb.Image()
 type TestStateProvider interface {
 	// Provide creates the base state for running tests.
 	Provide(ctx context.Context, pkgName string) (*TestStateResult, error)
@@ -287,3 +349,34 @@ func (p *ImageTestStateProvider) Provide(ctx context.Context, pkgName string) (*
 		Cleanup:   func() {}, // No cleanup needed for image-based approach
 	}, nil
 }
+
+// LayeredImageTestStateProvider provides test state by loading layers from
+// v1.Layer onto an image reference, such as a test environment onto each
+// base image of a test matrix.
+type LayeredImageTestStateProvider struct {
+	imageRef string
+	layers   *LayerTestStateProvider
+}
+
+// NewLayeredImageTestStateProvider creates a new
+// LayeredImageTestStateProvider.
+func NewLayeredImageTestStateProvider(imageRef string, layers []v1.Layer, imageLoader *ImageLoader) *LayeredImageTestStateProvider {
+	return &LayeredImageTestStateProvider{
+		imageRef: imageRef,
+		layers:   NewLayerTestStateProvider(layers, imageLoader),
+	}
+}
+
+// Provide loads layers and returns the image with them copied on top.
+func (p *LayeredImageTestStateProvider) Provide(ctx context.Context, pkgName string) (*TestStateResult, error) {
+	result, err := p.layers.Provide(ctx, pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	result.State = llb.Image(p.imageRef).File(
+		llb.Copy(result.State, "/", "/"),
+		llb.WithCustomName(fmt.Sprintf("copy test environment onto %s", p.imageRef)),
+	)
+	return result, nil
+}
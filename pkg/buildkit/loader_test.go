@@ -0,0 +1,116 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableRegistry returns the address of a registry that rejects every
+// request.
+func unavailableRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "registry unavailable", http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://") + "/apko-cache"
+}
+
+func TestRegistryLayerLoaderFallback(t *testing.T) {
+	ctx := slogtest.Context(t)
+	layers := []v1.Layer{createTestLayer(t, map[string][]byte{
+		"etc/os-release": []byte("ID=test\n"),
+	})}
+
+	newConfig := func(strict bool) *BuildConfig {
+		return &BuildConfig{
+			PackageName: "fallback",
+			ImgConfig:   &apko_types.ImageConfiguration{},
+			ApkoRegistryConfig: &ApkoRegistryConfig{
+				Registry: unavailableRegistry(t),
+				Insecure: true,
+				Strict:   strict,
+			},
+		}
+	}
+
+	t.Run("falls back to local layers", func(t *testing.T) {
+		cfg := newConfig(false)
+		loader := SelectLayerLoader(cfg, layers, NewImageLoader(t.TempDir()))
+		require.IsType(t, &RegistryLayerLoader{}, loader)
+
+		result, err := loader.Load(ctx, layers, cfg)
+		require.NoError(t, err)
+		defer result.Cleanup()
+
+		// Local mode extracts the layers and mounts them via llb.Local().
+		require.Len(t, result.LocalDirs, 1)
+		require.Contains(t, result.LocalDirs, "apko-fallback")
+	})
+
+	t.Run("strict mode fails", func(t *testing.T) {
+		cfg := newConfig(true)
+		loader := SelectLayerLoader(cfg, layers, NewImageLoader(t.TempDir()))
+
+		_, err := loader.Load(ctx, layers, cfg)
+		require.ErrorContains(t, err, "caching apko image")
+	})
+
+	t.Run("no fallback fails", func(t *testing.T) {
+		cfg := newConfig(false)
+
+		_, err := NewRegistryLayerLoader(nil).Load(ctx, layers, cfg)
+		require.ErrorContains(t, err, "caching apko image")
+	})
+}
+
+func TestLayeredImageTestStateProvider(t *testing.T) {
+	ctx := slogtest.Context(t)
+	layer := createTestLayer(t, map[string][]byte{
+		"usr/bin/hello": []byte("#!/bin/sh\n"),
+	})
+
+	provider := NewLayeredImageTestStateProvider(TestBaseImage, []v1.Layer{layer}, NewImageLoader(t.TempDir()))
+	result, err := provider.Provide(ctx, "hello")
+	require.NoError(t, err)
+	defer result.Cleanup()
+
+	// The layer is extracted for llb.Local(), whose state is copied onto
+	// the image.
+	require.Len(t, result.LocalDirs, 1)
+	def, err := result.State.Marshal(ctx, llb.LinuxAmd64)
+	require.NoError(t, err)
+
+	var sources []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		require.NoError(t, op.Unmarshal(dt))
+		if src := op.GetSource(); src != nil {
+			sources = append(sources, src.GetIdentifier())
+		}
+	}
+	require.ElementsMatch(t, []string{"docker-image://" + TestBaseImage, "local://apko-hello-test"}, sources)
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/moby/buildkit/client"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ValidateOCILayout checks that an image can be exported to the OCI image
+// layout in dir: dir must not exist, be empty, or already be a layout.
+func ValidateOCILayout(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading OCI layout %s: %w", dir, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ocispecs.ImageLayoutFile))
+	if err != nil {
+		return fmt.Errorf("%s is neither empty nor an OCI image layout: %w", dir, err)
+	}
+	var l ocispecs.ImageLayout
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("%s is not an OCI image layout: parsing %s: %w", dir, ocispecs.ImageLayoutFile, err)
+	}
+	if l.Version != ocispecs.ImageLayoutVersion {
+		return fmt.Errorf("%s is an OCI image layout of unsupported version %q", dir, l.Version)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ocispecs.ImageIndexFile)); err != nil {
+		return fmt.Errorf("%s is not an OCI image layout: %w", dir, err)
+	}
+	return nil
+}
+
+// ociLayoutExport returns the entry exporting the output of a build, as an
+// image, to the OCI image layout in dir.
+func ociLayoutExport(dir string) client.ExportEntry {
+	return client.ExportEntry{
+		Type: client.ExporterOCI,
+		// Without tar, the exporter writes the image to the content store
+		// of the layout rather than streaming a tarball.
+		Attrs:     map[string]string{"tar": "false"},
+		OutputDir: dir,
+	}
+}
+
+// workspaceExports returns the entries exporting the output of a build:
+// to melangeOutDir, and with cfg.OCILayoutDir to that layout too.
+func workspaceExports(melangeOutDir string, cfg *BuildConfig) []client.ExportEntry {
+	exports := []client.ExportEntry{{
+		Type:      client.ExporterLocal,
+		OutputDir: melangeOutDir,
+	}}
+	if cfg.OCILayoutDir != "" {
+		exports = append(exports, ociLayoutExport(cfg.OCILayoutDir))
+	}
+	return exports
+}
+
+// ociLayoutMu serializes writes to OCI layouts, which the builds of several
+// architectures or packages may share.
+var ociLayoutMu sync.Mutex
+
+// AppendOCILayout adds the image BuildWithLayers exported to the layout in
+// src to the layout in dst, named refName, replacing the image of the same
+// name. Each SBOM in sboms is attached to it as an artifact whose subject is
+// the image. dst is created if it does not exist.
+func AppendOCILayout(ctx context.Context, dst, src, refName string, sboms [][]byte) error {
+	log := clog.FromContext(ctx)
+
+	img, err := exportedImage(src)
+	if err != nil {
+		return err
+	}
+	subject, err := partial.Descriptor(img)
+	if err != nil {
+		return fmt.Errorf("getting image descriptor: %w", err)
+	}
+
+	ociLayoutMu.Lock()
+	defer ociLayoutMu.Unlock()
+
+	if err := ValidateOCILayout(dst); err != nil {
+		return err
+	}
+	p, err := layout.FromPath(dst)
+	if err != nil {
+		if p, err = layout.Write(dst, empty.Index); err != nil {
+			return fmt.Errorf("creating OCI layout %s: %w", dst, err)
+		}
+	}
+
+	name := map[string]string{ocispecs.AnnotationRefName: refName}
+	if err := p.ReplaceImage(img, match.Annotation(ocispecs.AnnotationRefName, refName), layout.WithAnnotations(name)); err != nil {
+		return fmt.Errorf("writing image to OCI layout %s: %w", dst, err)
+	}
+
+	for _, doc := range sboms {
+		artifact, err := SBOMArtifact(doc, SPDXMediaType, *subject)
+		if err != nil {
+			return err
+		}
+		d, err := artifact.Digest()
+		if err != nil {
+			return fmt.Errorf("computing SBOM artifact digest: %w", err)
+		}
+		if err := p.ReplaceImage(artifact, match.Digests(d)); err != nil {
+			return fmt.Errorf("writing SBOM to OCI layout %s: %w", dst, err)
+		}
+	}
+
+	log.Infof("exported %s@%s with %d SBOMs to OCI layout %s", refName, subject.Digest, len(sboms), dst)
+	return nil
+}
+
+// exportedImage returns the image of the layout in dir, to which
+// BuildWithLayers exported one.
+func exportedImage(dir string) (v1.Image, error) {
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout %s: %w", dir, err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout %s: %w", dir, err)
+	}
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading OCI layout %s: %w", dir, err)
+	}
+	if len(m.Manifests) != 1 {
+		return nil, fmt.Errorf("OCI layout %s has %d images, want 1", dir, len(m.Manifests))
+	}
+	img, err := idx.Image(m.Manifests[0].Digest)
+	if err != nil {
+		return nil, fmt.Errorf("reading image from OCI layout %s: %w", dir, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestValidateOCILayout(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		require.NoError(t, ValidateOCILayout(filepath.Join(t.TempDir(), "layout")))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		require.NoError(t, ValidateOCILayout(t.TempDir()))
+	})
+
+	t.Run("layout", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := layout.Write(dir, empty.Index)
+		require.NoError(t, err)
+		require.NoError(t, ValidateOCILayout(dir))
+	})
+
+	t.Run("not a layout", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0o644))
+		require.ErrorContains(t, ValidateOCILayout(dir), "neither empty nor an OCI image layout")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ocispecs.ImageLayoutFile), []byte(`{"imageLayoutVersion":"2.0.0"}`), 0o644))
+		require.ErrorContains(t, ValidateOCILayout(dir), "unsupported version")
+	})
+
+	t.Run("missing index", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ocispecs.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644))
+		require.Error(t, ValidateOCILayout(dir))
+	})
+}
+
+func TestWorkspaceExports(t *testing.T) {
+	exports := workspaceExports("/out", &BuildConfig{})
+	require.Len(t, exports, 1)
+	require.Equal(t, client.ExporterLocal, exports[0].Type)
+
+	exports = workspaceExports("/out", &BuildConfig{OCILayoutDir: "/layout"})
+	require.Len(t, exports, 2)
+	require.Equal(t, client.ExporterLocal, exports[0].Type)
+	require.Equal(t, client.ExporterOCI, exports[1].Type)
+	require.Equal(t, "/layout", exports[1].OutputDir)
+}
+
+func TestAppendOCILayout(t *testing.T) {
+	ctx := context.Background()
+
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	src := t.TempDir()
+	_, err = layout.Write(src, empty.Index)
+	require.NoError(t, err)
+	p, err := layout.FromPath(src)
+	require.NoError(t, err)
+	require.NoError(t, p.AppendImage(img))
+
+	dst := filepath.Join(t.TempDir(), "layout")
+	sboms := [][]byte{[]byte(`{"spdxVersion":"SPDX-2.3"}`)}
+	require.NoError(t, AppendOCILayout(ctx, dst, src, "test-pkg-1.0-r0-x86_64", sboms))
+	// Appending again replaces the image and its SBOM rather than
+	// duplicating them.
+	require.NoError(t, AppendOCILayout(ctx, dst, src, "test-pkg-1.0-r0-x86_64", sboms))
+
+	require.NoError(t, ValidateOCILayout(dst))
+	out, err := layout.ImageIndexFromPath(dst)
+	require.NoError(t, err)
+	m, err := out.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+
+	want, err := img.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want, m.Manifests[0].Digest)
+	require.Equal(t, "test-pkg-1.0-r0-x86_64", m.Manifests[0].Annotations[ocispecs.AnnotationRefName])
+
+	artifact, err := out.Image(m.Manifests[1].Digest)
+	require.NoError(t, err)
+	am, err := artifact.Manifest()
+	require.NoError(t, err)
+	require.NotNil(t, am.Subject)
+	require.Equal(t, want, am.Subject.Digest)
+	require.Equal(t, SPDXMediaType, am.Config.MediaType)
+}
+
+func TestOCILayoutExportIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	bk := startBuildKitContainer(t, ctx)
+
+	state := PrepareWorkspace(testBaseState(), "test-pkg")
+	state, err := NewPipelineBuilder().BuildPipelines(state, []config.Pipeline{{
+		Name: "setup",
+		Runs: `
+mkdir -p /home/build/melange-out/test-pkg
+echo "hello" > /home/build/melange-out/test-pkg/result.txt
+`,
+	}})
+	require.NoError(t, err)
+
+	def, err := ExportWorkspace(state).Marshal(ctx, llb.LinuxAmd64)
+	require.NoError(t, err)
+
+	c, err := New(ctx, bk.Addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	melangeOutDir := t.TempDir()
+	cfg := &BuildConfig{OCILayoutDir: t.TempDir()}
+	require.NoError(t, ValidateOCILayout(cfg.OCILayoutDir))
+
+	exports := workspaceExports(melangeOutDir, cfg)
+	require.Equal(t, client.ExporterOCI, exports[1].Type)
+	_, err = c.Client().Solve(ctx, def, client.SolveOpt{Exports: exports}, nil)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(melangeOutDir, "test-pkg", "result.txt"))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "hello")
+
+	require.NoError(t, ValidateOCILayout(cfg.OCILayoutDir))
+	img, err := exportedImage(cfg.OCILayoutDir)
+	require.NoError(t, err)
+	_, err = img.ConfigFile()
+	require.NoError(t, err)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.NotEmpty(t, layers)
+}
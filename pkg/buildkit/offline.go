@@ -0,0 +1,151 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// ErrOffline is returned, wrapped, when an offline build would need network
+// access. Its message names the step or registry that needs it.
+var ErrOffline = errors.New("network access is disabled offline")
+
+// CheckOffline returns an error for the first step or registry of cfg
+// that needs network access. The cache directory, if there is one, is the
+// local mirror of the downloads of fetch steps. An offline Builder checks
+// each build with it before solving it; callers check it themselves to fail
+// before resolving the build environment.
+func CheckOffline(cfg *BuildConfig) error {
+	if cfg.CacheConfig != nil && cfg.CacheConfig.Registry != "" {
+		if err := checkOfflineRegistry("cache registry", cfg.CacheConfig.Registry); err != nil {
+			return err
+		}
+	}
+	if cfg.ApkoRegistryConfig != nil && cfg.ApkoRegistryConfig.Registry != "" {
+		if err := checkOfflineRegistry("apko registry", cfg.ApkoRegistryConfig.Registry); err != nil {
+			return err
+		}
+	}
+
+	mirror := cfg.CacheDir != ""
+	if err := checkOfflinePipelines(cfg.Pipelines, mirror); err != nil {
+		return fmt.Errorf("main pipelines: %w", err)
+	}
+	for _, sp := range cfg.Subpackages {
+		if err := checkOfflinePipelines(sp.Pipeline, mirror); err != nil {
+			return fmt.Errorf("subpackage %s pipelines: %w", sp.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkOfflinePipelines returns an error naming the first step of
+// pipelines, nested or not, that needs network access. Steps whose if does
+// not hold never run, and are not checked.
+func checkOfflinePipelines(pipelines []config.Pipeline, mirror bool) error {
+	for i := range pipelines {
+		p := &pipelines[i]
+		if run, err := shouldRun(p); err != nil || !run {
+			// An invalid if is reported when the step is built.
+			continue
+		}
+		if err := checkOfflineStep(p, mirror); err != nil {
+			return fmt.Errorf("pipeline %d: %w", i, err)
+		}
+		if err := checkOfflinePipelines(p.Pipeline, mirror); err != nil {
+			return fmt.Errorf("pipeline %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkOfflineStep returns an error if p downloads from the network. A
+// fetch step that pins the checksum of its download finds it in the local
+// mirror, when there is one, and a git-checkout step may clone a local
+// repository.
+func checkOfflineStep(p *config.Pipeline, mirror bool) error {
+	switch p.Uses {
+	case "fetch":
+		pinned := p.With["expected-sha256"] != "" || p.With["expected-sha512"] != ""
+		if mirror && pinned {
+			return nil
+		}
+		return fmt.Errorf("step %q fetches %s: %w", pipelineName(p), p.With["uri"], ErrOffline)
+	case "git-checkout":
+		if isLocalRepository(p.With["repository"]) {
+			return nil
+		}
+		return fmt.Errorf("step %q clones %s: %w", pipelineName(p), p.With["repository"], ErrOffline)
+	}
+	return nil
+}
+
+// isLocalRepository reports whether repository, as given to git clone, is a
+// path or a file:// URL rather than a remote.
+func isLocalRepository(repository string) bool {
+	if filepath.IsAbs(repository) {
+		return true
+	}
+	u, err := url.Parse(repository)
+	return err == nil && u.Scheme == "file"
+}
+
+// CheckOfflineRepository returns an error if the APK repository or key at
+// location, described by what, is not on this host: it must be a path, a
+// file:// URL or a URL of a loopback address. The tag of a tagged
+// repository, as in "@local /packages", is ignored.
+func CheckOfflineRepository(what, location string) error {
+	if strings.HasPrefix(location, "@") {
+		_, location, _ = strings.Cut(location, " ")
+		location = strings.TrimSpace(location)
+	}
+	if !strings.Contains(location, "://") || isLocalRepository(location) {
+		return nil
+	}
+	if u, err := url.Parse(location); err == nil && isLocalRegistry(u.Host) {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %w", what, location, ErrOffline)
+}
+
+// checkOfflineRegistry returns an error if the registry of ref, described
+// by what, is not on this host.
+func checkOfflineRegistry(what, ref string) error {
+	if isLocalRegistry(ref) {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %w", what, ref, ErrOffline)
+}
+
+// isLocalRegistry reports whether the registry of ref, as in
+// "localhost:5000/cache", is a loopback address.
+func isLocalRegistry(ref string) bool {
+	host, _, _ := strings.Cut(ref, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
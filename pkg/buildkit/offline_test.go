@@ -0,0 +1,174 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestOfflineBuildGraph(t *testing.T) {
+	ctx := slogtest.Context(t)
+	loader := slowLayerLoader{}
+
+	newConfig := func(t *testing.T, pipelines ...config.Pipeline) *BuildConfig {
+		return &BuildConfig{
+			PackageName:  "hello",
+			Arch:         apko_types.ParseArchitecture("x86_64"),
+			Pipelines:    pipelines,
+			WorkspaceDir: t.TempDir(),
+		}
+	}
+	fetch := config.Pipeline{
+		Uses: "fetch",
+		With: map[string]string{
+			"uri":             "https://example.com/hello-1.0.0.tar.gz",
+			"expected-sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		},
+		Pipeline: []config.Pipeline{{Runs: "wget https://example.com/hello-1.0.0.tar.gz"}},
+	}
+
+	t.Run("fetch step fails at construction", func(t *testing.T) {
+		// Were the layers loaded, the build would fail with this error.
+		loader := slowLayerLoader{err: errors.New("layers loaded")}
+		cfg := newConfig(t, config.Pipeline{Runs: "./configure"}, fetch)
+
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).WithOffline(true).buildGraph(ctx, loader, nil, cfg)
+		require.ErrorIs(t, err, ErrOffline)
+		require.ErrorContains(t, err, `main pipelines: pipeline 1: step "uses: fetch" fetches https://example.com/hello-1.0.0.tar.gz`)
+
+		// Online, the same build is constructed.
+		_, err = (&Builder{pipeline: NewPipelineBuilder()}).buildGraph(ctx, slowLayerLoader{}, nil, cfg)
+		require.NoError(t, err)
+	})
+
+	t.Run("git-checkout step fails at construction", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.Subpackages = []config.Subpackage{{
+			Name: "hello-data",
+			Pipeline: []config.Pipeline{{
+				Name: "clone data",
+				Uses: "git-checkout",
+				With: map[string]string{"repository": "https://github.com/example/hello-data"},
+			}},
+		}}
+
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).WithOffline(true).buildGraph(ctx, loader, nil, cfg)
+		require.ErrorIs(t, err, ErrOffline)
+		require.ErrorContains(t, err, `subpackage hello-data pipelines: pipeline 0: step "clone data" clones https://github.com/example/hello-data`)
+	})
+
+	t.Run("remote registry fails at construction", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.CacheConfig = &CacheConfig{Registry: "registry.example.com/melange-cache"}
+
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).WithOffline(true).buildGraph(ctx, loader, nil, cfg)
+		require.ErrorIs(t, err, ErrOffline)
+		require.ErrorContains(t, err, "cache registry registry.example.com/melange-cache")
+	})
+
+	t.Run("local sources succeed", func(t *testing.T) {
+		cfg := newConfig(t,
+			fetch,
+			config.Pipeline{
+				Uses: "git-checkout",
+				With: map[string]string{"repository": "file:///srv/git/hello-data"},
+			},
+			config.Pipeline{
+				// Steps that do not run are not checked.
+				If:   "'a' == 'b'",
+				Uses: "fetch",
+				With: map[string]string{"uri": "https://example.com/unpinned.tar.gz"},
+			},
+			config.Pipeline{Runs: "make"},
+		)
+		// The cache directory mirrors the pinned download.
+		cfg.CacheDir = t.TempDir()
+		cfg.CacheConfig = &CacheConfig{Registry: "localhost:5000/melange-cache"}
+
+		g, err := (&Builder{pipeline: NewPipelineBuilder()}).WithOffline(true).buildGraph(ctx, loader, nil, cfg)
+		require.NoError(t, err)
+		defer g.cleanup()
+
+		// Every step runs without network access.
+		var steps int
+		for _, dt := range g.def.Def {
+			var op pb.Op
+			require.NoError(t, op.Unmarshal(dt))
+			exec := op.GetExec()
+			if exec == nil || !strings.Contains(strings.Join(exec.GetMeta().GetArgs(), " "), "\nexit 0") {
+				continue
+			}
+			steps++
+			require.Equal(t, pb.NetMode_NONE, exec.Network)
+		}
+		require.Equal(t, 2, steps)
+	})
+
+	t.Run("unpinned fetch needs the network despite a mirror", func(t *testing.T) {
+		unpinned := fetch
+		unpinned.With = map[string]string{"uri": "https://example.com/hello-1.0.0.tar.gz"}
+		cfg := newConfig(t, unpinned)
+		cfg.CacheDir = t.TempDir()
+
+		_, err := (&Builder{pipeline: NewPipelineBuilder()}).WithOffline(true).buildGraph(ctx, loader, nil, cfg)
+		require.ErrorIs(t, err, ErrOffline)
+	})
+}
+
+func TestIsLocalRegistry(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"localhost:5000/melange-cache":   true,
+		"localhost/melange-cache":        true,
+		"127.0.0.1:5000/apko-cache":      true,
+		"[::1]:5000/apko-cache":          true,
+		"registry:5000/melange-cache":    false,
+		"cgr.dev/chainguard/wolfi-base":  false,
+		"10.0.0.5:5000/melange-cache":    false,
+		"localhost.example.com/registry": false,
+	} {
+		require.Equal(t, want, isLocalRegistry(ref), ref)
+	}
+}
+
+func TestCheckOfflineRepository(t *testing.T) {
+	for location, local := range map[string]bool{
+		"/home/build/packages":                                true,
+		"./packages":                                          true,
+		"file:///home/build/packages":                         true,
+		"@local /home/build/packages":                         true,
+		"http://localhost:8080/packages":                      true,
+		"http://127.0.0.1/packages":                           true,
+		"/etc/apk/keys/melange.rsa.pub":                       true,
+		"https://packages.wolfi.dev/os":                       false,
+		"@wolfi https://packages.wolfi.dev/os":                false,
+		"https://packages.wolfi.dev/os/wolfi-signing.rsa.pub": false,
+	} {
+		err := CheckOfflineRepository("repository", location)
+		if local {
+			require.NoError(t, err, location)
+		} else {
+			require.ErrorIs(t, err, ErrOffline, location)
+		}
+	}
+}
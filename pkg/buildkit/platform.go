@@ -0,0 +1,85 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"fmt"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/containerd/platforms"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociPlatform returns the OCI platform that builds for arch run on.
+func ociPlatform(arch apko_types.Architecture) ocispecs.Platform {
+	p := arch.ToOCIPlatform()
+	return ocispecs.Platform{
+		OS:           p.OS,
+		Architecture: p.Architecture,
+		Variant:      p.Variant,
+	}
+}
+
+// llbPlatform returns the LLB constraint that runs operations on arch.
+func llbPlatform(arch apko_types.Architecture) llb.ConstraintsOpt {
+	return llb.Platform(ociPlatform(arch))
+}
+
+// checkPlatform verifies that one of the workers can run target, natively or
+// under emulation, and reports whether emulation is needed. A worker's first
+// platform is its native one; BuildKit advertises the others when QEMU
+// binfmt handlers are registered on the host.
+func checkPlatform(workers []*client.WorkerInfo, target ocispecs.Platform) (emulated bool, err error) {
+	match := platforms.NewMatcher(target)
+
+	var native []string
+	for _, w := range workers {
+		for i, p := range w.Platforms {
+			if !match.Match(p) {
+				continue
+			}
+			if i == 0 {
+				return false, nil
+			}
+			emulated = true
+		}
+		if len(w.Platforms) > 0 {
+			native = append(native, platforms.Format(w.Platforms[0]))
+		}
+	}
+	if emulated {
+		return true, nil
+	}
+
+	return false, fmt.Errorf("no buildkit worker supports %s (native platforms: %v); register QEMU emulation on the buildkit host, e.g. with `docker run --privileged --rm tonistiigi/binfmt --install %s`",
+		platforms.Format(target), native, target.Architecture)
+}
+
+// checkCrossEmulation verifies that the daemon can build for arch, logging
+// when the build will run under emulation.
+func (b *Builder) checkCrossEmulation(ctx context.Context, arch apko_types.Architecture) (bool, error) {
+	var opts []client.ListWorkersOption
+	if b.workerFilter != "" {
+		opts = append(opts, client.WithFilter([]string{b.workerFilter}))
+	}
+	workers, err := b.client.Client().ListWorkers(ctx, opts...)
+	if err != nil {
+		return false, fmt.Errorf("listing buildkit workers: %w", err)
+	}
+	return checkPlatform(workers, ociPlatform(arch))
+}
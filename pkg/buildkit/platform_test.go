@@ -0,0 +1,94 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLLBPlatform(t *testing.T) {
+	for arch, want := range map[string]string{
+		"x86_64":  "amd64",
+		"aarch64": "arm64",
+	} {
+		state := llb.Image(TestBaseImage).Run(llb.Args([]string{"true"})).Root()
+		def, err := state.Marshal(context.Background(), llbPlatform(apko_types.ParseArchitecture(arch)))
+		require.NoError(t, err)
+
+		var ops int
+		for _, dt := range def.Def {
+			var op pb.Op
+			require.NoError(t, op.Unmarshal(dt))
+			if op.GetOp() == nil {
+				continue
+			}
+			ops++
+			require.Equal(t, "linux", op.GetPlatform().GetOS())
+			require.Equal(t, want, op.GetPlatform().GetArchitecture())
+		}
+		require.NotZero(t, ops)
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	amd64 := ocispecs.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispecs.Platform{OS: "linux", Architecture: "arm64"}
+	riscv64 := ocispecs.Platform{OS: "linux", Architecture: "riscv64"}
+
+	nativeOnly := []*client.WorkerInfo{{ID: "w1", Platforms: []ocispecs.Platform{amd64}}}
+	withQEMU := []*client.WorkerInfo{{ID: "w1", Platforms: []ocispecs.Platform{amd64, arm64}}}
+
+	t.Run("native", func(t *testing.T) {
+		emulated, err := checkPlatform(nativeOnly, amd64)
+		require.NoError(t, err)
+		require.False(t, emulated)
+	})
+
+	t.Run("emulated", func(t *testing.T) {
+		emulated, err := checkPlatform(withQEMU, arm64)
+		require.NoError(t, err)
+		require.True(t, emulated)
+	})
+
+	t.Run("native on another worker", func(t *testing.T) {
+		workers := append(withQEMU, &client.WorkerInfo{ID: "w2", Platforms: []ocispecs.Platform{arm64}})
+		emulated, err := checkPlatform(workers, arm64)
+		require.NoError(t, err)
+		require.False(t, emulated)
+	})
+
+	t.Run("missing emulated platform", func(t *testing.T) {
+		_, err := checkPlatform(nativeOnly, arm64)
+		require.ErrorContains(t, err, "no buildkit worker supports linux/arm64")
+		require.ErrorContains(t, err, "linux/amd64")
+		require.ErrorContains(t, err, "binfmt --install arm64")
+
+		_, err = checkPlatform(withQEMU, riscv64)
+		require.ErrorContains(t, err, "linux/riscv64")
+	})
+
+	t.Run("no workers", func(t *testing.T) {
+		_, err := checkPlatform(nil, amd64)
+		require.Error(t, err)
+	})
+}
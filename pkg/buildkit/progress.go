@@ -40,14 +40,43 @@ const (
 	ProgressModeTTY ProgressMode = "tty"
 	// ProgressModeQuiet suppresses progress output.
 	ProgressModeQuiet ProgressMode = "quiet"
+	// ProgressModeMinimal suppresses progress output but for failed steps,
+	// which are logged as errors with their output once the solve ends.
+	ProgressModeMinimal ProgressMode = "minimal"
 )
 
+// VertexStatus is a structured progress update for a single build step.
+// It is delivered to a ProgressCallback for every vertex or log update
+// received from BuildKit, in the order the updates arrive.
+type VertexStatus struct {
+	// ID uniquely identifies the build step within a solve.
+	ID string
+	// Name is the display name of the build step.
+	Name string
+	// Started is set once the step has started.
+	Started *time.Time
+	// Completed is set once the step has finished.
+	Completed *time.Time
+	// Cached reports whether the step result came from the cache.
+	Cached bool
+	// Error holds the failure message if the step failed.
+	Error string
+	// Log holds stdout/stderr output if this update carries log data.
+	Log []byte
+}
+
+// ProgressCallback receives structured progress updates. It is called
+// synchronously from the progress loop and should not block.
+type ProgressCallback func(VertexStatus)
+
 // ProgressWriter handles BuildKit solve status updates and displays progress.
 type ProgressWriter struct {
 	mode      ProgressMode
 	out       io.Writer
 	showLogs  bool
 	startTime time.Time
+	callback  ProgressCallback
+	redactor  *Redactor
 
 	mu          sync.Mutex
 	vertices    map[digest.Digest]*vertexState
@@ -77,6 +106,21 @@ func NewProgressWriter(out io.Writer, mode ProgressMode, showLogs bool) *Progres
 	}
 }
 
+// WithCallback sets a callback that receives each status update in addition
+// to the displayed progress. Use ProgressModeQuiet to receive callbacks only.
+func (p *ProgressWriter) WithCallback(cb ProgressCallback) *ProgressWriter {
+	p.callback = cb
+	return p
+}
+
+// WithRedactor masks the secret values known to r in step names, errors and
+// logs, both in the displayed progress and in the updates passed to the
+// callback.
+func (p *ProgressWriter) WithRedactor(r *Redactor) *ProgressWriter {
+	p.redactor = r
+	return p
+}
+
 // Write processes status updates from BuildKit and displays progress.
 // This should be called in a goroutine while Solve is running.
 func (p *ProgressWriter) Write(ctx context.Context, ch chan *client.SolveStatus) error {
@@ -102,10 +146,11 @@ func (p *ProgressWriter) processStatus(log *clog.Logger, status *client.SolveSta
 
 	// Process vertices (build steps)
 	for _, v := range status.Vertexes {
+		name, vErr := p.redactor.Redact(v.Name), p.redactor.Redact(v.Error)
 		state, exists := p.vertices[v.Digest]
 		if !exists {
 			state = &vertexState{
-				name: v.Name,
+				name: name,
 			}
 			p.vertices[v.Digest] = state
 			p.vertexOrder = append(p.vertexOrder, v.Digest)
@@ -121,8 +166,8 @@ func (p *ProgressWriter) processStatus(log *clog.Logger, status *client.SolveSta
 		if v.Completed != nil && state.completed == nil {
 			state.completed = v.Completed
 			state.cached = v.Cached
-			if v.Error != "" {
-				state.error = v.Error
+			if vErr != "" {
+				state.error = vErr
 			}
 			p.printVertexCompleted(log, state)
 			p.completed++
@@ -130,6 +175,17 @@ func (p *ProgressWriter) processStatus(log *clog.Logger, status *client.SolveSta
 				p.cached++
 			}
 		}
+
+		if p.callback != nil {
+			p.callback(VertexStatus{
+				ID:        v.Digest.String(),
+				Name:      name,
+				Started:   v.Started,
+				Completed: v.Completed,
+				Cached:    v.Cached,
+				Error:     vErr,
+			})
+		}
 	}
 
 	// Process logs (stdout/stderr from build steps)
@@ -137,10 +193,22 @@ func (p *ProgressWriter) processStatus(log *clog.Logger, status *client.SolveSta
 	for _, l := range status.Logs {
 		state, exists := p.vertices[l.Vertex]
 		if exists {
-			state.logs = append(state.logs, l.Data...)
+			data := p.redactor.RedactBytes(l.Data)
+			state.logs = append(state.logs, data...)
 			// Only print logs in real-time if showLogs is enabled
 			if p.showLogs {
-				p.printLog(log, state, l.Data)
+				p.printLog(log, state, data)
+			}
+			if p.callback != nil {
+				p.callback(VertexStatus{
+					ID:        l.Vertex.String(),
+					Name:      state.name,
+					Started:   state.started,
+					Completed: state.completed,
+					Cached:    state.cached,
+					Error:     state.error,
+					Log:       data,
+				})
 			}
 		}
 	}
@@ -199,7 +267,7 @@ func (p *ProgressWriter) printVertexCompleted(log *clog.Logger, state *vertexSta
 }
 
 func (p *ProgressWriter) printLog(log *clog.Logger, _ *vertexState, data []byte) {
-	if p.mode == ProgressModeQuiet {
+	if p.mode == ProgressModeQuiet || p.mode == ProgressModeMinimal {
 		return
 	}
 	p.printLogUnlocked(log, data)
@@ -207,12 +275,16 @@ func (p *ProgressWriter) printLog(log *clog.Logger, _ *vertexState, data []byte)
 
 // printLogUnlocked prints log data without checking mode (for use when lock is held).
 func (p *ProgressWriter) printLogUnlocked(log *clog.Logger, data []byte) {
-	// Print each line with a prefix
+	printLogLines(log.Infof, data)
+}
+
+// printLogLines prints each line of data with a prefix through logf.
+func printLogLines(logf func(string, ...any), data []byte) {
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
 		line = strings.TrimRight(line, "\r")
 		if line != "" {
-			log.Infof("    | %s", line)
+			logf("    | %s", line)
 		}
 	}
 }
@@ -240,6 +312,11 @@ func (p *ProgressWriter) printSummary(log *clog.Logger) {
 		}
 	}
 
+	if p.mode == ProgressModeMinimal {
+		p.printFailedSteps(log)
+		return
+	}
+
 	// Print logs from failed steps (catches any logs that came in after completion)
 	if len(failedSteps) > 0 && !p.showLogs {
 		log.Infof("")
@@ -265,6 +342,23 @@ func (p *ProgressWriter) printSummary(log *clog.Logger) {
 	log.Infof("  Duration:     %.1fs", elapsed.Seconds())
 }
 
+// printFailedSteps logs each failed step, and its output, as an error.
+// The lock must be held.
+func (p *ProgressWriter) printFailedSteps(log *clog.Logger) {
+	for _, d := range p.vertexOrder {
+		state := p.vertices[d]
+		if state.error == "" {
+			continue
+		}
+		name := p.formatName(state.name)
+		if name == "" {
+			name = state.name
+		}
+		log.Errorf("step failed: %s: %s", name, state.error)
+		printLogLines(log.Errorf, state.logs)
+	}
+}
+
 // formatName cleans up vertex names for display.
 func (p *ProgressWriter) formatName(name string) string {
 	// Skip internal operations
@@ -17,9 +17,12 @@ package buildkit
 import (
 	"bytes"
 	"context"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/chainguard-dev/clog"
 	"github.com/moby/buildkit/client"
 	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/require"
@@ -40,6 +43,7 @@ func TestProgressModeConstants(t *testing.T) {
 	require.Equal(t, ProgressMode("plain"), ProgressModePlain)
 	require.Equal(t, ProgressMode("tty"), ProgressModeTTY)
 	require.Equal(t, ProgressMode("quiet"), ProgressModeQuiet)
+	require.Equal(t, ProgressMode("minimal"), ProgressModeMinimal)
 }
 
 func TestProgressWriterGetSummary(t *testing.T) {
@@ -288,3 +292,83 @@ func TestProgressWriterWriteError(t *testing.T) {
 
 	require.Equal(t, "something went wrong", state.error)
 }
+
+func TestProgressWriterMinimal(t *testing.T) {
+	var out bytes.Buffer
+	ctx := clog.WithLogger(context.Background(), clog.New(slog.NewTextHandler(&out, nil)))
+
+	pw := NewProgressWriter(&bytes.Buffer{}, ProgressModeMinimal, false)
+	ch := make(chan *client.SolveStatus)
+	done := make(chan error)
+	go func() {
+		done <- pw.Write(ctx, ch)
+	}()
+
+	ok, failed := digest.FromString("ok-vertex"), digest.FromString("failed-vertex")
+	now := time.Now()
+	ch <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{
+			{Digest: ok, Name: "passing step", Started: &now, Completed: &now},
+			{Digest: failed, Name: "failing step", Started: &now, Completed: &now, Error: "exit code 1"},
+		},
+		Logs: []*client.VertexLog{
+			{Vertex: ok, Data: []byte("all good\n")},
+			{Vertex: failed, Data: []byte("boom\n")},
+		},
+	}
+	close(ch)
+	require.NoError(t, <-done)
+
+	got := out.String()
+	require.NotContains(t, got, "passing step")
+	require.NotContains(t, got, "all good")
+	require.NotContains(t, got, "Build summary")
+	require.Contains(t, got, "step failed: failing step: exit code 1")
+	require.Contains(t, got, "| boom")
+	require.Equal(t, 2, strings.Count(got, "level=ERROR"))
+}
+
+func TestProgressWriterCallback(t *testing.T) {
+	d1 := digest.FromString("vertex1")
+	d2 := digest.FromString("vertex2")
+	start := time.Now()
+	end := start.Add(time.Second)
+
+	var got []VertexStatus
+	pw := NewProgressWriter(&bytes.Buffer{}, ProgressModeQuiet, false).WithCallback(func(s VertexStatus) {
+		got = append(got, s)
+	})
+
+	ch := make(chan *client.SolveStatus, 4)
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: d1, Name: "step 1", Started: &start},
+		{Digest: d2, Name: "step 2"},
+	}}
+	ch <- &client.SolveStatus{Logs: []*client.VertexLog{
+		{Vertex: d1, Data: []byte("hello\n")},
+	}}
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: d1, Name: "step 1", Started: &start, Completed: &end, Cached: true},
+		{Digest: d2, Name: "step 2", Started: &start, Completed: &end, Error: "boom"},
+	}}
+	close(ch)
+
+	require.NoError(t, pw.Write(context.Background(), ch))
+
+	require.Equal(t, []VertexStatus{
+		{ID: d1.String(), Name: "step 1", Started: &start},
+		{ID: d2.String(), Name: "step 2"},
+		{ID: d1.String(), Name: "step 1", Started: &start, Log: []byte("hello\n")},
+		{ID: d1.String(), Name: "step 1", Started: &start, Completed: &end, Cached: true},
+		{ID: d2.String(), Name: "step 2", Started: &start, Completed: &end, Error: "boom"},
+	}, got)
+}
+
+func TestBuilderWithProgressCallback(t *testing.T) {
+	var called bool
+	b := &Builder{}
+	require.Same(t, b, b.WithProgressCallback(func(VertexStatus) { called = true }))
+	require.NotNil(t, b.progressCallback)
+	b.progressCallback(VertexStatus{})
+	require.True(t, called)
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces the value of a secret environment variable in logs.
+const RedactedValue = "***"
+
+// minRedactedLength is the length below which values are not redacted, as
+// masking every occurrence of a short value such as "1" would garble the
+// logs without protecting anything.
+const minRedactedLength = 4
+
+// secretNameParts are the parts of environment variable names that mark the
+// variable as holding a secret.
+var secretNameParts = []string{
+	"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL",
+	"API_KEY", "APIKEY", "ACCESS_KEY", "PRIVATE_KEY",
+}
+
+// IsSecretName reports whether the environment variable name looks like it
+// holds a secret, e.g. GITHUB_TOKEN or DB_PASSWORD.
+func IsSecretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, part := range secretNameParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor masks the values of secret environment variables in log output.
+// A variable is secret if it is named when the Redactor is created or if
+// IsSecretName reports that its name looks like a secret. The variables
+// themselves are left untouched; only what is logged is masked.
+//
+// A nil *Redactor is valid and redacts nothing.
+type Redactor struct {
+	mu       sync.RWMutex
+	names    map[string]bool
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// NewRedactor creates a Redactor that treats the named variables as secret,
+// in addition to those with secret-like names.
+func NewRedactor(names []string) *Redactor {
+	r := &Redactor{
+		names:  make(map[string]bool, len(names)),
+		values: map[string]bool{},
+	}
+	for _, name := range names {
+		r.names[name] = true
+	}
+	return r
+}
+
+// AddEnv records the values of the secret variables in env, so that they are
+// redacted from then on.
+func (r *Redactor) AddEnv(env map[string]string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := false
+	for name, value := range env {
+		if len(value) < minRedactedLength || r.values[value] {
+			continue
+		}
+		if r.names[name] || IsSecretName(name) {
+			r.values[value] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Replace longer values first, so that a secret containing another is
+	// masked as a whole.
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	oldnew := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		oldnew = append(oldnew, v, RedactedValue)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// Redact returns s with the values of secret variables replaced by
+// RedactedValue.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// RedactBytes is like Redact for byte slices. It returns b itself if there
+// is nothing to redact.
+func (r *Redactor) RedactBytes(b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	s := string(b)
+	if redacted := r.Redact(s); redacted != s {
+		return []byte(redacted)
+	}
+	return b
+}
+
+// RedactError returns err with the values of secret variables masked in its
+// message. The original error is still available to errors.Is and errors.As.
+func (r *Redactor) RedactError(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	msg := r.Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
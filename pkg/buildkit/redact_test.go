@@ -0,0 +1,159 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func TestIsSecretName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"GITHUB_TOKEN":          true,
+		"npm_token":             true,
+		"DB_PASSWORD":           true,
+		"AWS_SECRET_ACCESS_KEY": true,
+		"AWS_ACCESS_KEY_ID":     true,
+		"STRIPE_API_KEY":        true,
+		"SSH_PRIVATE_KEY":       true,
+		"REGISTRY_CREDENTIALS":  true,
+		"HOME":                  false,
+		"PATH":                  false,
+		"GIT_AUTHOR_NAME":       false,
+		"SOURCE_DATE_EPOCH":     false,
+	} {
+		require.Equal(t, want, IsSecretName(name), name)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor([]string{"DEPLOY_URL"})
+	r.AddEnv(map[string]string{
+		"GITHUB_TOKEN": "ghp_abc123",
+		"DEPLOY_URL":   "https://user:pw@example.com",
+		"HOME":         "/home/build",
+		"API_KEY":      "x",
+	})
+
+	require.Equal(t,
+		"token=*** url=*** home=/home/build key=x",
+		r.Redact("token=ghp_abc123 url=https://user:pw@example.com home=/home/build key=x"))
+
+	// Values recorded later are redacted too, and a secret containing
+	// another is masked as a whole.
+	r.AddEnv(map[string]string{"NPM_TOKEN": "ghp_abc123-long"})
+	require.Equal(t, "*** ***", r.Redact("ghp_abc123-long ghp_abc123"))
+
+	in := []byte("nothing secret")
+	require.Same(t, &in[0], &r.RedactBytes(in)[0])
+	require.Equal(t, "a *** b", string(r.RedactBytes([]byte("a ghp_abc123 b"))))
+
+	base := errors.New("exit code 1: curl -H ghp_abc123")
+	err := r.RedactError(fmt.Errorf("step failed: %w", base))
+	require.EqualError(t, err, "step failed: exit code 1: curl -H ***")
+	require.ErrorIs(t, err, base)
+	require.Nil(t, r.RedactError(nil))
+}
+
+func TestRedactorNil(t *testing.T) {
+	var r *Redactor
+	r.AddEnv(map[string]string{"GITHUB_TOKEN": "ghp_abc123"})
+	require.Equal(t, "ghp_abc123", r.Redact("ghp_abc123"))
+	err := errors.New("ghp_abc123")
+	require.Equal(t, err, r.RedactError(err))
+}
+
+func TestProgressWriterRedactsSecrets(t *testing.T) {
+	const secret = "ghp_s3cr3t"
+
+	builder := NewPipelineBuilder()
+	builder.BaseEnv["GITHUB_TOKEN"] = secret
+	builder.Redactor = NewRedactor(nil)
+
+	state, err := builder.BuildPipeline(llb.Image(TestBaseImage), &config.Pipeline{
+		Name: "fetch",
+		Runs: "curl -H \"Authorization: token $GITHUB_TOKEN\" https://example.com",
+	})
+	require.NoError(t, err)
+
+	// The variable is still set for the step.
+	def, err := state.Marshal(context.Background(), llb.LinuxAmd64)
+	require.NoError(t, err)
+	var env []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		require.NoError(t, op.Unmarshal(dt))
+		if exec := op.GetExec(); exec != nil {
+			env = append(env, exec.GetMeta().GetEnv()...)
+		}
+	}
+	require.Contains(t, env, "GITHUB_TOKEN="+secret)
+
+	// But its value never reaches the output or the callback.
+	var out bytes.Buffer
+	ctx := clog.WithLogger(context.Background(), clog.New(slog.NewTextHandler(&out, nil)))
+
+	var got []VertexStatus
+	pw := NewProgressWriter(&out, ProgressModePlain, true).
+		WithCallback(func(s VertexStatus) { got = append(got, s) }).
+		WithRedactor(builder.Redactor)
+
+	d := digest.FromString("fetch")
+	start := time.Now()
+	end := start.Add(time.Second)
+	ch := make(chan *client.SolveStatus, 3)
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: d, Name: "echo " + secret, Started: &start},
+	}}
+	ch <- &client.SolveStatus{Logs: []*client.VertexLog{
+		{Vertex: d, Data: []byte("+ curl -H 'Authorization: token " + secret + "'\n")},
+	}}
+	ch <- &client.SolveStatus{Vertexes: []*client.Vertex{
+		{Digest: d, Name: "echo " + secret, Started: &start, Completed: &end, Error: "bad token " + secret},
+	}}
+	close(ch)
+	require.NoError(t, pw.Write(ctx, ch))
+
+	require.NotEmpty(t, got)
+	for _, s := range got {
+		require.NotContains(t, s.Name, secret)
+		require.NotContains(t, s.Error, secret)
+		require.NotContains(t, string(s.Log), secret)
+	}
+	require.Equal(t, "bad token "+RedactedValue, got[len(got)-1].Error)
+	require.Contains(t, string(got[1].Log), "token "+RedactedValue)
+
+	require.Contains(t, out.String(), RedactedValue)
+	require.NotContains(t, out.String(), secret)
+	summary := pw.GetSummary()
+	for _, step := range summary.Steps {
+		require.NotContains(t, step.Name, secret)
+		require.NotContains(t, step.Error, secret)
+	}
+}
@@ -30,10 +30,20 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
+// SPDXMediaType is the media type of SPDX JSON SBOMs attached to images.
+const SPDXMediaType types.MediaType = "application/spdx+json"
+
+// ImageSBOMFunc generates an SBOM for img, returning the document and its
+// media type.
+type ImageSBOMFunc func(ctx context.Context, img v1.Image) ([]byte, types.MediaType, error)
+
 // ApkoImageCache caches apko-generated base images in a registry.
 // This significantly speeds up builds by allowing BuildKit to use
 // llb.Image() instead of llb.Local(), enabling native layer caching.
@@ -44,6 +54,14 @@ type ApkoImageCache struct {
 
 	// Insecure allows connecting to registries over HTTP.
 	Insecure bool
+
+	// SBOM, if set, generates an SBOM for each image pushed to the cache,
+	// which is attached to the image as an OCI referrer. Images that are
+	// already cached are left as they are.
+	SBOM ImageSBOMFunc
+
+	// UserAgent, if set, is sent with requests to the registry.
+	UserAgent string
 }
 
 // NewApkoImageCache creates a new ApkoImageCache.
@@ -83,6 +101,9 @@ func (c *ApkoImageCache) GetOrCreate(ctx context.Context, imgConfig apko_types.I
 	if c.Insecure {
 		remoteOpts = append(remoteOpts, remote.WithTransport(&http.Transport{}))
 	}
+	if c.UserAgent != "" {
+		remoteOpts = append(remoteOpts, remote.WithUserAgent(c.UserAgent))
+	}
 
 	// Check if image already exists
 	if _, err := remote.Head(imgRef, remoteOpts...); err == nil {
@@ -113,9 +134,63 @@ func (c *ApkoImageCache) GetOrCreate(ctx context.Context, imgConfig apko_types.I
 	pushDuration := time.Since(pushStart)
 	log.Infof("apko_image_push took %s (%d layers)", pushDuration, len(layers))
 
+	if c.SBOM != nil {
+		// The image is usable without its SBOM, so don't fail the build.
+		if err := c.attachSBOM(ctx, imgRef.Context(), img, remoteOpts); err != nil {
+			log.Warnf("unable to attach SBOM to %s: %v", ref, err)
+		}
+	}
+
 	return ref, false, nil
 }
 
+// attachSBOM pushes an SBOM for img to repo as an artifact whose subject is
+// img, so that it is listed by the registry's referrers API. On registries
+// without that API, the referrers tag fallback scheme is used instead.
+func (c *ApkoImageCache) attachSBOM(ctx context.Context, repo name.Repository, img v1.Image, remoteOpts []remote.Option) error {
+	log := clog.FromContext(ctx)
+
+	doc, mediaType, err := c.SBOM(ctx, img)
+	if err != nil {
+		return fmt.Errorf("generating SBOM: %w", err)
+	}
+
+	subject, err := partial.Descriptor(img)
+	if err != nil {
+		return fmt.Errorf("getting image descriptor: %w", err)
+	}
+
+	artifact, err := SBOMArtifact(doc, mediaType, *subject)
+	if err != nil {
+		return err
+	}
+
+	d, err := artifact.Digest()
+	if err != nil {
+		return fmt.Errorf("computing SBOM artifact digest: %w", err)
+	}
+	if err := remote.Write(repo.Digest(d.String()), artifact, remoteOpts...); err != nil {
+		return fmt.Errorf("pushing SBOM artifact: %w", err)
+	}
+
+	log.Infof("attached %s SBOM %s to %s@%s", mediaType, d, repo, subject.Digest)
+	return nil
+}
+
+// SBOMArtifact returns an artifact holding the SBOM doc, of mediaType,
+// whose subject is the image described by subject.
+func SBOMArtifact(doc []byte, mediaType types.MediaType, subject v1.Descriptor) (v1.Image, error) {
+	artifact, err := mutate.AppendLayers(empty.Image, static.NewLayer(doc, mediaType))
+	if err != nil {
+		return nil, fmt.Errorf("creating SBOM artifact: %w", err)
+	}
+	artifact = mutate.MediaType(artifact, types.OCIManifestSchema1)
+	// The config media type is reported as the artifact type by the
+	// referrers API.
+	artifact = mutate.ConfigMediaType(artifact, mediaType)
+	return mutate.Subject(artifact, subject).(v1.Image), nil
+}
+
 // hashConfig creates a deterministic hash of the image configuration.
 // This is used as the image tag to enable cache hits for identical configs.
 func (c *ApkoImageCache) hashConfig(cfg apko_types.ImageConfiguration) string {
@@ -0,0 +1,156 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApkoImageCacheAttachSBOM(t *testing.T) {
+	ctx := slogtest.Context(t)
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	t.Cleanup(srv.Close)
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/apko-cache"
+
+	layers := []v1.Layer{createTestLayer(t, map[string][]byte{
+		"etc/os-release": []byte("ID=test\n"),
+	})}
+	sbom := []byte(`{"spdxVersion":"SPDX-2.3","name":"apko base image"}`)
+
+	var generated []v1.Hash
+	cache := NewApkoImageCache(repo, true)
+	cache.SBOM = func(_ context.Context, img v1.Image) ([]byte, types.MediaType, error) {
+		d, err := img.Digest()
+		if err != nil {
+			return nil, "", err
+		}
+		generated = append(generated, d)
+		return sbom, SPDXMediaType, nil
+	}
+
+	ref, hit, err := cache.GetOrCreate(ctx, apko_types.ImageConfiguration{}, layers)
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	imgRef, err := name.ParseReference(ref, name.Insecure)
+	require.NoError(t, err)
+	desc, err := remote.Head(imgRef)
+	require.NoError(t, err)
+	require.Equal(t, []v1.Hash{desc.Digest}, generated)
+
+	// The SBOM is listed as a referrer of the pushed image.
+	idx, err := remote.Referrers(imgRef.Context().Digest(desc.Digest.String()))
+	require.NoError(t, err)
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, im.Manifests, 1)
+	require.Equal(t, string(SPDXMediaType), im.Manifests[0].ArtifactType)
+
+	// The referrer manifest points at the SBOM blob.
+	artifact, err := remote.Image(imgRef.Context().Digest(im.Manifests[0].Digest.String()))
+	require.NoError(t, err)
+	m, err := artifact.Manifest()
+	require.NoError(t, err)
+	require.NotNil(t, m.Subject)
+	require.Equal(t, desc.Digest, m.Subject.Digest)
+	require.Len(t, m.Layers, 1)
+	require.Equal(t, SPDXMediaType, m.Layers[0].MediaType)
+
+	blob, err := remote.Layer(imgRef.Context().Digest(m.Layers[0].Digest.String()))
+	require.NoError(t, err)
+	rc, err := blob.Compressed()
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, sbom, got)
+
+	// A cache hit does not attach another SBOM.
+	_, hit, err = cache.GetOrCreate(ctx, apko_types.ImageConfiguration{}, layers)
+	require.NoError(t, err)
+	require.True(t, hit)
+	require.Len(t, generated, 1)
+}
+
+func TestApkoImageCacheAttachSBOMFailure(t *testing.T) {
+	ctx := slogtest.Context(t)
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/apko-cache"
+
+	layers := []v1.Layer{createTestLayer(t, map[string][]byte{
+		"etc/os-release": []byte("ID=test\n"),
+	})}
+
+	cache := NewApkoImageCache(repo, true)
+	cache.SBOM = func(context.Context, v1.Image) ([]byte, types.MediaType, error) {
+		return nil, "", errors.New("no SBOM")
+	}
+
+	// The image is still pushed and usable.
+	ref, hit, err := cache.GetOrCreate(ctx, apko_types.ImageConfiguration{}, layers)
+	require.NoError(t, err)
+	require.False(t, hit)
+	imgRef, err := name.ParseReference(ref, name.Insecure)
+	require.NoError(t, err)
+	_, err = remote.Head(imgRef)
+	require.NoError(t, err)
+}
+
+func TestApkoImageCacheUserAgent(t *testing.T) {
+	ctx := slogtest.Context(t)
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	var mu sync.Mutex
+	var userAgents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.UserAgent())
+		mu.Unlock()
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/apko-cache"
+
+	layers := []v1.Layer{createTestLayer(t, map[string][]byte{
+		"etc/os-release": []byte("ID=test\n"),
+	})}
+
+	cache := NewApkoImageCache(repo, true)
+	cache.UserAgent = "melange-test/1.0"
+	_, _, err := cache.GetOrCreate(ctx, apko_types.ImageConfiguration{}, layers)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, userAgents)
+	for _, ua := range userAgents {
+		require.True(t, strings.HasPrefix(ua, "melange-test/1.0"), "User-Agent %q", ua)
+	}
+}
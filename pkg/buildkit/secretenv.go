@@ -0,0 +1,63 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+)
+
+// SecretEnvOpts returns llb.RunOption entries setting the variables of
+// names from the build secrets of the same ID, sorted for deterministic LLB
+// generation. Variables set in env are skipped, so that the environment of
+// a step overrides its secrets.
+//
+// Unlike llb.AddEnv, the values are not part of the LLB graph: they do not
+// change its cache keys and are never written to it.
+func SecretEnvOpts(names []string, env map[string]string) []llb.RunOption {
+	var opts []llb.RunOption
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		if _, ok := env[name]; ok {
+			continue
+		}
+		opts = append(opts, llb.AddSecret(name, llb.SecretID(name), llb.SecretAsEnv(true)))
+	}
+	return opts
+}
+
+// secretEnvSession returns the session attachables providing the values of
+// secretEnv as the build secrets SecretEnvOpts sets variables from.
+func secretEnvSession(secretEnv map[string]string) []session.Attachable {
+	if len(secretEnv) == 0 {
+		return nil
+	}
+	secrets := make(map[string][]byte, len(secretEnv))
+	for name, value := range secretEnv {
+		secrets[name] = []byte(value)
+	}
+	return []session.Attachable{secretsprovider.FromMap(secrets)}
+}
+
+// newSecretEnvRedactor creates a Redactor treating the named variables and
+// those of secretEnv as secret, with the values of secretEnv recorded.
+func newSecretEnvRedactor(names []string, secretEnv map[string]string) *Redactor {
+	r := NewRedactor(slices.Concat(names, slices.Collect(maps.Keys(secretEnv))))
+	r.AddEnv(secretEnv)
+	return r
+}
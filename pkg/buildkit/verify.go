@@ -0,0 +1,44 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrIncompleteExport is returned by VerifyExport for an export that is
+// obviously incomplete.
+var ErrIncompleteExport = errors.New("incomplete export")
+
+// VerifyExport checks that the melange-out tree exported to dir is not
+// obviously incomplete, as an export cut short can leave it without BuildKit
+// reporting an error. Every package of packages must have its output
+// directory, which the build creates whether or not the package has files.
+func VerifyExport(dir string, packages []string) error {
+	for _, pkg := range packages {
+		fi, err := os.Stat(filepath.Join(dir, pkg))
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && !fi.IsDir()) {
+			return fmt.Errorf("%w to %s: the output directory of package %s is missing", ErrIncompleteExport, dir, pkg)
+		} else if err != nil {
+			return fmt.Errorf("checking export to %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
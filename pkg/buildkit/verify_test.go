@@ -0,0 +1,86 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyExport(t *testing.T) {
+	packages := []string{"hello", "hello-dev", "hello-meta"}
+
+	// export lays out an exported melange-out tree with the given files,
+	// and the directories of the given packages.
+	export := func(t *testing.T, dirs []string, files map[string]string) string {
+		dir := t.TempDir()
+		for _, d := range dirs {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0o755))
+		}
+		for name, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		}
+		return dir
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		dir := export(t, packages, map[string]string{
+			"hello/usr/bin/hello":               "#!/bin/sh\necho hello\n",
+			"hello/usr/lib/python3/__init__.py": "",
+			"hello-dev/usr/include/hello.h":     "void hello(void);\n",
+		})
+		require.NoError(t, VerifyExport(dir, packages))
+	})
+
+	t.Run("nothing exported but package directories", func(t *testing.T) {
+		// Empty packages are left for the empty package check.
+		require.NoError(t, VerifyExport(export(t, packages, nil), packages))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		dir := export(t, nil, map[string]string{
+			"hello/usr/bin/hello": "#!/bin/sh\necho hello\n",
+		})
+		err := VerifyExport(dir, packages)
+		require.ErrorIs(t, err, ErrIncompleteExport)
+		require.EqualError(t, err, "incomplete export to "+dir+": the output directory of package hello-dev is missing")
+	})
+
+	t.Run("package directory is a file", func(t *testing.T) {
+		dir := export(t, []string{"hello", "hello-dev"}, map[string]string{
+			"hello/usr/bin/hello": "#!/bin/sh\necho hello\n",
+			"hello-meta":          "",
+		})
+		require.ErrorIs(t, VerifyExport(dir, packages), ErrIncompleteExport)
+	})
+
+	t.Run("only empty files", func(t *testing.T) {
+		// Packages may well hold nothing but empty files.
+		dir := export(t, packages, map[string]string{
+			"hello/usr/lib/python3/__init__.py": "",
+			"hello-dev/usr/share/hello/.keep":   "",
+		})
+		require.NoError(t, VerifyExport(dir, packages))
+	})
+
+	t.Run("missing export", func(t *testing.T) {
+		err := VerifyExport(filepath.Join(t.TempDir(), "melange-out"), packages)
+		require.ErrorIs(t, err, ErrIncompleteExport)
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// GCOptions are the limits GC evicts the entries of a cache directory by.
+// A zero limit does not evict anything.
+type GCOptions struct {
+	// MaxAge evicts entries last modified longer ago than it.
+	MaxAge time.Duration
+	// MaxSize evicts the least recently modified entries, beyond those
+	// evicted by MaxAge, until the cache holds at most MaxSize bytes.
+	MaxSize uint64
+}
+
+// GCResult is what GC did to a cache directory.
+type GCResult struct {
+	// Evicted are the names of the entries removed.
+	Evicted []string
+	// Reclaimed is the number of bytes of the entries removed.
+	Reclaimed uint64
+	// InUse are the names of the entries that would have been removed,
+	// but were in use by a build.
+	InUse []string
+	// Size is the number of bytes left in the cache.
+	Size uint64
+}
+
+// entry is a top-level file or directory of a cache directory.
+type entry struct {
+	name    string
+	size    uint64
+	modTime time.Time
+}
+
+// GC evicts entries of the cache directory dir, its top-level files and
+// directories, past the limits of opts. The age of a directory is that of
+// the most recently modified file in it, as directories are modified by
+// adding to them. While a build uses the cache, holding it with Acquire,
+// its entries are in use and skipped, so that GC can run concurrently with
+// builds sharing the cache.
+func GC(ctx context.Context, dir string, opts GCOptions) (*GCResult, error) {
+	entries, err := scan(dir)
+	if err != nil {
+		return nil, err
+	}
+	unlock, unused, err := tryLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	if unused {
+		defer unlock()
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	result := &GCResult{}
+	for _, e := range entries {
+		result.Size += e.size
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		expired := opts.MaxAge > 0 && now.Sub(e.modTime) > opts.MaxAge
+		oversize := opts.MaxSize > 0 && result.Size > opts.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+
+		if !unused {
+			result.InUse = append(result.InUse, e.name)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.name)); err != nil {
+			return nil, fmt.Errorf("evicting %s: %w", e.name, err)
+		}
+		result.Evicted = append(result.Evicted, e.name)
+		result.Reclaimed += e.size
+		result.Size -= e.size
+	}
+	return result, nil
+}
+
+// scan returns the entries of the cache directory dir.
+func scan(dir string) ([]entry, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache dir: %w", err)
+	}
+
+	entries := make([]entry, 0, len(des))
+	for _, de := range des {
+		if de.Name() == LockName {
+			continue
+		}
+		e := entry{name: de.Name()}
+		err := filepath.WalkDir(filepath.Join(dir, de.Name()), func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			e.size += uint64(info.Size())
+			if info.ModTime().After(e.modTime) {
+				e.modTime = info.ModTime()
+			}
+			return nil
+		})
+		if err == nil && e.modTime.IsZero() {
+			// A directory without files is as old as itself.
+			var info fs.FileInfo
+			if info, err = de.Info(); err == nil {
+				e.modTime = info.ModTime()
+			}
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// Evicted by another GC since it was listed.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("scanning cache entry %s: %w", de.Name(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEntry writes a cache entry of size bytes to dir, last modified age
+// ago.
+func writeEntry(t *testing.T, dir, name string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o644))
+	mtime := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	des, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, de := range des {
+		if de.Name() != LockName {
+			names = append(names, de.Name())
+		}
+	}
+	return names
+}
+
+func TestGC(t *testing.T) {
+	ctx := slogtest.Context(t)
+	day := 24 * time.Hour
+
+	newCache := func(t *testing.T) string {
+		dir := t.TempDir()
+		writeEntry(t, dir, "sha256:old", 100, 30*day)
+		writeEntry(t, dir, "sha256:older", 200, 60*day)
+		writeEntry(t, dir, "sha256:recent", 300, time.Hour)
+		// A directory is as old as its newest file.
+		writeEntry(t, dir, "gomod/cache/old", 400, 40*day)
+		writeEntry(t, dir, "gomod/cache/recent", 500, 2*day)
+		return dir
+	}
+
+	t.Run("by age", func(t *testing.T) {
+		dir := newCache(t)
+		result, err := GC(ctx, dir, GCOptions{MaxAge: 7 * day})
+		require.NoError(t, err)
+		require.Equal(t, []string{"sha256:older", "sha256:old"}, result.Evicted)
+		require.Equal(t, uint64(300), result.Reclaimed)
+		require.Equal(t, uint64(1200), result.Size)
+		require.Equal(t, []string{"gomod", "sha256:recent"}, names(t, dir))
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		dir := newCache(t)
+		result, err := GC(ctx, dir, GCOptions{MaxSize: 1000})
+		require.NoError(t, err)
+		require.Equal(t, []string{"sha256:older", "sha256:old", "gomod"}, result.Evicted)
+		require.Equal(t, uint64(1200), result.Reclaimed)
+		require.Equal(t, uint64(300), result.Size)
+		require.Equal(t, []string{"sha256:recent"}, names(t, dir))
+	})
+
+	t.Run("by age and size", func(t *testing.T) {
+		dir := newCache(t)
+		result, err := GC(ctx, dir, GCOptions{MaxAge: 45 * day, MaxSize: 1200})
+		require.NoError(t, err)
+		require.Equal(t, []string{"sha256:older", "sha256:old"}, result.Evicted)
+		require.Equal(t, []string{"gomod", "sha256:recent"}, names(t, dir))
+	})
+
+	t.Run("within limits", func(t *testing.T) {
+		dir := newCache(t)
+		result, err := GC(ctx, dir, GCOptions{MaxAge: 90 * day, MaxSize: 1500})
+		require.NoError(t, err)
+		require.Empty(t, result.Evicted)
+		require.Zero(t, result.Reclaimed)
+		require.Len(t, names(t, dir), 4)
+	})
+
+	t.Run("entries in use are skipped", func(t *testing.T) {
+		dir := newCache(t)
+		release, err := Acquire(dir)
+		require.NoError(t, err)
+
+		result, err := GC(ctx, dir, GCOptions{MaxAge: 7 * day})
+		require.NoError(t, err)
+		require.Empty(t, result.Evicted)
+		require.Equal(t, []string{"sha256:older", "sha256:old"}, result.InUse)
+		require.Len(t, names(t, dir), 4)
+
+		// Once released, they are evicted.
+		release()
+		result, err = GC(ctx, dir, GCOptions{MaxAge: 7 * day})
+		require.NoError(t, err)
+		require.Equal(t, []string{"sha256:older", "sha256:old"}, result.Evicted)
+	})
+
+	t.Run("missing cache dir", func(t *testing.T) {
+		_, err := GC(ctx, filepath.Join(t.TempDir(), "missing"), GCOptions{MaxAge: day})
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		release, err := Acquire(filepath.Join(t.TempDir(), "missing"))
+		require.NoError(t, err)
+		release()
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LockName is the file of a cache directory that builds using it lock
+// shared, and that evicting its entries locks exclusively. It is not an
+// entry of the cache.
+const LockName = ".melange-cache.lock"
+
+// Acquire marks the cache directory dir as in use until the returned
+// function is called, so that GC leaves its entries alone. It holds a
+// shared lock on the LockName file of dir; GC evicts entries only if it can
+// lock it exclusively. A dir that does not exist, or is read-only without a
+// lock file, has nothing to acquire, as has an empty dir.
+func Acquire(dir string) (release func(), err error) {
+	if dir == "" {
+		return func() {}, nil
+	}
+	f, err := openLock(dir)
+	if err != nil || f == nil {
+		return func() {}, err
+	}
+	if _, err := lockFile(f, false, true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking cache dir %s: %w", dir, err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// Lock locks the cache directory dir exclusively, waiting until no build
+// holds it with Acquire, and returns the function unlocking it.
+func Lock(dir string) (unlock func(), err error) {
+	f, err := openLock(dir)
+	if err != nil || f == nil {
+		return func() {}, err
+	}
+	if _, err := lockFile(f, true, true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking cache dir %s: %w", dir, err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// tryLock locks the cache directory dir exclusively, unless it is in use.
+func tryLock(dir string) (unlock func(), ok bool, err error) {
+	f, err := openLock(dir)
+	if err != nil || f == nil {
+		return func() {}, err == nil, err
+	}
+	if ok, err := lockFile(f, true, false); err != nil || !ok {
+		f.Close()
+		return nil, false, err
+	}
+	return func() { f.Close() }, true, nil
+}
+
+// openLock opens the LockName file of dir, creating it if it can. It
+// returns a nil file if dir does not exist, or is read-only and has none.
+func openLock(dir string) (*os.File, error) {
+	path := filepath.Join(dir, LockName)
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o644)
+	if errors.Is(err, fs.ErrPermission) || isReadOnly(err) {
+		f, err = os.Open(path)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening cache lock: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package cache
+
+import "os"
+
+// lockFile does not lock f: cache directories are only locked on Unix, and
+// GC does not skip entries in use elsewhere.
+func lockFile(*os.File, bool, bool) (bool, error) {
+	return true, nil
+}
+
+// isReadOnly reports whether err is from writing to a read-only filesystem.
+func isReadOnly(error) bool {
+	return false
+}
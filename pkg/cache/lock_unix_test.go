@@ -0,0 +1,53 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	release, err := Acquire(dir)
+	require.NoError(t, err)
+	// Builds share the cache.
+	other, err := Acquire(dir)
+	require.NoError(t, err)
+	other()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := Lock(dir)
+		require.NoError(t, err)
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("locked a cache dir in use")
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-locked:
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not lock the cache dir once released")
+	}
+}
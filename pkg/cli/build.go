@@ -16,15 +16,19 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
 	"github.com/chainguard-dev/clog"
-	"github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"go.opentelemetry.io/otel"
@@ -33,43 +37,73 @@ import (
 
 	"github.com/dlorenc/melange2/pkg/build"
 	"github.com/dlorenc/melange2/pkg/buildkit"
+	"github.com/dlorenc/melange2/pkg/config"
 	"github.com/dlorenc/melange2/pkg/convention"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 	"github.com/dlorenc/melange2/pkg/linter"
+	"github.com/dlorenc/melange2/pkg/service/tracing"
 )
 
 // addBuildFlags registers all build command flags to the provided FlagSet using the BuildFlags struct
 func addBuildFlags(fs *pflag.FlagSet, flags *BuildFlags) {
-	fs.StringVar(&flags.BuildDate, "build-date", "", "date used for the timestamps of the files inside the image")
+	fs.StringVar(&flags.BuildDate, "build-date", "", "date used for the timestamps of the files inside the packages, as an RFC 3339 date or a Unix timestamp; SOURCE_DATE_EPOCH takes precedence (default 1970-01-01T00:00:00Z)")
 	fs.StringVar(&flags.WorkspaceDir, "workspace-dir", "", "directory used for the workspace at /home/build")
 	fs.StringVar(&flags.PipelineDir, "pipeline-dir", "", "directory used to extend defined built-in pipelines")
+	fs.BoolVar(&flags.ErrorOnShadow, "error-on-shadow", false, "fail when a pipeline of --pipeline-dir shadows a builtin pipeline, rather than warning")
+	fs.BoolVar(&flags.NormalizePipelines, "normalize-pipelines", false, "collapse pipelines that only wrap a single nested pipeline (changes log structure)")
+	fs.StringVar(&flags.StopAfter, "stop-after", "", "stop the build after the pipeline step with this name, keeping the partial workspace without packaging it")
 	fs.StringVar(&flags.SourceDir, "source-dir", "", "directory used for included sources")
 	fs.StringVar(&flags.CacheDir, "cache-dir", "./melange-cache/", "directory used for cached inputs")
+	fs.BoolVar(&flags.CacheDirReadOnly, "cache-dir-ro", false, "treat --cache-dir as a read-only shared cache that must already exist and is never written to")
 	fs.StringVar(&flags.ApkCacheDir, "apk-cache-dir", "", "directory used for cached apk packages (default is system-defined cache directory)")
 	fs.StringVar(&flags.SigningKey, "signing-key", "", "key to use for signing")
 	fs.StringVar(&flags.EnvFile, "env-file", "", "file to use for preloaded environment variables")
 	fs.StringVar(&flags.VarsFile, "vars-file", "", "file to use for preloaded build configuration variables")
 	fs.BoolVar(&flags.GenerateIndex, "generate-index", true, "whether to generate APKINDEX.tar.gz")
+	fs.BoolVar(&flags.VerifyInstall, "verify-install", false, "after building, verify that the packages install against the generated index")
+	fs.BoolVar(&flags.FailOnEmptyPackage, "fail-on-empty-package", true, "fail the build if the main package is empty, unless it sets options.no-provides")
 	fs.BoolVar(&flags.EmptyWorkspace, "empty-workspace", false, "whether the build workspace should be empty")
+	fs.StringArrayVar(&flags.IncludeSource, "include-source", nil, "with --empty-workspace, copy the files of the source directory matching this pattern, in .melangeignore syntax, into the workspace; may be repeated")
 	fs.BoolVar(&flags.StripOriginName, "strip-origin-name", false, "whether origin names should be stripped (for bootstrap)")
 	fs.StringVar(&flags.OutDir, "out-dir", "./packages/", "directory where packages will be output")
+	fs.StringVar(&flags.APKNameTemplate, "apk-name-template", "", "file name template of built packages, with the placeholders {name}, {version}, {epoch} and {arch} (default \"{name}-{version}-r{epoch}.apk\")")
 	fs.StringVar(&flags.DependencyLog, "dependency-log", "", "log dependencies to a specified file")
+	fs.StringVar(&flags.DependencyLogFormat, "dependency-log-format", build.DependencyLogFormatText, "format of the dependency log: text or json")
 	fs.StringVar(&flags.PurlNamespace, "namespace", "unknown", "namespace to use in package URLs in SBOM (eg wolfi, alpine)")
+	fs.StringArrayVar(&flags.SBOMExtraPackages, "sbom-extra-package", nil, "package URL of an extra package to declare in the SBOM (e.g., pkg:golang/github.com/foo/bar@v1.2.3); may be repeated")
+	fs.IntVar(&flags.SBOMConcurrency, "sbom-concurrency", runtime.NumCPU(), "number of packages whose SBOMs are generated in parallel")
 	fs.StringSliceVar(&flags.Archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config")
 	fs.StringVar(&flags.Libc, "override-host-triplet-libc-substitution-flavor", "gnu", "override the flavor of libc for ${{host.triplet.*}} substitutions (e.g. gnu,musl) -- default is gnu")
 	fs.StringSliceVar(&flags.BuildOption, "build-option", []string{}, "build options to enable")
-	fs.StringVar(&flags.BuildKitAddr, "buildkit-addr", buildkit.DefaultAddr, "BuildKit daemon address (e.g., tcp://localhost:1234)")
+	fs.StringVar(&flags.BuildKitAddr, "buildkit-addr", buildkit.DefaultAddr, "BuildKit daemon address (e.g., tcp://localhost:1234); when not given, $BUILDKIT_HOST or the socket of a local buildkitd is used before the default")
+	fs.DurationVar(&flags.BuildKitDialTimeout, "buildkit-dial-timeout", buildkit.DefaultDialTimeout, "how long to wait for the BuildKit daemon to respond before failing")
+	fs.DurationVar(&flags.FetchTimeout, "fetch-timeout", 0, "longest a download of the fetch pipeline may take, unless it sets download-timeout (0 for no limit)")
+	fs.BoolVar(&flags.CrossEmulation, "cross-emulation", false, "check that the BuildKit daemon supports each target architecture, natively or under QEMU emulation, before building")
+	fs.StringVar(&flags.BuildKitWorker, "buildkit-worker", "", "BuildKit worker to use when the daemon has several, by ID or worker filter (e.g., labels.\"org.mobyproject.buildkit.worker.snapshotter\"==overlayfs)")
+	fs.BoolVar(&flags.ParallelSolve, "parallel-solve", false, "load the build environment layers while constructing the build graph, instead of before")
+	fs.BoolVar(&flags.Offline, "offline", false, "fail before building if a repository, key, step or registry needs the network, and run steps without network access; fetch steps with a checksum may use --cache-dir as a local mirror")
 	fs.IntVar(&flags.MaxLayers, "max-layers", 50, "maximum number of layers for build environment (1 for single layer, higher for better cache efficiency)")
 	fs.StringSliceVarP(&flags.ExtraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the build environment keyring")
 	fs.StringSliceVarP(&flags.ExtraRepos, "repository-append", "r", []string{}, "path to extra repositories to include in the build environment")
 	fs.StringSliceVar(&flags.ExtraPackages, "package-append", []string{}, "extra packages to install for each of the build environments")
+	fs.StringVar(&flags.Lockfile, "lockfile", "", "install exactly the packages locked in this apko lockfile as the build environment, instead of resolving them")
+	fs.StringVar(&flags.WriteLockfile, "write-lockfile", "", "write the resolved build environment to this apko lockfile")
 	fs.BoolVar(&flags.CreateBuildLog, "create-build-log", false, "creates a package.log file containing a list of packages that were built by the command")
 	fs.BoolVar(&flags.PersistLintResults, "persist-lint-results", false, "persist lint results to JSON files in packages/{arch}/ directory")
+	fs.StringVar(&flags.LintOutput, "lint-output", "", "write a single aggregated JSON lint report covering all architectures and packages to this path")
 	fs.BoolVar(&flags.Debug, "debug", false, "enables debug logging of build pipelines")
+	fs.StringVar(&flags.SinceCommit, "since-commit", "", "when building several configs, build only those whose config or source directory changed since this git commit, and the configs that depend on them")
+	fs.BoolVar(&flags.SummaryOnly, "summary-only", false, "hide the progress of build steps, but for failures, and print a summary of the packages built once done")
 	fs.BoolVar(&flags.Remove, "rm", true, "clean up intermediate artifacts (e.g. container images, temp dirs)")
+	fs.BoolVar(&flags.KeepWorkspaceOnSuccess, "keep-workspace-on-success", false, "keep the workspace after a successful build, even with --rm")
+	fs.BoolVar(&flags.KeepWorkspaceOnFailure, "keep-workspace-on-failure", false, "keep the workspace after a failed build, even with --rm")
 	fs.StringVar(&flags.TraceFile, "trace", "", "where to write trace output")
+	fs.StringVar(&flags.TraceFormat, "trace-format", "stdout", "trace exporter to use: stdout (writes to --trace) or otlp (sends to --trace-endpoint)")
+	fs.StringVar(&flags.TraceEndpoint, "trace-endpoint", "", "URL of the OTLP collector to send traces to with --trace-format=otlp (e.g. https://otel-collector:4317)")
 	fs.StringSliceVar(&flags.LintRequire, "lint-require", linter.DefaultRequiredLinters(), "linters that must pass")
 	fs.StringSliceVar(&flags.LintWarn, "lint-warn", linter.DefaultWarnLinters(), "linters that will generate warnings")
 	fs.BoolVar(&flags.IgnoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	fs.BoolVar(&flags.KeyringVerify, "keyring-verify", false, "verify every repository index against the keyring before building")
 	fs.BoolVar(&flags.Cleanup, "cleanup", true, "when enabled, the temp dir used for the guest will be cleaned up after completion")
 	fs.StringVar(&flags.ConfigFileGitCommit, "git-commit", "", "commit hash of the git repository containing the build config file (defaults to detecting HEAD)")
 	fs.StringVar(&flags.ConfigFileGitRepoURL, "git-repo-url", "", "URL of the git repository containing the build config file (defaults to detecting from configured git remotes)")
@@ -77,8 +111,12 @@ func addBuildFlags(fs *pflag.FlagSet, flags *BuildFlags) {
 	fs.BoolVar(&flags.GenerateProvenance, "generate-provenance", false, "generate SLSA provenance for builds (included in a separate .attest.tar.gz file next to the APK)")
 	fs.StringVar(&flags.ExportOnFailure, "export-on-failure", "none", "export build environment on failure: none, tarball, docker, or registry (registry requires docker login)")
 	fs.StringVar(&flags.ExportRef, "export-ref", "", "path (for tarball) or image reference (for docker/registry) for debug image export")
+	fs.BoolVar(&flags.ExportBuildLog, "export-build-log", false, "write the build log to /melange-build.log in the debug image exported with --export-on-failure")
+	fs.StringVar(&flags.OCILayout, "oci-layout", "", "OCI image layout directory to also export the build output to, as an image with the package SBOMs attached")
 	fs.StringVar(&flags.ApkoRegistry, "apko-registry", "", "registry URL for caching apko base images (e.g., registry:5000/apko-cache)")
 	fs.BoolVar(&flags.ApkoRegistryInsecure, "apko-registry-insecure", false, "allow insecure (HTTP) connection to apko registry")
+	fs.BoolVar(&flags.ApkoRegistryStrict, "apko-registry-strict", false, "fail the build if the apko registry is unavailable instead of falling back to local layers")
+	fs.BoolVar(&flags.ApkoRegistryAttachSBOM, "apko-registry-attach-sbom", false, "attach an SPDX SBOM of the build environment to images pushed to the apko registry, as an OCI referrer")
 }
 
 // BuildFlags holds all parsed build command flags
@@ -86,43 +124,76 @@ type BuildFlags struct {
 	BuildDate            string
 	WorkspaceDir         string
 	PipelineDir          string
+	ErrorOnShadow        bool
+	NormalizePipelines   bool
+	StopAfter            string
 	SourceDir   string
 	CacheDir    string
+	CacheDirReadOnly bool
 	ApkCacheDir string
 	SigningKey           string
 	GenerateIndex        bool
+	VerifyInstall        bool
+	FailOnEmptyPackage   bool
 	EmptyWorkspace       bool
+	IncludeSource        []string
 	StripOriginName      bool
 	OutDir               string
+	APKNameTemplate      string
 	Archstrs             []string
 	ExtraKeys            []string
 	ExtraRepos           []string
 	DependencyLog        string
+	DependencyLogFormat  string
 	EnvFile              string
 	VarsFile             string
 	PurlNamespace        string
+	SBOMExtraPackages    []string
+	SBOMConcurrency      int
 	BuildOption          []string
 	CreateBuildLog       bool
 	PersistLintResults bool
+	LintOutput         string
 	Debug              bool
+	SummaryOnly        bool
+	SinceCommit        string
 	Remove             bool
+	KeepWorkspaceOnSuccess bool
+	KeepWorkspaceOnFailure bool
 	BuildKitAddr       string
+	BuildKitDialTimeout time.Duration
+	BuildKitWorker      string
+	CrossEmulation      bool
+	ParallelSolve       bool
+	Offline             bool
 	MaxLayers          int
 	ExtraPackages      []string
+	Lockfile           string
+	WriteLockfile      string
 	Libc                 string
 	LintRequire          []string
 	LintWarn             []string
 	IgnoreSignatures     bool
+	KeyringVerify        bool
+	UserAgent            string
+	Proxy                melangehttp.ProxyConfig
+	FetchTimeout         time.Duration
 	Cleanup              bool
 	ConfigFileGitCommit  string
 	ConfigFileGitRepoURL string
 	ConfigFileLicense    string
 	GenerateProvenance     bool
 	TraceFile              string
+	TraceFormat            string
+	TraceEndpoint          string
 	ExportOnFailure        string
 	ExportRef              string
+	ExportBuildLog         bool
+	OCILayout              string
 	ApkoRegistry           string
 	ApkoRegistryInsecure   bool
+	ApkoRegistryStrict     bool
+	ApkoRegistryAttachSBOM bool
 }
 
 // ParseBuildFlags parses build flags from the provided args and returns a BuildFlags struct
@@ -139,6 +210,40 @@ func ParseBuildFlags(args []string) (*BuildFlags, []string, error) {
 	return flags, fs.Args(), nil
 }
 
+// parseBuildDate parses the value of --build-date, an RFC 3339 date such as
+// 2024-01-02T15:04:05Z, or a Unix timestamp as with SOURCE_DATE_EPOCH.
+func parseBuildDate(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --build-date %q: want an RFC 3339 date (e.g. 2024-01-02T15:04:05Z) or a Unix timestamp", s)
+	}
+	return t.UTC(), nil
+}
+
+// proxyFlags returns the proxy configuration of the --http-proxy,
+// --https-proxy and --no-proxy flags of the root command in fs.
+func proxyFlags(fs *pflag.FlagSet) melangehttp.ProxyConfig {
+	var p melangehttp.ProxyConfig
+	p.HTTPProxy, _ = fs.GetString("http-proxy")
+	p.HTTPSProxy, _ = fs.GetString("https-proxy")
+	p.NoProxy, _ = fs.GetString("no-proxy")
+	return p
+}
+
+// discoverBuildKitAddr sets addr, the value of the --buildkit-addr flag of
+// fs, to the address buildkit.DiscoverAddr finds, unless the flag was given.
+func discoverBuildKitAddr(ctx context.Context, fs *pflag.FlagSet, addr *string) {
+	if fs.Changed("buildkit-addr") {
+		return
+	}
+	discovered, source := buildkit.DiscoverAddr()
+	clog.FromContext(ctx).Infof("using BuildKit at %s (%s)", discovered, source)
+	*addr = discovered
+}
+
 // ToBuildConfig converts BuildFlags into a BuildConfig struct.
 // This is the preferred way to create build configuration from CLI flags.
 func (flags *BuildFlags) ToBuildConfig(ctx context.Context, args ...string) (*build.BuildConfig, error) {
@@ -169,14 +274,21 @@ func (flags *BuildFlags) ToBuildConfig(ctx context.Context, args ...string) (*bu
 
 	// Git repo URL
 	if flags.ConfigFileGitRepoURL == "" {
-		log.Warnf("git repository URL for build config not provided")
-		cfg.ConfigFileRepositoryURL = "https://unknown/unknown/unknown"
+		cfg.ConfigFileRepositoryURL = build.UnknownRepositoryURL
 	} else {
 		cfg.ConfigFileRepositoryURL = flags.ConfigFileGitRepoURL
 	}
 
 	cfg.ConfigFileLicense = flags.ConfigFileLicense
 
+	if flags.BuildDate != "" {
+		t, err := parseBuildDate(flags.BuildDate)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SourceDateEpoch = t
+	}
+
 	// Convention: auto-detect pipeline directory
 	pipelineDir := flags.PipelineDir
 	if pipelineDir == "" {
@@ -189,6 +301,9 @@ func (flags *BuildFlags) ToBuildConfig(ctx context.Context, args ...string) (*bu
 		cfg.PipelineDirs = append(cfg.PipelineDirs, pipelineDir)
 	}
 	cfg.PipelineDirs = append(cfg.PipelineDirs, convention.BuiltinPipelineDir)
+	cfg.ErrorOnShadow = flags.ErrorOnShadow
+	cfg.NormalizePipelines = flags.NormalizePipelines
+	cfg.StopAfter = flags.StopAfter
 
 	// Convention: auto-detect signing key
 	signingKey := flags.SigningKey
@@ -213,34 +328,69 @@ func (flags *BuildFlags) ToBuildConfig(ctx context.Context, args ...string) (*bu
 	// Simple field mappings
 	cfg.WorkspaceDir = flags.WorkspaceDir
 	cfg.CacheDir = flags.CacheDir
+	cfg.CacheDirReadOnly = flags.CacheDirReadOnly
 	cfg.ApkCacheDir = flags.ApkCacheDir
 	cfg.GenerateIndex = flags.GenerateIndex
+	cfg.VerifyInstall = flags.VerifyInstall
+	cfg.FailOnEmptyPackage = flags.FailOnEmptyPackage
 	cfg.EmptyWorkspace = flags.EmptyWorkspace
+	if len(flags.IncludeSource) > 0 && !flags.EmptyWorkspace {
+		return nil, errors.New("--include-source requires --empty-workspace")
+	}
+	cfg.IncludeSource = flags.IncludeSource
 	cfg.OutDir = flags.OutDir
+	cfg.APKNameTemplate = flags.APKNameTemplate
 	cfg.ExtraKeys = flags.ExtraKeys
 	cfg.ExtraRepos = flags.ExtraRepos
 	cfg.ExtraPackages = flags.ExtraPackages
+	cfg.Lockfile = flags.Lockfile
+	cfg.WriteLockfile = flags.WriteLockfile
 	cfg.DependencyLog = flags.DependencyLog
+	cfg.DependencyLogFormat = flags.DependencyLogFormat
 	cfg.StripOriginName = flags.StripOriginName
 	cfg.EnvFile = flags.EnvFile
 	cfg.VarsFile = flags.VarsFile
+	cfg.SinceCommit = flags.SinceCommit
 	cfg.Namespace = flags.PurlNamespace
+	for _, s := range flags.SBOMExtraPackages {
+		ep, err := config.ParseSBOMExtraPackage(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sbom-extra-package: %w", err)
+		}
+		cfg.SBOMExtraPackages = append(cfg.SBOMExtraPackages, ep)
+	}
+	cfg.SBOMConcurrency = flags.SBOMConcurrency
 	cfg.EnabledBuildOptions = flags.BuildOption
 	cfg.CreateBuildLog = flags.CreateBuildLog
 	cfg.PersistLintResults = flags.PersistLintResults
 	cfg.Debug = flags.Debug
 	cfg.Remove = flags.Remove
+	cfg.KeepWorkspaceOnSuccess = flags.KeepWorkspaceOnSuccess
+	cfg.KeepWorkspaceOnFailure = flags.KeepWorkspaceOnFailure
 	cfg.LintRequire = flags.LintRequire
 	cfg.LintWarn = flags.LintWarn
 	cfg.Libc = flags.Libc
 	cfg.IgnoreSignatures = flags.IgnoreSignatures
+	cfg.KeyringVerify = flags.KeyringVerify
+	cfg.UserAgent = flags.UserAgent
+	cfg.Proxy = flags.Proxy
+	cfg.FetchTimeout = flags.FetchTimeout
 	cfg.GenerateProvenance = flags.GenerateProvenance
 	cfg.BuildKitAddr = flags.BuildKitAddr
+	cfg.BuildKitDialTimeout = flags.BuildKitDialTimeout
+	cfg.BuildKitWorker = flags.BuildKitWorker
+	cfg.CrossEmulation = flags.CrossEmulation
+	cfg.ParallelSolve = flags.ParallelSolve
+	cfg.Offline = flags.Offline
 	cfg.MaxLayers = flags.MaxLayers
 	cfg.ExportOnFailure = flags.ExportOnFailure
 	cfg.ExportRef = flags.ExportRef
+	cfg.ExportBuildLog = flags.ExportBuildLog
+	cfg.OCILayout = flags.OCILayout
 	cfg.ApkoRegistry = flags.ApkoRegistry
 	cfg.ApkoRegistryInsecure = flags.ApkoRegistryInsecure
+	cfg.ApkoRegistryStrict = flags.ApkoRegistryStrict
+	cfg.ApkoRegistryAttachSBOM = flags.ApkoRegistryAttachSBOM
 
 	// Handle HTTP_AUTH environment variable
 	if auth, ok := os.LookupEnv("HTTP_AUTH"); ok {
@@ -268,23 +418,29 @@ func buildCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "build",
 		Short:   "Build a package from a YAML configuration file",
-		Long:    `Build a package from a YAML configuration file.`,
-		Example: `  melange build [config.yaml]`,
+		Long: `Build a package from a YAML configuration file.
+
+When several configuration files or a directory of them are given, they are
+built one after another in dependency order, sharing a BuildKit connection.`,
+		Example: `  melange build [config.yaml]
+  melange build foo.yaml bar.yaml
+  melange build ./packages-src/`,
 		Args:    cobra.MinimumNArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 			log := clog.FromContext(ctx)
 
-			if flags.TraceFile != "" {
-				w, err := os.Create(flags.TraceFile) // #nosec G304 - User-specified trace file output
-				if err != nil {
-					return fmt.Errorf("creating trace file: %w", err)
-				}
-				defer w.Close()
-				exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
-				if err != nil {
-					return fmt.Errorf("creating stdout exporter: %w", err)
-				}
+			// --user-agent is a flag of the root command, shared by every
+			// command that makes HTTP requests.
+			flags.UserAgent, _ = cmd.Flags().GetString("user-agent")
+			flags.Proxy = proxyFlags(cmd.Flags())
+
+			exporter, closeTrace, err := newTraceExporter(ctx, flags)
+			if err != nil {
+				return err
+			}
+			if exporter != nil {
+				defer closeTrace()
 				tp := trace.NewTracerProvider(trace.WithBatcher(exporter))
 				otel.SetTracerProvider(tp)
 
@@ -299,15 +455,36 @@ func buildCmd() *cobra.Command {
 				ctx = tctx
 			}
 
+			discoverBuildKitAddr(ctx, cmd.Flags(), &flags.BuildKitAddr)
+
 			archs := apko_types.ParseArchitectures(flags.Archstrs)
 			log.Infof("melange version %s with buildkit@%s building %s at commit %s for arches %s", cmd.Version, flags.BuildKitAddr, args, flags.ConfigFileGitCommit, archs)
 
+			if len(args) > 1 || isDir(args) || flags.SinceCommit != "" {
+				return buildMultipleConfigs(ctx, cmd.OutOrStdout(), flags, archs, args)
+			}
+
 			cfg, err := flags.ToBuildConfig(ctx, args...)
 			if err != nil {
 				return fmt.Errorf("creating build config from flags: %w", err)
 			}
 
-			return BuildCmdWithConfig(ctx, archs, cfg)
+			if flags.LintOutput != "" {
+				cfg.LintReport = linter.NewReport()
+			}
+
+			buildErr := flags.runBuild(ctx, cmd.OutOrStdout(), archs, cfg, build.RunBuild)
+
+			// Write the report even if the build failed, so lint failures
+			// are visible to CI.
+			if cfg.LintReport != nil {
+				if err := cfg.LintReport.Write(flags.LintOutput); err != nil {
+					return errors.Join(buildErr, err)
+				}
+				log.Infof("wrote lint report to %s", flags.LintOutput)
+			}
+
+			return buildErr
 		},
 	}
 
@@ -317,27 +494,187 @@ func buildCmd() *cobra.Command {
 	return cmd
 }
 
-// Detect the git state from the build config file's parent directory.
-func detectGitHead(ctx context.Context, buildConfigFilePath string) (string, error) {
-	repoDir := filepath.Dir(buildConfigFilePath)
-	clog.FromContext(ctx).Debugf("detecting git state from %q", repoDir)
+// newTraceExporter creates the span exporter selected by --trace-format, or
+// returns a nil exporter when tracing is not enabled. The returned function
+// releases the exporter's output once the tracer provider is shut down.
+func newTraceExporter(ctx context.Context, flags *BuildFlags) (trace.SpanExporter, func(), error) {
+	switch flags.TraceFormat {
+	case "", "stdout":
+		if flags.TraceEndpoint != "" {
+			return nil, nil, errors.New("--trace-endpoint requires --trace-format=otlp")
+		}
+		if flags.TraceFile == "" {
+			return nil, nil, nil
+		}
+		w, err := os.Create(flags.TraceFile) // #nosec G304 - User-specified trace file output
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			w.Close()
+			return nil, nil, fmt.Errorf("creating stdout exporter: %w", err)
+		}
+		return exporter, func() { w.Close() }, nil
+	case "otlp":
+		if flags.TraceEndpoint == "" {
+			return nil, nil, errors.New("--trace-format=otlp requires --trace-endpoint")
+		}
+		if flags.TraceFile != "" {
+			return nil, nil, errors.New("--trace cannot be used with --trace-format=otlp")
+		}
+		exporter, err := tracing.NewOTLPExporter(ctx, flags.TraceEndpoint)
+		if err != nil {
+			return nil, nil, err
+		}
+		return exporter, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace format %q: must be stdout or otlp", flags.TraceFormat)
+	}
+}
+
+// runBuild runs build with cfg for archs. With --summary-only, it hides the
+// progress of build steps but for failures, and writes a summary of the
+// packages built to w once the build is done, whether it failed or not.
+func (flags *BuildFlags) runBuild(ctx context.Context, w io.Writer, archs []apko_types.Architecture, cfg *build.BuildConfig, run func(context.Context, []apko_types.Architecture, *build.BuildConfig) error) error {
+	if !flags.SummaryOnly {
+		return run(ctx, archs, cfg)
+	}
+
+	ctx = quietLog(ctx)
+	cfg.ProgressMode = buildkit.ProgressModeMinimal
+	cfg.Summary = build.NewSummary()
+	err := run(ctx, archs, cfg)
+	return errors.Join(err, cfg.Summary.Write(w))
+}
+
+// quietLog returns a context whose logger drops the records of the logger
+// in ctx below warnings, leaving the summary as the only account of a
+// build that went well.
+func quietLog(ctx context.Context) context.Context {
+	return clog.WithLogger(ctx, clog.New(warningsHandler{clog.FromContext(ctx).Handler()}))
+}
+
+// warningsHandler passes warnings and errors on to the handler it wraps.
+type warningsHandler struct {
+	slog.Handler
+}
+
+func (h warningsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn && h.Handler.Enabled(ctx, level)
+}
+
+func (h warningsHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h warningsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warningsHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h warningsHandler) WithGroup(name string) slog.Handler {
+	return warningsHandler{h.Handler.WithGroup(name)}
+}
+
+// isDir reports whether the only argument is a directory.
+func isDir(args []string) bool {
+	if len(args) != 1 {
+		return false
+	}
+	fi, err := os.Stat(args[0])
+	return err == nil && fi.IsDir()
+}
+
+// buildMultipleConfigs builds every configuration named by args, expanding
+// directories, in dependency order and reports the outcome of each. With
+// --summary-only, the summary of the packages built is written to w.
+func buildMultipleConfigs(ctx context.Context, w io.Writer, flags *BuildFlags, archs []apko_types.Architecture, args []string) error {
+	if flags.SummaryOnly {
+		ctx = quietLog(ctx)
+	}
+	log := clog.FromContext(ctx)
+
+	paths, err := build.ExpandConfigPaths(args)
+	if err != nil {
+		return fmt.Errorf("finding build configs: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no build configs found in %v", args)
+	}
+
+	var report *linter.Report
+	if flags.LintOutput != "" {
+		report = linter.NewReport()
+	}
+
+	var summary *build.Summary
+	if flags.SummaryOnly {
+		summary = build.NewSummary()
+	}
 
-	repo, err := git.PlainOpenWithOptions(repoDir, &git.PlainOpenOptions{DetectDotGit: true})
+	results, err := build.BuildConfigs(ctx, paths, archs, func(ctx context.Context, configFile string) (*build.BuildConfig, error) {
+		cfg, err := flags.ToBuildConfig(ctx, configFile)
+		if err != nil {
+			return nil, fmt.Errorf("creating build config from flags: %w", err)
+		}
+		cfg.LintReport = report
+		if summary != nil {
+			cfg.ProgressMode = buildkit.ProgressModeMinimal
+			cfg.Summary = summary
+		}
+		return cfg, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("opening git repository: %w", err)
+		return err
+	}
+
+	var errs []error
+	log.Infof("built %d configs:", len(results))
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			log.Warnf("  skipped %s (%s): %v", r.Package, r.ConfigFile, r.Err)
+		case r.Err != nil:
+			log.Errorf("  failed  %s (%s) after %s: %v", r.Package, r.ConfigFile, r.Duration.Round(time.Second), r.Err)
+		default:
+			log.Infof("  built   %s (%s) in %s", r.Package, r.ConfigFile, r.Duration.Round(time.Second))
+		}
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Package, r.Err))
+		}
+	}
+
+	if report != nil {
+		if err := report.Write(flags.LintOutput); err != nil {
+			errs = append(errs, err)
+		} else {
+			log.Infof("wrote lint report to %s", flags.LintOutput)
+		}
+	}
+
+	if summary != nil {
+		if err := summary.Write(w); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	head, err := repo.Head()
+	return errors.Join(errs...)
+}
+
+// Detect the git state from the build config file's parent directory.
+func detectGitHead(ctx context.Context, buildConfigFilePath string) (string, error) {
+	md, err := config.DetectGitMetadata(ctx, buildConfigFilePath)
 	if err != nil {
-		return "", fmt.Errorf("determining HEAD: %w", err)
+		return "", err
 	}
-	commit := head.Hash().String()
-	return commit, nil
+	return md.Commit, nil
 }
 
 // BuildCmdWithConfig executes builds for the given architectures using the provided BuildConfig.
-// This is the preferred entry point for programmatic builds.
+// Programmatic builds should use build.RunBuild, which it wraps.
 func BuildCmdWithConfig(ctx context.Context, archs []apko_types.Architecture, baseCfg *build.BuildConfig) error {
-	orchestrator := build.NewBuildOrchestrator(baseCfg)
-	return orchestrator.RunForArchitectures(ctx, archs)
+	return build.RunBuild(ctx, archs, baseCfg)
 }
@@ -0,0 +1,163 @@
+// Copyright 2022 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/build"
+	"github.com/dlorenc/melange2/pkg/buildkit"
+)
+
+func TestRunBuildSummaryOnly(t *testing.T) {
+	ctx := slogtest.Context(t)
+	archs := apko_types.ParseArchitectures([]string{"x86_64", "aarch64"})
+
+	// fakeBuild stands in for the builds of each architecture, recording
+	// their outcome as a real build does.
+	fakeBuild := func(_ context.Context, _ []apko_types.Architecture, cfg *build.BuildConfig) error {
+		if cfg.Summary != nil {
+			cfg.Summary.Add(
+				build.SummaryEntry{Arch: "x86_64", Package: "hello", APK: "packages/x86_64/hello-1.0.0-r0.apk", Duration: 3 * time.Second},
+				build.SummaryEntry{Arch: "x86_64", Package: "hello-doc", APK: "packages/x86_64/hello-doc-1.0.0-r0.apk", Duration: 3 * time.Second},
+				build.SummaryEntry{Arch: "aarch64", Package: "hello", Failed: true, Duration: time.Second},
+			)
+		}
+		return errors.New("aarch64: failed to build package")
+	}
+
+	t.Run("summary only", func(t *testing.T) {
+		flags, _, err := ParseBuildFlags([]string{"--summary-only"})
+		require.NoError(t, err)
+
+		var progressMode buildkit.ProgressMode
+		var out bytes.Buffer
+		cfg := build.NewBuildConfig()
+		err = flags.runBuild(ctx, &out, archs, cfg, func(ctx context.Context, archs []apko_types.Architecture, cfg *build.BuildConfig) error {
+			progressMode = cfg.ProgressMode
+			return fakeBuild(ctx, archs, cfg)
+		})
+
+		// The error still comes through in full.
+		require.EqualError(t, err, "aarch64: failed to build package")
+		require.Equal(t, buildkit.ProgressModeMinimal, progressMode)
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		require.Len(t, lines, 5)
+		require.Regexp(t, `^ARCH\s+PACKAGE\s+STATUS\s+DURATION\s+APK$`, string(lines[0]))
+		require.Regexp(t, `^aarch64\s+hello\s+failed\s+1s\s*$`, string(lines[1]))
+		require.Regexp(t, `^x86_64\s+hello\s+built\s+3s\s+packages/x86_64/hello-1.0.0-r0.apk$`, string(lines[2]))
+		require.Regexp(t, `^x86_64\s+hello-doc\s+built\s+3s\s+packages/x86_64/hello-doc-1.0.0-r0.apk$`, string(lines[3]))
+		require.Regexp(t, `^2 built, 1 failed in \d+s$`, string(lines[4]))
+	})
+
+	t.Run("summary only hides informational logs", func(t *testing.T) {
+		flags, _, err := ParseBuildFlags([]string{"--summary-only"})
+		require.NoError(t, err)
+
+		var logs bytes.Buffer
+		ctx := clog.WithLogger(ctx, clog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		cfg := build.NewBuildConfig()
+		err = flags.runBuild(ctx, io.Discard, archs, cfg, func(ctx context.Context, archs []apko_types.Architecture, cfg *build.BuildConfig) error {
+			log := clog.FromContext(ctx).With("arch", "x86_64")
+			log.Infof("installing packages")
+			log.Warnf("deprecated field")
+			return fakeBuild(ctx, archs, cfg)
+		})
+		require.Error(t, err)
+		require.NotContains(t, logs.String(), "installing packages")
+		require.Contains(t, logs.String(), "deprecated field")
+	})
+
+	t.Run("default", func(t *testing.T) {
+		flags, _, err := ParseBuildFlags(nil)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		cfg := build.NewBuildConfig()
+		err = flags.runBuild(ctx, &out, archs, cfg, fakeBuild)
+		require.EqualError(t, err, "aarch64: failed to build package")
+		require.Empty(t, cfg.ProgressMode)
+		require.Nil(t, cfg.Summary)
+		require.Empty(t, out.String())
+	})
+}
+
+func TestDiscoverBuildKitAddr(t *testing.T) {
+	ctx := slogtest.Context(t)
+	t.Setenv(buildkit.HostEnv, "tcp://buildkitd.example.com:1234")
+
+	parse := func(t *testing.T, args ...string) (*pflag.FlagSet, *BuildFlags) {
+		t.Helper()
+		flags := &BuildFlags{}
+		fs := pflag.NewFlagSet("build", pflag.ContinueOnError)
+		addBuildFlags(fs, flags)
+		require.NoError(t, fs.Parse(args))
+		return fs, flags
+	}
+
+	t.Run("BUILDKIT_HOST is honored", func(t *testing.T) {
+		fs, flags := parse(t)
+		discoverBuildKitAddr(ctx, fs, &flags.BuildKitAddr)
+		require.Equal(t, "tcp://buildkitd.example.com:1234", flags.BuildKitAddr)
+	})
+
+	t.Run("explicit flag wins", func(t *testing.T) {
+		fs, flags := parse(t, "--buildkit-addr", buildkit.DefaultAddr)
+		discoverBuildKitAddr(ctx, fs, &flags.BuildKitAddr)
+		require.Equal(t, buildkit.DefaultAddr, flags.BuildKitAddr)
+	})
+}
+
+func TestBuildDate(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	for _, tc := range []struct {
+		arg     string
+		want    time.Time
+		wantErr string
+	}{
+		{arg: "", want: time.Unix(0, 0).UTC()},
+		{arg: "2024-01-02T15:04:05Z", want: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{arg: "2024-01-02T16:04:05+01:00", want: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{arg: "1704207845", want: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{arg: "yesterday", wantErr: `invalid --build-date "yesterday"`},
+	} {
+		t.Run(tc.arg, func(t *testing.T) {
+			flags, _, err := ParseBuildFlags([]string{"--build-date", tc.arg, "--git-commit", "deadbeef"})
+			require.NoError(t, err)
+
+			cfg, err := flags.ToBuildConfig(ctx, "hello.yaml")
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tc.want.Equal(cfg.SourceDateEpoch), "SourceDateEpoch = %v, want %v", cfg.SourceDateEpoch, tc.want)
+		})
+	}
+}
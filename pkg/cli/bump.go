@@ -0,0 +1,61 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func bumpCmd() *cobra.Command {
+	var opts config.BumpOptions
+
+	cmd := &cobra.Command{
+		Use:   "bump",
+		Short: "Update the version or epoch of a Melange YAML file in place",
+		Long: `Update the version or epoch of a Melange YAML file in place.
+Setting a new --version resets the epoch to 0, while --epoch increments
+the epoch and keeps the current version. Comments and formatting in the
+file are preserved.`,
+		Example: `  melange bump config.yaml --version 1.2.3
+  melange bump config.yaml --epoch
+  melange bump config.yaml --version 1.2.3 --commit 0123456789abcdef0123456789abcdef01234567`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return BumpCmd(cmd.Context(), args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Version, "version", "", "new package version (resets the epoch to 0)")
+	cmd.Flags().BoolVar(&opts.Epoch, "epoch", false, "increment the package epoch")
+	cmd.Flags().StringVar(&opts.Commit, "commit", "", "update the package commit")
+	cmd.MarkFlagsMutuallyExclusive("version", "epoch")
+	cmd.MarkFlagsOneRequired("version", "epoch")
+
+	return cmd
+}
+
+// BumpCmd updates the version metadata of configFile in place.
+func BumpCmd(ctx context.Context, configFile string, opts config.BumpOptions) error {
+	if err := config.Bump(ctx, configFile, opts); err != nil {
+		return err
+	}
+	clog.FromContext(ctx).Infof("bumped %s", configFile)
+	return nil
+}
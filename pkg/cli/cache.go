@@ -0,0 +1,98 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/cache"
+)
+
+func cacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache directory of builds",
+		Long:  `Commands for managing the directory given to --cache-dir.`,
+	}
+
+	cmd.AddCommand(cacheGCCmd())
+
+	return cmd
+}
+
+func cacheGCCmd() *cobra.Command {
+	var cacheDir string
+	var maxAge time.Duration
+	var maxSize string
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Evict entries from the cache directory",
+		Long: `Evict the entries of the cache directory, its top-level files and
+directories, last modified longer ago than --max-age, then the least recently
+modified entries until the cache is no larger than --max-size. The age of a
+directory is that of the newest file in it.
+
+While a build uses the cache directory, its entries are in use and are
+skipped, so gc can run while builds share the cache directory.`,
+		Example: `  melange cache gc --max-age 720h
+  melange cache gc --cache-dir /var/cache/melange --max-size 20GB
+  melange cache gc --max-age 168h --max-size 5GiB`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := cache.GCOptions{MaxAge: maxAge}
+			if maxSize != "" {
+				size, err := humanize.ParseBytes(maxSize)
+				if err != nil {
+					return fmt.Errorf("invalid --max-size: %w", err)
+				}
+				opts.MaxSize = size
+			}
+			return CacheGCCmd(cmd.Context(), cmd.OutOrStdout(), cacheDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "./melange-cache/", "directory used for cached inputs")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "evict entries last modified longer ago than this (e.g. 720h)")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "evict the oldest entries until the cache is no larger than this (e.g. 10GB)")
+
+	return cmd
+}
+
+// CacheGCCmd evicts the entries of the cache directory dir past the limits
+// of opts, and reports what it reclaimed to out.
+func CacheGCCmd(ctx context.Context, out io.Writer, dir string, opts cache.GCOptions) error {
+	if opts.MaxAge <= 0 && opts.MaxSize == 0 {
+		return errors.New("at least one of --max-age and --max-size must be set")
+	}
+
+	result, err := cache.GC(ctx, dir, opts)
+	if err != nil {
+		return err
+	}
+	for _, name := range result.InUse {
+		fmt.Fprintf(out, "skipped %s: in use\n", name)
+	}
+	_, err = fmt.Fprintf(out, "evicted %d entries, reclaimed %s; %s left in %s\n",
+		len(result.Evicted), humanize.Bytes(result.Reclaimed), humanize.Bytes(result.Size), dir)
+	return err
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func canonicalizeCmd() *cobra.Command {
+	var write bool
+
+	cmd := &cobra.Command{
+		Use:   "canonicalize",
+		Short: "Rewrite a Melange YAML file in canonical form",
+		Long: `Rewrite a Melange YAML file in canonical form, for stable diffs.
+Keys are put in a fixed order, maps and dependency and package lists are
+sorted, and the YAML is reindented. Keys melange does not order, such as
+those of extensions, keep their place, and collections with YAML anchors
+or aliases keep their order. Comments are kept and the meaning of the
+configuration is unchanged. The result is written to stdout unless
+--write is set.`,
+		Example: `  melange canonicalize config.yaml
+  melange canonicalize --write config.yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return CanonicalizeCmd(cmd.Context(), args[0], write, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "rewrite the file in place instead of printing it")
+
+	return cmd
+}
+
+// CanonicalizeCmd writes configFile in canonical form to out, or back to
+// configFile if write is set.
+func CanonicalizeCmd(ctx context.Context, configFile string, write bool, out io.Writer) error {
+	cfg, err := config.ParseConfiguration(ctx, configFile)
+	if err != nil {
+		return err
+	}
+
+	if !write {
+		return cfg.Canonicalize(out)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Canonicalize(&buf); err != nil {
+		return err
+	}
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(configFile, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	clog.FromContext(ctx).Infof("canonicalized %s", configFile)
+	return nil
+}
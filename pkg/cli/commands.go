@@ -15,7 +15,6 @@
 package cli
 
 import (
-	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -25,18 +24,25 @@ import (
 	charmlog "github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/release-utils/version"
+
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 )
 
 func New() *cobra.Command {
 	var level slag.Level
 	var gcplog bool
+	var userAgent string
+	var proxy melangehttp.ProxyConfig
 	cmd := &cobra.Command{
 		Use:               "melange",
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		SilenceErrors:     true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			http.DefaultTransport = userAgentTransport{http.DefaultTransport}
+			if t, ok := http.DefaultTransport.(*http.Transport); ok && !proxy.IsZero() {
+				http.DefaultTransport = melangehttp.NewProxyTransport(t, proxy)
+			}
+			http.DefaultTransport = melangehttp.NewUserAgentTransport(http.DefaultTransport, userAgent)
 
 			if gcplog {
 				slog.SetDefault(slog.New(gcp.NewHandler(slog.Level(level))))
@@ -50,10 +56,21 @@ func New() *cobra.Command {
 	cmd.PersistentFlags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error)")
 	cmd.PersistentFlags().BoolVar(&gcplog, "gcplog", false, "use GCP logging")
 	_ = cmd.PersistentFlags().MarkHidden("gcplog")
+	cmd.PersistentFlags().StringVar(&userAgent, "user-agent", melangehttp.DefaultUserAgent(), "User-Agent to send with outbound HTTP requests")
+	cmd.PersistentFlags().StringVar(&proxy.HTTPProxy, "http-proxy", "", "proxy for outbound HTTP requests and, as HTTP_PROXY, for build and test steps")
+	cmd.PersistentFlags().StringVar(&proxy.HTTPSProxy, "https-proxy", "", "proxy for outbound HTTPS requests and, as HTTPS_PROXY, for build and test steps")
+	cmd.PersistentFlags().StringVar(&proxy.NoProxy, "no-proxy", "", "comma-separated hosts reached without a proxy, for outbound requests and, as NO_PROXY, for build and test steps")
 
 	cmd.AddCommand(buildCmd())
+	cmd.AddCommand(bumpCmd())
+	cmd.AddCommand(cacheCmd())
+	cmd.AddCommand(canonicalizeCmd())
 	cmd.AddCommand(completion())
+	cmd.AddCommand(depsCmd())
+	cmd.AddCommand(diffCmd())
+	cmd.AddCommand(explainCmd())
 	cmd.AddCommand(compile())
+	cmd.AddCommand(schemaCmd())
 	cmd.AddCommand(indexCmd())
 	cmd.AddCommand(keygen())
 	cmd.AddCommand(lint())
@@ -63,14 +80,8 @@ func New() *cobra.Command {
 	cmd.AddCommand(signCmd())
 	cmd.AddCommand(signIndex())
 	cmd.AddCommand(test())
+	cmd.AddCommand(validateCmd())
 	cmd.AddCommand(version.Version())
 	cmd.AddCommand(remoteCmd())
 	return cmd
 }
-
-type userAgentTransport struct{ t http.RoundTripper }
-
-func (u userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("User-Agent", fmt.Sprintf("melange/%s", version.GetVersionInfo().GitVersion))
-	return u.t.RoundTrip(req)
-}
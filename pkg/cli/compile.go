@@ -92,8 +92,7 @@ func compile() *cobra.Command {
 				}
 			}
 			if configFileGitRepoURL == "" {
-				log.Warnf("git repository URL for build config not provided")
-				configFileGitRepoURL = "https://unknown/unknown/unknown"
+				configFileGitRepoURL = build.UnknownRepositoryURL
 			}
 
 			arch := apko_types.ParseArchitecture(archstr)
@@ -0,0 +1,119 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/build"
+)
+
+func depsCmd() *cobra.Command {
+	var archstr string
+	var jsonOutput bool
+	var extraKeys []string
+	var extraRepos []string
+	var extraPackages []string
+	var buildOption []string
+	var envFile string
+	var varsFile string
+	var lockfile string
+	var apkCacheDir string
+	var ignoreSignatures bool
+
+	cmd := &cobra.Command{
+		Use:   "deps",
+		Short: "List the packages of the build environment of a package",
+		Long: `Resolve the build environment of a package as a build would, and list
+the packages installed in it, with their versions and the repository each
+is installed from. Nothing is built.`,
+		Example: `  melange deps config.yaml
+  melange deps --arch aarch64 -r https://packages.wolfi.dev/os config.yaml
+  melange deps --json config.yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := build.NewBuildConfig()
+			cfg.ConfigFile = args[0]
+			// Nothing is built, so the provenance of the configuration is
+			// not needed.
+			cfg.ConfigFileRepositoryURL = build.UnknownRepositoryURL
+			cfg.ConfigFileRepositoryCommit = "unknown"
+			cfg.Arch = apko_types.ParseArchitecture(archstr)
+			cfg.ExtraKeys = extraKeys
+			cfg.ExtraRepos = extraRepos
+			cfg.ExtraPackages = extraPackages
+			cfg.EnabledBuildOptions = buildOption
+			cfg.EnvFile = envFile
+			cfg.VarsFile = varsFile
+			cfg.Lockfile = lockfile
+			cfg.ApkCacheDir = apkCacheDir
+			cfg.IgnoreSignatures = ignoreSignatures
+			cfg.UserAgent, _ = cmd.Flags().GetString("user-agent")
+
+			return DepsCmd(cmd.Context(), cfg, jsonOutput, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVar(&archstr, "arch", runtime.GOARCH, "architecture to resolve the build environment for")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "list the packages as JSON")
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the build environment keyring")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include in the build environment")
+	cmd.Flags().StringSliceVar(&extraPackages, "package-append", []string{}, "extra packages to install in the build environment")
+	cmd.Flags().StringSliceVar(&buildOption, "build-option", []string{}, "build options to enable")
+	cmd.Flags().StringVar(&envFile, "env-file", "", "file to use for preloaded environment variables")
+	cmd.Flags().StringVar(&varsFile, "vars-file", "", "file to use for preloaded build configuration variables")
+	cmd.Flags().StringVar(&lockfile, "lockfile", "", "list the packages locked in this apko lockfile, instead of resolving them")
+	cmd.Flags().StringVar(&apkCacheDir, "apk-cache-dir", "", "directory used for cached apk packages (default is system-defined cache directory)")
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+
+	return cmd
+}
+
+// DepsCmd resolves the build environment of the package configured by cfg,
+// for cfg.Arch, and lists its packages to out, as a JSON array if
+// jsonOutput is set.
+func DepsCmd(ctx context.Context, cfg *build.BuildConfig, jsonOutput bool, out io.Writer) error {
+	b, err := build.NewFromConfig(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer b.Close(ctx)
+
+	pkgs, err := b.ResolveEnvironment(ctx)
+	if err != nil {
+		return fmt.Errorf("resolving build environment: %w", err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pkgs)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tREPOSITORY")
+	for _, p := range pkgs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, p.Repository)
+	}
+	return w.Flush()
+}
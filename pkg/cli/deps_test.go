@@ -0,0 +1,96 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/build"
+)
+
+func TestDepsCmd(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	// An unsigned index in which hello depends on libhello.
+	repo := t.TempDir()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	index := []byte("P:hello\nV:1.0-r0\nA:x86_64\nD:libhello\n\nP:libhello\nV:2.1-r0\nA:x86_64\n\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(index))}))
+	_, err := tw.Write(index)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "x86_64"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "x86_64", "APKINDEX.tar.gz"), buf.Bytes(), 0o644))
+	srv := httptest.NewServer(http.FileServer(http.Dir(repo)))
+	defer srv.Close()
+
+	configFile := filepath.Join(t.TempDir(), "pkg.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`package:
+  name: pkg
+  version: 1.0.0
+  epoch: 0
+environment:
+  contents:
+    packages:
+      - hello
+pipeline:
+  - runs: echo hello
+`), 0o644))
+
+	newConfig := func() *build.BuildConfig {
+		cfg := build.NewBuildConfig()
+		cfg.ConfigFile = configFile
+		cfg.ConfigFileRepositoryURL = build.UnknownRepositoryURL
+		cfg.ConfigFileRepositoryCommit = "unknown"
+		cfg.Arch = apko_types.ParseArchitecture("x86_64")
+		cfg.ExtraRepos = []string{srv.URL}
+		cfg.ApkCacheDir = t.TempDir()
+		cfg.IgnoreSignatures = true
+		return cfg
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, DepsCmd(ctx, newConfig(), true, &out))
+
+		var pkgs []build.EnvironmentPackage
+		require.NoError(t, json.Unmarshal(out.Bytes(), &pkgs))
+		require.Equal(t, []build.EnvironmentPackage{
+			{Name: "hello", Version: "1.0-r0", Repository: srv.URL + "/x86_64"},
+			{Name: "libhello", Version: "2.1-r0", Repository: srv.URL + "/x86_64"},
+		}, pkgs)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, DepsCmd(ctx, newConfig(), false, &out))
+		require.Regexp(t, `(?m)^NAME +VERSION +REPOSITORY\nhello +1\.0-r0 +http://\S+/x86_64\nlibhello +2\.1-r0 +http://\S+/x86_64\n$`, out.String())
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/apkdiff"
+)
+
+func diffCmd() *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the contents of packages from two builds",
+		Long: `Compare the contents of packages from two builds.
+Reports files that were added, removed or modified, including changes to
+content, permissions, ownership and link targets, and the change in size.
+Either two APK files or two output directories can be compared; packages
+in output directories are paired up by name and architecture.`,
+		Example: `  melange diff old/hello-1.0.0-r0.apk packages/x86_64/hello-1.0.0-r1.apk
+  melange diff --json ./packages-main/ ./packages/`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := apkdiff.Diff(cmd.Context(), args[0], args[1])
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(r)
+			}
+			return r.WriteText(cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "print the differences as JSON")
+
+	return cmd
+}
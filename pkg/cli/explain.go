@@ -0,0 +1,147 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/dlorenc/melange2/pkg/build"
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/convention"
+)
+
+func explainCmd() *cobra.Command {
+	var pipelineDir string
+
+	cmd := &cobra.Command{
+		Use:   "explain <uses>",
+		Short: "Describe the inputs of a pipeline",
+		Long: `Describe the pipeline a "uses" step with the given name runs: the inputs
+it declares, with their descriptions and defaults, and an example step.
+Pipelines are looked up as a build looks them up, in --pipeline-dir
+first and then among the builtin pipelines.`,
+		Example: `  melange explain fetch
+  melange explain go/build
+  melange explain --pipeline-dir ./pipelines my-pipeline`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var dirs []string
+			if pipelineDir != "" {
+				dirs = append(dirs, pipelineDir)
+			}
+			dirs = append(dirs, convention.BuiltinPipelineDir)
+			return ExplainCmd(args[0], dirs, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVar(&pipelineDir, "pipeline-dir", "", "directory used to extend defined built-in pipelines")
+
+	return cmd
+}
+
+// ExplainCmd describes to out the pipeline named uses, looked up in dirs and
+// then among the builtin pipelines: its inputs, in the order they are
+// declared, and an example step using it.
+func ExplainCmd(uses string, dirs []string, out io.Writer) error {
+	data, location, err := build.LoadPipeline(uses, dirs...)
+	if err != nil {
+		return err
+	}
+
+	var p config.Pipeline
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("parsing pipeline %q: %w", uses, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("parsing pipeline %q: %w", uses, err)
+	}
+
+	fmt.Fprintf(out, "%s: %s\n", uses, p.Name)
+	fmt.Fprintf(out, "(from %s)\n", location)
+
+	names := inputNames(&root)
+	fmt.Fprintln(out)
+	if len(names) == 0 {
+		fmt.Fprintln(out, "No inputs.")
+	} else {
+		fmt.Fprintln(out, "Inputs:")
+		for _, name := range names {
+			in := p.Inputs[name]
+			if in.Required {
+				fmt.Fprintf(out, "  %s (required)\n", name)
+			} else {
+				fmt.Fprintf(out, "  %s\n", name)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(in.Description), "\n") {
+				if line != "" {
+					fmt.Fprintf(out, "      %s\n", strings.TrimSpace(line))
+				}
+			}
+			if in.Default != "" {
+				fmt.Fprintf(out, "      Default: %s\n", in.Default)
+			}
+		}
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Usage:")
+	fmt.Fprintf(out, "  - uses: %s\n", uses)
+	var with []string
+	for _, name := range names {
+		if p.Inputs[name].Required {
+			with = append(with, name)
+		}
+	}
+	if len(with) > 0 {
+		fmt.Fprintln(out, "    with:")
+		for _, name := range with {
+			value := p.Inputs[name].Default
+			if value == "" {
+				value = "<" + name + ">"
+			}
+			fmt.Fprintf(out, "      %s: %s\n", name, value)
+		}
+	}
+	return nil
+}
+
+// inputNames returns the names of the inputs of the pipeline definition
+// root, in the order they are declared.
+func inputNames(root *yaml.Node) []string {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "inputs" || root.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		inputs := root.Content[i+1].Content
+		for j := 0; j < len(inputs); j += 2 {
+			names = append(names, inputs[j].Value)
+		}
+	}
+	return names
+}
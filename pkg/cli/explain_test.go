@@ -0,0 +1,79 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainCmd(t *testing.T) {
+	t.Run("builtin", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, ExplainCmd("fetch", nil, &out))
+
+		got := out.String()
+		require.Contains(t, got, "fetch: Fetch and extract external object into workspace\n")
+		require.Contains(t, got, "(from pipelines/fetch.yaml embedded in melange)\n")
+		require.Contains(t, got, "  uri (required)\n      The URI to fetch as an artifact.\n")
+		require.Contains(t, got, "  expected-sha256\n      The expected SHA256 of the downloaded artifact.\n")
+		require.Contains(t, got, "  strip-components\n      The number of path components to strip while extracting.\n      Default: 1\n")
+		require.Contains(t, got, "Usage:\n  - uses: fetch\n    with:\n      uri: <uri>\n")
+		// Inputs are listed in the order they are declared.
+		require.Less(t, bytes.Index(out.Bytes(), []byte("strip-components")), bytes.Index(out.Bytes(), []byte("  uri")))
+	})
+
+	t.Run("pipeline dir", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "greet.yaml"), []byte(`name: Greet someone
+
+inputs:
+  who:
+    description: Who to greet
+    required: true
+    default: world
+
+pipeline:
+  - runs: echo hello ${{inputs.who}}
+`), 0o644))
+
+		var out bytes.Buffer
+		require.NoError(t, ExplainCmd("greet", []string{dir}, &out))
+		require.Equal(t, `greet: Greet someone
+(from `+filepath.Join(dir, "greet.yaml")+`)
+
+Inputs:
+  who (required)
+      Who to greet
+      Default: world
+
+Usage:
+  - uses: greet
+    with:
+      who: world
+`, out.String())
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		var out bytes.Buffer
+		err := ExplainCmd("no-such-pipeline", []string{t.TempDir()}, &out)
+		require.EqualError(t, err, `could not find 'uses' pipeline "no-such-pipeline"`)
+		require.Empty(t, out.String())
+	})
+}
@@ -31,11 +31,28 @@ import (
 
 const defaultServerURL = "http://localhost:8080"
 
+// serverTokenEnv is the environment variable holding the token that
+// authenticates requests to the melange-server.
+const serverTokenEnv = "MELANGE_SERVER_TOKEN"
+
+// newClient creates a client of the melange-server at serverURL, with the
+// token of serverTokenEnv if set.
+func newClient(serverURL string) *client.Client {
+	var opts []client.Option
+	if token := os.Getenv(serverTokenEnv); token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	return client.New(serverURL, opts...)
+}
+
 func remoteCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "remote",
 		Short: "Interact with a melange build server",
-		Long:  `Commands for submitting builds and checking status on a remote melange-server.`,
+		Long: `Commands for submitting builds and checking status on a remote melange-server.
+
+Requests are authenticated with the token of the MELANGE_SERVER_TOKEN
+environment variable, when it is set.`,
 	}
 
 	cmd.AddCommand(remoteSubmitCmd())
@@ -56,6 +73,7 @@ func remoteSubmitCmd() *cobra.Command {
 	var backendSelector []string
 	var mode string
 	var envVars []string
+	var concurrencyPerBackend int
 	// Git source options
 	var gitRepo string
 	var gitRef string
@@ -133,17 +151,18 @@ via --secret-env on the melange-server.`,
 				return fmt.Errorf("invalid mode %q: must be 'flat' or 'dag'", mode)
 			}
 
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 
 			// Build the request based on input mode
 			req := types.CreateBuildRequest{
-				Pipelines:       pipelines,
-				Arch:            arch,
-				BackendSelector: selector,
-				WithTest:        withTest,
-				Debug:           debug,
-				Mode:            buildMode,
-				Env:             env,
+				Pipelines:             pipelines,
+				Arch:                  arch,
+				BackendSelector:       selector,
+				WithTest:              withTest,
+				Debug:                 debug,
+				Mode:                  buildMode,
+				Env:                   env,
+				MaxParallelPerBackend: concurrencyPerBackend,
 			}
 
 			// Determine mode: git source, multi-config, or single config
@@ -233,6 +252,7 @@ via --secret-env on the melange-server.`,
 	cmd.Flags().BoolVar(&wait, "wait", false, "wait for build to complete")
 	cmd.Flags().StringSliceVar(&backendSelector, "backend-selector", nil, "backend label selector (key=value)")
 	cmd.Flags().StringSliceVar(&envVars, "env", nil, "environment variable in KEY=VALUE format (NOT for secrets - use server-side --secret-env)")
+	cmd.Flags().IntVar(&concurrencyPerBackend, "concurrency-per-backend", 0, "maximum packages of this build to run on one backend at once (0 = backend capacity)")
 	cmd.Flags().StringVar(&mode, "mode", "flat", "build scheduling mode: 'flat' (parallel, no deps) or 'dag' (dependency order)")
 	// Git source options
 	cmd.Flags().StringVar(&gitRepo, "git-repo", "", "git repository URL for package configs")
@@ -271,7 +291,7 @@ func remoteStatusCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			buildID := args[0]
 
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 			build, err := c.GetBuild(cmd.Context(), buildID)
 			if err != nil {
 				return fmt.Errorf("getting build: %w", err)
@@ -289,17 +309,25 @@ func remoteStatusCmd() *cobra.Command {
 
 func remoteListCmd() *cobra.Command {
 	var serverURL string
+	var pkg string
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all builds",
-		Long:  `List all builds on the server.`,
+		Long:  `List all builds on the server, or with --package only the builds of a package.`,
 		Example: `  melange remote list
+  melange remote list --package curl
   melange remote list --server http://myserver:8080`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			c := client.New(serverURL)
-			builds, err := c.ListBuilds(cmd.Context())
+			c := newClient(serverURL)
+			var builds []types.Build
+			var err error
+			if pkg != "" {
+				builds, err = c.ListBuildsByPackage(cmd.Context(), pkg)
+			} else {
+				builds, err = c.ListBuilds(cmd.Context())
+			}
 			if err != nil {
 				return fmt.Errorf("listing builds: %w", err)
 			}
@@ -326,6 +354,7 @@ func remoteListCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVar(&serverURL, "server", defaultServerURL, "melange-server URL")
+	cmd.Flags().StringVar(&pkg, "package", "", "only list the builds with this package")
 
 	return cmd
 }
@@ -344,7 +373,7 @@ func remoteWaitCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			buildID := args[0]
 
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 			fmt.Printf("Waiting for build %s...\n", buildID)
 
 			build, err := c.WaitForBuild(cmd.Context(), buildID, pollInterval)
@@ -397,7 +426,7 @@ func remoteBackendsListCmd() *cobra.Command {
   melange remote backends list --arch aarch64`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 			resp, err := c.ListBackends(cmd.Context(), arch)
 			if err != nil {
 				return fmt.Errorf("listing backends: %w", err)
@@ -439,6 +468,7 @@ func remoteBackendsAddCmd() *cobra.Command {
 	var serverURL string
 	var addr string
 	var arch string
+	var emulatedArchs []string
 	var labels []string
 
 	cmd := &cobra.Command{
@@ -449,7 +479,10 @@ func remoteBackendsAddCmd() *cobra.Command {
   melange remote backends add --addr tcp://buildkit:1234 --arch x86_64
 
   # Add a backend with labels
-  melange remote backends add --addr tcp://buildkit:1234 --arch aarch64 --label tier=high-memory --label sandbox=privileged`,
+  melange remote backends add --addr tcp://buildkit:1234 --arch aarch64 --label tier=high-memory --label sandbox=privileged
+
+  # Add an x86_64 backend that can also build aarch64 under QEMU emulation
+  melange remote backends add --addr tcp://buildkit:1234 --arch x86_64 --emulated-arch aarch64`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if addr == "" {
@@ -462,17 +495,21 @@ func remoteBackendsAddCmd() *cobra.Command {
 			// Parse labels
 			labelMap := parseSelector(labels)
 
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 			backend, err := c.AddBackend(cmd.Context(), buildkit.Backend{
-				Addr:   addr,
-				Arch:   arch,
-				Labels: labelMap,
+				Addr:          addr,
+				Arch:          arch,
+				EmulatedArchs: emulatedArchs,
+				Labels:        labelMap,
 			})
 			if err != nil {
 				return fmt.Errorf("adding backend: %w", err)
 			}
 
 			fmt.Printf("Added backend: %s (arch: %s)\n", backend.Addr, backend.Arch)
+			if len(backend.EmulatedArchs) > 0 {
+				fmt.Printf("Emulated architectures: %s\n", strings.Join(backend.EmulatedArchs, ", "))
+			}
 			if len(backend.Labels) > 0 {
 				var parts []string
 				for k, v := range backend.Labels {
@@ -488,6 +525,7 @@ func remoteBackendsAddCmd() *cobra.Command {
 	cmd.Flags().StringVar(&serverURL, "server", defaultServerURL, "melange-server URL")
 	cmd.Flags().StringVar(&addr, "addr", "", "BuildKit daemon address (e.g., tcp://buildkit:1234)")
 	cmd.Flags().StringVar(&arch, "arch", "", "architecture (e.g., x86_64, aarch64)")
+	cmd.Flags().StringSliceVar(&emulatedArchs, "emulated-arch", nil, "additional architecture the backend can build under emulation; native backends are preferred (can be specified multiple times)")
 	cmd.Flags().StringSliceVar(&labels, "label", nil, "backend label in key=value format (can be specified multiple times)")
 
 	_ = cmd.MarkFlagRequired("addr")
@@ -512,7 +550,7 @@ func remoteBackendsRemoveCmd() *cobra.Command {
 				return fmt.Errorf("--addr is required")
 			}
 
-			c := client.New(serverURL)
+			c := newClient(serverURL)
 			if err := c.RemoveBackend(cmd.Context(), addr); err != nil {
 				return fmt.Errorf("removing backend: %w", err)
 			}
@@ -0,0 +1,50 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func schemaCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "config-schema",
+		Short: "Print the JSON schema for melange configuration files",
+		Long: `Print the JSON schema for melange configuration files.
+The schema can be used by editors to validate and complete configurations.`,
+		Example: `  melange config-schema -o melange.schema.json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output == "" {
+				_, err := cmd.OutOrStdout().Write(config.Schema())
+				return err
+			}
+			if err := os.WriteFile(output, config.Schema(), 0o644); err != nil {
+				return fmt.Errorf("writing schema: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the schema to (default stdout)")
+
+	return cmd
+}
@@ -17,6 +17,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"time"
 
 	apko_types "chainguard.dev/apko/pkg/build/types"
 	"github.com/spf13/cobra"
@@ -25,14 +26,17 @@ import (
 	"github.com/dlorenc/melange2/pkg/build"
 	"github.com/dlorenc/melange2/pkg/buildkit"
 	"github.com/dlorenc/melange2/pkg/convention"
+	melangehttp "github.com/dlorenc/melange2/pkg/http"
 )
 
 // addTestFlags registers all test command flags to the provided FlagSet using the TestFlags struct
 func addTestFlags(fs *pflag.FlagSet, flags *TestFlags) {
 	fs.StringVar(&flags.WorkspaceDir, "workspace-dir", "", "directory used for the workspace at /home/build")
 	fs.StringSliceVar(&flags.PipelineDirs, "pipeline-dirs", []string{}, "directories used to extend defined built-in pipelines")
+	fs.BoolVar(&flags.ErrorOnShadow, "error-on-shadow", false, "fail when a pipeline of --pipeline-dirs shadows a builtin pipeline, rather than warning")
 	fs.StringVar(&flags.SourceDir, "source-dir", "", "directory used for included sources")
 	fs.StringVar(&flags.CacheDir, "cache-dir", "", "directory used for cached inputs")
+	fs.BoolVar(&flags.CacheDirReadOnly, "cache-dir-ro", false, "treat --cache-dir as a read-only shared cache that must already exist and is never written to")
 	fs.StringVar(&flags.ApkCacheDir, "apk-cache-dir", "", "directory used for cached apk packages (default is system-defined cache directory)")
 	fs.StringSliceVar(&flags.Archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config")
 	fs.StringSliceVarP(&flags.ExtraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the build environment keyring")
@@ -41,24 +45,34 @@ func addTestFlags(fs *pflag.FlagSet, flags *TestFlags) {
 	fs.StringSliceVarP(&flags.ExtraRepos, "repository-append", "r", []string{}, "path to extra repositories to include in the build environment")
 	fs.StringSliceVar(&flags.ExtraTestPackages, "test-package-append", []string{}, "extra packages to install for each of the test environments")
 	fs.BoolVar(&flags.IgnoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
-	fs.StringVar(&flags.BuildKitAddr, "buildkit-addr", buildkit.DefaultAddr, "BuildKit daemon address (e.g., tcp://localhost:1234)")
+	fs.BoolVar(&flags.InheritBuildRepos, "inherit-build-repos", false, "add the build environment's repositories and keyring to the test environments")
+	fs.StringVar(&flags.BuildKitAddr, "buildkit-addr", buildkit.DefaultAddr, "BuildKit daemon address (e.g., tcp://localhost:1234); when not given, $BUILDKIT_HOST or the socket of a local buildkitd is used before the default")
+	fs.DurationVar(&flags.BuildKitDialTimeout, "buildkit-dial-timeout", buildkit.DefaultDialTimeout, "how long to wait for the BuildKit daemon to respond before failing")
+	fs.StringVar(&flags.BuildKitWorker, "buildkit-worker", "", "BuildKit worker to use when the daemon has several, by ID or worker filter (e.g., labels.\"org.mobyproject.buildkit.worker.snapshotter\"==overlayfs)")
 }
 
 // TestFlags holds all parsed test command flags
 type TestFlags struct {
-	WorkspaceDir      string
-	SourceDir         string
-	CacheDir          string
-	ApkCacheDir       string
-	Archstrs          []string
-	PipelineDirs      []string
-	ExtraKeys         []string
-	ExtraRepos        []string
-	EnvFile           string
-	Debug             bool
-	ExtraTestPackages []string
-	IgnoreSignatures  bool
-	BuildKitAddr      string
+	WorkspaceDir        string
+	SourceDir           string
+	CacheDir            string
+	CacheDirReadOnly    bool
+	ApkCacheDir         string
+	Archstrs            []string
+	PipelineDirs        []string
+	ErrorOnShadow       bool
+	ExtraKeys           []string
+	ExtraRepos          []string
+	EnvFile             string
+	Debug               bool
+	ExtraTestPackages   []string
+	IgnoreSignatures    bool
+	UserAgent           string
+	Proxy               melangehttp.ProxyConfig
+	InheritBuildRepos   bool
+	BuildKitAddr        string
+	BuildKitDialTimeout time.Duration
+	BuildKitWorker      string
 }
 
 // ParseTestFlags parses test flags from the provided args and returns a TestFlags struct
@@ -81,6 +95,7 @@ func (flags *TestFlags) ToTestConfig(ctx context.Context, args ...string) (*buil
 
 	cfg.WorkspaceDir = flags.WorkspaceDir
 	cfg.CacheDir = flags.CacheDir
+	cfg.CacheDirReadOnly = flags.CacheDirReadOnly
 	cfg.ApkCacheDir = flags.ApkCacheDir
 	cfg.ExtraKeys = flags.ExtraKeys
 	cfg.ExtraRepos = flags.ExtraRepos
@@ -88,7 +103,12 @@ func (flags *TestFlags) ToTestConfig(ctx context.Context, args ...string) (*buil
 	cfg.EnvFile = flags.EnvFile
 	cfg.Debug = flags.Debug
 	cfg.IgnoreSignatures = flags.IgnoreSignatures
+	cfg.UserAgent = flags.UserAgent
+	cfg.Proxy = flags.Proxy
+	cfg.InheritBuildRepos = flags.InheritBuildRepos
 	cfg.BuildKitAddr = flags.BuildKitAddr
+	cfg.BuildKitDialTimeout = flags.BuildKitDialTimeout
+	cfg.BuildKitWorker = flags.BuildKitWorker
 
 	if len(args) > 0 {
 		cfg.ConfigFile = args[0]
@@ -104,6 +124,7 @@ func (flags *TestFlags) ToTestConfig(ctx context.Context, args ...string) (*buil
 	// Add pipeline directories
 	cfg.PipelineDirs = append(cfg.PipelineDirs, flags.PipelineDirs...)
 	cfg.PipelineDirs = append(cfg.PipelineDirs, convention.BuiltinPipelineDir)
+	cfg.ErrorOnShadow = flags.ErrorOnShadow
 
 	return cfg, nil
 }
@@ -121,6 +142,9 @@ func test() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 			archs := apko_types.ParseArchitectures(flags.Archstrs)
+			flags.UserAgent, _ = cmd.Flags().GetString("user-agent")
+			flags.Proxy = proxyFlags(cmd.Flags())
+			discoverBuildKitAddr(ctx, cmd.Flags(), &flags.BuildKitAddr)
 
 			cfg, err := flags.ToTestConfig(ctx, args...)
 			if err != nil {
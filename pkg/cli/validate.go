@@ -0,0 +1,184 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/dlorenc/melange2/pkg/build"
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+func validateCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check Melange YAML files for errors without building them",
+		Long: `Parse and fully validate one or more Melange YAML files, without building
+them or contacting BuildKit. Directories are expanded to the YAML files
+they contain. Every file is checked, and the problems of all of them are
+reported with their file and line. The command fails if any file is
+invalid.`,
+		Example: `  melange validate config.yaml
+  melange validate ./packages/
+  melange validate --json ./packages/`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ValidateCmd(cmd.Context(), args, jsonOutput, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "report the results as JSON")
+
+	return cmd
+}
+
+// ValidationResult is the outcome of validating one configuration file.
+type ValidationResult struct {
+	File  string `json:"file"`
+	Valid bool   `json:"valid"`
+	// Errors lists every problem found in the file.
+	Errors []ValidationError `json:"errors,omitempty"`
+}
+
+// ValidationError is one problem found in a configuration file.
+type ValidationError struct {
+	// Line and Column locate the problem, when known. They are 1-based;
+	// zero means unknown.
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ValidateCmd validates the configuration files at paths, expanding
+// directories to the YAML files they contain, and reports the result for
+// each to out, as a JSON array if jsonOutput is set. It returns an error if
+// any file is invalid.
+func ValidateCmd(ctx context.Context, paths []string, jsonOutput bool, out io.Writer) error {
+	files, err := build.ExpandConfigPaths(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no configuration files found in %v", paths)
+	}
+
+	results := make([]ValidationResult, 0, len(files))
+	failed := 0
+	for _, f := range files {
+		result := ValidationResult{File: f, Valid: true}
+		if _, err := config.ParseConfiguration(ctx, f); err != nil {
+			failed++
+			result.Valid = false
+			result.Errors = describeValidationErrors(err)
+		}
+		results = append(results, result)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			if r.Valid {
+				fmt.Fprintf(out, "%s: ok\n", r.File)
+			}
+			for _, e := range r.Errors {
+				switch {
+				case e.Column > 0:
+					fmt.Fprintf(out, "%s:%d:%d: %s\n", r.File, e.Line, e.Column, e.Error)
+				case e.Line > 0:
+					fmt.Fprintf(out, "%s:%d: %s\n", r.File, e.Line, e.Error)
+				default:
+					fmt.Fprintf(out, "%s: %s\n", r.File, e.Error)
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d configurations are invalid", failed, len(files))
+	}
+	return nil
+}
+
+var (
+	// yamlLine matches the line number YAML decoding errors are reported with.
+	yamlLine = regexp.MustCompile(`\bline (\d+):`)
+	// yamlFieldLine matches the line number that starts each problem of a
+	// YAML type error.
+	yamlFieldLine = regexp.MustCompile(`^line (\d+): `)
+)
+
+// describeValidationErrors returns every problem err reports, with its
+// position when known.
+func describeValidationErrors(err error) []ValidationError {
+	if invalid := config.ValidationErrors(err); len(invalid) > 0 {
+		errs := make([]ValidationError, 0, len(invalid))
+		for _, e := range invalid {
+			errs = append(errs, ValidationError{
+				Line:   e.Line,
+				Column: e.Column,
+				Error:  "build configuration is invalid: " + e.Problem.Error(),
+			})
+		}
+		// Report the problems in the order of the file, those of unknown
+		// position last.
+		slices.SortStableFunc(errs, func(a, b ValidationError) int {
+			if (a.Line == 0) != (b.Line == 0) {
+				return cmp.Compare(b.Line, a.Line)
+			}
+			return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+		})
+		return errs
+	}
+
+	// A configuration that fails to decode reports each mismatched field.
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		errs := make([]ValidationError, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			e := ValidationError{Error: msg}
+			if m := yamlFieldLine.FindStringSubmatch(msg); m != nil {
+				e.Line, _ = strconv.Atoi(m[1])
+				e.Error = strings.TrimPrefix(msg, m[0])
+			}
+			errs = append(errs, e)
+		}
+		return errs
+	}
+
+	e := ValidationError{Error: err.Error()}
+	if m := yamlLine.FindStringSubmatch(e.Error); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+	}
+	return []ValidationError{e}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCmd(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"good.yaml": `package:
+  name: hello
+  version: 1.0.0
+  epoch: 0
+pipeline:
+  - runs: echo hello
+`,
+		"unknown-field.yaml": `package:
+  name: unknown-field
+  version: 1.0.0
+  epoch: 0
+  colour: blue
+  size: large
+`,
+		"bad-subpackage.yaml": `package:
+  name: bad-subpackage
+  version: 1.0.0
+  epoch: 0
+subpackages:
+  - name: "bad name"
+  - name: "worse name"
+pipeline:
+  - name: empty
+`,
+		"README.md": "not a configuration",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+		err := ValidateCmd(ctx, []string{dir}, false, &out)
+		require.EqualError(t, err, "2 of 3 configurations are invalid")
+
+		got := out.String()
+		require.Contains(t, got, filepath.Join(dir, "good.yaml")+": ok\n")
+		require.Contains(t, got, filepath.Join(dir, "unknown-field.yaml")+":5: field colour not found")
+		require.Contains(t, got, filepath.Join(dir, "unknown-field.yaml")+":6: field size not found")
+		require.Contains(t, got, filepath.Join(dir, "bad-subpackage.yaml")+":6:5: build configuration is invalid: subpackage name \"bad name\"")
+		require.Contains(t, got, filepath.Join(dir, "bad-subpackage.yaml")+":7:5: build configuration is invalid: subpackage name \"worse name\"")
+		require.Contains(t, got, filepath.Join(dir, "bad-subpackage.yaml")+":9:5: build configuration is invalid: pipeline step \"empty\" has no action")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		err := ValidateCmd(ctx, []string{dir}, true, &out)
+		require.Error(t, err)
+
+		var results []ValidationResult
+		require.NoError(t, json.Unmarshal(out.Bytes(), &results))
+		require.Len(t, results, 3)
+
+		byFile := map[string]ValidationResult{}
+		for _, r := range results {
+			byFile[filepath.Base(r.File)] = r
+		}
+		require.True(t, byFile["good.yaml"].Valid)
+		require.Empty(t, byFile["good.yaml"].Errors)
+
+		require.False(t, byFile["unknown-field.yaml"].Valid)
+		require.Len(t, byFile["unknown-field.yaml"].Errors, 2)
+		require.Equal(t, 5, byFile["unknown-field.yaml"].Errors[0].Line)
+		require.Equal(t, 6, byFile["unknown-field.yaml"].Errors[1].Line)
+
+		require.False(t, byFile["bad-subpackage.yaml"].Valid)
+		errs := byFile["bad-subpackage.yaml"].Errors
+		require.Len(t, errs, 3)
+		require.Equal(t, 6, errs[0].Line)
+		require.Equal(t, 5, errs[0].Column)
+		require.Equal(t, 7, errs[1].Line)
+		require.Equal(t, 9, errs[2].Line)
+	})
+
+	t.Run("all valid", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, ValidateCmd(ctx, []string{filepath.Join(dir, "good.yaml")}, false, &out))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		var out bytes.Buffer
+		require.Error(t, ValidateCmd(ctx, []string{filepath.Join(dir, "missing.yaml")}, false, &out))
+	})
+}
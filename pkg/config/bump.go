@@ -0,0 +1,157 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"gopkg.in/yaml.v3"
+)
+
+// BumpOptions describes an in-place update of a package's version metadata.
+type BumpOptions struct {
+	// Version is the new package version. Setting it resets the epoch to 0.
+	Version string
+	// Epoch increments the package epoch, keeping the version as-is.
+	Epoch bool
+	// Commit, if set, replaces the package commit.
+	Commit string
+}
+
+// Bump rewrites the package version, epoch and commit of the configuration
+// file at path in place. Edits are made at the positions recorded in the
+// parsed YAML node, so comments and formatting elsewhere in the file are
+// left untouched.
+func Bump(ctx context.Context, path string, opts BumpOptions) error {
+	if opts.Version == "" && !opts.Epoch {
+		return errors.New("either a new version or an epoch bump must be requested")
+	}
+	if opts.Version != "" && opts.Epoch {
+		return errors.New("a version bump and an epoch bump cannot be combined")
+	}
+	if opts.Version != "" {
+		if _, err := apk.ParseVersion(opts.Version); err != nil {
+			return fmt.Errorf("invalid version %q: %w", opts.Version, err)
+		}
+	}
+
+	cfg, err := ParseConfiguration(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 - User-specified configuration file
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Root() == nil {
+		return fmt.Errorf("bumping %s: configuration has no retained YAML node", path)
+	}
+	pkg := valueNode(cfg.Root(), "package")
+	if pkg == nil || pkg.Kind != yaml.MappingNode {
+		return fmt.Errorf("bumping %s: package block not found", path)
+	}
+
+	e := &lineEditor{lines: strings.SplitAfter(string(data), "\n")}
+
+	version := valueNode(pkg, "version")
+	if version == nil {
+		return fmt.Errorf("bumping %s: package.version is not set", path)
+	}
+
+	epoch := cfg.Package.Epoch + 1
+	if opts.Version != "" {
+		e.replace(version, opts.Version)
+		epoch = 0
+	}
+	if n := valueNode(pkg, "epoch"); n != nil {
+		e.replace(n, strconv.FormatUint(epoch, 10))
+	} else {
+		e.insertAfter(version, "epoch", strconv.FormatUint(epoch, 10))
+	}
+
+	if opts.Commit != "" {
+		if n := valueNode(pkg, "commit"); n != nil {
+			e.replace(n, opts.Commit)
+		} else {
+			e.insertAfter(version, "commit", opts.Commit)
+		}
+	}
+
+	return os.WriteFile(path, e.bytes(), info.Mode().Perm())
+}
+
+// lineEditor applies scalar replacements and key insertions to a file's
+// lines using node positions from the original parse.
+type lineEditor struct {
+	lines   []string
+	inserts map[int][]string
+}
+
+// replace substitutes the scalar at n's position with value, keeping the
+// scalar's quoting style.
+func (e *lineEditor) replace(n *yaml.Node, value string) {
+	line := e.lines[n.Line-1]
+	start := n.Column - 1
+
+	width := len(n.Value)
+	switch {
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		width += 2
+		value = strconv.Quote(value)
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		width += 2 + strings.Count(n.Value, "'")
+		value = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+
+	e.lines[n.Line-1] = line[:start] + value + line[min(start+width, len(line)):]
+}
+
+// insertAfter adds a "key: value" line after the line holding n, indented
+// like n's key.
+func (e *lineEditor) insertAfter(n *yaml.Node, key, value string) {
+	line := e.lines[n.Line-1]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+	if e.inserts == nil {
+		e.inserts = map[int][]string{}
+	}
+	e.inserts[n.Line-1] = append(e.inserts[n.Line-1], fmt.Sprintf("%s%s: %s\n", indent, key, value))
+}
+
+func (e *lineEditor) bytes() []byte {
+	var buf bytes.Buffer
+	for i, line := range e.lines {
+		buf.WriteString(line)
+		if extra, ok := e.inserts[i]; ok {
+			if !strings.HasSuffix(line, "\n") {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(strings.Join(extra, ""))
+		}
+	}
+	return buf.Bytes()
+}
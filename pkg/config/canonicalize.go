@@ -0,0 +1,296 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"gopkg.in/yaml.v3"
+)
+
+// canonicalSortedLists names the lists of each type whose order carries no
+// meaning, and which are therefore sorted by Canonicalize.
+var canonicalSortedLists = map[reflect.Type][]string{
+	reflect.TypeFor[Dependencies]():             {"runtime", "provides", "replaces"},
+	reflect.TypeFor[apko_types.ImageContents](): {"packages"},
+}
+
+// Canonicalize writes the configuration as it appears in its file, in a
+// canonical form suitable for stable diffs: keys are ordered as the fields
+// of the configuration types are declared, map keys and dependency and
+// package lists are sorted, and the YAML is written in block style, indented
+// by two spaces with a blank line between top-level sections. Keys that are
+// not fields of the configuration types keep their place, and collections
+// holding anchors or aliases are not reordered, so that each alias still
+// follows its anchor. Comments are kept, and the meaning of the
+// configuration is unchanged. Canonicalizing a canonical configuration is a
+// no-op.
+//
+// The configuration must have been parsed from a file, so that its YAML is
+// retained.
+func (cfg Configuration) Canonicalize(w io.Writer) error {
+	if cfg.root == nil {
+		return errors.New("configuration has no retained YAML node")
+	}
+
+	root := copyNode(cfg.root, map[*yaml.Node]*yaml.Node{})
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	canonicalizeNode(doc, reflect.TypeFor[Configuration]())
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(separateSections(buf.Bytes()))
+	return err
+}
+
+// separateSections puts a blank line before each top-level key of the YAML
+// document data but the first, along with the comments directly above it.
+func separateSections(data []byte) []byte {
+	var out []string
+	seenKey := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" && !strings.ContainsAny(line[:1], " #-\n") {
+			if seenKey {
+				// Start the section at its comments.
+				i := len(out)
+				for i > 0 && strings.HasPrefix(out[i-1], "#") {
+					i--
+				}
+				if i > 0 && out[i-1] != "\n" {
+					out = slices.Insert(out, i, "\n")
+				}
+			}
+			seenKey = true
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, ""))
+}
+
+// canonicalizeNode reorders n, which decodes into a value of type t, and its
+// children in place.
+func canonicalizeNode(n *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if f, ok := fields[n.Content[i].Value]; ok {
+				canonicalizeNode(n.Content[i+1], f.typ)
+			}
+		}
+		// Keys that are not fields, such as those of extensions, keep
+		// their place.
+		known := func(key *yaml.Node) bool {
+			_, ok := fields[key.Value]
+			return ok
+		}
+		sortPairs(n, known, func(a, b *yaml.Node) int {
+			return fields[a.Value].index - fields[b.Value].index
+		})
+		for _, key := range canonicalSortedLists[t] {
+			if list := valueNode(n, key); list != nil && list.Kind == yaml.SequenceNode && !hasAnchors(list) {
+				tail := detachFootComments(list.Content)
+				slices.SortStableFunc(list.Content, func(a, b *yaml.Node) int {
+					return strings.Compare(sortedListKey(a), sortedListKey(b))
+				})
+				attachFootComment(list.Content, tail)
+			}
+		}
+
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(n.Content); i += 2 {
+			canonicalizeNode(n.Content[i], t.Elem())
+		}
+		sortPairs(n, nil, func(a, b *yaml.Node) int {
+			return strings.Compare(a.Value, b.Value)
+		})
+
+	case n.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, item := range n.Content {
+			canonicalizeNode(item, t.Elem())
+		}
+	}
+}
+
+// sortedListKey returns what an item of a sorted list is sorted by: its
+// value, or the name of a conditional package.
+func sortedListKey(n *yaml.Node) string {
+	if name := valueNode(n, "name"); name != nil {
+		return name.Value
+	}
+	return n.Value
+}
+
+type yamlField struct {
+	index int
+	typ   reflect.Type
+}
+
+// yamlFields returns the fields of the struct type t by YAML key, numbered
+// in declaration order. Inlined structs are flattened into t.
+func yamlFields(t reflect.Type) map[string]yamlField {
+	fields := map[string]yamlField{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			tag := sf.Tag.Get("yaml")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if slices.Contains(strings.Split(opts, ","), "inline") {
+				ft := sf.Type
+				for ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					collect(ft)
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(sf.Name)
+			}
+			if _, ok := fields[name]; !ok {
+				fields[name] = yamlField{index: len(fields), typ: sf.Type}
+			}
+		}
+	}
+	collect(t)
+	return fields
+}
+
+// sortPairs stably sorts the key/value pairs of the mapping node n by key.
+// Only the pairs whose key is movable, or all if movable is nil, are sorted,
+// among the places they hold; the others stay where they are. A mapping
+// with anchors or aliases is left as is, as an alias must follow its
+// anchor.
+func sortPairs(n *yaml.Node, movable func(key *yaml.Node) bool, cmp func(a, b *yaml.Node) int) {
+	if hasAnchors(n) {
+		return
+	}
+
+	var pairs [][2]*yaml.Node
+	var places []int
+	keys := make([]*yaml.Node, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if movable == nil || movable(n.Content[i]) {
+			pairs = append(pairs, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+			places = append(places, i)
+		}
+		keys = append(keys, n.Content[i])
+	}
+	tail := detachFootComments(keys)
+	slices.SortStableFunc(pairs, func(a, b [2]*yaml.Node) int {
+		return cmp(a[0], b[0])
+	})
+	for j, p := range pairs {
+		i := places[j]
+		n.Content[i], n.Content[i+1] = p[0], p[1]
+	}
+	for i := range keys {
+		keys[i] = n.Content[2*i]
+	}
+	attachFootComment(keys, tail)
+}
+
+// hasAnchors reports whether n, or any node within it, is an anchor or an
+// alias.
+func hasAnchors(n *yaml.Node) bool {
+	if n.Anchor != "" || n.Kind == yaml.AliasNode {
+		return true
+	}
+	return slices.ContainsFunc(n.Content, hasAnchors)
+}
+
+// detachFootComments prepares the items of a collection for reordering. A
+// comment after an item is moved before the next one, so that it keeps
+// introducing it, and the comment after the last item, which ends the
+// collection, is removed and returned.
+func detachFootComments(items []*yaml.Node) string {
+	if len(items) == 0 {
+		return ""
+	}
+	for i, item := range items[:len(items)-1] {
+		if item.FootComment == "" {
+			continue
+		}
+		next := items[i+1]
+		next.HeadComment = strings.TrimSuffix(item.FootComment+"\n"+next.HeadComment, "\n")
+		item.FootComment = ""
+	}
+	last := items[len(items)-1]
+	tail := last.FootComment
+	last.FootComment = ""
+	return tail
+}
+
+// attachFootComment puts the comment returned by detachFootComments back at
+// the end of the reordered items.
+func attachFootComment(items []*yaml.Node, comment string) {
+	if len(items) > 0 {
+		items[len(items)-1].FootComment = comment
+	}
+}
+
+// copyNode returns a deep copy of n in block style, so that it can be
+// reordered without changing the retained YAML. Aliases refer to the copies
+// of their anchors.
+func copyNode(n *yaml.Node, copies map[*yaml.Node]*yaml.Node) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	if c, ok := copies[n]; ok {
+		return c
+	}
+	c := *n
+	copies[n] = &c
+	if (c.Kind == yaml.MappingNode || c.Kind == yaml.SequenceNode) && len(c.Content) > 0 {
+		// Flow collections are written in block style, apart from empty
+		// ones which have no block form.
+		c.Style &^= yaml.FlowStyle
+	}
+	c.Alias = copyNode(n.Alias, copies)
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = copyNode(child, copies)
+	}
+	return &c
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"gopkg.in/yaml.v3"
+)
+
+// ChangelogDateFormat is the layout of the dates of changelog entries.
+const ChangelogDateFormat = time.DateOnly
+
+// ChangelogPath returns the absolute path the changelog of the package is
+// installed at, or "" if it has none.
+func (p Package) ChangelogPath() string {
+	if len(p.Changelog) == 0 {
+		return ""
+	}
+	return path.Join("/usr/share/doc", p.Name, "changelog")
+}
+
+// WriteChangelog writes the changelog of the package in the style of a
+// Debian changelog: a heading naming the package, version and date of each
+// release, newest first, followed by a bulleted list of its changes.
+func (p Package) WriteChangelog(w io.Writer) error {
+	var b strings.Builder
+	for i, e := range p.Changelog {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s (%s) %s\n\n", p.Name, e.Version, e.Date)
+		for _, entry := range e.Entries {
+			lines := strings.Split(strings.TrimRight(entry, "\n"), "\n")
+			fmt.Fprintf(&b, "  * %s\n", lines[0])
+			for _, line := range lines[1:] {
+				fmt.Fprintf(&b, "    %s\n", line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// validateChangelog validates the changelog of p: each release must have a
+// valid version and date and at least one change, and the releases must be
+// listed newest first, none newer than the package itself. nodes, if known,
+// is the sequence node the changelog was parsed from.
+func validateChangelog(p Package, nodes *yaml.Node) error {
+	if nodes != nil && (nodes.Kind != yaml.SequenceNode || len(nodes.Content) != len(p.Changelog)) {
+		nodes = nil
+	}
+
+	// Each release must be older than the one listed before it, and the
+	// first no newer than the package, if its version can be compared.
+	newer, err := apk.ParseVersion(p.FullVersion())
+	ordered := err == nil
+	newerVersion := p.FullVersion()
+	for i, e := range p.Changelog {
+		var node *yaml.Node
+		if nodes != nil {
+			node = nodes.Content[i]
+		}
+
+		if e.Version == "" {
+			return errorAt(node, fmt.Errorf("changelog entry [%d] must have a version", i))
+		}
+		v, err := apk.ParseVersion(e.Version)
+		if err != nil {
+			return errorAt(valueNode(node, "version"), fmt.Errorf("changelog version %q: %w", e.Version, err))
+		}
+		if _, err := time.Parse(ChangelogDateFormat, e.Date); err != nil {
+			return errorAt(valueNode(node, "date"), fmt.Errorf("changelog %s: date %q must be formatted as YYYY-MM-DD", e.Version, e.Date))
+		}
+		if len(e.Entries) == 0 {
+			return errorAt(node, fmt.Errorf("changelog %s must list at least one change", e.Version))
+		}
+
+		if ordered {
+			switch c := apk.CompareVersions(v, newer); {
+			case i == 0 && c > 0:
+				return errorAt(valueNode(node, "version"), fmt.Errorf("changelog version %s is newer than the package version %s", e.Version, newerVersion))
+			case i > 0 && c >= 0:
+				return errorAt(valueNode(node, "version"), fmt.Errorf("changelog version %s must be older than %s, which is listed before it", e.Version, newerVersion))
+			}
+		}
+		newer, newerVersion, ordered = v, e.Version, true
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// ConditionalDependency is a runtime dependency that is only emitted when a
+// condition holds. It is written in dependencies.runtime as a mapping rather
+// than a string:
+//
+//	dependencies:
+//	  runtime:
+//	    - ca-certificates-bundle
+//	    - name: hello-plugins
+//	      if: ${{subpackages.hello-plugins.enabled}} == 'true'
+type ConditionalDependency struct {
+	// The package to depend on, as an entry of dependencies.runtime
+	Name string `json:"name" yaml:"name"`
+	// The condition, in the syntax of a pipeline's if, under which the
+	// dependency is emitted. It is evaluated when the package is built.
+	If string `json:"if" yaml:"if"`
+}
+
+// UnmarshalYAML decodes the dependencies as written, whose runtime
+// dependencies may be conditional.
+func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	rest, runtimeNode := withoutKey(node, "runtime")
+
+	type plain Dependencies
+	var runtime []runtimeDependency
+	err := decodeStrict(rest, (*plain)(d))
+	if runtimeNode != nil {
+		err = joinTypeErrors(err, decodeStrict(runtimeNode, &runtime))
+	}
+	if err != nil || runtimeNode == nil {
+		return err
+	}
+
+	d.Runtime, d.ConditionalRuntime = nil, nil
+	for _, dep := range runtime {
+		if dep.conditional != nil {
+			d.ConditionalRuntime = append(d.ConditionalRuntime, *dep.conditional)
+		} else {
+			d.Runtime = append(d.Runtime, dep.name)
+		}
+	}
+	return nil
+}
+
+// runtimeDependency is an entry of dependencies.runtime: the name of a
+// package, or a conditional dependency written as a mapping.
+type runtimeDependency struct {
+	name        string
+	conditional *ConditionalDependency
+}
+
+func (d *runtimeDependency) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return node.Decode(&d.name)
+	}
+	name, cond, err := decodeConditional(node, "conditional dependency", "depend on it")
+	if err != nil {
+		return err
+	}
+	d.conditional = &ConditionalDependency{Name: name, If: cond}
+	return nil
+}
@@ -15,12 +15,12 @@
 package config
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"iter"
 	"maps"
@@ -35,9 +35,11 @@ import (
 	purl "github.com/package-url/packageurl-go"
 
 	"github.com/dlorenc/melange2/pkg/sbom"
+	"github.com/dlorenc/melange2/pkg/util"
 
 	"github.com/chainguard-dev/clog"
 	"github.com/joho/godotenv"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
@@ -87,6 +89,11 @@ type PackageOption struct {
 	NoCommands bool `json:"no-commands,omitempty" yaml:"no-commands,omitempty"`
 	// Optional: Don't generate versioned depends for shared libraries
 	NoVersionedShlibDeps bool `json:"no-versioned-shlib-deps,omitempty" yaml:"no-versioned-shlib-deps,omitempty"`
+	// Optional: Generate so: and so-ver: provides for every shared library
+	// of the package with a SONAME, not only those in the library
+	// directories. No so-ver: provides are generated with
+	// no-versioned-shlib-deps.
+	ShlibProvides bool `json:"shlib-provides,omitempty" yaml:"shlib-provides,omitempty"`
 }
 
 type Checks struct {
@@ -96,9 +103,9 @@ type Checks struct {
 
 type Package struct {
 	// The name of the package
-	Name string `json:"name" yaml:"name"`
+	Name string `json:"name" yaml:"name" jsonschema:"required"`
 	// The version of the package
-	Version string `json:"version" yaml:"version"`
+	Version string `json:"version" yaml:"version" jsonschema:"required"`
 	// The monotone increasing epoch of the package
 	Epoch uint64 `json:"epoch" yaml:"epoch"`
 	// A human-readable description of the package
@@ -137,6 +144,160 @@ type Package struct {
 	// appropriately-sized test pods/VMs. If not specified, falls back
 	// to Resources.
 	TestResources *Resources `json:"test-resources,omitempty" yaml:"test-resources,omitempty"`
+	// Optional: Options that alter the generated SBOM
+	SBOM *PackageSBOM `json:"sbom,omitempty" yaml:"sbom,omitempty"`
+	// Optional: The history of changes to the package, newest release first.
+	// It is installed as /usr/share/doc/<name>/changelog
+	Changelog []ChangelogEntry `json:"changelog,omitempty" yaml:"changelog,omitempty"`
+	// Optional: The versions of melange that can build the package, as a
+	// semantic version constraint such as ">= 0.30.0, < 1"
+	NeedsMelangeVersion string `json:"needs-melange-version,omitempty" yaml:"needs-melange-version,omitempty"`
+}
+
+// ChangelogEntry describes the changes made in one release of a package.
+type ChangelogEntry struct {
+	// The version of the release, optionally with its epoch as -r<epoch>
+	Version string `json:"version" yaml:"version" jsonschema:"required"`
+	// The date of the release, as YYYY-MM-DD
+	Date string `json:"date" yaml:"date" jsonschema:"required"`
+	// The changes made in the release
+	Entries []string `json:"entries" yaml:"entries" jsonschema:"required"`
+}
+
+// PackageSBOM holds options that alter the SBOM generated for a package.
+type PackageSBOM struct {
+	// Optional: Packages to declare in the SBOM in addition to those melange
+	// detects itself, such as components vendored into the source tree
+	ExtraPackages []SBOMExtraPackage `json:"extra-packages,omitempty" yaml:"extra-packages,omitempty"`
+	// Optional: Patches applied to the source during the build
+	Patches []SBOMPatch `json:"patches,omitempty" yaml:"patches,omitempty"`
+	// Optional: Also declare the patches applied by `patch` pipeline steps
+	// listed in their `patches` input
+	DetectPatches bool `json:"detect-patches,omitempty" yaml:"detect-patches,omitempty"`
+}
+
+// SBOMPatch is a patch applied to the source of a package during the build.
+type SBOMPatch struct {
+	// The path of the patch file, relative to the workspace
+	Path string `json:"path" yaml:"path" jsonschema:"required"`
+	// Optional: What the patch changes, and why
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// SBOMPackage returns the SBOM element for the patch. The patch carries the
+// license of the package whose source it modifies.
+func (p SBOMPatch) SBOMPackage(supplier, license string) *sbom.Package {
+	return &sbom.Package{
+		IDComponents:    []string{"patch", p.Path},
+		Name:            p.Path,
+		LicenseDeclared: license,
+		Namespace:       supplier,
+		Description:     p.Description,
+		PrimaryPurpose:  "FILE",
+	}
+}
+
+// SBOMExtraPackage is a package declared manually in the SBOM.
+type SBOMExtraPackage struct {
+	// The name of the package
+	Name string `json:"name" yaml:"name" jsonschema:"required"`
+	// Optional: The version of the package
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Optional: The SPDX license expression of the package
+	License string `json:"license,omitempty" yaml:"license,omitempty"`
+	// Optional: The package URL identifying the package
+	PURL string `json:"purl,omitempty" yaml:"purl,omitempty"`
+	// Optional: Where the package was obtained from
+	DownloadLocation string `json:"download-location,omitempty" yaml:"download-location,omitempty"`
+}
+
+// PackageURL returns the normalized package URL of the extra package, or nil
+// if none is declared.
+func (ep SBOMExtraPackage) PackageURL() (*purl.PackageURL, error) {
+	if ep.PURL == "" {
+		return nil, nil
+	}
+
+	pu, err := purl.FromString(ep.PURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing purl %q: %w", ep.PURL, err)
+	}
+	if err := pu.Normalize(); err != nil {
+		return nil, fmt.Errorf("normalizing purl %q: %w", ep.PURL, err)
+	}
+	return &pu, nil
+}
+
+// SBOMPackage returns the SBOM package for the extra package. The supplier is
+// recorded as the package's namespace.
+func (ep SBOMExtraPackage) SBOMPackage(supplier string) (*sbom.Package, error) {
+	pu, err := ep.PackageURL()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sbom.Package{
+		// Keep extra packages apart from detected packages of the same name.
+		IDComponents:     []string{"extra", ep.Name, ep.Version},
+		Name:             ep.Name,
+		Version:          ep.Version,
+		LicenseDeclared:  ep.License,
+		Namespace:        supplier,
+		PURL:             pu,
+		DownloadLocation: ep.DownloadLocation,
+	}, nil
+}
+
+// SBOMPatches returns the patches to declare in the SBOM of the package: those
+// declared under sbom.patches and, with sbom.detect-patches, those applied by
+// `patch` steps of the main pipeline. A patch is declared once, with its
+// description if it has one.
+func (cfg Configuration) SBOMPatches() []SBOMPatch {
+	s := cfg.Package.SBOM
+	if s == nil {
+		return nil
+	}
+
+	patches := slices.Clone(s.Patches)
+	if !s.DetectPatches {
+		return patches
+	}
+	seen := map[string]bool{}
+	for _, p := range patches {
+		seen[p.Path] = true
+	}
+	var walk func(steps []Pipeline)
+	walk = func(steps []Pipeline) {
+		for _, step := range steps {
+			if step.Uses == "patch" {
+				for _, path := range strings.Fields(step.With["patches"]) {
+					if !seen[path] {
+						seen[path] = true
+						patches = append(patches, SBOMPatch{Path: path})
+					}
+				}
+			}
+			walk(step.Pipeline)
+		}
+	}
+	walk(cfg.Pipeline)
+	return patches
+}
+
+// ParseSBOMExtraPackage parses an extra SBOM package from a package URL, such
+// as "pkg:golang/github.com/foo/bar@v1.2.3". The name and version are taken
+// from the package URL.
+func ParseSBOMExtraPackage(s string) (SBOMExtraPackage, error) {
+	ep := SBOMExtraPackage{PURL: s}
+	pu, err := ep.PackageURL()
+	if err != nil {
+		return SBOMExtraPackage{}, err
+	}
+	if pu == nil {
+		return SBOMExtraPackage{}, fmt.Errorf("empty package URL")
+	}
+	ep.Name, ep.Version = pu.Name, pu.Version
+	return ep, nil
 }
 
 // CPE stores values used to produce a CPE to describe the package, suitable for
@@ -171,6 +332,9 @@ type Resources struct {
 	CPUModel string `json:"cpumodel,omitempty" yaml:"cpumodel,omitempty"`
 	Memory   string `json:"memory,omitempty" yaml:"memory,omitempty"`
 	Disk     string `json:"disk,omitempty" yaml:"disk,omitempty"`
+	// The GPU the build needs, such as "nvidia". melange-server only runs
+	// the build on a backend with the label gpu set to this value.
+	GPU string `json:"gpu,omitempty" yaml:"gpu,omitempty"`
 }
 
 // CPEString returns the CPE string for the package, suitable for matching
@@ -290,7 +454,7 @@ type Copyright struct {
 	// Optional: Attestations of the license
 	Attestation string `json:"attestation,omitempty" yaml:"attestation,omitempty"`
 	// Required: The license for this package
-	License string `json:"license" yaml:"license"`
+	License string `json:"license" yaml:"license" jsonschema:"required"`
 	// Optional: Path to text of the custom License Ref
 	LicensePath string `json:"license-path,omitempty" yaml:"license-path,omitempty"`
 	// Optional: License override
@@ -313,26 +477,120 @@ func (p Package) LicenseExpression() string {
 	return licenseExpression
 }
 
+// DefaultMaxLicenseFileSize is the largest license file LicensingInfos reads
+// unless overridden with WithMaxLicenseFileSize.
+const DefaultMaxLicenseFileSize int64 = 4 << 20
+
+// defaultLicenseReadConcurrency is the number of license files LicensingInfos
+// reads at once unless overridden with WithLicenseReadConcurrency.
+const defaultLicenseReadConcurrency = 4
+
+type licensingInfosOptions struct {
+	maxFileSize int64
+	concurrency int
+}
+
+// LicensingInfosOption configures LicensingInfos.
+type LicensingInfosOption func(*licensingInfosOptions)
+
+// WithMaxLicenseFileSize sets the largest license file, in bytes, that
+// LicensingInfos will read. Larger files are reported as an error. A value
+// of zero or less disables the limit.
+func WithMaxLicenseFileSize(size int64) LicensingInfosOption {
+	return func(o *licensingInfosOptions) {
+		o.maxFileSize = size
+	}
+}
+
+// WithLicenseReadConcurrency sets how many license files LicensingInfos reads
+// at once.
+func WithLicenseReadConcurrency(n int) LicensingInfosOption {
+	return func(o *licensingInfosOptions) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
 // LicensingInfos looks at the `Package.Copyright[].LicensePath` fields of the
 // parsed build configuration for the package. If this value has been set,
 // LicensingInfos opens the file at this path from the build's workspace
 // directory, and reads in the license content. LicensingInfos then returns a
 // map of the `Copyright.License` field to the string content of the file from
 // `.LicensePath`.
-func (p Package) LicensingInfos(workspaceDir string) (map[string]string, error) {
-	licenseInfos := make(map[string]string)
-	for _, cp := range p.Copyright {
-		if cp.LicensePath != "" {
-			content, err := os.ReadFile(filepath.Join(workspaceDir, cp.LicensePath)) // #nosec G304 - Reading license file from build workspace
+//
+// Files are read concurrently, and files larger than
+// DefaultMaxLicenseFileSize are rejected; see WithMaxLicenseFileSize. If
+// several entries share a license, the last one wins.
+func (p Package) LicensingInfos(workspaceDir string, opts ...LicensingInfosOption) (map[string]string, error) {
+	o := licensingInfosOptions{
+		maxFileSize: DefaultMaxLicenseFileSize,
+		concurrency: defaultLicenseReadConcurrency,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	contents := make([]string, len(p.Copyright))
+	var g errgroup.Group
+	g.SetLimit(o.concurrency)
+	for i, cp := range p.Copyright {
+		if cp.LicensePath == "" {
+			continue
+		}
+		g.Go(func() error {
+			content, err := readLicenseFile(filepath.Join(workspaceDir, cp.LicensePath), o.maxFileSize)
 			if err != nil {
-				return nil, fmt.Errorf("failed to read licensepath %q: %w", cp.LicensePath, err)
+				return fmt.Errorf("failed to read licensepath %q: %w", cp.LicensePath, err)
 			}
-			licenseInfos[cp.License] = string(content)
+			contents[i] = content
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	licenseInfos := make(map[string]string)
+	for i, cp := range p.Copyright {
+		if cp.LicensePath != "" {
+			licenseInfos[cp.License] = contents[i]
 		}
 	}
 	return licenseInfos, nil
 }
 
+// readLicenseFile reads the file at path, failing if it is larger than
+// maxSize bytes. The size is checked both before and while reading so that a
+// file that grows, or a symlink to a device, cannot exhaust memory.
+func readLicenseFile(path string, maxSize int64) (string, error) {
+	f, err := os.Open(path) // #nosec G304 - Reading license file from build workspace
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if maxSize <= 0 {
+		content, err := io.ReadAll(f)
+		return string(content), err
+	}
+
+	if fi, err := f.Stat(); err != nil {
+		return "", err
+	} else if fi.Size() > maxSize {
+		return "", fmt.Errorf("file is %d bytes, larger than the limit of %d bytes", fi.Size(), maxSize)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) > maxSize {
+		return "", fmt.Errorf("file is larger than the limit of %d bytes", maxSize)
+	}
+	return string(content), nil
+}
+
 // FullCopyright returns the concatenated copyright expressions defined
 // in the configuration file.
 func (p Package) FullCopyright() string {
@@ -399,6 +657,16 @@ type Pipeline struct {
 	WorkDir string `json:"working-directory,omitempty" yaml:"working-directory,omitempty"`
 	// Optional: environment variables to override apko
 	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: The name of a data range to loop the pipeline over
+	//
+	// The pipeline runs once for each item of the range, in key order, with
+	// ${{range.key}} and ${{range.value}} substituted, in place of the
+	// pipeline itself. For example, to fetch several tarballs:
+	// 		range: tarballs
+	// 		uses: fetch
+	// 		with:
+	// 		  uri: ${{range.value}}
+	Range string `json:"range,omitempty" yaml:"range,omitempty"`
 }
 
 // SHA256 generates a digest based on the text provided
@@ -620,7 +888,7 @@ type Subpackage struct {
 	// Optional: The iterable used to generate multiple subpackages
 	Range string `json:"range,omitempty" yaml:"range,omitempty"`
 	// Required: Name of the subpackage
-	Name string `json:"name" yaml:"name"`
+	Name string `json:"name" yaml:"name" jsonschema:"required"`
 	// Optional: The list of pipelines that produce subpackage.
 	Pipeline []Pipeline `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
 	// Optional: List of packages to depend on
@@ -634,6 +902,9 @@ type Subpackage struct {
 	URL string `json:"url,omitempty" yaml:"url,omitempty"`
 	// Optional: The git commit of the subpackage build configuration
 	Commit string `json:"commit,omitempty" yaml:"commit,omitempty"`
+	// Optional: The namespace of the subpackage's package URL ("purl") in
+	// the SBOM, in place of the namespace of the build
+	PurlNamespace string `json:"purl-namespace,omitempty" yaml:"purl-namespace,omitempty"`
 	// Optional: enabling, disabling, and configuration of build checks
 	Checks Checks `json:"checks" yaml:"checks,omitempty"`
 	// Test section for the subpackage.
@@ -662,12 +933,25 @@ type Capabilities struct {
 // Configuration is the root melange configuration.
 type Configuration struct {
 	// Package metadata
-	Package Package `json:"package" yaml:"package"`
+	Package Package `json:"package" yaml:"package" jsonschema:"required"`
 	// The specification for the packages build environment
 	// Optional: environment variables to override apko
 	Environment apko_types.ImageConfiguration `json:"environment" yaml:"environment,omitempty"`
 	// Optional: Linux capabilities configuration to apply to the melange runner.
 	Capabilities Capabilities `json:"capabilities" yaml:"capabilities,omitempty"`
+	// RedactEnvironment names the environment variables whose values are
+	// replaced with "***" in build and test logs. It is read from
+	// environment.redact. Variables with secret-like names, such as
+	// GITHUB_TOKEN, are masked even when not listed.
+	RedactEnvironment []string `json:"-" yaml:"-"`
+	// PackageNotes explains why packages of the build environment are
+	// installed, keyed by package name. It is read from the package-notes
+	// of environment.contents, and is only recorded, never resolved.
+	PackageNotes map[string]string `json:"-" yaml:"-"`
+	// ConditionalPackages are the packages of the build environment that
+	// are only installed when their condition holds. They are read from the
+	// mapping entries of environment.contents.packages.
+	ConditionalPackages []ConditionalPackage `json:"-" yaml:"-"`
 
 	// Required: The list of pipelines that produce the package.
 	Pipeline []Pipeline `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
@@ -690,6 +974,9 @@ type Configuration struct {
 	// Test section for the main package.
 	Test *Test `json:"test,omitempty" yaml:"test,omitempty"`
 
+	// Warnings are the warnings found parsing this configuration.
+	Warnings []Warning `json:"-" yaml:"-"`
+
 	// Parsed AST for this configuration
 	root *yaml.Node
 }
@@ -720,6 +1007,25 @@ type Test struct {
 
 	// Required: The list of pipelines that test the produced package.
 	Pipeline []Pipeline `json:"pipeline" yaml:"pipeline"`
+
+	// Optional: Run the tests once on each of several base images
+	Matrix *TestMatrix `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+}
+
+// TestMatrix lists the variants of the environment the tests of a package
+// run in.
+type TestMatrix struct {
+	// The images the tests run on, each in turn, with the test environment,
+	// including the package under test, installed on top of them.
+	BaseImages []string `json:"base-images,omitempty" yaml:"base-images,omitempty"`
+}
+
+// BaseImages returns the base images of the test matrix, if any.
+func (t *Test) BaseImages() []string {
+	if t == nil || t.Matrix == nil {
+		return nil
+	}
+	return t.Matrix.BaseImages
 }
 
 // Name returns a name for the configuration, using the package name. This
@@ -733,15 +1039,15 @@ type VarTransforms struct {
 	// Required: The original template variable.
 	//
 	// Example: ${{package.version}}
-	From string `json:"from" yaml:"from"`
+	From string `json:"from" yaml:"from" jsonschema:"required"`
 	// Required: The regular expression to match against the `from` variable
-	Match string `json:"match" yaml:"match"`
+	Match string `json:"match" yaml:"match" jsonschema:"required"`
 	// Required: The repl to replace on all `match` matches
-	Replace string `json:"replace" yaml:"replace"`
+	Replace string `json:"replace" yaml:"replace" jsonschema:"required"`
 	// Required: The name of the new variable to create
 	//
 	// Example: mangeled-package-version
-	To string `json:"to" yaml:"to"`
+	To string `json:"to" yaml:"to" jsonschema:"required"`
 }
 
 // Update provides information used to describe how to keep the package up to date
@@ -779,7 +1085,7 @@ type Update struct {
 // ReleaseMonitor indicates using the API for https://release-monitoring.org/
 type ReleaseMonitor struct {
 	// Required: ID number for release monitor
-	Identifier int `json:"identifier" yaml:"identifier"`
+	Identifier int `json:"identifier" yaml:"identifier" jsonschema:"required"`
 	// If the version in release monitor contains a prefix which should be ignored
 	StripPrefix string `json:"strip-prefix,omitempty" yaml:"strip-prefix,omitempty"`
 	// If the version in release monitor contains a suffix which should be ignored
@@ -802,7 +1108,7 @@ type VersionHandler interface {
 // GitHubMonitor indicates using the GitHub API
 type GitHubMonitor struct {
 	// Org/repo for GitHub
-	Identifier string `json:"identifier" yaml:"identifier"`
+	Identifier string `json:"identifier" yaml:"identifier" jsonschema:"required"`
 	// If the version in GitHub contains a prefix which should be ignored
 	StripPrefix string `json:"strip-prefix,omitempty" yaml:"strip-prefix,omitempty"`
 	// If the version in GitHub contains a suffix which should be ignored
@@ -895,9 +1201,9 @@ func (rm *ReleaseMonitor) GetFilterContains() string {
 // VersionTransform allows mapping the package version to an APK version
 type VersionTransform struct {
 	// Required: The regular expression to match against the `package.version` variable
-	Match string `json:"match" yaml:"match"`
+	Match string `json:"match" yaml:"match" jsonschema:"required"`
 	// Required: The repl to replace on all `match` matches
-	Replace string `json:"replace" yaml:"replace"`
+	Replace string `json:"replace" yaml:"replace" jsonschema:"required"`
 }
 
 // Period represents the update check period
@@ -947,9 +1253,15 @@ type Dependencies struct {
 	// determine priority of provides
 	ProviderPriority string `json:"provider-priority,omitempty" yaml:"provider-priority,omitempty"`
 	// Optional: An integer string compared against other equal package provides used to
-	// determine priority of file replacements
+	// determine priority of file replacements. The value "epoch" (or
+	// ${{package.epoch}}) resolves to the package epoch.
 	ReplacesPriority string `json:"replaces-priority,omitempty" yaml:"replaces-priority,omitempty"`
 
+	// ConditionalRuntime are the runtime dependencies that are only emitted
+	// when a condition holds. They are written as mappings among the
+	// runtime dependencies and read apart from them.
+	ConditionalRuntime []ConditionalDependency `json:"-" yaml:"-"`
+
 	// List of self-provided dependencies found outside of lib directories
 	// ("lib", "usr/lib", "lib64", or "usr/lib64").
 	Vendored []string `json:"-" yaml:"-"`
@@ -962,6 +1274,9 @@ type configOptions struct {
 	envFilePath  string
 	varsFilePath string
 	commit       string
+	gitMetadata  *GitMetadata
+
+	inheritBuildRepositories bool
 }
 
 // include reconciles all given opts into the receiver variable, such that it is
@@ -973,20 +1288,32 @@ func (options *configOptions) include(opts ...ConfigurationParsingOption) {
 }
 
 // WithFS sets the fs.FS implementation to use. So far this FS is used only for
-// reading the configuration file. If not provided, the default FS will be an
-// os.DirFS created from the configuration file's containing directory.
+// reading the configuration file and the files its environment includes. If
+// not provided, the default FS will be an os.DirFS created from the
+// configuration file's containing directory.
 func WithFS(filesystem fs.FS) ConfigurationParsingOption {
 	return func(options *configOptions) {
 		options.filesystem = filesystem
 	}
 }
 
+// WithCommit sets the commit of the configuration file, typically the
+// detected git HEAD. It becomes the package's commit, and that of each
+// subpackage, unless the configuration sets one explicitly.
 func WithCommit(hash string) ConfigurationParsingOption {
 	return func(options *configOptions) {
 		options.commit = hash
 	}
 }
 
+// WithGitMetadata sets the values of the ${{git.*}} substitutions, instead
+// of detecting them from the repository containing the configuration file.
+func WithGitMetadata(md GitMetadata) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.gitMetadata = &md
+	}
+}
+
 // WithEnvFileForParsing set the paths from which to read an environment file.
 func WithEnvFileForParsing(path string) ConfigurationParsingOption {
 	return func(options *configOptions) {
@@ -1002,6 +1329,15 @@ func WithVarsFileForParsing(path string) ConfigurationParsingOption {
 	}
 }
 
+// WithInheritBuildRepositories adds the build environment's repositories and
+// keyring to every test environment, so tests can resolve packages from the
+// same repositories used to build. Build-only repositories are not inherited.
+func WithInheritBuildRepositories(inherit bool) ConfigurationParsingOption {
+	return func(options *configOptions) {
+		options.inheritBuildRepositories = inherit
+	}
+}
+
 // propagateChildPipelines performs downward propagation of configuration values.
 func (p *Pipeline) propagateChildPipelines() {
 	for idx := range p.Pipeline {
@@ -1031,12 +1367,45 @@ func (cfg *Configuration) propagatePipelines() {
 	}
 }
 
+// inheritBuildRepositories unions the build environment's repositories and
+// keyring into the package and subpackage test environments.
+func (cfg *Configuration) inheritBuildRepositories() {
+	build := cfg.Environment.Contents
+	inherit := func(t *Test) {
+		if t == nil {
+			return
+		}
+		contents := &t.Environment.Contents
+		contents.Repositories = util.AppendMissing(contents.Repositories, build.Repositories)
+		contents.Keyring = util.AppendMissing(contents.Keyring, build.Keyring)
+	}
+
+	inherit(cfg.Test)
+	for _, sp := range cfg.Subpackages {
+		inherit(sp.Test)
+	}
+}
+
+// packageNotesKey is the key of the notes on the packages of the build
+// environment's contents, which apko's ImageContents does not know.
+const packageNotesKey = "package-notes"
+
 // ParseConfiguration returns a decoded build Configuration using the parsing options provided.
 func ParseConfiguration(ctx context.Context, configurationFilePath string, opts ...ConfigurationParsingOption) (*Configuration, error) {
 	options := &configOptions{}
 	configurationDirPath := filepath.Dir(configurationFilePath)
 	options.include(opts...)
 
+	// The path as given by the caller, used to locate validation errors.
+	displayPath := configurationFilePath
+
+	// The path on disk, used to find the git repository; unknown when the
+	// caller supplies the filesystem.
+	var gitConfigPath string
+	if options.filesystem == nil {
+		gitConfigPath = configurationFilePath
+	}
+
 	if options.filesystem == nil {
 		// TODO: this is an abstraction leak, and we can remove this `if statement` once
 		//  ParseConfiguration relies solely on an abstract fs.FS.
@@ -1055,29 +1424,65 @@ func ParseConfiguration(ctx context.Context, configurationFilePath string, opts
 	}
 	defer f.Close()
 
+	return parseConfiguration(ctx, f, configurationFilePath, displayPath, gitConfigPath, options)
+}
+
+// ParseConfigurationFromReader returns a decoded build Configuration read
+// from r, as ParseConfiguration does for a file. The configuration has no
+// file, so WithFS is ignored, its environment cannot include files, and
+// errors locate problems by line only.
+func ParseConfigurationFromReader(ctx context.Context, r io.Reader, opts ...ConfigurationParsingOption) (*Configuration, error) {
+	options := &configOptions{}
+	options.include(opts...)
+	options.filesystem = noIncludesFS{}
+
+	return parseConfiguration(ctx, r, "", "", "", options)
+}
+
+// parseConfiguration decodes the configuration read from r. name is the
+// path of the configuration in options.filesystem, displayPath the path
+// locating validation errors, and gitConfigPath the path on disk used to
+// find the git repository; all are empty for a configuration read from a
+// stream.
+func parseConfiguration(ctx context.Context, r io.Reader, name, displayPath, gitConfigPath string, options *configOptions) (*Configuration, error) {
+	ctx, warnings := collectWarnings(ctx)
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, decodeError(name, err)
+	}
+
 	root := yaml.Node{}
 
 	cfg := Configuration{root: &root}
 
 	// Unmarshal into a node first
-	decoderNode := yaml.NewDecoder(f)
-	err = decoderNode.Decode(&root)
-	if err != nil {
-		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, decodeError(name, err)
+	}
+	if root.Kind == 0 {
+		// The stream holds no document.
+		return nil, decodeError(name, io.EOF)
 	}
 
-	// XXX(Elizafox) - Node.Decode doesn't allow setting of KnownFields, so we do this cheesy hack below
-	data, err := yaml.Marshal(&root)
+	if err := checkMelangeVersion(&root); err != nil {
+		return nil, decodeError(name, locateInvalid(err, displayPath))
+	}
+
+	env, err := environmentWithIncludes(options.filesystem, name, &root)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+		return nil, decodeError(name, locateInvalid(err, displayPath))
 	}
 
-	// Now unmarshal it into the struct, part of said cheesy hack
-	reader := bytes.NewReader(data)
-	decoder := yaml.NewDecoder(reader)
-	decoder.KnownFields(true)
-	if err := decoder.Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+	// The build environment, which takes melange's extensions to apko's
+	// ImageConfiguration, is decoded on its own.
+	rest, _ := withoutKey(valueNode(&root), "environment")
+	err = decodeStrict(rest, &cfg)
+	if env != nil {
+		err = joinTypeErrors(err, cfg.decodeEnvironment(env))
+	}
+	if err != nil {
+		return nil, decodeError(name, locateInvalid(err, displayPath))
 	}
 
 	// If a variables file was defined, merge it into the variables block.
@@ -1097,8 +1502,40 @@ func ParseConfiguration(ctx context.Context, configurationFilePath string, opts
 		maps.Copy(cfg.Vars, vars)
 	}
 
+	// Resolve git metadata first so it can be used in vars and the version.
+	text := string(data)
+	if env != valueNode(&root, "environment") {
+		// The environment includes files, whose text may use git metadata.
+		included, err := yaml.Marshal(env)
+		if err != nil {
+			return nil, decodeError(name, err)
+		}
+		text += "\n" + string(included)
+	}
+	for _, v := range cfg.Vars {
+		text += "\n" + v
+	}
+	gitMap := gitSubstitutions(ctx, options, gitConfigPath, text)
+	if gitMap != nil {
+		gitReplacer := replacerFromMap(gitMap)
+		for k, v := range cfg.Vars {
+			cfg.Vars[k] = gitReplacer.Replace(v)
+		}
+		cfg.Package.Version = gitReplacer.Replace(cfg.Package.Version)
+	}
+
+	datas := make(map[string]DataItems, len(cfg.Data))
+	for _, d := range cfg.Data {
+		datas[d.Name] = d.Items
+	}
+
+	if err := cfg.expandPipelineRanges(datas); err != nil {
+		return nil, decodeError(name, err)
+	}
+
 	// Mutate config properties with substitutions.
 	configMap := buildConfigMap(&cfg)
+	maps.Copy(configMap, gitMap)
 	if err := cfg.PerformVarSubstitutions(configMap); err != nil {
 		return nil, fmt.Errorf("applying variable substitutions: %w", err)
 	}
@@ -1109,21 +1546,24 @@ func ParseConfiguration(ctx context.Context, configurationFilePath string, opts
 
 	cfg.Pipeline = replacePipelines(replacer, cfg.Pipeline)
 
-	datas := make(map[string]DataItems, len(cfg.Data))
-	for _, d := range cfg.Data {
-		datas[d.Name] = d.Items
-	}
-
 	cfg.Subpackages, err = replaceSubpackages(replacer, datas, cfg, cfg.Subpackages)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode configuration file %q: %w", configurationFilePath, err)
+		return nil, decodeError(name, err)
 	}
 
 	cfg.Environment = replaceImageConfig(replacer, cfg.Environment)
 
+	for i, p := range cfg.ConditionalPackages {
+		cfg.ConditionalPackages[i] = ConditionalPackage{
+			Name: replacer.Replace(p.Name),
+			If:   replacer.Replace(p.If),
+		}
+	}
+
 	cfg.Test = replaceTest(replacer, cfg.Test)
 
-	// Clear Data after expansion - range data is consumed by replaceSubpackages
+	// Clear Data after expansion - range data is consumed by
+	// expandPipelineRanges and replaceSubpackages
 	cfg.Data = nil
 
 	grpName := buildUser
@@ -1171,6 +1611,10 @@ func ParseConfiguration(ctx context.Context, configurationFilePath string, opts
 		}
 	}
 
+	if options.inheritBuildRepositories {
+		cfg.inheritBuildRepositories()
+	}
+
 	// Merge environment file if needed.
 	if envFile := options.envFilePath; envFile != "" {
 		envMap, err := godotenv.Read(envFile)
@@ -1223,12 +1667,48 @@ func ParseConfiguration(ctx context.Context, configurationFilePath string, opts
 
 	// Finally, validate the configuration we ended up with before returning it for use downstream.
 	if err = cfg.validate(ctx); err != nil {
-		return nil, fmt.Errorf("validating configuration %q: %w", cfg.Package.Name, err)
+		return nil, validationError(cfg.Package.Name, locateInvalid(err, displayPath))
 	}
+	cfg.Warnings = warnings.list
 
 	return &cfg, nil
 }
 
+// locateInvalid returns err with each problem it joins wrapped in an
+// ErrInvalidConfiguration located in the file displayPath. Problems that
+// are already wrapped are only located, and a *yaml.TypeError, whose
+// problems carry their own line, is returned as is.
+func locateInvalid(err error, displayPath string) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return err
+	}
+	var errs []error
+	for _, err := range splitErrors(err) {
+		e, ok := err.(ErrInvalidConfiguration)
+		if !ok {
+			e = invalid(err)
+		}
+		if e.Line > 0 {
+			e.File = displayPath
+		}
+		errs = append(errs, e)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// decodeError wraps err, a problem decoding the configuration file name, or
+// the configuration read from a stream if name is empty.
+func decodeError(name string, err error) error {
+	if name == "" {
+		return fmt.Errorf("unable to decode configuration: %w", err)
+	}
+	return fmt.Errorf("unable to decode configuration file %q: %w", name, err)
+}
+
 func (cfg Configuration) Root() *yaml.Node {
 	return cfg.root
 }
@@ -0,0 +1,176 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeStrict decodes node into v, as a decoder with KnownFields set
+// would: a mapping key that is not a field of the struct it is decoded into
+// is an error. Node.Decode has no such option, and encoding the node again
+// to decode it from a stream would lose its position in the file as
+// written. Types decoding themselves with UnmarshalYAML check their own
+// fields.
+//
+// The error, if any, is a *yaml.TypeError listing every problem found.
+func decodeStrict(node *yaml.Node, v any) error {
+	var problems []string
+	if err := node.Decode(v); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		problems = typeErr.Errors
+	}
+	problems = append(problems, unknownFields(node, reflect.TypeOf(v))...)
+	if len(problems) > 0 {
+		return &yaml.TypeError{Errors: problems}
+	}
+	return nil
+}
+
+// joinTypeErrors returns the problems of the *yaml.TypeError among errs in
+// one *yaml.TypeError, ordered by line. Any other error is returned first,
+// as is.
+func joinTypeErrors(errs ...error) error {
+	var problems []string
+	for _, err := range errs {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			problems = append(problems, typeErr.Errors...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(problems, func(a, b string) int {
+		return cmp.Compare(problemLine(a), problemLine(b))
+	})
+	return &yaml.TypeError{Errors: problems}
+}
+
+// problemLine returns the line a problem of a *yaml.TypeError starts with,
+// or zero.
+func problemLine(problem string) int {
+	s, ok := strings.CutPrefix(problem, "line ")
+	if !ok {
+		return 0
+	}
+	s, _, _ = strings.Cut(s, ":")
+	line, _ := strconv.Atoi(s)
+	return line
+}
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// unknownFields returns a problem, worded as yaml.v3 words it, for each
+// mapping key of node that is not a field of the struct of type t it is
+// decoded into.
+func unknownFields(node *yaml.Node, t reflect.Type) []string {
+	if node == nil || t == nil {
+		return nil
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return unknownFields(node.Content[0], t)
+	case yaml.AliasNode:
+		return unknownFields(node.Alias, t)
+	}
+
+	for t.Kind() == reflect.Pointer {
+		if reflect.PointerTo(t).Implements(unmarshalerType) {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	var problems []string
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if node.Kind == yaml.SequenceNode {
+			for _, item := range node.Content {
+				problems = append(problems, unknownFields(item, t.Elem())...)
+			}
+		}
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 1; i < len(node.Content); i += 2 {
+				problems = append(problems, unknownFields(node.Content[i], t.Elem())...)
+			}
+		}
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isMergeKey(key) {
+				merged := []*yaml.Node{value}
+				if value.Kind == yaml.SequenceNode {
+					merged = value.Content
+				}
+				for _, m := range merged {
+					problems = append(problems, unknownFields(m, t)...)
+				}
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, t))
+				continue
+			}
+			problems = append(problems, unknownFields(value, field.typ)...)
+		}
+	}
+	return problems
+}
+
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Value == "<<" && (node.Tag == "" || node.Tag == "!" || node.ShortTag() == "!!merge")
+}
+
+// withoutKey returns a copy of the mapping node with the entry of key
+// removed, and the value of that entry, if any. node is not modified.
+func withoutKey(node *yaml.Node, key string) (rest, value *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			copied := *node
+			copied.Content = slices.Delete(slices.Clone(node.Content), i, i+2)
+			return &copied, node.Content[i+1]
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"gopkg.in/yaml.v3"
+)
+
+// ConditionalPackage is a package of the build environment that is only
+// installed when a condition holds. It is written in
+// environment.contents.packages as a mapping rather than a string:
+//
+//	environment:
+//	  contents:
+//	    packages:
+//	      - build-base
+//	      - name: nasm
+//	        if: ${{build.arch}} == 'x86_64'
+type ConditionalPackage struct {
+	// The package to install, as an entry of environment.contents.packages
+	Name string `json:"name" yaml:"name"`
+	// The condition, in the syntax of a pipeline's if, under which the
+	// package is installed. It is evaluated for each architecture built.
+	If string `json:"if" yaml:"if"`
+}
+
+// environmentContents is the contents of the build environment as written.
+// It is apko's ImageContents, with melange's extensions: build repositories
+// may be spelled build-repositories, packages may be conditional, and the
+// packages may have notes.
+type environmentContents struct {
+	BuildRepositories        []string                        `yaml:"build_repositories,omitempty"`
+	MelangeBuildRepositories []string                        `yaml:"build-repositories,omitempty"`
+	RuntimeOnlyRepositories  []string                        `yaml:"runtime_repositories,omitempty"`
+	Repositories             []string                        `yaml:"repositories,omitempty"`
+	Keyring                  []string                        `yaml:"keyring,omitempty"`
+	Packages                 []environmentPackage            `yaml:"packages,omitempty"`
+	BaseImage                *apko_types.BaseImageDescriptor `yaml:"baseimage,omitempty"`
+	PackageNotes             map[string]string               `yaml:"package-notes,omitempty"`
+}
+
+// environmentPackage is an entry of environment.contents.packages: the name
+// of a package, or a conditional package written as a mapping.
+type environmentPackage struct {
+	name        string
+	conditional *ConditionalPackage
+}
+
+func (p *environmentPackage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return node.Decode(&p.name)
+	}
+	name, cond, err := decodeConditional(node, "conditional package", "install it")
+	if err != nil {
+		return err
+	}
+	p.conditional = &ConditionalPackage{Name: name, If: cond}
+	return nil
+}
+
+// decodeEnvironment decodes the build environment of cfg from node, the
+// environment as written with its includes merged in. Besides apko's
+// ImageConfiguration, it takes the variables to redact from logs, under
+// redact, and contents with melange's extensions.
+func (cfg *Configuration) decodeEnvironment(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	rest, contentsNode := withoutKey(node, "contents")
+	rest, redactNode := withoutKey(rest, "redact")
+	var contents environmentContents
+	err := decodeStrict(rest, &cfg.Environment)
+	if redactNode != nil {
+		err = joinTypeErrors(err, decodeStrict(redactNode, &cfg.RedactEnvironment))
+	}
+	if contentsNode != nil {
+		err = joinTypeErrors(err, decodeStrict(contentsNode, &contents))
+	}
+	if err != nil || contentsNode == nil {
+		return err
+	}
+
+	cfg.Environment.Contents = apko_types.ImageContents{
+		BuildRepositories:       append(contents.BuildRepositories, contents.MelangeBuildRepositories...),
+		RuntimeOnlyRepositories: contents.RuntimeOnlyRepositories,
+		Repositories:            contents.Repositories,
+		Keyring:                 contents.Keyring,
+		BaseImage:               contents.BaseImage,
+	}
+	for _, p := range contents.Packages {
+		if p.conditional != nil {
+			cfg.ConditionalPackages = append(cfg.ConditionalPackages, *p.conditional)
+		} else {
+			cfg.Environment.Contents.Packages = append(cfg.Environment.Contents.Packages, p.name)
+		}
+	}
+	cfg.PackageNotes = contents.PackageNotes
+	return nil
+}
+
+// decodeConditional decodes a conditional entry of a list of packages, what
+// being the kind of entry and unconditional what listing it as a string
+// does, for errors.
+func decodeConditional(node *yaml.Node, what, unconditional string) (name, cond string, err error) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i]; key.Value != "name" && key.Value != "if" {
+			return "", "", errorAt(key, fmt.Errorf("field %s not found in a %s", key.Value, what))
+		}
+	}
+
+	var entry struct {
+		Name string `yaml:"name"`
+		If   string `yaml:"if"`
+	}
+	if err := node.Decode(&entry); err != nil {
+		return "", "", errorAt(node, err)
+	}
+	if entry.Name == "" {
+		return "", "", errorAt(node, fmt.Errorf("%s must have a name", what))
+	}
+	if entry.If == "" {
+		return "", "", errorAt(node, fmt.Errorf("%s %q must have an if; list it as a string to always %s", what, entry.Name, unconditional))
+	}
+	return entry.Name, entry.If, nil
+}
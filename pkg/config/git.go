@@ -0,0 +1,123 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// shortCommitLength is the length of ${{git.short-commit}}, matching the
+// default abbreviation used by git.
+const shortCommitLength = 7
+
+// GitMetadata describes the git checkout containing a configuration file.
+type GitMetadata struct {
+	// Commit is the full hash of HEAD.
+	Commit string
+	// Tag is a tag pointing at HEAD, or empty if there is none. If several
+	// tags point at HEAD, the lexically greatest is used.
+	Tag string
+}
+
+// ShortCommit returns the abbreviated hash of HEAD.
+func (g GitMetadata) ShortCommit() string {
+	if len(g.Commit) > shortCommitLength {
+		return g.Commit[:shortCommitLength]
+	}
+	return g.Commit
+}
+
+// DetectGitMetadata reads the state of the git repository containing the
+// configuration file at configFilePath.
+func DetectGitMetadata(ctx context.Context, configFilePath string) (GitMetadata, error) {
+	repoDir := filepath.Dir(configFilePath)
+	clog.FromContext(ctx).Debugf("detecting git state from %q", repoDir)
+
+	repo, err := git.PlainOpenWithOptions(repoDir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return GitMetadata{}, fmt.Errorf("opening git repository: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return GitMetadata{}, fmt.Errorf("determining HEAD: %w", err)
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return GitMetadata{}, fmt.Errorf("listing tags: %w", err)
+	}
+	var names []string
+	if err := tags.ForEach(func(ref *plumbing.Reference) error {
+		hash := ref.Hash()
+		// Annotated tags point at a tag object rather than the commit.
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		if hash == head.Hash() {
+			names = append(names, ref.Name().Short())
+		}
+		return nil
+	}); err != nil {
+		return GitMetadata{}, fmt.Errorf("listing tags: %w", err)
+	}
+
+	md := GitMetadata{Commit: head.Hash().String()}
+	if len(names) > 0 {
+		md.Tag = slices.Max(names)
+	}
+	return md, nil
+}
+
+// gitSubstitutions returns the ${{git.*}} substitutions for a configuration.
+// Metadata is only detected when text references it; if it cannot be
+// determined, the substitutions are empty and a warning is logged.
+func gitSubstitutions(ctx context.Context, options *configOptions, configFilePath, text string) map[string]string {
+	if !strings.Contains(text, "${{git.") {
+		return nil
+	}
+
+	var md GitMetadata
+	switch {
+	case options.gitMetadata != nil:
+		md = *options.gitMetadata
+	case configFilePath == "":
+		warn(ctx, WarningGit, "git metadata is unavailable when the configuration is not parsed from a file on disk; ${{git.*}} variables will be empty")
+	default:
+		detected, err := DetectGitMetadata(ctx, configFilePath)
+		if err != nil {
+			warn(ctx, WarningGit, "unable to detect git metadata for %s, ${{git.*}} variables will be empty: %v", configFilePath, err)
+		}
+		md = detected
+	}
+
+	if md.Commit != "" && md.Tag == "" && strings.Contains(text, SubstitutionGitTag) {
+		warn(ctx, WarningGit, "no tag points at HEAD, %s will be empty", SubstitutionGitTag)
+	}
+
+	return map[string]string{
+		SubstitutionGitCommit:      md.Commit,
+		SubstitutionGitShortCommit: md.ShortCommit(),
+		SubstitutionGitTag:         md.Tag,
+	}
+}
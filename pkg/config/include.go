@@ -0,0 +1,184 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeTag tags a scalar naming a YAML file to include in the build
+// environment, as in:
+//
+//	environment:
+//	  <<: !include shared/environment.yaml
+//	  contents:
+//	    packages:
+//	      - build-base
+//
+// The path is resolved relative to the including file, in the filesystem
+// the configuration is parsed from, and must stay within the directory of
+// the configuration: "../shared/environment.yaml" is rejected.
+const includeTag = "!include"
+
+// noIncludesFS is the filesystem of a configuration read from a stream,
+// which has no directory to include files from.
+type noIncludesFS struct{}
+
+func (noIncludesFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("includes are not supported when the configuration is not read from a file")}
+}
+
+// environmentWithIncludes returns the build environment of the
+// configuration in root, the file name of fsys, with the files it includes
+// merged in, or nil if there is no environment. root is not modified.
+//
+// The environment may include files with "<<", naming one file or a list of
+// them, or be an include itself. Included files may include others. Values
+// of the including file override those of the files it includes, and those
+// of a file override those of the files before it in a list.
+func environmentWithIncludes(fsys fs.FS, name string, root *yaml.Node) (*yaml.Node, error) {
+	env := valueNode(root, "environment")
+	if env == nil {
+		return nil, nil
+	}
+	return resolveIncludes(fsys, name, env, []string{path.Clean(name)})
+}
+
+// resolveIncludes returns node, from the file name of fsys, with the files
+// it includes merged in. stack holds the files being included, outermost
+// first, to detect cycles.
+func resolveIncludes(fsys fs.FS, name string, node *yaml.Node, stack []string) (*yaml.Node, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == includeTag {
+		return includeFile(fsys, name, node, stack)
+	}
+	if node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "<<" {
+			continue
+		}
+		refs := []*yaml.Node{node.Content[i+1]}
+		if refs[0].Kind == yaml.SequenceNode {
+			refs = refs[0].Content
+		}
+		if !slices.ContainsFunc(refs, isInclude) {
+			// An ordinary YAML merge, which the decoder resolves.
+			continue
+		}
+
+		merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, ref := range refs {
+			if !isInclude(ref) {
+				return nil, positionIn(name, stack, ref, errors.New("an include must not be mixed with other values to merge"))
+			}
+			included, err := includeFile(fsys, name, ref, stack)
+			if err != nil {
+				return nil, err
+			}
+			merged = mergeNodes(merged, included)
+		}
+
+		local := *node
+		local.Content = slices.Delete(slices.Clone(node.Content), i, i+2)
+		return mergeNodes(merged, &local), nil
+	}
+	return node, nil
+}
+
+// includeFile reads the file that ref, in the file name of fsys, includes,
+// and resolves the includes of that file in turn.
+func includeFile(fsys fs.FS, name string, ref *yaml.Node, stack []string) (*yaml.Node, error) {
+	if path.IsAbs(ref.Value) {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("include %q must be a relative path", ref.Value))
+	}
+	target := path.Join(path.Dir(name), ref.Value)
+	if !fs.ValidPath(target) {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("include %q is outside of the configuration directory", ref.Value))
+	}
+	if slices.Contains(stack, target) {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(stack), target), " -> ")))
+	}
+
+	data, err := fs.ReadFile(fsys, target)
+	if err != nil {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("reading include: %w", err))
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("decoding include %s: %w", target, err))
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	fragment := doc.Content[0]
+	if fragment.Kind != yaml.MappingNode {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("include %s must be a mapping", target))
+	}
+
+	resolved, err := resolveIncludes(fsys, target, fragment, append(stack, target))
+	if err != nil {
+		return nil, positionIn(name, stack, ref, fmt.Errorf("including %s: %w", target, err))
+	}
+	return resolved, nil
+}
+
+// positionIn locates err at node of the file name. Positions in the
+// configuration itself, the first file of stack, are kept on the error as
+// errorAt does; those in included files are written into the message, since
+// the error is reported against the configuration.
+func positionIn(name string, stack []string, node *yaml.Node, err error) error {
+	if len(stack) == 1 {
+		return errorAt(node, err)
+	}
+	return fmt.Errorf("%s:%d:%d: %w", name, node.Line, node.Column, err)
+}
+
+func isInclude(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == includeTag
+}
+
+// mergeNodes returns the merge of over onto base. Mappings are merged key by
+// key, with the values of over taking precedence; any other value of over
+// replaces that of base. Neither node is modified.
+func mergeNodes(base, over *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+
+	merged := *over
+	merged.Content = nil
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		key, value := base.Content[i], base.Content[i+1]
+		if k := mappingKey(over, key.Value); k != nil {
+			key, value = k.key, mergeNodes(value, k.value)
+		}
+		merged.Content = append(merged.Content, key, value)
+	}
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		if mappingKey(base, over.Content[i].Value) == nil {
+			merged.Content = append(merged.Content, over.Content[i], over.Content[i+1])
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/release-utils/version"
+)
+
+// melangeVersion returns the version of the running melange.
+var melangeVersion = func() string {
+	return version.GetVersionInfo().GitVersion
+}
+
+// versionClause is one comparison of a version constraint, as in ">= 1.2".
+type versionClause struct {
+	op      string
+	version string
+}
+
+func (c versionClause) String() string {
+	return c.op + " " + c.version
+}
+
+// versionOps are the comparison operators of a version constraint, longest
+// first so that ">=" is not read as ">".
+var versionOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// parseVersionConstraint parses a constraint on a semantic version: one or
+// more comma-separated clauses such as ">= 0.30.0, < 1". A version without
+// an operator is a minimum, as if it were preceded by ">=".
+func parseVersionConstraint(constraint string) ([]versionClause, error) {
+	var clauses []versionClause
+	for _, part := range strings.Split(constraint, ",") {
+		part = strings.TrimSpace(part)
+		c := versionClause{op: ">="}
+		for _, op := range versionOps {
+			if rest, ok := strings.CutPrefix(part, op); ok {
+				c.op, part = op, strings.TrimSpace(rest)
+				break
+			}
+		}
+		c.version = strings.TrimPrefix(part, "v")
+		if !semver.IsValid("v" + c.version) {
+			return nil, fmt.Errorf("%q is not a semantic version", part)
+		}
+		clauses = append(clauses, c)
+	}
+	return clauses, nil
+}
+
+// satisfiedBy reports whether the semantic version v, with a leading "v",
+// satisfies the clause.
+func (c versionClause) satisfiedBy(v string) bool {
+	cmp := semver.Compare(v, "v"+c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp == 0
+}
+
+// gitDescribeSuffix matches what git describe appends to the tag of a
+// commit after it, as in "v0.30.0-5-g0123abc".
+var gitDescribeSuffix = regexp.MustCompile(`-\d+-g[0-9a-f]+(-dirty)?$`)
+
+// checkMelangeVersion checks that the running melange satisfies the
+// needs-melange-version of the package in root, if it has one. It runs
+// before the configuration is decoded, so that a configuration using fields
+// this melange does not know fails with the version it needs.
+//
+// Development builds, whose version is not a semantic version, satisfy any
+// constraint.
+func checkMelangeVersion(root *yaml.Node) error {
+	node := valueNode(root, "package", "needs-melange-version")
+	if node == nil {
+		return nil
+	}
+	if node.Kind != yaml.ScalarNode {
+		return errorAt(node, fmt.Errorf("needs-melange-version must be a version constraint"))
+	}
+	clauses, err := parseVersionConstraint(node.Value)
+	if err != nil {
+		return errorAt(node, fmt.Errorf("invalid needs-melange-version %q: %w", node.Value, err))
+	}
+
+	have := melangeVersion()
+	v := "v" + strings.TrimPrefix(gitDescribeSuffix.ReplaceAllString(have, ""), "v")
+	if !semver.IsValid(v) {
+		return nil
+	}
+	for _, c := range clauses {
+		if !c.satisfiedBy(v) {
+			return errorAt(node, fmt.Errorf("config requires melange %s, have %s", c, have))
+		}
+	}
+	return nil
+}
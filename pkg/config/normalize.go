@@ -0,0 +1,133 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dlorenc/melange2/pkg/util"
+)
+
+// NormalizePipelines collapses pipelines whose only content is a single
+// nested pipeline running in the same working directory and environment
+// into one level, and returns the number of levels removed.
+//
+// The collapsed pipeline keeps the conditions of both levels (joined with
+// &&), the union of their needs, and whichever name was set. Pipelines are
+// only collapsed when that preserves behavior: wrappers that use a named
+// pipeline, pass inputs, carry a label or assertions, or that would lose a
+// name are left alone.
+//
+// This changes the structure of build logs, so it is not applied by default.
+func (cfg *Configuration) NormalizePipelines() int {
+	n := normalizePipelines(cfg.Pipeline)
+	if cfg.Test != nil {
+		n += normalizePipelines(cfg.Test.Pipeline)
+	}
+	for i := range cfg.Subpackages {
+		sp := &cfg.Subpackages[i]
+		n += normalizePipelines(sp.Pipeline)
+		if sp.Test != nil {
+			n += normalizePipelines(sp.Test.Pipeline)
+		}
+	}
+	return n
+}
+
+// normalizePipelines normalizes each pipeline in place, innermost first.
+func normalizePipelines(pipelines []Pipeline) int {
+	n := 0
+	for i := range pipelines {
+		p := &pipelines[i]
+		n += normalizePipelines(p.Pipeline)
+		for canCollapse(p) {
+			collapse(p)
+			n++
+		}
+	}
+	return n
+}
+
+// canCollapse reports whether p is a plain wrapper around a single child
+// that can absorb it without changing what runs or where.
+func canCollapse(p *Pipeline) bool {
+	if len(p.Pipeline) != 1 {
+		return false
+	}
+	if p.Uses != "" || p.Runs != "" || len(p.With) > 0 || len(p.Inputs) > 0 ||
+		p.Label != "" || p.Assertions != nil {
+		return false
+	}
+
+	child := &p.Pipeline[0]
+	if p.Name != "" && child.Name != "" {
+		return false
+	}
+	if p.WorkDir != "" && child.WorkDir != "" && p.WorkDir != child.WorkDir {
+		return false
+	}
+	// Children inherit the parent's environment, so the child must already
+	// agree with every variable the parent sets.
+	for k, v := range p.Environment {
+		if cv, ok := child.Environment[k]; ok && cv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// collapse replaces p with its only child, folding in p's name, condition,
+// needs, working directory and environment.
+func collapse(p *Pipeline) {
+	parent := *p
+	child := parent.Pipeline[0]
+
+	if child.Name == "" {
+		child.Name = parent.Name
+	}
+
+	switch {
+	case parent.If == "":
+	case child.If == "":
+		child.If = parent.If
+	default:
+		child.If = fmt.Sprintf("(%s) && (%s)", parent.If, child.If)
+	}
+
+	if parent.Needs != nil {
+		needs := &Needs{Packages: slices.Clone(parent.Needs.Packages)}
+		if child.Needs != nil {
+			needs.Packages = util.AppendMissing(needs.Packages, child.Needs.Packages)
+		}
+		child.Needs = needs
+	}
+
+	if child.WorkDir == "" {
+		child.WorkDir = parent.WorkDir
+	}
+
+	for k, v := range parent.Environment {
+		if _, ok := child.Environment[k]; ok {
+			continue
+		}
+		if child.Environment == nil {
+			child.Environment = map[string]string{}
+		}
+		child.Environment[k] = v
+	}
+
+	*p = child
+}
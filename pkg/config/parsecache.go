@@ -0,0 +1,222 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+
+	"github.com/psanford/memfs"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseCache caches configurations parsed from bytes, so that parsing the
+// same bytes again with the same options, as the build service does when it
+// retries a package, reuses the parsed configuration. The configurations it
+// holds are never handed out: each parse returns a deep copy, which the
+// caller is free to modify.
+//
+// A ParseCache is safe for concurrent use. A nil ParseCache parses without
+// caching.
+type ParseCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*Configuration
+	// order holds the keys of entries, oldest first.
+	order []string
+}
+
+// NewParseCache returns a cache holding at most size configurations,
+// evicting the oldest first.
+func NewParseCache(size int) *ParseCache {
+	return &ParseCache{
+		size:    size,
+		entries: make(map[string]*Configuration, size),
+	}
+}
+
+// Parse parses the configuration in data as ParseConfiguration does, with
+// name naming it in errors. A WithFS option is ignored.
+func (c *ParseCache) Parse(ctx context.Context, name string, data []byte, opts ...ConfigurationParsingOption) (*Configuration, error) {
+	if c == nil {
+		return parseData(ctx, name, data, opts...)
+	}
+
+	options := &configOptions{}
+	options.include(opts...)
+
+	key, err := parseCacheKey(data, options)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return cached.deepCopy(), nil
+	}
+
+	cfg, err := parseData(ctx, name, data, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(key, cfg.deepCopy())
+	return cfg, nil
+}
+
+// parseData parses the configuration in data as a file named name.
+func parseData(ctx context.Context, name string, data []byte, opts ...ConfigurationParsingOption) (*Configuration, error) {
+	fsys := memfs.New()
+	if err := fsys.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	if err := fsys.WriteFile(name, data, 0o644); err != nil {
+		return nil, err
+	}
+	return ParseConfiguration(ctx, name, append(opts, WithFS(fsys))...)
+}
+
+func (c *ParseCache) add(key string, cfg *Configuration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok || c.size <= 0 {
+		return
+	}
+	if len(c.order) >= c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = cfg
+	c.order = append(c.order, key)
+}
+
+// parseCacheKey returns the digest of data and of everything else the
+// result of parsing it with options depends on, including the contents of
+// the environment and variables files.
+func parseCacheKey(data []byte, options *configOptions) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n", len(data), data)
+	fmt.Fprintf(h, "commit=%q\ninherit=%t\n", options.commit, options.inheritBuildRepositories)
+	if md := options.gitMetadata; md != nil {
+		fmt.Fprintf(h, "git=%+v\n", *md)
+	}
+	for _, file := range []string{options.envFilePath, options.varsFilePath} {
+		if file == "" {
+			fmt.Fprintln(h, "file=")
+			continue
+		}
+		b, err := os.ReadFile(file) // #nosec G304 - User-specified environment or variables file
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", file, err)
+		}
+		fmt.Fprintf(h, "file=%d\n%s\n", len(b), b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// deepCopy returns a copy of cfg that shares no memory with it.
+func (cfg *Configuration) deepCopy() *Configuration {
+	copies := map[copiedPointer]reflect.Value{}
+	c := deepCopyValue(reflect.ValueOf(cfg), copies).Interface().(*Configuration)
+	// The retained YAML is unexported, and so not copied above.
+	if cfg.root != nil {
+		c.root = deepCopyValue(reflect.ValueOf(cfg.root), copies).Interface().(*yaml.Node)
+	}
+	return c
+}
+
+// copiedPointer identifies a pointer copied by deepCopyValue. The type is
+// part of it, as a pointer to a struct and to its first field are equal.
+type copiedPointer struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// deepCopyValue copies v, and whatever it refers to, through its exported
+// fields. Unexported fields are copied by value. Pointers already copied,
+// found in copies, are not copied again, so that shared structure stays
+// shared.
+func deepCopyValue(v reflect.Value, copies map[copiedPointer]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedPointer{v.Pointer(), v.Type()}
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copies[key] = c
+		c.Elem().Set(deepCopyValue(v.Elem(), copies))
+		return c
+
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := range v.NumField() {
+			if f := c.Field(i); f.CanSet() {
+				f.Set(deepCopyValue(v.Field(i), copies))
+			}
+		}
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopyValue(v.Index(i), copies))
+		}
+		return c
+
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopyValue(v.Index(i), copies))
+		}
+		return c
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopyValue(iter.Value(), copies))
+		}
+		return c
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopyValue(v.Elem(), copies))
+		return c
+	}
+	return v
+}
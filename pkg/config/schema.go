@@ -0,0 +1,222 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/invopop/jsonschema"
+)
+
+//go:generate go run ../../internal/gen-jsonschema -o schema.json
+
+// schemaJSON is the JSON schema generated from Configuration, including the
+// field descriptions taken from the doc comments in this package.
+//
+//go:embed schema.json
+var schemaJSON []byte
+
+// Schema returns the JSON schema describing melange configuration files.
+func Schema() []byte {
+	return bytes.Clone(schemaJSON)
+}
+
+// GenerateSchema reflects the JSON schema for Configuration. If sourceDir is
+// set, it is the directory holding this package's source, from which field
+// descriptions are read.
+func GenerateSchema(sourceDir string) ([]byte, error) {
+	r := &jsonschema.Reflector{
+		// Configurations are written in YAML.
+		FieldNameTag: "yaml",
+		// Most fields may be omitted; only those tagged as required are.
+		RequiredFromJSONSchemaTags: true,
+		Mapper:                     schemaMapper,
+	}
+	if sourceDir != "" {
+		if err := r.AddGoComments("github.com/dlorenc/melange2/pkg/config", sourceDir); err != nil {
+			return nil, err
+		}
+	}
+
+	schema := r.Reflect(Configuration{})
+	describeBuildEnvironment(schema)
+	describeConditionalDependencies(schema)
+	allowYAMLScalars(schema)
+
+	b := new(bytes.Buffer)
+	enc := json.NewEncoder(b)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// schemaMapper describes types whose YAML form differs from their Go kind.
+func schemaMapper(t reflect.Type) *jsonschema.Schema {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		// Durations are written as strings such as "2h", or as nanoseconds.
+		return &jsonschema.Schema{
+			OneOf: []*jsonschema.Schema{{Type: "string"}, {Type: "integer"}},
+		}
+	}
+	return nil
+}
+
+// describeBuildEnvironment gives the build environment a schema of its
+// own: apko's ImageConfiguration, with the variables to redact from logs
+// and contents taking melange's extensions, as decodeEnvironment decodes
+// them. Test environments, which take none, keep apko's schema.
+func describeBuildEnvironment(s *jsonschema.Schema) {
+	cfg, ok := s.Definitions["Configuration"]
+	if !ok || cfg.Properties == nil {
+		return
+	}
+	image, ok := s.Definitions["ImageConfiguration"]
+	if !ok || image.Properties == nil {
+		return
+	}
+	contents, ok := s.Definitions["ImageContents"]
+	if !ok || contents.Properties == nil {
+		return
+	}
+
+	buildContents := cloneSchema(contents)
+	if repos, ok := contents.Properties.Get("build_repositories"); ok {
+		buildContents.Properties.Set("build-repositories", cloneSchema(repos))
+	}
+	if packages, ok := contents.Properties.Get("packages"); ok && packages.Items != nil {
+		packages = cloneSchema(packages)
+		allowConditionalItems(packages,
+			"The package to install",
+			"The condition, in the syntax of a pipeline's if, under which the package is installed.")
+		buildContents.Properties.Set("packages", packages)
+	}
+	buildContents.Properties.Set(packageNotesKey, &jsonschema.Schema{
+		Type:                 "object",
+		AdditionalProperties: &jsonschema.Schema{Type: "string"},
+		Description:          "Why each package is installed, keyed by package name. Notes are recorded in the dependency log and do not affect the build.",
+	})
+	s.Definitions["BuildEnvironmentContents"] = buildContents
+
+	build := cloneSchema(image)
+	if c, ok := image.Properties.Get("contents"); ok {
+		c = cloneSchema(c)
+		c.Ref = "#/$defs/BuildEnvironmentContents"
+		build.Properties.Set("contents", c)
+	}
+	build.Properties.Set("redact", &jsonschema.Schema{
+		Type:        "array",
+		Items:       &jsonschema.Schema{Type: "string"},
+		Description: "Names of environment variables whose values are replaced with \"***\" in build and test logs. Variables with secret-like names, such as GITHUB_TOKEN, are masked even when not listed.",
+	})
+	s.Definitions["BuildEnvironment"] = build
+
+	if env, ok := cfg.Properties.Get("environment"); ok {
+		env.Ref = "#/$defs/BuildEnvironment"
+	}
+}
+
+// cloneSchema returns a copy of s whose properties can be changed without
+// changing those of s.
+func cloneSchema(s *jsonschema.Schema) *jsonschema.Schema {
+	c := *s
+	if s.Properties != nil {
+		c.Properties = jsonschema.NewProperties()
+		for p := s.Properties.Oldest(); p != nil; p = p.Next() {
+			c.Properties.Set(p.Key, p.Value)
+		}
+	}
+	return &c
+}
+
+// describeConditionalDependencies allows the conditional runtime
+// dependencies, which are read apart from the rest, in the runtime
+// dependencies.
+func describeConditionalDependencies(s *jsonschema.Schema) {
+	deps, ok := s.Definitions["Dependencies"]
+	if !ok || deps.Properties == nil {
+		return
+	}
+	runtime, ok := deps.Properties.Get("runtime")
+	if !ok || runtime.Items == nil {
+		return
+	}
+	allowConditionalItems(runtime,
+		"The package to depend on",
+		"The condition, in the syntax of a pipeline's if, under which the dependency is emitted. ${{subpackages.<name>.enabled}} tests whether a subpackage is built.")
+}
+
+// allowConditionalItems allows the items of list to also be a mapping of a
+// name and the condition under which it applies.
+func allowConditionalItems(list *jsonschema.Schema, name, cond string) {
+	props := jsonschema.NewProperties()
+	props.Set("name", &jsonschema.Schema{Type: "string", Description: name})
+	props.Set("if", &jsonschema.Schema{Type: "string", Description: cond})
+	list.Items = &jsonschema.Schema{
+		OneOf: []*jsonschema.Schema{list.Items, {
+			Type:                 "object",
+			Properties:           props,
+			Required:             []string{"name", "if"},
+			AdditionalProperties: jsonschema.FalseSchema,
+		}},
+	}
+}
+
+// allowYAMLScalars loosens the types in s to what the YAML decoder accepts:
+// any field may be left empty (null), and unquoted numbers and booleans
+// decode into strings, as in "version: 1.2".
+func allowYAMLScalars(s *jsonschema.Schema) {
+	if s == nil {
+		return
+	}
+
+	switch s.Type {
+	case "":
+	case "string":
+		setTypes(s, "string", "number", "boolean", "null")
+	default:
+		setTypes(s, s.Type, "null")
+	}
+
+	for _, d := range s.Definitions {
+		allowYAMLScalars(d)
+	}
+	if s.Properties != nil {
+		for p := s.Properties.Oldest(); p != nil; p = p.Next() {
+			allowYAMLScalars(p.Value)
+		}
+	}
+	allowYAMLScalars(s.Items)
+	allowYAMLScalars(s.AdditionalProperties)
+	for _, sub := range slices.Concat(s.AllOf, s.AnyOf, s.OneOf) {
+		allowYAMLScalars(sub)
+	}
+}
+
+// setTypes replaces the single type of s with a list of types, which the
+// Schema type cannot otherwise express.
+func setTypes(s *jsonschema.Schema, types ...string) {
+	s.Type = ""
+	if s.Extras == nil {
+		s.Extras = map[string]any{}
+	}
+	s.Extras["type"] = types
+}
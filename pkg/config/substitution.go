@@ -134,6 +134,7 @@ func replacePipeline(r *strings.Replacer, in Pipeline) Pipeline {
 		Assertions:  in.Assertions,
 		WorkDir:     r.Replace(in.WorkDir),
 		Environment: replaceMap(r, in.Environment),
+		Range:       in.Range,
 	}
 }
 
@@ -149,14 +150,93 @@ func replacePipelines(r *strings.Replacer, in []Pipeline) []Pipeline {
 	return out
 }
 
+// expandPipelineRanges replaces each pipeline of in that sets range by a copy
+// of it for each item of the data it names, in key order, with
+// ${{range.key}} and ${{range.value}} substituted. The copies run in
+// sequence, in place of the pipeline. Nested pipelines are expanded first,
+// so that a range refers to the innermost ranged pipeline. path locates in
+// in errors.
+func expandPipelineRanges(datas map[string]DataItems, path string, in []Pipeline) ([]Pipeline, error) {
+	if in == nil {
+		return nil, nil
+	}
+
+	out := make([]Pipeline, 0, len(in))
+	for i, p := range in {
+		var err error
+		p.Pipeline, err = expandPipelineRanges(datas, fmt.Sprintf("%s[%d].pipeline", path, i), p.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Range == "" {
+			out = append(out, p)
+			continue
+		}
+
+		items, ok := datas[p.Range]
+		if !ok {
+			return nil, fmt.Errorf("%s[%d] specified undefined range: %q", path, i, p.Range)
+		}
+
+		// Ensure iterating over items is deterministic by sorting keys alphabetically
+		keys := make([]string, 0, len(items))
+		for k := range items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			r := strings.NewReplacer("${{range.key}}", k, "${{range.value}}", items[k])
+			expanded := replacePipeline(r, p)
+			expanded.Range = ""
+			out = append(out, expanded)
+		}
+	}
+	return out, nil
+}
+
+// expandPipelineRanges expands the ranged pipelines of the package, its
+// subpackages and their tests.
+func (cfg *Configuration) expandPipelineRanges(datas map[string]DataItems) error {
+	var err error
+	if cfg.Pipeline, err = expandPipelineRanges(datas, "pipeline", cfg.Pipeline); err != nil {
+		return err
+	}
+	if cfg.Test != nil {
+		if cfg.Test.Pipeline, err = expandPipelineRanges(datas, "test.pipeline", cfg.Test.Pipeline); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Subpackages {
+		sp := &cfg.Subpackages[i]
+		if sp.Pipeline, err = expandPipelineRanges(datas, fmt.Sprintf("subpackages[%d].pipeline", i), sp.Pipeline); err != nil {
+			return err
+		}
+		if sp.Test != nil {
+			if sp.Test.Pipeline, err = expandPipelineRanges(datas, fmt.Sprintf("subpackages[%d].test.pipeline", i), sp.Test.Pipeline); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func replaceTest(r *strings.Replacer, in *Test) *Test {
 	if in == nil {
 		return nil
 	}
-	return &Test{
+	out := &Test{
 		Environment: replaceImageConfig(r, in.Environment),
 		Pipeline:    replacePipelines(r, in.Pipeline),
 	}
+	if in.Matrix != nil {
+		out.Matrix = &TestMatrix{}
+		for _, image := range in.Matrix.BaseImages {
+			out.Matrix.BaseImages = append(out.Matrix.BaseImages, r.Replace(image))
+		}
+	}
+	return out
 }
 
 func replaceScriptlets(r *strings.Replacer, in *Scriptlets) *Scriptlets {
@@ -178,60 +258,121 @@ func replaceScriptlets(r *strings.Replacer, in *Scriptlets) *Scriptlets {
 	}
 }
 
-// replaceCommit defaults to value of in parameter unless commit is explicitly specified.
-func replaceCommit(commit string, in string) string {
+func replacePackageSBOM(r *strings.Replacer, in *PackageSBOM) *PackageSBOM {
+	if in == nil {
+		return nil
+	}
+
+	out := &PackageSBOM{}
+	for _, ep := range in.ExtraPackages {
+		out.ExtraPackages = append(out.ExtraPackages, SBOMExtraPackage{
+			Name:             r.Replace(ep.Name),
+			Version:          r.Replace(ep.Version),
+			License:          r.Replace(ep.License),
+			PURL:             r.Replace(ep.PURL),
+			DownloadLocation: r.Replace(ep.DownloadLocation),
+		})
+	}
+	for _, p := range in.Patches {
+		out.Patches = append(out.Patches, SBOMPatch{
+			Path:        r.Replace(p.Path),
+			Description: r.Replace(p.Description),
+		})
+	}
+	out.DetectPatches = in.DetectPatches
+	return out
+}
+
+func replaceChangelog(r *strings.Replacer, in []ChangelogEntry) []ChangelogEntry {
+	if in == nil {
+		return nil
+	}
+
+	out := make([]ChangelogEntry, 0, len(in))
+	for _, e := range in {
+		out = append(out, ChangelogEntry{
+			Version: r.Replace(e.Version),
+			Date:    r.Replace(e.Date),
+			Entries: replaceAll(r, e.Entries),
+		})
+	}
+	return out
+}
+
+// replaceCommit returns the explicitly configured commit in, with
+// substitutions applied, or the detected commit if none is configured.
+func replaceCommit(r *strings.Replacer, commit string, in string) string {
 	if in == "" {
 		return commit
 	}
-	return in
+	return r.Replace(in)
 }
 
 func replaceDependencies(r *strings.Replacer, in Dependencies) Dependencies {
 	return Dependencies{
-		Runtime:          replaceAll(r, in.Runtime),
-		Provides:         replaceAll(r, in.Provides),
-		Replaces:         replaceAll(r, in.Replaces),
-		ProviderPriority: r.Replace(in.ProviderPriority),
-		ReplacesPriority: r.Replace(in.ReplacesPriority),
+		Runtime:            replaceAll(r, in.Runtime),
+		Provides:           replaceAll(r, in.Provides),
+		Replaces:           replaceAll(r, in.Replaces),
+		ProviderPriority:   r.Replace(in.ProviderPriority),
+		ReplacesPriority:   r.Replace(in.ReplacesPriority),
+		ConditionalRuntime: replaceConditionalDependencies(r, in.ConditionalRuntime),
 	}
 }
 
+func replaceConditionalDependencies(r *strings.Replacer, in []ConditionalDependency) []ConditionalDependency {
+	if in == nil {
+		return nil
+	}
+	out := make([]ConditionalDependency, 0, len(in))
+	for _, d := range in {
+		out = append(out, ConditionalDependency{
+			Name: r.Replace(d.Name),
+			If:   r.Replace(d.If),
+		})
+	}
+	return out
+}
+
 func replacePackage(r *strings.Replacer, commit string, in Package) Package {
 	return Package{
-		Name:               r.Replace(in.Name),
-		Version:            r.Replace(in.Version),
-		Epoch:              in.Epoch,
-		Description:        r.Replace(in.Description),
-		Annotations:        replaceMap(r, in.Annotations),
-		URL:                r.Replace(in.URL),
-		Commit:             replaceCommit(commit, in.Commit),
-		TargetArchitecture: replaceAll(r, in.TargetArchitecture),
-		Copyright:          in.Copyright,
-		Dependencies:       replaceDependencies(r, in.Dependencies),
-		Options:            in.Options,
-		Scriptlets:         replaceScriptlets(r, in.Scriptlets),
-		Checks:             in.Checks,
-		CPE:                in.CPE,
-		Timeout:            in.Timeout,
-		Resources:          in.Resources,
-		TestResources:      in.TestResources,
-		SetCap:             in.SetCap,
+		Name:                r.Replace(in.Name),
+		Version:             r.Replace(in.Version),
+		Epoch:               in.Epoch,
+		Description:         r.Replace(in.Description),
+		Annotations:         replaceMap(r, in.Annotations),
+		URL:                 r.Replace(in.URL),
+		Commit:              replaceCommit(r, commit, in.Commit),
+		TargetArchitecture:  replaceAll(r, in.TargetArchitecture),
+		Copyright:           in.Copyright,
+		Dependencies:        replaceDependencies(r, in.Dependencies),
+		Options:             in.Options,
+		Scriptlets:          replaceScriptlets(r, in.Scriptlets),
+		Checks:              in.Checks,
+		CPE:                 in.CPE,
+		Timeout:             in.Timeout,
+		Resources:           in.Resources,
+		TestResources:       in.TestResources,
+		SetCap:              in.SetCap,
+		SBOM:                replacePackageSBOM(r, in.SBOM),
+		Changelog:           replaceChangelog(r, in.Changelog),
+		NeedsMelangeVersion: in.NeedsMelangeVersion,
 	}
 }
 
 func replaceSubpackage(r *strings.Replacer, detectedCommit string, in Subpackage) Subpackage {
 	return Subpackage{
-		If:           r.Replace(in.If),
-		Name:         r.Replace(in.Name),
-		Pipeline:     replacePipelines(r, in.Pipeline),
-		Dependencies: replaceDependencies(r, in.Dependencies),
-		Options:      in.Options,
-		Scriptlets:   replaceScriptlets(r, in.Scriptlets),
-		Description:  r.Replace(in.Description),
-		URL:          r.Replace(in.URL),
-		Commit:       replaceCommit(detectedCommit, in.Commit),
-		Checks:       in.Checks,
-		Test:         replaceTest(r, in.Test),
+		If:            r.Replace(in.If),
+		Name:          r.Replace(in.Name),
+		Pipeline:      replacePipelines(r, in.Pipeline),
+		Dependencies:  replaceDependencies(r, in.Dependencies),
+		Options:       in.Options,
+		Scriptlets:    replaceScriptlets(r, in.Scriptlets),
+		Description:   r.Replace(in.Description),
+		URL:           r.Replace(in.URL),
+		Commit:        replaceCommit(r, detectedCommit, in.Commit),
+		PurlNamespace: r.Replace(in.PurlNamespace),
+		Checks:        in.Checks,
+		Test:          replaceTest(r, in.Test),
 	}
 }
 
@@ -247,6 +388,17 @@ func mutateSlice(subst map[string]string, slice []string, fieldName string) erro
 	return nil
 }
 
+// mutateConditionalDependencies applies substitutions to the names of
+// conditional dependencies. Their conditions are evaluated at build time.
+func mutateConditionalDependencies(subst map[string]string, deps []ConditionalDependency, fieldName string) error {
+	for i := range deps {
+		if err := mutateString(subst, &deps[i].Name, fieldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // mutateString applies substitutions to a single string pointer.
 func mutateString(subst map[string]string, ptr *string, fieldName string) error {
 	mutated, err := util.MutateStringFromMap(subst, *ptr)
@@ -257,6 +409,18 @@ func mutateString(subst map[string]string, ptr *string, fieldName string) error
 	return nil
 }
 
+// ReplacesPriorityEpoch may be used as a replaces-priority value to have the
+// priority track the package epoch, equivalent to ${{package.epoch}}.
+const ReplacesPriorityEpoch = "epoch"
+
+// resolveReplacesPriority resolves the ReplacesPriorityEpoch sentinel to the
+// package epoch. Any other value is left untouched.
+func resolveReplacesPriority(ptr *string, epoch uint64) {
+	if *ptr == ReplacesPriorityEpoch {
+		*ptr = strconv.FormatUint(epoch, 10)
+	}
+}
+
 // ApplyDependencySubstitutions applies variable substitutions to all dependency fields
 // in the main package and subpackages.
 func (cfg *Configuration) ApplyDependencySubstitutions() error {
@@ -273,12 +437,16 @@ func (cfg *Configuration) ApplyDependencySubstitutions() error {
 	if err := mutateSlice(subst, deps.Runtime, "runtime dependency"); err != nil {
 		return err
 	}
+	if err := mutateConditionalDependencies(subst, deps.ConditionalRuntime, "conditional runtime dependency"); err != nil {
+		return err
+	}
 	if err := mutateSlice(subst, deps.Replaces, "replaces"); err != nil {
 		return err
 	}
 	if err := mutateString(subst, &deps.ProviderPriority, "provider priority"); err != nil {
 		return err
 	}
+	resolveReplacesPriority(&deps.ReplacesPriority, cfg.Package.Epoch)
 	if err := mutateString(subst, &deps.ReplacesPriority, "replaces priority"); err != nil {
 		return err
 	}
@@ -293,12 +461,16 @@ func (cfg *Configuration) ApplyDependencySubstitutions() error {
 		if err := mutateSlice(subst, spDeps.Runtime, fmt.Sprintf("%q runtime dependency", sp.Name)); err != nil {
 			return err
 		}
+		if err := mutateConditionalDependencies(subst, spDeps.ConditionalRuntime, fmt.Sprintf("%q conditional runtime dependency", sp.Name)); err != nil {
+			return err
+		}
 		if err := mutateSlice(subst, spDeps.Replaces, fmt.Sprintf("%q replaces", sp.Name)); err != nil {
 			return err
 		}
 		if err := mutateString(subst, &spDeps.ProviderPriority, fmt.Sprintf("%q provider priority", sp.Name)); err != nil {
 			return err
 		}
+		resolveReplacesPriority(&spDeps.ReplacesPriority, cfg.Package.Epoch)
 		if err := mutateString(subst, &spDeps.ReplacesPriority, fmt.Sprintf("%q replaces priority", sp.Name)); err != nil {
 			return err
 		}
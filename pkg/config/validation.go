@@ -18,18 +18,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"path"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
-	"github.com/chainguard-dev/clog"
+	"chainguard.dev/apko/pkg/apk/apk"
+	"gopkg.in/yaml.v3"
 )
 
 // ErrInvalidConfiguration is returned when a configuration is invalid.
 type ErrInvalidConfiguration struct {
 	Problem error
+
+	// File, Line and Column locate the problem in the configuration file,
+	// when known. Line and Column are 1-based; zero means unknown.
+	File   string
+	Line   int
+	Column int
 }
 
 func (e ErrInvalidConfiguration) Error() string {
+	if e.Line > 0 && e.File != "" {
+		return fmt.Sprintf("%s:%d: build configuration is invalid: %v", e.File, e.Line, e.Problem)
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: build configuration is invalid: %v", e.Line, e.Problem)
+	}
 	return fmt.Sprintf("build configuration is invalid: %v", e.Problem)
 }
 
@@ -37,65 +54,211 @@ func (e ErrInvalidConfiguration) Unwrap() error {
 	return e.Problem
 }
 
+// positionedError is a validation problem found at a node of the
+// configuration file.
+type positionedError struct {
+	node *yaml.Node
+	err  error
+}
+
+func (e positionedError) Error() string {
+	return e.err.Error()
+}
+
+func (e positionedError) Unwrap() error {
+	return e.err
+}
+
+// errorAt attaches the position of node, if any, to err.
+func errorAt(node *yaml.Node, err error) error {
+	if node == nil {
+		return err
+	}
+	return positionedError{node: node, err: err}
+}
+
+// invalid wraps a validation problem in an ErrInvalidConfiguration, carrying
+// over the position of the innermost node it was found at.
+func invalid(err error) ErrInvalidConfiguration {
+	e := ErrInvalidConfiguration{Problem: err}
+	for err != nil {
+		if pe, ok := err.(positionedError); ok {
+			e.Line, e.Column = pe.node.Line, pe.node.Column
+		}
+		err = errors.Unwrap(err)
+	}
+	return e
+}
+
 var packageNameRegex = regexp.MustCompile(`^[a-zA-Z\d][a-zA-Z\d+_.-]*$`)
 
+// Validate checks cfg as parsing does, for configurations modified after
+// they were parsed. The error is that of parsing, except that the problem is
+// located by line only, in the configuration as originally parsed.
+func (cfg Configuration) Validate(ctx context.Context) error {
+	if err := cfg.validate(ctx); err != nil {
+		return validationError(cfg.Package.Name, err)
+	}
+	return nil
+}
+
+// validationError wraps err, a problem validating the configuration of the
+// package name.
+func validationError(name string, err error) error {
+	return fmt.Errorf("validating configuration %q: %w", name, err)
+}
+
 func (cfg Configuration) validate(ctx context.Context) error {
+	var errs []error
+	fail := func(err error) {
+		for _, err := range splitErrors(err) {
+			errs = append(errs, invalid(err))
+		}
+	}
+
 	if !packageNameRegex.MatchString(cfg.Package.Name) {
-		return ErrInvalidConfiguration{Problem: fmt.Errorf("package name must match regex %q", packageNameRegex)}
+		node := keyNode(cfg.root, "package", "name")
+		if node == nil {
+			node = keyNode(cfg.root, "package")
+		}
+		fail(errorAt(node, fmt.Errorf("package name must match regex %q", packageNameRegex)))
 	}
 
+	transforms, err := compileVersionTransforms(cfg.Update.VersionTransform)
+	if err != nil {
+		fail(errorAt(keyNode(cfg.root, "update", "version-transform"), err))
+	}
 	if cfg.Package.Version == "" {
-		return ErrInvalidConfiguration{Problem: errors.New("package version must not be empty")}
+		fail(errorAt(keyNode(cfg.root, "package"), errors.New("package version must not be empty")))
+	} else if node := valueNode(cfg.root, "package", "version"); err == nil && (node == nil || !strings.Contains(node.Value, "${{git.")) {
+		// A version stamped with git metadata, as in "1.0_git${{git.short-commit}}",
+		// is not checked: a commit hash is not part of the apk version grammar.
+		if err := validateVersion(cfg.Package.Version, cfg.Package.Epoch, transforms); err != nil {
+			fail(errorAt(node, err))
+		}
 	}
 
-	// Note: Version format validation is complex - versions can contain variables,
-	// pre-release tags, etc. Consider adding semver-like validation in the future.
-
 	if err := validateDependenciesPriorities(cfg.Package.Dependencies); err != nil {
-		return ErrInvalidConfiguration{Problem: errors.New("priority must convert to integer")}
+		fail(errorAt(keyNode(cfg.root, "package", "dependencies"), errors.New("priority must convert to integer")))
 	}
-	if err := validatePipelines(ctx, cfg.Pipeline); err != nil {
-		return ErrInvalidConfiguration{Problem: err}
+	if err := validatePipelines(ctx, cfg.Pipeline, valueNode(cfg.root, "pipeline")); err != nil {
+		fail(err)
 	}
 	if err := validateCapabilities(cfg.Package.SetCap); err != nil {
-		return ErrInvalidConfiguration{Problem: err}
+		fail(errorAt(keyNode(cfg.root, "package", "setcap"), err))
+	}
+	if cfg.Package.Scriptlets != nil {
+		if err := validateTrigger(cfg.Package.Scriptlets.Trigger, keyNode(cfg.root, "package", "scriptlets", "trigger")); err != nil {
+			fail(err)
+		}
+	}
+
+	// Subpackages expanded from a range have no node of their own.
+	spNodes := valueNode(cfg.root, "subpackages")
+	if spNodes != nil && len(spNodes.Content) != len(cfg.Subpackages) {
+		spNodes = nil
 	}
 
 	saw := map[string]int{cfg.Package.Name: -1}
 	for i, sp := range cfg.Subpackages {
+		var spNode *yaml.Node
+		if spNodes != nil {
+			spNode = spNodes.Content[i]
+		}
+
 		if extant, ok := saw[sp.Name]; ok {
 			if extant == -1 {
-				return ErrInvalidConfiguration{
-					Problem: fmt.Errorf("subpackage[%d] has same name as main package: %q", i, sp.Name),
-				}
+				fail(errorAt(spNode, fmt.Errorf("subpackage[%d] has same name as main package: %q", i, sp.Name)))
 			} else {
-				return ErrInvalidConfiguration{
-					Problem: fmt.Errorf("saw duplicate subpackage name %q (subpackages index: %d and %d)", sp.Name, extant, i),
-				}
+				fail(errorAt(spNode, fmt.Errorf("saw duplicate subpackage name %q (subpackages index: %d and %d)", sp.Name, extant, i)))
 			}
+		} else {
+			saw[sp.Name] = i
 		}
 
-		saw[sp.Name] = i
-
 		if !packageNameRegex.MatchString(sp.Name) {
-			return ErrInvalidConfiguration{Problem: fmt.Errorf("subpackage name %q (subpackages index: %d) must match regex %q", sp.Name, i, packageNameRegex)}
+			fail(errorAt(spNode, fmt.Errorf("subpackage name %q (subpackages index: %d) must match regex %q", sp.Name, i, packageNameRegex)))
 		}
 		if err := validateDependenciesPriorities(sp.Dependencies); err != nil {
-			return ErrInvalidConfiguration{Problem: errors.New("priority must convert to integer")}
+			fail(errorAt(keyNode(spNode, "dependencies"), errors.New("priority must convert to integer")))
 		}
-		if err := validatePipelines(ctx, sp.Pipeline); err != nil {
-			return ErrInvalidConfiguration{Problem: err}
+		if err := validatePipelines(ctx, sp.Pipeline, valueNode(spNode, "pipeline")); err != nil {
+			fail(err)
 		}
 		if err := validateCapabilities(sp.SetCap); err != nil {
-			return ErrInvalidConfiguration{Problem: err}
+			fail(errorAt(keyNode(spNode, "setcap"), err))
+		}
+		if sp.Scriptlets != nil {
+			if err := validateTrigger(sp.Scriptlets.Trigger, keyNode(spNode, "scriptlets", "trigger")); err != nil {
+				fail(fmt.Errorf("subpackage %q: %w", sp.Name, err))
+			}
 		}
+		if sp.Test != nil && sp.Test.Matrix != nil {
+			fail(errorAt(keyNode(spNode, "test", "matrix"), fmt.Errorf("subpackage %q: a test matrix is only supported in the test of the main package, where it applies to every test", sp.Name)))
+		}
+	}
+
+	if err := validateTestMatrix(cfg.Test.BaseImages(), valueNode(cfg.root, "test", "matrix", "base-images")); err != nil {
+		fail(err)
+	}
+
+	if err := validateSBOMExtraPackages(cfg.Package.SBOM, valueNode(cfg.root, "package", "sbom", "extra-packages")); err != nil {
+		fail(err)
+	}
+
+	if err := validateSBOMPatches(cfg.Package.SBOM, valueNode(cfg.root, "package", "sbom", "patches")); err != nil {
+		fail(err)
+	}
+
+	if err := validateChangelog(cfg.Package, valueNode(cfg.root, "package", "changelog")); err != nil {
+		fail(err)
 	}
 
 	if err := validateCPE(cfg.Package.CPE); err != nil {
-		return ErrInvalidConfiguration{Problem: fmt.Errorf("CPE validation: %w", err)}
+		fail(errorAt(keyNode(cfg.root, "package", "cpe"), fmt.Errorf("CPE validation: %w", err)))
 	}
 
-	return nil
+	if err := cfg.validatePackageNotes(); err != nil {
+		fail(err)
+	}
+
+	for _, name := range cfg.UnusedVars() {
+		warn(ctx, WarningUnusedVariable, "variable %q is declared but never used", name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// splitErrors returns the errors joined in err, as by errors.Join, or err
+// alone.
+func splitErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// ValidationErrors returns every ErrInvalidConfiguration in err, as returned
+// by ParseConfiguration or Validate for a configuration with several
+// problems, in the order they were found.
+func ValidationErrors(err error) []ErrInvalidConfiguration {
+	var errs []ErrInvalidConfiguration
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case ErrInvalidConfiguration:
+			errs = append(errs, e)
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+	walk(err)
+	return errs
 }
 
 func pipelineName(p Pipeline, i int) string {
@@ -110,27 +273,98 @@ func pipelineName(p Pipeline, i int) string {
 	return fmt.Sprintf("[%d]", i)
 }
 
-func validatePipelines(ctx context.Context, ps []Pipeline) error {
-	log := clog.FromContext(ctx)
+// validatePipelines validates ps, joining the problems of every step. nodes,
+// if known, is the sequence node the pipelines were parsed from and is used
+// to locate problems.
+func validatePipelines(ctx context.Context, ps []Pipeline, nodes *yaml.Node) error {
+	if nodes != nil && (nodes.Kind != yaml.SequenceNode || len(nodes.Content) != len(ps)) {
+		nodes = nil
+	}
+	var errs []error
 	for i, p := range ps {
+		var node *yaml.Node
+		if nodes != nil {
+			node = nodes.Content[i]
+		}
+
+		if isEmptyStep(p) {
+			errs = append(errs, errorAt(node, fmt.Errorf("pipeline step %s has no action", pipelineName(p, i))))
+			continue
+		}
+
 		if p.With != nil && p.Uses == "" {
-			return fmt.Errorf("pipeline contains with but no uses")
+			errs = append(errs, errorAt(node, fmt.Errorf("pipeline contains with but no uses")))
 		}
 
 		if p.Uses != "" && p.Runs != "" {
-			return fmt.Errorf("pipeline cannot contain both uses %q and runs", p.Uses)
+			errs = append(errs, errorAt(node, fmt.Errorf("pipeline cannot contain both uses %q and runs", p.Uses)))
 		}
 
 		if p.Uses != "" && len(p.Pipeline) > 0 {
-			log.Warnf("pipeline %s contains both uses and a pipeline", pipelineName(p, i))
+			warn(ctx, WarningPipeline, "pipeline %s contains both uses and a pipeline", pipelineName(p, i))
 		}
 
 		if len(p.With) > 0 && p.Runs != "" {
-			return fmt.Errorf("pipeline cannot contain both with and runs")
+			errs = append(errs, errorAt(node, fmt.Errorf("pipeline cannot contain both with and runs")))
 		}
 
-		if err := validatePipelines(ctx, p.Pipeline); err != nil {
-			return fmt.Errorf("validating pipeline %s children: %w", pipelineName(p, i), err)
+		if err := validatePipelines(ctx, p.Pipeline, valueNode(node, "pipeline")); err != nil {
+			for _, err := range splitErrors(err) {
+				errs = append(errs, fmt.Errorf("validating pipeline %s children: %w", pipelineName(p, i), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isEmptyStep reports whether p does nothing at all: it has no action and
+// nothing, such as an environment, for nested steps to inherit.
+func isEmptyStep(p Pipeline) bool {
+	return p.Uses == "" && p.Runs == "" && len(p.Pipeline) == 0 &&
+		len(p.With) == 0 && len(p.Inputs) == 0 && len(p.Environment) == 0 &&
+		p.WorkDir == "" && p.Needs == nil && p.Assertions == nil
+}
+
+// valueNode returns the value at the given mapping keys below node, or nil
+// if there is none. A document node is looked through.
+func valueNode(node *yaml.Node, keys ...string) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		k := mappingKey(node, key)
+		if k == nil {
+			return nil
+		}
+		node = k.value
+	}
+	return node
+}
+
+// keyNode is like valueNode but returns the node of the final key, whose
+// position is that of the "key:" line.
+func keyNode(node *yaml.Node, keys ...string) *yaml.Node {
+	if len(keys) == 0 {
+		return nil
+	}
+	k := mappingKey(valueNode(node, keys[:len(keys)-1]...), keys[len(keys)-1])
+	if k == nil {
+		return nil
+	}
+	return k.key
+}
+
+type mappingEntry struct {
+	key, value *yaml.Node
+}
+
+func mappingKey(node *yaml.Node, key string) *mappingEntry {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return &mappingEntry{key: node.Content[i], value: node.Content[i+1]}
 		}
 	}
 	return nil
@@ -150,6 +384,195 @@ func validateDependenciesPriorities(deps Dependencies) error {
 	return nil
 }
 
+// validateTrigger checks that the paths a trigger monitors are absolute,
+// well-formed glob patterns, and that a trigger with paths has a script to
+// run. node, if known, is the node the trigger was parsed from.
+func validateTrigger(t Trigger, node *yaml.Node) error {
+	if len(t.Paths) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(t.Script) == "" {
+		return errorAt(node, errors.New("trigger script must not be empty when trigger paths are set"))
+	}
+	for _, p := range t.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return errorAt(node, fmt.Errorf("trigger path %q must be absolute", p))
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return errorAt(node, fmt.Errorf("trigger path %q is not a valid glob pattern: %w", p, err))
+		}
+	}
+	return nil
+}
+
+// validateSBOMExtraPackages validates the extra SBOM packages of s. nodes, if
+// known, is the sequence node they were parsed from.
+func validateSBOMExtraPackages(s *PackageSBOM, nodes *yaml.Node) error {
+	if s == nil {
+		return nil
+	}
+	if nodes != nil && (nodes.Kind != yaml.SequenceNode || len(nodes.Content) != len(s.ExtraPackages)) {
+		nodes = nil
+	}
+	for i, ep := range s.ExtraPackages {
+		var node *yaml.Node
+		if nodes != nil {
+			node = nodes.Content[i]
+		}
+
+		if ep.Name == "" {
+			return errorAt(node, fmt.Errorf("sbom extra package [%d] must have a name", i))
+		}
+		if _, err := ep.PackageURL(); err != nil {
+			return errorAt(keyNode(node, "purl"), fmt.Errorf("sbom extra package %q: %w", ep.Name, err))
+		}
+	}
+	return nil
+}
+
+// validateTestMatrix checks that the base images of a test matrix are named
+// and listed once. nodes, if known, is the sequence node they were parsed
+// from.
+func validateTestMatrix(images []string, nodes *yaml.Node) error {
+	if nodes != nil && (nodes.Kind != yaml.SequenceNode || len(nodes.Content) != len(images)) {
+		nodes = nil
+	}
+	seen := map[string]bool{}
+	for i, image := range images {
+		var node *yaml.Node
+		if nodes != nil {
+			node = nodes.Content[i]
+		}
+
+		if image == "" {
+			return errorAt(node, fmt.Errorf("test matrix base image [%d] must not be empty", i))
+		}
+		if seen[image] {
+			return errorAt(node, fmt.Errorf("test matrix base image %q is listed more than once", image))
+		}
+		seen[image] = true
+	}
+	return nil
+}
+
+// validateSBOMPatches validates the SBOM patches of s. nodes, if known, is
+// the sequence node they were parsed from.
+func validateSBOMPatches(s *PackageSBOM, nodes *yaml.Node) error {
+	if s == nil {
+		return nil
+	}
+	if nodes != nil && (nodes.Kind != yaml.SequenceNode || len(nodes.Content) != len(s.Patches)) {
+		nodes = nil
+	}
+	seen := map[string]bool{}
+	for i, p := range s.Patches {
+		var node *yaml.Node
+		if nodes != nil {
+			node = nodes.Content[i]
+		}
+
+		if p.Path == "" {
+			return errorAt(node, fmt.Errorf("sbom patch [%d] must have a path", i))
+		}
+		if path.IsAbs(p.Path) {
+			return errorAt(keyNode(node, "path"), fmt.Errorf("sbom patch %q must be relative to the workspace", p.Path))
+		}
+		if seen[p.Path] {
+			return errorAt(keyNode(node, "path"), fmt.Errorf("sbom patch %q is declared more than once", p.Path))
+		}
+		seen[p.Path] = true
+	}
+	return nil
+}
+
+// validatePackageNotes checks that each package note is about a package of
+// the build environment, conditional or not, or one that a build option
+// adds to it.
+func (cfg Configuration) validatePackageNotes() error {
+	installed := map[string]bool{}
+	for _, pkg := range cfg.Environment.Contents.Packages {
+		installed[PackageName(pkg)] = true
+	}
+	for _, pkg := range cfg.ConditionalPackages {
+		installed[PackageName(pkg.Name)] = true
+	}
+	for _, opt := range cfg.Options {
+		for _, pkg := range opt.Environment.Contents.Packages.Add {
+			installed[PackageName(pkg)] = true
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.PackageNotes)) {
+		if !installed[name] {
+			node := keyNode(cfg.root, "environment", "contents", packageNotesKey, name)
+			return errorAt(node, fmt.Errorf("%s: %q is not a package of the build environment", packageNotesKey, name))
+		}
+	}
+	return nil
+}
+
+// PackageName returns the name of the package in an entry of a package
+// list, without the version constraint or repository tag it may have, as in
+// "foo>=1.2" or "foo@local".
+func PackageName(pkg string) string {
+	if i := strings.IndexAny(pkg, "<>=~@"); i >= 0 {
+		return pkg[:i]
+	}
+	return pkg
+}
+
+// versionTransform is a compiled version-transform rule.
+type versionTransform struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// compileVersionTransforms compiles the version-transform rules of an update
+// configuration, in order.
+func compileVersionTransforms(transforms []VersionTransform) ([]versionTransform, error) {
+	compiled := make([]versionTransform, 0, len(transforms))
+	for i, t := range transforms {
+		re, err := regexp.Compile(t.Match)
+		if err != nil {
+			return nil, fmt.Errorf("version-transform[%d] has an invalid match %q: %w", i, t.Match, err)
+		}
+		compiled = append(compiled, versionTransform{match: re, replace: t.Replace})
+	}
+	return compiled, nil
+}
+
+// validateVersion checks that version, the package.version of a
+// configuration, is an apk version once the version-transform rules are
+// applied to it, so that a version such as "v1.2.3" or "1.2.3-rc1" fails
+// rather than producing a package whose version does not sort as intended.
+// Versions with unresolved substitutions are not checked.
+func validateVersion(version string, epoch uint64, transforms []versionTransform) error {
+	if strings.Contains(version, "${{") {
+		return nil
+	}
+	transformed := version
+	for _, t := range transforms {
+		transformed = t.match.ReplaceAllString(transformed, t.replace)
+	}
+	if _, err := apk.ParseVersion(fmt.Sprintf("%s-r%d", transformed, epoch)); err == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf("package version %q is not a valid apk version", version)
+	if transformed != version {
+		msg = fmt.Sprintf("package version %q (%q after version-transform) is not a valid apk version", version, transformed)
+	}
+	switch {
+	case strings.HasPrefix(transformed, "v"):
+		msg += `: remove the leading "v"`
+	case strings.Contains(transformed, "-"):
+		msg += `: it must not contain "-"; the release is the epoch, and a pre-release is a suffix such as "_rc1"`
+	default:
+		msg += `: it must be numbers separated by ".", optionally followed by a letter and suffixes such as "_rc1" or "_p2"`
+	}
+	return errors.New(msg)
+}
+
 func validateCPE(cpe CPE) error {
 	if cpe.Part != "" && cpe.Part != "a" {
 		return fmt.Errorf("invalid CPE part (must be 'a' for application, if specified): %q", cpe.Part)
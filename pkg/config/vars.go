@@ -17,6 +17,9 @@ package config
 import (
 	"fmt"
 	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
 
 	"github.com/dlorenc/melange2/pkg/util"
 )
@@ -42,6 +45,11 @@ const (
 	SubstitutionCrossTripletRustMusl  = "${{cross.triplet.rust.musl}}"
 	SubstitutionBuildArch             = "${{build.arch}}"
 	SubstitutionBuildGoArch           = "${{build.goarch}}"
+	SubstitutionBuildUserAgent        = "${{build.user-agent}}"
+	SubstitutionBuildFetchTimeout     = "${{build.fetch-timeout}}"
+	SubstitutionGitCommit             = "${{git.commit}}"
+	SubstitutionGitShortCommit        = "${{git.short-commit}}"
+	SubstitutionGitTag                = "${{git.tag}}"
 )
 
 // Get variables from configuration and return them in a map
@@ -82,3 +90,44 @@ func (cfg Configuration) PerformVarSubstitutions(nw map[string]string) error {
 
 	return nil
 }
+
+// varReferenceRegex matches a ${{vars.<name>}} reference.
+var varReferenceRegex = regexp.MustCompile(`\$\{\{\s*vars\.([^\s}]+)\s*\}\}`)
+
+// UnusedVars returns the sorted names of variables declared in the vars block
+// of the configuration file that are never referenced as ${{vars.<name>}}
+// anywhere else in the file. It inspects the file as written, before
+// substitution, and returns nil if the YAML node was not retained.
+func (cfg Configuration) UnusedVars() []string {
+	root := cfg.Root()
+	if root == nil {
+		return nil
+	}
+	vars := valueNode(root, "vars")
+	if vars == nil || vars.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	used := map[string]bool{}
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode {
+			for _, m := range varReferenceRegex.FindAllStringSubmatch(n.Value, -1) {
+				used[m[1]] = true
+			}
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	var unused []string
+	for i := 0; i+1 < len(vars.Content); i += 2 {
+		if name := vars.Content[i].Value; !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
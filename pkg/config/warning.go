@@ -0,0 +1,78 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// Warning is a problem found parsing or building a package that does not
+// fail it.
+type Warning struct {
+	// Kind classifies the warning, as one of the Warning* constants.
+	Kind string `json:"kind"`
+	// Message describes the warning, as it is logged.
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s", w.Kind, w.Message)
+}
+
+// The kinds of warnings.
+const (
+	// WarningDeprecated is the use of a deprecated field or value.
+	WarningDeprecated = "deprecated"
+	// WarningGit is git metadata of the configuration that is missing.
+	WarningGit = "git"
+	// WarningUnusedVariable is a variable that is declared but never used.
+	WarningUnusedVariable = "unused-variable"
+	// WarningPipeline is a pipeline that is suspicious, but valid.
+	WarningPipeline = "pipeline"
+	// WarningBuild is a setting of the build that is ignored or
+	// unsupported.
+	WarningBuild = "build"
+)
+
+type warningsKey struct{}
+
+// warnings collects the warnings logged while parsing a configuration.
+type warnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+// collectWarnings returns a context in which warn records warnings in the
+// returned list.
+func collectWarnings(ctx context.Context) (context.Context, *warnings) {
+	ws := &warnings{}
+	return context.WithValue(ctx, warningsKey{}, ws), ws
+}
+
+// warn logs a warning of the given kind, and records it if ctx was returned
+// by collectWarnings.
+func warn(ctx context.Context, kind, format string, args ...any) {
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...)}
+	clog.FromContext(ctx).Warn(w.Message)
+	if ws, ok := ctx.Value(warningsKey{}).(*warnings); ok {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		ws.list = append(ws.list, w)
+	}
+}
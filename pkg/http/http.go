@@ -9,8 +9,33 @@ import (
 
 	"github.com/chainguard-dev/clog"
 	"golang.org/x/time/rate"
+	"sigs.k8s.io/release-utils/version"
 )
 
+// DefaultUserAgent returns the User-Agent melange identifies itself with,
+// "melange/<version>".
+func DefaultUserAgent() string {
+	return fmt.Sprintf("melange/%s", version.GetVersionInfo().GitVersion)
+}
+
+// NewUserAgentTransport returns a transport that sends requests through t
+// with their User-Agent set to userAgent.
+func NewUserAgentTransport(t http.RoundTripper, userAgent string) http.RoundTripper {
+	return userAgentTransport{t: t, userAgent: userAgent}
+}
+
+type userAgentTransport struct {
+	t         http.RoundTripper
+	userAgent string
+}
+
+func (u userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", u.userAgent)
+	return u.t.RoundTrip(req)
+}
+
 // RLHTTPClient Rate Limited HTTP Client
 type RLHTTPClient struct {
 	Client      *http.Client
@@ -197,3 +197,33 @@ func TestRLHTTPClient_GetArtifactSHA256(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	rec := &recordingTransport{}
+	client := &http.Client{Transport: NewUserAgentTransport(rec, "melange-test/1.0")}
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/APKINDEX.tar.gz", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Go-http-client/1.1")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, rec.requests, 1)
+	assert.Equal(t, "melange-test/1.0", rec.requests[0].Header.Get("User-Agent"))
+	// The request of the caller is left as it was.
+	assert.Equal(t, "Go-http-client/1.1", req.Header.Get("User-Agent"))
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	assert.Regexp(t, `^melange/.+`, DefaultUserAgent())
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig is the proxy configuration of outbound HTTP requests, with
+// the meaning of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables.
+type ProxyConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// IsZero reports whether p configures no proxy.
+func (p ProxyConfig) IsZero() bool {
+	return p == ProxyConfig{}
+}
+
+// Environment returns the environment variables setting the proxies of p,
+// in both upper and lower case since tools disagree on which they read.
+// Fields of p that are not set have no variables.
+func (p ProxyConfig) Environment() map[string]string {
+	env := map[string]string{}
+	for name, value := range map[string]string{
+		"HTTP_PROXY":  p.HTTPProxy,
+		"HTTPS_PROXY": p.HTTPSProxy,
+		"NO_PROXY":    p.NoProxy,
+	} {
+		if value == "" {
+			continue
+		}
+		env[name] = value
+		env[strings.ToLower(name)] = value
+	}
+	return env
+}
+
+// NewProxyTransport returns a copy of t that sends requests through the
+// proxies of p. Fields of p that are not set fall back to the environment,
+// as with http.ProxyFromEnvironment.
+func NewProxyTransport(t *http.Transport, p ProxyConfig) *http.Transport {
+	cfg := httpproxy.FromEnvironment()
+	if p.HTTPProxy != "" {
+		cfg.HTTPProxy = p.HTTPProxy
+	}
+	if p.HTTPSProxy != "" {
+		cfg.HTTPSProxy = p.HTTPSProxy
+	}
+	if p.NoProxy != "" {
+		cfg.NoProxy = p.NoProxy
+	}
+	proxy := cfg.ProxyFunc()
+
+	t = t.Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	return t
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyConfigEnvironment(t *testing.T) {
+	assert.True(t, ProxyConfig{}.IsZero())
+	assert.Empty(t, ProxyConfig{}.Environment())
+
+	p := ProxyConfig{
+		HTTPProxy: "http://proxy.example.com:3128",
+		NoProxy:   "localhost,.internal",
+	}
+	assert.False(t, p.IsZero())
+	assert.Equal(t, map[string]string{
+		"HTTP_PROXY": "http://proxy.example.com:3128",
+		"http_proxy": "http://proxy.example.com:3128",
+		"NO_PROXY":   "localhost,.internal",
+		"no_proxy":   "localhost,.internal",
+	}, p.Environment())
+}
+
+func TestNewProxyTransport(t *testing.T) {
+	for _, env := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(env, "")
+	}
+
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A request sent to a proxy has the absolute URL it is for.
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	tr := NewProxyTransport(http.DefaultTransport.(*http.Transport), ProxyConfig{
+		HTTPProxy: proxy.URL,
+		NoProxy:   "direct.example.com",
+	})
+	require.NotSame(t, http.DefaultTransport, tr)
+	client := &http.Client{Transport: tr}
+
+	resp, err := client.Get("http://packages.example.com/os/APKINDEX.tar.gz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"http://packages.example.com/os/APKINDEX.tar.gz"}, proxied)
+
+	req, err := http.NewRequest(http.MethodGet, "http://direct.example.com/", nil)
+	require.NoError(t, err)
+	u, err := tr.Proxy(req)
+	require.NoError(t, err)
+	assert.Nil(t, u, "NO_PROXY hosts are reached directly")
+
+	req, err = http.NewRequest(http.MethodGet, "https://packages.example.com/", nil)
+	require.NoError(t, err)
+	u, err = tr.Proxy(req)
+	require.NoError(t, err)
+	assert.Nil(t, u, "HTTPS requests use no proxy without --https-proxy")
+}
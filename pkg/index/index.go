@@ -15,12 +15,14 @@
 package index
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"chainguard.dev/apko/pkg/apk/apk"
@@ -196,22 +198,34 @@ func (idx *Index) UpdateIndex(ctx context.Context) error {
 		}
 	}
 
-	for _, pkg := range packages {
-		found := false
+	// Look up existing entries by name and version so that merging a few
+	// packages into a large index does not rescan it for every package.
+	existing := make(map[string]int, len(idx.Index.Packages))
+	for i, p := range idx.Index.Packages {
+		existing[packageKey(p)] = i
+	}
 
-		for i, p := range idx.Index.Packages {
-			if pkg.Name == p.Name && pkg.Version == p.Version {
-				found = true
-				idx.Index.Packages[i] = pkg
-				break
-			}
+	for _, pkg := range packages {
+		if pkg == nil {
+			// Skipped due to an unexpected architecture.
+			continue
 		}
 
-		if !found {
-			idx.Index.Packages = append(idx.Index.Packages, pkg)
+		key := packageKey(pkg)
+		if i, ok := existing[key]; ok {
+			idx.Index.Packages[i] = pkg
+			continue
 		}
+
+		existing[key] = len(idx.Index.Packages)
+		idx.Index.Packages = append(idx.Index.Packages, pkg)
 	}
 
+	// Order entries independently of how they were added, so that merging
+	// packages into an existing index produces the same index as
+	// regenerating it from every package.
+	sortPackages(idx.Index.Packages)
+
 	pkgNames := make([]string, 0, len(packages))
 	for _, p := range packages {
 		if p != nil {
@@ -224,6 +238,33 @@ func (idx *Index) UpdateIndex(ctx context.Context) error {
 	return nil
 }
 
+// sortPackages orders index entries by name, then by apk version, so that
+// 1.10 sorts after 1.9.
+func sortPackages(pkgs []*apk.Package) {
+	slices.SortStableFunc(pkgs, func(a, b *apk.Package) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), compareVersions(a.Version, b.Version))
+	})
+}
+
+// compareVersions compares two apk versions, falling back to comparing them
+// as strings if either does not parse.
+func compareVersions(a, b string) int {
+	va, err := apk.ParseVersion(a)
+	if err != nil {
+		return cmp.Compare(a, b)
+	}
+	vb, err := apk.ParseVersion(b)
+	if err != nil {
+		return cmp.Compare(a, b)
+	}
+	return apk.CompareVersions(va, vb)
+}
+
+// packageKey identifies an index entry by package name and version.
+func packageKey(p *apk.Package) string {
+	return p.Name + "=" + p.Version
+}
+
 func (idx *Index) GenerateIndex(ctx context.Context) error {
 	ctx, span := otel.Tracer("melange").Start(ctx, "GenerateIndex")
 	defer span.End()
@@ -255,8 +296,8 @@ func (idx *Index) WriteArchiveIndex(ctx context.Context, destinationFile string)
 	}
 
 	if idx.SigningKey != "" {
-		log.Infof("signing apk index at %s", idx.IndexFile)
-		if err := sign.SignIndex(ctx, idx.SigningKey, idx.IndexFile); err != nil {
+		log.Infof("signing apk index at %s", destinationFile)
+		if err := sign.SignIndex(ctx, idx.SigningKey, destinationFile); err != nil {
 			return fmt.Errorf("failed to sign apk index: %w", err)
 		}
 	}
@@ -14,6 +14,8 @@ import (
 	"chainguard.dev/apko/pkg/apk/expandapk"
 	"github.com/chainguard-dev/clog/slogtest"
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/dlorenc/melange2/pkg/sign"
 )
 
 func TestUpdateIndex(t *testing.T) {
@@ -119,6 +121,26 @@ func mangleApk(t *testing.T, newDesc string) string {
 	return f.Name()
 }
 
+func TestSortPackages(t *testing.T) {
+	pkgs := []*apk.Package{
+		{Name: "foo", Version: "1.10-r0"},
+		{Name: "bar", Version: "2.0-r0"},
+		{Name: "foo", Version: "1.9-r1"},
+		{Name: "foo", Version: "1.9-r0"},
+		{Name: "foo", Version: "1.9_rc1-r0"},
+	}
+	sortPackages(pkgs)
+
+	var got []string
+	for _, p := range pkgs {
+		got = append(got, p.Name+"-"+p.Version)
+	}
+	want := []string{"bar-2.0-r0", "foo-1.9_rc1-r0", "foo-1.9-r0", "foo-1.9-r1", "foo-1.10-r0"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sortPackages(): (-want, +got):\n%s", diff)
+	}
+}
+
 func TestMergeIndex(t *testing.T) {
 	ctx := slogtest.Context(t)
 	newDesc := "This should replace the existing description"
@@ -149,3 +171,89 @@ func TestMergeIndex(t *testing.T) {
 		t.Errorf("UpdateIndex(): (-want, +got):\n%s", diff)
 	}
 }
+
+func TestIncrementalMergeMatchesFullIndex(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	dir := filepath.Join("..", "sca", "testdata", "generated", "x86_64")
+	pkgs, err := filepath.Glob(filepath.Join(dir, "*.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) < 4 {
+		t.Fatalf("wanted at least 4 test packages, got %d", len(pkgs))
+	}
+
+	signingKey := filepath.Join("..", "sign", "testdata", "test.pem")
+	tmp := t.TempDir()
+
+	// Regenerate the index from every package at once.
+	full := filepath.Join(tmp, "full", "APKINDEX.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := New(WithIndexFile(full), WithSigningKey(signingKey), WithPackageDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.GenerateIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Build the same index up one package at a time, in reverse order, as
+	// successive builds would, rebuilding one package along the way.
+	merged := filepath.Join(tmp, "merged", "APKINDEX.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(merged), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	steps := append([]string{pkgs[1]}, pkgs...)
+	for i := len(steps) - 1; i >= 0; i-- {
+		idx, err := New(
+			WithIndexFile(merged),
+			WithMergeIndexFileFlag(true),
+			WithSigningKey(signingKey),
+			WithPackageFiles([]string{steps[i]}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.GenerateIndex(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readIndex := func(path string) *apk.APKIndex {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		index, err := apk.IndexFromArchive(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+
+	want, got := readIndex(full), readIndex(merged)
+	if len(got.Packages) != len(pkgs) {
+		t.Errorf("merged index has %d packages, want %d", len(got.Packages), len(pkgs))
+	}
+	if diff := cmp.Diff(want.Packages, got.Packages); diff != "" {
+		t.Errorf("merged index differs from full index (-full, +merged):\n%s", diff)
+	}
+
+	// The merged index is re-signed after every merge.
+	pub, err := os.ReadFile(signingKey + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sign.VerifyIndex(data, map[string][]byte{"test.pem.pub": pub}); err != nil {
+		t.Errorf("merged index signature: %v", err)
+	}
+}
@@ -143,10 +143,10 @@ func LintAPK(ctx context.Context, path string, require, warn []string, outputDir
 	results := make(map[string]*types.PackageLintResults)
 
 	// Run warning linters - logs directly, ignores errors
-	_ = lintPackageFS(ctx, cfg, pkgname, exp.TarFS, warn, results, fullPackageName)
+	_ = lintPackageFS(ctx, cfg, pkgname, exp.TarFS, warn, types.SeverityWarning, results, fullPackageName)
 
 	// Run required linters - logs directly, returns errors
-	lintErr := lintPackageFS(ctx, cfg, pkgname, exp.TarFS, require, results, fullPackageName)
+	lintErr := lintPackageFS(ctx, cfg, pkgname, exp.TarFS, require, types.SeverityError, results, fullPackageName)
 
 	// Save lint results to JSON file if outputDir is provided and there are findings
 	if outputDir != "" && len(results) > 0 {
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"slices"
 
 	apkofs "chainguard.dev/apko/pkg/apk/fs"
 	"github.com/chainguard-dev/clog"
@@ -26,13 +27,46 @@ import (
 	"github.com/dlorenc/melange2/pkg/linter/types"
 )
 
+// noCommandsLinter is required for packages that set no-commands.
+const noCommandsLinter = "nocommands"
+
+// packageOptions returns the options of the package or subpackage named
+// packageName in cfg, or nil if it has none.
+func packageOptions(cfg *config.Configuration, packageName string) *config.PackageOption {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Package.Name == packageName {
+		return cfg.Package.Options
+	}
+	for _, sp := range cfg.Subpackages {
+		if sp.Name == packageName {
+			return sp.Options
+		}
+	}
+	return nil
+}
+
 // Lint the given build directory at the given path
 // Lint results will be stored as JSON in the packages directory
 func LintBuild(ctx context.Context, cfg *config.Configuration, packageName string, require, warn []string, fsys apkofs.FullFS, outputDir, arch string) error {
+	return LintBuildWithReport(ctx, cfg, packageName, require, warn, fsys, outputDir, arch, nil)
+}
+
+// LintBuildWithReport is like LintBuild, but additionally records findings
+// in report when it is non-nil
+func LintBuildWithReport(ctx context.Context, cfg *config.Configuration, packageName string, require, warn []string, fsys apkofs.FullFS, outputDir, arch string, report *Report) error {
 	if err := checkLinters(append(require, warn...)); err != nil {
 		return err
 	}
 
+	// A package that declares it provides no commands is held to it,
+	// unless the linter has been demoted to a warning.
+	if opts := packageOptions(cfg, packageName); opts != nil && opts.NoCommands &&
+		!slices.Contains(require, noCommandsLinter) && !slices.Contains(warn, noCommandsLinter) {
+		require = append(slices.Clone(require), noCommandsLinter)
+	}
+
 	// map of pkgname -> lint results
 	results := make(map[string]*types.PackageLintResults)
 
@@ -48,10 +82,14 @@ func LintBuild(ctx context.Context, cfg *config.Configuration, packageName strin
 	}
 
 	// Run warning linters - logs directly, ignores errors
-	_ = lintPackageFS(ctx, cfg, packageName, fsys, warn, results, fullPackageName)
+	_ = lintPackageFS(ctx, cfg, packageName, fsys, warn, types.SeverityWarning, results, fullPackageName)
 
 	// Run required linters - logs directly, returns errors
-	lintErr := lintPackageFS(ctx, cfg, packageName, fsys, require, results, fullPackageName)
+	lintErr := lintPackageFS(ctx, cfg, packageName, fsys, require, types.SeverityError, results, fullPackageName)
+
+	if report != nil {
+		report.Add(arch, results)
+	}
 
 	// Save lint results to JSON file if there are any findings
 	if outputDir != "" && len(results) > 0 {
@@ -27,7 +27,7 @@ import (
 	"github.com/dlorenc/melange2/pkg/linter/types"
 )
 
-func lintPackageFS(ctx context.Context, cfg *config.Configuration, pkgname string, fsys fs.FS, linters []string, results map[string]*types.PackageLintResults, fullPackageName string) error {
+func lintPackageFS(ctx context.Context, cfg *config.Configuration, pkgname string, fsys fs.FS, linters []string, severity string, results map[string]*types.PackageLintResults, fullPackageName string) error {
 	log := clog.FromContext(ctx)
 	var errs []error
 
@@ -70,8 +70,9 @@ func lintPackageFS(ctx context.Context, cfg *config.Configuration, pkgname strin
 
 			// Append finding to the linter's findings list
 			finding := &types.LinterFinding{
-				Message: messageLines[0], // Use first line as the summary message
-				Details: details,
+				Message:  messageLines[0], // Use first line as the summary message
+				Severity: severity,
+				Details:  details,
 			}
 			if linter.Explain != "" {
 				log.Warnf("  → %s", linter.Explain)
@@ -199,6 +199,16 @@ var linterMap = map[string]linter{
 		Explain:         "This package contains static archives (.a files)",
 		defaultBehavior: Warn,
 	},
+	"nocommands": {
+		LinterFunc:      linters.NoCommandsLinter,
+		Explain:         "Remove the commands from the package, or unset no-commands",
+		defaultBehavior: Ignore, // Required for packages that set no-commands.
+	},
+	"dangling-symlinks": {
+		LinterFunc:      linters.DanglingSymlinksLinter,
+		Explain:         "Fix or remove the symlinks, or add a runtime dependency that provides their targets (e.g. so:libfoo.so.1 or cmd:foo)",
+		defaultBehavior: Warn,
+	},
 	"duplicate": {
 		LinterFunc:      linters.DuplicateLinter,
 		Explain:         "This package contains files with the same name and content in different directories (consider symlinking)",
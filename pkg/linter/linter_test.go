@@ -725,6 +725,39 @@ func Test_worldWriteLinter(t *testing.T) {
 	assert.Error(t, LintBuild(ctx, nil, "worldwrite", linters, nil, fsys, t.TempDir(), "x86_64"))
 }
 
+func Test_noCommandsOption(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	newFS := func(t *testing.T, mode os.FileMode) apkofs.FullFS {
+		fsys := apkofs.DirFS(ctx, t.TempDir())
+		assert.NoError(t, fsys.MkdirAll(filepath.Join("usr", "bin"), 0o755))
+		assert.NoError(t, fsys.WriteFile(filepath.Join("usr", "bin", "tool"), []byte("#!/bin/sh\n"), mode))
+		return fsys
+	}
+
+	cfg := &config.Configuration{
+		Package: config.Package{Name: "lib", Version: "1.0.0", Options: &config.PackageOption{NoCommands: true}},
+		Subpackages: []config.Subpackage{
+			{Name: "lib-dev", Options: &config.PackageOption{NoCommands: true}},
+			{Name: "lib-tools"},
+		},
+	}
+
+	// Packages that set no-commands fail with a command, whatever the
+	// linters asked for.
+	assert.ErrorContains(t, LintBuild(ctx, cfg, "lib", nil, nil, newFS(t, 0o755), "", "x86_64"), "lib sets no-commands but provides commands")
+	assert.Error(t, LintBuild(ctx, cfg, "lib-dev", nil, nil, newFS(t, 0o755), "", "x86_64"))
+
+	// Files that are not executable are not commands.
+	assert.NoError(t, LintBuild(ctx, cfg, "lib", nil, nil, newFS(t, 0o644), "", "x86_64"))
+
+	// Packages that do not set it may provide commands.
+	assert.NoError(t, LintBuild(ctx, cfg, "lib-tools", nil, nil, newFS(t, 0o755), "", "x86_64"))
+
+	// Disabling the linter only warns.
+	assert.NoError(t, LintBuild(ctx, cfg, "lib", nil, []string{"nocommands"}, newFS(t, 0o755), "", "x86_64"))
+}
+
 func Test_lintApk(t *testing.T) {
 	ctx := slogtest.Context(t)
 
@@ -759,3 +792,119 @@ func Test_lintApkWithOutput(t *testing.T) {
 	assert.NotEmpty(t, manInfoFindings[0].Message)
 	assert.NotEmpty(t, manInfoFindings[0].Explain)
 }
+
+func Test_lintReport(t *testing.T) {
+	ctx := slogtest.Context(t)
+	report := NewReport()
+
+	cfg := &config.Configuration{
+		Package: config.Package{Name: "report", Version: "1.0.0", Epoch: 2},
+	}
+
+	newFS := func(t *testing.T) apkofs.FullFS {
+		fsys := apkofs.DirFS(ctx, t.TempDir())
+		assert.NoError(t, fsys.MkdirAll(filepath.Join("usr", "lib"), 0o755))
+		_, err := fsys.Create(filepath.Join("usr", "lib", "test.txt"))
+		assert.NoError(t, err)
+		assert.NoError(t, fsys.Chmod(filepath.Join("usr", "lib", "test.txt"), 0o776))
+		return fsys
+	}
+
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		// Required findings fail the lint, warnings do not
+		assert.Error(t, LintBuildWithReport(ctx, cfg, "report", []string{"worldwrite"}, nil, newFS(t), "", arch, report))
+		assert.NoError(t, LintBuildWithReport(ctx, cfg, "report-sub", nil, []string{"worldwrite"}, newFS(t), "", arch, report))
+	}
+
+	path := filepath.Join(t.TempDir(), "reports", "lint.json")
+	assert.NoError(t, report.Write(path))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var got types.LintReport
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Len(t, got.Results, 4)
+
+	seen := map[string]string{}
+	for _, entry := range got.Results {
+		assert.Equal(t, "worldwrite", entry.Linter)
+		assert.NotEmpty(t, entry.Findings)
+		seen[entry.Arch+"/"+entry.Package] = entry.Severity
+	}
+	assert.Equal(t, map[string]string{
+		"aarch64/report-1.0.0-r2":     types.SeverityError,
+		"aarch64/report-sub-1.0.0-r2": types.SeverityWarning,
+		"x86_64/report-1.0.0-r2":      types.SeverityError,
+		"x86_64/report-sub-1.0.0-r2":  types.SeverityWarning,
+	}, seen)
+
+	// Entries are sorted by arch first
+	assert.Equal(t, "aarch64", got.Results[0].Arch)
+}
+
+func Test_danglingSymlinksLinter(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	linters := []string{"dangling-symlinks"}
+
+	newFS := func(t *testing.T) apkofs.FullFS {
+		fsys := apkofs.DirFS(ctx, t.TempDir())
+		assert.NoError(t, fsys.MkdirAll(filepath.Join("usr", "lib"), 0o755))
+		assert.NoError(t, fsys.MkdirAll(filepath.Join("usr", "bin"), 0o755))
+		_, err := fsys.Create(filepath.Join("usr", "lib", "libfoo.so.1.2"))
+		assert.NoError(t, err)
+		return fsys
+	}
+	cfg := func(runtime ...string) *config.Configuration {
+		return &config.Configuration{
+			Package: config.Package{
+				Name:         "foo",
+				Dependencies: config.Dependencies{Runtime: runtime},
+			},
+			Subpackages: []config.Subpackage{{
+				Name:         "foo-libs",
+				Dependencies: config.Dependencies{Provides: []string{"libfoo"}},
+			}},
+		}
+	}
+
+	t.Run("links to files in the package", func(t *testing.T) {
+		fsys := newFS(t)
+		assert.NoError(t, fsys.Symlink("libfoo.so.1.2", filepath.Join("usr", "lib", "libfoo.so.1")))
+		assert.NoError(t, fsys.Symlink("/usr/lib/libfoo.so.1", filepath.Join("usr", "lib", "libfoo.so")))
+		assert.NoError(t, fsys.Symlink("../lib", filepath.Join("usr", "bin", "lib")))
+		assert.NoError(t, LintBuild(ctx, cfg(), "foo", linters, nil, fsys, t.TempDir(), "x86_64"))
+	})
+
+	t.Run("dangling link", func(t *testing.T) {
+		fsys := newFS(t)
+		assert.NoError(t, fsys.Symlink("libfoo.so.2", filepath.Join("usr", "lib", "libfoo.so")))
+		err := LintBuild(ctx, cfg(), "foo", linters, nil, fsys, t.TempDir(), "x86_64")
+		assert.ErrorContains(t, err, "foo contains 1 dangling symlink")
+		assert.ErrorContains(t, err, "usr/lib/libfoo.so -> libfoo.so.2")
+	})
+
+	t.Run("symlink loop", func(t *testing.T) {
+		fsys := newFS(t)
+		assert.NoError(t, fsys.Symlink("b", filepath.Join("usr", "lib", "a")))
+		assert.NoError(t, fsys.Symlink("a", filepath.Join("usr", "lib", "b")))
+		assert.ErrorContains(t, LintBuild(ctx, cfg(), "foo", linters, nil, fsys, t.TempDir(), "x86_64"), "foo contains 2 dangling symlinks")
+	})
+
+	t.Run("targets provided by runtime dependencies", func(t *testing.T) {
+		fsys := newFS(t)
+		assert.NoError(t, fsys.Symlink("libbar.so.3", filepath.Join("usr", "lib", "libbar.so")))
+		assert.NoError(t, fsys.Symlink("/usr/bin/bar", filepath.Join("usr", "bin", "baz")))
+		assert.Error(t, LintBuild(ctx, cfg(), "foo", linters, nil, fsys, t.TempDir(), "x86_64"))
+		assert.NoError(t, LintBuild(ctx, cfg("so:libbar.so.3", "cmd:bar"), "foo", linters, nil, fsys, t.TempDir(), "x86_64"))
+	})
+
+	t.Run("targets in a package of the same configuration", func(t *testing.T) {
+		fsys := newFS(t)
+		assert.NoError(t, fsys.Symlink("libfoo.so.2", filepath.Join("usr", "lib", "libfoo.so")))
+		assert.NoError(t, LintBuild(ctx, cfg("foo-libs"), "foo", linters, nil, fsys, t.TempDir(), "x86_64"))
+		assert.NoError(t, LintBuild(ctx, cfg("libfoo=1.2-r0"), "foo", linters, nil, fsys, t.TempDir(), "x86_64"))
+	})
+
+}
@@ -16,6 +16,7 @@ package linters
 
 import (
 	"context"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
@@ -25,6 +26,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/linter/types"
 )
 
 func TestIsIgnoredPath(t *testing.T) {
@@ -240,6 +242,40 @@ func TestEmptyLinter(t *testing.T) {
 	})
 }
 
+func TestNoCommandsLinter(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Configuration{}
+
+	t.Run("returns nil for package without commands", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"usr/lib/libfoo.so.1":     &fstest.MapFile{Data: []byte("library"), Mode: 0o755},
+			"usr/bin/README":          &fstest.MapFile{Data: []byte("not a command"), Mode: 0o644},
+			"usr/libexec/foo/helper":  &fstest.MapFile{Data: []byte("binary"), Mode: 0o755},
+			"usr/share/foo/script.sh": &fstest.MapFile{Data: []byte("#!/bin/sh"), Mode: 0o755},
+		}
+
+		err := NoCommandsLinter(ctx, cfg, "test-pkg", fsys)
+		assert.NoError(t, err)
+	})
+
+	t.Run("returns error for executables in command directories", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"usr/bin/myapp":  &fstest.MapFile{Data: []byte("binary"), Mode: 0o755},
+			"sbin/mydaemon":  &fstest.MapFile{Data: []byte("binary"), Mode: 0o700},
+			"usr/bin/mylink": &fstest.MapFile{Data: []byte("myapp"), Mode: fs.ModeSymlink | 0o777},
+			"usr/lib/libfoo": &fstest.MapFile{Data: []byte("library"), Mode: 0o755},
+		}
+
+		err := NoCommandsLinter(ctx, cfg, "test-pkg", fsys)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "test-pkg sets no-commands but provides commands")
+
+		var structErr *types.StructuredError
+		require.ErrorAs(t, err, &structErr)
+		assert.Equal(t, []string{"sbin/mydaemon", "usr/bin/myapp", "usr/bin/mylink"}, structErr.Details.(*types.PathListDetails).Paths)
+	})
+}
+
 func TestDevLinter(t *testing.T) {
 	ctx := context.Background()
 	cfg := &config.Configuration{}
@@ -0,0 +1,48 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linters
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// commandDirs are the directories commands are installed to.
+var commandDirs = []string{"bin", "sbin", "usr/bin", "usr/sbin", "usr/local/bin", "usr/local/sbin"}
+
+// NoCommandsLinter fails if the package provides commands: executable files,
+// or symlinks, in the command directories.
+func NoCommandsLinter(ctx context.Context, _ *config.Configuration, pkgname string, fsys fs.FS) error {
+	return AllPaths(ctx, pkgname, fsys,
+		func(p string, d fs.DirEntry) bool {
+			if !slices.Contains(commandDirs, path.Dir(p)) {
+				return false
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return true
+			}
+			info, err := d.Info()
+			return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+		},
+		func(pkgname string, _ []string) string {
+			return fmt.Sprintf("%s sets no-commands but provides commands", pkgname)
+		},
+	)
+}
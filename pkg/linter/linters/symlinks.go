@@ -0,0 +1,211 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/linter/types"
+)
+
+// maxSymlinkHops is how many symlinks are followed resolving a path before
+// it is taken to loop, as Linux does.
+const maxSymlinkHops = 40
+
+// errSymlinkLoop is returned resolving a path with a symlink loop.
+var errSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// symlinkFS is a filesystem whose symlinks can be read without following
+// them.
+type symlinkFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+}
+
+// DanglingSymlinksLinter fails if the package has symlinks whose targets
+// are not in it. A target the package does not have may be provided by a
+// runtime dependency, configured or generated: a shared object by a so:
+// dependency, a command by a cmd: dependency, or anything by a package
+// built from the same configuration, whose contents are not known when the
+// package is linted.
+func DanglingSymlinksLinter(ctx context.Context, cfg *config.Configuration, pkgname string, fsys fs.FS) error {
+	lfs, ok := fsys.(symlinkFS)
+	if !ok {
+		// Without reading them, symlinks cannot be resolved.
+		return nil
+	}
+	deps := runtimeDependencies(cfg, pkgname)
+	if providesAll(cfg, deps) {
+		return nil
+	}
+
+	var dangling []types.DanglingSymlink
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 || IsIgnoredPath(p) {
+			return nil
+		}
+
+		target, err := lfs.Readlink(p)
+		if err != nil {
+			return err
+		}
+		resolved, err := resolveSymlinks(lfs, p)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errSymlinkLoop) {
+			return err
+		}
+		if providedBy(resolved, deps) {
+			return nil
+		}
+		dangling = append(dangling, types.DanglingSymlink{Path: p, Target: target})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(dangling) > 0 {
+		details := &types.DanglingSymlinksDetails{
+			Symlinks: dangling,
+		}
+
+		linkWord := "symlink"
+		if len(dangling) > 1 {
+			linkWord = "symlinks"
+		}
+		message := fmt.Sprintf("%s contains %d dangling %s", pkgname, len(dangling), linkWord)
+		for _, l := range dangling {
+			message += fmt.Sprintf("\n%s -> %s", l.Path, l.Target)
+		}
+		return types.NewStructuredError(message, details)
+	}
+
+	return nil
+}
+
+// resolveSymlinks resolves name, following symlinks in any of its
+// components, within fsys: the targets of absolute symlinks are taken from
+// the root of fsys, as they are from the root of the filesystem the package
+// is installed to. On error, it also returns the path, as far as it was
+// resolved, that does not exist.
+func resolveSymlinks(fsys symlinkFS, name string) (string, error) {
+	resolved, rest := "", name
+	for hops := 0; rest != ""; {
+		var c string
+		c, rest, _ = strings.Cut(rest, "/")
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = path.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := path.Join(resolved, c)
+		// Not every filesystem's Lstat reports a dangling symlink, so
+		// symlinks are told apart by reading them.
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			if _, err := fsys.Lstat(next); err != nil {
+				return path.Join(next, rest), err
+			}
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return next, errSymlinkLoop
+		}
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+		rest = strings.TrimPrefix(target, "/") + "/" + rest
+	}
+	return resolved, nil
+}
+
+// runtimeDependencies returns the runtime dependencies of the package or
+// subpackage named pkgname in cfg.
+func runtimeDependencies(cfg *config.Configuration, pkgname string) []string {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Package.Name == pkgname {
+		return cfg.Package.Dependencies.Runtime
+	}
+	for _, sp := range cfg.Subpackages {
+		if sp.Name == pkgname {
+			return sp.Dependencies.Runtime
+		}
+	}
+	return nil
+}
+
+// providesAll reports whether one of deps is a package built from cfg,
+// which may provide any target.
+func providesAll(cfg *config.Configuration, deps []string) bool {
+	return slices.ContainsFunc(deps, func(dep string) bool { return builtWith(cfg, config.PackageName(dep)) })
+}
+
+// builtWith reports whether the package name, or one it provides, is built
+// from cfg.
+func builtWith(cfg *config.Configuration, name string) bool {
+	if cfg == nil {
+		return false
+	}
+	if cfg.Package.Name == name || slices.ContainsFunc(cfg.Package.Dependencies.Provides, func(p string) bool { return config.PackageName(p) == name }) {
+		return true
+	}
+	for _, sp := range cfg.Subpackages {
+		if sp.Name == name || slices.ContainsFunc(sp.Dependencies.Provides, func(p string) bool { return config.PackageName(p) == name }) {
+			return true
+		}
+	}
+	return false
+}
+
+// providedBy reports whether one of deps, the runtime dependencies of a
+// package, provides the missing path target.
+func providedBy(target string, deps []string) bool {
+	base := path.Base(target)
+	for _, dep := range deps {
+		name := config.PackageName(dep)
+		switch {
+		case name == "so:"+base && IsSharedObjectFileRegex.MatchString(base):
+			return true
+		case name == "cmd:"+base && slices.Contains(commandDirs, path.Dir(target)):
+			return true
+		}
+	}
+	return false
+}
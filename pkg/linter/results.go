@@ -15,11 +15,14 @@
 package linter
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 
 	"github.com/chainguard-dev/clog"
 
@@ -66,3 +69,73 @@ func saveLintResults(ctx context.Context, cfg *config.Configuration, results map
 
 	return nil
 }
+
+// Report aggregates lint results across packages and architectures so they
+// can be written as a single JSON document. It is safe for concurrent use.
+type Report struct {
+	mu      sync.Mutex
+	entries []types.LintReportEntry
+}
+
+// NewReport creates an empty Report.
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Add records the results of linting packages for arch.
+func (r *Report) Add(arch string, results map[string]*types.PackageLintResults) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, pkgResults := range results {
+		for linterName, findings := range pkgResults.Findings {
+			if len(findings) == 0 {
+				continue
+			}
+			r.entries = append(r.entries, types.LintReportEntry{
+				Arch:     arch,
+				Package:  pkgResults.PackageName,
+				Linter:   linterName,
+				Severity: findings[0].Severity,
+				Findings: findings,
+			})
+		}
+	}
+}
+
+// Write saves the aggregated report to path, sorted by architecture,
+// package and linter.
+func (r *Report) Write(path string) error {
+	r.mu.Lock()
+	entries := slices.Clone(r.entries)
+	r.mu.Unlock()
+
+	slices.SortFunc(entries, func(a, b types.LintReportEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Arch, b.Arch),
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.Linter, b.Linter),
+		)
+	})
+	if entries == nil {
+		entries = []types.LintReportEntry{}
+	}
+
+	jsonData, err := json.MarshalIndent(types.LintReport{Results: entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling lint report: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating lint report directory: %w", err)
+		}
+	}
+
+	// #nosec G306 - Lint report should be world-readable
+	if err := os.WriteFile(path, jsonData, 0o644); err != nil {
+		return fmt.Errorf("writing lint report to %s: %w", path, err)
+	}
+
+	return nil
+}
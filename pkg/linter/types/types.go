@@ -104,6 +104,17 @@ type NonLinuxDetails struct {
 	References []NonLinuxReference `json:"references"`
 }
 
+// DanglingSymlink represents a symlink whose target is not in the package
+type DanglingSymlink struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+// DanglingSymlinksDetails contains symlinks whose targets are missing
+type DanglingSymlinksDetails struct {
+	Symlinks []DanglingSymlink `json:"symlinks"`
+}
+
 // StructuredError is an error that carries structured details for JSON serialization
 type StructuredError struct {
 	Message string
@@ -123,11 +134,18 @@ func NewStructuredError(message string, details any) error {
 	}
 }
 
+// Severity levels recorded on linter findings
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
 // LinterFinding represents a single finding from a linter
 type LinterFinding struct {
-	Message string `json:"message"`
-	Explain string `json:"explain,omitempty"`
-	Details any    `json:"details,omitempty"` // Structured data specific to the linter
+	Message  string `json:"message"`
+	Explain  string `json:"explain,omitempty"`
+	Severity string `json:"severity,omitempty"` // SeverityWarning or SeverityError
+	Details  any    `json:"details,omitempty"`  // Structured data specific to the linter
 }
 
 // PackageLintResults contains all linter findings for a package
@@ -135,3 +153,17 @@ type PackageLintResults struct {
 	PackageName string                      `json:"package_name"`
 	Findings    map[string][]*LinterFinding `json:"findings"` // map of linter name -> findings
 }
+
+// LintReportEntry contains one linter's findings for a package on an architecture
+type LintReportEntry struct {
+	Arch     string           `json:"arch"`
+	Package  string           `json:"package"`
+	Linter   string           `json:"linter"`
+	Severity string           `json:"severity"`
+	Findings []*LinterFinding `json:"findings"`
+}
+
+// LintReport is an aggregated lint report covering multiple packages and architectures
+type LintReport struct {
+	Results []LintReportEntry `json:"results"`
+}
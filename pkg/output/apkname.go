@@ -0,0 +1,80 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dlorenc/melange2/pkg/config"
+)
+
+// DefaultAPKNameTemplate is the file name template of built packages when
+// none is given.
+const DefaultAPKNameTemplate = "{name}-{version}-r{epoch}.apk"
+
+// apkNamePlaceholder matches the placeholders of an APK name template.
+var apkNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// APKFileName returns the file name of the package name, at version and
+// epoch, built for arch. The template may use the placeholders {name},
+// {version}, {epoch} and {arch}; an empty template is
+// DefaultAPKNameTemplate.
+func APKFileName(template, name, version string, epoch uint64, arch string) string {
+	if template == "" {
+		template = DefaultAPKNameTemplate
+	}
+	return strings.NewReplacer(
+		"{name}", name,
+		"{version}", version,
+		"{epoch}", strconv.FormatUint(epoch, 10),
+		"{arch}", arch,
+	).Replace(template)
+}
+
+// ValidateAPKNameTemplate checks that template only uses known placeholders,
+// names a file rather than a path, ends in .apk, which the index is generated
+// from, and gives every package of cfg built for arch a file name of its own.
+func ValidateAPKNameTemplate(template string, cfg *config.Configuration, arch string) error {
+	if template == "" {
+		return nil
+	}
+
+	for _, ph := range apkNamePlaceholder.FindAllString(template, -1) {
+		switch ph {
+		case "{name}", "{version}", "{epoch}", "{arch}":
+		default:
+			return fmt.Errorf("apk name template %q: unknown placeholder %s", template, ph)
+		}
+	}
+	if strings.Contains(template, "/") {
+		return fmt.Errorf("apk name template %q: must be a file name, not a path", template)
+	}
+	if !strings.HasSuffix(template, ".apk") {
+		return fmt.Errorf("apk name template %q: must end in .apk", template)
+	}
+
+	named := map[string]string{}
+	for name := range cfg.AllPackageNames() {
+		fn := APKFileName(template, name, cfg.Package.Version, cfg.Package.Epoch, arch)
+		if other, ok := named[fn]; ok {
+			return fmt.Errorf("apk name template %q: packages %s and %s would both be written to %s", template, other, name, fn)
+		}
+		named[fn] = name
+	}
+	return nil
+}
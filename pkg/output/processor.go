@@ -19,20 +19,28 @@ package output
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"slices"
 	"time"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	apkofs "chainguard.dev/apko/pkg/apk/fs"
 	apko_build "chainguard.dev/apko/pkg/build"
+	apko_types "chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/tarfs"
 	"github.com/chainguard-dev/clog"
 
 	"github.com/dlorenc/melange2/pkg/build/sbom"
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/config"
 	"github.com/dlorenc/melange2/pkg/index"
 	"github.com/dlorenc/melange2/pkg/license"
 	"github.com/dlorenc/melange2/pkg/linter"
+	"github.com/dlorenc/melange2/pkg/linter/linters"
 )
 
 // ProcessOptions controls which post-processing steps to run.
@@ -47,6 +55,9 @@ type ProcessOptions struct {
 	SkipEmit bool
 	// SkipIndex disables APKINDEX generation.
 	SkipIndex bool
+	// FailOnEmptyPackage fails processing if the main package has no
+	// files, unless it is a virtual package marked no-provides.
+	FailOnEmptyPackage bool
 }
 
 // LintConfig contains configuration for package linting.
@@ -59,6 +70,12 @@ type LintConfig struct {
 	PersistResults bool
 	// OutDir is the directory to write lint results to.
 	OutDir string
+	// Report, if set, collects lint results for an aggregated report.
+	Report *linter.Report
+	// Dependencies are the runtime dependencies static analysis generated
+	// for each package, by name. Linters take them as runtime dependencies
+	// of the package, as well as the configured ones.
+	Dependencies map[string][]string
 }
 
 // SBOMConfig contains configuration for SBOM generation.
@@ -83,6 +100,25 @@ type EmitConfig struct {
 type IndexConfig struct {
 	// SigningKey is the path to the signing key.
 	SigningKey string
+	// APKNameTemplate is the file name template the packages were
+	// written with. Empty means DefaultAPKNameTemplate.
+	APKNameTemplate string
+}
+
+// VerifyConfig contains configuration for post-build install verification.
+type VerifyConfig struct {
+	// Enabled installs each built package into a scratch apko environment
+	// after the index has been generated.
+	Enabled bool
+	// Repositories are the configured repositories used alongside the
+	// freshly generated index to resolve dependencies.
+	Repositories []string
+	// Keyring is the list of keys used to verify Repositories.
+	Keyring []string
+	// IgnoreSignatures disables repository signature verification.
+	IgnoreSignatures bool
+	// ApkCacheDir is the directory used for cached apk packages.
+	ApkCacheDir string
 }
 
 // ProcessInput contains all the inputs needed for post-build processing.
@@ -108,6 +144,7 @@ type Processor struct {
 	SBOM    SBOMConfig
 	Emit    EmitConfig
 	Index   IndexConfig
+	Verify  VerifyConfig
 }
 
 // NewProcessor creates a new Processor with default options.
@@ -125,6 +162,14 @@ type linterTarget struct {
 func (p *Processor) Process(ctx context.Context, input *ProcessInput) error {
 	log := clog.FromContext(ctx)
 
+	// Catch pipelines that installed nothing before anything else looks
+	// at the output.
+	if p.Options.FailOnEmptyPackage {
+		if err := p.runEmptyCheck(ctx, input); err != nil {
+			return err
+		}
+	}
+
 	// Perform package linting
 	if !p.Options.SkipLint {
 		if err := p.runLinting(ctx, input); err != nil {
@@ -139,6 +184,12 @@ func (p *Processor) Process(ctx context.Context, input *ProcessInput) error {
 		}
 	}
 
+	// Install the changelog, after linting so that it does not hide an
+	// empty package.
+	if err := p.runChangelog(ctx, input); err != nil {
+		return err
+	}
+
 	// Generate SBOMs
 	if !p.Options.SkipSBOM {
 		if err := p.runSBOMGeneration(ctx, input); err != nil {
@@ -160,6 +211,13 @@ func (p *Processor) Process(ctx context.Context, input *ProcessInput) error {
 		}
 	}
 
+	// Verify the packages install against the generated index
+	if p.Verify.Enabled {
+		if err := p.runVerifyInstall(ctx, input); err != nil {
+			return err
+		}
+	}
+
 	log.Debug("post-build processing completed")
 	return nil
 }
@@ -167,6 +225,7 @@ func (p *Processor) Process(ctx context.Context, input *ProcessInput) error {
 // runLinting performs package linting on all packages.
 func (p *Processor) runLinting(ctx context.Context, input *ProcessInput) error {
 	log := clog.FromContext(ctx)
+	cfg := withRuntimeDependencies(input.Configuration, p.Lint.Dependencies)
 
 	// Build list of packages to lint
 	targets := []linterTarget{
@@ -202,7 +261,7 @@ func (p *Processor) runLinting(ctx context.Context, input *ProcessInput) error {
 			outDir = p.Lint.OutDir
 		}
 
-		if err := linter.LintBuild(ctx, input.Configuration, lt.pkgName, require, warn, fsys, outDir, input.Arch); err != nil {
+		if err := linter.LintBuildWithReport(ctx, cfg, lt.pkgName, require, warn, fsys, outDir, input.Arch, p.Lint.Report); err != nil {
 			return fmt.Errorf("unable to lint package %s: %w", lt.pkgName, err)
 		}
 	}
@@ -210,6 +269,65 @@ func (p *Processor) runLinting(ctx context.Context, input *ProcessInput) error {
 	return nil
 }
 
+// withRuntimeDependencies returns cfg, or a copy of it in which the
+// packages also have the runtime dependencies in deps, by package name.
+func withRuntimeDependencies(cfg *config.Configuration, deps map[string][]string) *config.Configuration {
+	if len(deps) == 0 {
+		return cfg
+	}
+
+	c := *cfg
+	c.Package.Dependencies.Runtime = append(slices.Clone(cfg.Package.Dependencies.Runtime), deps[cfg.Package.Name]...)
+	c.Subpackages = slices.Clone(cfg.Subpackages)
+	for i := range c.Subpackages {
+		sp := &c.Subpackages[i]
+		sp.Dependencies.Runtime = append(slices.Clone(sp.Dependencies.Runtime), deps[sp.Name]...)
+	}
+	return &c
+}
+
+// runEmptyCheck fails if the main package, unless it is a virtual package,
+// has no files in its output directory.
+func (p *Processor) runEmptyCheck(ctx context.Context, input *ProcessInput) error {
+	pkg := input.Configuration.Package
+	if pkg.Options != nil && pkg.Options.NoProvides {
+		return nil
+	}
+
+	ok, err := hasFiles(ctx, input.WorkspaceDirFS, filepath.Join(melangeOutputDirName, pkg.Name))
+	if err != nil {
+		return fmt.Errorf("checking package %s for files: %w", pkg.Name, err)
+	}
+	if !ok {
+		return fmt.Errorf("package %s is empty: the pipelines installed nothing to ${{targets.destdir}} (mark a virtual package with options.no-provides)", pkg.Name)
+	}
+	return nil
+}
+
+// hasFiles reports whether the directory dir of fsys, or any below it, holds
+// a file other than the ones melange generates. A missing directory has none.
+func hasFiles(ctx context.Context, fsys fs.FS, dir string) (bool, error) {
+	found := false
+	err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() || linters.IsIgnoredPath(rel) {
+			return nil
+		}
+		found = true
+		return fs.SkipAll
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return found, err
+}
+
 // runLicenseCheck performs license checking on the build output.
 func (p *Processor) runLicenseCheck(ctx context.Context, input *ProcessInput) error {
 	if _, _, err := license.LicenseCheck(ctx, input.Configuration, input.WorkspaceDirFS); err != nil {
@@ -218,6 +336,32 @@ func (p *Processor) runLicenseCheck(ctx context.Context, input *ProcessInput) er
 	return nil
 }
 
+// runChangelog writes the changelog of the package, if it has one, into its
+// output directory.
+func (p *Processor) runChangelog(ctx context.Context, input *ProcessInput) error {
+	pkg := input.Configuration.Package
+	changelog := pkg.ChangelogPath()
+	if changelog == "" {
+		return nil
+	}
+	clog.FromContext(ctx).Infof("installing changelog for %s", pkg.Name)
+
+	path := filepath.Join(melangeOutputDirName, pkg.Name, changelog)
+	if err := input.WorkspaceDirFS.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating changelog directory: %w", err)
+	}
+	f, err := input.WorkspaceDirFS.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating changelog: %w", err)
+	}
+	defer f.Close()
+
+	if err := pkg.WriteChangelog(f); err != nil {
+		return fmt.Errorf("writing changelog: %w", err)
+	}
+	return f.Close()
+}
+
 // runSBOMGeneration generates SBOMs for all packages.
 func (p *Processor) runSBOMGeneration(ctx context.Context, input *ProcessInput) error {
 	if p.SBOM.Generator == nil {
@@ -279,18 +423,13 @@ func (p *Processor) runIndexGeneration(ctx context.Context, input *ProcessInput)
 
 	// Pre-allocate slice for main package + subpackages
 	apkFiles := make([]string, 0, 1+len(input.Configuration.Subpackages))
-	pkgFileName := fmt.Sprintf("%s-%s-r%d.apk",
-		input.Configuration.Package.Name,
-		input.Configuration.Package.Version,
-		input.Configuration.Package.Epoch)
-	apkFiles = append(apkFiles, filepath.Join(packageDir, pkgFileName))
-
-	for _, subpkg := range input.Configuration.Subpackages {
-		subpkgFileName := fmt.Sprintf("%s-%s-r%d.apk",
-			subpkg.Name,
+	for name := range input.Configuration.AllPackageNames() {
+		fileName := APKFileName(p.Index.APKNameTemplate,
+			name,
 			input.Configuration.Package.Version,
-			input.Configuration.Package.Epoch)
-		apkFiles = append(apkFiles, filepath.Join(packageDir, subpkgFileName))
+			input.Configuration.Package.Epoch,
+			input.Arch)
+		apkFiles = append(apkFiles, filepath.Join(packageDir, fileName))
 	}
 
 	opts := []index.Option{
@@ -312,6 +451,91 @@ func (p *Processor) runIndexGeneration(ctx context.Context, input *ProcessInput)
 	return nil
 }
 
+// runVerifyInstall installs each built package into a scratch apko
+// environment, resolving dependencies against the generated index and the
+// configured repositories. It fails on unmet dependencies or install errors.
+func (p *Processor) runVerifyInstall(ctx context.Context, input *ProcessInput) error {
+	log := clog.FromContext(ctx)
+
+	if p.Options.SkipIndex {
+		return errors.New("install verification requires index generation")
+	}
+
+	outDir, err := filepath.Abs(input.OutDir)
+	if err != nil {
+		return fmt.Errorf("resolving output directory: %w", err)
+	}
+
+	repos := append([]string{outDir}, p.Verify.Repositories...)
+	keyring := slices.Clone(p.Verify.Keyring)
+	ignoreSignatures := p.Verify.IgnoreSignatures
+	if p.Index.SigningKey != "" {
+		keyring = append(keyring, p.Index.SigningKey+".pub")
+	} else if !ignoreSignatures {
+		log.Warnf("no signing key configured, ignoring signatures while verifying install")
+		ignoreSignatures = true
+	}
+
+	arch := apko_types.ParseArchitecture(input.Arch)
+	version := input.Configuration.Package.FullVersion()
+
+	for name := range input.Configuration.AllPackageNames() {
+		log.Infof("verifying %s-%s installs cleanly", name, version)
+
+		ic := apko_types.ImageConfiguration{
+			Contents: apko_types.ImageContents{
+				Repositories: repos,
+				Keyring:      keyring,
+				Packages:     []string{name + "=" + version},
+			},
+			Archs: []apko_types.Architecture{arch},
+		}
+
+		if err := verifyInstall(ctx, ic, arch, ignoreSignatures, p.Verify.ApkCacheDir); err != nil {
+			return fmt.Errorf("verifying install of %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyInstall installs the packages of ic into an in-memory filesystem.
+func verifyInstall(ctx context.Context, ic apko_types.ImageConfiguration, arch apko_types.Architecture, ignoreSignatures bool, apkCacheDir string) error {
+	tmp, err := os.MkdirTemp("", "melange-verify-*")
+	if err != nil {
+		return fmt.Errorf("creating apko tempdir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	opts := []apko_build.Option{
+		apko_build.WithImageConfiguration(ic),
+		apko_build.WithArch(arch),
+		apko_build.WithTempDir(tmp),
+		apko_build.WithIgnoreSignatures(ignoreSignatures),
+	}
+	if apkCacheDir != "" {
+		opts = append(opts, apko_build.WithCache(apkCacheDir, false, apk.NewCache(true)))
+
+		// Keep the apk cache from being evicted while apko fills it.
+		release, err := cache.Acquire(apkCacheDir)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	bc, err := apko_build.New(ctx, tarfs.New(), opts...)
+	if err != nil {
+		return fmt.Errorf("unable to create build context: %w", err)
+	}
+
+	if err := bc.BuildImage(ctx); err != nil {
+		return fmt.Errorf("installing package: %w", err)
+	}
+
+	return nil
+}
+
 // pkgFromSub creates a Package from a Subpackage.
 func pkgFromSub(sub *config.Subpackage) *config.Package {
 	return &config.Package{
@@ -16,6 +16,8 @@ package output
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -138,6 +140,29 @@ func TestLintConfig(t *testing.T) {
 	})
 }
 
+func TestWithRuntimeDependencies(t *testing.T) {
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:         "foo",
+			Dependencies: config.Dependencies{Runtime: []string{"bash"}},
+		},
+		Subpackages: []config.Subpackage{{Name: "foo-dev"}},
+	}
+
+	assert.Same(t, cfg, withRuntimeDependencies(cfg, nil))
+
+	got := withRuntimeDependencies(cfg, map[string][]string{
+		"foo":     {"so:libc.so.6"},
+		"foo-dev": {"foo"},
+	})
+	assert.Equal(t, []string{"bash", "so:libc.so.6"}, got.Package.Dependencies.Runtime)
+	assert.Equal(t, []string{"foo"}, got.Subpackages[0].Dependencies.Runtime)
+
+	// The configuration itself is left alone.
+	assert.Equal(t, []string{"bash"}, cfg.Package.Dependencies.Runtime)
+	assert.Empty(t, cfg.Subpackages[0].Dependencies.Runtime)
+}
+
 func TestProcessInput(t *testing.T) {
 	t.Run("all fields populated", func(t *testing.T) {
 		cfg := &config.Configuration{
@@ -201,6 +226,148 @@ func TestProcessor_ProcessSkipsAll(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestProcessor_ProcessInstallsChangelog(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-package",
+			Version: "1.1.0",
+			Changelog: []config.ChangelogEntry{{
+				Version: "1.1.0-r0",
+				Date:    "2024-03-01",
+				Entries: []string{"New upstream release."},
+			}, {
+				Version: "1.0.0-r0",
+				Date:    "2024-01-15",
+				Entries: []string{"Initial package."},
+			}},
+		},
+	}
+
+	processor := &Processor{
+		Options: ProcessOptions{
+			SkipLint:         true,
+			SkipLicenseCheck: true,
+			SkipSBOM:         true,
+			SkipEmit:         true,
+			SkipIndex:        true,
+		},
+	}
+
+	input := &ProcessInput{
+		Configuration:   cfg,
+		WorkspaceDir:    tmpDir,
+		WorkspaceDirFS:  apkofs.DirFS(ctx, tmpDir),
+		OutDir:          tmpDir,
+		Arch:            "x86_64",
+		SourceDateEpoch: time.Now(),
+	}
+	require.NoError(t, processor.Process(ctx, input))
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "melange-out", "test-package", "usr", "share", "doc", "test-package", "changelog"))
+	require.NoError(t, err)
+	assert.Equal(t, `test-package (1.1.0-r0) 2024-03-01
+
+  * New upstream release.
+
+test-package (1.0.0-r0) 2024-01-15
+
+  * Initial package.
+`, string(got))
+}
+
+func TestProcessor_FailOnEmptyPackage(t *testing.T) {
+	ctx := context.Background()
+
+	process := func(t *testing.T, pkg config.Package, files ...string) error {
+		t.Helper()
+		tmpDir := t.TempDir()
+		for _, f := range files {
+			path := filepath.Join(tmpDir, "melange-out", pkg.Name, f)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+		}
+
+		processor := &Processor{
+			Options: ProcessOptions{
+				SkipLint:           true,
+				SkipLicenseCheck:   true,
+				SkipSBOM:           true,
+				SkipEmit:           true,
+				SkipIndex:          true,
+				FailOnEmptyPackage: true,
+			},
+		}
+		return processor.Process(ctx, &ProcessInput{
+			Configuration:   &config.Configuration{Package: pkg},
+			WorkspaceDir:    tmpDir,
+			WorkspaceDirFS:  apkofs.DirFS(ctx, tmpDir),
+			OutDir:          tmpDir,
+			Arch:            "x86_64",
+			SourceDateEpoch: time.Now(),
+		})
+	}
+
+	t.Run("empty package", func(t *testing.T) {
+		err := process(t, config.Package{Name: "hello", Version: "1.0.0"})
+		assert.ErrorContains(t, err, "package hello is empty")
+	})
+
+	t.Run("only generated files", func(t *testing.T) {
+		err := process(t, config.Package{Name: "hello", Version: "1.0.0"}, "var/lib/db/sbom/hello-1.0.0-r0.spdx.json")
+		assert.ErrorContains(t, err, "package hello is empty")
+	})
+
+	t.Run("package with files", func(t *testing.T) {
+		assert.NoError(t, process(t, config.Package{Name: "hello", Version: "1.0.0"}, "usr/bin/hello"))
+	})
+
+	t.Run("virtual package", func(t *testing.T) {
+		pkg := config.Package{Name: "hello", Version: "1.0.0", Options: &config.PackageOption{NoProvides: true}}
+		assert.NoError(t, process(t, pkg))
+	})
+}
+
+func TestProcessor_VerifyRequiresIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+
+	cfg := &config.Configuration{
+		Package: config.Package{
+			Name:    "test-package",
+			Version: "1.0.0",
+		},
+	}
+
+	processor := &Processor{
+		Options: ProcessOptions{
+			SkipLint:         true,
+			SkipLicenseCheck: true,
+			SkipSBOM:         true,
+			SkipEmit:         true,
+			SkipIndex:        true,
+		},
+		Verify: VerifyConfig{
+			Enabled: true,
+		},
+	}
+
+	input := &ProcessInput{
+		Configuration:   cfg,
+		WorkspaceDir:    tmpDir,
+		WorkspaceDirFS:  apkofs.DirFS(ctx, tmpDir),
+		OutDir:          tmpDir,
+		Arch:            "x86_64",
+		SourceDateEpoch: time.Now(),
+	}
+
+	err := processor.Process(ctx, input)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires index generation")
+}
+
 func TestProcessor_ProcessWithNilGenerator(t *testing.T) {
 	tmpDir := t.TempDir()
 	ctx := context.Background()
@@ -334,6 +501,66 @@ func TestProcessor_EmitterCalled(t *testing.T) {
 	assert.Contains(t, emittedPkgs, "main-package-doc")
 }
 
+func TestAPKFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"default", "", "hello-1.2.3-r4.apk"},
+		{"explicit default", DefaultAPKNameTemplate, "hello-1.2.3-r4.apk"},
+		{"all placeholders", "{arch}-{name}_{version}-{epoch}.apk", "x86_64-hello_1.2.3-4.apk"},
+		{"repeated placeholder", "{name}-{name}.apk", "hello-hello.apk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, APKFileName(tt.template, "hello", "1.2.3", 4, "x86_64"))
+		})
+	}
+}
+
+func TestValidateAPKNameTemplate(t *testing.T) {
+	cfg := &config.Configuration{
+		Package: config.Package{Name: "hello", Version: "1.2.3", Epoch: 4},
+		Subpackages: []config.Subpackage{
+			{Name: "hello-dev"},
+			{Name: "hello-doc"},
+		},
+	}
+
+	for _, template := range []string{"", DefaultAPKNameTemplate, "{arch}-{name}_{version}-{epoch}.apk", "{name}.apk"} {
+		t.Run("valid "+template, func(t *testing.T) {
+			require.NoError(t, ValidateAPKNameTemplate(template, cfg, "x86_64"))
+		})
+	}
+
+	t.Run("names must be unique", func(t *testing.T) {
+		err := ValidateAPKNameTemplate("{version}-r{epoch}.apk", cfg, "x86_64")
+		require.EqualError(t, err, `apk name template "{version}-r{epoch}.apk": packages hello and hello-dev would both be written to 1.2.3-r4.apk`)
+	})
+
+	t.Run("single package needs no name", func(t *testing.T) {
+		single := &config.Configuration{Package: cfg.Package}
+		require.NoError(t, ValidateAPKNameTemplate("{version}-r{epoch}.apk", single, "x86_64"))
+	})
+
+	t.Run("unknown placeholder", func(t *testing.T) {
+		err := ValidateAPKNameTemplate("{name}-{release}.apk", cfg, "x86_64")
+		require.ErrorContains(t, err, "unknown placeholder {release}")
+	})
+
+	t.Run("path", func(t *testing.T) {
+		err := ValidateAPKNameTemplate("{arch}/{name}.apk", cfg, "x86_64")
+		require.ErrorContains(t, err, "must be a file name, not a path")
+	})
+
+	t.Run("no .apk suffix", func(t *testing.T) {
+		err := ValidateAPKNameTemplate("{name}-{version}.tar.gz", cfg, "x86_64")
+		require.ErrorContains(t, err, "must end in .apk")
+	})
+}
+
 func TestMelangeOutputDirName(t *testing.T) {
 	assert.Equal(t, "melange-out", melangeOutputDirName)
 }
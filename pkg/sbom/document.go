@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"hash/fnv"
+	"maps"
+	"slices"
 	"time"
 
 	apko_build "chainguard.dev/apko/pkg/build"
@@ -56,12 +58,14 @@ func (d Document) ToSPDX(ctx context.Context, releaseData *apko_build.ReleaseDat
 		spdxPkgs = append(spdxPkgs, p.ToSPDX(ctx))
 	}
 
+	// Sort the licensing infos, so that the document does not depend on the
+	// order of the map.
 	licensingInfos := make([]spdx.LicensingInfo, 0, len(d.LicensingInfos))
-	for licenseID, extractedText := range d.LicensingInfos {
+	for _, licenseID := range slices.Sorted(maps.Keys(d.LicensingInfos)) {
 		licensingInfos = append(licensingInfos,
 			spdx.LicensingInfo{
 				LicenseID:     licenseID,
-				ExtractedText: extractedText,
+				ExtractedText: d.LicensingInfos[licenseID],
 			},
 		)
 	}
@@ -86,6 +86,16 @@ type Package struct {
 	// source locations; Leaving this empty will result in NOASSERTION being
 	// used as its value.
 	DownloadLocation string
+
+	// The absolute path of the changelog the package installs, if any. If
+	// set, it will be added as an ExternalRef of type "changelog".
+	Changelog string
+
+	// A free-form description of the package, if any.
+	Description string
+
+	// The SPDX primary package purpose, e.g. "FILE", if any.
+	PrimaryPurpose string
 }
 
 // ToSPDX returns the Package converted to its SPDX representation.
@@ -115,6 +125,8 @@ func (p Package) ToSPDX(ctx context.Context) spdx.Package {
 		LicenseDeclared:  p.LicenseDeclared,
 		DownloadLocation: p.DownloadLocation,
 		CopyrightText:    p.Copyright,
+		Description:      p.Description,
+		PrimaryPurpose:   p.PrimaryPurpose,
 		Checksums:        p.getChecksums(),
 		ExternalRefs:     p.getExternalRefs(),
 		Originator:       p.getSupplier(), // yes, we use this value for both fields (for now)
@@ -179,6 +191,14 @@ func (p Package) getExternalRefs() []spdx.ExternalRef {
 		})
 	}
 
+	if p.Changelog != "" {
+		result = append(result, spdx.ExternalRef{
+			Category: "OTHER",
+			Locator:  p.Changelog,
+			Type:     "changelog",
+		})
+	}
+
 	return result
 }
 
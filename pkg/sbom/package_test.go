@@ -205,6 +205,21 @@ func TestPackageToSPDX(t *testing.T) {
 				require.Contains(t, sp.ExternalRefs[0].Locator, "pkg:apk/wolfi/purl-pkg")
 			},
 		},
+		{
+			name: "package with changelog",
+			pkg: Package{
+				Name:      "changelog-pkg",
+				Version:   "1.0.0",
+				Changelog: "/usr/share/doc/changelog-pkg/changelog",
+				Namespace: "test",
+			},
+			check: func(t *testing.T, sp spdx.Package) {
+				require.Len(t, sp.ExternalRefs, 1)
+				require.Equal(t, "OTHER", sp.ExternalRefs[0].Category)
+				require.Equal(t, "changelog", sp.ExternalRefs[0].Type)
+				require.Equal(t, "/usr/share/doc/changelog-pkg/changelog", sp.ExternalRefs[0].Locator)
+			},
+		},
 		{
 			name: "package with download location",
 			pkg: Package{
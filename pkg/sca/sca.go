@@ -687,10 +687,19 @@ func generateSharedObjectNameDeps(ctx context.Context, hdl SCAHandle, generated
 
 				libver := sonameLibver(soname)
 
-				if isInDir(path, expandedLibDirs) {
+				switch {
+				case hdl.Options().ShlibProvides:
+					// Every staged library is provided, as packages
+					// splitting libraries out often install them in
+					// directories of their own.
+					generated.Provides = append(generated.Provides, fmt.Sprintf("so:%s=%s", soname, libver))
+					if !hdl.Options().NoVersionedShlibDeps {
+						generated.Provides = append(generated.Provides, fmt.Sprintf("so-ver:%s=%s", soname, hdl.Version()))
+					}
+				case isInDir(path, expandedLibDirs):
 					generated.Provides = append(generated.Provides, fmt.Sprintf("so:%s=%s", soname, libver))
 					generated.Provides = append(generated.Provides, fmt.Sprintf("so-ver:%s=%s", soname, hdl.Version()))
-				} else {
+				default:
 					generated.Vendored = append(generated.Vendored, fmt.Sprintf("so:%s=%s", soname, libver))
 					generated.Vendored = append(generated.Vendored, fmt.Sprintf("so-ver:%s=%s", soname, hdl.Version()))
 				}
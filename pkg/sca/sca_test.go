@@ -216,6 +216,51 @@ func TestVendoredPkgConfig(t *testing.T) {
 	}
 }
 
+func TestShlibProvides(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	for _, tc := range []struct {
+		name string
+		opts config.PackageOption
+		want []string
+	}{{
+		name: "shlib-provides",
+		opts: config.PackageOption{ShlibProvides: true},
+		want: []string{
+			"so-ver:libecpg_compat.so.3=4604-r0",
+			"so:libecpg_compat.so.3=3",
+		},
+	}, {
+		name: "no-versioned-shlib-deps",
+		opts: config.PackageOption{ShlibProvides: true, NoVersionedShlibDeps: true},
+		want: []string{
+			"so:libecpg_compat.so.3=3",
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The library of this package is in usr/libexec, which is not a
+			// library directory, so it is vendored without shlib-provides.
+			th := handleFromApk(ctx, t, "neon-4604-r0.apk", "neon.yaml")
+			defer th.exp.Close()
+			th.cfg.Package.Options = &tc.opts
+
+			got := config.Dependencies{}
+			if err := Analyze(ctx, th, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, got.Provides); diff != "" {
+				t.Errorf("Analyze() provides: (-want, +got):\n%s", diff)
+			}
+			for _, v := range got.Vendored {
+				if strings.HasPrefix(v, "so:") || strings.HasPrefix(v, "so-ver:") {
+					t.Errorf("Analyze() vendored %s, want it provided", v)
+				}
+			}
+		})
+	}
+}
+
 func TestRubySca(t *testing.T) {
 	ctx := slogtest.Context(t)
 	// Generated by:
@@ -0,0 +1,121 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnauthenticated is returned by an Authenticator for a request without
+// valid credentials.
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Authenticator identifies the tenant making a request.
+type Authenticator interface {
+	// Authenticate returns the tenant r is made by, or ErrUnauthenticated
+	// when r has no valid credentials.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// MaxTenantLength is the maximum length of a tenant.
+const MaxTenantLength = 63
+
+// tenantName matches the names of tenants.
+var tenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateTenant checks the name of a tenant.
+func validateTenant(tenant string) error {
+	if len(tenant) > MaxTenantLength {
+		return fmt.Errorf("tenant too long (max %d characters)", MaxTenantLength)
+	}
+	if !tenantName.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant %q: must be letters, digits, '.', '_' or '-', starting with a letter or digit", tenant)
+	}
+	return nil
+}
+
+// TokenAuthenticator authenticates requests by the bearer token of their
+// Authorization header. Only the SHA-256 of the tokens is kept.
+type TokenAuthenticator struct {
+	tenants map[[sha256.Size]byte]string
+}
+
+// NewTokenAuthenticator creates a TokenAuthenticator for tokens, the
+// tenants keyed by their tokens.
+func NewTokenAuthenticator(tokens map[string]string) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tenants: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, tenant := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("empty token for tenant %q", tenant)
+		}
+		if err := validateTenant(tenant); err != nil {
+			return nil, err
+		}
+		a.tenants[sha256.Sum256([]byte(token))] = tenant
+	}
+	return a, nil
+}
+
+// LoadTokenAuthenticator creates a TokenAuthenticator from a YAML file
+// listing the tokens of each tenant:
+//
+//	team-a:
+//	  - <token>
+//	team-b:
+//	  - <token>
+//	  - <token>
+func LoadTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenant tokens: %w", err)
+	}
+
+	var tenants map[string][]string
+	if err := yaml.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parsing tenant tokens: %w", err)
+	}
+
+	tokens := make(map[string]string)
+	for tenant, ts := range tenants {
+		for _, token := range ts {
+			if other, ok := tokens[token]; ok && other != tenant {
+				return nil, fmt.Errorf("token of tenant %q is also a token of tenant %q", tenant, other)
+			}
+			tokens[token] = tenant
+		}
+	}
+	return NewTokenAuthenticator(tokens)
+}
+
+// Authenticate returns the tenant of the bearer token of r.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", ErrUnauthenticated
+	}
+	tenant, ok := a.tenants[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return tenant, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTokenAuthenticator(t *testing.T) {
+	authenticate := func(a *TokenAuthenticator, header string) (string, error) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/builds", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return a.Authenticate(req)
+	}
+
+	t.Run("tokens of each tenant", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("team-a:\n  - token-1\n  - token-2\nteam-b:\n  - token-3\n"), 0o600))
+		a, err := LoadTokenAuthenticator(path)
+		require.NoError(t, err)
+
+		for header, want := range map[string]string{
+			"Bearer token-1": "team-a",
+			"Bearer token-2": "team-a",
+			"Bearer token-3": "team-b",
+		} {
+			tenant, err := authenticate(a, header)
+			require.NoError(t, err)
+			require.Equal(t, want, tenant)
+		}
+		for _, header := range []string{"", "Bearer ", "Bearer token-4", "Basic token-1", "token-1"} {
+			_, err := authenticate(a, header)
+			require.ErrorIs(t, err, ErrUnauthenticated, header)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"invalid tenant": "../a:\n  - token-1\n",
+			"empty token":    "team-a:\n  - \"\"\n",
+			"shared token":   "team-a:\n  - token-1\nteam-b:\n  - token-1\n",
+			"not a map":      "- token-1\n",
+		} {
+			path := filepath.Join(t.TempDir(), "tokens.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			_, err := LoadTokenAuthenticator(path)
+			require.Error(t, err, name)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadTokenAuthenticator(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.Error(t, err)
+	})
+}
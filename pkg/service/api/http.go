@@ -0,0 +1,82 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTPConfig tunes the HTTP server the API is served with.
+type HTTPConfig struct {
+	// ReadHeaderTimeout bounds reading the headers of a request.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading a whole request, body included.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing a response, except for the routes
+	// wrapped in WithoutWriteTimeout.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection is kept open
+	// waiting for the next request.
+	IdleTimeout time.Duration
+	// MaxConcurrentStreams limits the streams of each HTTP/2 connection.
+	// Zero uses the default of net/http.
+	MaxConcurrentStreams int
+	// H2C serves HTTP/2 over cleartext connections, alongside HTTP/1.1.
+	H2C bool
+}
+
+// DefaultHTTPConfig returns the HTTP server settings of melange-server.
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+}
+
+// NewHTTPServer returns an HTTP server for handler listening on addr,
+// configured by cfg.
+func NewHTTPServer(addr string, handler http.Handler, cfg HTTPConfig) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    1 << 20, // 1MB
+		Protocols:         &protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+		},
+	}
+}
+
+// WithoutWriteTimeout lifts the write deadline of the server for h, a
+// streaming route that stays open for as long as there is data to send.
+func WithoutWriteTimeout(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Not every ResponseWriter supports deadlines; those that do not
+		// have none to lift.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		h.ServeHTTP(w, r)
+	})
+}
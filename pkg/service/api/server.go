@@ -18,7 +18,11 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -41,25 +45,45 @@ type Server struct {
 	buildStore store.BuildStore
 	pool       *buildkit.Pool
 	mux        *http.ServeMux
+
+	// authn identifies the tenant of requests for builds. Without it,
+	// every request is made by store.DefaultTenant.
+	authn Authenticator
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithAuthenticator makes the requests for builds be made by the tenant
+// authn authenticates them as, and denies those it does not authenticate.
+func WithAuthenticator(authn Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authn = authn
+	}
 }
 
 // NewServer creates a new API server.
-func NewServer(buildStore store.BuildStore, pool *buildkit.Pool) *Server {
+func NewServer(buildStore store.BuildStore, pool *buildkit.Pool, opts ...ServerOption) *Server {
 	s := &Server{
 		buildStore: buildStore,
 		pool:       pool,
 		mux:        http.NewServeMux(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 	s.setupRoutes()
 	return s
 }
 
 func (s *Server) setupRoutes() {
-	s.mux.HandleFunc("/api/v1/builds", s.handleBuilds)
-	s.mux.HandleFunc("/api/v1/builds/", s.handleBuild)
+	s.mux.HandleFunc("/api/v1/builds", s.authenticated(s.handleBuilds))
+	s.mux.HandleFunc("/api/v1/builds/", s.authenticated(s.handleBuild))
+	s.mux.HandleFunc("/api/v1/builds/stats", s.authenticated(s.handleBuildStats))
 	s.mux.HandleFunc("/api/v1/backends", s.handleBackends)
 	s.mux.HandleFunc("/api/v1/backends/status", s.handleBackendsStatus)
 	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReady)
 }
 
 // BuildMetricsResponse is the response body for the build metrics endpoint.
@@ -109,6 +133,31 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// ReadyResponse is the response body for the readiness endpoint.
+type ReadyResponse struct {
+	Status string `json:"status"`
+	// Architectures lists the architectures with a backend able to accept a build.
+	Architectures []string `json:"architectures"`
+}
+
+// handleReady reports whether the server can make progress on builds.
+// It returns 503 when no backend can accept a job for any architecture,
+// because every circuit is open or every backend is at capacity.
+// GET /readyz
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	resp := ReadyResponse{Status: "ready", Architectures: s.pool.ReadyArchitectures()}
+	code := http.StatusOK
+	if len(resp.Architectures) == 0 {
+		resp.Status = "unavailable"
+		resp.Architectures = []string{}
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
 // handleBackends handles backend management:
 // GET /api/v1/backends - list available backends
 // POST /api/v1/backends - add a new backend
@@ -147,9 +196,10 @@ func (s *Server) listBackends(w http.ResponseWriter, r *http.Request) {
 
 // AddBackendRequest is the request body for adding a backend.
 type AddBackendRequest struct {
-	Addr   string            `json:"addr"`
-	Arch   string            `json:"arch"`
-	Labels map[string]string `json:"labels,omitempty"`
+	Addr          string            `json:"addr"`
+	Arch          string            `json:"arch"`
+	EmulatedArchs []string          `json:"emulatedArchs,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
 }
 
 // addBackend adds a new backend to the pool.
@@ -161,9 +211,10 @@ func (s *Server) addBackend(w http.ResponseWriter, r *http.Request) {
 	}
 
 	backend := buildkit.Backend{
-		Addr:   req.Addr,
-		Arch:   req.Arch,
-		Labels: req.Labels,
+		Addr:          req.Addr,
+		Arch:          req.Arch,
+		EmulatedArchs: req.EmulatedArchs,
+		Labels:        req.Labels,
 	}
 
 	if err := s.pool.Add(backend); err != nil {
@@ -231,25 +282,83 @@ func (s *Server) handleBackendsStatus(w http.ResponseWriter, r *http.Request) {
 // MaxBodySize is the maximum allowed request body size (10MB).
 const MaxBodySize = 10 << 20
 
-// handleBuilds handles POST /api/v1/builds (create build) and GET /api/v1/builds (list builds).
-func (s *Server) handleBuilds(w http.ResponseWriter, r *http.Request) {
+// IdempotencyKeyHeader is the request header carrying a client-supplied key
+// that makes retrying POST /api/v1/builds safe: a repeat request with the
+// same key returns the build created by the first one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// MaxIdempotencyKeyLength is the maximum length of an idempotency key.
+const MaxIdempotencyKeyLength = 255
+
+// tenantHandlerFunc handles a request made by tenant.
+type tenantHandlerFunc func(w http.ResponseWriter, r *http.Request, tenant string)
+
+// authenticated passes the requests h handles the tenant they are made by,
+// as identified by the authenticator of s, and denies requests it does not
+// authenticate.
+func (s *Server) authenticated(h tenantHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authn == nil {
+			h(w, r, store.DefaultTenant)
+			return
+		}
+		tenant, err := s.authn.Authenticate(r)
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "authenticating request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h(w, r, tenant)
+	}
+}
+
+// MaxMetadataBodySize is the maximum allowed size of a metadata patch (64KB).
+const MaxMetadataBodySize = 64 << 10
+
+// validateMetadata checks the keys of build metadata.
+func validateMetadata(metadata map[string]string) error {
+	for k := range metadata {
+		if k == "" {
+			return errors.New("metadata keys must not be empty")
+		}
+	}
+	return nil
+}
+
+// envName matches the names of environment variables a build may be given.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnv checks that each key of env is a valid environment variable
+// name.
+func validateEnv(env map[string]string) error {
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		if !envName.MatchString(k) {
+			return fmt.Errorf("invalid env: %q is not a valid environment variable name", k)
+		}
+	}
+	return nil
+}
+
+// handleBuilds handles POST /api/v1/builds (create build) and GET /api/v1/builds (list builds,
+// optionally filtered with ?package=name).
+func (s *Server) handleBuilds(w http.ResponseWriter, r *http.Request, tenant string) {
 	switch r.Method {
 	case http.MethodPost:
-		s.createBuild(w, r)
+		s.createBuild(w, r, tenant)
 	case http.MethodGet:
-		s.listBuilds(w, r)
+		s.listBuilds(w, r, tenant)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// handleBuild handles GET /api/v1/builds/:id and GET /api/v1/builds/:id/metrics.
-func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+// handleBuild handles GET /api/v1/builds/:id, GET /api/v1/builds/:id/metrics
+// and PATCH /api/v1/builds/:id/metadata.
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request, tenant string) {
 	// Extract build ID from path
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/builds/")
 	if path == "" {
@@ -257,14 +366,25 @@ func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check if this is a metadata request
+	if buildID, ok := strings.CutSuffix(path, "/metadata"); ok {
+		s.handleBuildMetadata(w, r, tenant, buildID)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	// Check if this is a metrics request
 	if strings.HasSuffix(path, "/metrics") {
 		buildID := strings.TrimSuffix(path, "/metrics")
-		s.handleBuildMetrics(w, r, buildID)
+		s.handleBuildMetrics(w, r, tenant, buildID)
 		return
 	}
 
-	build, err := s.buildStore.GetBuild(r.Context(), path)
+	build, err := s.buildStore.GetBuild(r.Context(), tenant, path)
 	if err != nil {
 		if errors.Is(err, svcerrors.ErrBuildNotFound) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -278,10 +398,90 @@ func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(build)
 }
 
+// handleBuildStats returns the number of builds in each status, with
+// ?package=name only of the builds with that package, and with
+// ?since=time (RFC 3339) only of the builds created since then.
+// GET /api/v1/builds/stats
+func (s *Server) handleBuildStats(w http.ResponseWriter, r *http.Request, tenant string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter := store.BuildFilter{Package: r.URL.Query().Get("package")}
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+
+	counts, err := s.buildStore.CountBuilds(r.Context(), tenant, filter)
+	if err != nil {
+		http.Error(w, "failed to count builds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := types.BuildStatsResponse{Statuses: counts}
+	for _, n := range counts {
+		resp.Total += n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleBuildMetadata merges the key/values in the request body into the
+// metadata of a build, removing keys with an empty value, and returns the
+// resulting metadata.
+// PATCH /api/v1/builds/:id/metadata
+func (s *Server) handleBuildMetadata(w http.ResponseWriter, r *http.Request, tenant, buildID string) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxMetadataBodySize)
+	var patch map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateMetadata(patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if err := s.buildStore.UpdateBuildMetadata(ctx, tenant, buildID, patch); err != nil {
+		if errors.Is(err, svcerrors.ErrBuildNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	build, err := s.buildStore.GetBuild(ctx, tenant, buildID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	metadata := build.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(metadata)
+}
+
 // handleBuildMetrics returns detailed metrics for a build.
 // GET /api/v1/builds/:id/metrics
-func (s *Server) handleBuildMetrics(w http.ResponseWriter, r *http.Request, buildID string) {
-	build, err := s.buildStore.GetBuild(r.Context(), buildID)
+func (s *Server) handleBuildMetrics(w http.ResponseWriter, r *http.Request, tenant, buildID string) {
+	build, err := s.buildStore.GetBuild(r.Context(), tenant, buildID)
 	if err != nil {
 		if errors.Is(err, svcerrors.ErrBuildNotFound) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -408,7 +608,7 @@ func percentile(values []int64, p int) int64 {
 
 // createBuild creates a new build.
 // Supports single config, multiple configs, or git source.
-func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createBuild(w http.ResponseWriter, r *http.Request, tenant string) {
 	ctx, span := tracing.StartSpan(r.Context(), "api.createBuild",
 		trace.WithAttributes(attribute.String("http.method", r.Method)),
 	)
@@ -419,6 +619,12 @@ func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
 
 	log := clog.FromContext(ctx)
 
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("%s header too long (max %d characters)", IdempotencyKeyHeader, MaxIdempotencyKeyLength), http.StatusBadRequest)
+		return
+	}
+
 	// Limit request body size to prevent OOM
 	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
 
@@ -431,6 +637,18 @@ func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateEnv(req.Env); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MaxParallelPerBackend < 0 {
+		http.Error(w, "max_parallel_per_backend must not be negative", http.StatusBadRequest)
+		return
+	}
 
 	// Collect configs from single config, multiple configs, or git source
 	var configs []string
@@ -522,21 +740,28 @@ func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
 
 	// Create build spec
 	spec := types.BuildSpec{
-		Configs:         configs,
-		GitSource:       req.GitSource,
-		Pipelines:       req.Pipelines,
-		SourceFiles:     req.SourceFiles,
-		Arch:            req.Arch,
-		BackendSelector: req.BackendSelector,
-		WithTest:        req.WithTest,
-		Debug:           req.Debug,
-		Mode:            mode,
-		Env:             req.Env,
+		Configs:               configs,
+		GitSource:             req.GitSource,
+		Pipelines:             req.Pipelines,
+		SourceFiles:           req.SourceFiles,
+		Arch:                  req.Arch,
+		BackendSelector:       req.BackendSelector,
+		WithTest:              req.WithTest,
+		Debug:                 req.Debug,
+		Mode:                  mode,
+		Env:                   req.Env,
+		MaxParallelPerBackend: req.MaxParallelPerBackend,
 	}
 
 	// Create build in store
 	storeTimer := tracing.NewTimer(ctx, "store_create_build")
-	build, err := s.buildStore.CreateBuild(ctx, sorted, spec)
+	var build *types.Build
+	created := true
+	if idempotencyKey != "" {
+		build, created, err = s.buildStore.CreateBuildWithKey(ctx, tenant, idempotencyKey, sorted, spec, store.WithMetadata(req.Metadata))
+	} else {
+		build, err = s.buildStore.CreateBuild(ctx, tenant, sorted, spec, store.WithMetadata(req.Metadata))
+	}
 	storeTimer.Stop()
 	if err != nil {
 		http.Error(w, "failed to create build: "+err.Error(), http.StatusInternalServerError)
@@ -544,16 +769,24 @@ func (s *Server) createBuild(w http.ResponseWriter, r *http.Request) {
 	}
 
 	span.SetAttributes(attribute.String("build_id", build.ID))
-	log.Infof("created build %s with %d packages", build.ID, len(sorted))
 
-	// Collect package names for response
-	packageNames := make([]string, len(sorted))
-	for i, node := range sorted {
-		packageNames[i] = node.Name
+	// Collect package names for response from the stored build, which
+	// is an earlier one when the idempotency key has been seen before
+	packageNames := make([]string, len(build.Packages))
+	for i, pkg := range build.Packages {
+		packageNames[i] = pkg.Name
+	}
+
+	status := http.StatusCreated
+	if created {
+		log.Infof("created build %s with %d packages", build.ID, len(sorted))
+	} else {
+		log.Infof("returning existing build %s for idempotency key %q", build.ID, idempotencyKey)
+		status = http.StatusOK
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(types.CreateBuildResponse{
 		ID:       build.ID,
 		Packages: packageNames,
@@ -605,9 +838,16 @@ func (e *configError) Error() string {
 	return e.msg
 }
 
-// listBuilds lists all builds.
-func (s *Server) listBuilds(w http.ResponseWriter, r *http.Request) {
-	builds, err := s.buildStore.ListBuilds(r.Context())
+// listBuilds lists all builds, or with ?package=name only the builds with
+// that package.
+func (s *Server) listBuilds(w http.ResponseWriter, r *http.Request, tenant string) {
+	var builds []*types.Build
+	var err error
+	if name := r.URL.Query().Get("package"); name != "" {
+		builds, err = s.buildStore.ListBuildsByPackage(r.Context(), tenant, name)
+	} else {
+		builds, err = s.buildStore.ListBuilds(r.Context(), tenant)
+	}
 	if err != nil {
 		http.Error(w, "failed to list builds: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -16,22 +16,29 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/dlorenc/melange2/pkg/service/buildkit"
 	"github.com/dlorenc/melange2/pkg/service/store"
+	"github.com/dlorenc/melange2/pkg/service/types"
 )
 
-func newTestServer(t *testing.T, backends []buildkit.Backend) *Server {
+func newTestServer(t *testing.T, backends []buildkit.Backend, opts ...ServerOption) *Server {
 	t.Helper()
 	pool, err := buildkit.NewPool(backends)
 	require.NoError(t, err)
-	return NewServer(store.NewMemoryBuildStore(), pool)
+	return NewServer(store.NewMemoryBuildStore(), pool, opts...)
 }
 
 func TestListBackends(t *testing.T) {
@@ -294,6 +301,64 @@ func TestHealthEndpoint(t *testing.T) {
 	require.Equal(t, "ok", resp["status"])
 }
 
+func TestReadyEndpoint(t *testing.T) {
+	pool, err := buildkit.NewPoolWithConfig(buildkit.PoolConfig{
+		Backends: []buildkit.Backend{
+			{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+			{Addr: "tcp://arm64-1:1234", Arch: "aarch64"},
+		},
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+	})
+	require.NoError(t, err)
+	server := NewServer(store.NewMemoryBuildStore(), pool)
+
+	ready := func(t *testing.T) (int, ReadyResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		var resp ReadyResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w.Code, resp
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		code, resp := ready(t)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "ready", resp.Status)
+		require.ElementsMatch(t, []string{"x86_64", "aarch64"}, resp.Architectures)
+	})
+
+	t.Run("one circuit open", func(t *testing.T) {
+		backend, err := pool.SelectAndAcquire("aarch64", nil)
+		require.NoError(t, err)
+		pool.Release(backend.Addr, false)
+
+		code, resp := ready(t)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"x86_64"}, resp.Architectures)
+	})
+
+	t.Run("all circuits open", func(t *testing.T) {
+		backend, err := pool.SelectAndAcquire("x86_64", nil)
+		require.NoError(t, err)
+		pool.Release(backend.Addr, false)
+
+		code, resp := ready(t)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "unavailable", resp.Status)
+		require.Empty(t, resp.Architectures)
+
+		// Liveness is unaffected.
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
 // Build API tests
 
 func TestCreateBuild(t *testing.T) {
@@ -442,6 +507,167 @@ func TestCreateBuild(t *testing.T) {
 	})
 }
 
+func TestCreateBuildIdempotencyKey(t *testing.T) {
+	backends := []buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	}
+	server := newTestServer(t, backends)
+
+	post := func(t *testing.T, key string) (int, types.CreateBuildResponse) {
+		t.Helper()
+		body := `{"config_yaml": "package:\n  name: single-pkg\n  version: 1.0.0\n"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		var resp types.CreateBuildResponse
+		if w.Code == http.StatusOK || w.Code == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		}
+		return w.Code, resp
+	}
+
+	t.Run("same key returns the same build", func(t *testing.T) {
+		code, first := post(t, "retry-1")
+		require.Equal(t, http.StatusCreated, code)
+
+		code, again := post(t, "retry-1")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, first.ID, again.ID)
+		require.Equal(t, []string{"single-pkg"}, again.Packages)
+	})
+
+	t.Run("different keys create different builds", func(t *testing.T) {
+		code, a := post(t, "retry-2")
+		require.Equal(t, http.StatusCreated, code)
+		code, b := post(t, "retry-3")
+		require.Equal(t, http.StatusCreated, code)
+		require.NotEqual(t, a.ID, b.ID)
+	})
+
+	t.Run("no key always creates a build", func(t *testing.T) {
+		code, a := post(t, "")
+		require.Equal(t, http.StatusCreated, code)
+		code, b := post(t, "")
+		require.Equal(t, http.StatusCreated, code)
+		require.NotEqual(t, a.ID, b.ID)
+	})
+
+	t.Run("key too long", func(t *testing.T) {
+		code, _ := post(t, strings.Repeat("k", MaxIdempotencyKeyLength+1))
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func TestBuildTenants(t *testing.T) {
+	backends := []buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	}
+	authn, err := NewTokenAuthenticator(map[string]string{
+		"alice-token": "alice",
+		"bob-token":   "bob",
+	})
+	require.NoError(t, err)
+	server := newTestServer(t, backends, WithAuthenticator(authn))
+
+	do := func(t *testing.T, method, path, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	create := func(t *testing.T, token, body string) string {
+		t.Helper()
+		w := do(t, http.MethodPost, "/api/v1/builds", token, body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp types.CreateBuildResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.ID
+	}
+	list := func(t *testing.T, token string) []string {
+		t.Helper()
+		w := do(t, http.MethodGet, "/api/v1/builds", token, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var builds []types.Build
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&builds))
+		var ids []string
+		for _, b := range builds {
+			ids = append(ids, b.ID)
+		}
+		return ids
+	}
+
+	config := `"config_yaml": "package:\n  name: pkg\n  version: 1.0.0\n"`
+	alice := create(t, "alice-token", `{`+config+`}`)
+	bob := create(t, "bob-token", `{`+config+`}`)
+
+	t.Run("builds are listed only for their tenant", func(t *testing.T) {
+		require.Equal(t, []string{alice}, list(t, "alice-token"))
+		require.Equal(t, []string{bob}, list(t, "bob-token"))
+	})
+
+	t.Run("builds of another tenant are not found", func(t *testing.T) {
+		w := do(t, http.MethodGet, "/api/v1/builds/"+alice, "alice-token", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var build types.Build
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&build))
+		require.Equal(t, "alice", build.Tenant)
+		require.Equal(t, "alice", build.Packages[0].Tenant)
+
+		w = do(t, http.MethodGet, "/api/v1/builds/"+alice, "bob-token", "")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		w = do(t, http.MethodGet, "/api/v1/builds/"+alice+"/metrics", "bob-token", "")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		w = do(t, http.MethodPatch, "/api/v1/builds/"+alice+"/metadata", "bob-token", `{"user": "bob"}`)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("stats are of the tenant", func(t *testing.T) {
+		w := do(t, http.MethodGet, "/api/v1/builds/stats", "bob-token", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var stats types.BuildStatsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		require.Equal(t, 1, stats.Total)
+	})
+
+	t.Run("requests without valid credentials are denied", func(t *testing.T) {
+		for _, token := range []string{"", "carol-token"} {
+			w := do(t, http.MethodGet, "/api/v1/builds", token, "")
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			w = do(t, http.MethodGet, "/api/v1/builds/"+alice, token, "")
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			w = do(t, http.MethodGet, "/api/v1/builds/stats", token, "")
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			w = do(t, http.MethodPost, "/api/v1/builds", token, `{`+config+`}`)
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("without an authenticator builds are of the default tenant", func(t *testing.T) {
+		server := newTestServer(t, backends)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(`{`+config+`}`))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp types.CreateBuildResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+		build, err := server.buildStore.GetBuild(t.Context(), store.DefaultTenant, resp.ID)
+		require.NoError(t, err)
+		require.Equal(t, store.DefaultTenant, build.Tenant)
+	})
+}
+
 func TestListBuilds(t *testing.T) {
 	backends := []buildkit.Backend{
 		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
@@ -486,6 +712,136 @@ func TestListBuilds(t *testing.T) {
 	})
 }
 
+func TestListBuildsByPackage(t *testing.T) {
+	backends := []buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	}
+	server := newTestServer(t, backends)
+
+	var withCurl []string
+	for _, name := range []string{"curl", "zlib", "curl"} {
+		body := `{"configs": ["package:\n  name: ` + name + `\n  version: 1.0.0\n"]}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var resp types.CreateBuildResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		if name == "curl" {
+			withCurl = append(withCurl, resp.ID)
+		}
+	}
+
+	list := func(t *testing.T, pkg string) []string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/builds?package="+pkg, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var builds []types.Build
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&builds))
+		ids := []string{}
+		for _, b := range builds {
+			ids = append(ids, b.ID)
+		}
+		return ids
+	}
+
+	require.ElementsMatch(t, withCurl, list(t, "curl"))
+	require.Len(t, list(t, "zlib"), 1)
+	require.Empty(t, list(t, "openssl"))
+}
+
+func TestBuildStats(t *testing.T) {
+	backends := []buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	}
+	buildStore := store.NewMemoryBuildStore()
+	pool, err := buildkit.NewPool(backends)
+	require.NoError(t, err)
+	server := NewServer(buildStore, pool)
+
+	stats := func(t *testing.T, query string) (int, types.BuildStatsResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/builds/stats"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		var resp types.BuildStatsResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		}
+		return w.Code, resp
+	}
+
+	t.Run("no builds", func(t *testing.T) {
+		code, resp := stats(t, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 0, resp.Total)
+		require.Empty(t, resp.Statuses)
+	})
+
+	for _, name := range []string{"curl", "zlib", "curl"} {
+		body := `{"configs": ["package:\n  name: ` + name + `\n  version: 1.0.0\n"]}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		if name == "zlib" {
+			var resp types.CreateBuildResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			build, err := buildStore.GetBuild(context.Background(), store.DefaultTenant, resp.ID)
+			require.NoError(t, err)
+			build.Status = types.BuildStatusFailed
+			require.NoError(t, buildStore.UpdateBuild(context.Background(), build))
+		}
+	}
+
+	t.Run("all builds", func(t *testing.T) {
+		code, resp := stats(t, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 3, resp.Total)
+		require.Equal(t, map[types.BuildStatus]int{
+			types.BuildStatusPending: 2,
+			types.BuildStatusFailed:  1,
+		}, resp.Statuses)
+	})
+
+	t.Run("builds of a package", func(t *testing.T) {
+		code, resp := stats(t, "?package=curl")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 2, resp.Total)
+		require.Equal(t, map[types.BuildStatus]int{types.BuildStatusPending: 2}, resp.Statuses)
+	})
+
+	t.Run("builds created since", func(t *testing.T) {
+		code, resp := stats(t, "?since="+time.Now().Add(time.Hour).Format(time.RFC3339))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 0, resp.Total)
+
+		code, resp = stats(t, "?since="+time.Now().Add(-time.Hour).Format(time.RFC3339))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 3, resp.Total)
+	})
+
+	t.Run("invalid since", func(t *testing.T) {
+		code, _ := stats(t, "?since=yesterday")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds/stats", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
 func TestGetBuild(t *testing.T) {
 	backends := []buildkit.Backend{
 		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
@@ -545,6 +901,156 @@ func TestGetBuild(t *testing.T) {
 	})
 }
 
+func TestBuildMetadata(t *testing.T) {
+	backends := []buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	}
+	server := newTestServer(t, backends)
+
+	do := func(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	getMetadata := func(t *testing.T, id string) map[string]string {
+		t.Helper()
+		w := do(t, http.MethodGet, "/api/v1/builds/"+id, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var build types.Build
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&build))
+		return build.Metadata
+	}
+
+	w := do(t, http.MethodPost, "/api/v1/builds", `{
+		"config_yaml": "package:\n  name: test-pkg\n  version: 1.0.0\n",
+		"metadata": {"user": "alice", "pr": "https://github.com/example/repo/pull/1", "reviewer": ""}
+	}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created types.CreateBuildResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	t.Run("set at creation", func(t *testing.T) {
+		// Empty values are recorded as given, unlike in a patch.
+		require.Equal(t, map[string]string{
+			"user":     "alice",
+			"pr":       "https://github.com/example/repo/pull/1",
+			"reviewer": "",
+		}, getMetadata(t, created.ID))
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		w := do(t, http.MethodPatch, "/api/v1/builds/"+created.ID+"/metadata",
+			`{"ci_run": "https://ci.example.com/runs/7", "user": ""}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		var got map[string]string
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+
+		want := map[string]string{
+			"ci_run":   "https://ci.example.com/runs/7",
+			"pr":       "https://github.com/example/repo/pull/1",
+			"reviewer": "",
+		}
+		require.Equal(t, want, got)
+		require.Equal(t, want, getMetadata(t, created.ID))
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		w := do(t, http.MethodPatch, "/api/v1/builds/"+created.ID+"/metadata", `{"": "x"}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do(t, http.MethodPost, "/api/v1/builds", `{
+			"config_yaml": "package:\n  name: test-pkg\n  version: 1.0.0\n",
+			"metadata": {"": "x"}
+		}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		w := do(t, http.MethodPatch, "/api/v1/builds/"+created.ID+"/metadata", `{"user": 1}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("non-existent build", func(t *testing.T) {
+		w := do(t, http.MethodPatch, "/api/v1/builds/non-existent/metadata", `{"user": "alice"}`)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		w := do(t, http.MethodGet, "/api/v1/builds/"+created.ID+"/metadata", "")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestCreateBuildMaxParallelPerBackend(t *testing.T) {
+	server := newTestServer(t, []buildkit.Backend{{Addr: "tcp://amd64-1:1234", Arch: "x86_64"}})
+
+	create := func(t *testing.T, limit int) *httptest.ResponseRecorder {
+		t.Helper()
+		body := fmt.Sprintf(`{"config_yaml": "package:\n  name: test-pkg\n  version: 1.0.0\n", "max_parallel_per_backend": %d}`, limit)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := create(t, 2)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created types.CreateBuildResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	build, err := server.buildStore.GetBuild(t.Context(), store.DefaultTenant, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, build.Spec.MaxParallelPerBackend)
+
+	w = create(t, -1)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "max_parallel_per_backend must not be negative")
+}
+
+func TestCreateBuildEnv(t *testing.T) {
+	server := newTestServer(t, []buildkit.Backend{{Addr: "tcp://amd64-1:1234", Arch: "x86_64"}})
+
+	create := func(t *testing.T, env string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := fmt.Sprintf(`{"config_yaml": "package:\n  name: test-pkg\n  version: 1.0.0\n", "env": %s}`, env)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/builds", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := create(t, `{"BUILD_NUMBER": "42", "_CI": "true"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created types.CreateBuildResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	// The env is recorded with the build, and returned with it.
+	want := map[string]string{"BUILD_NUMBER": "42", "_CI": "true"}
+	build, err := server.buildStore.GetBuild(t.Context(), store.DefaultTenant, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, want, build.Spec.Env)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/builds/"+created.ID, nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got types.Build
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	require.Equal(t, want, got.Spec.Env)
+
+	for _, name := range []string{"", "1BUILD", "BUILD-NUMBER", "BUILD NUMBER", "BUILD=NUMBER"} {
+		t.Run(fmt.Sprintf("invalid name %q", name), func(t *testing.T) {
+			key, err := json.Marshal(name)
+			require.NoError(t, err)
+			w := create(t, fmt.Sprintf(`{%s: "42"}`, key))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), "is not a valid environment variable name")
+		})
+	}
+}
+
 func TestBuildsMethodNotAllowed(t *testing.T) {
 	backends := []buildkit.Backend{
 		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
@@ -557,3 +1063,78 @@ func TestBuildsMethodNotAllowed(t *testing.T) {
 
 	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
 }
+
+func TestNewHTTPServer(t *testing.T) {
+	cfg := HTTPConfig{
+		ReadHeaderTimeout:    time.Second,
+		ReadTimeout:          2 * time.Second,
+		WriteTimeout:         200 * time.Millisecond,
+		IdleTimeout:          4 * time.Second,
+		MaxConcurrentStreams: 16,
+		H2C:                  true,
+	}
+
+	// The handler streams two events, the second after the write timeout
+	// has passed.
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		_ = http.NewResponseController(w).Flush()
+		time.Sleep(2 * cfg.WriteTimeout)
+		_, _ = io.WriteString(w, "data: 2\n\n")
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/stream", WithoutWriteTimeout(stream))
+	mux.Handle("/other", stream)
+	srv := NewHTTPServer("127.0.0.1:0", mux, cfg)
+
+	require.Equal(t, cfg.ReadHeaderTimeout, srv.ReadHeaderTimeout)
+	require.Equal(t, cfg.ReadTimeout, srv.ReadTimeout)
+	require.Equal(t, cfg.WriteTimeout, srv.WriteTimeout)
+	require.Equal(t, cfg.IdleTimeout, srv.IdleTimeout)
+	require.Equal(t, 16, srv.HTTP2.MaxConcurrentStreams)
+	require.True(t, srv.Protocols.HTTP1())
+	require.True(t, srv.Protocols.UnencryptedHTTP2())
+
+	l, err := net.Listen("tcp", srv.Addr)
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	base := "http://" + l.Addr().String()
+
+	get := func(t *testing.T, h2c bool, path string) (*http.Response, string, error) {
+		t.Helper()
+		var protocols http.Protocols
+		protocols.SetHTTP1(!h2c)
+		protocols.SetUnencryptedHTTP2(h2c)
+		client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+		t.Cleanup(client.CloseIdleConnections)
+
+		resp, err := client.Get(base + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp, string(body), err
+	}
+
+	for _, h2c := range []bool{false, true} {
+		t.Run(fmt.Sprintf("h2c=%t", h2c), func(t *testing.T) {
+			t.Run("streaming route outlives the write timeout", func(t *testing.T) {
+				resp, body, err := get(t, h2c, "/stream")
+				require.NoError(t, err)
+				require.Equal(t, "data: 1\n\ndata: 2\n\n", body)
+				if h2c {
+					require.Equal(t, 2, resp.ProtoMajor)
+				} else {
+					require.Equal(t, 1, resp.ProtoMajor)
+				}
+			})
+
+			t.Run("other responses are cut off", func(t *testing.T) {
+				_, body, err := get(t, h2c, "/other")
+				require.Error(t, err)
+				require.NotContains(t, body, "data: 2")
+			})
+		})
+	}
+}
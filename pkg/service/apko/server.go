@@ -42,6 +42,8 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
+
+	"github.com/dlorenc/melange2/pkg/cache"
 )
 
 // Server implements the ApkoService gRPC server.
@@ -232,6 +234,13 @@ func (s *Server) buildLayers(ctx context.Context, imgConfig *apko_types.ImageCon
 	// Add APK cache if configured
 	if s.ApkCacheDir != "" {
 		opts = append(opts, apko_build.WithCache(s.ApkCacheDir, false, apk.NewCache(true)))
+
+		// Keep the apk cache from being evicted while apko fills it.
+		release, err := cache.Acquire(s.ApkCacheDir)
+		if err != nil {
+			return "", 0, false, nil, err
+		}
+		defer release()
 	}
 
 	// Lock image configuration
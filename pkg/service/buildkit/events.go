@@ -0,0 +1,112 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildkit
+
+import (
+	"sync"
+	"time"
+)
+
+// SubscriberBufferSize is the number of events buffered per subscriber.
+// Events for a subscriber whose buffer is full are dropped so that a slow
+// consumer never stalls the pool.
+const SubscriberBufferSize = 64
+
+// PoolEventType identifies what changed in the pool.
+type PoolEventType string
+
+const (
+	// PoolEventAdded is emitted when a backend is added to the pool.
+	PoolEventAdded PoolEventType = "added"
+	// PoolEventRemoved is emitted when a backend is removed from the pool.
+	PoolEventRemoved PoolEventType = "removed"
+	// PoolEventUpdated is emitted when a backend's configuration changes.
+	PoolEventUpdated PoolEventType = "updated"
+	// PoolEventCircuitOpened is emitted when a backend's circuit breaker opens.
+	PoolEventCircuitOpened PoolEventType = "circuit-opened"
+	// PoolEventCircuitClosed is emitted when a backend's circuit breaker closes.
+	PoolEventCircuitClosed PoolEventType = "circuit-closed"
+	// PoolEventDraining is emitted when a backend starts being drained. It
+	// is followed by PoolEventRemoved once its jobs finish.
+	PoolEventDraining PoolEventType = "draining"
+)
+
+// PoolEvent describes a change to a backend in the pool.
+type PoolEvent struct {
+	Type    PoolEventType `json:"type"`
+	Backend Backend       `json:"backend"`
+	Time    time.Time     `json:"time"`
+}
+
+// subscribers fans out pool events to subscribed channels.
+type subscribers struct {
+	mu   sync.Mutex
+	next uint64
+	subs map[uint64]chan PoolEvent
+}
+
+// Subscribe returns a channel that receives an event whenever a backend is
+// added, removed, updated, drained, or changes circuit state, and a function
+// that cancels the subscription and closes the channel. The cancel function
+// is safe to call more than once.
+//
+// Events are delivered without blocking the pool: if a subscriber falls more
+// than SubscriberBufferSize events behind, further events are dropped for it
+// until it catches up. Subscribers that need an exact view should re-read
+// List or Status after receiving an event.
+func (p *Pool) Subscribe() (<-chan PoolEvent, func()) {
+	s := &p.events
+	ch := make(chan PoolEvent, SubscriberBufferSize)
+
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[uint64]chan PoolEvent)
+	}
+	id := s.next
+	s.next++
+	s.subs[id] = ch
+	s.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, id)
+			s.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// publish delivers an event to all subscribers without blocking.
+func (p *Pool) publish(typ PoolEventType, backend Backend) {
+	s := &p.events
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.subs) == 0 {
+		return
+	}
+
+	ev := PoolEvent{Type: typ, Backend: backend, Time: time.Now()}
+	for _, ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+			// Subscriber is not keeping up; drop rather than stall the pool.
+		}
+	}
+}
@@ -19,12 +19,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/chainguard-dev/clog"
 	svcerrors "github.com/dlorenc/melange2/pkg/service/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/yaml.v3"
 )
 
@@ -50,6 +52,11 @@ type Backend struct {
 	// Arch is the architecture this backend supports (e.g., "x86_64", "aarch64").
 	Arch string `json:"arch" yaml:"arch"`
 
+	// EmulatedArchs are additional architectures this backend can build for
+	// under emulation (e.g., QEMU via binfmt_misc). Backends native to an
+	// architecture are always preferred over those emulating it.
+	EmulatedArchs []string `json:"emulatedArchs,omitempty" yaml:"emulatedArchs,omitempty"`
+
 	// Labels are arbitrary key-value pairs for backend selection.
 	// Examples: tier=high-memory, sandbox=privileged
 	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
@@ -59,6 +66,15 @@ type Backend struct {
 	MaxJobs int `json:"maxJobs,omitempty" yaml:"maxJobs,omitempty"`
 }
 
+// supports reports whether the backend can build for arch, and whether it
+// does so natively rather than under emulation.
+func (b Backend) supports(arch string) (ok, native bool) {
+	if b.Arch == arch {
+		return true, true
+	}
+	return slices.Contains(b.EmulatedArchs, arch), false
+}
+
 // backendState tracks runtime state for a backend (not serialized).
 type backendState struct {
 	// activeJobs is the current number of jobs running on this backend.
@@ -73,6 +89,10 @@ type backendState struct {
 	// circuitOpen is true if the circuit breaker is open (backend excluded).
 	circuitOpen atomic.Bool
 
+	// draining is true once the backend is being drained: it takes no new
+	// jobs, and is removed when its active jobs finish.
+	draining atomic.Bool
+
 	// mu protects lastFailure
 	mu sync.Mutex
 }
@@ -84,6 +104,7 @@ type BackendStatus struct {
 	Failures    int       `json:"failures"`
 	CircuitOpen bool      `json:"circuitOpen"`
 	LastFailure time.Time `json:"lastFailure,omitempty"`
+	Draining    bool      `json:"draining,omitempty"`
 }
 
 // PoolConfig is the configuration for a BuildKit pool.
@@ -113,6 +134,12 @@ type Pool struct {
 	defaultMaxJobs   int
 	failureThreshold int
 	recoveryTimeout  time.Duration
+
+	// events notifies subscribers of backend changes.
+	events subscribers
+
+	// acquireWait is how long callers wait to acquire a backend.
+	acquireWait *prometheus.HistogramVec
 }
 
 // NewPool creates a new BuildKit pool from the given backends with default configuration.
@@ -162,9 +189,28 @@ func NewPoolWithConfig(config PoolConfig) (*Pool, error) {
 		defaultMaxJobs:   defaultMaxJobs,
 		failureThreshold: failureThreshold,
 		recoveryTimeout:  recoveryTimeout,
+		acquireWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "melange_buildkit_backend_acquire_wait_seconds",
+				Help:    "Time spent waiting to acquire a BuildKit backend slot in seconds",
+				Buckets: prometheus.ExponentialBuckets(0.01, 2, 16), // 0.01s to ~5.5m
+			},
+			[]string{"arch"},
+		),
 	}, nil
 }
 
+// Collectors returns the metrics of the pool, for the caller to register.
+func (p *Pool) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.acquireWait}
+}
+
+// RecordAcquireWait records that a caller waited wait to acquire a backend
+// for arch, from asking for one until it was acquired.
+func (p *Pool) RecordAcquireWait(arch string, wait time.Duration) {
+	p.acquireWait.WithLabelValues(arch).Observe(wait.Seconds())
+}
+
 // NewPoolFromConfig creates a pool from a YAML config file.
 func NewPoolFromConfig(configPath string) (*Pool, error) {
 	data, err := os.ReadFile(configPath)
@@ -197,6 +243,8 @@ func NewPoolFromSingleAddr(addr, arch string) (*Pool, error) {
 
 // Select chooses a backend matching the given architecture and selector.
 // It uses load-aware selection, picking the least-loaded available backend.
+// Backends native to the architecture are preferred; backends emulating it
+// are only chosen when no native backend is available.
 // Backends with open circuits or at capacity are excluded.
 // Returns ErrNoAvailableBackend if all matching backends are unavailable.
 func (p *Pool) Select(arch string, selector map[string]string) (*Backend, error) {
@@ -205,6 +253,7 @@ func (p *Pool) Select(arch string, selector map[string]string) (*Backend, error)
 
 	var best *Backend
 	var bestLoad float64 = 2.0 // Start higher than max possible (1.0)
+	var bestNative bool
 
 	now := time.Now()
 
@@ -212,7 +261,8 @@ func (p *Pool) Select(arch string, selector map[string]string) (*Backend, error)
 		b := &p.backends[i]
 
 		// Filter by architecture
-		if b.Arch != arch {
+		ok, native := b.supports(arch)
+		if !ok {
 			continue
 		}
 
@@ -222,7 +272,7 @@ func (p *Pool) Select(arch string, selector map[string]string) (*Backend, error)
 		}
 
 		state := p.state[b.Addr]
-		if state == nil {
+		if state == nil || state.draining.Load() {
 			continue
 		}
 
@@ -252,11 +302,12 @@ func (p *Pool) Select(arch string, selector map[string]string) (*Backend, error)
 			continue // At capacity
 		}
 
-		// Calculate load ratio
+		// Calculate load ratio, preferring native backends over emulated ones
 		load := float64(active) / float64(maxJobs)
-		if best == nil || load < bestLoad {
+		if best == nil || (native && !bestNative) || (native == bestNative && load < bestLoad) {
 			best = b
 			bestLoad = load
+			bestNative = native
 		}
 	}
 
@@ -278,8 +329,17 @@ func (p *Pool) SelectAndAcquire(arch string, selector map[string]string) (*Backe
 
 // SelectAndAcquireWithContext atomically selects a backend and acquires a slot with context for logging.
 // This eliminates the race condition between Select() and Acquire().
+// Like Select, it prefers backends native to the architecture, falling back
+// to emulating backends only when no native backend has a free slot.
 // Returns the backend if successful, or an error if no backend is available.
 func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, selector map[string]string) (*Backend, error) {
+	return p.SelectAndAcquireWithFilter(ctx, arch, selector, nil)
+}
+
+// SelectAndAcquireWithFilter is like SelectAndAcquireWithContext, but only
+// considers backends for which allow, if not nil, returns true. allow is
+// called with the pool locked, and must not call back into the pool.
+func (p *Pool) SelectAndAcquireWithFilter(ctx context.Context, arch string, selector map[string]string, allow func(Backend) bool) (*Backend, error) {
 	log := clog.FromContext(ctx)
 	startTime := time.Now()
 
@@ -294,19 +354,21 @@ func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, sel
 		state   *backendState
 		maxJobs int
 		load    float64
+		native  bool
 	}
 
 	candidates := make([]candidate, 0, len(p.backends))
 
 	// Count filtered backends for logging
-	var totalBackends, archFiltered, selectorFiltered, circuitOpen, atCapacity int
+	var totalBackends, archFiltered, selectorFiltered, disallowed, circuitOpen, atCapacity int
 
 	for i := range p.backends {
 		b := &p.backends[i]
 		totalBackends++
 
 		// Filter by architecture
-		if b.Arch != arch {
+		ok, native := b.supports(arch)
+		if !ok {
 			archFiltered++
 			continue
 		}
@@ -317,11 +379,22 @@ func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, sel
 			continue
 		}
 
+		if allow != nil && !allow(*b) {
+			disallowed++
+			continue
+		}
+
 		state := p.state[b.Addr]
 		if state == nil {
 			continue
 		}
 
+		// A draining backend takes no new jobs
+		if state.draining.Load() {
+			disallowed++
+			continue
+		}
+
 		// Check circuit breaker
 		if state.circuitOpen.Load() {
 			state.mu.Lock()
@@ -351,15 +424,17 @@ func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, sel
 			state:   state,
 			maxJobs: maxJobs,
 			load:    load,
+			native:  native,
 		})
 	}
 
-	// Sort by load (least loaded first) and try to acquire
+	// Sort by nativeness and load (native, least loaded first) and try to acquire
 	for len(candidates) > 0 {
-		// Find the least loaded candidate
+		// Find the least loaded candidate, native backends first
 		bestIdx := 0
 		for i := 1; i < len(candidates); i++ {
-			if candidates[i].load < candidates[bestIdx].load {
+			c, best := candidates[i], candidates[bestIdx]
+			if (c.native && !best.native) || (c.native == best.native && c.load < best.load) {
 				bestIdx = i
 			}
 		}
@@ -377,8 +452,8 @@ func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, sel
 				// Successfully acquired
 				result := *c.backend
 				duration := time.Since(startTime)
-				log.Infof("backend selection: selected %s (load=%.1f%%) in %s (candidates=%d, arch_filtered=%d, circuit_open=%d, at_capacity=%d)",
-					result.Addr, c.load*100, duration, len(candidates), archFiltered, circuitOpen, atCapacity)
+				log.Infof("backend selection: selected %s (load=%.1f%%, native=%t) in %s (candidates=%d, arch_filtered=%d, circuit_open=%d, at_capacity=%d)",
+					result.Addr, c.load*100, c.native, duration, len(candidates), archFiltered, circuitOpen, atCapacity)
 				return &result, nil
 			}
 			// CAS failed, retry
@@ -389,14 +464,23 @@ func (p *Pool) SelectAndAcquireWithContext(ctx context.Context, arch string, sel
 	}
 
 	duration := time.Since(startTime)
-	log.Errorf("backend selection failed in %s: no available backend (total=%d, arch_filtered=%d, selector_filtered=%d, circuit_open=%d, at_capacity=%d)",
-		duration, totalBackends, archFiltered, selectorFiltered, circuitOpen, atCapacity)
+	log.Errorf("backend selection failed in %s: no available backend (total=%d, arch_filtered=%d, selector_filtered=%d, disallowed=%d, circuit_open=%d, at_capacity=%d)",
+		duration, totalBackends, archFiltered, selectorFiltered, disallowed, circuitOpen, atCapacity)
 	return nil, ErrNoAvailableBackend
 }
 
 // Release decrements the active job count and records success/failure.
 // This should be called when a job completes (regardless of outcome).
 func (p *Pool) Release(addr string, success bool) {
+	var drained bool
+	defer func() {
+		// Remove a draining backend once its last job is released, which
+		// needs the write lock.
+		if drained {
+			p.removeIfDrained(addr)
+		}
+	}()
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -406,13 +490,15 @@ func (p *Pool) Release(addr string, success bool) {
 	}
 
 	// Decrement active jobs
-	state.activeJobs.Add(-1)
+	drained = state.activeJobs.Add(-1) == 0 && state.draining.Load()
 
 	if success {
 		// Reset failure count on success
 		state.failures.Store(0)
 		// Close circuit if it was open (half-open -> closed)
-		state.circuitOpen.Store(false)
+		if state.circuitOpen.Swap(false) {
+			p.publishLocked(PoolEventCircuitClosed, addr)
+		}
 	} else {
 		// Increment failure count
 		failures := state.failures.Add(1)
@@ -423,8 +509,19 @@ func (p *Pool) Release(addr string, success bool) {
 		state.mu.Unlock()
 
 		// Open circuit if threshold reached
-		if int(failures) >= p.failureThreshold {
-			state.circuitOpen.Store(true)
+		if int(failures) >= p.failureThreshold && !state.circuitOpen.Swap(true) {
+			p.publishLocked(PoolEventCircuitOpened, addr)
+		}
+	}
+}
+
+// publishLocked publishes an event for the backend with the given address.
+// The caller must hold p.mu.
+func (p *Pool) publishLocked(typ PoolEventType, addr string) {
+	for _, b := range p.backends {
+		if b.Addr == addr {
+			p.publish(typ, b)
+			return
 		}
 	}
 }
@@ -449,21 +546,23 @@ func (p *Pool) List() []Backend {
 	return result
 }
 
-// ListByArch returns all backends for the given architecture.
+// ListByArch returns all backends for the given architecture, including
+// backends that build it under emulation.
 func (p *Pool) ListByArch(arch string) []Backend {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
 	var result []Backend
 	for _, b := range p.backends {
-		if b.Arch == arch {
+		if ok, _ := b.supports(arch); ok {
 			result = append(result, b)
 		}
 	}
 	return result
 }
 
-// Architectures returns a list of unique architectures supported by the pool.
+// Architectures returns a list of unique architectures supported by the pool,
+// natively or under emulation.
 func (p *Pool) Architectures() []string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -471,14 +570,29 @@ func (p *Pool) Architectures() []string {
 	seen := make(map[string]bool)
 	var archs []string
 	for _, b := range p.backends {
-		if !seen[b.Arch] {
-			seen[b.Arch] = true
-			archs = append(archs, b.Arch)
+		for _, arch := range append([]string{b.Arch}, b.EmulatedArchs...) {
+			if !seen[arch] {
+				seen[arch] = true
+				archs = append(archs, arch)
+			}
 		}
 	}
 	return archs
 }
 
+// ReadyArchitectures returns the architectures for which at least one backend
+// can currently accept a job, i.e. has a closed (or half-open) circuit and
+// spare capacity.
+func (p *Pool) ReadyArchitectures() []string {
+	var ready []string
+	for _, arch := range p.Architectures() {
+		if _, err := p.Select(arch, nil); err == nil {
+			ready = append(ready, arch)
+		}
+	}
+	return ready
+}
+
 // Status returns the current status of all backends for observability.
 func (p *Pool) Status() []BackendStatus {
 	p.mu.RLock()
@@ -494,6 +608,7 @@ func (p *Pool) Status() []BackendStatus {
 			status.ActiveJobs = int(state.activeJobs.Load())
 			status.Failures = int(state.failures.Load())
 			status.CircuitOpen = state.circuitOpen.Load()
+			status.Draining = state.draining.Load()
 
 			state.mu.Lock()
 			status.LastFailure = state.lastFailure
@@ -534,9 +649,48 @@ func (p *Pool) Add(backend Backend) error {
 	p.backends = append(p.backends, backend)
 	p.state[backend.Addr] = &backendState{}
 
+	p.publish(PoolEventAdded, backend)
+
 	return nil
 }
 
+// Update replaces the configuration of an existing backend, identified by its
+// address, while preserving its runtime state (active jobs, circuit breaker).
+// Returns an error if the backend is invalid or not found.
+func (p *Pool) Update(backend Backend) error {
+	if backend.Addr == "" {
+		return fmt.Errorf("addr is required")
+	}
+	if backend.Arch == "" {
+		return fmt.Errorf("arch is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, b := range p.backends {
+		if b.Addr == backend.Addr {
+			if backend.Labels == nil {
+				backend.Labels = map[string]string{}
+			}
+			p.backends[i] = backend
+			p.publish(PoolEventUpdated, backend)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", svcerrors.ErrBackendNotFound, backend.Addr)
+}
+
+// MaxJobs returns the number of jobs b may run at once: its MaxJobs, or the
+// default of the pool if it has none.
+func (p *Pool) MaxJobs(b Backend) int {
+	if b.MaxJobs != 0 {
+		return b.MaxJobs
+	}
+	return p.defaultMaxJobs
+}
+
 // TotalCapacity returns the total job capacity across all backends.
 // This is useful for configuring scheduler parallelism.
 func (p *Pool) TotalCapacity() int {
@@ -564,14 +718,67 @@ func (p *Pool) Remove(addr string) error {
 		return fmt.Errorf("cannot remove the last backend")
 	}
 
-	// Find and remove the backend
+	if p.state[addr] == nil {
+		return fmt.Errorf("%w: %s", svcerrors.ErrBackendNotFound, addr)
+	}
+	p.removeLocked(addr)
+	return nil
+}
+
+// DrainBackend stops giving the backend at addr new jobs, and removes it
+// from the pool once the jobs it is running finish, at once if it runs
+// none. Returns an error if the backend is not found, or if no other
+// backend would be left to take jobs.
+func (p *Pool) DrainBackend(addr string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state[addr]
+	if state == nil {
+		return fmt.Errorf("%w: %s", svcerrors.ErrBackendNotFound, addr)
+	}
+	if state.draining.Load() {
+		return nil
+	}
+
+	remaining := 0
+	for _, b := range p.backends {
+		if s := p.state[b.Addr]; b.Addr != addr && s != nil && !s.draining.Load() {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return fmt.Errorf("cannot drain the last backend")
+	}
+
+	state.draining.Store(true)
+	p.publishLocked(PoolEventDraining, addr)
+	if state.activeJobs.Load() == 0 {
+		p.removeLocked(addr)
+	}
+	return nil
+}
+
+// removeIfDrained removes the backend at addr if it is draining and runs
+// no more jobs.
+func (p *Pool) removeIfDrained(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state := p.state[addr]; state != nil && state.draining.Load() && state.activeJobs.Load() == 0 {
+		p.removeLocked(addr)
+	}
+}
+
+// removeLocked removes the backend at addr. The caller must hold p.mu for
+// writing.
+func (p *Pool) removeLocked(addr string) {
 	for i, b := range p.backends {
 		if b.Addr == addr {
 			p.backends = append(p.backends[:i], p.backends[i+1:]...)
 			delete(p.state, addr)
-			return nil
+			p.publish(PoolEventRemoved, b)
+			return
 		}
 	}
-
-	return fmt.Errorf("%w: %s", svcerrors.ErrBackendNotFound, addr)
 }
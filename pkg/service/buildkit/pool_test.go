@@ -15,6 +15,8 @@
 package buildkit
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -22,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -309,6 +312,81 @@ func TestPoolRemoveValidation(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestPoolDrainBackend(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("idle backend is removed at once", func(t *testing.T) {
+		pool, err := NewPool([]Backend{
+			{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+			{Addr: "tcp://amd64-2:1234", Arch: "x86_64"},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, pool.DrainBackend("tcp://amd64-2:1234"))
+		require.Len(t, pool.List(), 1)
+		require.Equal(t, "tcp://amd64-1:1234", pool.List()[0].Addr)
+	})
+
+	t.Run("busy backend is removed when its jobs finish", func(t *testing.T) {
+		pool, err := NewPool([]Backend{
+			{Addr: "tcp://amd64-1:1234", Arch: "x86_64", MaxJobs: 2},
+			{Addr: "tcp://amd64-2:1234", Arch: "x86_64", MaxJobs: 2},
+		})
+		require.NoError(t, err)
+		events, cancel := pool.Subscribe()
+		defer cancel()
+
+		allowSecond := func(b Backend) bool { return b.Addr == "tcp://amd64-2:1234" }
+		for range 2 {
+			_, err := pool.SelectAndAcquireWithFilter(ctx, "x86_64", nil, allowSecond)
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, pool.DrainBackend("tcp://amd64-2:1234"))
+		require.Equal(t, PoolEventDraining, (<-events).Type)
+
+		// The draining backend takes no new jobs, though it has room for
+		// them once a job finishes.
+		pool.Release("tcp://amd64-2:1234", true)
+		for _, s := range pool.Status() {
+			if s.Addr == "tcp://amd64-2:1234" {
+				require.True(t, s.Draining)
+				require.Equal(t, 1, s.ActiveJobs)
+			}
+		}
+		b, err := pool.SelectAndAcquire("x86_64", nil)
+		require.NoError(t, err)
+		require.Equal(t, "tcp://amd64-1:1234", b.Addr)
+
+		pool.Release("tcp://amd64-2:1234", true)
+		require.Len(t, pool.List(), 1)
+		event := <-events
+		require.Equal(t, PoolEventRemoved, event.Type)
+		require.Equal(t, "tcp://amd64-2:1234", event.Backend.Addr)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		pool, err := NewPool([]Backend{
+			{Addr: "tcp://amd64-1:1234", Arch: "x86_64", MaxJobs: 1},
+			{Addr: "tcp://amd64-2:1234", Arch: "x86_64", MaxJobs: 1},
+		})
+		require.NoError(t, err)
+
+		err = pool.DrainBackend("tcp://nonexistent:1234")
+		require.ErrorIs(t, err, ErrBackendNotFound)
+
+		_, err = pool.SelectAndAcquire("x86_64", nil)
+		require.NoError(t, err)
+		_, err = pool.SelectAndAcquire("x86_64", nil)
+		require.NoError(t, err)
+		require.NoError(t, pool.DrainBackend("tcp://amd64-1:1234"))
+
+		// The other backend is the last one left to take jobs.
+		err = pool.DrainBackend("tcp://amd64-2:1234")
+		require.ErrorContains(t, err, "cannot drain the last backend")
+	})
+}
+
 func TestPoolAcquireRelease(t *testing.T) {
 	pool, err := NewPool([]Backend{
 		{Addr: "tcp://backend:1234", Arch: "x86_64", MaxJobs: 2},
@@ -414,6 +492,33 @@ func TestPoolCircuitBreaker(t *testing.T) {
 	pool.Release(backend.Addr, true)
 }
 
+func TestPoolReadyArchitectures(t *testing.T) {
+	pool, err := NewPoolWithConfig(PoolConfig{
+		Backends: []Backend{
+			{Addr: "tcp://amd64:1234", Arch: "x86_64", MaxJobs: 1},
+			{Addr: "tcp://arm64:1234", Arch: "aarch64"},
+		},
+		FailureThreshold: 1,
+		RecoveryTimeout:  time.Hour,
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"x86_64", "aarch64"}, pool.ReadyArchitectures())
+
+	// Open the aarch64 circuit.
+	backend, err := pool.SelectAndAcquire("aarch64", nil)
+	require.NoError(t, err)
+	pool.Release(backend.Addr, false)
+	require.Equal(t, []string{"x86_64"}, pool.ReadyArchitectures())
+
+	// Fill the only x86_64 slot.
+	backend, err = pool.SelectAndAcquire("x86_64", nil)
+	require.NoError(t, err)
+	require.Empty(t, pool.ReadyArchitectures())
+
+	pool.Release(backend.Addr, true)
+	require.Equal(t, []string{"x86_64"}, pool.ReadyArchitectures())
+}
+
 func TestPoolStatus(t *testing.T) {
 	pool, err := NewPool([]Backend{
 		{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 4},
@@ -552,6 +657,73 @@ func TestPoolSelectAndAcquire(t *testing.T) {
 	require.NotNil(t, backend)
 }
 
+func TestPoolSelectAndAcquireWithFilter(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool([]Backend{
+		{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 2},
+		{Addr: "tcp://backend-2:1234", Arch: "x86_64", MaxJobs: 2},
+	})
+	require.NoError(t, err)
+
+	onlyBackend2 := func(b Backend) bool { return b.Addr == "tcp://backend-2:1234" }
+
+	// Only the allowed backend is used, although the other is less loaded
+	for range 2 {
+		backend, err := pool.SelectAndAcquireWithFilter(ctx, "x86_64", nil, onlyBackend2)
+		require.NoError(t, err)
+		require.Equal(t, "tcp://backend-2:1234", backend.Addr)
+	}
+
+	// The allowed backend is full, while the other has free slots
+	_, err = pool.SelectAndAcquireWithFilter(ctx, "x86_64", nil, onlyBackend2)
+	require.ErrorIs(t, err, ErrNoAvailableBackend)
+
+	// Without a filter, the other backend is used
+	backend, err := pool.SelectAndAcquireWithFilter(ctx, "x86_64", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp://backend-1:1234", backend.Addr)
+}
+
+func TestPoolSelectAndAcquirePrefersNative(t *testing.T) {
+	pool, err := NewPool([]Backend{
+		// Listed first and idle, but only emulates aarch64.
+		{Addr: "tcp://amd64:1234", Arch: "x86_64", EmulatedArchs: []string{"aarch64"}, MaxJobs: 4},
+		{Addr: "tcp://arm64:1234", Arch: "aarch64", MaxJobs: 2},
+	})
+	require.NoError(t, err)
+
+	// Native slots are used first, even once the native backend is busier.
+	for i := 0; i < 2; i++ {
+		backend, err := pool.SelectAndAcquire("aarch64", nil)
+		require.NoError(t, err)
+		require.Equal(t, "tcp://arm64:1234", backend.Addr)
+	}
+
+	// With the native backend full, the emulating backend is the fallback.
+	backend, err := pool.SelectAndAcquire("aarch64", nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp://amd64:1234", backend.Addr)
+
+	// Select follows the same preference once a native slot frees up.
+	pool.Release("tcp://arm64:1234", true)
+	backend, err = pool.Select("aarch64", nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp://arm64:1234", backend.Addr)
+
+	// The emulating backend still serves its native architecture.
+	backend, err = pool.SelectAndAcquire("x86_64", nil)
+	require.NoError(t, err)
+	require.Equal(t, "tcp://amd64:1234", backend.Addr)
+
+	require.ElementsMatch(t, []string{"x86_64", "aarch64"}, pool.Architectures())
+	require.Len(t, pool.ListByArch("aarch64"), 2)
+	require.Len(t, pool.ListByArch("x86_64"), 1)
+
+	// Backends that neither run nor emulate an architecture are not used.
+	_, err = pool.SelectAndAcquire("riscv64", nil)
+	require.ErrorIs(t, err, ErrNoAvailableBackend)
+}
+
 func TestPoolConcurrentSelectAndAcquire(t *testing.T) {
 	// Create a pool with 8 total slots across 2 backends
 	pool, err := NewPoolWithConfig(PoolConfig{
@@ -694,3 +866,136 @@ func TestPoolTotalCapacity(t *testing.T) {
 	// Total should be 8 + 4 + 2 (default) = 14
 	require.Equal(t, 14, pool.TotalCapacity())
 }
+
+func TestPoolSubscribe(t *testing.T) {
+	pool, err := NewPoolWithConfig(PoolConfig{
+		Backends: []Backend{
+			{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+		},
+		FailureThreshold: 1,
+	})
+	require.NoError(t, err)
+
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	next := func() PoolEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for pool event")
+			return PoolEvent{}
+		}
+	}
+
+	require.NoError(t, pool.Add(Backend{Addr: "tcp://arm64-1:1234", Arch: "aarch64"}))
+	ev := next()
+	require.Equal(t, PoolEventAdded, ev.Type)
+	require.Equal(t, "tcp://arm64-1:1234", ev.Backend.Addr)
+	require.False(t, ev.Time.IsZero())
+
+	require.NoError(t, pool.Update(Backend{Addr: "tcp://arm64-1:1234", Arch: "aarch64", MaxJobs: 8}))
+	ev = next()
+	require.Equal(t, PoolEventUpdated, ev.Type)
+	require.Equal(t, 8, ev.Backend.MaxJobs)
+	require.Equal(t, 8, pool.ListByArch("aarch64")[0].MaxJobs)
+
+	backend, err := pool.SelectAndAcquire("x86_64", nil)
+	require.NoError(t, err)
+	pool.Release(backend.Addr, false)
+	ev = next()
+	require.Equal(t, PoolEventCircuitOpened, ev.Type)
+	require.Equal(t, "tcp://amd64-1:1234", ev.Backend.Addr)
+
+	// A further failure while open does not emit a duplicate event.
+	pool.state[backend.Addr].activeJobs.Add(1)
+	pool.Release(backend.Addr, false)
+
+	pool.state[backend.Addr].activeJobs.Add(1)
+	pool.Release(backend.Addr, true)
+	ev = next()
+	require.Equal(t, PoolEventCircuitClosed, ev.Type)
+
+	require.NoError(t, pool.Remove("tcp://arm64-1:1234"))
+	ev = next()
+	require.Equal(t, PoolEventRemoved, ev.Type)
+	require.Equal(t, "tcp://arm64-1:1234", ev.Backend.Addr)
+
+	// Failed operations do not emit events.
+	require.Error(t, pool.Remove("tcp://missing:1234"))
+	require.Error(t, pool.Update(Backend{Addr: "tcp://missing:1234", Arch: "x86_64"}))
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event: %+v", ev)
+	default:
+	}
+}
+
+func TestPoolUnsubscribe(t *testing.T) {
+	pool, err := NewPool([]Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+	})
+	require.NoError(t, err)
+
+	events, cancel := pool.Subscribe()
+	other, cancelOther := pool.Subscribe()
+	defer cancelOther()
+
+	cancel()
+	cancel() // idempotent
+
+	_, ok := <-events
+	require.False(t, ok, "channel should be closed after unsubscribe")
+
+	require.NoError(t, pool.Add(Backend{Addr: "tcp://amd64-2:1234", Arch: "x86_64"}))
+	ev := <-other
+	require.Equal(t, PoolEventAdded, ev.Type)
+}
+
+func TestPoolSubscribeSlowConsumer(t *testing.T) {
+	pool, err := NewPool([]Backend{
+		{Addr: "tcp://amd64-0:1234", Arch: "x86_64"},
+	})
+	require.NoError(t, err)
+
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	// Never read from events; the pool must not block.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= SubscriberBufferSize*2; i++ {
+			_ = pool.Add(Backend{Addr: fmt.Sprintf("tcp://amd64-%d:1234", i), Arch: "x86_64"})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool blocked on a slow subscriber")
+	}
+	require.Len(t, events, SubscriberBufferSize)
+	require.Len(t, pool.List(), SubscriberBufferSize*2+1)
+}
+
+func TestPoolRecordAcquireWait(t *testing.T) {
+	pool, err := NewPool([]Backend{
+		{Addr: "tcp://backend-1:1234", Arch: "x86_64"},
+	})
+	require.NoError(t, err)
+
+	pool.RecordAcquireWait("x86_64", 50*time.Millisecond)
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(pool.Collectors()[0]))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	require.Equal(t, "melange_buildkit_backend_acquire_wait_seconds", families[0].GetName())
+	h := families[0].GetMetric()[0].GetHistogram()
+	require.Equal(t, uint64(1), h.GetSampleCount())
+	require.Equal(t, (50 * time.Millisecond).Seconds(), h.GetSampleSum())
+}
@@ -35,14 +35,46 @@ type Client struct {
 	httpClient *http.Client
 }
 
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates the requests of the client with token, as a
+// bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.httpClient.Transport = &tokenTransport{token: token, base: c.httpClient.Transport}
+	}
+}
+
+// tokenTransport sets the Authorization header of requests to a bearer
+// token.
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
 // New creates a new melange service client.
-func New(baseURL string) *Client {
-	return &Client{
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Health checks if the server is healthy.
@@ -180,7 +212,9 @@ func (c *Client) SubmitBuild(ctx context.Context, req types.CreateBuildRequest)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
+	// A repeat request with the same idempotency key returns the existing
+	// build with 200 OK.
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
 	}
@@ -224,7 +258,56 @@ func (c *Client) GetBuild(ctx context.Context, buildID string) (*types.Build, er
 
 // ListBuilds lists all builds.
 func (c *Client) ListBuilds(ctx context.Context) ([]types.Build, error) {
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/builds", nil)
+	return c.listBuilds(ctx, c.baseURL+"/api/v1/builds")
+}
+
+// ListBuildsByPackage lists the builds with a package named name.
+func (c *Client) ListBuildsByPackage(ctx context.Context, name string) ([]types.Build, error) {
+	return c.listBuilds(ctx, c.baseURL+"/api/v1/builds?package="+url.QueryEscape(name))
+}
+
+// BuildStats returns the number of builds in each status. A non-empty name
+// counts only the builds with a package of that name, and a non-zero since
+// only the builds created since then.
+func (c *Client) BuildStats(ctx context.Context, name string, since time.Time) (*types.BuildStatsResponse, error) {
+	q := url.Values{}
+	if name != "" {
+		q.Set("package", name)
+	}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	u := c.baseURL + "/api/v1/builds/stats"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var stats types.BuildStatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &stats, nil
+}
+
+func (c *Client) listBuilds(ctx context.Context, u string) ([]types.Build, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
@@ -37,6 +37,21 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, 30*time.Second, c.httpClient.Timeout)
 }
 
+func TestWithToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithToken("secret"))
+	assert.Equal(t, 30*time.Second, c.httpClient.Timeout)
+	builds, err := c.ListBuilds(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, builds)
+}
+
 func TestHealth(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -388,6 +403,28 @@ func TestListBuilds(t *testing.T) {
 	})
 }
 
+func TestBuildStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/builds/stats", r.URL.Path)
+		assert.Equal(t, "curl", r.URL.Query().Get("package"))
+		assert.Equal(t, "2024-01-02T03:04:05Z", r.URL.Query().Get("since"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(types.BuildStatsResponse{
+			Total:    3,
+			Statuses: map[types.BuildStatus]int{types.BuildStatusSuccess: 2, types.BuildStatusFailed: 1},
+		})
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	stats, err := c.BuildStats(context.Background(), "curl", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 2, stats.Statuses[types.BuildStatusSuccess])
+}
+
 func TestWaitForBuild(t *testing.T) {
 	t.Run("immediate success", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
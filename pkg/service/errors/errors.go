@@ -39,4 +39,8 @@ var (
 
 	// ErrPackageNotFound is returned when a package job does not exist.
 	ErrPackageNotFound = errors.New("package not found")
+
+	// ErrNoTenant is returned when an operation scoped to a tenant is
+	// given none.
+	ErrNoTenant = errors.New("no tenant given")
 )
@@ -199,6 +199,17 @@ func (m *MelangeMetrics) UpdateBackendMetrics(total, available int, activeJobs m
 	}
 }
 
+// Register adds collectors kept elsewhere, such as the metrics of the
+// BuildKit pool, to the metrics served by m.
+func (m *MelangeMetrics) Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RecordStorageSync records a storage sync operation.
 func (m *MelangeMetrics) RecordStorageSync(backend string, durationSeconds float64) {
 	m.StorageSyncDurationSeconds.WithLabelValues(backend).Observe(durationSeconds)
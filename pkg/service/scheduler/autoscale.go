@@ -0,0 +1,215 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+	"github.com/dlorenc/melange2/pkg/service/types"
+)
+
+// DefaultAutoscaleInterval is how often RunAutoscaler calls the autoscaler
+// unless WithAutoscaler sets an interval.
+const DefaultAutoscaleInterval = 30 * time.Second
+
+// PoolMetrics describes the demand on the pool and its use, as passed to an
+// Autoscaler.
+type PoolMetrics struct {
+	// QueueDepth is the number of packages of active builds waiting to
+	// build, pending or blocked on their dependencies, by architecture.
+	QueueDepth map[string]int `json:"queueDepth"`
+	// Utilization is the fraction of the job slots of the backends native
+	// to each architecture that are in use, from 0 to 1.
+	Utilization map[string]float64 `json:"utilization"`
+	// Backends is the status of each backend of the pool.
+	Backends []buildkit.BackendStatus `json:"backends"`
+}
+
+// Autoscaler adapts the pool to the demand on it, typically by adding
+// backends with Pool.Add when packages queue up and retiring idle ones with
+// Pool.DrainBackend.
+type Autoscaler interface {
+	// Autoscale is called periodically with the current metrics of pool.
+	// An error is logged; it does not stop later calls.
+	Autoscale(ctx context.Context, pool *buildkit.Pool, metrics PoolMetrics) error
+}
+
+// NopAutoscaler is an Autoscaler that leaves the pool as it is. It is the
+// autoscaler of a scheduler unless WithAutoscaler sets another.
+type NopAutoscaler struct{}
+
+// Autoscale does nothing.
+func (NopAutoscaler) Autoscale(context.Context, *buildkit.Pool, PoolMetrics) error {
+	return nil
+}
+
+// AutoscaleDecision is the answer of the endpoint of a WebhookAutoscaler:
+// the backends to add to the pool, and the addresses of those to drain.
+type AutoscaleDecision struct {
+	Add   []buildkit.Backend `json:"add,omitempty"`
+	Drain []string           `json:"drain,omitempty"`
+}
+
+// WebhookAutoscaler is an Autoscaler that leaves the decisions to an HTTP
+// endpoint, typically provided by the infrastructure that provisions
+// backends. Each call POSTs the PoolMetrics as JSON to URL, and applies the
+// AutoscaleDecision it answers with.
+type WebhookAutoscaler struct {
+	URL string
+	// Client sends the requests; http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Autoscale asks the endpoint what to do with pool, and does it. Every
+// backend of the decision is added or drained even if another fails.
+func (w *WebhookAutoscaler) Autoscale(ctx context.Context, pool *buildkit.Pool, metrics PoolMetrics) error {
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("encoding pool metrics: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling autoscaler webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("autoscaler webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var decision AutoscaleDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decoding autoscaler webhook response: %w", err)
+	}
+
+	log := clog.FromContext(ctx)
+	var errs []error
+	for _, b := range decision.Add {
+		if err := pool.Add(b); err != nil {
+			errs = append(errs, fmt.Errorf("adding backend %s: %w", b.Addr, err))
+			continue
+		}
+		log.Infof("autoscaler added backend %s (%s)", b.Addr, b.Arch)
+	}
+	for _, addr := range decision.Drain {
+		if err := pool.DrainBackend(addr); err != nil {
+			errs = append(errs, fmt.Errorf("draining backend %s: %w", addr, err))
+			continue
+		}
+		log.Infof("autoscaler draining backend %s", addr)
+	}
+	return errors.Join(errs...)
+}
+
+// WithAutoscaler sets the autoscaler RunAutoscaler calls every interval, or
+// every DefaultAutoscaleInterval if interval is zero.
+func WithAutoscaler(a Autoscaler, interval time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.autoscaler = a
+		if interval > 0 {
+			s.autoscaleInterval = interval
+		}
+	}
+}
+
+// RunAutoscaler calls the autoscaler with the metrics of the pool
+// periodically, until ctx is cancelled. It returns at once if the scheduler
+// has no autoscaler but the NopAutoscaler.
+// This should be called in a separate goroutine.
+func (s *Scheduler) RunAutoscaler(ctx context.Context) error {
+	if _, ok := s.autoscaler.(NopAutoscaler); ok {
+		return nil
+	}
+
+	log := clog.FromContext(ctx)
+	ticker := time.NewTicker(s.autoscaleInterval)
+	defer ticker.Stop()
+
+	log.Infof("pool autoscaler started: interval=%s", s.autoscaleInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := s.autoscale(ctx); err != nil {
+				log.Errorf("autoscaling pool: %v", err)
+			}
+		}
+	}
+}
+
+// autoscale calls the autoscaler once with the current metrics of the pool.
+func (s *Scheduler) autoscale(ctx context.Context) error {
+	metrics, err := s.poolMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	return s.autoscaler.Autoscale(ctx, s.pool, metrics)
+}
+
+// poolMetrics returns the current metrics of the pool.
+func (s *Scheduler) poolMetrics(ctx context.Context) (PoolMetrics, error) {
+	builds, err := s.buildStore.ListActiveBuilds(ctx)
+	if err != nil {
+		return PoolMetrics{}, fmt.Errorf("listing active builds: %w", err)
+	}
+
+	m := PoolMetrics{
+		QueueDepth:  make(map[string]int),
+		Utilization: make(map[string]float64),
+		Backends:    s.pool.Status(),
+	}
+	for _, build := range builds {
+		arch := specArch(build.Spec)
+		for _, pkg := range build.Packages {
+			if pkg.Status == types.PackageStatusPending || pkg.Status == types.PackageStatusBlocked {
+				m.QueueDepth[arch]++
+			}
+		}
+	}
+
+	active, capacity := make(map[string]int), make(map[string]int)
+	for _, b := range m.Backends {
+		active[b.Arch] += b.ActiveJobs
+		capacity[b.Arch] += s.pool.MaxJobs(b.Backend)
+	}
+	for arch, c := range capacity {
+		if c > 0 {
+			m.Utilization[arch] = float64(active[arch]) / float64(c)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+	"github.com/dlorenc/melange2/pkg/service/dag"
+	"github.com/dlorenc/melange2/pkg/service/storage"
+	"github.com/dlorenc/melange2/pkg/service/store"
+	"github.com/dlorenc/melange2/pkg/service/types"
+)
+
+// queueAutoscaler adds a backend for an architecture whenever more than
+// threshold of its packages are queued, up to max backends.
+type queueAutoscaler struct {
+	threshold int
+	max       int
+	added     int
+	calls     chan PoolMetrics
+}
+
+func (a *queueAutoscaler) Autoscale(ctx context.Context, pool *buildkit.Pool, m PoolMetrics) error {
+	defer func() {
+		select {
+		case a.calls <- m:
+		default:
+		}
+	}()
+	for arch, depth := range m.QueueDepth {
+		if depth > a.threshold && len(pool.ListByArch(arch)) < a.max {
+			a.added++
+			if err := pool.Add(buildkit.Backend{Addr: fmt.Sprintf("tcp://%s-scaled-%d:1234", arch, a.added), Arch: arch}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestScheduler_Autoscaler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, err := buildkit.NewPool([]buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64", MaxJobs: 2},
+		{Addr: "tcp://arm64-1:1234", Arch: "aarch64", MaxJobs: 2},
+	})
+	require.NoError(t, err)
+	localStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	buildStore := store.NewMemoryBuildStore()
+
+	// A synthetic backlog of ten x86_64 packages, and one aarch64 package.
+	var nodes []dag.Node
+	for i := range 10 {
+		nodes = append(nodes, dag.Node{Name: fmt.Sprintf("pkg-%d", i)})
+	}
+	_, err = buildStore.CreateBuild(ctx, store.DefaultTenant, nodes, types.BuildSpec{Arch: "x86_64"})
+	require.NoError(t, err)
+	_, err = buildStore.CreateBuild(ctx, store.DefaultTenant, []dag.Node{{Name: "arm"}}, types.BuildSpec{Arch: "aarch64"})
+	require.NoError(t, err)
+
+	// One of the two x86_64 slots is in use.
+	_, err = pool.SelectAndAcquire("x86_64", nil)
+	require.NoError(t, err)
+
+	a := &queueAutoscaler{threshold: 5, max: 2, calls: make(chan PoolMetrics, 1)}
+	s := New(buildStore, localStorage, pool, Config{OutputDir: t.TempDir()}, WithAutoscaler(a, 10*time.Millisecond))
+
+	done := make(chan error)
+	go func() { done <- s.RunAutoscaler(ctx) }()
+
+	select {
+	case m := <-a.calls:
+		require.Equal(t, map[string]int{"x86_64": 10, "aarch64": 1}, m.QueueDepth)
+		require.Equal(t, map[string]float64{"x86_64": 0.5, "aarch64": 0}, m.Utilization)
+		require.Len(t, m.Backends, 2)
+	case <-time.After(5 * time.Second):
+		t.Fatal("autoscaler was not called")
+	}
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	// Only the architecture with a deep queue was scaled up.
+	require.Len(t, pool.ListByArch("x86_64"), 2)
+	require.Len(t, pool.ListByArch("aarch64"), 1)
+}
+
+func TestScheduler_NopAutoscaler(t *testing.T) {
+	s := newTestScheduler(t, Config{})
+	require.Equal(t, NopAutoscaler{}, s.autoscaler)
+
+	// With no autoscaler, RunAutoscaler returns at once.
+	require.NoError(t, s.RunAutoscaler(context.Background()))
+}
+
+func TestWebhookAutoscaler(t *testing.T) {
+	ctx := context.Background()
+
+	pool, err := buildkit.NewPool([]buildkit.Backend{
+		{Addr: "tcp://amd64-1:1234", Arch: "x86_64"},
+		{Addr: "tcp://amd64-2:1234", Arch: "x86_64"},
+	})
+	require.NoError(t, err)
+
+	var got PoolMetrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		require.NoError(t, json.NewEncoder(w).Encode(AutoscaleDecision{
+			Add:   []buildkit.Backend{{Addr: "tcp://arm64-1:1234", Arch: "aarch64"}},
+			Drain: []string{"tcp://amd64-2:1234", "tcp://unknown:1234"},
+		}))
+	}))
+	defer srv.Close()
+
+	a := &WebhookAutoscaler{URL: srv.URL}
+	err = a.Autoscale(ctx, pool, PoolMetrics{QueueDepth: map[string]int{"aarch64": 12}, Backends: pool.Status()})
+	// The backend that cannot be drained is reported, but does not stop the
+	// others.
+	require.ErrorContains(t, err, "draining backend tcp://unknown:1234")
+	require.Equal(t, map[string]int{"aarch64": 12}, got.QueueDepth)
+	require.Len(t, got.Backends, 2)
+
+	require.Len(t, pool.ListByArch("aarch64"), 1)
+	// The idle backend is removed as soon as it is drained.
+	require.Len(t, pool.ListByArch("x86_64"), 1)
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "provisioner unavailable", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		a := &WebhookAutoscaler{URL: srv.URL}
+		require.ErrorContains(t, a.Autoscale(ctx, pool, PoolMetrics{}), "503 Service Unavailable: provisioner unavailable")
+	})
+
+	t.Run("nothing to do", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+
+		a := &WebhookAutoscaler{URL: srv.URL}
+		require.NoError(t, a.Autoscale(ctx, pool, PoolMetrics{}))
+	})
+}
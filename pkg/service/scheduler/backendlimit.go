@@ -0,0 +1,89 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+)
+
+// backendLimiter limits how many packages of one build run on each backend
+// at once, below the capacity of the backend in the pool.
+type backendLimiter struct {
+	limit int
+
+	mu sync.Mutex
+	// active counts the packages of the build running on each backend.
+	active map[string]int
+	// released is closed, and replaced, when a package releases its
+	// backend.
+	released chan struct{}
+}
+
+func newBackendLimiter(limit int) *backendLimiter {
+	return &backendLimiter{
+		limit:    limit,
+		active:   make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire selects and acquires a backend from pool, as SelectAndAcquire
+// does, on which fewer than limit packages of the build run. While every
+// backend the build may use is taken, it waits for a package of the build
+// to release its backend, so that the limit does not fail packages the pool
+// has room for.
+func (l *backendLimiter) acquire(ctx context.Context, pool *buildkit.Pool, arch string, selector map[string]string) (*buildkit.Backend, error) {
+	for {
+		l.mu.Lock()
+		backend, err := pool.SelectAndAcquireWithFilter(ctx, arch, selector, func(b buildkit.Backend) bool {
+			return l.active[b.Addr] < l.limit
+		})
+		if err == nil {
+			l.active[backend.Addr]++
+			l.mu.Unlock()
+			return backend, nil
+		}
+		running, released := len(l.active) > 0, l.released
+		l.mu.Unlock()
+
+		// With no package of the build running, the limit excluded no
+		// backend, and waiting would not help.
+		if !errors.Is(err, buildkit.ErrNoAvailableBackend) || !running {
+			return nil, err
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release records that a package of the build no longer runs on the
+// backend at addr. The backend must also be released to the pool.
+func (l *backendLimiter) release(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[addr]--; l.active[addr] <= 0 {
+		delete(l.active, addr)
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+)
+
+func TestBackendLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	activeJobs := func(pool *buildkit.Pool) map[string]int {
+		active := map[string]int{}
+		for _, s := range pool.Status() {
+			active[s.Addr] = s.ActiveJobs
+		}
+		return active
+	}
+
+	t.Run("limit is respected while the pool has capacity", func(t *testing.T) {
+		pool, err := buildkit.NewPool([]buildkit.Backend{
+			{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 4},
+		})
+		require.NoError(t, err)
+		l := newBackendLimiter(2)
+
+		for range 2 {
+			_, err := l.acquire(ctx, pool, "x86_64", nil)
+			require.NoError(t, err)
+		}
+
+		// A third package waits, although the backend has two free slots.
+		acquired := make(chan *buildkit.Backend)
+		go func() {
+			backend, err := l.acquire(ctx, pool, "x86_64", nil)
+			if err != nil {
+				backend = nil
+			}
+			acquired <- backend
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("acquired a backend beyond the limit of the build")
+		case <-time.After(100 * time.Millisecond):
+		}
+		require.Equal(t, map[string]int{"tcp://backend-1:1234": 2}, activeJobs(pool))
+
+		// It runs once a package of the build finishes.
+		pool.Release("tcp://backend-1:1234", true)
+		l.release("tcp://backend-1:1234")
+		select {
+		case backend := <-acquired:
+			require.NotNil(t, backend)
+			require.Equal(t, "tcp://backend-1:1234", backend.Addr)
+		case <-time.After(5 * time.Second):
+			t.Fatal("package did not run after a package of the build finished")
+		}
+		require.Equal(t, map[string]int{"tcp://backend-1:1234": 2}, activeJobs(pool))
+	})
+
+	t.Run("packages spread across backends", func(t *testing.T) {
+		pool, err := buildkit.NewPool([]buildkit.Backend{
+			{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 4},
+			{Addr: "tcp://backend-2:1234", Arch: "x86_64", MaxJobs: 4},
+		})
+		require.NoError(t, err)
+		l := newBackendLimiter(1)
+
+		// Another build fills backend-1 more than backend-2, so that
+		// without the limit both packages would go to backend-2.
+		_, err = pool.SelectAndAcquireWithFilter(ctx, "x86_64", nil, func(b buildkit.Backend) bool { return b.Addr == "tcp://backend-1:1234" })
+		require.NoError(t, err)
+
+		for range 2 {
+			_, err := l.acquire(ctx, pool, "x86_64", nil)
+			require.NoError(t, err)
+		}
+		require.Equal(t, map[string]int{"tcp://backend-1:1234": 2, "tcp://backend-2:1234": 1}, activeJobs(pool))
+	})
+
+	t.Run("full pool fails without waiting", func(t *testing.T) {
+		pool, err := buildkit.NewPool([]buildkit.Backend{
+			{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 1},
+		})
+		require.NoError(t, err)
+		_, err = pool.SelectAndAcquire("x86_64", nil)
+		require.NoError(t, err)
+
+		_, err = newBackendLimiter(1).acquire(ctx, pool, "x86_64", nil)
+		require.ErrorIs(t, err, buildkit.ErrNoAvailableBackend)
+	})
+
+	t.Run("waiting stops with the context", func(t *testing.T) {
+		pool, err := buildkit.NewPool([]buildkit.Backend{
+			{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 4},
+		})
+		require.NoError(t, err)
+		l := newBackendLimiter(1)
+		_, err = l.acquire(ctx, pool, "x86_64", nil)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = l.acquire(ctx, pool, "x86_64", nil)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// DefaultBuildLogMaxBytes is the size at which a package build log is
+	// rotated when Config.BuildLogMaxBytes is zero.
+	DefaultBuildLogMaxBytes = 100 << 20
+	// DefaultBuildLogMaxFiles is how many rotated build logs are kept when
+	// Config.BuildLogMaxFiles is zero.
+	DefaultBuildLogMaxFiles = 3
+)
+
+// rotatingLog is a log file that is rotated when it would grow past
+// maxBytes. The current log is always written to path; rotated logs are
+// renamed to path.1 (the most recent) through path.<maxFiles>, and older
+// ones are removed. A maxBytes of zero or less disables rotation.
+type rotatingLog struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	rotated int
+}
+
+// createRotatingLog creates, or truncates, the log file at path.
+func createRotatingLog(path string, maxBytes int64, maxFiles int) (*rotatingLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingLog{path: path, maxBytes: maxBytes, maxFiles: maxFiles, f: f}, nil
+}
+
+// Write writes p to the current log, rotating it first if p would take it
+// past maxBytes. A single write is never split across files.
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating build log: %w", err)
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated logs up by one, moves the current log to
+// path.1, and starts a new, empty log.
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	if l.maxFiles <= 0 {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+	} else {
+		// The oldest log, if there are already maxFiles, is overwritten.
+		for i := min(l.rotated, l.maxFiles-1); i > 0; i-- {
+			if err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1)); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.rotatedPath(1)); err != nil {
+			return err
+		}
+		l.rotated = min(l.rotated+1, l.maxFiles)
+	}
+
+	f, err := os.Create(l.path)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.size = 0
+	return nil
+}
+
+func (l *rotatingLog) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// RotatedPaths returns the paths of the rotated logs that are kept, most
+// recent first.
+func (l *rotatingLog) RotatedPaths() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	paths := make([]string, 0, l.rotated)
+	for i := 1; i <= l.rotated; i++ {
+		paths = append(paths, l.rotatedPath(i))
+	}
+	return paths
+}
+
+// Close closes the current log.
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
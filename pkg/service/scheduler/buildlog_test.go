@@ -0,0 +1,101 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingLog(t *testing.T) {
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// writeLines writes four 9-byte lines, "aaaaaaaa\n" through "dddddddd\n",
+	// through a writer that also copies to a buffer standing in for stderr.
+	writeLines := func(t *testing.T, l *rotatingLog) string {
+		t.Helper()
+		var stderr bytes.Buffer
+		w := io.MultiWriter(&stderr, l)
+		for _, c := range "abcd" {
+			_, err := fmt.Fprintln(w, strings.Repeat(string(c), 8))
+			require.NoError(t, err)
+		}
+		require.NoError(t, l.Close())
+		return stderr.String()
+	}
+
+	t.Run("rotates past the limit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "build.log")
+		l, err := createRotatingLog(path, 10, 2)
+		require.NoError(t, err)
+
+		stderr := writeLines(t, l)
+
+		// Nothing is lost on stderr, and the most recent lines are kept.
+		require.Equal(t, "aaaaaaaa\nbbbbbbbb\ncccccccc\ndddddddd\n", stderr)
+		require.Equal(t, "dddddddd\n", readFile(t, path))
+		require.Equal(t, "cccccccc\n", readFile(t, path+".1"))
+		require.Equal(t, "bbbbbbbb\n", readFile(t, path+".2"))
+		require.NoFileExists(t, path+".3")
+		require.Equal(t, []string{path + ".1", path + ".2"}, l.RotatedPaths())
+	})
+
+	t.Run("keeps several writes per file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "build.log")
+		l, err := createRotatingLog(path, 20, 3)
+		require.NoError(t, err)
+
+		writeLines(t, l)
+
+		require.Equal(t, "cccccccc\ndddddddd\n", readFile(t, path))
+		require.Equal(t, "aaaaaaaa\nbbbbbbbb\n", readFile(t, path+".1"))
+		require.Equal(t, []string{path + ".1"}, l.RotatedPaths())
+	})
+
+	t.Run("keeps no rotated files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "build.log")
+		l, err := createRotatingLog(path, 10, -1)
+		require.NoError(t, err)
+
+		writeLines(t, l)
+
+		require.Equal(t, "dddddddd\n", readFile(t, path))
+		require.NoFileExists(t, path+".1")
+		require.Empty(t, l.RotatedPaths())
+	})
+
+	t.Run("rotation disabled", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "build.log")
+		l, err := createRotatingLog(path, -1, 3)
+		require.NoError(t, err)
+
+		writeLines(t, l)
+
+		require.Equal(t, "aaaaaaaa\nbbbbbbbb\ncccccccc\ndddddddd\n", readFile(t, path))
+		require.Empty(t, l.RotatedPaths())
+	})
+}
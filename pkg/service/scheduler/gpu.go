@@ -0,0 +1,59 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+	"github.com/dlorenc/melange2/pkg/service/types"
+)
+
+// gpuLabel is the label of the backends with a GPU, whose value names the
+// GPU, as in "gpu=nvidia".
+const gpuLabel = "gpu"
+
+// backendSelector returns the labels the backend building the package
+// configured by cfg must have: those of the build, and the GPU the package
+// requests in its resources. It fails if no backend for arch has the GPU, as
+// the package could never run.
+func (s *Scheduler) backendSelector(arch string, cfg *config.Configuration, spec types.BuildSpec) (map[string]string, error) {
+	var gpu string
+	if cfg.Package.Resources != nil {
+		gpu = cfg.Package.Resources.GPU
+	}
+	if gpu == "" {
+		return spec.BackendSelector, nil
+	}
+	if v, ok := spec.BackendSelector[gpuLabel]; ok && v != gpu {
+		return nil, fmt.Errorf("package requests gpu %q, but the build selects backends with %s=%s", gpu, gpuLabel, v)
+	}
+
+	if !slices.ContainsFunc(s.pool.ListByArch(arch), func(b buildkit.Backend) bool {
+		return b.Labels[gpuLabel] == gpu
+	}) {
+		return nil, fmt.Errorf("package requests gpu %q, but no %s backend has the label %s=%s", gpu, arch, gpuLabel, gpu)
+	}
+
+	selector := maps.Clone(spec.BackendSelector)
+	if selector == nil {
+		selector = make(map[string]string, 1)
+	}
+	selector[gpuLabel] = gpu
+	return selector, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dlorenc/melange2/pkg/config"
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+	"github.com/dlorenc/melange2/pkg/service/storage"
+	"github.com/dlorenc/melange2/pkg/service/store"
+	"github.com/dlorenc/melange2/pkg/service/types"
+)
+
+func TestScheduler_GPUBackendSelection(t *testing.T) {
+	ctx := context.Background()
+
+	newScheduler := func(t *testing.T, backends ...buildkit.Backend) *Scheduler {
+		t.Helper()
+		pool, err := buildkit.NewPool(backends)
+		require.NoError(t, err)
+		localStorage, err := storage.NewLocalStorage(t.TempDir())
+		require.NoError(t, err)
+		return New(store.NewMemoryBuildStore(), localStorage, pool, Config{OutputDir: t.TempDir()})
+	}
+
+	parse := func(configYAML string) *config.Configuration {
+		cfg, err := config.ParseConfigurationFromReader(ctx, strings.NewReader(configYAML))
+		require.NoError(t, err)
+		return cfg
+	}
+	gpuPkg := parse(`
+package:
+  name: cuda-kernels
+  version: 1.0.0
+  resources:
+    cpu: "8"
+    gpu: nvidia
+`)
+	plainPkg := parse(`
+package:
+  name: hello
+  version: 1.0.0
+`)
+
+	t.Run("a GPU package selects a labeled backend", func(t *testing.T) {
+		s := newScheduler(t,
+			buildkit.Backend{Addr: "tcp://cpu:1234", Arch: "x86_64", Labels: map[string]string{"tier": "standard"}},
+			buildkit.Backend{Addr: "tcp://gpu:1234", Arch: "x86_64", Labels: map[string]string{"tier": "standard", "gpu": "nvidia"}},
+		)
+		spec := types.BuildSpec{BackendSelector: map[string]string{"tier": "standard"}}
+
+		selector, err := s.backendSelector("x86_64", gpuPkg, spec)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"tier": "standard", "gpu": "nvidia"}, selector)
+		require.Equal(t, map[string]string{"tier": "standard"}, spec.BackendSelector)
+
+		for range 3 {
+			backend, err := s.acquireBackend(ctx, nil, "x86_64", selector, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, "tcp://gpu:1234", backend.Addr)
+		}
+	})
+
+	t.Run("other packages keep the selector of the build", func(t *testing.T) {
+		s := newScheduler(t, buildkit.Backend{Addr: "tcp://cpu:1234", Arch: "x86_64"})
+		spec := types.BuildSpec{BackendSelector: map[string]string{"tier": "standard"}}
+
+		selector, err := s.backendSelector("x86_64", plainPkg, spec)
+		require.NoError(t, err)
+		require.Equal(t, spec.BackendSelector, selector)
+	})
+
+	t.Run("no GPU backend for the arch", func(t *testing.T) {
+		s := newScheduler(t,
+			buildkit.Backend{Addr: "tcp://cpu:1234", Arch: "x86_64"},
+			buildkit.Backend{Addr: "tcp://gpu:1234", Arch: "aarch64", Labels: map[string]string{"gpu": "nvidia"}},
+			buildkit.Backend{Addr: "tcp://amd:1234", Arch: "x86_64", Labels: map[string]string{"gpu": "amd"}},
+		)
+
+		_, err := s.backendSelector("x86_64", gpuPkg, types.BuildSpec{})
+		require.EqualError(t, err, `package requests gpu "nvidia", but no x86_64 backend has the label gpu=nvidia`)
+	})
+
+	t.Run("conflicting selector", func(t *testing.T) {
+		s := newScheduler(t, buildkit.Backend{Addr: "tcp://gpu:1234", Arch: "x86_64", Labels: map[string]string{"gpu": "nvidia"}})
+
+		_, err := s.backendSelector("x86_64", gpuPkg, types.BuildSpec{BackendSelector: map[string]string{"gpu": "amd"}})
+		require.ErrorContains(t, err, "the build selects backends with gpu=amd")
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -34,6 +35,8 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/dlorenc/melange2/pkg/build"
+	"github.com/dlorenc/melange2/pkg/cache"
+	"github.com/dlorenc/melange2/pkg/config"
 	"github.com/dlorenc/melange2/pkg/service/buildkit"
 	"github.com/dlorenc/melange2/pkg/service/metrics"
 	"github.com/dlorenc/melange2/pkg/service/storage"
@@ -49,7 +52,8 @@ type Config struct {
 	// PollInterval is how often to check for new builds.
 	PollInterval time.Duration
 	// MaxParallel is the maximum number of concurrent package builds.
-	// Defaults to number of CPUs.
+	// Defaults to the total capacity of the pool, which follows backends
+	// as they are added to and removed from it, such as by an autoscaler.
 	MaxParallel int
 	// CacheRegistry is the registry URL for BuildKit cache.
 	// If empty, caching is disabled.
@@ -85,6 +89,17 @@ type Config struct {
 	// client-provided environment variables.
 	// Example: {"GITHUB_TOKEN": "ghp_xxx"}
 	SecretEnv map[string]string
+	// BuildKitDialTimeout bounds how long to wait for a backend to respond
+	// when connecting. Defaults to buildkit.DefaultDialTimeout if zero.
+	BuildKitDialTimeout time.Duration
+	// BuildLogMaxBytes is the size at which a package's build log is
+	// rotated. Defaults to DefaultBuildLogMaxBytes if zero; a negative value
+	// disables rotation.
+	BuildLogMaxBytes int64
+	// BuildLogMaxFiles is how many rotated build logs are kept per package.
+	// Defaults to DefaultBuildLogMaxFiles if zero; a negative value keeps
+	// none.
+	BuildLogMaxFiles int
 }
 
 // Scheduler processes builds.
@@ -94,13 +109,20 @@ type Scheduler struct {
 	pool       *buildkit.Pool
 	config     Config
 	metrics    *metrics.MelangeMetrics
+	// parseCache parses package configurations, reusing those parsed by
+	// earlier attempts of a package if set.
+	parseCache *config.ParseCache
 
-	// sem is a semaphore for limiting concurrent builds
-	sem chan struct{}
+	// slots limits concurrent package builds
+	slots *buildSlots
 	// buildMu protects concurrent build processing
 	buildMu sync.Mutex
 	// activeBuilds tracks which builds are being processed
 	activeBuilds map[string]bool
+
+	// autoscaler is called by RunAutoscaler every autoscaleInterval.
+	autoscaler        Autoscaler
+	autoscaleInterval time.Duration
 }
 
 // SchedulerOption configures a Scheduler.
@@ -113,6 +135,14 @@ func WithMetrics(m *metrics.MelangeMetrics) SchedulerOption {
 	}
 }
 
+// WithParseCache reuses parsed package configurations through cache, so
+// that retrying a package does not parse its configuration again.
+func WithParseCache(cache *config.ParseCache) SchedulerOption {
+	return func(s *Scheduler) {
+		s.parseCache = cache
+	}
+}
+
 // New creates a new scheduler.
 func New(buildStore store.BuildStore, storageBackend storage.Storage, pool *buildkit.Pool, config Config, opts ...SchedulerOption) *Scheduler {
 	if config.PollInterval == 0 {
@@ -121,21 +151,21 @@ func New(buildStore store.BuildStore, storageBackend storage.Storage, pool *buil
 	if config.OutputDir == "" {
 		config.OutputDir = "/var/lib/melange/output"
 	}
-	if config.MaxParallel == 0 {
-		// Default to pool's total capacity for optimal throughput.
-		// Falls back to NumCPU if pool capacity is somehow 0.
-		config.MaxParallel = pool.TotalCapacity()
-		if config.MaxParallel == 0 {
-			config.MaxParallel = runtime.NumCPU()
-		}
+	if config.BuildLogMaxBytes == 0 {
+		config.BuildLogMaxBytes = DefaultBuildLogMaxBytes
+	}
+	if config.BuildLogMaxFiles == 0 {
+		config.BuildLogMaxFiles = DefaultBuildLogMaxFiles
 	}
 	s := &Scheduler{
-		buildStore:   buildStore,
-		storage:      storageBackend,
-		pool:         pool,
-		config:       config,
-		sem:          make(chan struct{}, config.MaxParallel),
-		activeBuilds: make(map[string]bool),
+		buildStore:        buildStore,
+		storage:           storageBackend,
+		pool:              pool,
+		config:            config,
+		slots:             newBuildSlots(config.MaxParallel, pool),
+		activeBuilds:      make(map[string]bool),
+		autoscaler:        NopAutoscaler{},
+		autoscaleInterval: DefaultAutoscaleInterval,
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -270,13 +300,19 @@ func (s *Scheduler) processBuild(ctx context.Context, build *types.Build) {
 		}
 	}
 
+	// Limit the packages of the build on each backend, if asked to
+	var limiter *backendLimiter
+	if build.Spec.MaxParallelPerBackend > 0 {
+		limiter = newBackendLimiter(build.Spec.MaxParallelPerBackend)
+	}
+
 	// Process packages until no more are ready
 	var wg sync.WaitGroup
 	for {
-		// Try to acquire a semaphore slot
-		select {
-		case s.sem <- struct{}{}:
-		case <-ctx.Done():
+		// Try to acquire a slot. A package waits for a backend from here
+		// until one is acquired for it.
+		requested := time.Now()
+		if err := s.slots.acquire(ctx); err != nil {
 			wg.Wait()
 			return
 		}
@@ -284,12 +320,12 @@ func (s *Scheduler) processBuild(ctx context.Context, build *types.Build) {
 		// Try to claim a ready package
 		pkg, err := s.buildStore.ClaimReadyPackage(ctx, build.ID)
 		if err != nil {
-			<-s.sem // Release slot
+			s.slots.release()
 			log.Errorf("error claiming package for build %s: %v", build.ID, err)
 			break
 		}
 		if pkg == nil {
-			<-s.sem // Release slot
+			s.slots.release()
 			// No ready packages, check if we're done
 			break
 		}
@@ -298,8 +334,8 @@ func (s *Scheduler) processBuild(ctx context.Context, build *types.Build) {
 		wg.Add(1)
 		go func(p *types.PackageJob) {
 			defer wg.Done()
-			defer func() { <-s.sem }()
-			s.executePackageBuild(ctx, build.ID, p)
+			defer s.slots.release()
+			s.executePackageBuild(ctx, build.ID, p, limiter, requested)
 		}(pkg)
 	}
 
@@ -318,11 +354,13 @@ func (s *Scheduler) processBuild(ctx context.Context, build *types.Build) {
 	}
 
 	// Update final build status
-	s.updateBuildStatus(ctx, build.ID)
+	s.updateBuildStatus(ctx, build.Tenant, build.ID)
 }
 
 // executePackageBuild executes a single package build within a multi-package build.
-func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg *types.PackageJob) {
+// If limiter is not nil, it limits the packages of the build on each backend.
+// requested is when the package asked for a scheduler slot.
+func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg *types.PackageJob, limiter *backendLimiter, requested time.Time) {
 	ctx, span := tracing.StartSpan(ctx, "scheduler.executePackageBuild",
 		trace.WithAttributes(
 			attribute.String("build_id", buildID),
@@ -337,7 +375,7 @@ func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg
 	log.Infof("building package %s in build %s", pkg.Name, buildID)
 
 	// Get the build spec for common options
-	build, err := s.buildStore.GetBuild(ctx, buildID)
+	build, err := s.buildStore.GetBuild(ctx, pkg.Tenant, buildID)
 	if err != nil {
 		log.Errorf("failed to get build %s: %v", buildID, err)
 		tracing.RecordError(ctx, err)
@@ -349,7 +387,7 @@ func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg
 	jobID := fmt.Sprintf("%s-%s", buildID, pkg.Name)
 
 	// Execute the build
-	buildErr := s.executePackageJob(ctx, jobID, pkg, build.Spec)
+	buildErr := s.executePackageJob(ctx, jobID, pkg, build.Spec, limiter, requested)
 
 	// Update package status
 	now := time.Now()
@@ -365,7 +403,7 @@ func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg
 		log.Errorf("package %s failed after %s: %v", pkg.Name, duration, buildErr)
 
 		// Mark dependent packages as skipped
-		s.cascadeFailure(ctx, buildID, pkg.Name)
+		s.cascadeFailure(ctx, pkg.Tenant, buildID, pkg.Name)
 	} else {
 		pkg.Status = types.PackageStatusSuccess
 		log.Infof("package %s completed successfully in %s", pkg.Name, duration)
@@ -407,8 +445,38 @@ func (s *Scheduler) executePackageBuild(ctx context.Context, buildID string, pkg
 	apko_build.ClearPools()
 }
 
-// executePackageJob executes a package build with the given spec.
-func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *types.PackageJob, spec types.BuildSpec) error {
+// acquireBackend selects and acquires a backend for arch, through limiter if
+// it is not nil, and records in the pool metrics how long the package waited
+// for it from requested, when it asked for a scheduler slot.
+func (s *Scheduler) acquireBackend(ctx context.Context, limiter *backendLimiter, arch string, selector map[string]string, requested time.Time) (*buildkit.Backend, error) {
+	ctx, span := tracing.StartSpan(ctx, "phase_backend_selection",
+		trace.WithAttributes(attribute.String("arch", arch)),
+	)
+	defer span.End()
+
+	var backend *buildkit.Backend
+	var err error
+	if limiter != nil {
+		backend, err = limiter.acquire(ctx, s.pool, arch, selector)
+	} else {
+		backend, err = s.pool.SelectAndAcquireWithContext(ctx, arch, selector)
+	}
+	wait := time.Since(requested)
+	span.SetAttributes(attribute.Float64("wait_seconds", wait.Seconds()))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("backend_addr", backend.Addr))
+	s.pool.RecordAcquireWait(arch, wait)
+	return backend, nil
+}
+
+// executePackageJob executes a package build with the given spec, on a
+// backend acquired through limiter if it is not nil, which the package asked
+// for a scheduler slot for at requested.
+func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *types.PackageJob, spec types.BuildSpec, limiter *backendLimiter, requested time.Time) error {
 	ctx, span := tracing.StartSpan(ctx, "scheduler.executePackageJob",
 		trace.WithAttributes(
 			attribute.String("job_id", jobID),
@@ -490,13 +558,16 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 		return fmt.Errorf("creating log dir: %w", err)
 	}
 
-	// Create log file
+	// Create log file, rotated so that a runaway build cannot fill the disk
 	logPath := filepath.Join(logDir, "build.log")
-	logFile, err := os.Create(logPath)
+	logFile, err := createRotatingLog(logPath, s.config.BuildLogMaxBytes, s.config.BuildLogMaxFiles)
 	if err != nil {
 		return fmt.Errorf("creating log file: %w", err)
 	}
-	defer logFile.Close()
+	defer func() {
+		logFile.Close()
+		pkg.RotatedLogPaths = logFile.RotatedPaths()
+	}()
 	pkg.LogPath = logPath
 
 	setupDuration := setupTimer.Stop()
@@ -518,23 +589,56 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 	fmt.Fprintf(logFile, "Job ID: %s\n", jobID)
 
 	// Determine architecture
-	arch := spec.Arch
-	if arch == "" {
-		arch = runtime.GOARCH
-		if arch == "arm64" {
-			arch = "aarch64"
-		} else if arch == "amd64" {
-			arch = "x86_64"
-		}
-	}
+	arch := specArch(spec)
 	targetArch := apko_types.ParseArchitecture(arch)
 	span.SetAttributes(attribute.String("arch", arch))
 
+	// Create cache directory
+	cacheDir := filepath.Join(tmpDir, "cache")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+
+	// Build configuration using the unified BuildConfig
+	buildCfg := build.NewBuildConfigForRemote(build.RemoteBuildParams{
+		ConfigPath:           configPath,
+		PipelineDir:          func() string { if len(pipelines) > 0 { return pipelineDir }; return "" }(),
+		SourceDir:            func() string { if len(sourceFiles) > 0 { return sourceDir }; return "" }(),
+		OutputDir:            outputDir,
+		CacheDir:             cacheDir,
+		ApkCacheDir:          s.config.ApkCacheDir,
+		Debug:                spec.Debug,
+		JobID:                jobID,
+		CacheRegistry:        s.config.CacheRegistry,
+		CacheMode:            s.config.CacheMode,
+		ApkoRegistry:         s.config.ApkoRegistry,
+		ApkoRegistryInsecure: s.config.ApkoRegistryInsecure,
+		ApkoServiceAddr:      s.config.ApkoServiceAddr,
+		BuildKitDialTimeout:  s.config.BuildKitDialTimeout,
+		ExtraEnv:             buildEnv(spec.Env, s.config.SecretEnv),
+	})
+	buildCfg.Arch = targetArch
+
+	// Parse the configuration, for the GPU it requests as well as for
+	// the build
+	cfg, err := s.parseCache.Parse(ctx, filepath.Base(configPath), []byte(pkg.ConfigYAML),
+		config.WithCommit(buildCfg.ConfigFileRepositoryCommit))
+	if err != nil {
+		return fmt.Errorf("initializing build: failed to load configuration: %w", err)
+	}
+	buildCfg.Configuration = cfg
+
 	// Phase 2: Backend selection
 	backendTimer := tracing.NewTimer(ctx, "phase_backend_selection")
 
-	// Atomically select and acquire a backend slot
-	backend, err := s.pool.SelectAndAcquireWithContext(ctx, arch, spec.BackendSelector)
+	selector, err := s.backendSelector(arch, cfg, spec)
+	if err != nil {
+		return fmt.Errorf("selecting backend: %w", err)
+	}
+
+	// Atomically select and acquire a backend slot, within the limit of
+	// the build on each backend
+	backend, err := s.acquireBackend(ctx, limiter, arch, selector, requested)
 	if err != nil {
 		return fmt.Errorf("selecting backend: %w", err)
 	}
@@ -552,8 +656,13 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 	var buildSuccess bool
 	defer func() {
 		s.pool.Release(backend.Addr, buildSuccess)
+		if limiter != nil {
+			limiter.release(backend.Addr)
+		}
 	}()
 
+	buildCfg.BuildKitAddr = backend.Addr
+
 	pkg.Backend = &types.Backend{
 		Addr:   backend.Addr,
 		Arch:   backend.Arch,
@@ -563,42 +672,6 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 	span.SetAttributes(attribute.String("backend_addr", backend.Addr))
 	log.Infof("building package %s for architecture: %s on backend %s", pkg.Name, targetArch, backend.Addr)
 
-	// Create cache directory
-	cacheDir := filepath.Join(tmpDir, "cache")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return fmt.Errorf("creating cache dir: %w", err)
-	}
-
-	// Merge environment variables: start with client-provided env, then overlay server secrets.
-	// Server-side secrets take precedence over client env to prevent override attacks.
-	extraEnv := make(map[string]string)
-	for k, v := range spec.Env {
-		extraEnv[k] = v
-	}
-	for k, v := range s.config.SecretEnv {
-		extraEnv[k] = v
-	}
-
-	// Build configuration using the unified BuildConfig
-	buildCfg := build.NewBuildConfigForRemote(build.RemoteBuildParams{
-		ConfigPath:           configPath,
-		PipelineDir:          func() string { if len(pipelines) > 0 { return pipelineDir }; return "" }(),
-		SourceDir:            func() string { if len(sourceFiles) > 0 { return sourceDir }; return "" }(),
-		OutputDir:            outputDir,
-		CacheDir:             cacheDir,
-		ApkCacheDir:          s.config.ApkCacheDir,
-		BackendAddr:          backend.Addr,
-		Debug:                spec.Debug,
-		JobID:                jobID,
-		CacheRegistry:        s.config.CacheRegistry,
-		CacheMode:            s.config.CacheMode,
-		ApkoRegistry:         s.config.ApkoRegistry,
-		ApkoRegistryInsecure: s.config.ApkoRegistryInsecure,
-		ApkoServiceAddr:      s.config.ApkoServiceAddr,
-		ExtraEnv:             extraEnv,
-	})
-	buildCfg.Arch = targetArch
-
 	// Phase 3: Build initialization
 	initTimer := tracing.NewTimer(ctx, "phase_build_init")
 
@@ -622,7 +695,9 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 	log.Infof("starting BuildKit execution for package %s", pkg.Name)
 
 	// Execute the build
-	if err := bc.BuildPackage(ctx); err != nil {
+	err = bc.BuildPackage(ctx)
+	pkg.Warnings = packageWarnings(bc.Warnings())
+	if err != nil {
 		buildkitDuration := buildkitTimer.Stop()
 		span.AddEvent("buildkit_failed", trace.WithAttributes(
 			attribute.String("duration", buildkitDuration.String()),
@@ -694,6 +769,41 @@ func (s *Scheduler) executePackageJob(ctx context.Context, jobID string, pkg *ty
 	return nil
 }
 
+// specArch returns the architecture a build with spec builds for: that of
+// the spec, or else that of the server.
+func specArch(spec types.BuildSpec) string {
+	if spec.Arch != "" {
+		return spec.Arch
+	}
+	switch runtime.GOARCH {
+	case "arm64":
+		return "aarch64"
+	case "amd64":
+		return "x86_64"
+	}
+	return runtime.GOARCH
+}
+
+// packageWarnings converts the warnings of a build for its package job.
+func packageWarnings(warnings []build.Warning) []types.Warning {
+	var out []types.Warning
+	for _, w := range warnings {
+		out = append(out, types.Warning{Kind: w.Kind, Message: w.Message})
+	}
+	return out
+}
+
+// buildEnv returns the environment variables injected into the pipeline
+// steps of each package of a build: the env of its spec overlaid with the
+// server's secrets. The secrets take precedence, so that a client cannot
+// override them.
+func buildEnv(env, secretEnv map[string]string) map[string]string {
+	extraEnv := make(map[string]string, len(env)+len(secretEnv))
+	maps.Copy(extraEnv, env)
+	maps.Copy(extraEnv, secretEnv)
+	return extraEnv
+}
+
 // markPackageFailed marks a package as failed.
 func (s *Scheduler) markPackageFailed(ctx context.Context, buildID string, pkg *types.PackageJob, err error) {
 	now := time.Now()
@@ -701,14 +811,14 @@ func (s *Scheduler) markPackageFailed(ctx context.Context, buildID string, pkg *
 	pkg.FinishedAt = &now
 	pkg.Error = err.Error()
 	_ = s.buildStore.UpdatePackageJob(ctx, buildID, pkg)
-	s.cascadeFailure(ctx, buildID, pkg.Name)
+	s.cascadeFailure(ctx, pkg.Tenant, buildID, pkg.Name)
 }
 
 // cascadeFailure marks packages that depend on the failed package as skipped.
-func (s *Scheduler) cascadeFailure(ctx context.Context, buildID, failedPkg string) {
+func (s *Scheduler) cascadeFailure(ctx context.Context, tenant, buildID, failedPkg string) {
 	log := clog.FromContext(ctx)
 
-	build, err := s.buildStore.GetBuild(ctx, buildID)
+	build, err := s.buildStore.GetBuild(ctx, tenant, buildID)
 	if err != nil {
 		log.Errorf("failed to get build for cascade: %v", err)
 		return
@@ -739,7 +849,7 @@ func (s *Scheduler) cascadeFailure(ctx context.Context, buildID, failedPkg strin
 					log.Errorf("failed to mark %s as skipped: %v", pkg.Name, err)
 				}
 				// Cascade further
-				s.cascadeFailure(ctx, buildID, pkg.Name)
+				s.cascadeFailure(ctx, tenant, buildID, pkg.Name)
 				break
 			}
 		}
@@ -747,10 +857,10 @@ func (s *Scheduler) cascadeFailure(ctx context.Context, buildID, failedPkg strin
 }
 
 // updateBuildStatus updates the overall build status based on package statuses.
-func (s *Scheduler) updateBuildStatus(ctx context.Context, buildID string) {
+func (s *Scheduler) updateBuildStatus(ctx context.Context, tenant, buildID string) {
 	log := clog.FromContext(ctx)
 
-	build, err := s.buildStore.GetBuild(ctx, buildID)
+	build, err := s.buildStore.GetBuild(ctx, tenant, buildID)
 	if err != nil {
 		log.Errorf("failed to get build for status update: %v", err)
 		return
@@ -860,45 +970,95 @@ func (s *Scheduler) RunCacheCleanup(ctx context.Context) error {
 
 // cleanupCacheDir removes files older than ttl from the cache directory.
 // Returns the number of files evicted and bytes freed.
+//
+// The cache is shared by concurrent builds. apko fills it by writing each
+// file under a temporary name and then advertising it with a symlink, so a
+// reader finds either a link to a complete file or no link at all. Eviction
+// keeps to that: a link is removed before the file it points to, a file still
+// linked from an entry that is not evicted is kept, and a directory is only
+// removed once it is older than ttl, so one a build has just created to
+// expand a package into is left alone. Builds hold the cache with
+// cache.Acquire while apko writes to it, and eviction waits for them to be
+// done, so that it never races a write.
 func (s *Scheduler) cleanupCacheDir(cacheDir string, ttl time.Duration) (int, int64, error) {
+	unlock, err := cache.Lock(cacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
 	cutoff := time.Now().Add(-ttl)
 	var evicted int
 	var freed int64
 
-	err := filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
+	var links, files []string
+	err = filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
-			return nil
+		switch {
+		case d.Type()&os.ModeSymlink != 0:
+			links = append(links, path)
+		case d.Type().IsRegular() && path != filepath.Join(cacheDir, cache.LockName):
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Evict stale and dangling links, and note the files the rest point to.
+	linked := map[string]bool{}
+	for _, path := range links {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue // Skip files we can't stat
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil || info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err == nil {
+				evicted++
+			}
+			continue
 		}
+		linked[target] = true
+	}
 
-		info, err := d.Info()
+	for _, path := range files {
+		info, err := os.Lstat(path)
 		if err != nil {
-			return nil // Skip files we can't stat
+			continue // Skip files we can't stat
 		}
 
 		// Check if file is older than TTL (using modification time)
-		if info.ModTime().Before(cutoff) {
+		if info.ModTime().Before(cutoff) && !linked[evalPath(path)] {
 			size := info.Size()
 			if err := os.Remove(path); err == nil {
 				evicted++
 				freed += size
 			}
 		}
-		return nil
-	})
+	}
 
 	// Also clean up empty directories
-	if err == nil {
-		s.cleanupEmptyDirs(cacheDir)
-	}
+	s.cleanupEmptyDirs(cacheDir, cutoff)
 
-	return evicted, freed, err
+	return evicted, freed, nil
 }
 
-// cleanupEmptyDirs removes empty directories from the cache.
-func (s *Scheduler) cleanupEmptyDirs(cacheDir string) {
+// evalPath returns path with any symlinks in it resolved, as
+// filepath.EvalSymlinks does for the targets of cache links.
+func evalPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	return path
+}
+
+// cleanupEmptyDirs removes empty directories last modified before cutoff
+// from the cache.
+func (s *Scheduler) cleanupEmptyDirs(cacheDir string, cutoff time.Time) {
 	// Walk in reverse order (depth-first) to clean up empty nested dirs
 	var dirs []string
 	_ = filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
@@ -910,6 +1070,10 @@ func (s *Scheduler) cleanupEmptyDirs(cacheDir string) {
 
 	// Remove empty dirs from deepest to shallowest
 	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
 		entries, err := os.ReadDir(dirs[i])
 		if err == nil && len(entries) == 0 {
 			_ = os.Remove(dirs[i])
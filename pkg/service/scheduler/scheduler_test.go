@@ -15,13 +15,23 @@
 package scheduler
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"chainguard.dev/apko/pkg/paths"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 
+	"github.com/dlorenc/melange2/pkg/cache"
 	"github.com/dlorenc/melange2/pkg/service/buildkit"
 	"github.com/dlorenc/melange2/pkg/service/dag"
 	"github.com/dlorenc/melange2/pkg/service/storage"
@@ -154,8 +164,7 @@ func TestNew(t *testing.T) {
 		// newTestScheduler creates a pool with 1 backend, DefaultMaxJobs=4
 		// So total capacity is 4, which should be the default MaxParallel
 		s := newTestScheduler(t, Config{})
-		assert.Equal(t, 4, s.config.MaxParallel) // Pool capacity (1 backend * 4 jobs)
-		assert.Equal(t, 4, cap(s.sem))
+		assert.Equal(t, 4, s.slots.limit()) // Pool capacity (1 backend * 4 jobs)
 	})
 
 	t.Run("respects custom poll interval", func(t *testing.T) {
@@ -166,7 +175,7 @@ func TestNew(t *testing.T) {
 	t.Run("respects custom max parallel", func(t *testing.T) {
 		s := newTestScheduler(t, Config{MaxParallel: 4})
 		assert.Equal(t, 4, s.config.MaxParallel)
-		assert.Equal(t, 4, cap(s.sem))
+		assert.Equal(t, 4, s.slots.limit())
 	})
 
 	t.Run("initializes active builds map", func(t *testing.T) {
@@ -261,7 +270,7 @@ func TestScheduler_UpdateBuildStatus(t *testing.T) {
 			for i, pkg := range tt.packages {
 				nodes[i] = dag.Node{Name: pkg.Name, ConfigYAML: "test"}
 			}
-			build, err := s.buildStore.CreateBuild(ctx, nodes, types.BuildSpec{})
+			build, err := s.buildStore.CreateBuild(ctx, store.DefaultTenant, nodes, types.BuildSpec{})
 			require.NoError(t, err)
 
 			// Update package statuses
@@ -271,10 +280,10 @@ func TestScheduler_UpdateBuildStatus(t *testing.T) {
 			}
 
 			// Run updateBuildStatus
-			s.updateBuildStatus(ctx, build.ID)
+			s.updateBuildStatus(ctx, store.DefaultTenant, build.ID)
 
 			// Check result
-			updated, err := s.buildStore.GetBuild(ctx, build.ID)
+			updated, err := s.buildStore.GetBuild(ctx, store.DefaultTenant, build.ID)
 			require.NoError(t, err)
 			assert.Equal(t, tt.expectedStatus, updated.Status)
 
@@ -297,14 +306,14 @@ func TestScheduler_CascadeFailure(t *testing.T) {
 		{Name: "pkg-c", ConfigYAML: "test", Dependencies: []string{"pkg-b"}},
 		{Name: "pkg-d", ConfigYAML: "test"}, // Independent
 	}
-	build, err := s.buildStore.CreateBuild(ctx, nodes, types.BuildSpec{})
+	build, err := s.buildStore.CreateBuild(ctx, store.DefaultTenant, nodes, types.BuildSpec{})
 	require.NoError(t, err)
 
 	// Cascade failure from pkg-a
-	s.cascadeFailure(ctx, build.ID, "pkg-a")
+	s.cascadeFailure(ctx, store.DefaultTenant, build.ID, "pkg-a")
 
 	// Check results
-	updated, err := s.buildStore.GetBuild(ctx, build.ID)
+	updated, err := s.buildStore.GetBuild(ctx, store.DefaultTenant, build.ID)
 	require.NoError(t, err)
 
 	statuses := make(map[string]types.PackageStatus)
@@ -331,14 +340,14 @@ func TestScheduler_CascadeFailure_ExternalDeps(t *testing.T) {
 		{Name: "pkg-a", ConfigYAML: "test"},
 		{Name: "pkg-b", ConfigYAML: "test", Dependencies: []string{"external-dep", "pkg-a"}},
 	}
-	build, err := s.buildStore.CreateBuild(ctx, nodes, types.BuildSpec{})
+	build, err := s.buildStore.CreateBuild(ctx, store.DefaultTenant, nodes, types.BuildSpec{})
 	require.NoError(t, err)
 
 	// Cascade failure from pkg-a
-	s.cascadeFailure(ctx, build.ID, "pkg-a")
+	s.cascadeFailure(ctx, store.DefaultTenant, build.ID, "pkg-a")
 
 	// Check results
-	updated, err := s.buildStore.GetBuild(ctx, build.ID)
+	updated, err := s.buildStore.GetBuild(ctx, store.DefaultTenant, build.ID)
 	require.NoError(t, err)
 
 	statuses := make(map[string]types.PackageStatus)
@@ -397,31 +406,267 @@ func TestScheduler_Run_ContextCancellation(t *testing.T) {
 }
 
 func TestScheduler_Semaphore(t *testing.T) {
+	ctx := context.Background()
 	s := newTestScheduler(t, Config{MaxParallel: 2})
 
-	// Semaphore should allow MaxParallel concurrent operations
-	assert.Equal(t, 2, cap(s.sem))
+	// Slots should allow MaxParallel concurrent operations
+	assert.Equal(t, 2, s.slots.limit())
 
 	// Acquire both slots
-	s.sem <- struct{}{}
-	s.sem <- struct{}{}
+	require.NoError(t, s.slots.acquire(ctx))
+	require.NoError(t, s.slots.acquire(ctx))
 
-	// Try to acquire non-blocking - should fail
-	select {
-	case s.sem <- struct{}{}:
-		t.Fatal("semaphore should be full")
-	default:
-		// Expected
-	}
+	// Try to acquire without waiting - should fail
+	full, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.slots.acquire(full), context.DeadlineExceeded)
 
 	// Release one
-	<-s.sem
+	s.slots.release()
 
 	// Now should be able to acquire
+	require.NoError(t, s.slots.acquire(ctx))
+}
+
+func TestScheduler_SlotsFollowPoolCapacity(t *testing.T) {
+	ctx := context.Background()
+	pool, err := buildkit.NewPool([]buildkit.Backend{
+		{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 1},
+	})
+	require.NoError(t, err)
+	localStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	s := New(store.NewMemoryBuildStore(), localStorage, pool, Config{OutputDir: t.TempDir()})
+
+	require.NoError(t, s.slots.acquire(ctx))
+
+	// A second package waits until a backend is added to the pool, and
+	// then takes the slot it brings.
+	acquired := make(chan error)
+	go func() {
+		acquired <- s.slots.acquire(ctx)
+	}()
 	select {
-	case s.sem <- struct{}{}:
-		// Expected
-	default:
-		t.Fatal("semaphore should have space")
+	case err := <-acquired:
+		t.Fatalf("acquired a slot beyond the capacity of the pool: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, pool.Add(buildkit.Backend{Addr: "tcp://backend-2:1234", Arch: "x86_64", MaxJobs: 1}))
+	select {
+	case err := <-acquired:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot of the added backend was not used")
+	}
+	assert.Equal(t, 2, s.slots.limit())
+}
+
+func TestScheduler_CleanupCacheDir(t *testing.T) {
+	s := newTestScheduler(t, Config{})
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+
+	// writeEntry writes a file into the expand directory of pkg, advertised
+	// by a link, as apko caches a package, and dates both at mtime.
+	writeEntry := func(pkg, name string, mtime time.Time) (string, string) {
+		expand := filepath.Join(dir, pkg, "expand-apk1")
+		require.NoError(t, os.MkdirAll(expand, 0o755))
+		file := filepath.Join(expand, name)
+		require.NoError(t, os.WriteFile(file, []byte(name), 0o644))
+		link := filepath.Join(dir, pkg, name)
+		require.NoError(t, os.Symlink(filepath.Join("expand-apk1", name), link))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+		require.NoError(t, unix.Lutimes(link, []unix.Timeval{unix.NsecToTimeval(mtime.UnixNano()), unix.NsecToTimeval(mtime.UnixNano())}))
+		return file, link
 	}
+
+	staleFile, staleLink := writeEntry("stale", "a.ctl.tar.gz", old)
+	// A link advertised recently to a file written long ago.
+	usedFile, usedLink := writeEntry("used", "b.ctl.tar.gz", time.Now())
+	require.NoError(t, os.Chtimes(usedFile, old, old))
+	// A link to a file that is gone.
+	dangling := filepath.Join(dir, "used", "c.ctl.tar.gz")
+	require.NoError(t, os.Symlink("expand-apk1/missing", dangling))
+	// A directory a build has just created to expand a package into.
+	expanding := filepath.Join(dir, "new", "expand-apk2")
+	require.NoError(t, os.MkdirAll(expanding, 0o755))
+
+	evicted, freed, err := s.cleanupCacheDir(dir, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, evicted)
+	assert.Equal(t, int64(len("a.ctl.tar.gz")), freed)
+
+	for _, path := range []string{staleFile, staleLink, dangling} {
+		_, err := os.Lstat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist, path)
+	}
+	got, err := os.ReadFile(usedLink)
+	require.NoError(t, err)
+	assert.Equal(t, "b.ctl.tar.gz", string(got))
+	assert.DirExists(t, expanding)
+}
+
+func TestScheduler_CleanupCacheDirConcurrentWrites(t *testing.T) {
+	s := newTestScheduler(t, Config{})
+	root := filepath.Join(t.TempDir(), "wolfi")
+	dir := filepath.Join(root, "x86_64", "hello-1.0-r0")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	dst := filepath.Join(dir, "0123abcd.dat.tar.gz")
+	content := bytes.Repeat([]byte("hello-1.0-r0 "), 64<<10)
+
+	// Builds write the same package at once, the way apko does, holding
+	// the cache, while it is being cleaned up.
+	var wg sync.WaitGroup
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _, _ = s.cleanupCacheDir(root, time.Hour)
+			}
+		}
+	}()
+	errs := make(chan error, 16)
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := cache.Acquire(root)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			tmp, err := os.CreateTemp(dir, "*.tmp")
+			if err != nil {
+				errs <- err
+				return
+			}
+			for chunk := range slices.Chunk(content, 32<<10) {
+				if _, err := tmp.Write(chunk); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err := tmp.Close(); err != nil {
+				errs <- err
+				return
+			}
+			if err := paths.AdvertiseCachedFile(tmp.Name(), dst); err != nil {
+				errs <- err
+				return
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(content, got) {
+				errs <- fmt.Errorf("read %d bytes of %d", len(got), len(content))
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-stopped
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+}
+
+func TestScheduler_CleanupCacheDirWaitsForWriters(t *testing.T) {
+	s := newTestScheduler(t, Config{})
+	root := t.TempDir()
+	// A file apko is still writing, under its temporary name.
+	tmp := filepath.Join(root, "x86_64", "hello-1.0-r0", "1234.tmp")
+	require.NoError(t, os.MkdirAll(filepath.Dir(tmp), 0o755))
+	require.NoError(t, os.WriteFile(tmp, []byte("partial"), 0o644))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(tmp, old, old))
+
+	release, err := cache.Acquire(root)
+	require.NoError(t, err)
+	done := make(chan int)
+	go func() {
+		evicted, _, err := s.cleanupCacheDir(root, time.Hour)
+		assert.NoError(t, err)
+		done <- evicted
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("cleaned up the cache while it was written to")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.FileExists(t, tmp)
+
+	release()
+	require.Equal(t, 1, <-done)
+	require.NoFileExists(t, tmp)
+	require.FileExists(t, filepath.Join(root, cache.LockName))
+}
+
+func TestScheduler_AcquireBackendWait(t *testing.T) {
+	ctx := context.Background()
+	pool, err := buildkit.NewPool([]buildkit.Backend{
+		{Addr: "tcp://backend-1:1234", Arch: "x86_64", MaxJobs: 1},
+	})
+	require.NoError(t, err)
+	localStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	s := New(store.NewMemoryBuildStore(), localStorage, pool, Config{OutputDir: t.TempDir()})
+	limiter := newBackendLimiter(1)
+
+	backend, err := s.acquireBackend(ctx, limiter, "x86_64", nil, time.Now())
+	require.NoError(t, err)
+
+	// A second package, which already waited a second for a scheduler
+	// slot, waits for the first to release the backend.
+	requested := time.Now().Add(-time.Second)
+	acquired := make(chan error)
+	go func() {
+		_, err := s.acquireBackend(ctx, limiter, "x86_64", nil, requested)
+		acquired <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pool.Release(backend.Addr, true)
+	limiter.release(backend.Addr)
+	require.NoError(t, <-acquired)
+
+	// The wait is recorded from when the package asked for a slot.
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(pool.Collectors()[0]))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	require.Len(t, families[0].GetMetric(), 1)
+	h := families[0].GetMetric()[0].GetHistogram()
+	require.Equal(t, uint64(2), h.GetSampleCount())
+	require.GreaterOrEqual(t, h.GetSampleSum(), (time.Second + 50*time.Millisecond).Seconds())
+}
+
+func TestBuildEnv(t *testing.T) {
+	spec := types.BuildSpec{Env: map[string]string{"BUILD_NUMBER": "42", "GITHUB_TOKEN": "from-client"}}
+	secrets := map[string]string{"GITHUB_TOKEN": "from-server"}
+
+	// The env of the build is applied, and the server's secrets win.
+	require.Equal(t, map[string]string{
+		"BUILD_NUMBER": "42",
+		"GITHUB_TOKEN": "from-server",
+	}, buildEnv(spec.Env, secrets))
+
+	// Neither is modified.
+	require.Equal(t, "from-client", spec.Env["GITHUB_TOKEN"])
+	require.Len(t, secrets, 1)
+
+	require.Empty(t, buildEnv(nil, nil))
 }
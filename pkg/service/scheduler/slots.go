@@ -0,0 +1,101 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/dlorenc/melange2/pkg/service/buildkit"
+)
+
+// buildSlots limits how many package builds run at once, to max or, if max
+// is zero, to the total capacity of pool as backends are added to and
+// removed from it.
+type buildSlots struct {
+	max  int
+	pool *buildkit.Pool
+
+	mu sync.Mutex
+	// used counts the package builds running.
+	used int
+	// released is closed, and replaced, when a package build releases its
+	// slot.
+	released chan struct{}
+}
+
+func newBuildSlots(max int, pool *buildkit.Pool) *buildSlots {
+	return &buildSlots{
+		max:      max,
+		pool:     pool,
+		released: make(chan struct{}),
+	}
+}
+
+// limit returns how many package builds may run at once. Without a fixed
+// maximum, it is the capacity of the pool, or the number of CPUs if the
+// pool somehow has none.
+func (s *buildSlots) limit() int {
+	if s.max > 0 {
+		return s.max
+	}
+	if n := s.pool.TotalCapacity(); n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// acquire waits for a slot to run a package build in. Without a fixed
+// maximum, a slot opens as well when the pool grows.
+func (s *buildSlots) acquire(ctx context.Context) error {
+	var changed <-chan buildkit.PoolEvent
+	for {
+		s.mu.Lock()
+		if s.used < s.limit() {
+			s.used++
+			s.mu.Unlock()
+			return nil
+		}
+		released := s.released
+		s.mu.Unlock()
+
+		// Subscribe before waiting, then check the limit again, so that
+		// no backend added meanwhile is missed.
+		if changed == nil && s.max == 0 {
+			events, cancel := s.pool.Subscribe()
+			defer cancel()
+			changed = events
+			continue
+		}
+
+		select {
+		case <-released:
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the slot of a package build.
+func (s *buildSlots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used--
+	close(s.released)
+	s.released = make(chan struct{})
+}
@@ -0,0 +1,362 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/chainguard-dev/clog"
+	"golang.org/x/sync/errgroup"
+)
+
+// S3Storage stores artifacts and logs in an S3-compatible object store,
+// such as AWS S3 or MinIO.
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+
+	// Connection configuration
+	endpoint   string
+	region     string
+	maxRetries int
+
+	// Concurrency configuration
+	maxConcurrentUploads int
+
+	// uploadSem limits concurrent uploads
+	uploadSem chan struct{}
+}
+
+// S3Option configures an S3Storage instance.
+type S3Option func(*S3Storage)
+
+// WithS3Endpoint overrides the S3 endpoint URL, e.g. to target MinIO.
+// Path-style addressing is used when an endpoint is set.
+func WithS3Endpoint(endpoint string) S3Option {
+	return func(s *S3Storage) {
+		s.endpoint = endpoint
+	}
+}
+
+// WithS3Region sets the region of the bucket. If unset, the region is taken
+// from the standard AWS configuration sources.
+func WithS3Region(region string) S3Option {
+	return func(s *S3Storage) {
+		s.region = region
+	}
+}
+
+// WithS3MaxConcurrentUploads sets the maximum number of concurrent uploads.
+func WithS3MaxConcurrentUploads(n int) S3Option {
+	return func(s *S3Storage) {
+		s.maxConcurrentUploads = n
+		s.uploadSem = make(chan struct{}, n)
+	}
+}
+
+// WithS3MaxRetries sets the maximum number of attempts for each request.
+func WithS3MaxRetries(n int) S3Option {
+	return func(s *S3Storage) {
+		s.maxRetries = n
+	}
+}
+
+// NewS3Storage creates a new S3 storage backend. Credentials are loaded from
+// the standard AWS configuration sources (environment, shared config, IAM).
+func NewS3Storage(ctx context.Context, bucket string, opts ...S3Option) (*S3Storage, error) {
+	s := &S3Storage{
+		bucket:               bucket,
+		maxConcurrentUploads: DefaultMaxConcurrentUploads,
+		maxRetries:           DefaultMaxRetries,
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// Initialize semaphore if not set by option
+	if s.uploadSem == nil {
+		s.uploadSem = make(chan struct{}, s.maxConcurrentUploads)
+	}
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if s.region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(s.region))
+	}
+	if s.maxRetries > 0 {
+		loadOpts = append(loadOpts, awsconfig.WithRetryMaxAttempts(s.maxRetries))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if s.endpoint != "" {
+			o.BaseEndpoint = aws.String(s.endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return s, nil
+}
+
+// Type returns the storage backend type.
+func (s *S3Storage) Type() string {
+	return "s3"
+}
+
+// url returns the s3:// URL of an object.
+func (s *S3Storage) url(objectPath string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, objectPath)
+}
+
+// upload writes body to objectPath. The SDK retries transient failures,
+// seeking body back to the start between attempts.
+func (s *S3Storage) upload(ctx context.Context, objectPath, contentType string, body io.ReadSeeker) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectPath),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("writing to S3: %w", err)
+	}
+	return nil
+}
+
+// WriteLog writes a build log to S3.
+func (s *S3Storage) WriteLog(ctx context.Context, jobID, pkgName string, r io.Reader) (string, error) {
+	objectPath := fmt.Sprintf("builds/%s/logs/%s.log", jobID, pkgName)
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("reading log content: %w", err)
+	}
+
+	if err := s.upload(ctx, objectPath, "text/plain", bytes.NewReader(content)); err != nil {
+		return "", fmt.Errorf("writing log to S3: %w", err)
+	}
+
+	return s.url(objectPath), nil
+}
+
+// WriteArtifact writes a build artifact to S3.
+func (s *S3Storage) WriteArtifact(ctx context.Context, jobID, name string, r io.Reader) (string, error) {
+	objectPath := fmt.Sprintf("builds/%s/artifacts/%s", jobID, name)
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("reading artifact content: %w", err)
+	}
+
+	if err := s.upload(ctx, objectPath, artifactContentType(name), bytes.NewReader(content)); err != nil {
+		return "", fmt.Errorf("writing artifact to S3: %w", err)
+	}
+
+	return s.url(objectPath), nil
+}
+
+// GetLogURL returns the URL for a job's log.
+func (s *S3Storage) GetLogURL(ctx context.Context, jobID, pkgName string) (string, error) {
+	objectPath := fmt.Sprintf("builds/%s/logs/%s.log", jobID, pkgName)
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectPath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("log not found: %w", err)
+	}
+	return s.url(objectPath), nil
+}
+
+// ListArtifacts lists all artifacts for a job.
+func (s *S3Storage) ListArtifacts(ctx context.Context, jobID string) ([]Artifact, error) {
+	prefix := fmt.Sprintf("builds/%s/artifacts/", jobID)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var artifacts []Artifact
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing artifacts: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+
+			// Skip if it's a "directory" (ends with /)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+
+			artifacts = append(artifacts, Artifact{
+				Name: strings.TrimPrefix(key, prefix),
+				URL:  s.url(key),
+				Size: aws.ToInt64(obj.Size),
+			})
+		}
+	}
+	return artifacts, nil
+}
+
+// OutputDir returns a local temp directory for building.
+// The contents will be uploaded to S3 via SyncOutputDir.
+func (s *S3Storage) OutputDir(ctx context.Context, jobID string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("melange-build-%s-*", jobID))
+	if err != nil {
+		return "", fmt.Errorf("creating temp directory: %w", err)
+	}
+	return tmpDir, nil
+}
+
+// SyncOutputDir uploads the contents of the local output directory to S3.
+// Uploads run concurrently, bounded by the configured upload limit.
+func (s *S3Storage) SyncOutputDir(ctx context.Context, jobID, localDir string) error {
+	log := clog.FromContext(ctx)
+	startTime := time.Now()
+
+	var files []fileToUpload
+	var totalBytes int64
+
+	err := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		totalBytes += info.Size()
+
+		relPath, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return fmt.Errorf("getting relative path: %w", err)
+		}
+
+		// Determine if this is a log or artifact
+		var objectPath, contentType string
+		if strings.Contains(relPath, "logs") || strings.HasSuffix(relPath, ".log") {
+			objectPath = fmt.Sprintf("builds/%s/logs/%s", jobID, filepath.Base(relPath))
+		} else {
+			objectPath = fmt.Sprintf("builds/%s/artifacts/%s", jobID, filepath.Base(relPath))
+		}
+		if strings.HasSuffix(relPath, ".log") {
+			contentType = "text/plain"
+		} else {
+			contentType = artifactContentType(relPath)
+		}
+
+		files = append(files, fileToUpload{
+			localPath:   path,
+			objectPath:  objectPath,
+			contentType: contentType,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking directory: %w", err)
+	}
+
+	if len(files) == 0 {
+		log.Infof("storage sync: no files to upload for job %s", jobID)
+		return nil
+	}
+
+	log.Infof("storage sync: uploading %d files (%.2f MB) for job %s to s3://%s",
+		len(files), float64(totalBytes)/(1024*1024), jobID, s.bucket)
+
+	var uploadedFiles atomic.Int32
+	var uploadedBytes atomic.Int64
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	for _, f := range files {
+		g.Go(func() error {
+			// Acquire semaphore slot
+			select {
+			case s.uploadSem <- struct{}{}:
+				defer func() { <-s.uploadSem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			file, err := os.Open(f.localPath)
+			if err != nil {
+				return fmt.Errorf("opening %s: %w", f.localPath, err)
+			}
+			defer file.Close()
+
+			info, err := file.Stat()
+			if err != nil {
+				return fmt.Errorf("stat %s: %w", f.localPath, err)
+			}
+
+			if err := s.upload(ctx, f.objectPath, f.contentType, file); err != nil {
+				return fmt.Errorf("uploading %s: %w", f.localPath, err)
+			}
+
+			uploadedFiles.Add(1)
+			uploadedBytes.Add(info.Size())
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	duration := time.Since(startTime)
+
+	if err != nil {
+		log.Errorf("storage sync failed after %s: uploaded %d/%d files (%.2f MB), error: %v",
+			duration, uploadedFiles.Load(), len(files), float64(uploadedBytes.Load())/(1024*1024), err)
+		return err
+	}
+
+	throughputMBps := float64(totalBytes) / (1024 * 1024) / duration.Seconds()
+	log.Infof("storage sync complete: uploaded %d files (%.2f MB) in %s (%.2f MB/s) for job %s",
+		len(files), float64(totalBytes)/(1024*1024), duration, throughputMBps, jobID)
+
+	return nil
+}
+
+// artifactContentType returns the content type for an artifact name.
+func artifactContentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".apk"):
+		return "application/vnd.apk"
+	case strings.HasSuffix(name, ".tar.gz"):
+		return "application/gzip"
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a minimal in-memory S3 server supporting path-style PutObject,
+// HeadObject and ListObjectsV2 for a single bucket.
+type fakeS3 struct {
+	bucket string
+
+	mu           sync.Mutex
+	objects      map[string][]byte
+	contentTypes map[string]string
+}
+
+type fakeS3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name       `xml:"ListBucketResult"`
+	Name        string         `xml:"Name"`
+	Prefix      string         `xml:"Prefix"`
+	KeyCount    int            `xml:"KeyCount"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Contents    []fakeS3Object `xml:"Contents"`
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{
+		bucket:       bucket,
+		objects:      map[string][]byte{},
+		contentTypes: map[string]string{},
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && key != "":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.objects[key] = body
+		f.contentTypes[key] = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodHead && key != "":
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		result := fakeS3ListResult{Name: f.bucket, Prefix: prefix}
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, fakeS3Object{Key: k, Size: int64(len(v))})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool {
+			return result.Contents[i].Key < result.Contents[j].Key
+		})
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) object(key string) ([]byte, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.objects[key]
+	return b, f.contentTypes[key], ok
+}
+
+func newTestS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	fake, srv := newFakeS3(t, "melange-test")
+	s, err := NewS3Storage(context.Background(), "melange-test",
+		WithS3Endpoint(srv.URL),
+		WithS3MaxRetries(1),
+		WithS3MaxConcurrentUploads(2))
+	require.NoError(t, err)
+	return s, fake
+}
+
+func TestS3Storage_WriteArtifact(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestS3Storage(t)
+
+	assert.Equal(t, "s3", s.Type())
+
+	url, err := s.WriteArtifact(ctx, "job-1", "hello-1.0-r0.apk", bytes.NewReader([]byte("apk data")))
+	require.NoError(t, err)
+	assert.Equal(t, "s3://melange-test/builds/job-1/artifacts/hello-1.0-r0.apk", url)
+
+	got, contentType, ok := fake.object("builds/job-1/artifacts/hello-1.0-r0.apk")
+	require.True(t, ok)
+	assert.Equal(t, "apk data", string(got))
+	assert.Equal(t, "application/vnd.apk", contentType)
+}
+
+func TestS3Storage_WriteLogAndGetLogURL(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestS3Storage(t)
+
+	_, err := s.GetLogURL(ctx, "job-1", "hello")
+	require.Error(t, err)
+
+	url, err := s.WriteLog(ctx, "job-1", "hello", strings.NewReader("build ok\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3://melange-test/builds/job-1/logs/hello.log", url)
+
+	got, contentType, ok := fake.object("builds/job-1/logs/hello.log")
+	require.True(t, ok)
+	assert.Equal(t, "build ok\n", string(got))
+	assert.Equal(t, "text/plain", contentType)
+
+	url, err = s.GetLogURL(ctx, "job-1", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "s3://melange-test/builds/job-1/logs/hello.log", url)
+}
+
+func TestS3Storage_SyncOutputDirAndList(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestS3Storage(t)
+
+	dir, err := s.OutputDir(ctx, "job-2")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	files := map[string]string{
+		"x86_64/hello-1.0-r0.apk": "apk",
+		"x86_64/APKINDEX.tar.gz":  "index",
+		"logs/build.log":          "log",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+
+	require.NoError(t, s.SyncOutputDir(ctx, "job-2", dir))
+
+	got, _, ok := fake.object("builds/job-2/logs/build.log")
+	require.True(t, ok)
+	assert.Equal(t, "log", string(got))
+
+	_, contentType, ok := fake.object("builds/job-2/artifacts/APKINDEX.tar.gz")
+	require.True(t, ok)
+	assert.Equal(t, "application/gzip", contentType)
+
+	artifacts, err := s.ListArtifacts(ctx, "job-2")
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+	assert.Equal(t, Artifact{
+		Name: "APKINDEX.tar.gz",
+		URL:  "s3://melange-test/builds/job-2/artifacts/APKINDEX.tar.gz",
+		Size: int64(len("index")),
+	}, artifacts[0])
+	assert.Equal(t, "hello-1.0-r0.apk", artifacts[1].Name)
+
+	artifacts, err = s.ListArtifacts(ctx, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, artifacts)
+}
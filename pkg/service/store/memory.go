@@ -18,6 +18,8 @@ package store
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -61,11 +63,24 @@ type MemoryBuildStore struct {
 	// This avoids O(n) scans when the scheduler polls every second
 	activeBuilds map[string]struct{}
 
+	// packageBuilds is an index of the IDs of the builds with each
+	// package, by package name.
+	packageBuilds map[string]map[string]struct{}
+
+	// idempotencyKeys maps the tenant and idempotency key of each build
+	// created with one to the build's ID.
+	idempotencyKeys map[idempotencyKey]string
+
 	// For background eviction
 	stopCh chan struct{}
 	doneCh chan struct{}
 }
 
+// idempotencyKey is an idempotency key, which is unique within a tenant.
+type idempotencyKey struct {
+	tenant, key string
+}
+
 // MemoryBuildStoreOption configures a MemoryBuildStore.
 type MemoryBuildStoreOption func(*MemoryBuildStore)
 
@@ -93,8 +108,10 @@ func WithEvictionInterval(interval time.Duration) MemoryBuildStoreOption {
 // NewMemoryBuildStore creates a new in-memory build store with default settings.
 func NewMemoryBuildStore(opts ...MemoryBuildStoreOption) *MemoryBuildStore {
 	s := &MemoryBuildStore{
-		builds:       make(map[string]*types.Build),
-		activeBuilds: make(map[string]struct{}),
+		builds:          make(map[string]*types.Build),
+		activeBuilds:    make(map[string]struct{}),
+		packageBuilds:   make(map[string]map[string]struct{}),
+		idempotencyKeys: make(map[idempotencyKey]string),
 		config: MemoryBuildStoreConfig{
 			MaxCompletedBuilds: DefaultMaxCompletedBuilds,
 			BuildTTL:           DefaultBuildTTL,
@@ -167,8 +184,7 @@ func (s *MemoryBuildStore) evictOldBuilds() {
 
 		// Check TTL first
 		if s.config.BuildTTL > 0 && now.Sub(finishedAt) > s.config.BuildTTL {
-			delete(s.builds, id)
-			delete(s.activeBuilds, id) // Clean index too
+			s.deleteBuild(id)
 			continue
 		}
 
@@ -188,8 +204,41 @@ func (s *MemoryBuildStore) evictOldBuilds() {
 		// Evict oldest builds exceeding the limit
 		toEvict := len(completed) - s.config.MaxCompletedBuilds
 		for i := 0; i < toEvict; i++ {
-			delete(s.builds, completed[i].id)
-			delete(s.activeBuilds, completed[i].id) // Clean index too
+			s.deleteBuild(completed[i].id)
+		}
+	}
+}
+
+// deleteBuild removes a build and its index entries. The caller must hold
+// s.mu.
+func (s *MemoryBuildStore) deleteBuild(id string) {
+	if build := s.builds[id]; build.IdempotencyKey != "" {
+		delete(s.idempotencyKeys, idempotencyKey{build.Tenant, build.IdempotencyKey})
+	}
+	s.unindexPackages(s.builds[id])
+	delete(s.builds, id)
+	delete(s.activeBuilds, id)
+}
+
+// indexPackages adds build to the package index. The caller must hold s.mu.
+func (s *MemoryBuildStore) indexPackages(build *types.Build) {
+	for _, pkg := range build.Packages {
+		ids, ok := s.packageBuilds[pkg.Name]
+		if !ok {
+			ids = make(map[string]struct{})
+			s.packageBuilds[pkg.Name] = ids
+		}
+		ids[build.ID] = struct{}{}
+	}
+}
+
+// unindexPackages removes build from the package index. The caller must
+// hold s.mu.
+func (s *MemoryBuildStore) unindexPackages(build *types.Build) {
+	for _, pkg := range build.Packages {
+		delete(s.packageBuilds[pkg.Name], build.ID)
+		if len(s.packageBuilds[pkg.Name]) == 0 {
+			delete(s.packageBuilds, pkg.Name)
 		}
 	}
 }
@@ -212,16 +261,47 @@ func (s *MemoryBuildStore) Stats() (total, active, completed int) {
 }
 
 // CreateBuild creates a new multi-package build.
-func (s *MemoryBuildStore) CreateBuild(ctx context.Context, packages []dag.Node, spec types.BuildSpec) (*types.Build, error) {
+func (s *MemoryBuildStore) CreateBuild(ctx context.Context, tenant string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createBuild(tenant, "", packages, spec, createBuildOptions(opts)), nil
+}
+
+// CreateBuildWithKey creates a new multi-package build, or returns the build
+// already created with the same idempotency key.
+func (s *MemoryBuildStore) CreateBuildWithKey(ctx context.Context, tenant, key string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (*types.Build, bool, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, false, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if id, ok := s.idempotencyKeys[idempotencyKey{tenant, key}]; ok {
+		return s.copyBuild(s.builds[id]), false, nil
+	}
+
+	build := s.createBuild(tenant, key, packages, spec, createBuildOptions(opts))
+	s.idempotencyKeys[idempotencyKey{tenant, key}] = build.ID
+	return s.copyBuild(build), true, nil
+}
+
+// createBuild adds a new build to the store. The caller must hold s.mu.
+func (s *MemoryBuildStore) createBuild(tenant, key string, packages []dag.Node, spec types.BuildSpec, o createOptions) *types.Build {
 	build := &types.Build{
-		ID:        "bld-" + uuid.New().String()[:8],
-		Status:    types.BuildStatusPending,
-		Packages:  make([]types.PackageJob, len(packages)),
-		Spec:      spec,
-		CreatedAt: time.Now(),
+		ID:             "bld-" + uuid.New().String()[:8],
+		Status:         types.BuildStatusPending,
+		Packages:       make([]types.PackageJob, len(packages)),
+		Spec:           spec,
+		CreatedAt:      time.Now(),
+		Tenant:         tenant,
+		IdempotencyKey: key,
+		Metadata:       maps.Clone(o.metadata),
 	}
 
 	// Convert DAG nodes to PackageJobs
@@ -231,22 +311,28 @@ func (s *MemoryBuildStore) CreateBuild(ctx context.Context, packages []dag.Node,
 			Status:       types.PackageStatusPending,
 			ConfigYAML:   node.ConfigYAML,
 			Dependencies: node.Dependencies,
+			Tenant:       tenant,
 			Pipelines:    spec.Pipelines,
 		}
 	}
 
 	s.builds[build.ID] = build
 	s.activeBuilds[build.ID] = struct{}{} // Track as active
-	return build, nil
+	s.indexPackages(build)
+	return build
 }
 
-// GetBuild retrieves a build by ID.
-func (s *MemoryBuildStore) GetBuild(ctx context.Context, id string) (*types.Build, error) {
+// GetBuild retrieves a build of tenant by ID.
+func (s *MemoryBuildStore) GetBuild(ctx context.Context, tenant, id string) (*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	build, ok := s.builds[id]
-	if !ok {
+	if !ok || build.Tenant != tenant {
 		return nil, fmt.Errorf("%w: %s", svcerrors.ErrBuildNotFound, id)
 	}
 
@@ -259,11 +345,17 @@ func (s *MemoryBuildStore) UpdateBuild(ctx context.Context, build *types.Build)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.builds[build.ID]; !ok {
+	existing, ok := s.builds[build.ID]
+	if !ok {
 		return fmt.Errorf("%w: %s", svcerrors.ErrBuildNotFound, build.ID)
 	}
 
-	s.builds[build.ID] = s.copyBuild(build)
+	updated := s.copyBuild(build)
+	updated.Tenant = existing.Tenant
+	updated.Metadata = existing.Metadata
+	s.unindexPackages(existing)
+	s.builds[build.ID] = updated
+	s.indexPackages(updated)
 
 	// Update active index based on terminal status
 	if IsTerminalStatus(build.Status) {
@@ -272,13 +364,53 @@ func (s *MemoryBuildStore) UpdateBuild(ctx context.Context, build *types.Build)
 	return nil
 }
 
-// ListBuilds returns all builds.
-func (s *MemoryBuildStore) ListBuilds(ctx context.Context) ([]*types.Build, error) {
+// UpdateBuildMetadata merges patch into the metadata of a build.
+func (s *MemoryBuildStore) UpdateBuildMetadata(ctx context.Context, tenant, id string, patch map[string]string) error {
+	if err := checkTenant(tenant); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	build, ok := s.builds[id]
+	if !ok || build.Tenant != tenant {
+		return fmt.Errorf("%w: %s", svcerrors.ErrBuildNotFound, id)
+	}
+
+	// Build a new map, as copies of the build share the old one.
+	metadata := maps.Clone(build.Metadata)
+	for k, v := range patch {
+		if v == "" {
+			delete(metadata, k)
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, len(patch))
+		}
+		metadata[k] = v
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	build.Metadata = metadata
+	return nil
+}
+
+// ListBuilds returns all builds of tenant.
+func (s *MemoryBuildStore) ListBuilds(ctx context.Context, tenant string) ([]*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	builds := make([]*types.Build, 0, len(s.builds))
 	for _, build := range s.builds {
+		if build.Tenant != tenant {
+			continue
+		}
 		builds = append(builds, s.copyBuild(build))
 	}
 
@@ -290,6 +422,61 @@ func (s *MemoryBuildStore) ListBuilds(ctx context.Context) ([]*types.Build, erro
 	return builds, nil
 }
 
+// ListBuildsByPackage returns the builds of tenant with a package named
+// name, using the package index.
+func (s *MemoryBuildStore) ListBuildsByPackage(ctx context.Context, tenant, name string) ([]*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.packageBuilds[name]
+	builds := make([]*types.Build, 0, len(ids))
+	for id := range ids {
+		if build := s.builds[id]; build.Tenant == tenant {
+			builds = append(builds, s.copyBuild(build))
+		}
+	}
+
+	// Sort by CreatedAt for deterministic ordering
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].CreatedAt.Before(builds[j].CreatedAt)
+	})
+
+	return builds, nil
+}
+
+// CountBuilds tallies the builds of tenant matching filter by status, using
+// the package index when filter selects a package.
+func (s *MemoryBuildStore) CountBuilds(ctx context.Context, tenant string, filter BuildFilter) (map[types.BuildStatus]int, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[types.BuildStatus]int)
+	count := func(build *types.Build) {
+		if build.CreatedAt.Before(filter.Since) || build.Tenant != tenant {
+			return
+		}
+		counts[build.Status]++
+	}
+	if filter.Package != "" {
+		for id := range s.packageBuilds[filter.Package] {
+			count(s.builds[id])
+		}
+	} else {
+		for _, build := range s.builds {
+			count(build)
+		}
+	}
+	return counts, nil
+}
+
 // ListActiveBuilds returns only non-terminal builds using the active index.
 // This is O(active) instead of O(total) - critical for scheduler performance at scale.
 func (s *MemoryBuildStore) ListActiveBuilds(ctx context.Context) ([]*types.Build, error) {
@@ -385,6 +572,7 @@ func (s *MemoryBuildStore) UpdatePackageJob(ctx context.Context, buildID string,
 	for i := range build.Packages {
 		if build.Packages[i].Name == pkg.Name {
 			build.Packages[i] = *pkg
+			build.Packages[i].Tenant = build.Tenant
 			return nil
 		}
 	}
@@ -395,6 +583,7 @@ func (s *MemoryBuildStore) UpdatePackageJob(ctx context.Context, buildID string,
 // copyBuild creates a deep copy of a build.
 func (s *MemoryBuildStore) copyBuild(build *types.Build) *types.Build {
 	copy := *build
+	copy.Metadata = maps.Clone(build.Metadata)
 	copy.Packages = make([]types.PackageJob, len(build.Packages))
 	for i, pkg := range build.Packages {
 		pkgCopy := pkg
@@ -404,6 +593,12 @@ func (s *MemoryBuildStore) copyBuild(build *types.Build) *types.Build {
 				pkgCopy.Dependencies[j] = dep
 			}
 		}
+		if pkg.RotatedLogPaths != nil {
+			pkgCopy.RotatedLogPaths = slices.Clone(pkg.RotatedLogPaths)
+		}
+		if pkg.Warnings != nil {
+			pkgCopy.Warnings = slices.Clone(pkg.Warnings)
+		}
 		if pkg.Pipelines != nil {
 			pkgCopy.Pipelines = make(map[string]string)
 			for k, v := range pkg.Pipelines {
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/dlorenc/melange2/pkg/service/dag"
+	svcerrors "github.com/dlorenc/melange2/pkg/service/errors"
 	"github.com/dlorenc/melange2/pkg/service/types"
 )
 
@@ -41,7 +42,9 @@ func TestMemoryBuildStore_CreateBuild(t *testing.T) {
 		Pipelines: map[string]string{"test.yaml": "content"},
 	}
 
-	build, err := store.CreateBuild(ctx, packages, spec)
+	metadata := map[string]string{"user": "alice", "reviewer": ""}
+
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, spec, WithMetadata(metadata))
 	require.NoError(t, err)
 	require.NotNil(t, build)
 
@@ -53,6 +56,54 @@ func TestMemoryBuildStore_CreateBuild(t *testing.T) {
 	assert.Equal(t, "pkg-b", build.Packages[1].Name)
 	assert.Equal(t, []string{"pkg-a"}, build.Packages[1].Dependencies)
 	assert.False(t, build.CreatedAt.IsZero())
+	// Metadata is recorded with the build as given, empty values included.
+	assert.Equal(t, metadata, build.Metadata)
+}
+
+func TestMemoryBuildStore_CreateBuildWithKey(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBuildStore(WithEvictionInterval(0))
+
+	packages := []dag.Node{{Name: "pkg-a", ConfigYAML: "package:\n  name: pkg-a"}}
+
+	first, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", packages, types.BuildSpec{})
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.Equal(t, "key-1", first.IdempotencyKey)
+
+	again, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", []dag.Node{{Name: "other"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	require.False(t, created)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, "pkg-a", again.Packages[0].Name)
+
+	other, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-2", packages, types.BuildSpec{})
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	builds, err := store.ListBuilds(ctx, DefaultTenant)
+	require.NoError(t, err)
+	assert.Len(t, builds, 2)
+
+	t.Run("key is released when the build is evicted", func(t *testing.T) {
+		store := NewMemoryBuildStore(WithBuildTTL(time.Nanosecond), WithEvictionInterval(0))
+
+		build, _, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", packages, types.BuildSpec{})
+		require.NoError(t, err)
+		build.Status = types.BuildStatusSuccess
+		now := time.Now()
+		build.FinishedAt = &now
+		require.NoError(t, store.UpdateBuild(ctx, build))
+
+		time.Sleep(time.Millisecond)
+		store.evictOldBuilds()
+
+		next, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", packages, types.BuildSpec{})
+		require.NoError(t, err)
+		require.True(t, created)
+		assert.NotEqual(t, build.ID, next.ID)
+	})
 }
 
 func TestMemoryBuildStore_GetBuild(t *testing.T) {
@@ -60,24 +111,24 @@ func TestMemoryBuildStore_GetBuild(t *testing.T) {
 	store := NewMemoryBuildStore()
 
 	packages := []dag.Node{{Name: "test"}}
-	created, err := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	created, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 	require.NoError(t, err)
 
 	t.Run("existing build", func(t *testing.T) {
-		build, err := store.GetBuild(ctx, created.ID)
+		build, err := store.GetBuild(ctx, DefaultTenant, created.ID)
 		require.NoError(t, err)
 		assert.Equal(t, created.ID, build.ID)
 	})
 
 	t.Run("non-existent build", func(t *testing.T) {
-		_, err := store.GetBuild(ctx, "non-existent")
+		_, err := store.GetBuild(ctx, DefaultTenant, "non-existent")
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "build not found")
 	})
 
 	t.Run("returns deep copy", func(t *testing.T) {
-		build1, _ := store.GetBuild(ctx, created.ID)
-		build2, _ := store.GetBuild(ctx, created.ID)
+		build1, _ := store.GetBuild(ctx, DefaultTenant, created.ID)
+		build2, _ := store.GetBuild(ctx, DefaultTenant, created.ID)
 
 		build1.Status = types.BuildStatusRunning
 		assert.NotEqual(t, build1.Status, build2.Status)
@@ -94,7 +145,7 @@ func TestMemoryBuildStore_UpdateBuild(t *testing.T) {
 	store := NewMemoryBuildStore()
 
 	packages := []dag.Node{{Name: "test"}}
-	build, err := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 	require.NoError(t, err)
 
 	t.Run("update existing build", func(t *testing.T) {
@@ -102,7 +153,7 @@ func TestMemoryBuildStore_UpdateBuild(t *testing.T) {
 		err := store.UpdateBuild(ctx, build)
 		require.NoError(t, err)
 
-		updated, _ := store.GetBuild(ctx, build.ID)
+		updated, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 		assert.Equal(t, types.BuildStatusRunning, updated.Status)
 	})
 
@@ -113,24 +164,85 @@ func TestMemoryBuildStore_UpdateBuild(t *testing.T) {
 	})
 }
 
+func TestMemoryBuildStore_UpdateBuildMetadata(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBuildStore()
+
+	build, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "test"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Nil(t, build.Metadata)
+
+	t.Run("set metadata", func(t *testing.T) {
+		require.NoError(t, store.UpdateBuildMetadata(ctx, DefaultTenant, build.ID, map[string]string{
+			"user":   "alice",
+			"ci-run": "https://ci.example.com/runs/1",
+		}))
+
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "alice", "ci-run": "https://ci.example.com/runs/1"}, got.Metadata)
+	})
+
+	t.Run("patch metadata", func(t *testing.T) {
+		require.NoError(t, store.UpdateBuildMetadata(ctx, DefaultTenant, build.ID, map[string]string{
+			"ci-run": "https://ci.example.com/runs/2",
+			"pr":     "42",
+			"user":   "",
+		}))
+
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"ci-run": "https://ci.example.com/runs/2", "pr": "42"}, got.Metadata)
+	})
+
+	t.Run("kept by UpdateBuild", func(t *testing.T) {
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		got.Status = types.BuildStatusRunning
+		got.Metadata = nil
+		require.NoError(t, store.UpdateBuild(ctx, got))
+
+		got, err = store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, types.BuildStatusRunning, got.Status)
+		assert.Equal(t, map[string]string{"ci-run": "https://ci.example.com/runs/2", "pr": "42"}, got.Metadata)
+	})
+
+	t.Run("returns deep copy", func(t *testing.T) {
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		got.Metadata["pr"] = "43"
+
+		again, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "42", again.Metadata["pr"])
+	})
+
+	t.Run("non-existent build", func(t *testing.T) {
+		err := store.UpdateBuildMetadata(ctx, DefaultTenant, "non-existent", map[string]string{"user": "alice"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "build not found")
+	})
+}
+
 func TestMemoryBuildStore_ListBuilds(t *testing.T) {
 	ctx := context.Background()
 	store := NewMemoryBuildStore()
 
 	t.Run("empty store", func(t *testing.T) {
-		builds, err := store.ListBuilds(ctx)
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
 		require.NoError(t, err)
 		assert.Empty(t, builds)
 	})
 
 	t.Run("returns all builds sorted by creation time", func(t *testing.T) {
-		store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 		time.Sleep(10 * time.Millisecond)
-		store.CreateBuild(ctx, []dag.Node{{Name: "b"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "b"}}, types.BuildSpec{})
 		time.Sleep(10 * time.Millisecond)
-		store.CreateBuild(ctx, []dag.Node{{Name: "c"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "c"}}, types.BuildSpec{})
 
-		builds, err := store.ListBuilds(ctx)
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
 		require.NoError(t, err)
 		assert.Len(t, builds, 3)
 
@@ -142,6 +254,252 @@ func TestMemoryBuildStore_ListBuilds(t *testing.T) {
 	})
 }
 
+func TestMemoryBuildStore_ListBuildsByPackage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBuildStore(WithEvictionInterval(0))
+
+	first, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "zlib"}, {Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "openssl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	second, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+
+	t.Run("builds containing the package", func(t *testing.T) {
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		require.Len(t, builds, 2)
+		assert.Equal(t, first.ID, builds[0].ID)
+		assert.Equal(t, second.ID, builds[1].ID)
+	})
+
+	t.Run("no build contains the package", func(t *testing.T) {
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl-dev")
+		require.NoError(t, err)
+		assert.Empty(t, builds)
+	})
+
+	t.Run("evicted builds are dropped", func(t *testing.T) {
+		first.Status = types.BuildStatusSuccess
+		require.NoError(t, store.UpdateBuild(ctx, first))
+		store.mu.Lock()
+		store.deleteBuild(first.ID)
+		store.mu.Unlock()
+
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, second.ID, builds[0].ID)
+
+		builds, err = store.ListBuildsByPackage(ctx, DefaultTenant, "zlib")
+		require.NoError(t, err)
+		assert.Empty(t, builds)
+	})
+}
+
+func TestMemoryBuildStore_CountBuilds(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBuildStore(WithEvictionInterval(0))
+
+	t.Run("empty store", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+	})
+
+	statuses := []types.BuildStatus{
+		types.BuildStatusPending,
+		types.BuildStatusRunning,
+		types.BuildStatusSuccess,
+		types.BuildStatusSuccess,
+		types.BuildStatusFailed,
+		types.BuildStatusPartial,
+	}
+	var since time.Time
+	for i, status := range statuses {
+		if i == 3 {
+			time.Sleep(10 * time.Millisecond)
+			since = time.Now()
+		}
+		names := []dag.Node{{Name: "zlib"}}
+		if i%2 == 0 {
+			names = append(names, dag.Node{Name: "curl"})
+		}
+		build, err := store.CreateBuild(ctx, DefaultTenant, names, types.BuildSpec{})
+		require.NoError(t, err)
+		build.Status = status
+		require.NoError(t, store.UpdateBuild(ctx, build))
+	}
+
+	// tally counts the statuses of the listed builds the filter selects.
+	tally := func(t *testing.T, builds []*types.Build, since time.Time) map[types.BuildStatus]int {
+		t.Helper()
+		counts := make(map[types.BuildStatus]int)
+		for _, b := range builds {
+			if !b.CreatedAt.Before(since) {
+				counts[b.Status]++
+			}
+		}
+		return counts
+	}
+
+	t.Run("all builds", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{
+			types.BuildStatusPending: 1,
+			types.BuildStatusRunning: 1,
+			types.BuildStatusSuccess: 2,
+			types.BuildStatusFailed:  1,
+			types.BuildStatusPartial: 1,
+		}, counts)
+
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, time.Time{}), counts)
+	})
+
+	t.Run("builds of a package", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "curl"})
+		require.NoError(t, err)
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, time.Time{}), counts)
+		assert.Equal(t, 3, counts[types.BuildStatusPending]+counts[types.BuildStatusSuccess]+counts[types.BuildStatusFailed])
+
+		counts, err = store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "openssl"})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+	})
+
+	t.Run("builds created since", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{Since: since})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{
+			types.BuildStatusSuccess: 1,
+			types.BuildStatusFailed:  1,
+			types.BuildStatusPartial: 1,
+		}, counts)
+
+		counts, err = store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "curl", Since: since})
+		require.NoError(t, err)
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, since), counts)
+	})
+}
+
+func TestMemoryBuildStore_Tenants(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryBuildStore(WithEvictionInterval(0))
+
+	aliceBuild, err := store.CreateBuild(ctx, "alice", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", aliceBuild.Tenant)
+	bobBuild, err := store.CreateBuild(ctx, "bob", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", bobBuild.Tenant)
+	defaultBuild, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTenant, defaultBuild.Tenant)
+
+	t.Run("get", func(t *testing.T) {
+		build, err := store.GetBuild(ctx, "alice", aliceBuild.ID)
+		require.NoError(t, err)
+		assert.Equal(t, aliceBuild.ID, build.ID)
+
+		_, err = store.GetBuild(ctx, "alice", bobBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+		_, err = store.GetBuild(ctx, DefaultTenant, aliceBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+		err = store.UpdateBuildMetadata(ctx, "alice", bobBuild.ID, map[string]string{"user": "alice"})
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		builds, err := store.ListBuilds(ctx, "alice")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, aliceBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuildsByPackage(ctx, "bob", "curl")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, bobBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuilds(ctx, DefaultTenant)
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, defaultBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuilds(ctx, "carol")
+		require.NoError(t, err)
+		assert.Empty(t, builds)
+	})
+
+	t.Run("count", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, "alice", BuildFilter{Package: "curl"})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{types.BuildStatusPending: 1}, counts)
+	})
+
+	t.Run("jobs carry the tenant", func(t *testing.T) {
+		assert.Equal(t, "alice", aliceBuild.Packages[0].Tenant)
+
+		job, err := store.ClaimReadyPackage(ctx, bobBuild.ID)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, "bob", job.Tenant)
+
+		job.Status = types.PackageStatusSuccess
+		require.NoError(t, store.UpdatePackageJob(ctx, bobBuild.ID, job))
+		build, err := store.GetBuild(ctx, "bob", bobBuild.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "bob", build.Packages[0].Tenant)
+	})
+
+	t.Run("no tenant is denied", func(t *testing.T) {
+		_, err := store.CreateBuild(ctx, "", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, _, err = store.CreateBuildWithKey(ctx, "", "key-1", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.GetBuild(ctx, "", bobBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		err = store.UpdateBuildMetadata(ctx, "", bobBuild.ID, map[string]string{"user": "mallory"})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.ListBuilds(ctx, "")
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.ListBuildsByPackage(ctx, "", "curl")
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.CountBuilds(ctx, "", BuildFilter{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+	})
+
+	t.Run("active builds span tenants", func(t *testing.T) {
+		builds, err := store.ListActiveBuilds(ctx)
+		require.NoError(t, err)
+		assert.Len(t, builds, 3)
+	})
+
+	t.Run("idempotency keys are per tenant", func(t *testing.T) {
+		first, created, err := store.CreateBuildWithKey(ctx, "alice", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.True(t, created)
+
+		other, created, err := store.CreateBuildWithKey(ctx, "bob", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.True(t, created)
+		assert.NotEqual(t, first.ID, other.ID)
+
+		again, created, err := store.CreateBuildWithKey(ctx, "alice", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.False(t, created)
+		assert.Equal(t, first.ID, again.ID)
+	})
+}
+
 func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 	ctx := context.Background()
 
@@ -151,7 +509,7 @@ func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a", Dependencies: []string{"pkg-b"}},
 			{Name: "pkg-b", Dependencies: []string{"pkg-c"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// pkg-a depends on pkg-b, pkg-b depends on pkg-c (not in graph)
 		// pkg-b should be claimable since pkg-c is external
@@ -168,7 +526,7 @@ func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		claimed, err := store.ClaimReadyPackage(ctx, build.ID)
 		require.NoError(t, err)
@@ -184,7 +542,7 @@ func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// Claim and complete pkg-a
 		store.ClaimReadyPackage(ctx, build.ID)
@@ -206,7 +564,7 @@ func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// Claim and fail pkg-a
 		store.ClaimReadyPackage(ctx, build.ID)
@@ -233,7 +591,7 @@ func TestMemoryBuildStore_ClaimReadyPackage(t *testing.T) {
 		packages := []dag.Node{
 			{Name: "pkg-a", Dependencies: []string{"external-dep"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// pkg-a depends on external-dep which isn't in the build
 		// So pkg-a should be claimable
@@ -249,7 +607,7 @@ func TestMemoryBuildStore_UpdatePackageJob(t *testing.T) {
 	store := NewMemoryBuildStore()
 
 	packages := []dag.Node{{Name: "test-pkg"}}
-	build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 	t.Run("update existing package", func(t *testing.T) {
 		now := time.Now()
@@ -261,7 +619,7 @@ func TestMemoryBuildStore_UpdatePackageJob(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		updated, _ := store.GetBuild(ctx, build.ID)
+		updated, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 		assert.Equal(t, types.PackageStatusSuccess, updated.Packages[0].Status)
 		assert.NotNil(t, updated.Packages[0].FinishedAt)
 		assert.Equal(t, "/logs/test.log", updated.Packages[0].LogPath)
@@ -290,17 +648,17 @@ func TestMemoryBuildStore_CopyBuild(t *testing.T) {
 	spec := types.BuildSpec{
 		Pipelines: map[string]string{"p1.yaml": "content1"},
 	}
-	build, _ := store.CreateBuild(ctx, packages, spec)
+	build, _ := store.CreateBuild(ctx, DefaultTenant, packages, spec)
 
 	// Get a copy
-	copy, _ := store.GetBuild(ctx, build.ID)
+	copy, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 
 	// Modify the copy's slices and maps
 	copy.Packages[0].Dependencies[0] = "modified"
 	copy.Packages[0].Pipelines["p1.yaml"] = "modified"
 
 	// Get another copy and verify original is unchanged
-	original, _ := store.GetBuild(ctx, build.ID)
+	original, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 	assert.Equal(t, "dep-1", original.Packages[0].Dependencies[0])
 	assert.Equal(t, "content1", original.Packages[0].Pipelines["p1.yaml"])
 }
@@ -317,9 +675,9 @@ func TestMemoryBuildStore_ListActiveBuilds(t *testing.T) {
 
 	t.Run("returns only active builds", func(t *testing.T) {
 		// Create three builds
-		build1, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
-		build2, _ := store.CreateBuild(ctx, []dag.Node{{Name: "b"}}, types.BuildSpec{})
-		build3, _ := store.CreateBuild(ctx, []dag.Node{{Name: "c"}}, types.BuildSpec{})
+		build1, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		build2, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "b"}}, types.BuildSpec{})
+		build3, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "c"}}, types.BuildSpec{})
 
 		// Complete build2 (success)
 		build2.Status = types.BuildStatusSuccess
@@ -341,7 +699,7 @@ func TestMemoryBuildStore_ListActiveBuilds(t *testing.T) {
 
 	t.Run("running builds are active", func(t *testing.T) {
 		store := NewMemoryBuildStore()
-		build, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 
 		build.Status = types.BuildStatusRunning
 		now := time.Now()
@@ -356,7 +714,7 @@ func TestMemoryBuildStore_ListActiveBuilds(t *testing.T) {
 
 	t.Run("partial builds are not active", func(t *testing.T) {
 		store := NewMemoryBuildStore()
-		build, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 
 		build.Status = types.BuildStatusPartial
 		now := time.Now()
@@ -382,9 +740,9 @@ func TestMemoryBuildStore_Stats(t *testing.T) {
 
 	t.Run("mixed builds", func(t *testing.T) {
 		// Create builds with different statuses
-		_, _ = store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{}) // stays pending (active)
-		build2, _ := store.CreateBuild(ctx, []dag.Node{{Name: "b"}}, types.BuildSpec{})
-		build3, _ := store.CreateBuild(ctx, []dag.Node{{Name: "c"}}, types.BuildSpec{})
+		_, _ = store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{}) // stays pending (active)
+		build2, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "b"}}, types.BuildSpec{})
+		build3, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "c"}}, types.BuildSpec{})
 
 		// build2 becomes success (completed)
 		build2.Status = types.BuildStatusSuccess
@@ -430,14 +788,14 @@ func TestMemoryBuildStore_Eviction(t *testing.T) {
 		)
 
 		// Create and complete a build
-		build, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 		build.Status = types.BuildStatusSuccess
 		now := time.Now()
 		build.FinishedAt = &now
 		store.UpdateBuild(ctx, build)
 
 		// Build should exist initially
-		_, err := store.GetBuild(ctx, build.ID)
+		_, err := store.GetBuild(ctx, DefaultTenant, build.ID)
 		require.NoError(t, err)
 
 		// Wait for TTL to expire
@@ -447,7 +805,7 @@ func TestMemoryBuildStore_Eviction(t *testing.T) {
 		store.evictOldBuilds()
 
 		// Build should be evicted
-		_, err = store.GetBuild(ctx, build.ID)
+		_, err = store.GetBuild(ctx, DefaultTenant, build.ID)
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "build not found")
 	})
@@ -462,7 +820,7 @@ func TestMemoryBuildStore_Eviction(t *testing.T) {
 		// Create and complete 4 builds
 		var buildIDs []string
 		for i := 0; i < 4; i++ {
-			build, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+			build, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 			build.Status = types.BuildStatusSuccess
 			now := time.Now()
 			build.FinishedAt = &now
@@ -476,16 +834,16 @@ func TestMemoryBuildStore_Eviction(t *testing.T) {
 
 		// Only the 2 most recent should remain
 		// Oldest 2 should be evicted
-		_, err := store.GetBuild(ctx, buildIDs[0])
+		_, err := store.GetBuild(ctx, DefaultTenant, buildIDs[0])
 		assert.Error(t, err, "oldest build should be evicted")
 
-		_, err = store.GetBuild(ctx, buildIDs[1])
+		_, err = store.GetBuild(ctx, DefaultTenant, buildIDs[1])
 		assert.Error(t, err, "second oldest build should be evicted")
 
-		_, err = store.GetBuild(ctx, buildIDs[2])
+		_, err = store.GetBuild(ctx, DefaultTenant, buildIDs[2])
 		assert.NoError(t, err, "third build should remain")
 
-		_, err = store.GetBuild(ctx, buildIDs[3])
+		_, err = store.GetBuild(ctx, DefaultTenant, buildIDs[3])
 		assert.NoError(t, err, "newest build should remain")
 	})
 
@@ -496,13 +854,13 @@ func TestMemoryBuildStore_Eviction(t *testing.T) {
 		)
 
 		// Create a build but don't complete it
-		build, _ := store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 
 		time.Sleep(50 * time.Millisecond)
 		store.evictOldBuilds()
 
 		// Active build should still exist
-		_, err := store.GetBuild(ctx, build.ID)
+		_, err := store.GetBuild(ctx, DefaultTenant, build.ID)
 		require.NoError(t, err)
 	})
 }
@@ -0,0 +1,4 @@
+-- Migration: 002_rotated_log_paths (rollback)
+-- Description: Drop the rotated build log paths of package jobs
+
+ALTER TABLE package_jobs DROP COLUMN IF EXISTS rotated_log_paths;
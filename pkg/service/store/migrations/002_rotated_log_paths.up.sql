@@ -0,0 +1,4 @@
+-- Migration: 002_rotated_log_paths
+-- Description: Record the rotated parts of each package's build log
+
+ALTER TABLE package_jobs ADD COLUMN rotated_log_paths TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+-- Migration: 003_idempotency_key (rollback)
+-- Description: Drop the idempotency keys of builds
+
+ALTER TABLE builds DROP COLUMN IF EXISTS idempotency_key;
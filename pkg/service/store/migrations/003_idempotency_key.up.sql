@@ -0,0 +1,4 @@
+-- Migration: 003_idempotency_key
+-- Description: Record the client-supplied idempotency key of each build
+
+ALTER TABLE builds ADD COLUMN idempotency_key TEXT UNIQUE;
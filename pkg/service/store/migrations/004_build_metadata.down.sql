@@ -0,0 +1,4 @@
+-- Migration: 004_build_metadata (rollback)
+-- Description: Drop the metadata of builds
+
+ALTER TABLE builds DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,4 @@
+-- Migration: 004_build_metadata
+-- Description: Record arbitrary key/values describing the context of each build
+
+ALTER TABLE builds ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,4 @@
+-- Migration: 005_package_jobs_name (rollback)
+-- Description: Drop the index of package jobs by name
+
+DROP INDEX IF EXISTS idx_package_jobs_name;
@@ -0,0 +1,4 @@
+-- Migration: 005_package_jobs_name
+-- Description: Index package jobs by name, to find the builds of a package
+
+CREATE INDEX idx_package_jobs_name ON package_jobs(name);
@@ -0,0 +1,4 @@
+-- Migration: 006_package_jobs_warnings (rollback)
+-- Description: Drop the warnings of package jobs
+
+ALTER TABLE package_jobs DROP COLUMN IF EXISTS warnings;
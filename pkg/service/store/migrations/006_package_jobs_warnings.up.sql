@@ -0,0 +1,4 @@
+-- Migration: 006_package_jobs_warnings
+-- Description: Record the warnings of each package's build
+
+ALTER TABLE package_jobs ADD COLUMN warnings JSONB;
@@ -0,0 +1,19 @@
+-- Migration: 007_build_tenant (rollback)
+-- Description: Drop the tenants of builds
+
+ALTER TABLE builds DROP CONSTRAINT IF EXISTS builds_tenant_idempotency_key_key;
+
+-- Keys were only unique within a tenant: keep each on its earliest build,
+-- so that they can be unique again.
+UPDATE builds SET idempotency_key = NULL
+WHERE idempotency_key IS NOT NULL AND id NOT IN (
+    SELECT DISTINCT ON (idempotency_key) id FROM builds
+    WHERE idempotency_key IS NOT NULL
+    ORDER BY idempotency_key, created_at, id
+);
+
+ALTER TABLE builds ADD CONSTRAINT builds_idempotency_key_key UNIQUE (idempotency_key);
+
+DROP INDEX IF EXISTS idx_builds_tenant_created_at;
+
+ALTER TABLE builds DROP COLUMN IF EXISTS tenant;
@@ -0,0 +1,9 @@
+-- Migration: 007_build_tenant
+-- Description: Record the tenant of each build, and scope idempotency keys to it
+
+ALTER TABLE builds ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+
+CREATE INDEX idx_builds_tenant_created_at ON builds(tenant, created_at);
+
+ALTER TABLE builds DROP CONSTRAINT IF EXISTS builds_idempotency_key_key;
+ALTER TABLE builds ADD CONSTRAINT builds_tenant_idempotency_key_key UNIQUE (tenant, idempotency_key);
@@ -150,35 +150,72 @@ func (s *PostgresBuildStore) Ping(ctx context.Context) error {
 }
 
 // CreateBuild creates a new multi-package build.
-func (s *PostgresBuildStore) CreateBuild(ctx context.Context, packages []dag.Node, spec types.BuildSpec) (*types.Build, error) {
+func (s *PostgresBuildStore) CreateBuild(ctx context.Context, tenant string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (*types.Build, error) {
+	build, _, err := s.createBuild(ctx, tenant, "", packages, spec, createBuildOptions(opts))
+	return build, err
+}
+
+// CreateBuildWithKey creates a new multi-package build, or returns the build
+// already created with the same idempotency key. The unique constraint on
+// the key makes concurrent requests with the same key create one build.
+func (s *PostgresBuildStore) CreateBuildWithKey(ctx context.Context, tenant, key string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (*types.Build, bool, error) {
+	return s.createBuild(ctx, tenant, key, packages, spec, createBuildOptions(opts))
+}
+
+// createBuild inserts a build of tenant, with its metadata, and its package
+// jobs. An empty key creates a build without an idempotency key.
+func (s *PostgresBuildStore) createBuild(ctx context.Context, tenant, key string, packages []dag.Node, spec types.BuildSpec, o createOptions) (*types.Build, bool, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, false, err
+	}
+
 	buildID := "bld-" + uuid.New().String()[:8]
 	now := time.Now()
 
 	specJSON, err := json.Marshal(spec)
 	if err != nil {
-		return nil, fmt.Errorf("marshaling spec: %w", err)
+		return nil, false, fmt.Errorf("marshaling spec: %w", err)
+	}
+	metadataJSON := []byte("{}")
+	if len(o.metadata) > 0 {
+		metadataJSON, err = json.Marshal(o.metadata)
+		if err != nil {
+			return nil, false, fmt.Errorf("marshaling metadata: %w", err)
+		}
 	}
 
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("beginning transaction: %w", err)
+		return nil, false, fmt.Errorf("beginning transaction: %w", err)
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	// Insert build
-	_, err = tx.Exec(ctx, `
-		INSERT INTO builds (id, status, created_at, spec)
-		VALUES ($1, 'pending', $2, $3)
-	`, buildID, now, specJSON)
+	// Insert build, unless one of the tenant was already created with the
+	// same key
+	result, err := tx.Exec(ctx, `
+		INSERT INTO builds (id, status, created_at, spec, idempotency_key, tenant, metadata)
+		VALUES ($1, 'pending', $2, $3, NULLIF($4, ''), $5, $6)
+		ON CONFLICT (tenant, idempotency_key) DO NOTHING
+	`, buildID, now, specJSON, key, tenant, metadataJSON)
 	if err != nil {
-		return nil, fmt.Errorf("inserting build: %w", err)
+		return nil, false, fmt.Errorf("inserting build: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		var existingID string
+		if err := tx.QueryRow(ctx, `
+			SELECT id FROM builds WHERE tenant = $1 AND idempotency_key = $2
+		`, tenant, key).Scan(&existingID); err != nil {
+			return nil, false, fmt.Errorf("querying build with idempotency key: %w", err)
+		}
+		build, err := s.GetBuild(ctx, tenant, existingID)
+		return build, false, err
 	}
 
 	// Insert package jobs
 	for i, node := range packages {
 		pipelinesJSON, err := json.Marshal(spec.Pipelines)
 		if err != nil {
-			return nil, fmt.Errorf("marshaling pipelines: %w", err)
+			return nil, false, fmt.Errorf("marshaling pipelines: %w", err)
 		}
 
 		sourceFilesJSON := []byte("{}")
@@ -186,7 +223,7 @@ func (s *PostgresBuildStore) CreateBuild(ctx context.Context, packages []dag.Nod
 			if sf, ok := spec.SourceFiles[node.Name]; ok {
 				sourceFilesJSON, err = json.Marshal(sf)
 				if err != nil {
-					return nil, fmt.Errorf("marshaling source files: %w", err)
+					return nil, false, fmt.Errorf("marshaling source files: %w", err)
 				}
 			}
 		}
@@ -202,29 +239,34 @@ func (s *PostgresBuildStore) CreateBuild(ctx context.Context, packages []dag.Nod
 			VALUES ($1, $2, 'pending', $3, $4, $5, $6, $7)
 		`, buildID, node.Name, node.ConfigYAML, deps, pipelinesJSON, sourceFilesJSON, i)
 		if err != nil {
-			return nil, fmt.Errorf("inserting package job %s: %w", node.Name, err)
+			return nil, false, fmt.Errorf("inserting package job %s: %w", node.Name, err)
 		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return nil, fmt.Errorf("committing transaction: %w", err)
+		return nil, false, fmt.Errorf("committing transaction: %w", err)
 	}
 
 	// Return the created build
-	return s.GetBuild(ctx, buildID)
+	build, err := s.GetBuild(ctx, tenant, buildID)
+	return build, true, err
 }
 
-// GetBuild retrieves a build by ID.
-func (s *PostgresBuildStore) GetBuild(ctx context.Context, id string) (*types.Build, error) {
+// GetBuild retrieves a build of tenant by ID.
+func (s *PostgresBuildStore) GetBuild(ctx context.Context, tenant, id string) (*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
 	var build types.Build
-	var specJSON []byte
+	var specJSON, metadataJSON []byte
 
 	err := s.pool.QueryRow(ctx, `
-		SELECT id, status, created_at, started_at, finished_at, spec
-		FROM builds WHERE id = $1
-	`, id).Scan(
+		SELECT id, status, created_at, started_at, finished_at, spec, COALESCE(idempotency_key, ''), metadata, tenant
+		FROM builds WHERE id = $1 AND tenant = $2
+	`, id, tenant).Scan(
 		&build.ID, &build.Status, &build.CreatedAt,
-		&build.StartedAt, &build.FinishedAt, &specJSON,
+		&build.StartedAt, &build.FinishedAt, &specJSON, &build.IdempotencyKey, &metadataJSON, &build.Tenant,
 	)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, fmt.Errorf("%w: %s", svcerrors.ErrBuildNotFound, id)
@@ -236,11 +278,17 @@ func (s *PostgresBuildStore) GetBuild(ctx context.Context, id string) (*types.Bu
 	if err := json.Unmarshal(specJSON, &build.Spec); err != nil {
 		return nil, fmt.Errorf("unmarshaling spec: %w", err)
 	}
+	if err := json.Unmarshal(metadataJSON, &build.Metadata); err != nil {
+		return nil, fmt.Errorf("unmarshaling metadata: %w", err)
+	}
+	if len(build.Metadata) == 0 {
+		build.Metadata = nil
+	}
 
 	// Query package jobs
 	rows, err := s.pool.Query(ctx, `
 		SELECT name, status, config_yaml, dependencies, started_at, finished_at,
-		       error, log_path, output_path, backend, pipelines, source_files, metrics
+		       error, log_path, rotated_log_paths, output_path, backend, pipelines, source_files, metrics, warnings
 		FROM package_jobs
 		WHERE build_id = $1
 		ORDER BY position
@@ -255,6 +303,7 @@ func (s *PostgresBuildStore) GetBuild(ctx context.Context, id string) (*types.Bu
 		if err != nil {
 			return nil, fmt.Errorf("scanning package job: %w", err)
 		}
+		pkg.Tenant = build.Tenant
 		build.Packages = append(build.Packages, *pkg)
 	}
 
@@ -282,12 +331,52 @@ func (s *PostgresBuildStore) UpdateBuild(ctx context.Context, build *types.Build
 	return nil
 }
 
-// ListBuilds returns all builds.
-func (s *PostgresBuildStore) ListBuilds(ctx context.Context) ([]*types.Build, error) {
+// UpdateBuildMetadata merges patch into the metadata of a build in a single
+// statement, so that concurrent patches of different keys are all kept.
+func (s *PostgresBuildStore) UpdateBuildMetadata(ctx context.Context, tenant, id string, patch map[string]string) error {
+	if err := checkTenant(tenant); err != nil {
+		return err
+	}
+
+	set := make(map[string]string, len(patch))
+	removed := []string{}
+	for k, v := range patch {
+		if v == "" {
+			removed = append(removed, k)
+		} else {
+			set[k] = v
+		}
+	}
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("marshaling metadata: %w", err)
+	}
+
+	result, err := s.pool.Exec(ctx, `
+		UPDATE builds
+		SET metadata = (metadata || $2::jsonb) - $3::TEXT[]
+		WHERE id = $1 AND tenant = $4
+	`, id, setJSON, removed, tenant)
+	if err != nil {
+		return fmt.Errorf("updating build metadata: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", svcerrors.ErrBuildNotFound, id)
+	}
+	return nil
+}
+
+// ListBuilds returns all builds of tenant.
+func (s *PostgresBuildStore) ListBuilds(ctx context.Context, tenant string) ([]*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
 	rows, err := s.pool.Query(ctx, `
 		SELECT id FROM builds
+		WHERE tenant = $1
 		ORDER BY created_at
-	`)
+	`, tenant)
 	if err != nil {
 		return nil, fmt.Errorf("querying builds: %w", err)
 	}
@@ -299,7 +388,7 @@ func (s *PostgresBuildStore) ListBuilds(ctx context.Context) ([]*types.Build, er
 		if err := rows.Scan(&id); err != nil {
 			return nil, fmt.Errorf("scanning build id: %w", err)
 		}
-		build, err := s.GetBuild(ctx, id)
+		build, err := s.GetBuild(ctx, tenant, id)
 		if err != nil {
 			return nil, fmt.Errorf("getting build %s: %w", id, err)
 		}
@@ -313,10 +402,90 @@ func (s *PostgresBuildStore) ListBuilds(ctx context.Context) ([]*types.Build, er
 	return builds, nil
 }
 
+// ListBuildsByPackage returns the builds of tenant with a package named name.
+func (s *PostgresBuildStore) ListBuildsByPackage(ctx context.Context, tenant, name string) ([]*types.Build, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT b.id FROM builds b
+		WHERE EXISTS (
+			SELECT 1 FROM package_jobs p WHERE p.build_id = b.id AND p.name = $1
+		)
+		AND b.tenant = $2
+		ORDER BY b.created_at
+	`, name, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("querying builds of package %s: %w", name, err)
+	}
+	defer rows.Close()
+
+	var builds []*types.Build
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning build id: %w", err)
+		}
+		build, err := s.GetBuild(ctx, tenant, id)
+		if err != nil {
+			return nil, fmt.Errorf("getting build %s: %w", id, err)
+		}
+		builds = append(builds, build)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating builds of package %s: %w", name, err)
+	}
+
+	return builds, nil
+}
+
+// CountBuilds counts the builds of tenant matching filter, grouped by status.
+func (s *PostgresBuildStore) CountBuilds(ctx context.Context, tenant string, filter BuildFilter) (map[types.BuildStatus]int, error) {
+	if err := checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	var since *time.Time
+	if !filter.Since.IsZero() {
+		since = &filter.Since
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT b.status, COUNT(*) FROM builds b
+		WHERE ($1 = '' OR EXISTS (
+			SELECT 1 FROM package_jobs p WHERE p.build_id = b.id AND p.name = $1
+		))
+		AND ($2::TIMESTAMPTZ IS NULL OR b.created_at >= $2)
+		AND b.tenant = $3
+		GROUP BY b.status
+	`, filter.Package, since, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("counting builds: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[types.BuildStatus]int)
+	for rows.Next() {
+		var status types.BuildStatus
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("scanning build count: %w", err)
+		}
+		counts[status] = n
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating build counts: %w", err)
+	}
+
+	return counts, nil
+}
+
 // ListActiveBuilds returns only non-terminal builds (pending/running).
 func (s *PostgresBuildStore) ListActiveBuilds(ctx context.Context) ([]*types.Build, error) {
 	rows, err := s.pool.Query(ctx, `
-		SELECT id FROM builds
+		SELECT id, tenant FROM builds
 		WHERE status IN ('pending', 'running')
 		ORDER BY created_at
 	`)
@@ -327,11 +496,11 @@ func (s *PostgresBuildStore) ListActiveBuilds(ctx context.Context) ([]*types.Bui
 
 	var builds []*types.Build
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var id, tenant string
+		if err := rows.Scan(&id, &tenant); err != nil {
 			return nil, fmt.Errorf("scanning build id: %w", err)
 		}
-		build, err := s.GetBuild(ctx, id)
+		build, err := s.GetBuild(ctx, tenant, id)
 		if err != nil {
 			return nil, fmt.Errorf("getting build %s: %w", id, err)
 		}
@@ -457,18 +626,20 @@ func (s *PostgresBuildStore) ClaimReadyPackage(ctx context.Context, buildID stri
 
 	// Fetch the full package job to return
 	var pkg types.PackageJob
-	var backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON []byte
+	var backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON, warningsJSON []byte
 	var errorStr, logPath, outputPath *string
 
 	err = s.pool.QueryRow(ctx, `
-		SELECT name, status, config_yaml, dependencies, started_at, finished_at,
-		       error, log_path, output_path, backend, pipelines, source_files, metrics
-		FROM package_jobs
-		WHERE build_id = $1 AND name = $2
+		SELECT p.name, p.status, p.config_yaml, p.dependencies, p.started_at, p.finished_at,
+		       p.error, p.log_path, p.rotated_log_paths, p.output_path, p.backend, p.pipelines, p.source_files, p.metrics, p.warnings,
+		       b.tenant
+		FROM package_jobs p JOIN builds b ON b.id = p.build_id
+		WHERE p.build_id = $1 AND p.name = $2
 	`, buildID, claimName).Scan(
 		&pkg.Name, &pkg.Status, &pkg.ConfigYAML, &pkg.Dependencies,
-		&pkg.StartedAt, &pkg.FinishedAt, &errorStr, &logPath,
-		&outputPath, &backendJSON, &pipelinesJSON, &sourceFilesJSON, &metricsJSON,
+		&pkg.StartedAt, &pkg.FinishedAt, &errorStr, &logPath, &pkg.RotatedLogPaths,
+		&outputPath, &backendJSON, &pipelinesJSON, &sourceFilesJSON, &metricsJSON, &warningsJSON,
+		&pkg.Tenant,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("fetching claimed package: %w", err)
@@ -504,13 +675,18 @@ func (s *PostgresBuildStore) ClaimReadyPackage(ctx context.Context, buildID stri
 			return nil, fmt.Errorf("unmarshaling metrics: %w", err)
 		}
 	}
+	if len(warningsJSON) > 0 && string(warningsJSON) != "null" {
+		if err := json.Unmarshal(warningsJSON, &pkg.Warnings); err != nil {
+			return nil, fmt.Errorf("unmarshaling warnings: %w", err)
+		}
+	}
 
 	return &pkg, nil
 }
 
 // UpdatePackageJob updates a package job within a build.
 func (s *PostgresBuildStore) UpdatePackageJob(ctx context.Context, buildID string, pkg *types.PackageJob) error {
-	var backendJSON, metricsJSON, pipelinesJSON, sourceFilesJSON []byte
+	var backendJSON, metricsJSON, pipelinesJSON, sourceFilesJSON, warningsJSON []byte
 	var err error
 
 	if pkg.Backend != nil {
@@ -527,6 +703,13 @@ func (s *PostgresBuildStore) UpdatePackageJob(ctx context.Context, buildID strin
 		}
 	}
 
+	if pkg.Warnings != nil {
+		warningsJSON, err = json.Marshal(pkg.Warnings)
+		if err != nil {
+			return fmt.Errorf("marshaling warnings: %w", err)
+		}
+	}
+
 	if pkg.Pipelines != nil {
 		pipelinesJSON, err = json.Marshal(pkg.Pipelines)
 		if err != nil {
@@ -551,10 +734,12 @@ func (s *PostgresBuildStore) UpdatePackageJob(ctx context.Context, buildID strin
 		UPDATE package_jobs
 		SET status = $3, started_at = $4, finished_at = $5, error = $6,
 		    log_path = $7, output_path = $8, backend = $9, pipelines = COALESCE($10, pipelines),
-		    source_files = COALESCE($11, source_files), metrics = $12
+		    source_files = COALESCE($11, source_files), metrics = $12,
+		    rotated_log_paths = COALESCE($13::TEXT[], '{}'), warnings = $14
 		WHERE build_id = $1 AND name = $2
 	`, buildID, pkg.Name, pkg.Status, pkg.StartedAt, pkg.FinishedAt, errorPtr,
-		pkg.LogPath, pkg.OutputPath, backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON)
+		pkg.LogPath, pkg.OutputPath, backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON,
+		pkg.RotatedLogPaths, warningsJSON)
 
 	if err != nil {
 		return fmt.Errorf("updating package job: %w", err)
@@ -568,13 +753,13 @@ func (s *PostgresBuildStore) UpdatePackageJob(ctx context.Context, buildID strin
 // scanPackageJob scans a package job from a database row.
 func scanPackageJob(rows pgx.Rows) (*types.PackageJob, error) {
 	var pkg types.PackageJob
-	var backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON []byte
+	var backendJSON, pipelinesJSON, sourceFilesJSON, metricsJSON, warningsJSON []byte
 	var errorStr, logPath, outputPath *string
 
 	err := rows.Scan(
 		&pkg.Name, &pkg.Status, &pkg.ConfigYAML, &pkg.Dependencies,
-		&pkg.StartedAt, &pkg.FinishedAt, &errorStr, &logPath,
-		&outputPath, &backendJSON, &pipelinesJSON, &sourceFilesJSON, &metricsJSON,
+		&pkg.StartedAt, &pkg.FinishedAt, &errorStr, &logPath, &pkg.RotatedLogPaths,
+		&outputPath, &backendJSON, &pipelinesJSON, &sourceFilesJSON, &metricsJSON, &warningsJSON,
 	)
 	if err != nil {
 		return nil, err
@@ -610,6 +795,11 @@ func scanPackageJob(rows pgx.Rows) (*types.PackageJob, error) {
 			return nil, fmt.Errorf("unmarshaling metrics: %w", err)
 		}
 	}
+	if len(warningsJSON) > 0 && string(warningsJSON) != "null" {
+		if err := json.Unmarshal(warningsJSON, &pkg.Warnings); err != nil {
+			return nil, fmt.Errorf("unmarshaling warnings: %w", err)
+		}
+	}
 
 	return &pkg, nil
 }
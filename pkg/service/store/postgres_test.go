@@ -27,6 +27,7 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 
 	"github.com/dlorenc/melange2/pkg/service/dag"
+	svcerrors "github.com/dlorenc/melange2/pkg/service/errors"
 	"github.com/dlorenc/melange2/pkg/service/types"
 )
 
@@ -99,7 +100,9 @@ func TestPostgresBuildStore_CreateBuild(t *testing.T) {
 		Pipelines: map[string]string{"test.yaml": "content"},
 	}
 
-	build, err := store.CreateBuild(ctx, packages, spec)
+	metadata := map[string]string{"user": "alice", "reviewer": ""}
+
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, spec, WithMetadata(metadata))
 	require.NoError(t, err)
 	require.NotNil(t, build)
 
@@ -111,6 +114,59 @@ func TestPostgresBuildStore_CreateBuild(t *testing.T) {
 	assert.Equal(t, "pkg-b", build.Packages[1].Name)
 	assert.Equal(t, []string{"pkg-a"}, build.Packages[1].Dependencies)
 	assert.False(t, build.CreatedAt.IsZero())
+	// Metadata is recorded with the build as given, empty values included.
+	assert.Equal(t, metadata, build.Metadata)
+}
+
+func TestPostgresBuildStore_CreateBuildWithKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping PostgreSQL test in short mode")
+	}
+
+	store, cleanup := setupTestPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	packages := []dag.Node{{Name: "pkg-a", ConfigYAML: "package:\n  name: pkg-a"}}
+
+	first, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", packages, types.BuildSpec{})
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.Equal(t, "key-1", first.IdempotencyKey)
+
+	again, created, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-1", []dag.Node{{Name: "other"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	require.False(t, created)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, "pkg-a", again.Packages[0].Name)
+
+	// Builds without a key never conflict with each other
+	a, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
+	require.NoError(t, err)
+	b, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.NotEqual(t, a.ID, b.ID)
+	assert.Empty(t, a.IdempotencyKey)
+
+	// Concurrent requests with the same key create one build
+	var wg sync.WaitGroup
+	ids := make([]string, 5)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			build, _, err := store.CreateBuildWithKey(ctx, DefaultTenant, "key-2", packages, types.BuildSpec{})
+			assert.NoError(t, err)
+			if build != nil {
+				ids[i] = build.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
 }
 
 func TestPostgresBuildStore_GetBuild(t *testing.T) {
@@ -124,17 +180,17 @@ func TestPostgresBuildStore_GetBuild(t *testing.T) {
 	ctx := context.Background()
 
 	packages := []dag.Node{{Name: "test"}}
-	created, err := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	created, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 	require.NoError(t, err)
 
 	t.Run("existing build", func(t *testing.T) {
-		build, err := store.GetBuild(ctx, created.ID)
+		build, err := store.GetBuild(ctx, DefaultTenant, created.ID)
 		require.NoError(t, err)
 		assert.Equal(t, created.ID, build.ID)
 	})
 
 	t.Run("non-existent build", func(t *testing.T) {
-		_, err := store.GetBuild(ctx, "non-existent")
+		_, err := store.GetBuild(ctx, DefaultTenant, "non-existent")
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "build not found")
 	})
@@ -151,7 +207,7 @@ func TestPostgresBuildStore_UpdateBuild(t *testing.T) {
 	ctx := context.Background()
 
 	packages := []dag.Node{{Name: "test"}}
-	build, err := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 	require.NoError(t, err)
 
 	t.Run("update existing build", func(t *testing.T) {
@@ -159,7 +215,7 @@ func TestPostgresBuildStore_UpdateBuild(t *testing.T) {
 		err := store.UpdateBuild(ctx, build)
 		require.NoError(t, err)
 
-		updated, _ := store.GetBuild(ctx, build.ID)
+		updated, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 		assert.Equal(t, types.BuildStatusRunning, updated.Status)
 	})
 
@@ -173,6 +229,61 @@ func TestPostgresBuildStore_UpdateBuild(t *testing.T) {
 	})
 }
 
+func TestPostgresBuildStore_UpdateBuildMetadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping PostgreSQL test in short mode")
+	}
+
+	store, cleanup := setupTestPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	build, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "test"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Nil(t, build.Metadata)
+
+	t.Run("set metadata", func(t *testing.T) {
+		require.NoError(t, store.UpdateBuildMetadata(ctx, DefaultTenant, build.ID, map[string]string{
+			"user":   "alice",
+			"ci-run": "https://ci.example.com/runs/1",
+		}))
+
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"user": "alice", "ci-run": "https://ci.example.com/runs/1"}, got.Metadata)
+	})
+
+	t.Run("patch metadata", func(t *testing.T) {
+		require.NoError(t, store.UpdateBuildMetadata(ctx, DefaultTenant, build.ID, map[string]string{
+			"ci-run": "https://ci.example.com/runs/2",
+			"pr":     "42",
+			"user":   "",
+		}))
+
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"ci-run": "https://ci.example.com/runs/2", "pr": "42"}, got.Metadata)
+	})
+
+	t.Run("kept by UpdateBuild", func(t *testing.T) {
+		got, err := store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		got.Status = types.BuildStatusRunning
+		require.NoError(t, store.UpdateBuild(ctx, got))
+
+		got, err = store.GetBuild(ctx, DefaultTenant, build.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"ci-run": "https://ci.example.com/runs/2", "pr": "42"}, got.Metadata)
+	})
+
+	t.Run("non-existent build", func(t *testing.T) {
+		err := store.UpdateBuildMetadata(ctx, DefaultTenant, "non-existent", map[string]string{"user": "alice"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "build not found")
+	})
+}
+
 func TestPostgresBuildStore_ListBuilds(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping PostgreSQL test in short mode")
@@ -184,19 +295,19 @@ func TestPostgresBuildStore_ListBuilds(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("empty store", func(t *testing.T) {
-		builds, err := store.ListBuilds(ctx)
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
 		require.NoError(t, err)
 		assert.Empty(t, builds)
 	})
 
 	t.Run("returns all builds sorted by creation time", func(t *testing.T) {
-		store.CreateBuild(ctx, []dag.Node{{Name: "a"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "a"}}, types.BuildSpec{})
 		time.Sleep(10 * time.Millisecond)
-		store.CreateBuild(ctx, []dag.Node{{Name: "b"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "b"}}, types.BuildSpec{})
 		time.Sleep(10 * time.Millisecond)
-		store.CreateBuild(ctx, []dag.Node{{Name: "c"}}, types.BuildSpec{})
+		store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "c"}}, types.BuildSpec{})
 
-		builds, err := store.ListBuilds(ctx)
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
 		require.NoError(t, err)
 		assert.Len(t, builds, 3)
 
@@ -208,6 +319,263 @@ func TestPostgresBuildStore_ListBuilds(t *testing.T) {
 	})
 }
 
+func TestPostgresBuildStore_ListBuildsByPackage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping PostgreSQL test in short mode")
+	}
+
+	store, cleanup := setupTestPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	first, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "zlib"}, {Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "openssl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	second, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+
+	t.Run("builds containing the package", func(t *testing.T) {
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		require.Len(t, builds, 2)
+		assert.Equal(t, first.ID, builds[0].ID)
+		assert.Equal(t, second.ID, builds[1].ID)
+		assert.Len(t, builds[0].Packages, 2)
+	})
+
+	t.Run("build containing the package twice", func(t *testing.T) {
+		dup, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "zstd"}, {Name: "zstd"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "zstd")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, dup.ID, builds[0].ID)
+	})
+
+	t.Run("no build contains the package", func(t *testing.T) {
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl-dev")
+		require.NoError(t, err)
+		assert.Empty(t, builds)
+	})
+}
+
+func TestPostgresBuildStore_CountBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping PostgreSQL test in short mode")
+	}
+
+	store, cleanup := setupTestPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	t.Run("empty store", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+	})
+
+	statuses := []types.BuildStatus{
+		types.BuildStatusPending,
+		types.BuildStatusRunning,
+		types.BuildStatusSuccess,
+		types.BuildStatusSuccess,
+		types.BuildStatusFailed,
+		types.BuildStatusPartial,
+	}
+	var since time.Time
+	for i, status := range statuses {
+		if i == 3 {
+			time.Sleep(10 * time.Millisecond)
+			since = time.Now()
+		}
+		names := []dag.Node{{Name: "zlib"}}
+		if i%2 == 0 {
+			names = append(names, dag.Node{Name: "curl"})
+		}
+		build, err := store.CreateBuild(ctx, DefaultTenant, names, types.BuildSpec{})
+		require.NoError(t, err)
+		build.Status = status
+		require.NoError(t, store.UpdateBuild(ctx, build))
+	}
+
+	// tally counts the statuses of the listed builds the filter selects.
+	tally := func(t *testing.T, builds []*types.Build, since time.Time) map[types.BuildStatus]int {
+		t.Helper()
+		counts := make(map[types.BuildStatus]int)
+		for _, b := range builds {
+			if !b.CreatedAt.Before(since) {
+				counts[b.Status]++
+			}
+		}
+		return counts
+	}
+
+	t.Run("all builds", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{
+			types.BuildStatusPending: 1,
+			types.BuildStatusRunning: 1,
+			types.BuildStatusSuccess: 2,
+			types.BuildStatusFailed:  1,
+			types.BuildStatusPartial: 1,
+		}, counts)
+
+		builds, err := store.ListBuilds(ctx, DefaultTenant)
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, time.Time{}), counts)
+	})
+
+	t.Run("builds of a package", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "curl"})
+		require.NoError(t, err)
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, time.Time{}), counts)
+		assert.Equal(t, 3, counts[types.BuildStatusPending]+counts[types.BuildStatusSuccess]+counts[types.BuildStatusFailed])
+
+		counts, err = store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "openssl"})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+	})
+
+	t.Run("builds created since", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, DefaultTenant, BuildFilter{Since: since})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{
+			types.BuildStatusSuccess: 1,
+			types.BuildStatusFailed:  1,
+			types.BuildStatusPartial: 1,
+		}, counts)
+
+		counts, err = store.CountBuilds(ctx, DefaultTenant, BuildFilter{Package: "curl", Since: since})
+		require.NoError(t, err)
+		builds, err := store.ListBuildsByPackage(ctx, DefaultTenant, "curl")
+		require.NoError(t, err)
+		assert.Equal(t, tally(t, builds, since), counts)
+	})
+}
+
+func TestPostgresBuildStore_Tenants(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping PostgreSQL test in short mode")
+	}
+
+	store, cleanup := setupTestPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	aliceBuild, err := store.CreateBuild(ctx, "alice", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", aliceBuild.Tenant)
+	bobBuild, err := store.CreateBuild(ctx, "bob", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", bobBuild.Tenant)
+	defaultBuild, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTenant, defaultBuild.Tenant)
+
+	t.Run("get", func(t *testing.T) {
+		build, err := store.GetBuild(ctx, "alice", aliceBuild.ID)
+		require.NoError(t, err)
+		assert.Equal(t, aliceBuild.ID, build.ID)
+
+		_, err = store.GetBuild(ctx, "alice", bobBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+		_, err = store.GetBuild(ctx, DefaultTenant, aliceBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+		err = store.UpdateBuildMetadata(ctx, "alice", bobBuild.ID, map[string]string{"user": "alice"})
+		require.ErrorIs(t, err, svcerrors.ErrBuildNotFound)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		builds, err := store.ListBuilds(ctx, "alice")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, aliceBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuildsByPackage(ctx, "bob", "curl")
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, bobBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuilds(ctx, DefaultTenant)
+		require.NoError(t, err)
+		require.Len(t, builds, 1)
+		assert.Equal(t, defaultBuild.ID, builds[0].ID)
+
+		builds, err = store.ListBuilds(ctx, "carol")
+		require.NoError(t, err)
+		assert.Empty(t, builds)
+	})
+
+	t.Run("count", func(t *testing.T) {
+		counts, err := store.CountBuilds(ctx, "alice", BuildFilter{Package: "curl"})
+		require.NoError(t, err)
+		assert.Equal(t, map[types.BuildStatus]int{types.BuildStatusPending: 1}, counts)
+	})
+
+	t.Run("jobs carry the tenant", func(t *testing.T) {
+		assert.Equal(t, "alice", aliceBuild.Packages[0].Tenant)
+
+		job, err := store.ClaimReadyPackage(ctx, bobBuild.ID)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		assert.Equal(t, "bob", job.Tenant)
+
+		job.Status = types.PackageStatusSuccess
+		require.NoError(t, store.UpdatePackageJob(ctx, bobBuild.ID, job))
+		build, err := store.GetBuild(ctx, "bob", bobBuild.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "bob", build.Packages[0].Tenant)
+	})
+
+	t.Run("no tenant is denied", func(t *testing.T) {
+		_, err := store.CreateBuild(ctx, "", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, _, err = store.CreateBuildWithKey(ctx, "", "key-1", []dag.Node{{Name: "curl"}}, types.BuildSpec{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.GetBuild(ctx, "", bobBuild.ID)
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		err = store.UpdateBuildMetadata(ctx, "", bobBuild.ID, map[string]string{"user": "mallory"})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.ListBuilds(ctx, "")
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.ListBuildsByPackage(ctx, "", "curl")
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+		_, err = store.CountBuilds(ctx, "", BuildFilter{})
+		require.ErrorIs(t, err, svcerrors.ErrNoTenant)
+	})
+
+	t.Run("active builds span tenants", func(t *testing.T) {
+		builds, err := store.ListActiveBuilds(ctx)
+		require.NoError(t, err)
+		assert.Len(t, builds, 3)
+	})
+
+	t.Run("idempotency keys are per tenant", func(t *testing.T) {
+		first, created, err := store.CreateBuildWithKey(ctx, "alice", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.True(t, created)
+
+		other, created, err := store.CreateBuildWithKey(ctx, "bob", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.True(t, created)
+		assert.NotEqual(t, first.ID, other.ID)
+
+		again, created, err := store.CreateBuildWithKey(ctx, "alice", "key-1", []dag.Node{{Name: "zlib"}}, types.BuildSpec{})
+		require.NoError(t, err)
+		require.False(t, created)
+		assert.Equal(t, first.ID, again.ID)
+	})
+}
+
 func TestPostgresBuildStore_ListActiveBuilds(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping PostgreSQL test in short mode")
@@ -219,11 +587,11 @@ func TestPostgresBuildStore_ListActiveBuilds(t *testing.T) {
 	ctx := context.Background()
 
 	// Create builds with different statuses
-	build1, err := store.CreateBuild(ctx, []dag.Node{{Name: "active1"}}, types.BuildSpec{})
+	build1, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "active1"}}, types.BuildSpec{})
 	require.NoError(t, err)
-	build2, err := store.CreateBuild(ctx, []dag.Node{{Name: "active2"}}, types.BuildSpec{})
+	build2, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "active2"}}, types.BuildSpec{})
 	require.NoError(t, err)
-	build3, err := store.CreateBuild(ctx, []dag.Node{{Name: "completed"}}, types.BuildSpec{})
+	build3, err := store.CreateBuild(ctx, DefaultTenant, []dag.Node{{Name: "completed"}}, types.BuildSpec{})
 	require.NoError(t, err)
 
 	// Complete build3
@@ -263,7 +631,7 @@ func TestPostgresBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		claimed, err := store.ClaimReadyPackage(ctx, build.ID)
 		require.NoError(t, err)
@@ -278,7 +646,7 @@ func TestPostgresBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// Claim and complete pkg-a
 		store.ClaimReadyPackage(ctx, build.ID)
@@ -299,7 +667,7 @@ func TestPostgresBuildStore_ClaimReadyPackage(t *testing.T) {
 			{Name: "pkg-a"},
 			{Name: "pkg-b", Dependencies: []string{"pkg-a"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// Claim and fail pkg-a
 		store.ClaimReadyPackage(ctx, build.ID)
@@ -318,7 +686,7 @@ func TestPostgresBuildStore_ClaimReadyPackage(t *testing.T) {
 		packages := []dag.Node{
 			{Name: "pkg-a", Dependencies: []string{"external-dep"}},
 		}
-		build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+		build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 		// pkg-a depends on external-dep which isn't in the build
 		// So pkg-a should be claimable
@@ -347,7 +715,7 @@ func TestPostgresBuildStore_ClaimReadyPackage_Concurrent(t *testing.T) {
 			ConfigYAML: fmt.Sprintf("package:\n  name: pkg-%d", i),
 		}
 	}
-	build, err := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 	require.NoError(t, err)
 
 	// Concurrently claim all packages
@@ -399,7 +767,7 @@ func TestPostgresBuildStore_UpdatePackageJob(t *testing.T) {
 	ctx := context.Background()
 
 	packages := []dag.Node{{Name: "test-pkg"}}
-	build, _ := store.CreateBuild(ctx, packages, types.BuildSpec{})
+	build, _ := store.CreateBuild(ctx, DefaultTenant, packages, types.BuildSpec{})
 
 	t.Run("update existing package", func(t *testing.T) {
 		now := time.Now()
@@ -412,15 +780,17 @@ func TestPostgresBuildStore_UpdatePackageJob(t *testing.T) {
 				TotalDurationMs:    1000,
 				BuildKitDurationMs: 500,
 			},
+			Warnings: []types.Warning{{Kind: "git", Message: "git repository URL for build config not provided"}},
 		})
 		require.NoError(t, err)
 
-		updated, _ := store.GetBuild(ctx, build.ID)
+		updated, _ := store.GetBuild(ctx, DefaultTenant, build.ID)
 		assert.Equal(t, types.PackageStatusSuccess, updated.Packages[0].Status)
 		assert.NotNil(t, updated.Packages[0].FinishedAt)
 		assert.Equal(t, "/logs/test.log", updated.Packages[0].LogPath)
 		require.NotNil(t, updated.Packages[0].Metrics)
 		assert.Equal(t, int64(1000), updated.Packages[0].Metrics.TotalDurationMs)
+		assert.Equal(t, []types.Warning{{Kind: "git", Message: "git repository URL for build config not provided"}}, updated.Packages[0].Warnings)
 	})
 
 	t.Run("non-existent package", func(t *testing.T) {
@@ -466,11 +836,11 @@ func TestPostgresBuildStore_SourceFiles(t *testing.T) {
 		},
 	}
 
-	build, err := store.CreateBuild(ctx, packages, spec)
+	build, err := store.CreateBuild(ctx, DefaultTenant, packages, spec)
 	require.NoError(t, err)
 
 	// Verify source files are stored
-	retrieved, err := store.GetBuild(ctx, build.ID)
+	retrieved, err := store.GetBuild(ctx, DefaultTenant, build.ID)
 	require.NoError(t, err)
 	require.Len(t, retrieved.Packages, 1)
 	assert.Equal(t, "patch content", retrieved.Packages[0].SourceFiles["patches/fix.patch"])
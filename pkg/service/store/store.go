@@ -17,24 +17,48 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/dlorenc/melange2/pkg/service/dag"
 	"github.com/dlorenc/melange2/pkg/service/types"
 )
 
-// BuildStore defines the interface for build storage.
+// BuildStore defines the interface for build storage. Builds belong to a
+// tenant. The operations that take a tenant only create, see and update
+// its builds, and fail with svcerrors.ErrNoTenant if it is empty. The
+// others serve the scheduler, and see the builds of every tenant.
 type BuildStore interface {
-	// CreateBuild creates a new multi-package build from DAG nodes.
-	CreateBuild(ctx context.Context, packages []dag.Node, spec types.BuildSpec) (*types.Build, error)
+	// CreateBuild creates a new multi-package build of tenant from DAG
+	// nodes.
+	CreateBuild(ctx context.Context, tenant string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (*types.Build, error)
 
-	// GetBuild retrieves a build by ID.
-	GetBuild(ctx context.Context, id string) (*types.Build, error)
+	// CreateBuildWithKey creates a build like CreateBuild, recording the
+	// client-supplied idempotency key. If a build of the same tenant was
+	// already created with the same key, that build is returned instead and
+	// created is false.
+	CreateBuildWithKey(ctx context.Context, tenant, key string, packages []dag.Node, spec types.BuildSpec, opts ...CreateBuildOption) (build *types.Build, created bool, err error)
 
-	// UpdateBuild updates an existing build.
+	// GetBuild retrieves a build of tenant by ID.
+	GetBuild(ctx context.Context, tenant, id string) (*types.Build, error)
+
+	// UpdateBuild updates an existing build. The tenant and metadata of the
+	// build are not changed; use UpdateBuildMetadata for the metadata.
 	UpdateBuild(ctx context.Context, build *types.Build) error
 
-	// ListBuilds returns all builds.
-	ListBuilds(ctx context.Context) ([]*types.Build, error)
+	// UpdateBuildMetadata merges patch into the metadata of a build of
+	// tenant. Keys with an empty value are removed.
+	UpdateBuildMetadata(ctx context.Context, tenant, id string, patch map[string]string) error
+
+	// ListBuilds returns all builds of tenant.
+	ListBuilds(ctx context.Context, tenant string) ([]*types.Build, error)
+
+	// ListBuildsByPackage returns the builds of tenant with a package named
+	// name, sorted by creation time.
+	ListBuildsByPackage(ctx context.Context, tenant, name string) ([]*types.Build, error)
+
+	// CountBuilds returns the number of builds of tenant matching filter in
+	// each status. Statuses no build is in are absent.
+	CountBuilds(ctx context.Context, tenant string, filter BuildFilter) (map[types.BuildStatus]int, error)
 
 	// ListActiveBuilds returns only non-terminal builds (pending/running).
 	// This is optimized for frequent polling by the scheduler.
@@ -49,6 +73,40 @@ type BuildStore interface {
 	UpdatePackageJob(ctx context.Context, buildID string, pkg *types.PackageJob) error
 }
 
+// CreateBuildOption configures a build created by CreateBuild or
+// CreateBuildWithKey.
+type CreateBuildOption func(*createOptions)
+
+// createOptions holds the options of a created build.
+type createOptions struct {
+	metadata map[string]string
+}
+
+// WithMetadata records metadata with the created build as given, empty
+// values included.
+func WithMetadata(metadata map[string]string) CreateBuildOption {
+	return func(o *createOptions) {
+		o.metadata = metadata
+	}
+}
+
+// createBuildOptions applies opts to the options of a created build.
+func createBuildOptions(opts []CreateBuildOption) createOptions {
+	var o createOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// BuildFilter selects builds. The zero BuildFilter selects every build.
+type BuildFilter struct {
+	// Package selects the builds with a package of this name.
+	Package string
+	// Since selects the builds created at or after this time.
+	Since time.Time
+}
+
 // IsTerminalStatus returns true if the build is in a terminal state.
 func IsTerminalStatus(status types.BuildStatus) bool {
 	switch status {
@@ -0,0 +1,32 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	svcerrors "github.com/dlorenc/melange2/pkg/service/errors"
+)
+
+// DefaultTenant is the tenant of the builds of a server that does not
+// authenticate tenants.
+const DefaultTenant = "default"
+
+// checkTenant returns svcerrors.ErrNoTenant if tenant is empty, so that an
+// operation scoped to a tenant never sees the builds of every tenant.
+func checkTenant(tenant string) error {
+	if tenant == "" {
+		return svcerrors.ErrNoTenant
+	}
+	return nil
+}
@@ -17,6 +17,8 @@ package tracing
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"os"
 	"time"
 
@@ -134,6 +136,36 @@ func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error)
 	return tp.Shutdown, nil
 }
 
+// ValidateOTLPEndpoint checks that endpoint is an http or https URL naming
+// an OTLP collector, e.g. "https://otel-collector:4317".
+func ValidateOTLPEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid OTLP endpoint %q: scheme must be http or https", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid OTLP endpoint %q: missing host", endpoint)
+	}
+	return nil
+}
+
+// NewOTLPExporter creates an exporter that sends spans over gRPC to the
+// collector at endpoint. An http endpoint uses an insecure connection.
+// The collector is not contacted until spans are exported.
+func NewOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	if err := ValidateOTLPEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	return exporter, nil
+}
+
 // StartSpan starts a new span with the given name.
 // Returns the context with the span and the span itself.
 func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
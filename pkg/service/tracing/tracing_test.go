@@ -0,0 +1,61 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"testing"
+
+	"github.com/chainguard-dev/clog/slogtest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+)
+
+func TestValidateOTLPEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4317"},
+		{endpoint: "https://otel-collector.example.com:4317"},
+		{endpoint: "", wantErr: true},
+		{endpoint: "localhost:4317", wantErr: true},
+		{endpoint: "grpc://localhost:4317", wantErr: true},
+		{endpoint: "http://", wantErr: true},
+		{endpoint: "http://local host", wantErr: true},
+	} {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			err := ValidateOTLPEndpoint(tt.endpoint)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewOTLPExporter(t *testing.T) {
+	ctx := slogtest.Context(t)
+
+	// Nothing listens on this port; constructing the exporter must not
+	// need a live collector.
+	exporter, err := NewOTLPExporter(ctx, "http://127.0.0.1:1")
+	require.NoError(t, err)
+	require.IsType(t, &otlptrace.Exporter{}, exporter)
+	require.NoError(t, exporter.Shutdown(ctx))
+
+	_, err = NewOTLPExporter(ctx, "127.0.0.1:1")
+	require.Error(t, err)
+}
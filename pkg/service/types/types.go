@@ -58,7 +58,18 @@ type CreateBuildRequest struct {
 
 	// Env specifies additional environment variables to inject into all pipeline steps.
 	// This is useful for passing credentials like GITHUB_TOKEN for private repo access.
+	// Keys must be valid environment variable names. The env is recorded
+	// with the build, in its spec.
 	Env map[string]string `json:"env,omitempty"`
+
+	// MaxParallelPerBackend limits how many packages of the build run on
+	// one backend at once, below the capacity of the backend. Zero means
+	// no limit beyond the capacity.
+	MaxParallelPerBackend int `json:"max_parallel_per_backend,omitempty"`
+
+	// Metadata holds arbitrary key/values to record with the build, such
+	// as the user, pull request or CI run that triggered it.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // CreateBuildResponse is the response body for creating a build.
@@ -67,6 +78,14 @@ type CreateBuildResponse struct {
 	Packages []string `json:"packages"` // Package names in build order
 }
 
+// BuildStatsResponse is the response body for build statistics.
+type BuildStatsResponse struct {
+	// Total is the number of builds counted.
+	Total int `json:"total"`
+	// Statuses is the number of builds in each status.
+	Statuses map[BuildStatus]int `json:"statuses"`
+}
+
 // BuildMode specifies how packages are scheduled for building.
 type BuildMode string
 
@@ -107,22 +126,37 @@ const (
 
 // PackageJob represents a single package within a build.
 type PackageJob struct {
-	Name         string            `json:"name"`
-	Status       PackageStatus     `json:"status"`
-	ConfigYAML   string            `json:"config_yaml"`
-	Dependencies []string          `json:"dependencies"`
-	StartedAt    *time.Time        `json:"started_at,omitempty"`
-	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
-	Error        string            `json:"error,omitempty"`
-	LogPath      string            `json:"log_path,omitempty"`
-	OutputPath   string            `json:"output_path,omitempty"`
-	Backend      *Backend          `json:"backend,omitempty"`
-	Pipelines    map[string]string `json:"pipelines,omitempty"`
+	Name         string        `json:"name"`
+	Status       PackageStatus `json:"status"`
+	ConfigYAML   string        `json:"config_yaml"`
+	Dependencies []string      `json:"dependencies"`
+	StartedAt    *time.Time    `json:"started_at,omitempty"`
+	FinishedAt   *time.Time    `json:"finished_at,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	LogPath      string        `json:"log_path,omitempty"`
+	// RotatedLogPaths are the earlier parts of the build log, most recent
+	// first, when it grew large enough to be rotated.
+	RotatedLogPaths []string          `json:"rotated_log_paths,omitempty"`
+	OutputPath      string            `json:"output_path,omitempty"`
+	Backend         *Backend          `json:"backend,omitempty"`
+	Pipelines       map[string]string `json:"pipelines,omitempty"`
 	// SourceFiles is a map of relative file paths to their content.
 	// These files will be written to the source directory before building.
 	SourceFiles map[string]string `json:"source_files,omitempty"`
 	// Metrics holds detailed timing information for the build phases.
 	Metrics *PackageBuildMetrics `json:"metrics,omitempty"`
+	// Warnings are the warnings found parsing and building the package.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Tenant is the tenant of the build the job belongs to.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Warning is a problem found parsing or building a package that did not
+// fail it.
+type Warning struct {
+	// Kind classifies the warning, such as "deprecated" or "git".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
 }
 
 // PackageBuildMetrics holds detailed timing information for package builds.
@@ -171,6 +205,20 @@ type Build struct {
 	CreatedAt  time.Time    `json:"created_at"`
 	StartedAt  *time.Time   `json:"started_at,omitempty"`
 	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+
+	// Tenant is the tenant the build belongs to. Clients only see the
+	// builds of their own tenant.
+	Tenant string `json:"tenant,omitempty"`
+
+	// IdempotencyKey is the client-supplied key the build was created
+	// with, if any. Creating a build again with the same key returns this
+	// build instead of a new one.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Metadata holds arbitrary key/values describing the context of the
+	// build, such as the user or CI run that triggered it. It does not
+	// affect the build.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // BuildSpec contains the specification for a multi-package build.
@@ -208,6 +256,11 @@ type BuildSpec struct {
 	// Env specifies additional environment variables to inject into all pipeline steps.
 	// This is useful for passing credentials like GITHUB_TOKEN for private repo access.
 	Env map[string]string `json:"env,omitempty"`
+
+	// MaxParallelPerBackend limits how many packages of the build run on
+	// one backend at once, below the capacity of the backend. Zero means
+	// no limit beyond the capacity.
+	MaxParallelPerBackend int `json:"max_parallel_per_backend,omitempty"`
 }
 
 // GitSource specifies a git repository source for package configs.
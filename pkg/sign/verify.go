@@ -0,0 +1,119 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+
+	"chainguard.dev/apko/pkg/apk/signature"
+)
+
+// indexSignatureRegex matches the signature entries of a signed APKINDEX.
+var indexSignatureRegex = regexp.MustCompile(`^\.SIGN\.(RSA|RSA256)\.(.+)$`)
+
+// VerifyIndex verifies the signature of an APKINDEX.tar.gz against keys,
+// a map of key file name (e.g. "wolfi-signing.rsa.pub") to PEM-encoded
+// public key. The returned error names the keys the index was signed with
+// and the keys that were expected, so that keyring mismatches can be
+// diagnosed without digging through apko's resolution errors.
+func VerifyIndex(indexData []byte, keys map[string][]byte) error {
+	expected := slices.Sorted(maps.Keys(keys))
+	if len(expected) == 0 {
+		return errors.New("no keys provided to verify signature")
+	}
+
+	buf := bytes.NewReader(indexData)
+	gzr, err := gzip.NewReader(buf)
+	if err != nil {
+		return fmt.Errorf("reading index: %w", err)
+	}
+	// The signature is stored in its own gzip stream ahead of the signed data.
+	gzr.Multistream(false)
+	defer gzr.Close()
+
+	type sig struct {
+		key    string
+		digest crypto.Hash
+		data   []byte
+	}
+	var found []string
+	var sigs []sig
+
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading index signature: %w", err)
+		}
+		m := indexSignatureRegex.FindStringSubmatch(hdr.Name)
+		if m == nil {
+			continue
+		}
+		found = append(found, m[2])
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("reading index signature: %w", err)
+		}
+		digest := crypto.SHA1
+		if m[1] == "RSA256" {
+			digest = crypto.SHA256
+		}
+		// Keys fetched through some proxies lose their .rsa.pub suffix.
+		key := m[2]
+		if _, ok := keys[key]; !ok {
+			key = strings.TrimSuffix(key, ".rsa.pub")
+		}
+		if _, ok := keys[key]; ok {
+			sigs = append(sigs, sig{key: key, digest: digest, data: data})
+		}
+	}
+
+	if len(found) == 0 {
+		return fmt.Errorf("index is not signed; expected a signature by one of %v", expected)
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("index is signed by %v, but the keyring only contains %v", found, expected)
+	}
+
+	signed := indexData[len(indexData)-buf.Len():]
+	var errs []error
+	for _, s := range sigs {
+		digest, err := HashData(signed, s.digest)
+		if err != nil {
+			return err
+		}
+		if err := signature.RSAVerifyDigest(digest, s.digest, s.data, keys[s.key]); err != nil {
+			errs = append(errs, fmt.Errorf("signature by %s does not verify: %w", s.key, err))
+			continue
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
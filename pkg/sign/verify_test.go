@@ -0,0 +1,150 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestIndex writes a minimal unsigned APKINDEX.tar.gz and returns its path.
+func writeTestIndex(t *testing.T) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	content := []byte("C:Q1abc=\nP:hello\nV:1.0-r0\n\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "APKINDEX", Mode: 0o644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "APKINDEX.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func generatePublicKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestVerifyIndex(t *testing.T) {
+	ctx := context.Background()
+
+	pubKey, err := os.ReadFile("testdata/" + testPubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned, err := os.ReadFile(writeTestIndex(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedPath := writeTestIndex(t)
+	if err := SignIndex(ctx, "testdata/"+testPrivKey, signedPath); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := os.ReadFile(signedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(signed)
+	tampered[len(tampered)-1] ^= 0xff
+
+	for _, tc := range []struct {
+		name    string
+		index   []byte
+		keys    map[string][]byte
+		wantErr string
+	}{{
+		name:  "valid signature",
+		index: signed,
+		keys:  map[string][]byte{testPubkey: pubKey},
+	}, {
+		name:  "key name without suffix",
+		index: signed,
+		keys:  map[string][]byte{strings.TrimSuffix(testPubkey, ".pub"): pubKey, testPubkey: pubKey},
+	}, {
+		name:    "wrong key contents",
+		index:   signed,
+		keys:    map[string][]byte{testPubkey: generatePublicKey(t)},
+		wantErr: "signature by test.pem.pub does not verify",
+	}, {
+		name:    "tampered index",
+		index:   tampered,
+		keys:    map[string][]byte{testPubkey: pubKey},
+		wantErr: "does not verify",
+	}, {
+		name:    "key not in keyring",
+		index:   signed,
+		keys:    map[string][]byte{"other.rsa.pub": pubKey},
+		wantErr: "index is signed by [test.pem.pub], but the keyring only contains [other.rsa.pub]",
+	}, {
+		name:    "unsigned index",
+		index:   unsigned,
+		keys:    map[string][]byte{testPubkey: pubKey},
+		wantErr: "index is not signed; expected a signature by one of [test.pem.pub]",
+	}, {
+		name:    "empty keyring",
+		index:   signed,
+		wantErr: "no keys provided",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyIndex(tc.index, tc.keys)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendMissing(t *testing.T) {
+	require.Equal(t, []string{"a", "b", "c"}, AppendMissing([]string{"a", "b"}, []string{"b", "c", "a"}))
+	require.Equal(t, []string{"a"}, AppendMissing(nil, []string{"a", "a"}))
+	require.Nil(t, AppendMissing(nil, nil))
+}